@@ -0,0 +1,199 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipamclient
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// DefaultPollInterval is the default interval between two reads of an
+// IPClaim while waiting for it
+const DefaultPollInterval = 2 * time.Second
+
+// Client wraps a controller-runtime client with the IPAM helpers. The
+// scheme of the wrapped client must contain the ipam.metal3.io types.
+type Client struct {
+	client.Client
+
+	// PollInterval is the interval between two reads of an IPClaim while
+	// waiting for it
+	PollInterval time.Duration
+}
+
+// Capacity is the number of addresses of an IPPool, or of prefixes if the
+// IPPool delegates prefixes
+type Capacity struct {
+	// Total is the number of addresses of the enabled pools of the IPPool
+	Total uint64
+	// Allocated is the number of allocated addresses
+	Allocated uint64
+	// Free is the number of addresses that can still be allocated
+	Free uint64
+}
+
+// New returns a Client wrapping the given client
+func New(cl client.Client) *Client {
+	return &Client{
+		Client:       cl,
+		PollInterval: DefaultPollInterval,
+	}
+}
+
+// CreateClaim creates the IPClaim and waits until it is bound, then returns
+// its IPAddress. An existing IPClaim of the same name is reused, so that the
+// call can be repeated after a failure. The wait is bounded by the context.
+func (c *Client) CreateClaim(ctx context.Context, claim *ipamv1.IPClaim) (*ipamv1.IPAddress, error) {
+	if err := c.Create(ctx, claim); err != nil && !apierrors.IsAlreadyExists(err) {
+		return nil, errors.Wrap(err, "failed to create the IPClaim")
+	}
+	return c.WaitForBinding(ctx, client.ObjectKeyFromObject(claim))
+}
+
+// WaitForBinding waits until the IPClaim is bound and returns its IPAddress.
+// It fails as soon as the binding deadline of the claim is exceeded, or with
+// the last error of the claim when the context is done.
+func (c *Client) WaitForBinding(ctx context.Context, key client.ObjectKey) (*ipamv1.IPAddress, error) {
+	claim := &ipamv1.IPClaim{}
+	err := c.poll(ctx, func() (bool, error) {
+		if err := c.Get(ctx, key, claim); err != nil {
+			return false, errors.Wrap(err, "failed to get the IPClaim")
+		}
+		if failed := meta.FindStatusCondition(claim.Status.Conditions,
+			ipamv1.IPClaimBindingFailedCondition,
+		); failed != nil && failed.Status == metav1.ConditionTrue {
+			return false, errors.New(fmt.Sprintf("IPClaim %s binding failed: %s", key, failed.Message))
+		}
+		return claim.Status.Address != nil, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return nil, errors.New(fmt.Sprintf("IPClaim %s not bound: %s", key, claimError(claim)))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	address := &ipamv1.IPAddress{}
+	addressKey := client.ObjectKey{
+		Name:      claim.Status.Address.Name,
+		Namespace: claim.Status.Address.Namespace,
+	}
+	if addressKey.Namespace == "" {
+		addressKey.Namespace = claim.Namespace
+	}
+	if err := c.Get(ctx, addressKey, address); err != nil {
+		return nil, errors.Wrap(err, "failed to get the IPAddress")
+	}
+	return address, nil
+}
+
+// ReleaseClaim deletes the IPClaim, retrying on conflicts and server errors,
+// and waits until it is gone, its addresses being released. Releasing a
+// missing IPClaim succeeds. The wait is bounded by the context.
+func (c *Client) ReleaseClaim(ctx context.Context, key client.ObjectKey) error {
+	claim := &ipamv1.IPClaim{}
+	err := retry.OnError(retry.DefaultBackoff, isRetriable, func() error {
+		if err := c.Get(ctx, key, claim); err != nil {
+			return err
+		}
+		return c.Delete(ctx, claim, client.Preconditions{UID: &claim.UID})
+	})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to delete the IPClaim")
+	}
+
+	err = c.poll(ctx, func() (bool, error) {
+		err := c.Get(ctx, key, claim)
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, errors.Wrap(err, "failed to get the IPClaim")
+	})
+	if err == wait.ErrWaitTimeout {
+		return errors.New(fmt.Sprintf("IPClaim %s not released: %s", key, claimError(claim)))
+	}
+	return err
+}
+
+// PoolCapacity returns the capacity of the IPPool. The disabled pools are
+// not counted, but the addresses allocated from them are.
+func (c *Client) PoolCapacity(ctx context.Context, key client.ObjectKey) (*Capacity, error) {
+	ipPool := &ipamv1.IPPool{}
+	if err := c.Get(ctx, key, ipPool); err != nil {
+		return nil, errors.Wrap(err, "failed to get the IPPool")
+	}
+
+	total, err := ipPool.Spec.Capacity()
+	if err != nil {
+		return nil, err
+	}
+	capacity := &Capacity{
+		Total:     total,
+		Allocated: uint64(len(ipPool.Status.AllocatedAddresses())),
+	}
+	if capacity.Total > capacity.Allocated {
+		capacity.Free = capacity.Total - capacity.Allocated
+	}
+	return capacity, nil
+}
+
+// poll runs the condition at each poll interval until it is true, it fails or
+// the context is done
+func (c *Client) poll(ctx context.Context, condition wait.ConditionFunc) error {
+	interval := c.PollInterval
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	return wait.PollImmediateUntil(interval, condition, ctx.Done())
+}
+
+// isRetriable returns true for the errors that may not happen again
+func isRetriable(err error) bool {
+	return apierrors.IsConflict(err) || apierrors.IsServerTimeout(err) ||
+		apierrors.IsTimeout(err) || apierrors.IsTooManyRequests(err) ||
+		apierrors.IsInternalError(err) || apierrors.IsServiceUnavailable(err)
+}
+
+// claimError describes why the claim is still pending
+func claimError(claim *ipamv1.IPClaim) string {
+	for _, conditionType := range []string{
+		ipamv1.IPClaimDeregistrationFailedCondition,
+		ipamv1.IPClaimBindingHeldCondition,
+	} {
+		condition := meta.FindStatusCondition(claim.Status.Conditions, conditionType)
+		if condition != nil && condition.Status == metav1.ConditionTrue {
+			return condition.Message
+		}
+	}
+	if claim.Status.ErrorMessage != nil {
+		return *claim.Status.ErrorMessage
+	}
+	return "timed out"
+}
@@ -0,0 +1,360 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipamclient
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newTestClient(g *WithT, objects ...client.Object) *Client {
+	s := runtime.NewScheme()
+	g.Expect(ipamv1.AddToScheme(s)).To(Succeed())
+	c := New(fake.NewClientBuilder().WithScheme(s).WithObjects(objects...).Build())
+	c.PollInterval = 10 * time.Millisecond
+	return c
+}
+
+func newTestClaim() *ipamv1.IPClaim {
+	return &ipamv1.IPClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "claim",
+			Namespace: "myns",
+		},
+		Spec: ipamv1.IPClaimSpec{
+			Pool: corev1.ObjectReference{Name: "pool"},
+		},
+	}
+}
+
+func TestCreateClaim(t *testing.T) {
+	address := &ipamv1.IPAddress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "pool-192-168-0-10",
+			Namespace: "myns",
+		},
+		Spec: ipamv1.IPAddressSpec{
+			Address: "192.168.0.10",
+			Prefix:  24,
+		},
+	}
+
+	tests := []struct {
+		name            string
+		claimStatus     *ipamv1.IPClaimStatus
+		expectedAddress ipamv1.IPAddressStr
+		expectedError   string
+	}{
+		{
+			name:          "should create the claim and time out",
+			expectedError: "IPClaim myns/claim not bound: timed out",
+		},
+		{
+			name: "should return the address of a bound claim",
+			claimStatus: &ipamv1.IPClaimStatus{
+				Address: &corev1.ObjectReference{Name: "pool-192-168-0-10"},
+			},
+			expectedAddress: "192.168.0.10",
+		},
+		{
+			name: "should time out with the error of the claim",
+			claimStatus: &ipamv1.IPClaimStatus{
+				ErrorMessage: pointer.StringPtr("Exhausted IP Pools"),
+			},
+			expectedError: "IPClaim myns/claim not bound: Exhausted IP Pools",
+		},
+		{
+			name: "should time out with the holds of the claim",
+			claimStatus: &ipamv1.IPClaimStatus{
+				Conditions: []metav1.Condition{
+					{
+						Type:    ipamv1.IPClaimBindingHeldCondition,
+						Status:  metav1.ConditionTrue,
+						Message: "Proposed 192.168.0.11, held by network",
+					},
+				},
+			},
+			expectedError: "IPClaim myns/claim not bound: Proposed 192.168.0.11, held by network",
+		},
+		{
+			name: "should fail when the binding failed",
+			claimStatus: &ipamv1.IPClaimStatus{
+				Conditions: []metav1.Condition{
+					{
+						Type:    ipamv1.IPClaimBindingFailedCondition,
+						Status:  metav1.ConditionTrue,
+						Message: "No address after 10m0s",
+					},
+				},
+			},
+			expectedError: "IPClaim myns/claim binding failed: No address after 10m0s",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			objects := []client.Object{address.DeepCopy()}
+			if tt.claimStatus != nil {
+				claim := newTestClaim()
+				claim.Status = *tt.claimStatus
+				objects = append(objects, claim)
+			}
+			c := newTestClient(g, objects...)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			ipAddress, err := c.CreateClaim(ctx, newTestClaim())
+			if tt.expectedError != "" {
+				g.Expect(err).To(MatchError(tt.expectedError))
+			} else {
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(ipAddress.Spec.Address).To(Equal(tt.expectedAddress))
+			}
+
+			g.Expect(c.Get(context.TODO(), client.ObjectKey{Name: "claim", Namespace: "myns"},
+				&ipamv1.IPClaim{},
+			)).To(Succeed())
+		})
+	}
+}
+
+func TestWaitForBinding(t *testing.T) {
+	g := NewWithT(t)
+
+	claim := newTestClaim()
+	c := newTestClient(g, claim, &ipamv1.IPAddress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "pool-192-168-0-10",
+			Namespace: "myns",
+		},
+		Spec: ipamv1.IPAddressSpec{
+			Address: "192.168.0.10",
+		},
+	})
+
+	updated := make(chan error, 1)
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		claim.Status.Address = &corev1.ObjectReference{
+			Name:      "pool-192-168-0-10",
+			Namespace: "myns",
+		}
+		updated <- c.Status().Update(context.TODO(), claim)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ipAddress, err := c.WaitForBinding(ctx, client.ObjectKeyFromObject(claim))
+	g.Expect(<-updated).To(Succeed())
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(ipAddress.Spec.Address).To(Equal(ipamv1.IPAddressStr("192.168.0.10")))
+}
+
+func TestReleaseClaim(t *testing.T) {
+
+	tests := []struct {
+		name          string
+		claim         *ipamv1.IPClaim
+		expectedError string
+	}{
+		{
+			name: "should succeed when the claim does not exist",
+		},
+		{
+			name:  "should delete the claim",
+			claim: newTestClaim(),
+		},
+		{
+			name: "should time out with the deregistration error",
+			claim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:       "claim",
+					Namespace:  "myns",
+					Finalizers: []string{ipamv1.IPClaimFinalizer},
+				},
+				Status: ipamv1.IPClaimStatus{
+					Conditions: []metav1.Condition{
+						{
+							Type:    ipamv1.IPClaimDeregistrationFailedCondition,
+							Status:  metav1.ConditionTrue,
+							Message: "Deregistration failed: 500 Internal Server Error",
+						},
+					},
+				},
+			},
+			expectedError: "IPClaim myns/claim not released: Deregistration failed: 500 Internal Server Error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			objects := []client.Object{}
+			if tt.claim != nil {
+				objects = append(objects, tt.claim)
+			}
+			c := newTestClient(g, objects...)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			key := client.ObjectKey{Name: "claim", Namespace: "myns"}
+			err := c.ReleaseClaim(ctx, key)
+			if tt.expectedError != "" {
+				g.Expect(err).To(MatchError(tt.expectedError))
+				return
+			}
+			g.Expect(err).NotTo(HaveOccurred())
+			err = c.Get(context.TODO(), key, &ipamv1.IPClaim{})
+			g.Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		})
+	}
+}
+
+func TestPoolCapacity(t *testing.T) {
+
+	tests := []struct {
+		name             string
+		spec             ipamv1.IPPoolSpec
+		status           ipamv1.IPPoolStatus
+		expectedCapacity *Capacity
+		expectErr        bool
+	}{
+		{
+			name: "should count the addresses of the pools",
+			spec: ipamv1.IPPoolSpec{
+				Pools: []ipamv1.Pool{
+					{
+						Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+						End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.19")),
+					},
+					{
+						Subnet: (*ipamv1.IPSubnetStr)(pointer.StringPtr("192.168.1.0/30")),
+					},
+				},
+			},
+			status: ipamv1.IPPoolStatus{
+				Allocations: map[string]ipamv1.IPAddressStr{
+					"claim1": "192.168.0.10",
+				},
+				AllocatedRanges: []string{"192.168.1.1-192.168.1.2"},
+			},
+			expectedCapacity: &Capacity{Total: 12, Allocated: 3, Free: 9},
+		},
+		{
+			name: "should skip the disabled pools",
+			spec: ipamv1.IPPoolSpec{
+				Pools: []ipamv1.Pool{
+					{
+						Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+						End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.11")),
+					},
+					{
+						Start:    (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.10")),
+						End:      (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.19")),
+						Disabled: true,
+					},
+				},
+			},
+			status: ipamv1.IPPoolStatus{
+				Allocations: map[string]ipamv1.IPAddressStr{
+					"claim1": "192.168.1.10",
+					"claim2": "192.168.1.11",
+					"claim3": "192.168.1.12",
+				},
+			},
+			expectedCapacity: &Capacity{Total: 2, Allocated: 3, Free: 0},
+		},
+		{
+			name: "should count the delegated prefixes",
+			spec: ipamv1.IPPoolSpec{
+				Pools: []ipamv1.Pool{
+					{
+						Subnet: (*ipamv1.IPSubnetStr)(pointer.StringPtr("2001:db8::/56")),
+					},
+				},
+				DelegatedPrefix: 64,
+			},
+			status: ipamv1.IPPoolStatus{
+				Allocations: map[string]ipamv1.IPAddressStr{
+					"claim1": "2001:db8::",
+				},
+			},
+			expectedCapacity: &Capacity{Total: 256, Allocated: 1, Free: 255},
+		},
+		{
+			name: "should saturate the large IPv6 pools",
+			spec: ipamv1.IPPoolSpec{
+				Pools: []ipamv1.Pool{
+					{
+						Subnet: (*ipamv1.IPSubnetStr)(pointer.StringPtr("2001::/48")),
+					},
+					{
+						Subnet: (*ipamv1.IPSubnetStr)(pointer.StringPtr("2001:1::/120")),
+					},
+				},
+			},
+			expectedCapacity: &Capacity{Total: math.MaxUint64, Free: math.MaxUint64},
+		},
+		{
+			name: "should fail with an invalid pool",
+			spec: ipamv1.IPPoolSpec{
+				Pools: []ipamv1.Pool{{}},
+			},
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			c := newTestClient(g, &ipamv1.IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "pool",
+					Namespace: "myns",
+				},
+				Spec:   tt.spec,
+				Status: tt.status,
+			})
+
+			capacity, err := c.PoolCapacity(context.TODO(),
+				client.ObjectKey{Name: "pool", Namespace: "myns"},
+			)
+			if tt.expectErr {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(capacity).To(Equal(tt.expectedCapacity))
+		})
+	}
+}
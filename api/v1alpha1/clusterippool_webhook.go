@@ -0,0 +1,100 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+func (c *ClusterIPPool) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(c).
+		Complete()
+}
+
+// +kubebuilder:webhook:verbs=create;update;delete,path=/validate-ipam-metal3-io-v1alpha4-clusterippool,mutating=false,failurePolicy=fail,groups=ipam.metal3.io,resources=clusterippools,versions=v1alpha4,name=validation.clusterippool.ipam.metal3.io,matchPolicy=Equivalent,sideEffects=None,admissionReviewVersions=v1;v1beta1
+// +kubebuilder:webhook:verbs=create;update,path=/mutate-ipam-metal3-io-v1alpha4-clusterippool,mutating=true,failurePolicy=fail,groups=ipam.metal3.io,resources=clusterippools,versions=v1alpha4,name=default.clusterippool.ipam.metal3.io,matchPolicy=Equivalent,sideEffects=None,admissionReviewVersions=v1;v1beta1
+
+var _ webhook.Defaulter = &ClusterIPPool{}
+var _ webhook.Validator = &ClusterIPPool{}
+
+// Default defaults the name prefix and normalizes the addresses. It does not
+// apply any IPAMConfig, those are namespaced
+func (c *ClusterIPPool) Default() {
+	c.Spec.applyDefaults(c.Name)
+}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (c *ClusterIPPool) ValidateCreate() error {
+	allErrs := c.validateClusterScope()
+	allErrs = append(allErrs, c.AsIPPool().validateSpec()...)
+	return c.invalid(allErrs)
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (c *ClusterIPPool) ValidateUpdate(old runtime.Object) error {
+	oldClusterIPPool, ok := old.(*ClusterIPPool)
+	if !ok || oldClusterIPPool == nil {
+		return apierrors.NewInternalError(errors.New("unable to convert existing object"))
+	}
+	allErrs := c.validateClusterScope()
+	allErrs = append(allErrs, c.AsIPPool().validateSpecUpdate(oldClusterIPPool.AsIPPool())...)
+	return c.invalid(allErrs)
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (c *ClusterIPPool) ValidateDelete() error {
+	if err := c.AsIPPool().validateDeletionPolicy(); err != nil {
+		return apierrors.NewForbidden(GroupVersion.WithResource("clusterippools").GroupResource(), c.Name, err)
+	}
+	return nil
+}
+
+// validateClusterScope rejects the settings of the IPPools that only apply in
+// a namespace: the cluster the pool belongs to and the renaming of the pool
+func (c *ClusterIPPool) validateClusterScope() field.ErrorList {
+	allErrs := field.ErrorList{}
+	if c.Spec.ClusterName != nil {
+		allErrs = append(allErrs,
+			field.Forbidden(
+				field.NewPath("spec", "clusterName"),
+				"is not supported in a ClusterIPPool",
+			),
+		)
+	}
+	if _, ok := c.Annotations[RenamedFromAnnotation]; ok {
+		allErrs = append(allErrs,
+			field.Forbidden(
+				field.NewPath("metadata", "annotations").Key(RenamedFromAnnotation),
+				"is not supported in a ClusterIPPool",
+			),
+		)
+	}
+	return allErrs
+}
+
+func (c *ClusterIPPool) invalid(allErrs field.ErrorList) error {
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("ClusterIPPool").GroupKind(), c.Name, allErrs)
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+)
+
+func TestClusterIPPoolDefault(t *testing.T) {
+	g := NewWithT(t)
+
+	c := &ClusterIPPool{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "abc",
+		},
+	}
+	c.Default()
+
+	g.Expect(c.Spec).To(Equal(IPPoolSpec{NamePrefix: "abc"}))
+	g.Expect(c.Status).To(Equal(IPPoolStatus{}))
+}
+
+func TestClusterIPPoolCreateValidation(t *testing.T) {
+	tests := []struct {
+		name        string
+		expectErr   bool
+		annotations map[string]string
+		spec        IPPoolSpec
+	}{
+		{
+			name:      "should succeed when the spec is valid",
+			expectErr: false,
+			spec: IPPoolSpec{
+				Pools: []Pool{
+					{
+						Start: (*IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+						End:   (*IPAddressStr)(pointer.StringPtr("192.168.0.20")),
+					},
+				},
+				AllowedNamespaces: []string{"foo"},
+			},
+		},
+		{
+			name:      "should fail when the cluster name is set",
+			expectErr: true,
+			spec: IPPoolSpec{
+				ClusterName: pointer.StringPtr("abc"),
+			},
+		},
+		{
+			name:      "should fail when the pool is renamed",
+			expectErr: true,
+			annotations: map[string]string{
+				RenamedFromAnnotation: "bcd",
+			},
+		},
+		{
+			name:      "should fail when a pool is invalid",
+			expectErr: true,
+			spec: IPPoolSpec{
+				Pools: []Pool{
+					{
+						End: (*IPAddressStr)(pointer.StringPtr("192.168.0.20")),
+					},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			obj := &ClusterIPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "abc",
+					Annotations: tt.annotations,
+				},
+				Spec: tt.spec,
+			}
+
+			if tt.expectErr {
+				g.Expect(obj.ValidateCreate()).NotTo(Succeed())
+			} else {
+				g.Expect(obj.ValidateCreate()).To(Succeed())
+			}
+
+			g.Expect(obj.ValidateDelete()).To(Succeed())
+		})
+	}
+}
+
+func TestClusterIPPoolUpdateValidation(t *testing.T) {
+	tests := []struct {
+		name      string
+		expectErr bool
+		newSpec   IPPoolSpec
+		oldSpec   IPPoolSpec
+	}{
+		{
+			name:      "should succeed when the namePrefix is unchanged",
+			expectErr: false,
+			newSpec: IPPoolSpec{
+				NamePrefix:        "abcd",
+				AllowedNamespaces: []string{"foo"},
+			},
+			oldSpec: IPPoolSpec{
+				NamePrefix: "abcd",
+			},
+		},
+		{
+			name:      "should fail when the namePrefix is modified",
+			expectErr: true,
+			newSpec: IPPoolSpec{
+				NamePrefix: "abcde",
+			},
+			oldSpec: IPPoolSpec{
+				NamePrefix: "abcd",
+			},
+		},
+		{
+			name:      "should fail when the cluster name is set",
+			expectErr: true,
+			newSpec: IPPoolSpec{
+				ClusterName: pointer.StringPtr("abc"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			newPool := &ClusterIPPool{
+				ObjectMeta: metav1.ObjectMeta{Name: "abc"},
+				Spec:       tt.newSpec,
+			}
+			oldPool := &ClusterIPPool{
+				ObjectMeta: metav1.ObjectMeta{Name: "abc"},
+				Spec:       tt.oldSpec,
+			}
+
+			if tt.expectErr {
+				g.Expect(newPool.ValidateUpdate(oldPool)).NotTo(Succeed())
+			} else {
+				g.Expect(newPool.ValidateUpdate(oldPool)).To(Succeed())
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// ControllerConfigName is the name of the ControllerConfig used by the
+	// controllers.
+	ControllerConfigName = "default"
+
+	// ControllerConfigAppliedCondition reports that the settings of the
+	// ControllerConfig are applied by the running controllers.
+	ControllerConfigAppliedCondition = "Applied"
+)
+
+// ControllerConfigSpec defines the operator-wide settings of the controllers.
+// The settings that are not given default to the values of the flags of the
+// controllers.
+type ControllerConfigSpec struct {
+
+	// RequeueInterval is the interval after which the objects whose
+	// reconciliation is paused or blocked are reconciled again.
+	// +optional
+	RequeueInterval *metav1.Duration `json:"requeueInterval,omitempty"`
+
+	// StaleClaimThreshold is the duration after which an IPClaim without an
+	// address is reported as stale. Zero disables the reporting.
+	// +optional
+	StaleClaimThreshold *metav1.Duration `json:"staleClaimThreshold,omitempty"`
+
+	// +kubebuilder:validation:Minimum=0
+	// LogVerbosity is the verbosity of the logs of the controllers.
+	// +optional
+	LogVerbosity *int32 `json:"logVerbosity,omitempty"`
+}
+
+// ControllerConfigStatus defines the observed state of ControllerConfig.
+type ControllerConfigStatus struct {
+	// ObservedGeneration is the generation of the ControllerConfig applied
+	// by the controllers.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions reports whether the settings are applied.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:resource:path=controllerconfigs,scope=Cluster,categories=cluster-api,shortName=ipamcc;m3ipamcc;metal3ipamcc
+// +kubebuilder:storageversion
+// +kubebuilder:subresource:status
+// +kubebuilder:object:root=true
+// +kubebuilder:printcolumn:name="Requeue Interval",type="string",JSONPath=".spec.requeueInterval",description="Interval of the requeues"
+// +kubebuilder:printcolumn:name="Stale Claim Threshold",type="string",JSONPath=".spec.staleClaimThreshold",description="Duration after which a claim without address is stale"
+// +kubebuilder:printcolumn:name="Applied Generation",type="integer",JSONPath=".status.observedGeneration",description="Generation applied by the controllers"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp",description="Time duration since creation of ControllerConfig"
+// ControllerConfig is the Schema for the controllerconfigs API
+type ControllerConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ControllerConfigSpec   `json:"spec,omitempty"`
+	Status ControllerConfigStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ControllerConfigList contains a list of ControllerConfig
+type ControllerConfigList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ControllerConfig `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ControllerConfig{}, &ControllerConfigList{})
+}
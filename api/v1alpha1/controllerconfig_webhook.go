@@ -0,0 +1,115 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+func (c *ControllerConfig) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(c).
+		Complete()
+}
+
+// +kubebuilder:webhook:verbs=create;update,path=/validate-ipam-metal3-io-v1alpha4-controllerconfig,mutating=false,failurePolicy=fail,groups=ipam.metal3.io,resources=controllerconfigs,versions=v1alpha4,name=validation.controllerconfig.ipam.metal3.io,matchPolicy=Equivalent,sideEffects=None,admissionReviewVersions=v1;v1beta1
+// +kubebuilder:webhook:verbs=create;update,path=/mutate-ipam-metal3-io-v1alpha4-controllerconfig,mutating=true,failurePolicy=fail,groups=ipam.metal3.io,resources=controllerconfigs,versions=v1alpha4,name=default.controllerconfig.ipam.metal3.io,matchPolicy=Equivalent,sideEffects=None,admissionReviewVersions=v1;v1beta1
+
+var _ webhook.Defaulter = &ControllerConfig{}
+var _ webhook.Validator = &ControllerConfig{}
+
+func (c *ControllerConfig) Default() {
+}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (c *ControllerConfig) ValidateCreate() error {
+	return c.validate()
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (c *ControllerConfig) ValidateUpdate(old runtime.Object) error {
+	oldControllerConfig, ok := old.(*ControllerConfig)
+	if !ok || oldControllerConfig == nil {
+		return apierrors.NewInternalError(errors.New("unable to convert existing object"))
+	}
+	return c.validate()
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (c *ControllerConfig) ValidateDelete() error {
+	return nil
+}
+
+func (c *ControllerConfig) validate() error {
+	allErrs := field.ErrorList{}
+
+	// Only one ControllerConfig is used by the controllers
+	if c.Name != ControllerConfigName {
+		allErrs = append(allErrs,
+			field.Invalid(
+				field.NewPath("metadata", "name"),
+				c.Name,
+				"must be "+ControllerConfigName,
+			),
+		)
+	}
+
+	if c.Spec.RequeueInterval != nil && c.Spec.RequeueInterval.Duration <= 0 {
+		allErrs = append(allErrs,
+			field.Invalid(
+				field.NewPath("spec", "requeueInterval"),
+				c.Spec.RequeueInterval.Duration.String(),
+				"must be positive",
+			),
+		)
+	}
+	allErrs = append(allErrs, validateNonNegativeDuration(
+		field.NewPath("spec", "staleClaimThreshold"), c.Spec.StaleClaimThreshold,
+	)...)
+
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("ControllerConfig").GroupKind(), c.Name, allErrs)
+}
+
+// validateNonNegativeDuration verifies that the duration, if given, is not
+// negative
+func validateNonNegativeDuration(path *field.Path, duration *metav1.Duration) field.ErrorList {
+	if duration == nil || duration.Duration >= 0 {
+		return nil
+	}
+	return field.ErrorList{
+		field.Invalid(path, duration.Duration.String(), "cannot be negative"),
+	}
+}
+
+// validatePositiveDuration verifies that the duration, if given, is positive
+func validatePositiveDuration(path *field.Path, duration *metav1.Duration) field.ErrorList {
+	if duration == nil || duration.Duration > 0 {
+		return nil
+	}
+	return field.ErrorList{
+		field.Invalid(path, duration.Duration.String(), "must be positive"),
+	}
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestControllerConfigDefault(t *testing.T) {
+	g := NewWithT(t)
+
+	c := &ControllerConfig{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: ControllerConfigName,
+		},
+	}
+	c.Default()
+
+	g.Expect(c.Spec).To(Equal(ControllerConfigSpec{}))
+}
+
+func TestControllerConfigValidation(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		configName string
+		expectErr  bool
+		spec       ControllerConfigSpec
+	}{
+		{
+			name:       "should succeed when values are correct",
+			expectErr:  false,
+			configName: ControllerConfigName,
+			spec: ControllerConfigSpec{
+				RequeueInterval:     &metav1.Duration{Duration: time.Minute},
+				StaleClaimThreshold: &metav1.Duration{},
+			},
+		},
+		{
+			name:       "should fail with another name",
+			expectErr:  true,
+			configName: "abc",
+		},
+		{
+			name:       "should fail with a zero requeue interval",
+			expectErr:  true,
+			configName: ControllerConfigName,
+			spec: ControllerConfigSpec{
+				RequeueInterval: &metav1.Duration{},
+			},
+		},
+		{
+			name:       "should fail with a negative stale claim threshold",
+			expectErr:  true,
+			configName: ControllerConfigName,
+			spec: ControllerConfigSpec{
+				StaleClaimThreshold: &metav1.Duration{Duration: -time.Minute},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			obj := &ControllerConfig{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: tt.configName,
+				},
+				Spec: tt.spec,
+			}
+
+			if tt.expectErr {
+				g.Expect(obj.ValidateCreate()).NotTo(Succeed())
+				g.Expect(obj.ValidateUpdate(obj.DeepCopy())).NotTo(Succeed())
+			} else {
+				g.Expect(obj.ValidateCreate()).To(Succeed())
+				g.Expect(obj.ValidateUpdate(obj.DeepCopy())).To(Succeed())
+			}
+
+			g.Expect(obj.ValidateUpdate(nil)).NotTo(Succeed())
+			g.Expect(obj.ValidateDelete()).To(Succeed())
+		})
+	}
+}
@@ -16,6 +16,13 @@ limitations under the License.
 
 package v1alpha1
 
-func (*IPPool) Hub()    {}
-func (*IPAddress) Hub() {}
-func (*IPClaim) Hub()   {}
+func (*IPPool) Hub()                {}
+func (*IPAddress) Hub()             {}
+func (*IPClaim) Hub()               {}
+func (*IPClaimSet) Hub()            {}
+func (*IPAMConfig) Hub()            {}
+func (*ControllerConfig) Hub()      {}
+func (*IPOverlapReport) Hub()       {}
+func (*ClusterIPPool) Hub()         {}
+func (*IPPoolClaim) Hub()           {}
+func (*IPPoolAllocationShard) Hub() {}
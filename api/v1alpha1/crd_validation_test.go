@@ -0,0 +1,201 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The validation rules of the CRDs are checked by the API server of envtest,
+// without the webhooks.
+func TestIPPoolValidationRules(t *testing.T) {
+	addr := func(s string) *IPAddressStr {
+		a := IPAddressStr(s)
+		return &a
+	}
+	subnet := func(s string) *IPSubnetStr {
+		n := IPSubnetStr(s)
+		return &n
+	}
+
+	tests := []struct {
+		name          string
+		spec          IPPoolSpec
+		expectedError string
+	}{
+		{
+			name: "should succeed with a valid IPv4 range",
+			spec: IPPoolSpec{
+				Pools: []Pool{{
+					Start:  addr("192.168.0.1"),
+					End:    addr("192.168.0.10"),
+					Subnet: subnet("192.168.0.0/24"),
+				}},
+			},
+		},
+		{
+			name: "should succeed with a valid IPv6 range",
+			spec: IPPoolSpec{
+				Pools: []Pool{{
+					Start: addr("2001:db8::1"),
+					End:   addr("2001:db8::10"),
+				}},
+			},
+		},
+		{
+			name: "should succeed with a single address range",
+			spec: IPPoolSpec{
+				Pools: []Pool{{Start: addr("192.168.0.1"), End: addr("192.168.0.1")}},
+			},
+		},
+		{
+			name: "should succeed with only a subnet",
+			spec: IPPoolSpec{
+				Pools: []Pool{{Subnet: subnet("2001:db8::/64")}},
+			},
+		},
+		{
+			name: "should fail without start nor subnet",
+			spec: IPPoolSpec{
+				Pools: []Pool{{Prefix: 24}},
+			},
+			expectedError: "either start or subnet is required",
+		},
+		{
+			name: "should fail with an end without start",
+			spec: IPPoolSpec{
+				Pools: []Pool{{End: addr("192.168.0.10"), Subnet: subnet("192.168.0.0/24")}},
+			},
+			expectedError: "end is ignored without start",
+		},
+		{
+			name: "should fail with an end before the start",
+			spec: IPPoolSpec{
+				Pools: []Pool{{Start: addr("192.168.0.10"), End: addr("192.168.0.9")}},
+			},
+			expectedError: "end must not be before the start",
+		},
+		{
+			name: "should fail with an end before the start in a previous octet",
+			spec: IPPoolSpec{
+				Pools: []Pool{{Start: addr("10.0.1.0"), End: addr("10.0.0.255")}},
+			},
+			expectedError: "end must not be before the start",
+		},
+		{
+			name: "should fail with a start and an end of different families",
+			spec: IPPoolSpec{
+				Pools: []Pool{{Start: addr("192.168.0.1"), End: addr("2001:db8::10")}},
+			},
+			expectedError: "end must be of the family of the start",
+		},
+		{
+			name: "should fail with a start and a subnet of different families",
+			spec: IPPoolSpec{
+				Pools: []Pool{{Start: addr("192.168.0.1"), Subnet: subnet("2001:db8::/64")}},
+			},
+			expectedError: "start must be of the family of the subnet",
+		},
+		{
+			name: "should fail with two backends",
+			spec: IPPoolSpec{
+				Pools: []Pool{{Subnet: subnet("192.168.0.0/24")}},
+				Backend: &Backend{
+					NetBox:   &NetBoxBackend{URL: "https://netbox.example.com"},
+					Infoblox: &InfobloxBackend{URL: "https://infoblox.example.com/wapi/v2.11"},
+				},
+			},
+			expectedError: "exactly one of netbox and infoblox must be given",
+		},
+		{
+			name: "should fail without backend",
+			spec: IPPoolSpec{
+				Pools:   []Pool{{Subnet: subnet("192.168.0.0/24")}},
+				Backend: &Backend{},
+			},
+			expectedError: "exactly one of netbox and infoblox must be given",
+		},
+		{
+			name: "should fail with a release hook URL and Job",
+			spec: IPPoolSpec{
+				Pools: []Pool{{Subnet: subnet("192.168.0.0/24")}},
+				ReleaseHook: &ReleaseHook{
+					URL: "https://hook.example.com",
+					Job: &batchv1.JobTemplateSpec{},
+				},
+			},
+			expectedError: "exactly one of url and job must be given",
+		},
+		{
+			name: "should fail with an empty release hook",
+			spec: IPPoolSpec{
+				Pools:       []Pool{{Subnet: subnet("192.168.0.0/24")}},
+				ReleaseHook: &ReleaseHook{},
+			},
+			expectedError: "exactly one of url and job must be given",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			tt.spec.NamePrefix = "abcpref"
+			pool := &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					GenerateName: "abc-",
+					Namespace:    "default",
+				},
+				Spec: tt.spec,
+			}
+			err := c.Create(context.TODO(), pool)
+			if tt.expectedError != "" {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(tt.expectedError))
+			} else {
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(c.Delete(context.TODO(), pool)).To(Succeed())
+			}
+		})
+	}
+}
+
+func TestIPClaimValidationRules(t *testing.T) {
+	g := NewWithT(t)
+
+	claim := &IPClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "abc",
+			Namespace: "default",
+		},
+		Spec: IPClaimSpec{
+			Pool: corev1.ObjectReference{Name: "abc"},
+		},
+	}
+	g.Expect(c.Create(context.TODO(), claim)).To(Succeed())
+	defer func() {
+		g.Expect(c.Delete(context.TODO(), claim)).To(Succeed())
+	}()
+
+	claim.Spec.Pool.Name = "def"
+	err := c.Update(context.TODO(), claim)
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(err.Error()).To(ContainSubstring("pool cannot be modified"))
+}
@@ -25,6 +25,23 @@ const (
 	// DataFinalizer allows IPAddressReconciler to clean up resources
 	// associated with IPAddress before removing it from the apiserver.
 	IPAddressFinalizer = "ipaddress.ipam.metal3.io"
+
+	// AddressRoleLabel is the label containing the role of an IPAddress
+	// allocated as an additional address of an IPClaim
+	AddressRoleLabel = "ipam.metal3.io/address-role"
+
+	// RetainedAddressLabel is the label of the IPAddresses kept after the
+	// deletion of their IPClaim, by the Retain reclaim policy of their pool
+	RetainedAddressLabel = "ipam.metal3.io/retained"
+
+	// BackendIDAnnotation is the annotation containing the identifier of the
+	// reservation of an IPAddress in the backend of its pool
+	BackendIDAnnotation = "ipam.metal3.io/backend-id"
+
+	// BackendMetadataAnnotation is the annotation containing the hash of the
+	// description and tags of the reservation of an IPAddress in the backend
+	// of its pool, to update the reservation when they change
+	BackendMetadataAnnotation = "ipam.metal3.io/backend-metadata"
 )
 
 // IPAddressSpec defines the desired state of IPAddress.
@@ -46,8 +63,21 @@ type IPAddressSpec struct {
 	// Address contains the IP address
 	Address IPAddressStr `json:"address"`
 
+	// DelegatedPrefix is the prefix delegated to the claim, in CIDR notation,
+	// if the pool delegates prefixes. The address is its first address.
+	DelegatedPrefix *IPSubnetStr `json:"delegatedPrefix,omitempty"`
+
 	// DNSServers is the list of dns servers
 	DNSServers []IPAddressStr `json:"dnsServers,omitempty"`
+
+	// SearchDomains is the list of dns search domains
+	SearchDomains []string `json:"searchDomains,omitempty"`
+
+	// NTPServers is the list of ntp servers, as IP addresses or host names
+	NTPServers []string `json:"ntpServers,omitempty"`
+
+	// DomainName is the domain name of the network
+	DomainName string `json:"domainName,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
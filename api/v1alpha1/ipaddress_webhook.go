@@ -68,6 +68,36 @@ func (c *IPAddress) ValidateCreate() error {
 				"cannot be empty",
 			),
 		)
+	} else if isIPv4Mapped(string(c.Spec.Address)) {
+		allErrs = append(allErrs,
+			field.Invalid(
+				field.NewPath("spec", "address"),
+				c.Spec.Address,
+				ipv4MappedMsg,
+			),
+		)
+	}
+
+	if c.Spec.Gateway != nil && isIPv4Mapped(string(*c.Spec.Gateway)) {
+		allErrs = append(allErrs,
+			field.Invalid(
+				field.NewPath("spec", "gateway"),
+				*c.Spec.Gateway,
+				ipv4MappedMsg,
+			),
+		)
+	}
+
+	for i, dnsServer := range c.Spec.DNSServers {
+		if isIPv4Mapped(string(dnsServer)) {
+			allErrs = append(allErrs,
+				field.Invalid(
+					field.NewPath("spec", "dnsServers").Index(i),
+					dnsServer,
+					ipv4MappedMsg,
+				),
+			)
+		}
 	}
 
 	if len(allErrs) == 0 {
@@ -43,6 +43,8 @@ func TestIPAddressCreateValidation(t *testing.T) {
 		expectErr   bool
 		ipPool      corev1.ObjectReference
 		address     IPAddressStr
+		gateway     *IPAddressStr
+		dnsServers  []IPAddressStr
 		claim       corev1.ObjectReference
 	}{
 		{
@@ -68,6 +70,44 @@ func TestIPAddressCreateValidation(t *testing.T) {
 				Name: "abc",
 			},
 		},
+		{
+			name:        "should fail with an IPv4-mapped address",
+			expectErr:   true,
+			addressName: "abc-1",
+			ipPool: corev1.ObjectReference{
+				Name: "abc",
+			},
+			claim: corev1.ObjectReference{
+				Name: "abc",
+			},
+			address: "::ffff:192.168.0.10",
+		},
+		{
+			name:        "should fail with an IPv4-mapped gateway",
+			expectErr:   true,
+			addressName: "abc-1",
+			ipPool: corev1.ObjectReference{
+				Name: "abc",
+			},
+			claim: corev1.ObjectReference{
+				Name: "abc",
+			},
+			address: "192.168.0.10",
+			gateway: ipAddressStrPtr("::ffff:192.168.0.1"),
+		},
+		{
+			name:        "should fail with an IPv4-mapped dns server",
+			expectErr:   true,
+			addressName: "abc-1",
+			ipPool: corev1.ObjectReference{
+				Name: "abc",
+			},
+			claim: corev1.ObjectReference{
+				Name: "abc",
+			},
+			address:    "192.168.0.10",
+			dnsServers: []IPAddressStr{"8.8.8.8", "::ffff:8.8.4.4"},
+		},
 		{
 			name:        "should fail without ipPool name",
 			expectErr:   true,
@@ -104,9 +144,11 @@ func TestIPAddressCreateValidation(t *testing.T) {
 					Name:      tt.addressName,
 				},
 				Spec: IPAddressSpec{
-					Pool:    tt.ipPool,
-					Address: tt.address,
-					Claim:   tt.claim,
+					Pool:       tt.ipPool,
+					Address:    tt.address,
+					Gateway:    tt.gateway,
+					DNSServers: tt.dnsServers,
+					Claim:      tt.claim,
 				},
 			}
 
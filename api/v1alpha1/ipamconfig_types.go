@@ -0,0 +1,105 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// IPAMConfigName is the name of the IPAMConfig supplying the defaults of
+	// the IPPools of its namespace.
+	IPAMConfigName = "default"
+
+	// IPPoolNamePlaceholder is replaced by the name of the IPPool in the
+	// namePrefix of an IPAMConfig.
+	IPPoolNamePlaceholder = "{name}"
+)
+
+// IPAMConfigSpec defines the defaults of the IPPools of a namespace. They are
+// set on the IPPools when they are created, unless the IPPool sets them.
+type IPAMConfigSpec struct {
+
+	// NamePrefix is the pattern of the namePrefix of the IPPools. "{name}" is
+	// replaced by the name of the IPPool.
+	// +optional
+	NamePrefix string `json:"namePrefix,omitempty"`
+
+	// DNSServers is the default list of dns servers
+	// +optional
+	DNSServers []IPAddressStr `json:"dnsServers,omitempty"`
+
+	// SearchDomains is the default list of dns search domains
+	// +optional
+	SearchDomains []string `json:"searchDomains,omitempty"`
+
+	// NTPServers is the default list of ntp servers, as IP addresses or host
+	// names
+	// +optional
+	NTPServers []string `json:"ntpServers,omitempty"`
+
+	// DomainName is the default domain name of the network
+	// +optional
+	DomainName string `json:"domainName,omitempty"`
+
+	// AllowedNamespaces is the default list of namespaces whose IPClaims are
+	// allowed to reference the IPPools.
+	// +optional
+	AllowedNamespaces []string `json:"allowedNamespaces,omitempty"`
+
+	// PropagatedAnnotations is the default list of annotations copied from
+	// the IPClaims to their IPAddresses.
+	// +optional
+	PropagatedAnnotations []string `json:"propagatedAnnotations,omitempty"`
+
+	// ReclaimPolicy is the default reclaimPolicy of the IPPools.
+	// +kubebuilder:validation:Enum=Delete;Retain
+	// +optional
+	ReclaimPolicy ReclaimPolicy `json:"reclaimPolicy,omitempty"`
+
+	// AllocationStrategy is the default allocationStrategy of the IPPools.
+	// +kubebuilder:validation:Enum=Sequential;Random;LeastRecentlyUsed;Hash;EUI64
+	// +optional
+	AllocationStrategy AllocationStrategy `json:"allocationStrategy,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:resource:path=ipamconfigs,scope=Namespaced,categories=cluster-api,shortName=ipamc;ipamconfig;m3ipamc;m3ipamconfig;m3ipamconfigs;metal3ipamc;metal3ipamconfig;metal3ipamconfigs
+// +kubebuilder:storageversion
+// +kubebuilder:object:root=true
+// +kubebuilder:printcolumn:name="Name Prefix",type="string",JSONPath=".spec.namePrefix",description="Pattern of the namePrefix of the IPPools"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp",description="Time duration since creation of Metal3IPAMConfig"
+// IPAMConfig is the Schema for the ipamconfigs API
+type IPAMConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec IPAMConfigSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// IPAMConfigList contains a list of IPAMConfig
+type IPAMConfigList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []IPAMConfig `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&IPAMConfig{}, &IPAMConfigList{})
+}
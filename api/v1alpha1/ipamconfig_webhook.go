@@ -0,0 +1,102 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+func (c *IPAMConfig) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(c).
+		Complete()
+}
+
+// +kubebuilder:webhook:verbs=create;update,path=/validate-ipam-metal3-io-v1alpha4-ipamconfig,mutating=false,failurePolicy=fail,groups=ipam.metal3.io,resources=ipamconfigs,versions=v1alpha4,name=validation.ipamconfig.ipam.metal3.io,matchPolicy=Equivalent,sideEffects=None,admissionReviewVersions=v1;v1beta1
+// +kubebuilder:webhook:verbs=create;update,path=/mutate-ipam-metal3-io-v1alpha4-ipamconfig,mutating=true,failurePolicy=fail,groups=ipam.metal3.io,resources=ipamconfigs,versions=v1alpha4,name=default.ipamconfig.ipam.metal3.io,matchPolicy=Equivalent,sideEffects=None,admissionReviewVersions=v1;v1beta1
+
+var _ webhook.Defaulter = &IPAMConfig{}
+var _ webhook.Validator = &IPAMConfig{}
+
+func (c *IPAMConfig) Default() {
+}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (c *IPAMConfig) ValidateCreate() error {
+	return c.validate()
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (c *IPAMConfig) ValidateUpdate(old runtime.Object) error {
+	oldIPAMConfig, ok := old.(*IPAMConfig)
+	if !ok || oldIPAMConfig == nil {
+		return apierrors.NewInternalError(errors.New("unable to convert existing object"))
+	}
+	return c.validate()
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (c *IPAMConfig) ValidateDelete() error {
+	return nil
+}
+
+func (c *IPAMConfig) validate() error {
+	allErrs := field.ErrorList{}
+
+	// Only one IPAMConfig is used per namespace
+	if c.Name != IPAMConfigName {
+		allErrs = append(allErrs,
+			field.Invalid(
+				field.NewPath("metadata", "name"),
+				c.Name,
+				"must be "+IPAMConfigName,
+			),
+		)
+	}
+
+	// The pattern is verified with a name of a single character, since the
+	// IPAddress names are made of the namePrefix and of the IPClaim name
+	if c.Spec.NamePrefix != "" {
+		namePrefix := strings.ReplaceAll(c.Spec.NamePrefix, IPPoolNamePlaceholder, "a")
+		for _, msg := range validation.IsDNS1123Subdomain(namePrefix + "-a") {
+			allErrs = append(allErrs,
+				field.Invalid(field.NewPath("spec", "namePrefix"), c.Spec.NamePrefix, msg),
+			)
+		}
+	}
+
+	allErrs = append(allErrs, validateNetworkAddresses(
+		field.NewPath("spec"), nil, c.Spec.DNSServers, 0,
+	)...)
+	allErrs = append(allErrs, validateDomains(
+		field.NewPath("spec"), c.Spec.SearchDomains, c.Spec.NTPServers,
+		c.Spec.DomainName,
+	)...)
+
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("IPAMConfig").GroupKind(), c.Name, allErrs)
+}
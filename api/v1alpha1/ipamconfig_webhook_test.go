@@ -0,0 +1,125 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestIPAMConfigDefault(t *testing.T) {
+	g := NewWithT(t)
+
+	c := &IPAMConfig{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "foo",
+		},
+	}
+	c.Default()
+
+	g.Expect(c.Spec).To(Equal(IPAMConfigSpec{}))
+}
+
+func TestIPAMConfigValidation(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		configName string
+		expectErr  bool
+		spec       IPAMConfigSpec
+	}{
+		{
+			name:       "should succeed when values are correct",
+			expectErr:  false,
+			configName: IPAMConfigName,
+			spec: IPAMConfigSpec{
+				NamePrefix:    "{name}-addr",
+				DNSServers:    []IPAddressStr{"8.8.8.8", "2001:4860:4860::8888"},
+				SearchDomains: []string{"example.com"},
+				NTPServers:    []string{"ntp.example.com"},
+				DomainName:    "example.com",
+			},
+		},
+		{
+			name:       "should fail with another name",
+			expectErr:  true,
+			configName: "abc",
+		},
+		{
+			name:       "should fail with an invalid namePrefix",
+			expectErr:  true,
+			configName: IPAMConfigName,
+			spec: IPAMConfigSpec{
+				NamePrefix: "{name}_addr",
+			},
+		},
+		{
+			name:       "should fail with an invalid dns server",
+			expectErr:  true,
+			configName: IPAMConfigName,
+			spec: IPAMConfigSpec{
+				DNSServers: []IPAddressStr{"dns.example.com"},
+			},
+		},
+		{
+			name:       "should fail with an invalid search domain",
+			expectErr:  true,
+			configName: IPAMConfigName,
+			spec: IPAMConfigSpec{
+				SearchDomains: []string{"example com"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			obj := &IPAMConfig{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+					Name:      tt.configName,
+				},
+				Spec: tt.spec,
+			}
+
+			if tt.expectErr {
+				g.Expect(obj.ValidateCreate()).NotTo(Succeed())
+				g.Expect(obj.ValidateUpdate(obj.DeepCopy())).NotTo(Succeed())
+			} else {
+				g.Expect(obj.ValidateCreate()).To(Succeed())
+				g.Expect(obj.ValidateUpdate(obj.DeepCopy())).To(Succeed())
+			}
+
+			g.Expect(obj.ValidateDelete()).To(Succeed())
+		})
+	}
+}
+
+func TestIPAMConfigUpdateValidationNilOld(t *testing.T) {
+	g := NewWithT(t)
+
+	obj := &IPAMConfig{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "foo",
+			Name:      IPAMConfigName,
+		},
+	}
+	g.Expect(obj.ValidateUpdate(nil)).NotTo(Succeed())
+}
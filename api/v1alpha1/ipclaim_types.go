@@ -25,6 +25,95 @@ const (
 	// IPClaimFinalizer allows IPClaimReconciler to clean up resources
 	// associated with IPClaim before removing it from the apiserver.
 	IPClaimFinalizer = "ipclaim.ipam.metal3.io"
+
+	// PrefixAnnotation overrides the prefix of the allocated address, for
+	// claims created by controllers that do not set the prefix field. The
+	// prefix field takes precedence over the annotation.
+	PrefixAnnotation = "ipam.metal3.io/prefix"
+
+	// HAPeerRole is the role of the address of the second node of an HA
+	// address set
+	HAPeerRole = "peer"
+
+	// HAVIPRole is the role of the virtual IP of an HA address set
+	HAVIPRole = "vip"
+
+	// AddressCountRolePrefix prefixes the roles of the additional addresses
+	// requested by the address count of a claim, followed by their index
+	// starting at 1, the claim address being the first one.
+	AddressCountRolePrefix = "address-"
+
+	// DualStackIPv6Role is the role of the IPv6 address of a claim of a
+	// dual-stack pool
+	DualStackIPv6Role = "ipv6"
+
+	// MaxAddressCount is the maximum number of addresses of a claim
+	MaxAddressCount = 256
+
+	// IPClaimStaleCondition reports the claims left without an address for
+	// longer than the stale claim threshold of the controller. It is true if
+	// the pool has addresses available, and false if it is exhausted.
+	IPClaimStaleCondition = "Stale"
+
+	// IPClaimBindingFailedCondition reports the claims left without an
+	// address after their binding deadline. No address is allocated to them
+	// anymore, they need to be recreated.
+	IPClaimBindingFailedCondition = "BindingFailed"
+
+	// BindingHoldAnnotationPrefix is the prefix of the annotations holding
+	// the binding of a claim, like the lifecycle hooks of Cluster API. The
+	// address of a claim with such annotations is computed but not allocated
+	// until all of them are removed. The name of the hold follows the prefix.
+	BindingHoldAnnotationPrefix = "hold.ipam.metal3.io/"
+
+	// IPClaimBindingHeldCondition reports the claims whose binding is held.
+	// Its message contains the proposed addresses and the holds.
+	IPClaimBindingHeldCondition = "BindingHeld"
+
+	// IPClaimDeregistrationFailedCondition reports the claims being deleted
+	// whose addresses could not be deregistered by the release hook of the
+	// pool. The addresses are not available until the hook succeeds.
+	IPClaimDeregistrationFailedCondition = "DeregistrationFailed"
+
+	// LeaseRenewedAnnotation renews the lease of the addresses of a claim. It
+	// contains the RFC3339 time of the renewal, the lease then expires after
+	// its duration from that time.
+	LeaseRenewedAnnotation = "ipam.metal3.io/lease-renewed"
+
+	// MACAddressAnnotation contains the MAC address of the machine of a
+	// claim. The claim gets the address reserved for it in the
+	// macReservations of the pool, if any.
+	MACAddressAnnotation = "ipam.metal3.io/mac-address"
+
+	// IPClaimLeaseExpiredCondition reports the claims whose lease expired
+	// without being renewed. Their addresses are released, and allocated again
+	// once the lease is renewed.
+	IPClaimLeaseExpiredCondition = "LeaseExpired"
+
+	// BackendDescriptionAnnotation overrides the description of the
+	// reservations of the addresses of a claim in the backend of its pool,
+	// "<namespace>/<name>" of the claim by default.
+	BackendDescriptionAnnotation = "ipam.metal3.io/backend-description"
+
+	// BackendTagsAnnotation contains the comma-separated tags added to the
+	// reservations of the addresses of a claim in the backend of its pool
+	BackendTagsAnnotation = "ipam.metal3.io/backend-tags"
+
+	// AllocationAPILabel marks the IPClaims created by the allocation API of
+	// the controller on behalf of the systems outside of the cluster. Only
+	// those claims can be read and released through the API.
+	AllocationAPILabel = "ipam.metal3.io/allocation-api"
+
+	// ServedByAnnotation contains the name of the IPPool serving a claim
+	// handed over by its pool, the target pool of a drain or a fallback pool
+	// of an exhausted pool.
+	ServedByAnnotation = "ipam.metal3.io/served-by"
+
+	// MigrateAnnotation moves a claim of a draining pool to the target pool
+	// of the drain, releasing its current address. It is set when the machine
+	// of the claim is reprovisioned in place, and removed once the claim is
+	// handed over.
+	MigrateAnnotation = "ipam.metal3.io/migrate"
 )
 
 // IPClaimSpec defines the desired state of IPClaim.
@@ -32,6 +121,80 @@ type IPClaimSpec struct {
 
 	// Pool is the IPPool this was generated from.
 	Pool corev1.ObjectReference `json:"pool"`
+
+	// PoolSelector selects the IPPool of the claim by its labels when the
+	// pool name is not given, among the IPPools of the namespace of the claim,
+	// or among the ClusterIPPools if the pool kind is ClusterIPPool. The
+	// selected pool is then recorded in the pool reference.
+	// +optional
+	PoolSelector *metav1.LabelSelector `json:"poolSelector,omitempty"`
+
+	// Subnet restricts the allocation to the addresses of the pool that are
+	// in this subnet. It must overlap with at least one of the pools.
+	// +optional
+	Subnet *IPSubnetStr `json:"subnet,omitempty"`
+
+	// PreferredAddress is an address the allocator tries first for the claim
+	// address, such as the address a brown-field host already owns.
+	// +optional
+	PreferredAddress *PreferredAddress `json:"preferredAddress,omitempty"`
+
+	// Prefix overrides the prefix of the pools for the allocated address.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=128
+	// +optional
+	Prefix *int `json:"prefix,omitempty"`
+
+	// AffinityGroup is a key shared by claims whose addresses must all be
+	// allocated from the same pool of the IPPool. The first allocation of the
+	// group selects the pool.
+	// +optional
+	AffinityGroup string `json:"affinityGroup,omitempty"`
+
+	// HAAddressSet requests a set of addresses for a pair of HA gateways from
+	// the same pool: the claim address for the first node, an address for the
+	// peer node and a virtual IP. The addresses are released together.
+	// +optional
+	HAAddressSet bool `json:"haAddressSet,omitempty"`
+
+	// Roles is the list of the roles of the additional addresses requested by
+	// the claim, such as secondary or bmc. The claim address is the primary
+	// one. An IPAddress labelled with its role is created for each of them.
+	// +optional
+	Roles []string `json:"roles,omitempty"`
+
+	// AddressCount is the number of addresses requested by the claim, all
+	// allocated from the same pool of the IPPool. The claim address is the
+	// first one, the others get the address-1 to address-N-1 roles.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=256
+	// +optional
+	AddressCount *int `json:"addressCount,omitempty"`
+
+	// BindingDeadline is the duration, from the creation of the claim, after
+	// which the claim is marked failed if it has no address. It defaults to
+	// the claimBindingDeadline of the IPPool. Zero disables the deadline.
+	// +optional
+	BindingDeadline *metav1.Duration `json:"bindingDeadline,omitempty"`
+
+	// LeaseDuration is the duration after which the addresses of the claim
+	// are released if the lease is not renewed, from the creation of the
+	// claim or its last renewal. It defaults to the leaseDuration of the
+	// IPPool. Zero disables the lease.
+	// +optional
+	LeaseDuration *metav1.Duration `json:"leaseDuration,omitempty"`
+}
+
+// PreferredAddress is the address preferred by a claim.
+type PreferredAddress struct {
+
+	// Address is the preferred address of the claim.
+	Address IPAddressStr `json:"address"`
+
+	// Strict fails the allocation when the address is not available, instead
+	// of allocating another address.
+	// +optional
+	Strict bool `json:"strict,omitempty"`
 }
 
 // IPClaimStatus defines the observed state of IPClaim.
@@ -40,8 +203,24 @@ type IPClaimStatus struct {
 	// Address is the IPAddress that was generated for this claim.
 	Address *corev1.ObjectReference `json:"address,omitempty"`
 
+	// Addresses contains the additional IPAddresses generated for this claim,
+	// by role.
+	Addresses map[string]corev1.ObjectReference `json:"addresses,omitempty"`
+
+	// Pool is the IPPool, or ClusterIPPool, that allocated the addresses of
+	// the claim. It differs from the referenced pool when the claim was
+	// handed over to a fallback pool or to the target pool of a drain.
+	// +optional
+	Pool *corev1.ObjectReference `json:"pool,omitempty"`
+
 	// ErrorMessage contains the error message
 	ErrorMessage *string `json:"errorMessage,omitempty"`
+
+	// Conditions defines the current service state of the IPClaim.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -55,6 +234,11 @@ type IPClaim struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
 
+	// +kubebuilder:validation:XValidation:rule="!has(oldSelf.pool.name) || (has(self.pool.name) && self.pool.name == oldSelf.pool.name)",message="pool cannot be modified"
+	// +kubebuilder:validation:XValidation:rule="has(self.prefix) == has(oldSelf.prefix) && (!has(self.prefix) || self.prefix == oldSelf.prefix)",message="prefix cannot be modified"
+	// +kubebuilder:validation:XValidation:rule="has(self.affinityGroup) == has(oldSelf.affinityGroup) && (!has(self.affinityGroup) || self.affinityGroup == oldSelf.affinityGroup)",message="affinityGroup cannot be modified"
+	// +kubebuilder:validation:XValidation:rule="(has(self.haAddressSet) && self.haAddressSet) == (has(oldSelf.haAddressSet) && oldSelf.haAddressSet)",message="haAddressSet cannot be modified"
+	// +kubebuilder:validation:XValidation:rule="has(self.addressCount) == has(oldSelf.addressCount) && (!has(self.addressCount) || self.addressCount == oldSelf.addressCount)",message="addressCount cannot be modified"
 	Spec   IPClaimSpec   `json:"spec,omitempty"`
 	Status IPClaimStatus `json:"status,omitempty"`
 }
@@ -14,15 +14,34 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+
 	"github.com/pkg/errors"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/apimachinery/pkg/util/validation/field"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 )
 
+// ipClaimWebhookReader is used to fetch the IPPool referenced by an IPClaim
+// from another namespace
+var ipClaimWebhookReader client.Reader
+
+// ValidateIPClaimPools enables the rejection of the IPClaims whose pool does
+// not exist, is being deleted or is exhausted. It is disabled by default, the
+// IPClaims being possibly created before their pools, as by clusterctl move.
+var ValidateIPClaimPools = false
+
 func (c *IPClaim) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	ipClaimWebhookReader = mgr.GetAPIReader()
 	return ctrl.NewWebhookManagedBy(mgr).
 		For(c).
 		Complete()
@@ -34,13 +53,25 @@ func (c *IPClaim) SetupWebhookWithManager(mgr ctrl.Manager) error {
 var _ webhook.Defaulter = &IPClaim{}
 var _ webhook.Validator = &IPClaim{}
 
+// Default sets the namespace of the IPPool referenced by name to the
+// namespace of the claim and normalizes the addresses of the claim
 func (c *IPClaim) Default() {
+	if c.Spec.PoolSelector == nil && c.Spec.Pool.Name != "" && c.Spec.Pool.Namespace == "" &&
+		!IsClusterIPPoolRef(c.Spec.Pool) {
+		c.Spec.Pool.Namespace = c.Namespace
+	}
+	c.Spec.Subnet = normalizeIPSubnetPtr(c.Spec.Subnet)
+	if c.Spec.PreferredAddress != nil {
+		c.Spec.PreferredAddress.Address = normalizeIPAddress(c.Spec.PreferredAddress.Address)
+	}
 }
 
 // ValidateCreate implements webhook.Validator so a webhook will be registered for the type
 func (c *IPClaim) ValidateCreate() error {
 	allErrs := field.ErrorList{}
-	if c.Spec.Pool.Name == "" {
+	if c.Spec.PoolSelector != nil {
+		allErrs = append(allErrs, c.validatePoolSelector()...)
+	} else if c.Spec.Pool.Name == "" {
 		allErrs = append(allErrs,
 			field.Invalid(
 				field.NewPath("spec", "pool", "name"),
@@ -48,6 +79,42 @@ func (c *IPClaim) ValidateCreate() error {
 				"cannot be empty",
 			),
 		)
+	} else if IsClusterIPPoolRef(c.Spec.Pool) {
+		allErrs = append(allErrs, c.validateClusterPool()...)
+	} else if c.Spec.Pool.Namespace != "" && c.Spec.Pool.Namespace != c.Namespace {
+		if err := c.validateCrossNamespacePool(); err != nil {
+			allErrs = append(allErrs,
+				field.Forbidden(
+					field.NewPath("spec", "pool", "namespace"),
+					err.Error(),
+				),
+			)
+		}
+	}
+
+	if c.Spec.Subnet != nil {
+		if err := c.validateSubnet(); err != nil {
+			allErrs = append(allErrs,
+				field.Invalid(
+					field.NewPath("spec", "subnet"),
+					c.Spec.Subnet,
+					err.Error(),
+				),
+			)
+		}
+	}
+
+	allErrs = append(allErrs, c.validatePreferredAddress()...)
+	allErrs = append(allErrs, c.validatePrefix()...)
+	allErrs = append(allErrs, c.validateRoles()...)
+	allErrs = append(allErrs, c.validateAddressCount()...)
+	allErrs = append(allErrs, validateNonNegativeDuration(
+		field.NewPath("spec", "bindingDeadline"), c.Spec.BindingDeadline,
+	)...)
+	allErrs = append(allErrs, c.validateLease()...)
+	allErrs = append(allErrs, c.validateMACAddress()...)
+	if len(allErrs) == 0 && ValidateIPClaimPools && ipClaimWebhookReader != nil {
+		allErrs = append(allErrs, c.validatePoolAvailability()...)
 	}
 
 	if len(allErrs) == 0 {
@@ -56,6 +123,354 @@ func (c *IPClaim) ValidateCreate() error {
 	return apierrors.NewInvalid(GroupVersion.WithKind("IPClaim").GroupKind(), c.Name, allErrs)
 }
 
+// validatePoolAvailability verifies that the pool of the claim exists, is
+// not being deleted and is not exhausted, unless the claim has an address
+// kept for it in the pool or the pool has fallback pools
+func (c *IPClaim) validatePoolAvailability() field.ErrorList {
+	allErrs := field.ErrorList{}
+	path := field.NewPath("spec", "pool", "name")
+	var ipPool *IPPool
+	var err error
+	if c.Spec.PoolSelector != nil {
+		path = field.NewPath("spec", "poolSelector")
+		ipPool, err = SelectClaimPool(context.TODO(), ipClaimWebhookReader, c)
+		if err == nil && ipPool == nil {
+			return append(allErrs, field.NotFound(path, "no pool matches the selector"))
+		}
+	} else {
+		ipPool, err = GetClaimPool(context.TODO(), ipClaimWebhookReader, c)
+		if apierrors.IsNotFound(err) {
+			return append(allErrs,
+				field.NotFound(path, fmt.Sprintf("pool %s does not exist", c.poolKey())),
+			)
+		}
+	}
+	if err != nil {
+		return append(allErrs,
+			field.InternalError(path, errors.Wrap(err, "unable to get the pool of the claim")),
+		)
+	}
+
+	if !ipPool.DeletionTimestamp.IsZero() {
+		return append(allErrs,
+			field.Invalid(path, ipPool.Name, "the pool is being deleted"),
+		)
+	}
+	if meta.IsStatusConditionTrue(ipPool.Status.Conditions, IPPoolExhaustedCondition) &&
+		len(ipPool.Spec.FallbackPools) == 0 && !c.hasKeptAddress(ipPool) {
+		return append(allErrs,
+			field.Invalid(path, ipPool.Name, "the pool has no address left to allocate"),
+		)
+	}
+	return allErrs
+}
+
+// hasKeptAddress returns true if the pool keeps an address for the claim, a
+// pre-allocated, retained or MAC-reserved one
+func (c *IPClaim) hasKeptAddress(ipPool *IPPool) bool {
+	key := c.Name
+	if c.Namespace != ipPool.Namespace {
+		key = c.Namespace + "/" + c.Name
+	}
+	if _, ok := ipPool.Spec.PreAllocations[key]; ok {
+		return true
+	}
+	if _, ok := ipPool.Status.RetainedAddresses[key]; ok {
+		return true
+	}
+	mac, err := c.GetMACAddress()
+	if err != nil || mac == "" {
+		return false
+	}
+	_, ok := ipPool.Spec.MACReservations[mac]
+	return ok
+}
+
+// validatePoolSelector verifies that the pool selector is valid and that the
+// pool is not also given by name, the pools being selected in the namespace of
+// the claim
+func (c *IPClaim) validatePoolSelector() field.ErrorList {
+	allErrs := field.ErrorList{}
+	if _, err := metav1.LabelSelectorAsSelector(c.Spec.PoolSelector); err != nil {
+		allErrs = append(allErrs,
+			field.Invalid(
+				field.NewPath("spec", "poolSelector"),
+				c.Spec.PoolSelector,
+				err.Error(),
+			),
+		)
+	}
+	if c.Spec.Pool.Name != "" {
+		allErrs = append(allErrs,
+			field.Invalid(
+				field.NewPath("spec", "pool", "name"),
+				c.Spec.Pool.Name,
+				"must be empty with a pool selector",
+			),
+		)
+	}
+	if c.Spec.Pool.Namespace != "" {
+		allErrs = append(allErrs,
+			field.Invalid(
+				field.NewPath("spec", "pool", "namespace"),
+				c.Spec.Pool.Namespace,
+				"must be empty with a pool selector",
+			),
+		)
+	}
+	return allErrs
+}
+
+// validateLease verifies that the lease duration is not negative and that
+// the lease renewed annotation is an RFC3339 time
+func (c *IPClaim) validateLease() field.ErrorList {
+	allErrs := validateNonNegativeDuration(
+		field.NewPath("spec", "leaseDuration"), c.Spec.LeaseDuration,
+	)
+	if _, err := c.GetLeaseRenewal(); err != nil {
+		allErrs = append(allErrs,
+			field.Invalid(
+				field.NewPath("metadata", "annotations").Key(LeaseRenewedAnnotation),
+				c.Annotations[LeaseRenewedAnnotation],
+				"is not an RFC3339 time",
+			),
+		)
+	}
+	return allErrs
+}
+
+// validateMACAddress verifies that the MAC address annotation, if any, is a
+// valid MAC address
+func (c *IPClaim) validateMACAddress() field.ErrorList {
+	allErrs := field.ErrorList{}
+	if _, err := c.GetMACAddress(); err != nil {
+		allErrs = append(allErrs,
+			field.Invalid(
+				field.NewPath("metadata", "annotations").Key(MACAddressAnnotation),
+				c.Annotations[MACAddressAnnotation],
+				"is not a MAC address",
+			),
+		)
+	}
+	return allErrs
+}
+
+// validateRoles verifies that the roles of the additional addresses are
+// unique and valid label values
+func (c *IPClaim) validateRoles() field.ErrorList {
+	allErrs := field.ErrorList{}
+	roles := map[string]bool{}
+	if c.Spec.HAAddressSet {
+		roles[HAPeerRole] = true
+		roles[HAVIPRole] = true
+	}
+	for i := 1; i < c.GetAddressCount(); i++ {
+		roles[fmt.Sprintf("%s%d", AddressCountRolePrefix, i)] = true
+	}
+	for i, role := range c.Spec.Roles {
+		rolePath := field.NewPath("spec", "roles").Index(i)
+		if role == "" {
+			allErrs = append(allErrs, field.Invalid(rolePath, role, "cannot be empty"))
+			continue
+		}
+		for _, msg := range validation.IsValidLabelValue(role) {
+			allErrs = append(allErrs, field.Invalid(rolePath, role, msg))
+		}
+		if roles[role] {
+			allErrs = append(allErrs, field.Duplicate(rolePath, role))
+		}
+		roles[role] = true
+	}
+	return allErrs
+}
+
+// validatePreferredAddress verifies that the preferred address is a valid
+// address, in the claim subnet if set
+func (c *IPClaim) validatePreferredAddress() field.ErrorList {
+	allErrs := field.ErrorList{}
+	if c.Spec.PreferredAddress == nil {
+		return allErrs
+	}
+	addressPath := field.NewPath("spec", "preferredAddress", "address")
+	address := c.Spec.PreferredAddress.Address
+	ip := net.ParseIP(string(address))
+	if ip == nil {
+		return append(allErrs, field.Invalid(addressPath, address, "is not a valid IP address"))
+	}
+	if isIPv4Mapped(string(address)) {
+		return append(allErrs, field.Invalid(addressPath, address, ipv4MappedMsg))
+	}
+	if c.Spec.Subnet == nil {
+		return allErrs
+	}
+	if _, subnet, err := net.ParseCIDR(string(*c.Spec.Subnet)); err == nil && !subnet.Contains(ip) {
+		allErrs = append(allErrs, field.Invalid(addressPath, address, "is not in the claim subnet"))
+	}
+	return allErrs
+}
+
+// validateAddressCount verifies that the address count is within bounds
+func (c *IPClaim) validateAddressCount() field.ErrorList {
+	allErrs := field.ErrorList{}
+	if c.Spec.AddressCount == nil {
+		return allErrs
+	}
+	if *c.Spec.AddressCount < 1 || *c.Spec.AddressCount > MaxAddressCount {
+		allErrs = append(allErrs,
+			field.Invalid(
+				field.NewPath("spec", "addressCount"),
+				*c.Spec.AddressCount,
+				fmt.Sprintf("must be between 1 and %d", MaxAddressCount),
+			),
+		)
+	}
+	return allErrs
+}
+
+// validatePrefix verifies that the prefix override is a valid prefix length,
+// and fits the IP family of the claim subnet if set
+func (c *IPClaim) validatePrefix() field.ErrorList {
+	allErrs := field.ErrorList{}
+	prefixPath := field.NewPath("spec", "prefix")
+	if c.Spec.Prefix == nil {
+		prefixPath = field.NewPath("metadata", "annotations").Key(PrefixAnnotation)
+	}
+	prefix, ok, err := c.GetPrefixOverride()
+	if err != nil {
+		return append(allErrs,
+			field.Invalid(prefixPath, c.Annotations[PrefixAnnotation], "is not an integer"),
+		)
+	}
+	if !ok {
+		return allErrs
+	}
+	maxPrefix := 8 * net.IPv6len
+	if c.Spec.Subnet != nil {
+		if ip, _, err := net.ParseCIDR(string(*c.Spec.Subnet)); err == nil && ip.To4() != nil {
+			maxPrefix = 8 * net.IPv4len
+		}
+	}
+	if prefix < 0 || prefix > maxPrefix {
+		allErrs = append(allErrs,
+			field.Invalid(prefixPath, prefix,
+				fmt.Sprintf("must be between 0 and %d", maxPrefix),
+			),
+		)
+	}
+	return allErrs
+}
+
+// validateSubnet verifies that the subnet is valid and overlaps with the
+// pools of the IPPool, if it exists already
+func (c *IPClaim) validateSubnet() error {
+	_, subnet, err := net.ParseCIDR(string(*c.Spec.Subnet))
+	if err != nil {
+		return errors.New("is not a valid subnet")
+	}
+	if isIPv4Mapped(string(*c.Spec.Subnet)) {
+		return errors.New(ipv4MappedMsg)
+	}
+	if ipClaimWebhookReader == nil || c.Spec.Pool.Name == "" {
+		return nil
+	}
+	ipPool, err := GetClaimPool(context.TODO(), ipClaimWebhookReader, c)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return errors.Wrapf(err, "unable to get IPPool %s", c.poolKey())
+	}
+	for _, pool := range ipPool.Spec.Pools {
+		poolRange, err := NewPoolRange(pool)
+		if err != nil {
+			continue
+		}
+		if poolRange.Overlaps(subnet) {
+			return nil
+		}
+	}
+	return errors.Errorf("does not overlap with the pools of IPPool %s", c.poolKey())
+}
+
+// poolKey returns the key of the IPPool referenced by the claim, without
+// namespace for a ClusterIPPool
+func (c *IPClaim) poolKey() client.ObjectKey {
+	if IsClusterIPPoolRef(c.Spec.Pool) {
+		return client.ObjectKey{Name: c.Spec.Pool.Name}
+	}
+	namespace := c.Spec.Pool.Namespace
+	if namespace == "" {
+		namespace = c.Namespace
+	}
+	return client.ObjectKey{
+		Name:      c.Spec.Pool.Name,
+		Namespace: namespace,
+	}
+}
+
+// validateClusterPool verifies that the reference to a ClusterIPPool has no
+// namespace and that the ClusterIPPool, if it exists already, allows the
+// namespace of the claim
+func (c *IPClaim) validateClusterPool() field.ErrorList {
+	allErrs := field.ErrorList{}
+	if c.Spec.Pool.Namespace != "" {
+		return append(allErrs,
+			field.Invalid(
+				field.NewPath("spec", "pool", "namespace"),
+				c.Spec.Pool.Namespace,
+				"must be empty for a ClusterIPPool",
+			),
+		)
+	}
+	if ipClaimWebhookReader == nil {
+		return allErrs
+	}
+	ipPool, err := GetClaimPool(context.TODO(), ipClaimWebhookReader, c)
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			allErrs = append(allErrs,
+				field.InternalError(field.NewPath("spec", "pool"),
+					errors.Wrapf(err, "unable to get ClusterIPPool %s", c.Spec.Pool.Name),
+				),
+			)
+		}
+		return allErrs
+	}
+	if !ipPool.IsNamespaceAllowed(c.Namespace) {
+		allErrs = append(allErrs,
+			field.Forbidden(
+				field.NewPath("spec", "pool", "name"),
+				fmt.Sprintf("ClusterIPPool %s does not allow IPClaims from namespace %s",
+					c.Spec.Pool.Name, c.Namespace,
+				),
+			),
+		)
+	}
+	return allErrs
+}
+
+// validateCrossNamespacePool verifies that the IPPool referenced in another
+// namespace allows the namespace of the claim
+func (c *IPClaim) validateCrossNamespacePool() error {
+	if ipClaimWebhookReader == nil {
+		return errors.New("cross-namespace pool references are not allowed")
+	}
+	key := c.poolKey()
+	ipPool, err := GetIPPool(context.TODO(), ipClaimWebhookReader, key)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return errors.Errorf("IPPool %s not found", key)
+		}
+		return errors.Wrapf(err, "unable to get IPPool %s", key)
+	}
+	if !ipPool.IsNamespaceAllowed(c.Namespace) {
+		return errors.Errorf("IPPool %s does not allow IPClaims from namespace %s",
+			key, c.Namespace,
+		)
+	}
+	return nil
+}
+
 // ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
 func (c *IPClaim) ValidateUpdate(old runtime.Object) error {
 	allErrs := field.ErrorList{}
@@ -63,8 +478,34 @@ func (c *IPClaim) ValidateUpdate(old runtime.Object) error {
 	if !ok || oldIPClaim == nil {
 		return apierrors.NewInternalError(errors.New("unable to convert existing object"))
 	}
+	// The claims are compared as defaulted, not to reject the defaults of the
+	// claims created before the defaulting as modifications
+	oldIPClaim = oldIPClaim.DeepCopy()
+	oldIPClaim.Default()
+	c = c.DeepCopy()
+	c.Default()
 
-	if c.Spec.Pool.Name != oldIPClaim.Spec.Pool.Name {
+	// The pool selected by the pool selector is recorded once
+	selected := oldIPClaim.Spec.PoolSelector != nil && oldIPClaim.Spec.Pool.Name == ""
+	if !reflect.DeepEqual(c.Spec.PoolSelector, oldIPClaim.Spec.PoolSelector) {
+		allErrs = append(allErrs,
+			field.Invalid(
+				field.NewPath("spec", "poolSelector"),
+				c.Spec.PoolSelector,
+				"cannot be modified",
+			),
+		)
+	} else if selected {
+		if c.Spec.Pool.Kind != oldIPClaim.Spec.Pool.Kind {
+			allErrs = append(allErrs,
+				field.Invalid(
+					field.NewPath("spec", "pool", "kind"),
+					c.Spec.Pool.Kind,
+					"cannot be modified",
+				),
+			)
+		}
+	} else if c.Spec.Pool.Name != oldIPClaim.Spec.Pool.Name {
 		allErrs = append(allErrs,
 			field.Invalid(
 				field.NewPath("spec", "pool"),
@@ -90,6 +531,85 @@ func (c *IPClaim) ValidateUpdate(old runtime.Object) error {
 		)
 	}
 
+	if !reflect.DeepEqual(c.Spec.Subnet, oldIPClaim.Spec.Subnet) {
+		allErrs = append(allErrs,
+			field.Invalid(
+				field.NewPath("spec", "subnet"),
+				c.Spec.Subnet,
+				"cannot be modified",
+			),
+		)
+	}
+
+	if !reflect.DeepEqual(c.Spec.Roles, oldIPClaim.Spec.Roles) {
+		allErrs = append(allErrs,
+			field.Invalid(
+				field.NewPath("spec", "roles"),
+				c.Spec.Roles,
+				"cannot be modified",
+			),
+		)
+	}
+
+	if c.Spec.HAAddressSet != oldIPClaim.Spec.HAAddressSet {
+		allErrs = append(allErrs,
+			field.Invalid(
+				field.NewPath("spec", "haAddressSet"),
+				c.Spec.HAAddressSet,
+				"cannot be modified",
+			),
+		)
+	}
+
+	if !reflect.DeepEqual(c.Spec.PreferredAddress, oldIPClaim.Spec.PreferredAddress) {
+		allErrs = append(allErrs,
+			field.Invalid(
+				field.NewPath("spec", "preferredAddress"),
+				c.Spec.PreferredAddress,
+				"cannot be modified",
+			),
+		)
+	}
+
+	if !reflect.DeepEqual(c.Spec.AddressCount, oldIPClaim.Spec.AddressCount) {
+		allErrs = append(allErrs,
+			field.Invalid(
+				field.NewPath("spec", "addressCount"),
+				c.Spec.AddressCount,
+				"cannot be modified",
+			),
+		)
+	}
+
+	if c.Spec.AffinityGroup != oldIPClaim.Spec.AffinityGroup {
+		allErrs = append(allErrs,
+			field.Invalid(
+				field.NewPath("spec", "affinityGroup"),
+				c.Spec.AffinityGroup,
+				"cannot be modified",
+			),
+		)
+	}
+
+	if !reflect.DeepEqual(c.Spec.Prefix, oldIPClaim.Spec.Prefix) {
+		allErrs = append(allErrs,
+			field.Invalid(
+				field.NewPath("spec", "prefix"),
+				c.Spec.Prefix,
+				"cannot be modified",
+			),
+		)
+	} else {
+		allErrs = append(allErrs, c.validatePrefix()...)
+	}
+
+	// The deadline can be modified, for example extended, until it expires
+	allErrs = append(allErrs, validateNonNegativeDuration(
+		field.NewPath("spec", "bindingDeadline"), c.Spec.BindingDeadline,
+	)...)
+	allErrs = append(allErrs, c.validateLease()...)
+	allErrs = append(allErrs, c.validateMACAddress()...)
+
 	if len(allErrs) == 0 {
 		return nil
 	}
@@ -15,10 +15,15 @@ package v1alpha1
 
 import (
 	"testing"
+	"time"
 
 	. "github.com/onsi/gomega"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
 
 func TestIPClaimDefault(t *testing.T) {
@@ -35,35 +40,1005 @@ func TestIPClaimDefault(t *testing.T) {
 	g.Expect(c.Status).To(Equal(IPClaimStatus{}))
 }
 
+func TestIPClaimDefaultValues(t *testing.T) {
+	tests := []struct {
+		name         string
+		spec         IPClaimSpec
+		expectedSpec IPClaimSpec
+	}{
+		{
+			name: "should default the pool namespace to the claim namespace",
+			spec: IPClaimSpec{
+				Pool: corev1.ObjectReference{Name: "abc"},
+			},
+			expectedSpec: IPClaimSpec{
+				Pool: corev1.ObjectReference{Name: "abc", Namespace: "foo"},
+			},
+		},
+		{
+			name: "should not override the pool namespace",
+			spec: IPClaimSpec{
+				Pool: corev1.ObjectReference{Name: "abc", Namespace: "bar"},
+			},
+			expectedSpec: IPClaimSpec{
+				Pool: corev1.ObjectReference{Name: "abc", Namespace: "bar"},
+			},
+		},
+		{
+			name: "should not set a namespace on a ClusterIPPool reference",
+			spec: IPClaimSpec{
+				Pool: corev1.ObjectReference{Name: "abc", Kind: ClusterIPPoolKind},
+			},
+			expectedSpec: IPClaimSpec{
+				Pool: corev1.ObjectReference{Name: "abc", Kind: ClusterIPPoolKind},
+			},
+		},
+		{
+			name: "should not set a namespace with a pool selector",
+			spec: IPClaimSpec{
+				PoolSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"role": "provisioning"},
+				},
+			},
+			expectedSpec: IPClaimSpec{
+				PoolSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"role": "provisioning"},
+				},
+			},
+		},
+		{
+			name: "should normalize the subnet and the preferred address",
+			spec: IPClaimSpec{
+				Pool:             corev1.ObjectReference{Name: "abc", Namespace: "foo"},
+				Subnet:           (*IPSubnetStr)(pointer.StringPtr("2001:DB8:0::0001/64")),
+				PreferredAddress: &PreferredAddress{Address: "2001:DB8::0005"},
+			},
+			expectedSpec: IPClaimSpec{
+				Pool:             corev1.ObjectReference{Name: "abc", Namespace: "foo"},
+				Subnet:           (*IPSubnetStr)(pointer.StringPtr("2001:db8::1/64")),
+				PreferredAddress: &PreferredAddress{Address: "2001:db8::5"},
+			},
+		},
+		{
+			name: "should not normalize invalid or IPv4-mapped addresses",
+			spec: IPClaimSpec{
+				Pool:             corev1.ObjectReference{Name: "abc", Namespace: "foo"},
+				Subnet:           (*IPSubnetStr)(pointer.StringPtr("2001:DB8::1")),
+				PreferredAddress: &PreferredAddress{Address: "::FFFF:192.168.0.1"},
+			},
+			expectedSpec: IPClaimSpec{
+				Pool:             corev1.ObjectReference{Name: "abc", Namespace: "foo"},
+				Subnet:           (*IPSubnetStr)(pointer.StringPtr("2001:DB8::1")),
+				PreferredAddress: &PreferredAddress{Address: "::FFFF:192.168.0.1"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			c := &IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "abc-1",
+					Namespace: "foo",
+				},
+				Spec: tt.spec,
+			}
+			c.Default()
+
+			g.Expect(c.Spec).To(Equal(tt.expectedSpec))
+		})
+	}
+}
+
 func TestIPClaimCreateValidation(t *testing.T) {
 
 	tests := []struct {
-		name      string
-		claimName string
-		expectErr bool
-		ipPool    corev1.ObjectReference
+		name      string
+		claimName string
+		expectErr bool
+		ipPool    corev1.ObjectReference
+	}{
+		{
+			name:      "should succeed when ipPool is correct",
+			expectErr: false,
+			claimName: "abc-1",
+			ipPool: corev1.ObjectReference{
+				Name: "abc",
+			},
+		},
+		{
+			name:      "should fail without ipPool",
+			expectErr: true,
+			claimName: "abc-1",
+			ipPool:    corev1.ObjectReference{},
+		},
+		{
+			name:      "should fail without ipPool name",
+			expectErr: true,
+			claimName: "abc-1",
+			ipPool: corev1.ObjectReference{
+				Namespace: "abc",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			obj := &IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+					Name:      tt.claimName,
+				},
+				Spec: IPClaimSpec{
+					Pool: tt.ipPool,
+				},
+			}
+
+			if tt.expectErr {
+				g.Expect(obj.ValidateCreate()).NotTo(Succeed())
+			} else {
+				g.Expect(obj.ValidateCreate()).To(Succeed())
+			}
+
+			g.Expect(obj.ValidateDelete()).To(Succeed())
+		})
+	}
+}
+
+func TestIPClaimCreateValidationCrossNamespace(t *testing.T) {
+	s := runtime.NewScheme()
+	if err := AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+	pools := []client.Object{
+		&IPPool{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "denied",
+				Namespace: "bar",
+			},
+		},
+		&IPPool{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "allowed",
+				Namespace: "bar",
+			},
+			Spec: IPPoolSpec{
+				AllowedNamespaces: []string{"baz", "foo"},
+			},
+		},
+		&IPPool{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "all",
+				Namespace: "bar",
+			},
+			Spec: IPPoolSpec{
+				AllowedNamespaces: []string{AllNamespaces},
+			},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		expectErr bool
+		noReader  bool
+		ipPool    corev1.ObjectReference
+	}{
+		{
+			name:      "should succeed when ipPool is in the same namespace",
+			expectErr: false,
+			noReader:  true,
+			ipPool: corev1.ObjectReference{
+				Name:      "abc",
+				Namespace: "foo",
+			},
+		},
+		{
+			name:      "should fail when the pool can not be fetched",
+			expectErr: true,
+			noReader:  true,
+			ipPool: corev1.ObjectReference{
+				Name:      "allowed",
+				Namespace: "bar",
+			},
+		},
+		{
+			name:      "should fail when ipPool does not exist",
+			expectErr: true,
+			ipPool: corev1.ObjectReference{
+				Name:      "abc",
+				Namespace: "bar",
+			},
+		},
+		{
+			name:      "should fail when ipPool does not allow the namespace",
+			expectErr: true,
+			ipPool: corev1.ObjectReference{
+				Name:      "denied",
+				Namespace: "bar",
+			},
+		},
+		{
+			name:      "should succeed when ipPool allows the namespace",
+			expectErr: false,
+			ipPool: corev1.ObjectReference{
+				Name:      "allowed",
+				Namespace: "bar",
+			},
+		},
+		{
+			name:      "should succeed when ipPool allows all namespaces",
+			expectErr: false,
+			ipPool: corev1.ObjectReference{
+				Name:      "all",
+				Namespace: "bar",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			if tt.noReader {
+				ipClaimWebhookReader = nil
+			} else {
+				ipClaimWebhookReader = fake.NewClientBuilder().WithScheme(s).WithObjects(pools...).Build()
+			}
+			defer func() { ipClaimWebhookReader = nil }()
+
+			obj := &IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+					Name:      "abc-1",
+				},
+				Spec: IPClaimSpec{
+					Pool: tt.ipPool,
+				},
+			}
+
+			if tt.expectErr {
+				g.Expect(obj.ValidateCreate()).NotTo(Succeed())
+			} else {
+				g.Expect(obj.ValidateCreate()).To(Succeed())
+			}
+		})
+	}
+}
+
+func TestIPClaimCreateValidationClusterIPPool(t *testing.T) {
+	s := runtime.NewScheme()
+	if err := AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+	pools := []client.Object{
+		&ClusterIPPool{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "all",
+			},
+		},
+		&ClusterIPPool{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "restricted",
+			},
+			Spec: IPPoolSpec{
+				AllowedNamespaces: []string{"bar"},
+			},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		expectErr bool
+		noReader  bool
+		ipPool    corev1.ObjectReference
+	}{
+		{
+			name:      "should succeed when the ClusterIPPool can not be fetched",
+			expectErr: false,
+			noReader:  true,
+			ipPool: corev1.ObjectReference{
+				Name: "restricted",
+				Kind: ClusterIPPoolKind,
+			},
+		},
+		{
+			name:      "should fail when the reference has a namespace",
+			expectErr: true,
+			ipPool: corev1.ObjectReference{
+				Name:      "all",
+				Namespace: "foo",
+				Kind:      ClusterIPPoolKind,
+			},
+		},
+		{
+			name:      "should succeed when the ClusterIPPool does not exist yet",
+			expectErr: false,
+			ipPool: corev1.ObjectReference{
+				Name: "abc",
+				Kind: ClusterIPPoolKind,
+			},
+		},
+		{
+			name:      "should succeed when the ClusterIPPool allows all namespaces",
+			expectErr: false,
+			ipPool: corev1.ObjectReference{
+				Name: "all",
+				Kind: ClusterIPPoolKind,
+			},
+		},
+		{
+			name:      "should fail when the ClusterIPPool does not allow the namespace",
+			expectErr: true,
+			ipPool: corev1.ObjectReference{
+				Name: "restricted",
+				Kind: ClusterIPPoolKind,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			if tt.noReader {
+				ipClaimWebhookReader = nil
+			} else {
+				ipClaimWebhookReader = fake.NewClientBuilder().WithScheme(s).WithObjects(pools...).Build()
+			}
+			defer func() { ipClaimWebhookReader = nil }()
+
+			obj := &IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+					Name:      "abc-1",
+				},
+				Spec: IPClaimSpec{
+					Pool: tt.ipPool,
+				},
+			}
+
+			if tt.expectErr {
+				g.Expect(obj.ValidateCreate()).NotTo(Succeed())
+			} else {
+				g.Expect(obj.ValidateCreate()).To(Succeed())
+			}
+		})
+	}
+}
+
+func TestIPClaimCreateValidationPoolAvailability(t *testing.T) {
+	s := runtime.NewScheme()
+	if err := AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+	deletionTimestamp := metav1.Now()
+	exhausted := IPPoolStatus{
+		Conditions: []metav1.Condition{
+			{Type: IPPoolExhaustedCondition, Status: metav1.ConditionTrue, Reason: "NoAddressLeft"},
+		},
+	}
+	pools := []client.Object{
+		&IPPool{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "available",
+				Namespace: "foo",
+				Labels:    map[string]string{"rack": "1"},
+			},
+		},
+		&IPPool{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              "deleted",
+				Namespace:         "foo",
+				DeletionTimestamp: &deletionTimestamp,
+				Finalizers:        []string{IPPoolFinalizer},
+			},
+		},
+		&IPPool{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "exhausted",
+				Namespace: "foo",
+				Labels:    map[string]string{"rack": "2"},
+			},
+			Spec: IPPoolSpec{
+				PreAllocations: map[string]IPAddressStr{
+					"pre-allocated": "192.168.0.10",
+				},
+			},
+			Status: exhausted,
+		},
+		&IPPool{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "fallback",
+				Namespace: "foo",
+			},
+			Spec: IPPoolSpec{
+				FallbackPools: []string{"available"},
+			},
+			Status: exhausted,
+		},
+	}
+
+	tests := []struct {
+		name         string
+		expectErr    bool
+		disabled     bool
+		claimName    string
+		poolName     string
+		poolSelector *metav1.LabelSelector
+	}{
+		{
+			name:      "should succeed when the pool is available",
+			expectErr: false,
+			poolName:  "available",
+		},
+		{
+			name:      "should fail when the pool does not exist",
+			expectErr: true,
+			poolName:  "abc",
+		},
+		{
+			name:      "should succeed when the pool does not exist and the validation is disabled",
+			expectErr: false,
+			disabled:  true,
+			poolName:  "abc",
+		},
+		{
+			name:      "should fail when the pool is being deleted",
+			expectErr: true,
+			poolName:  "deleted",
+		},
+		{
+			name:      "should fail when the pool is exhausted",
+			expectErr: true,
+			poolName:  "exhausted",
+		},
+		{
+			name:      "should succeed when the exhausted pool has an address pre-allocated to the claim",
+			expectErr: false,
+			claimName: "pre-allocated",
+			poolName:  "exhausted",
+		},
+		{
+			name:      "should succeed when the exhausted pool has fallback pools",
+			expectErr: false,
+			poolName:  "fallback",
+		},
+		{
+			name:         "should succeed when the selector matches an available pool",
+			expectErr:    false,
+			poolSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"rack": "1"}},
+		},
+		{
+			name:         "should fail when the selector matches no pool",
+			expectErr:    true,
+			poolSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"rack": "3"}},
+		},
+		{
+			name:         "should fail when the selector matches an exhausted pool only",
+			expectErr:    true,
+			poolSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"rack": "2"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			ValidateIPClaimPools = !tt.disabled
+			ipClaimWebhookReader = fake.NewClientBuilder().WithScheme(s).WithObjects(pools...).Build()
+			defer func() {
+				ValidateIPClaimPools = false
+				ipClaimWebhookReader = nil
+			}()
+
+			claimName := tt.claimName
+			if claimName == "" {
+				claimName = "abc-1"
+			}
+			obj := &IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+					Name:      claimName,
+				},
+				Spec: IPClaimSpec{
+					Pool: corev1.ObjectReference{
+						Name: tt.poolName,
+					},
+					PoolSelector: tt.poolSelector,
+				},
+			}
+
+			if tt.expectErr {
+				g.Expect(obj.ValidateCreate()).NotTo(Succeed())
+			} else {
+				g.Expect(obj.ValidateCreate()).To(Succeed())
+			}
+		})
+	}
+}
+
+func TestIPClaimCreateValidationSubnet(t *testing.T) {
+	s := runtime.NewScheme()
+	if err := AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+	pools := []client.Object{
+		&IPPool{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "abc",
+				Namespace: "foo",
+			},
+			Spec: IPPoolSpec{
+				Pools: []Pool{
+					{
+						Start: (*IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+						End:   (*IPAddressStr)(pointer.StringPtr("192.168.0.100")),
+					},
+					{
+						Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.2.0/24")),
+					},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		expectErr bool
+		noReader  bool
+		poolName  string
+		subnet    string
+	}{
+		{
+			name:      "should fail when the subnet is invalid",
+			expectErr: true,
+			noReader:  true,
+			poolName:  "abc",
+			subnet:    "192.168.0.0",
+		},
+		{
+			name:      "should fail when the subnet is IPv4-mapped",
+			expectErr: true,
+			noReader:  true,
+			poolName:  "abc",
+			subnet:    "::ffff:192.168.0.0/120",
+		},
+		{
+			name:      "should succeed without reader",
+			expectErr: false,
+			noReader:  true,
+			poolName:  "abc",
+			subnet:    "10.0.0.0/24",
+		},
+		{
+			name:      "should succeed when the pool does not exist",
+			expectErr: false,
+			poolName:  "abcd",
+			subnet:    "10.0.0.0/24",
+		},
+		{
+			name:      "should succeed when the subnet overlaps with a range",
+			expectErr: false,
+			poolName:  "abc",
+			subnet:    "192.168.0.64/26",
+		},
+		{
+			name:      "should succeed when the subnet overlaps with a subnet",
+			expectErr: false,
+			poolName:  "abc",
+			subnet:    "192.168.2.128/25",
+		},
+		{
+			name:      "should fail when the subnet does not overlap",
+			expectErr: true,
+			poolName:  "abc",
+			subnet:    "192.168.1.0/24",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			if tt.noReader {
+				ipClaimWebhookReader = nil
+			} else {
+				ipClaimWebhookReader = fake.NewClientBuilder().WithScheme(s).WithObjects(pools...).Build()
+			}
+			defer func() { ipClaimWebhookReader = nil }()
+
+			obj := &IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+					Name:      "abc-1",
+				},
+				Spec: IPClaimSpec{
+					Pool: corev1.ObjectReference{
+						Name: tt.poolName,
+					},
+					Subnet: (*IPSubnetStr)(pointer.StringPtr(tt.subnet)),
+				},
+			}
+
+			if tt.expectErr {
+				g.Expect(obj.ValidateCreate()).NotTo(Succeed())
+			} else {
+				g.Expect(obj.ValidateCreate()).To(Succeed())
+			}
+		})
+	}
+}
+
+func TestIPClaimCreateValidationPrefix(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		expectErr   bool
+		prefix      *int
+		annotations map[string]string
+		subnet      *IPSubnetStr
+	}{
+		{
+			name:      "should succeed without override",
+			expectErr: false,
+		},
+		{
+			name:      "should succeed with an IPv6 prefix",
+			expectErr: false,
+			prefix:    pointer.IntPtr(64),
+		},
+		{
+			name:      "should fail with a negative prefix",
+			expectErr: true,
+			prefix:    pointer.IntPtr(-1),
+		},
+		{
+			name:      "should fail with a prefix above 128",
+			expectErr: true,
+			prefix:    pointer.IntPtr(129),
+		},
+		{
+			name:      "should fail with an IPv6 prefix on an IPv4 subnet",
+			expectErr: true,
+			prefix:    pointer.IntPtr(64),
+			subnet:    (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24")),
+		},
+		{
+			name:        "should succeed with a valid annotation",
+			expectErr:   false,
+			annotations: map[string]string{PrefixAnnotation: "24"},
+		},
+		{
+			name:        "should fail with an invalid annotation",
+			expectErr:   true,
+			annotations: map[string]string{PrefixAnnotation: "abc"},
+		},
+		{
+			name:        "should fail with an annotation above 128",
+			expectErr:   true,
+			annotations: map[string]string{PrefixAnnotation: "200"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			obj := &IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace:   "foo",
+					Name:        "abc-1",
+					Annotations: tt.annotations,
+				},
+				Spec: IPClaimSpec{
+					Pool: corev1.ObjectReference{
+						Name: "abc",
+					},
+					Prefix: tt.prefix,
+					Subnet: tt.subnet,
+				},
+			}
+
+			if tt.expectErr {
+				g.Expect(obj.ValidateCreate()).NotTo(Succeed())
+			} else {
+				g.Expect(obj.ValidateCreate()).To(Succeed())
+			}
+		})
+	}
+}
+
+func TestIPClaimCreateValidationPreferredAddress(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		expectErr bool
+		address   string
+		subnet    *IPSubnetStr
+	}{
+		{
+			name:      "should succeed with an IPv4 address",
+			expectErr: false,
+			address:   "192.168.0.10",
+		},
+		{
+			name:      "should succeed with an IPv6 address in the subnet",
+			expectErr: false,
+			address:   "2001:db8::10",
+			subnet:    (*IPSubnetStr)(pointer.StringPtr("2001:db8::/64")),
+		},
+		{
+			name:      "should fail with an invalid address",
+			expectErr: true,
+			address:   "192.168.0.300",
+		},
+		{
+			name:      "should fail with an IPv4-mapped IPv6 address",
+			expectErr: true,
+			address:   "::ffff:192.168.0.10",
+		},
+		{
+			name:      "should fail with an address out of the subnet",
+			expectErr: true,
+			address:   "192.168.1.10",
+			subnet:    (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24")),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			obj := &IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+					Name:      "abc-1",
+				},
+				Spec: IPClaimSpec{
+					Pool: corev1.ObjectReference{
+						Name: "abc",
+					},
+					Subnet: tt.subnet,
+					PreferredAddress: &PreferredAddress{
+						Address: IPAddressStr(tt.address),
+						Strict:  true,
+					},
+				},
+			}
+
+			if tt.expectErr {
+				g.Expect(obj.ValidateCreate()).NotTo(Succeed())
+			} else {
+				g.Expect(obj.ValidateCreate()).To(Succeed())
+			}
+		})
+	}
+}
+
+func TestIPClaimCreateValidationRoles(t *testing.T) {
+
+	tests := []struct {
+		name         string
+		expectErr    bool
+		haAddressSet bool
+		addressCount *int
+		roles        []string
+	}{
+		{
+			name:      "should succeed with roles",
+			expectErr: false,
+			roles:     []string{"secondary", "bmc"},
+		},
+		{
+			name:      "should fail with an empty role",
+			expectErr: true,
+			roles:     []string{""},
+		},
+		{
+			name:      "should fail with an invalid role",
+			expectErr: true,
+			roles:     []string{"bmc/1"},
+		},
+		{
+			name:      "should fail with duplicated roles",
+			expectErr: true,
+			roles:     []string{"bmc", "bmc"},
+		},
+		{
+			name:         "should fail with an HA role in an HA address set",
+			expectErr:    true,
+			haAddressSet: true,
+			roles:        []string{HAVIPRole},
+		},
+		{
+			name:      "should succeed with an HA role without HA address set",
+			expectErr: false,
+			roles:     []string{HAVIPRole},
+		},
+		{
+			name:         "should succeed with an address count and roles",
+			expectErr:    false,
+			addressCount: pointer.IntPtr(3),
+			roles:        []string{"bmc", AddressCountRolePrefix + "3"},
+		},
+		{
+			name:         "should fail with a role of the address count",
+			expectErr:    true,
+			addressCount: pointer.IntPtr(3),
+			roles:        []string{AddressCountRolePrefix + "2"},
+		},
+		{
+			name:         "should fail with a zero address count",
+			expectErr:    true,
+			addressCount: pointer.IntPtr(0),
+		},
+		{
+			name:         "should fail with an address count above the maximum",
+			expectErr:    true,
+			addressCount: pointer.IntPtr(MaxAddressCount + 1),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			obj := &IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+					Name:      "abc-1",
+				},
+				Spec: IPClaimSpec{
+					Pool: corev1.ObjectReference{
+						Name: "abc",
+					},
+					HAAddressSet: tt.haAddressSet,
+					AddressCount: tt.addressCount,
+					Roles:        tt.roles,
+				},
+			}
+
+			if tt.expectErr {
+				g.Expect(obj.ValidateCreate()).NotTo(Succeed())
+			} else {
+				g.Expect(obj.ValidateCreate()).To(Succeed())
+			}
+		})
+	}
+}
+
+func TestIPClaimValidationBindingDeadline(t *testing.T) {
+	tests := []struct {
+		name            string
+		bindingDeadline *metav1.Duration
+		expectErr       bool
+	}{
+		{
+			name: "should succeed without deadline",
+		},
+		{
+			name:            "should succeed with a deadline",
+			bindingDeadline: &metav1.Duration{Duration: time.Hour},
+		},
+		{
+			name:            "should succeed with a zero deadline",
+			bindingDeadline: &metav1.Duration{},
+		},
+		{
+			name:            "should fail with a negative deadline",
+			bindingDeadline: &metav1.Duration{Duration: -time.Hour},
+			expectErr:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			obj := &IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+					Name:      "abc-1",
+				},
+				Spec: IPClaimSpec{
+					Pool:            corev1.ObjectReference{Name: "abc"},
+					BindingDeadline: tt.bindingDeadline,
+				},
+			}
+			oldObj := obj.DeepCopy()
+			oldObj.Spec.BindingDeadline = &metav1.Duration{Duration: time.Minute}
+
+			if tt.expectErr {
+				g.Expect(obj.ValidateCreate()).NotTo(Succeed())
+				g.Expect(obj.ValidateUpdate(oldObj)).NotTo(Succeed())
+			} else {
+				g.Expect(obj.ValidateCreate()).To(Succeed())
+				g.Expect(obj.ValidateUpdate(oldObj)).To(Succeed())
+			}
+		})
+	}
+}
+
+func TestIPClaimValidationLease(t *testing.T) {
+	tests := []struct {
+		name          string
+		leaseDuration *metav1.Duration
+		renewal       string
+		expectErr     bool
+	}{
+		{
+			name: "should succeed without lease",
+		},
+		{
+			name:          "should succeed with a renewed lease",
+			leaseDuration: &metav1.Duration{Duration: time.Hour},
+			renewal:       "2021-09-01T10:00:00Z",
+		},
+		{
+			name:          "should fail with a negative lease duration",
+			leaseDuration: &metav1.Duration{Duration: -time.Hour},
+			expectErr:     true,
+		},
+		{
+			name:      "should fail with an invalid renewal",
+			renewal:   "yesterday",
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			obj := &IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+					Name:      "abc-1",
+				},
+				Spec: IPClaimSpec{
+					Pool:          corev1.ObjectReference{Name: "abc"},
+					LeaseDuration: tt.leaseDuration,
+				},
+			}
+			if tt.renewal != "" {
+				obj.Annotations = map[string]string{LeaseRenewedAnnotation: tt.renewal}
+			}
+			oldObj := obj.DeepCopy()
+			oldObj.Annotations = nil
+
+			if tt.expectErr {
+				g.Expect(obj.ValidateCreate()).NotTo(Succeed())
+				g.Expect(obj.ValidateUpdate(oldObj)).NotTo(Succeed())
+			} else {
+				g.Expect(obj.ValidateCreate()).To(Succeed())
+				g.Expect(obj.ValidateUpdate(oldObj)).To(Succeed())
+			}
+		})
+	}
+}
+
+func TestIPClaimValidationMACAddress(t *testing.T) {
+	tests := []struct {
+		name       string
+		macAddress string
+		expectErr  bool
 	}{
 		{
-			name:      "should succeed when ipPool is correct",
-			expectErr: false,
-			claimName: "abc-1",
-			ipPool: corev1.ObjectReference{
-				Name: "abc",
-			},
+			name:       "should succeed with a MAC address",
+			macAddress: "52:54:00:aa:bb:01",
 		},
 		{
-			name:      "should fail without ipPool",
-			expectErr: true,
-			claimName: "abc-1",
-			ipPool:    corev1.ObjectReference{},
+			name:       "should succeed with an uppercase dash-separated MAC address",
+			macAddress: "52-54-00-AA-BB-01",
 		},
 		{
-			name:      "should fail without ipPool name",
-			expectErr: true,
-			claimName: "abc-1",
-			ipPool: corev1.ObjectReference{
-				Namespace: "abc",
-			},
+			name:       "should fail with an invalid MAC address",
+			macAddress: "52:54:00:aa:bb",
+			expectErr:  true,
 		},
 	}
 
@@ -73,21 +1048,24 @@ func TestIPClaimCreateValidation(t *testing.T) {
 
 			obj := &IPClaim{
 				ObjectMeta: metav1.ObjectMeta{
-					Namespace: "foo",
-					Name:      tt.claimName,
+					Namespace:   "foo",
+					Name:        "abc-1",
+					Annotations: map[string]string{MACAddressAnnotation: tt.macAddress},
 				},
 				Spec: IPClaimSpec{
-					Pool: tt.ipPool,
+					Pool: corev1.ObjectReference{Name: "abc"},
 				},
 			}
+			oldObj := obj.DeepCopy()
+			oldObj.Annotations = nil
 
 			if tt.expectErr {
 				g.Expect(obj.ValidateCreate()).NotTo(Succeed())
+				g.Expect(obj.ValidateUpdate(oldObj)).NotTo(Succeed())
 			} else {
 				g.Expect(obj.ValidateCreate()).To(Succeed())
+				g.Expect(obj.ValidateUpdate(oldObj)).To(Succeed())
 			}
-
-			g.Expect(obj.ValidateDelete()).To(Succeed())
 		})
 	}
 }
@@ -166,6 +1144,113 @@ func TestIPClaimUpdateValidation(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:      "should fail when subnet changes",
+			expectErr: true,
+			new: &IPClaimSpec{
+				Pool: corev1.ObjectReference{
+					Name: "abc",
+				},
+				Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24")),
+			},
+			old: &IPClaimSpec{
+				Pool: corev1.ObjectReference{
+					Name: "abc",
+				},
+			},
+		},
+		{
+			name:      "should fail when prefix changes",
+			expectErr: true,
+			new: &IPClaimSpec{
+				Pool: corev1.ObjectReference{
+					Name: "abc",
+				},
+				Prefix: pointer.IntPtr(24),
+			},
+			old: &IPClaimSpec{
+				Pool: corev1.ObjectReference{
+					Name: "abc",
+				},
+			},
+		},
+		{
+			name:      "should fail when affinity group changes",
+			expectErr: true,
+			new: &IPClaimSpec{
+				Pool: corev1.ObjectReference{
+					Name: "abc",
+				},
+				AffinityGroup: "abc",
+			},
+			old: &IPClaimSpec{
+				Pool: corev1.ObjectReference{
+					Name: "abc",
+				},
+				AffinityGroup: "abcd",
+			},
+		},
+		{
+			name:      "should fail when HA address set changes",
+			expectErr: true,
+			new: &IPClaimSpec{
+				Pool: corev1.ObjectReference{
+					Name: "abc",
+				},
+				HAAddressSet: true,
+			},
+			old: &IPClaimSpec{
+				Pool: corev1.ObjectReference{
+					Name: "abc",
+				},
+			},
+		},
+		{
+			name:      "should fail when preferred address changes",
+			expectErr: true,
+			new: &IPClaimSpec{
+				Pool: corev1.ObjectReference{
+					Name: "abc",
+				},
+				PreferredAddress: &PreferredAddress{Address: "192.168.0.11"},
+			},
+			old: &IPClaimSpec{
+				Pool: corev1.ObjectReference{
+					Name: "abc",
+				},
+				PreferredAddress: &PreferredAddress{Address: "192.168.0.10"},
+			},
+		},
+		{
+			name:      "should fail when address count changes",
+			expectErr: true,
+			new: &IPClaimSpec{
+				Pool: corev1.ObjectReference{
+					Name: "abc",
+				},
+				AddressCount: pointer.IntPtr(2),
+			},
+			old: &IPClaimSpec{
+				Pool: corev1.ObjectReference{
+					Name: "abc",
+				},
+			},
+		},
+		{
+			name:      "should fail when roles change",
+			expectErr: true,
+			new: &IPClaimSpec{
+				Pool: corev1.ObjectReference{
+					Name: "abc",
+				},
+				Roles: []string{"bmc"},
+			},
+			old: &IPClaimSpec{
+				Pool: corev1.ObjectReference{
+					Name: "abc",
+				},
+			},
+		},
 		{
 			name:      "should fail when Pool kind changes",
 			expectErr: true,
@@ -182,6 +1267,111 @@ func TestIPClaimUpdateValidation(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:      "should succeed when the selected pool is recorded",
+			expectErr: false,
+			new: &IPClaimSpec{
+				Pool: corev1.ObjectReference{
+					Name:      "abc",
+					Namespace: "foo",
+				},
+				PoolSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"role": "provisioning"},
+				},
+			},
+			old: &IPClaimSpec{
+				PoolSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"role": "provisioning"},
+				},
+			},
+		},
+		{
+			name:      "should fail when the selected pool is modified",
+			expectErr: true,
+			new: &IPClaimSpec{
+				Pool: corev1.ObjectReference{
+					Name: "bcd",
+				},
+				PoolSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"role": "provisioning"},
+				},
+			},
+			old: &IPClaimSpec{
+				Pool: corev1.ObjectReference{
+					Name: "abc",
+				},
+				PoolSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"role": "provisioning"},
+				},
+			},
+		},
+		{
+			name:      "should fail when the kind of the selected pool changes",
+			expectErr: true,
+			new: &IPClaimSpec{
+				Pool: corev1.ObjectReference{
+					Name: "abc",
+					Kind: ClusterIPPoolKind,
+				},
+				PoolSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"role": "provisioning"},
+				},
+			},
+			old: &IPClaimSpec{
+				PoolSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"role": "provisioning"},
+				},
+			},
+		},
+		{
+			name:      "should fail when the pool selector changes",
+			expectErr: true,
+			new: &IPClaimSpec{
+				PoolSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"role": "external"},
+				},
+			},
+			old: &IPClaimSpec{
+				PoolSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"role": "provisioning"},
+				},
+			},
+		},
+		{
+			name:      "should succeed when the pool namespace is defaulted",
+			expectErr: false,
+			new: &IPClaimSpec{
+				Pool: corev1.ObjectReference{
+					Name:      "abc",
+					Namespace: "foo",
+				},
+			},
+			old: &IPClaimSpec{
+				Pool: corev1.ObjectReference{
+					Name: "abc",
+				},
+			},
+		},
+		{
+			name:      "should succeed when the addresses are normalized",
+			expectErr: false,
+			new: &IPClaimSpec{
+				Pool: corev1.ObjectReference{
+					Name:      "abc",
+					Namespace: "foo",
+				},
+				Subnet:           (*IPSubnetStr)(pointer.StringPtr("2001:db8::1/64")),
+				PreferredAddress: &PreferredAddress{Address: "2001:db8::5"},
+			},
+			old: &IPClaimSpec{
+				Pool: corev1.ObjectReference{
+					Name:      "abc",
+					Namespace: "foo",
+				},
+				Subnet:           (*IPSubnetStr)(pointer.StringPtr("2001:DB8:0::0001/64")),
+				PreferredAddress: &PreferredAddress{Address: "2001:DB8::0005"},
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -216,3 +1406,70 @@ func TestIPClaimUpdateValidation(t *testing.T) {
 		})
 	}
 }
+
+func TestIPClaimCreateValidationPoolSelector(t *testing.T) {
+	selector := &metav1.LabelSelector{
+		MatchLabels: map[string]string{"role": "provisioning"},
+	}
+	tests := []struct {
+		name      string
+		pool      corev1.ObjectReference
+		selector  *metav1.LabelSelector
+		expectErr bool
+	}{
+		{
+			name:     "should succeed with a pool selector",
+			selector: selector,
+		},
+		{
+			name:     "should succeed with a ClusterIPPool selector",
+			pool:     corev1.ObjectReference{Kind: ClusterIPPoolKind},
+			selector: selector,
+		},
+		{
+			name:      "should fail with a pool name and a pool selector",
+			pool:      corev1.ObjectReference{Name: "abc"},
+			selector:  selector,
+			expectErr: true,
+		},
+		{
+			name:      "should fail with a pool namespace and a pool selector",
+			pool:      corev1.ObjectReference{Namespace: "bar"},
+			selector:  selector,
+			expectErr: true,
+		},
+		{
+			name: "should fail with an invalid pool selector",
+			selector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{{
+					Key:      "role",
+					Operator: "Unknown",
+				}},
+			},
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			obj := &IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+					Name:      "abc-1",
+				},
+				Spec: IPClaimSpec{
+					Pool:         tt.pool,
+					PoolSelector: tt.selector,
+				},
+			}
+
+			if tt.expectErr {
+				g.Expect(obj.ValidateCreate()).NotTo(Succeed())
+			} else {
+				g.Expect(obj.ValidateCreate()).To(Succeed())
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// IPClaimSetLabel is the label containing the name of the IPClaimSet that
+	// created the IPClaim
+	IPClaimSetLabel = "ipam.metal3.io/ipclaimset-name"
+
+	// ClaimPoolAnnotation is the annotation opting a MachineDeployment in for
+	// the creation of an IPClaimSet. Its value is the name of the IPPool.
+	ClaimPoolAnnotation = "ipam.metal3.io/claim-pool"
+)
+
+// IPClaimTemplate describes the IPClaims created by an IPClaimSet.
+type IPClaimTemplate struct {
+
+	// Labels are the labels of the IPClaims.
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Annotations are the annotations of the IPClaims.
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// Spec is the spec of the IPClaims.
+	Spec IPClaimSpec `json:"spec"`
+}
+
+// IPClaimSetSpec defines the desired state of IPClaimSet.
+type IPClaimSetSpec struct {
+
+	// +kubebuilder:validation:Minimum=0
+	// Replicas is the number of IPClaims to maintain.
+	Replicas int32 `json:"replicas"`
+
+	// Template is the template of the IPClaims.
+	Template IPClaimTemplate `json:"template"`
+}
+
+// IPClaimSetStatus defines the observed state of IPClaimSet.
+type IPClaimSetStatus struct {
+	// LastUpdated identifies when this status was last observed.
+	// +optional
+	LastUpdated *metav1.Time `json:"lastUpdated,omitempty"`
+
+	// Replicas is the number of IPClaims of the set.
+	Replicas int32 `json:"replicas,omitempty"`
+
+	// ReadyReplicas is the number of IPClaims of the set with an allocated
+	// address.
+	ReadyReplicas int32 `json:"readyReplicas,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:resource:path=ipclaimsets,scope=Namespaced,categories=cluster-api,shortName=ipcs;ipclaimset;m3ipcs;m3ipclaimset;m3ipclaimsets;metal3ipcs;metal3ipclaimset;metal3ipclaimsets
+// +kubebuilder:storageversion
+// +kubebuilder:subresource:status
+// +kubebuilder:object:root=true
+// +kubebuilder:printcolumn:name="Replicas",type="integer",JSONPath=".spec.replicas",description="Number of IPClaims to maintain"
+// +kubebuilder:printcolumn:name="Ready",type="integer",JSONPath=".status.readyReplicas",description="Number of IPClaims with an allocated address"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp",description="Time duration since creation of Metal3IPClaimSet"
+// IPClaimSet is the Schema for the ipclaimsets API
+type IPClaimSet struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   IPClaimSetSpec   `json:"spec,omitempty"`
+	Status IPClaimSetStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// IPClaimSetList contains a list of IPClaimSet
+type IPClaimSetList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []IPClaimSet `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&IPClaimSet{}, &IPClaimSetList{})
+}
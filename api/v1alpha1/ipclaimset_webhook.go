@@ -0,0 +1,108 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+func (c *IPClaimSet) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(c).
+		Complete()
+}
+
+// +kubebuilder:webhook:verbs=create;update,path=/validate-ipam-metal3-io-v1alpha4-ipclaimset,mutating=false,failurePolicy=fail,groups=ipam.metal3.io,resources=ipclaimsets,versions=v1alpha4,name=validation.ipclaimset.ipam.metal3.io,matchPolicy=Equivalent,sideEffects=None,admissionReviewVersions=v1;v1beta1
+// +kubebuilder:webhook:verbs=create;update,path=/mutate-ipam-metal3-io-v1alpha4-ipclaimset,mutating=true,failurePolicy=fail,groups=ipam.metal3.io,resources=ipclaimsets,versions=v1alpha4,name=default.ipclaimset.ipam.metal3.io,matchPolicy=Equivalent,sideEffects=None,admissionReviewVersions=v1;v1beta1
+
+var _ webhook.Defaulter = &IPClaimSet{}
+var _ webhook.Validator = &IPClaimSet{}
+
+func (c *IPClaimSet) Default() {
+}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (c *IPClaimSet) ValidateCreate() error {
+	allErrs := c.validateTemplate()
+
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("IPClaimSet").GroupKind(), c.Name, allErrs)
+}
+
+// validateTemplate verifies that the claims rendered from the template are
+// valid
+func (c *IPClaimSet) validateTemplate() field.ErrorList {
+	allErrs := field.ErrorList{}
+	if c.Spec.Replicas < 0 {
+		allErrs = append(allErrs,
+			field.Invalid(
+				field.NewPath("spec", "replicas"),
+				c.Spec.Replicas,
+				"cannot be negative",
+			),
+		)
+	}
+
+	claim := &IPClaim{
+		ObjectMeta: c.claimObjectMeta(),
+		Spec:       c.Spec.Template.Spec,
+	}
+	if err := claim.ValidateCreate(); err != nil {
+		allErrs = append(allErrs,
+			field.Invalid(
+				field.NewPath("spec", "template"),
+				c.Spec.Template,
+				err.Error(),
+			),
+		)
+	}
+	return allErrs
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (c *IPClaimSet) ValidateUpdate(old runtime.Object) error {
+	oldIPClaimSet, ok := old.(*IPClaimSet)
+	if !ok || oldIPClaimSet == nil {
+		return apierrors.NewInternalError(errors.New("unable to convert existing object"))
+	}
+
+	allErrs := c.validateTemplate()
+
+	if c.Spec.Template.Spec.Pool != oldIPClaimSet.Spec.Template.Spec.Pool {
+		allErrs = append(allErrs,
+			field.Invalid(
+				field.NewPath("spec", "template", "spec", "pool"),
+				c.Spec.Template.Spec.Pool,
+				"cannot be modified",
+			),
+		)
+	}
+
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("IPClaimSet").GroupKind(), c.Name, allErrs)
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (c *IPClaimSet) ValidateDelete() error {
+	return nil
+}
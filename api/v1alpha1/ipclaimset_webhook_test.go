@@ -0,0 +1,214 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestIPClaimSetDefault(t *testing.T) {
+	g := NewWithT(t)
+
+	c := &IPClaimSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "foo",
+		},
+	}
+	c.Default()
+
+	g.Expect(c.Spec).To(Equal(IPClaimSetSpec{}))
+	g.Expect(c.Status).To(Equal(IPClaimSetStatus{}))
+}
+
+func TestIPClaimSetCreateValidation(t *testing.T) {
+	tests := []struct {
+		name      string
+		expectErr bool
+		replicas  int32
+		template  IPClaimTemplate
+	}{
+		{
+			name:      "should succeed with a valid template",
+			expectErr: false,
+			replicas:  3,
+			template: IPClaimTemplate{
+				Spec: IPClaimSpec{
+					Pool: corev1.ObjectReference{
+						Name: "abc",
+					},
+				},
+			},
+		},
+		{
+			name:      "should fail with negative replicas",
+			expectErr: true,
+			replicas:  -1,
+			template: IPClaimTemplate{
+				Spec: IPClaimSpec{
+					Pool: corev1.ObjectReference{
+						Name: "abc",
+					},
+				},
+			},
+		},
+		{
+			name:      "should fail without pool",
+			expectErr: true,
+			replicas:  3,
+		},
+		{
+			name:      "should fail with an invalid claim spec",
+			expectErr: true,
+			replicas:  3,
+			template: IPClaimTemplate{
+				Spec: IPClaimSpec{
+					Pool: corev1.ObjectReference{
+						Name: "abc",
+					},
+					Roles: []string{""},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			obj := &IPClaimSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+					Name:      "abc",
+				},
+				Spec: IPClaimSetSpec{
+					Replicas: tt.replicas,
+					Template: tt.template,
+				},
+			}
+
+			if tt.expectErr {
+				g.Expect(obj.ValidateCreate()).NotTo(Succeed())
+			} else {
+				g.Expect(obj.ValidateCreate()).To(Succeed())
+			}
+			g.Expect(obj.ValidateDelete()).To(Succeed())
+		})
+	}
+}
+
+func TestIPClaimSetUpdateValidation(t *testing.T) {
+	tests := []struct {
+		name      string
+		expectErr bool
+		new       *IPClaimSetSpec
+		old       *IPClaimSetSpec
+	}{
+		{
+			name:      "should succeed when scaling",
+			expectErr: false,
+			new: &IPClaimSetSpec{
+				Replicas: 3,
+				Template: IPClaimTemplate{
+					Spec: IPClaimSpec{
+						Pool: corev1.ObjectReference{
+							Name: "abc",
+						},
+					},
+				},
+			},
+			old: &IPClaimSetSpec{
+				Replicas: 1,
+				Template: IPClaimTemplate{
+					Spec: IPClaimSpec{
+						Pool: corev1.ObjectReference{
+							Name: "abc",
+						},
+					},
+				},
+			},
+		},
+		{
+			name:      "should fail with nil old",
+			expectErr: true,
+			new: &IPClaimSetSpec{
+				Template: IPClaimTemplate{
+					Spec: IPClaimSpec{
+						Pool: corev1.ObjectReference{
+							Name: "abc",
+						},
+					},
+				},
+			},
+			old: nil,
+		},
+		{
+			name:      "should fail when pool changes",
+			expectErr: true,
+			new: &IPClaimSetSpec{
+				Template: IPClaimTemplate{
+					Spec: IPClaimSpec{
+						Pool: corev1.ObjectReference{
+							Name: "abc",
+						},
+					},
+				},
+			},
+			old: &IPClaimSetSpec{
+				Template: IPClaimTemplate{
+					Spec: IPClaimSpec{
+						Pool: corev1.ObjectReference{
+							Name: "abcd",
+						},
+					},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var new, old *IPClaimSet
+			g := NewWithT(t)
+			new = &IPClaimSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+					Name:      "abc",
+				},
+				Spec: *tt.new,
+			}
+
+			if tt.old != nil {
+				old = &IPClaimSet{
+					ObjectMeta: metav1.ObjectMeta{
+						Namespace: "foo",
+						Name:      "abc",
+					},
+					Spec: *tt.old,
+				}
+			} else {
+				old = nil
+			}
+
+			if tt.expectErr {
+				g.Expect(new.ValidateUpdate(old)).NotTo(Succeed())
+			} else {
+				g.Expect(new.ValidateUpdate(old)).To(Succeed())
+			}
+		})
+	}
+}
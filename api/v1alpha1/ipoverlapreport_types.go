@@ -0,0 +1,152 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// OverlapSeverity is the severity of an overlap between two pool ranges.
+type OverlapSeverity string
+
+const (
+	// OverlapSeverityCritical reports an address allocated from both pools.
+	OverlapSeverityCritical OverlapSeverity = "Critical"
+
+	// OverlapSeverityWarning reports addresses of the overlap allocated from
+	// one of the pools, that the other pool can allocate again.
+	OverlapSeverityWarning OverlapSeverity = "Warning"
+
+	// OverlapSeverityInfo reports an overlap without allocated addresses.
+	OverlapSeverityInfo OverlapSeverity = "Info"
+)
+
+// IPOverlapReportSpec defines the desired state of IPOverlapReport.
+type IPOverlapReportSpec struct {
+
+	// Interval is the interval between two generations of the report.
+	// Defaults to one hour.
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+}
+
+// OverlappingRange identifies a range of the pools of an IPPool.
+type OverlappingRange struct {
+
+	// Namespace is the namespace of the IPPool.
+	Namespace string `json:"namespace"`
+
+	// Name is the name of the IPPool.
+	Name string `json:"name"`
+
+	// Index is the index of the range in the pools of the IPPool.
+	Index int `json:"index"`
+}
+
+// AffectedAllocation is an address of an overlap allocated from a pool.
+type AffectedAllocation struct {
+
+	// Namespace is the namespace of the IPPool.
+	Namespace string `json:"namespace"`
+
+	// Pool is the name of the IPPool.
+	Pool string `json:"pool"`
+
+	// Claim is the key of the allocation in the IPPool status, the name of
+	// the IPClaim.
+	Claim string `json:"claim"`
+
+	// Address is the allocated address.
+	Address IPAddressStr `json:"address"`
+}
+
+// IPRangeOverlap is an overlap between two pool ranges.
+type IPRangeOverlap struct {
+
+	// Ranges are the two overlapping ranges.
+	Ranges []OverlappingRange `json:"ranges"`
+
+	// Start is the first address of the overlap.
+	Start IPAddressStr `json:"start"`
+
+	// End is the last address of the overlap.
+	End IPAddressStr `json:"end"`
+
+	// Severity is the severity of the overlap.
+	Severity OverlapSeverity `json:"severity"`
+
+	// AffectedAllocations are the addresses of the overlap allocated from
+	// the pools.
+	// +optional
+	AffectedAllocations []AffectedAllocation `json:"affectedAllocations,omitempty"`
+}
+
+// IPOverlapReportStatus defines the observed state of IPOverlapReport.
+type IPOverlapReportStatus struct {
+	// LastUpdated identifies when the report was last generated.
+	// +optional
+	LastUpdated *metav1.Time `json:"lastUpdated,omitempty"`
+
+	// Pools is the number of IPPools checked.
+	// +optional
+	Pools int `json:"pools,omitempty"`
+
+	// Critical is the number of critical overlaps.
+	// +optional
+	Critical int `json:"critical,omitempty"`
+
+	// Warning is the number of overlaps with a warning severity.
+	// +optional
+	Warning int `json:"warning,omitempty"`
+
+	// Overlaps are the overlaps between the ranges of the IPPools of all
+	// namespaces.
+	// +optional
+	Overlaps []IPRangeOverlap `json:"overlaps,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:resource:path=ipoverlapreports,scope=Cluster,categories=cluster-api,shortName=ipor;m3ipor;metal3ipor
+// +kubebuilder:storageversion
+// +kubebuilder:subresource:status
+// +kubebuilder:object:root=true
+// +kubebuilder:printcolumn:name="Pools",type="integer",JSONPath=".status.pools",description="Number of IPPools checked"
+// +kubebuilder:printcolumn:name="Critical",type="integer",JSONPath=".status.critical",description="Number of critical overlaps"
+// +kubebuilder:printcolumn:name="Warning",type="integer",JSONPath=".status.warning",description="Number of overlaps with a warning severity"
+// +kubebuilder:printcolumn:name="Last Updated",type="date",JSONPath=".status.lastUpdated",description="Time of the last generation of the report"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp",description="Time duration since creation of IPOverlapReport"
+// IPOverlapReport is the Schema for the ipoverlapreports API
+type IPOverlapReport struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   IPOverlapReportSpec   `json:"spec,omitempty"`
+	Status IPOverlapReportStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// IPOverlapReportList contains a list of IPOverlapReport
+type IPOverlapReportList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []IPOverlapReport `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&IPOverlapReport{}, &IPOverlapReportList{})
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+func (c *IPOverlapReport) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(c).
+		Complete()
+}
+
+// +kubebuilder:webhook:verbs=create;update,path=/validate-ipam-metal3-io-v1alpha4-ipoverlapreport,mutating=false,failurePolicy=fail,groups=ipam.metal3.io,resources=ipoverlapreports,versions=v1alpha4,name=validation.ipoverlapreport.ipam.metal3.io,matchPolicy=Equivalent,sideEffects=None,admissionReviewVersions=v1;v1beta1
+// +kubebuilder:webhook:verbs=create;update,path=/mutate-ipam-metal3-io-v1alpha4-ipoverlapreport,mutating=true,failurePolicy=fail,groups=ipam.metal3.io,resources=ipoverlapreports,versions=v1alpha4,name=default.ipoverlapreport.ipam.metal3.io,matchPolicy=Equivalent,sideEffects=None,admissionReviewVersions=v1;v1beta1
+
+var _ webhook.Defaulter = &IPOverlapReport{}
+var _ webhook.Validator = &IPOverlapReport{}
+
+func (c *IPOverlapReport) Default() {
+}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (c *IPOverlapReport) ValidateCreate() error {
+	return c.validate()
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (c *IPOverlapReport) ValidateUpdate(old runtime.Object) error {
+	oldIPOverlapReport, ok := old.(*IPOverlapReport)
+	if !ok || oldIPOverlapReport == nil {
+		return apierrors.NewInternalError(errors.New("unable to convert existing object"))
+	}
+	return c.validate()
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (c *IPOverlapReport) ValidateDelete() error {
+	return nil
+}
+
+func (c *IPOverlapReport) validate() error {
+	allErrs := field.ErrorList{}
+
+	if c.Spec.Interval != nil && c.Spec.Interval.Duration <= 0 {
+		allErrs = append(allErrs,
+			field.Invalid(
+				field.NewPath("spec", "interval"),
+				c.Spec.Interval.Duration.String(),
+				"must be positive",
+			),
+		)
+	}
+
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("IPOverlapReport").GroupKind(), c.Name, allErrs)
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestIPOverlapReportDefault(t *testing.T) {
+	g := NewWithT(t)
+
+	c := &IPOverlapReport{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "abc",
+		},
+	}
+	c.Default()
+
+	g.Expect(c.Spec).To(Equal(IPOverlapReportSpec{}))
+}
+
+func TestIPOverlapReportValidation(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		expectErr bool
+		spec      IPOverlapReportSpec
+	}{
+		{
+			name:      "should succeed without interval",
+			expectErr: false,
+		},
+		{
+			name:      "should succeed with a positive interval",
+			expectErr: false,
+			spec: IPOverlapReportSpec{
+				Interval: &metav1.Duration{Duration: time.Hour},
+			},
+		},
+		{
+			name:      "should fail with a zero interval",
+			expectErr: true,
+			spec: IPOverlapReportSpec{
+				Interval: &metav1.Duration{},
+			},
+		},
+		{
+			name:      "should fail with a negative interval",
+			expectErr: true,
+			spec: IPOverlapReportSpec{
+				Interval: &metav1.Duration{Duration: -time.Minute},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			obj := &IPOverlapReport{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "abc",
+				},
+				Spec: tt.spec,
+			}
+
+			if tt.expectErr {
+				g.Expect(obj.ValidateCreate()).NotTo(Succeed())
+				g.Expect(obj.ValidateUpdate(obj.DeepCopy())).NotTo(Succeed())
+			} else {
+				g.Expect(obj.ValidateCreate()).To(Succeed())
+				g.Expect(obj.ValidateUpdate(obj.DeepCopy())).To(Succeed())
+			}
+
+			g.Expect(obj.ValidateUpdate(nil)).NotTo(Succeed())
+			g.Expect(obj.ValidateDelete()).To(Succeed())
+		})
+	}
+}
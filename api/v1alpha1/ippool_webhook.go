@@ -14,29 +14,128 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
 	"reflect"
+	"sort"
+	"strings"
 
 	"github.com/pkg/errors"
+	admissionv1 "k8s.io/api/admission/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/apimachinery/pkg/util/validation/field"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 )
 
+// ipPoolWebhookReader is used to fetch the IPAMConfig of the namespace of an
+// IPPool, the other IPPools of the namespace and the allocation shards
+var ipPoolWebhookReader client.Reader
+
 func (c *IPPool) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	ipPoolWebhookReader = mgr.GetAPIReader()
+	// Registered before the builder runs, so that it does not register the
+	// validating webhook without the warnings
+	mgr.GetWebhookServer().Register("/validate-ipam-metal3-io-v1alpha4-ippool",
+		&webhook.Admission{Handler: &ipPoolValidatingHandler{
+			validator: admission.ValidatingWebhookFor(c).Handler,
+		}},
+	)
 	return ctrl.NewWebhookManagedBy(mgr).
 		For(c).
 		Complete()
 }
 
-// +kubebuilder:webhook:verbs=create;update,path=/validate-ipam-metal3-io-v1alpha4-ippool,mutating=false,failurePolicy=fail,groups=ipam.metal3.io,resources=ippools,versions=v1alpha4,name=validation.ippool.ipam.metal3.io,matchPolicy=Equivalent,sideEffects=None,admissionReviewVersions=v1;v1beta1
+// +kubebuilder:webhook:verbs=create;update;delete,path=/validate-ipam-metal3-io-v1alpha4-ippool,mutating=false,failurePolicy=fail,groups=ipam.metal3.io,resources=ippools,versions=v1alpha4,name=validation.ippool.ipam.metal3.io,matchPolicy=Equivalent,sideEffects=None,admissionReviewVersions=v1;v1beta1
 // +kubebuilder:webhook:verbs=create;update,path=/mutate-ipam-metal3-io-v1alpha4-ippool,mutating=true,failurePolicy=fail,groups=ipam.metal3.io,resources=ippools,versions=v1alpha4,name=default.ippool.ipam.metal3.io,matchPolicy=Equivalent,sideEffects=None,admissionReviewVersions=v1;v1beta1
 
 var _ webhook.Defaulter = &IPPool{}
 var _ webhook.Validator = &IPPool{}
 
+// ipPoolValidatingHandler validates the IPPools like the validating webhook of
+// the builder, and warns about the risky but legal updates
+type ipPoolValidatingHandler struct {
+	validator admission.Handler
+	decoder   *admission.Decoder
+}
+
+// InjectDecoder injects the decoder into the handler and its validator
+func (h *ipPoolValidatingHandler) InjectDecoder(d *admission.Decoder) error {
+	h.decoder = d
+	_, err := admission.InjectDecoderInto(d, h.validator)
+	return err
+}
+
+// Handle validates the request and adds the warnings of the updates allowed
+func (h *ipPoolValidatingHandler) Handle(ctx context.Context, req admission.Request) admission.Response {
+	response := h.validator.Handle(ctx, req)
+	if !response.Allowed || req.Operation != admissionv1.Update {
+		return response
+	}
+	ipPool, oldIPPool := &IPPool{}, &IPPool{}
+	if err := h.decoder.DecodeRaw(req.Object, ipPool); err != nil {
+		return response
+	}
+	if err := h.decoder.DecodeRaw(req.OldObject, oldIPPool); err != nil {
+		return response
+	}
+	return response.WithWarnings(ipPool.updateWarnings(ctx, oldIPPool)...)
+}
+
+// Default sets the defaults of the IPAMConfig of the namespace, if any, on
+// the IPPool
 func (c *IPPool) Default() {
+	if ipPoolWebhookReader != nil {
+		ipamConfig := &IPAMConfig{}
+		key := client.ObjectKey{Name: IPAMConfigName, Namespace: c.Namespace}
+		if err := ipPoolWebhookReader.Get(context.TODO(), key, ipamConfig); err == nil {
+			c.ApplyIPAMConfig(ipamConfig)
+		}
+	}
+	c.Spec.applyDefaults(c.Name)
+}
+
+// applyDefaults defaults the name prefix of the pool to its name and
+// normalizes its addresses
+func (s *IPPoolSpec) applyDefaults(name string) {
+	if s.NamePrefix == "" {
+		s.NamePrefix = name
+	}
+	s.normalizeAddresses()
+}
+
+// normalizeAddresses rewrites the addresses and subnets of the pool in their
+// canonical form, such as 2001:db8::1 for 2001:DB8:0::0001, so that they
+// match the allocated addresses. The invalid and IPv4-mapped addresses are
+// left to the validation.
+func (s *IPPoolSpec) normalizeAddresses() {
+	for i := range s.Pools {
+		pool := &s.Pools[i]
+		pool.Start = normalizeIPAddressPtr(pool.Start)
+		pool.End = normalizeIPAddressPtr(pool.End)
+		pool.Subnet = normalizeIPSubnetPtr(pool.Subnet)
+		pool.Gateway = normalizeIPAddressPtr(pool.Gateway)
+		normalizeIPAddresses(pool.DNSServers)
+		for j := range pool.Exclude {
+			pool.Exclude[j].Start = normalizeIPAddress(pool.Exclude[j].Start)
+			pool.Exclude[j].End = normalizeIPAddressPtr(pool.Exclude[j].End)
+		}
+	}
+	s.Gateway = normalizeIPAddressPtr(s.Gateway)
+	normalizeIPAddresses(s.DNSServers)
+	for key, address := range s.PreAllocations {
+		s.PreAllocations[key] = normalizeIPAddress(address)
+	}
+	for key, address := range s.MACReservations {
+		s.MACReservations[key] = normalizeIPAddress(address)
+	}
 }
 
 // ValidateCreate implements webhook.Validator so a webhook will be registered for the type
@@ -46,12 +145,28 @@ func (c *IPPool) ValidateCreate() error {
 
 // ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
 func (c *IPPool) ValidateUpdate(old runtime.Object) error {
-	allErrs := field.ErrorList{}
 	oldM3ipp, ok := old.(*IPPool)
 	if !ok || oldM3ipp == nil {
 		return apierrors.NewInternalError(errors.New("unable to convert existing object"))
 	}
 
+	allErrs := c.validateSpecUpdate(oldM3ipp)
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("Metal3Data").GroupKind(), c.Name, allErrs)
+}
+
+// validateSpecUpdate verifies the updated spec of the pool against the old
+// pool, shared by the IPPools and ClusterIPPools
+func (c *IPPool) validateSpecUpdate(oldM3ipp *IPPool) field.ErrorList {
+	allErrs := field.ErrorList{}
+	// The spec is compared with the old one as defaulted, not to reject the
+	// normalization of its addresses as modifications
+	oldM3ipp = oldM3ipp.DeepCopy()
+	oldM3ipp.Spec.normalizeAddresses()
+	allErrs = append(allErrs, c.validateNetworkSettings()...)
+
 	if !reflect.DeepEqual(c.Spec.NamePrefix, oldM3ipp.Spec.NamePrefix) {
 		allErrs = append(allErrs,
 			field.Invalid(
@@ -61,89 +176,1246 @@ func (c *IPPool) ValidateUpdate(old runtime.Object) error {
 			),
 		)
 	}
-	allocationOutOfBonds, inUseOutOfBonds := c.checkPoolBonds(oldM3ipp)
-	if len(allocationOutOfBonds) != 0 {
-		for _, address := range allocationOutOfBonds {
+	// The pools of the existing IPPools are only verified when modified, not
+	// to prevent the removal of their finalizers
+	if !reflect.DeepEqual(c.Spec.Pools, oldM3ipp.Spec.Pools) {
+		allErrs = append(allErrs, c.validatePools()...)
+	}
+	if !reflect.DeepEqual(c.Spec.Pools, oldM3ipp.Spec.Pools) ||
+		!reflect.DeepEqual(c.Spec.Gateway, oldM3ipp.Spec.Gateway) ||
+		c.Spec.Prefix != oldM3ipp.Spec.Prefix || c.Spec.OffSubnetGateway != oldM3ipp.Spec.OffSubnetGateway {
+		allErrs = append(allErrs, c.validateGateways()...)
+	}
+	allErrs = append(allErrs, c.validatePreAllocations()...)
+	allErrs = append(allErrs, c.validateMACReservations()...)
+	allErrs = append(allErrs, c.validateDelegatedPrefix()...)
+	if c.Spec.DualStack != oldM3ipp.Spec.DualStack {
+		allErrs = append(allErrs,
+			field.Invalid(
+				field.NewPath("spec", "dualStack"),
+				c.Spec.DualStack,
+				"cannot be modified",
+			),
+		)
+	}
+	if c.Spec.DelegatedPrefix != oldM3ipp.Spec.DelegatedPrefix {
+		allErrs = append(allErrs,
+			field.Invalid(
+				field.NewPath("spec", "delegatedPrefix"),
+				c.Spec.DelegatedPrefix,
+				"cannot be modified",
+			),
+		)
+	}
+	allErrs = append(allErrs, validateNonNegativeDuration(
+		field.NewPath("spec", "claimBindingDeadline"), c.Spec.ClaimBindingDeadline,
+	)...)
+	allErrs = append(allErrs, validateNonNegativeDuration(
+		field.NewPath("spec", "leaseDuration"), c.Spec.LeaseDuration,
+	)...)
+	allErrs = append(allErrs, c.validateReleaseHook()...)
+	allErrs = append(allErrs, c.validateBackend()...)
+	allErrs = append(allErrs, c.validateReleaseSettings()...)
+	allErrs = append(allErrs, c.validateDrain()...)
+	allErrs = append(allErrs, c.validateFallbackPools()...)
+
+	inUseOutOfBonds, err := c.checkPoolBonds(oldM3ipp)
+	if err != nil {
+		allErrs = append(allErrs,
+			field.Forbidden(field.NewPath("spec", "pools"), err.Error()),
+		)
+	}
+	if len(inUseOutOfBonds) != 0 {
+		listed := make([]string, len(inUseOutOfBonds))
+		for i, address := range inUseOutOfBonds {
+			listed[i] = string(address)
+		}
+		allErrs = append(allErrs,
+			field.Invalid(
+				field.NewPath("spec", "pools"),
+				truncatedList(listed),
+				"the addresses are in use but out of bonds of the pools given, or excluded",
+			),
+		)
+	}
+	return allErrs
+}
+
+// updateWarnings returns the warnings about the update of the pool: the
+// network settings changed while addresses are allocated, since the allocated
+// addresses keep the former ones, and the ranges added or modified that
+// overlap the ranges of the other IPPools of the namespace
+func (c *IPPool) updateWarnings(ctx context.Context, old *IPPool) []string {
+	warnings := []string{}
+	allocated := old.Status.AllocatedAddresses()
+	if len(allocated) != 0 {
+		if !reflect.DeepEqual(c.Spec.Backend, old.Spec.Backend) {
+			warnings = append(warnings, fmt.Sprintf(
+				"spec.backend: the reservations of the %d addresses already allocated are not moved to the new backend",
+				len(allocated),
+			))
+		}
+		if !reflect.DeepEqual(c.Spec.Gateway, old.Spec.Gateway) {
+			warnings = append(warnings, fmt.Sprintf(
+				"spec.gateway: the %d addresses already allocated keep the former gateway %s",
+				len(allocated), formatOptionalAddress(old.Spec.Gateway),
+			))
+		}
+		if c.Spec.Prefix != old.Spec.Prefix {
+			warnings = append(warnings, fmt.Sprintf(
+				"spec.prefix: the %d addresses already allocated keep the former prefix %d",
+				len(allocated), old.Spec.Prefix,
+			))
+		}
+	}
+	for i, pool := range c.Spec.Pools {
+		if i >= len(old.Spec.Pools) {
+			break
+		}
+		oldPool := old.Spec.Pools[i]
+		nbAllocated := countAddressesInPool(oldPool, allocated)
+		if nbAllocated == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(pool.Gateway, oldPool.Gateway) {
+			warnings = append(warnings, fmt.Sprintf(
+				"spec.pools[%d].gateway: the %d addresses already allocated keep the former gateway %s",
+				i, nbAllocated, formatOptionalAddress(oldPool.Gateway),
+			))
+		}
+		if pool.Prefix != oldPool.Prefix {
+			warnings = append(warnings, fmt.Sprintf(
+				"spec.pools[%d].prefix: the %d addresses already allocated keep the former prefix %d",
+				i, nbAllocated, oldPool.Prefix,
+			))
+		}
+	}
+	return append(warnings, c.overlapWarnings(ctx, old)...)
+}
+
+// overlapWarnings returns the warnings about the ranges added or modified that
+// overlap the ranges of the other IPPools of the namespace, since the same
+// addresses might then be allocated by both IPPools
+func (c *IPPool) overlapWarnings(ctx context.Context, old *IPPool) []string {
+	if ipPoolWebhookReader == nil {
+		return nil
+	}
+	ipPools := IPPoolList{}
+	if err := ipPoolWebhookReader.List(ctx, &ipPools, client.InNamespace(c.Namespace)); err != nil {
+		return nil
+	}
+	warnings := []string{}
+	for i, pool := range c.Spec.Pools {
+		if i < len(old.Spec.Pools) && samePoolRange(pool, old.Spec.Pools[i]) {
+			continue
+		}
+		poolRange, err := NewPoolRange(pool)
+		if err != nil {
+			continue
+		}
+		for _, ipPool := range ipPools.Items {
+			if ipPool.Name == c.Name {
+				continue
+			}
+			for _, otherPool := range ipPool.Spec.Pools {
+				otherRange, err := NewPoolRange(otherPool)
+				if err != nil || !poolRange.OverlapsRange(otherRange) {
+					continue
+				}
+				warnings = append(warnings, fmt.Sprintf(
+					"spec.pools[%d]: overlaps the pools of the IPPool %s, the same addresses might be allocated by both",
+					i, ipPool.Name,
+				))
+				break
+			}
+		}
+	}
+	return warnings
+}
+
+// samePoolRange returns true if the pools only differ by whether they are
+// disabled
+func samePoolRange(pool, old Pool) bool {
+	pool.Disabled = old.Disabled
+	return reflect.DeepEqual(pool, old)
+}
+
+// countAddressesInPool returns the number of the addresses in the pool
+func countAddressesInPool(pool Pool, addresses []IPAddressStr) int {
+	poolRange, err := NewPoolRange(pool)
+	if err != nil {
+		return 0
+	}
+	count := 0
+	for _, address := range addresses {
+		if poolRange.Contains(net.ParseIP(string(address))) {
+			count++
+		}
+	}
+	return count
+}
+
+// formatOptionalAddress renders an optional address
+func formatOptionalAddress(address *IPAddressStr) string {
+	if address == nil {
+		return "none"
+	}
+	return string(*address)
+}
+
+// checkPoolBonds returns the addresses allocated by the old pool that the
+// updated pools do not contain anymore, removed or excluded from the ranges.
+// The allocations of a sharded pool are read from its shards when its pools
+// are modified, the modification is refused if they cannot be read.
+func (c *IPPool) checkPoolBonds(old *IPPool) ([]IPAddressStr, error) {
+	allocated := old.Status.AllocatedAddresses()
+	if old.Status.AllocationShards != 0 && !reflect.DeepEqual(c.Spec.Pools, old.Spec.Pools) {
+		sharded, err := old.shardedAddresses(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+		allocated = append(allocated, sharded...)
+	}
+	return c.OutOfRangeAddresses(allocated), nil
+}
+
+// shardedAddresses returns the addresses recorded in the
+// IPPoolAllocationShards of the pool
+func (c *IPPool) shardedAddresses(ctx context.Context) ([]IPAddressStr, error) {
+	if ipPoolWebhookReader == nil {
+		return nil, fmt.Errorf("the allocations are recorded in %d allocation shards that cannot be read",
+			c.Status.AllocationShards,
+		)
+	}
+	shards := IPPoolAllocationShardList{}
+	if err := ipPoolWebhookReader.List(ctx, &shards, client.InNamespace(c.Namespace),
+		client.MatchingLabels{IPPoolAllocationShardLabel: c.Name},
+	); err != nil {
+		return nil, errors.Wrap(err, "unable to read the allocation shards")
+	}
+	addresses := []IPAddressStr{}
+	for _, shard := range shards.Items {
+		for _, address := range shard.Spec.Allocations {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses, nil
+}
+
+// validateMACReservations verifies that the MAC reservations are keyed by
+// MAC addresses in their lowercase colon-separated form, and that their
+// addresses are in the pools and not pre-allocated or reserved twice
+func (c *IPPool) validateMACReservations() field.ErrorList {
+	allErrs := field.ErrorList{}
+	if len(c.Spec.MACReservations) == 0 {
+		return allErrs
+	}
+	if c.Spec.ExternallyManaged {
+		return append(allErrs,
+			field.Forbidden(field.NewPath("spec", "macReservations"),
+				"is not allowed in an externally managed IPPool",
+			),
+		)
+	}
+	preAllocated := make(map[IPAddressStr]bool, len(c.Spec.PreAllocations))
+	for _, address := range c.Spec.PreAllocations {
+		preAllocated[address] = true
+	}
+	keys := make([]string, 0, len(c.Spec.MACReservations))
+	for key := range c.Spec.MACReservations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	reserved := make(map[IPAddressStr]string, len(keys))
+	for _, key := range keys {
+		path := field.NewPath("spec", "macReservations").Key(key)
+		address := c.Spec.MACReservations[key]
+		if mac, err := net.ParseMAC(key); err != nil || mac.String() != key {
+			allErrs = append(allErrs,
+				field.Invalid(path, key, "is not a lowercase colon-separated MAC address"),
+			)
+		}
+		switch {
+		case isIPv4Mapped(string(address)):
+			allErrs = append(allErrs, field.Invalid(path, address, ipv4MappedMsg))
+		case c.Spec.DelegatedPrefix == 0 && c.isNetworkOrBroadcast(address):
+			allErrs = append(allErrs,
+				field.Invalid(path, address, "is the network or broadcast address of a pool subnet"),
+			)
+		case !c.isAddressInBonds(address):
+			allErrs = append(allErrs,
+				field.Invalid(path, address, "is out of bonds of the pools given"),
+			)
+		case c.isExcluded(address):
+			allErrs = append(allErrs, field.Invalid(path, address, "is excluded from the pools"))
+		case c.isGateway(address):
+			allErrs = append(allErrs, field.Invalid(path, address, "is the gateway of a pool"))
+		case preAllocated[address]:
+			allErrs = append(allErrs, field.Invalid(path, address, "is pre-allocated"))
+		case reserved[address] != "":
+			allErrs = append(allErrs,
+				field.Invalid(path, address, "is reserved for "+reserved[address]),
+			)
+		}
+		reserved[address] = key
+	}
+	return allErrs
+}
+
+// validatePreAllocations verifies that the preAllocations are in the pools,
+// since the addresses out of the pools can never be allocated
+func (c *IPPool) validatePreAllocations() field.ErrorList {
+	allErrs := field.ErrorList{}
+	keys := make([]string, 0, len(c.Spec.PreAllocations))
+	for key := range c.Spec.PreAllocations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		address := c.Spec.PreAllocations[key]
+		if isIPv4Mapped(string(address)) {
+			allErrs = append(allErrs,
+				field.Invalid(
+					field.NewPath("spec", "preAllocations").Key(key),
+					address,
+					ipv4MappedMsg,
+				),
+			)
+		} else if c.Spec.DelegatedPrefix == 0 && c.isNetworkOrBroadcast(address) {
+			allErrs = append(allErrs,
+				field.Invalid(
+					field.NewPath("spec", "preAllocations").Key(key),
+					address,
+					"is the network or broadcast address of a pool subnet",
+				),
+			)
+		} else if !c.isAddressInBonds(address) {
 			allErrs = append(allErrs,
 				field.Invalid(
-					field.NewPath("spec", "preAllocations"),
+					field.NewPath("spec", "preAllocations").Key(key),
 					address,
 					"is out of bonds of the pools given",
 				),
 			)
-		}
-	}
-	if len(inUseOutOfBonds) != 0 {
-		for _, address := range inUseOutOfBonds {
+		} else if c.isExcluded(address) {
 			allErrs = append(allErrs,
 				field.Invalid(
-					field.NewPath("spec", "pools"),
+					field.NewPath("spec", "preAllocations").Key(key),
 					address,
-					"is in use but out of bonds of the pools given",
+					"is excluded from the pools",
+				),
+			)
+		} else if c.isGateway(address) {
+			allErrs = append(allErrs,
+				field.Invalid(
+					field.NewPath("spec", "preAllocations").Key(key),
+					address,
+					"is the gateway of a pool",
 				),
 			)
 		}
 	}
+	return allErrs
+}
 
-	if len(allErrs) == 0 {
-		return nil
+// validateAllocationStrategy verifies that an IPPool with the EUI64
+// allocation strategy has an IPv6 /64 pool and does not delegate prefixes
+func (c *IPPool) validateAllocationStrategy() field.ErrorList {
+	allErrs := field.ErrorList{}
+	if c.Spec.AllocationStrategy != AllocationStrategyEUI64 {
+		return allErrs
 	}
-	return apierrors.NewInvalid(GroupVersion.WithKind("Metal3Data").GroupKind(), c.Name, allErrs)
+	strategyPath := field.NewPath("spec", "allocationStrategy")
+	if c.Spec.DelegatedPrefix != 0 {
+		allErrs = append(allErrs,
+			field.Forbidden(strategyPath, "EUI64 is not allowed with delegated prefixes"),
+		)
+	}
+	for _, pool := range c.Spec.Pools {
+		poolRange, err := NewPoolRange(pool)
+		if err == nil && poolRange.IsIPv6() && poolRange.Prefix() == 64 {
+			return allErrs
+		}
+	}
+	return append(allErrs,
+		field.Invalid(strategyPath, c.Spec.AllocationStrategy,
+			"requires a pool with an IPv6 /64 subnet",
+		),
+	)
 }
 
-func (c *IPPool) checkPoolBonds(old *IPPool) ([]IPAddressStr, []IPAddressStr) {
-	allocationOutOfBonds := []IPAddressStr{}
-	inUseOutOfBonds := []IPAddressStr{}
-	for _, address := range c.Spec.PreAllocations {
-		inBonds := c.isAddressInBonds(address)
+// validateDualStack verifies that a dual-stack IPPool has pools of both
+// families, and no delegated prefixes
+func (c *IPPool) validateDualStack() field.ErrorList {
+	allErrs := field.ErrorList{}
+	if !c.Spec.DualStack {
+		return allErrs
+	}
+	dualStackPath := field.NewPath("spec", "dualStack")
+	if c.Spec.ExternallyManaged {
+		allErrs = append(allErrs,
+			field.Forbidden(dualStackPath, "is not allowed in an externally managed IPPool"),
+		)
+	}
+	if c.Spec.DelegatedPrefix != 0 {
+		allErrs = append(allErrs,
+			field.Forbidden(dualStackPath, "is not allowed with delegated prefixes"),
+		)
+	}
+	ipv4, ipv6 := false, false
+	for _, pool := range c.Spec.Pools {
+		poolRange, err := NewPoolRange(pool)
+		if err != nil {
+			// Reported with the pools
+			continue
+		}
+		if poolRange.IsIPv6() {
+			ipv6 = true
+		} else {
+			ipv4 = true
+		}
+	}
+	if !ipv4 || !ipv6 {
+		allErrs = append(allErrs,
+			field.Invalid(dualStackPath, c.Spec.DualStack,
+				"requires at least an IPv4 pool and an IPv6 pool",
+			),
+		)
+	}
+	return allErrs
+}
 
-		if !inBonds {
-			allocationOutOfBonds = append(allocationOutOfBonds, address)
+// validateDelegatedPrefix verifies that the delegated prefixes fit in the
+// pools and that the pre-allocated prefixes are aligned on their length
+func (c *IPPool) validateDelegatedPrefix() field.ErrorList {
+	allErrs := field.ErrorList{}
+	if c.Spec.DelegatedPrefix == 0 {
+		return allErrs
+	}
+	for i, pool := range c.Spec.Pools {
+		poolRange, err := NewPoolRange(pool)
+		if err != nil {
+			// Reported with the pools
+			continue
+		}
+		if _, err := poolRange.PrefixAt(nil, c.Spec.DelegatedPrefix); err != nil {
+			allErrs = append(allErrs,
+				field.Invalid(
+					field.NewPath("spec", "delegatedPrefix"),
+					c.Spec.DelegatedPrefix,
+					fmt.Sprintf("no prefix of this length fits in spec.pools[%d]", i),
+				),
+			)
 		}
 	}
-	for _, address := range old.Status.Allocations {
-		inBonds := c.isAddressInBonds(address)
 
-		if !inBonds {
-			inUseOutOfBonds = append(inUseOutOfBonds, address)
+	keys := make([]string, 0, len(c.Spec.PreAllocations))
+	for key := range c.Spec.PreAllocations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		address := c.Spec.PreAllocations[key]
+		ip := net.ParseIP(string(address))
+		if ip == nil {
+			continue
+		}
+		bitsLen := 8 * net.IPv6len
+		if ip.To4() != nil {
+			bitsLen = 8 * net.IPv4len
+		}
+		if c.Spec.DelegatedPrefix > bitsLen ||
+			!ip.Mask(net.CIDRMask(c.Spec.DelegatedPrefix, bitsLen)).Equal(ip) {
+			allErrs = append(allErrs,
+				field.Invalid(
+					field.NewPath("spec", "preAllocations").Key(key),
+					address,
+					fmt.Sprintf("is not the first address of a prefix of length %d", c.Spec.DelegatedPrefix),
+				),
+			)
 		}
 	}
-	return allocationOutOfBonds, inUseOutOfBonds
+	return allErrs
 }
 
 func (c *IPPool) isAddressInBonds(address IPAddressStr) bool {
-	inBonds := false
+	ip := net.ParseIP(string(address))
+	if ip == nil {
+		return false
+	}
 	for _, pool := range c.Spec.Pools {
-		if inBonds {
-			break
+		poolRange, err := NewPoolRange(pool)
+		if err != nil {
+			continue
 		}
-		index := 0
-		for !inBonds {
-			allocatedAddress, err := GetIPAddress(pool, index)
-			if err != nil {
-				break
-			}
-			index++
-			if allocatedAddress == address {
-				inBonds = true
-				break
+		if c.Spec.DelegatedPrefix != 0 {
+			// With delegated prefixes, the address is the first one of a
+			// prefix that must fit entirely in the pool, network included
+			if c.isPrefixInRange(poolRange, ip) {
+				return true
 			}
+			continue
+		}
+		if poolRange.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPrefixInRange returns true if the delegated prefix containing the
+// address fits entirely in the pool range
+func (c *IPPool) isPrefixInRange(poolRange *PoolRange, ip net.IP) bool {
+	bitsLen := 8 * net.IPv6len
+	if ip.To4() != nil {
+		ip = ip.To4()
+		bitsLen = 8 * net.IPv4len
+	}
+	if c.Spec.DelegatedPrefix > bitsLen {
+		return false
+	}
+	first := ip.Mask(net.CIDRMask(c.Spec.DelegatedPrefix, bitsLen))
+	prefix, err := poolRange.PrefixAt(first, c.Spec.DelegatedPrefix)
+	if err != nil {
+		return false
+	}
+	return prefix.IP.Equal(first)
+}
+
+// isExcluded returns true if the address is in an excluded range of a pool
+func (c *IPPool) isExcluded(address IPAddressStr) bool {
+	ip := net.ParseIP(string(address))
+	if ip == nil {
+		return false
+	}
+	for _, pool := range c.Spec.Pools {
+		poolRange, err := NewPoolRange(pool)
+		if err != nil {
+			continue
+		}
+		if poolRange.Contains(ip) && poolRange.IsExcluded(ip) {
+			return true
 		}
 	}
-	return inBonds
+	return false
+}
+
+// isGateway returns true if the address is the gateway of a pool containing
+// it, its own or the default gateway of the IPPool
+func (c *IPPool) isGateway(address IPAddressStr) bool {
+	ip := net.ParseIP(string(address))
+	if ip == nil || c.Spec.DelegatedPrefix != 0 {
+		return false
+	}
+	for _, pool := range c.Spec.Pools {
+		poolRange, err := NewPoolRange(pool)
+		if err != nil || !poolRange.Contains(ip) {
+			continue
+		}
+		gateway := pool.Gateway
+		if gateway == nil && c.Spec.Gateway != nil &&
+			(c.Spec.OffSubnetGateway || poolRange.InSubnet(net.ParseIP(string(*c.Spec.Gateway)))) {
+			gateway = c.Spec.Gateway
+		}
+		if gateway != nil && ip.Equal(net.ParseIP(string(*gateway))) {
+			return true
+		}
+	}
+	return false
+}
+
+// isNetworkOrBroadcast returns true if the address is the network or
+// broadcast address of the subnet of a pool
+func (c *IPPool) isNetworkOrBroadcast(address IPAddressStr) bool {
+	ip := net.ParseIP(string(address))
+	if ip == nil {
+		return false
+	}
+	for _, pool := range c.Spec.Pools {
+		poolRange, err := NewPoolRange(pool)
+		if err != nil {
+			continue
+		}
+		if poolRange.IsNetworkOrBroadcast(ip) {
+			return true
+		}
+	}
+	return false
 }
 
 // ValidateDelete implements webhook.Validator so a webhook will be registered for the type
 func (c *IPPool) ValidateDelete() error {
+	if err := c.validateDeletionPolicy(); err != nil {
+		return apierrors.NewForbidden(GroupVersion.WithResource("ippools").GroupResource(), c.Name, err)
+	}
 	return nil
 }
 
-//No further validation for now
-func (c *IPPool) validate() error {
-	var allErrs field.ErrorList
+// validateDeletionPolicy refuses the deletion of the pools with the Block
+// deletion policy while addresses are allocated to their claims
+func (c *IPPool) validateDeletionPolicy() error {
+	if c.Spec.DeletionPolicy != DeletionPolicyBlock {
+		return nil
+	}
+	if message := c.DeletionBlockedMessage(); message != "" {
+		return errors.New("the deletion policy is Block and " + message)
+	}
+	return nil
+}
 
+func (c *IPPool) validate() error {
+	allErrs := c.validateSpec()
 	if len(allErrs) == 0 {
 		return nil
 	}
 	return apierrors.NewInvalid(GroupVersion.WithKind("IPPool").GroupKind(), c.Name, allErrs)
 }
+
+// validateSpec verifies the spec of the pool, shared by the IPPools and
+// ClusterIPPools
+func (c *IPPool) validateSpec() field.ErrorList {
+	var allErrs field.ErrorList
+
+	allErrs = append(allErrs, c.validateNetworkSettings()...)
+	allErrs = append(allErrs, c.validateGateways()...)
+	allErrs = append(allErrs, c.validatePools()...)
+	allErrs = append(allErrs, c.validatePreAllocations()...)
+	allErrs = append(allErrs, c.validateMACReservations()...)
+	allErrs = append(allErrs, c.validateDelegatedPrefix()...)
+	allErrs = append(allErrs, c.validateDualStack()...)
+	allErrs = append(allErrs, c.validateAllocationStrategy()...)
+	allErrs = append(allErrs, validateNonNegativeDuration(
+		field.NewPath("spec", "claimBindingDeadline"), c.Spec.ClaimBindingDeadline,
+	)...)
+	allErrs = append(allErrs, validateNonNegativeDuration(
+		field.NewPath("spec", "leaseDuration"), c.Spec.LeaseDuration,
+	)...)
+	allErrs = append(allErrs, c.validateReleaseHook()...)
+	allErrs = append(allErrs, c.validateBackend()...)
+	allErrs = append(allErrs, c.validateReleaseSettings()...)
+	allErrs = append(allErrs, c.validateDrain()...)
+	allErrs = append(allErrs, c.validateFallbackPools()...)
+	return allErrs
+}
+
+// validatePools verifies that the start, end and subnet of each pool are
+// consistent, and that the pools do not overlap each other. The overlapping
+// addresses would otherwise be allocated from the first pool only, silently
+// breaking the affinity groups and the subnets of the claims.
+func (c *IPPool) validatePools() field.ErrorList {
+	allErrs := field.ErrorList{}
+	poolRanges := make([]*PoolRange, len(c.Spec.Pools))
+	for i, pool := range c.Spec.Pools {
+		path := field.NewPath("spec", "pools").Index(i)
+		poolRange, errs := validatePoolRange(path, pool)
+		allErrs = append(allErrs, errs...)
+		if c.Spec.DelegatedPrefix != 0 && len(pool.Exclude) != 0 {
+			allErrs = append(allErrs,
+				field.Forbidden(path.Child("exclude"), "not allowed with delegated prefixes"),
+			)
+		}
+		if poolRange == nil {
+			continue
+		}
+		poolRanges[i] = poolRange
+		for j, otherRange := range poolRanges[:i] {
+			if otherRange == nil || !poolRange.OverlapsRange(otherRange) {
+				continue
+			}
+			first, last := poolRange.Bounds()
+			allErrs = append(allErrs,
+				field.Invalid(path, first.String()+"-"+last.String(),
+					fmt.Sprintf("overlaps spec.pools[%d]", j),
+				),
+			)
+		}
+	}
+	return allErrs
+}
+
+// validatePoolRange verifies that the start, end and subnet of the pool are
+// valid addresses of the same family, the end not being before the start,
+// and that the start and end are in the subnet. It returns the parsed range if
+// the pool is valid.
+func validatePoolRange(path *field.Path, pool Pool) (*PoolRange, field.ErrorList) {
+	allErrs := field.ErrorList{}
+	if pool.Start == nil && pool.Subnet == nil {
+		return nil, append(allErrs, field.Required(path, "either start or subnet is required"))
+	}
+	if pool.Start == nil && pool.End != nil {
+		allErrs = append(allErrs,
+			field.Invalid(path.Child("end"), *pool.End, "is ignored without start"),
+		)
+	}
+
+	var start, end net.IP
+	var ipNet *net.IPNet
+	if pool.Start != nil {
+		if start = net.ParseIP(string(*pool.Start)); start == nil {
+			allErrs = append(allErrs,
+				field.Invalid(path.Child("start"), *pool.Start, "is not a valid IP address"),
+			)
+		}
+	}
+	if pool.Start != nil && pool.End != nil {
+		if end = net.ParseIP(string(*pool.End)); end == nil {
+			allErrs = append(allErrs,
+				field.Invalid(path.Child("end"), *pool.End, "is not a valid IP address"),
+			)
+		}
+	}
+	if pool.Subnet != nil {
+		var err error
+		if _, ipNet, err = net.ParseCIDR(string(*pool.Subnet)); err != nil {
+			allErrs = append(allErrs,
+				field.Invalid(path.Child("subnet"), *pool.Subnet, "is not a valid subnet"),
+			)
+		}
+	}
+	if len(allErrs) != 0 {
+		return nil, allErrs
+	}
+
+	if start != nil && end != nil {
+		if ipFamily(start) != ipFamily(end) {
+			allErrs = append(allErrs,
+				field.Invalid(path.Child("end"), *pool.End, "must be of the family of the start"),
+			)
+		} else if compareIPs(start, end) > 0 {
+			allErrs = append(allErrs,
+				field.Invalid(path.Child("end"), *pool.End, "must not be before the start"),
+			)
+		}
+	}
+	if ipNet != nil && start != nil && !ipNet.Contains(start) {
+		allErrs = append(allErrs,
+			field.Invalid(path.Child("start"), *pool.Start, "is not in the subnet"),
+		)
+	}
+	if ipNet != nil && end != nil && !ipNet.Contains(end) {
+		allErrs = append(allErrs,
+			field.Invalid(path.Child("end"), *pool.End, "is not in the subnet"),
+		)
+	}
+	if len(allErrs) != 0 {
+		return nil, allErrs
+	}
+	if allErrs = validateExcludedRanges(path, pool); len(allErrs) != 0 {
+		return nil, allErrs
+	}
+
+	poolRange, err := NewPoolRange(pool)
+	if err != nil {
+		return nil, append(allErrs, field.Invalid(path, "", err.Error()))
+	}
+	return poolRange, nil
+}
+
+// validateExcludedRanges verifies that the excluded ranges of the pool are
+// valid ranges of the pool that do not overlap each other
+func validateExcludedRanges(path *field.Path, pool Pool) field.ErrorList {
+	allErrs := field.ErrorList{}
+	excludedPool := pool
+	excludedPool.Exclude = nil
+	poolRange, err := NewPoolRange(excludedPool)
+	if err != nil {
+		return allErrs
+	}
+	excludedRanges := make([][2]net.IP, len(pool.Exclude))
+	for i, excluded := range pool.Exclude {
+		excludedPath := path.Child("exclude").Index(i)
+		first := net.ParseIP(string(excluded.Start))
+		if first == nil || !poolRange.Contains(first) {
+			allErrs = append(allErrs,
+				field.Invalid(excludedPath.Child("start"), excluded.Start, "is not an address of the pool"),
+			)
+			continue
+		}
+		last := first
+		if excluded.End != nil {
+			if last = net.ParseIP(string(*excluded.End)); last == nil || !poolRange.Contains(last) {
+				allErrs = append(allErrs,
+					field.Invalid(excludedPath.Child("end"), *excluded.End, "is not an address of the pool"),
+				)
+				continue
+			}
+			if compareIPs(first, last) > 0 {
+				allErrs = append(allErrs,
+					field.Invalid(excludedPath.Child("end"), *excluded.End, "must not be before the start"),
+				)
+				continue
+			}
+		}
+		for j, other := range excludedRanges[:i] {
+			if other[0] != nil && compareIPs(first, other[1]) <= 0 && compareIPs(other[0], last) <= 0 {
+				allErrs = append(allErrs,
+					field.Invalid(excludedPath, excluded.Start,
+						fmt.Sprintf("overlaps %s", path.Child("exclude").Index(j)),
+					),
+				)
+			}
+		}
+		excludedRanges[i] = [2]net.IP{first, last}
+	}
+	return allErrs
+}
+
+// validateReleaseHook verifies that the release hook, if given, calls either
+// a valid HTTP URL or a Job
+func (c *IPPool) validateReleaseHook() field.ErrorList {
+	hook := c.Spec.ReleaseHook
+	if hook == nil {
+		return nil
+	}
+	allErrs := field.ErrorList{}
+	path := field.NewPath("spec", "releaseHook")
+
+	if (hook.URL == "") == (hook.Job == nil) {
+		allErrs = append(allErrs,
+			field.Invalid(path, "", "exactly one of url and job must be given"),
+		)
+	}
+	if hook.URL != "" {
+		hookURL, err := url.Parse(hook.URL)
+		if err != nil || (hookURL.Scheme != "http" && hookURL.Scheme != "https") || hookURL.Host == "" {
+			allErrs = append(allErrs,
+				field.Invalid(path.Child("url"), hook.URL, "must be an http or https URL"),
+			)
+		}
+	}
+	if hook.Timeout != nil && hook.Timeout.Duration <= 0 {
+		allErrs = append(allErrs,
+			field.Invalid(path.Child("timeout"), hook.Timeout.Duration.String(), "must be positive"),
+		)
+	}
+	if hook.Job != nil && len(hook.Job.Spec.Template.Spec.Containers) == 0 {
+		allErrs = append(allErrs,
+			field.Required(path.Child("job", "spec", "template", "spec", "containers"),
+				"the job needs a container",
+			),
+		)
+	}
+	return allErrs
+}
+
+// validateBackend verifies that exactly one backend is given, and that the
+// pool allocates single addresses itself
+func (c *IPPool) validateBackend() field.ErrorList {
+	backend := c.Spec.Backend
+	if backend == nil {
+		return nil
+	}
+	allErrs := field.ErrorList{}
+	path := field.NewPath("spec", "backend")
+
+	if (backend.NetBox == nil) == (backend.Infoblox == nil) {
+		allErrs = append(allErrs,
+			field.Invalid(path, "", "exactly one of netbox and infoblox must be given"),
+		)
+		return allErrs
+	}
+	if backend.NetBox != nil {
+		allErrs = append(allErrs, validateBackendURL(path.Child("netbox", "url"), backend.NetBox.URL)...)
+		allErrs = append(allErrs, validatePositiveDuration(path.Child("netbox", "timeout"), backend.NetBox.Timeout)...)
+	}
+	if backend.Infoblox != nil {
+		allErrs = append(allErrs, validateBackendURL(path.Child("infoblox", "url"), backend.Infoblox.URL)...)
+		allErrs = append(allErrs, validatePositiveDuration(path.Child("infoblox", "timeout"), backend.Infoblox.Timeout)...)
+		if backend.Infoblox.CredentialsSecret.Name == "" {
+			allErrs = append(allErrs,
+				field.Required(path.Child("infoblox", "credentialsSecret", "name"), "the credentials are required"),
+			)
+		}
+		if backend.Infoblox.CredentialsSecret.Namespace == "" && c.Namespace == "" {
+			allErrs = append(allErrs,
+				field.Required(path.Child("infoblox", "credentialsSecret", "namespace"),
+					"the namespace of the credentials is required in a ClusterIPPool",
+				),
+			)
+		}
+	}
+	if c.Spec.ExternallyManaged {
+		allErrs = append(allErrs,
+			field.Forbidden(path, "the addresses of an externally managed pool are not allocated"),
+		)
+	}
+	if c.Spec.DelegatedPrefix != 0 {
+		allErrs = append(allErrs,
+			field.Forbidden(path, "the delegated prefixes cannot be reserved in a backend"),
+		)
+	}
+	return allErrs
+}
+
+// validateReleaseSettings verifies the retention of the sticky allocations
+// and the quarantine period, that are only possible if the pool allocates the
+// addresses
+func (c *IPPool) validateReleaseSettings() field.ErrorList {
+	allErrs := field.ErrorList{}
+	durations := []struct {
+		name  string
+		value *metav1.Duration
+	}{
+		{"stickyAllocationRetention", c.Spec.StickyAllocationRetention},
+		{"quarantinePeriod", c.Spec.QuarantinePeriod},
+	}
+	for _, duration := range durations {
+		path := field.NewPath("spec", duration.name)
+		allErrs = append(allErrs, validateNonNegativeDuration(path, duration.value)...)
+		if c.Spec.ExternallyManaged && duration.value != nil && duration.value.Duration != 0 {
+			allErrs = append(allErrs,
+				field.Forbidden(path, "the addresses of an externally managed pool are not allocated"),
+			)
+		}
+	}
+	return allErrs
+}
+
+// validateDrain verifies that a pool is drained into another IPPool. The
+// ClusterIPPools and the externally managed pools can not be drained.
+func (c *IPPool) validateDrain() field.ErrorList {
+	if c.Spec.Drain == nil {
+		return nil
+	}
+	allErrs := field.ErrorList{}
+	path := field.NewPath("spec", "drain")
+	if c.Namespace == "" {
+		allErrs = append(allErrs,
+			field.Forbidden(path, "a ClusterIPPool can not be drained"),
+		)
+	}
+	if c.Spec.ExternallyManaged {
+		allErrs = append(allErrs,
+			field.Forbidden(path, "the addresses of an externally managed pool are not allocated"),
+		)
+	}
+	if c.Spec.Drain.TargetPool == "" {
+		allErrs = append(allErrs,
+			field.Required(path.Child("targetPool"), "the target pool is required"),
+		)
+	} else if c.Spec.Drain.TargetPool == c.Name {
+		allErrs = append(allErrs,
+			field.Invalid(path.Child("targetPool"), c.Spec.Drain.TargetPool,
+				"a pool can not be drained into itself",
+			),
+		)
+	}
+	return allErrs
+}
+
+// validateFallbackPools verifies that the fallback pools are other IPPools,
+// given once. The ClusterIPPools and the externally managed pools have no
+// fallback pools.
+func (c *IPPool) validateFallbackPools() field.ErrorList {
+	if len(c.Spec.FallbackPools) == 0 {
+		return nil
+	}
+	allErrs := field.ErrorList{}
+	path := field.NewPath("spec", "fallbackPools")
+	if c.Namespace == "" {
+		allErrs = append(allErrs,
+			field.Forbidden(path, "a ClusterIPPool has no fallback pools"),
+		)
+	}
+	if c.Spec.ExternallyManaged {
+		allErrs = append(allErrs,
+			field.Forbidden(path, "the addresses of an externally managed pool are not allocated"),
+		)
+	}
+	seen := map[string]bool{}
+	for i, name := range c.Spec.FallbackPools {
+		switch {
+		case name == "":
+			allErrs = append(allErrs, field.Required(path.Index(i), "the name of the pool is required"))
+		case name == c.Name:
+			allErrs = append(allErrs, field.Invalid(path.Index(i), name, "a pool can not fall back to itself"))
+		case seen[name]:
+			allErrs = append(allErrs, field.Duplicate(path.Index(i), name))
+		}
+		seen[name] = true
+	}
+	return allErrs
+}
+
+// validateBackendURL verifies that the URL of a backend is an http or https
+// URL
+func validateBackendURL(path *field.Path, backendURL string) field.ErrorList {
+	parsedURL, err := url.Parse(backendURL)
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || parsedURL.Host == "" {
+		return field.ErrorList{field.Invalid(path, backendURL, "must be an http or https URL")}
+	}
+	return nil
+}
+
+// validateNetworkSettings verifies that the gateways and DNS servers are IP
+// addresses of the family of the pools they apply to. The default settings
+// are only verified against the family of the pools if all the pools are of
+// the same family.
+func (c *IPPool) validateNetworkSettings() field.ErrorList {
+	allErrs := field.ErrorList{}
+	specFamily := 0
+	for i, pool := range c.Spec.Pools {
+		allErrs = append(allErrs, validatePoolBounds(
+			field.NewPath("spec", "pools").Index(i), pool,
+		)...)
+		family := 0
+		if poolRange, err := NewPoolRange(pool); err == nil {
+			family = ipFamily(poolRange.start)
+		}
+		if i == 0 {
+			specFamily = family
+		} else if family != specFamily {
+			specFamily = 0
+		}
+		allErrs = append(allErrs, validateNetworkAddresses(
+			field.NewPath("spec", "pools").Index(i), pool.Gateway, pool.DNSServers, family,
+		)...)
+		allErrs = append(allErrs, validateDomains(
+			field.NewPath("spec", "pools").Index(i), pool.SearchDomains, pool.NTPServers, pool.DomainName,
+		)...)
+	}
+	allErrs = append(allErrs, validateNetworkAddresses(
+		field.NewPath("spec"), c.Spec.Gateway, c.Spec.DNSServers, specFamily,
+	)...)
+	allErrs = append(allErrs, validateDomains(
+		field.NewPath("spec"), c.Spec.SearchDomains, c.Spec.NTPServers, c.Spec.DomainName,
+	)...)
+	return allErrs
+}
+
+// validateGateways verifies that the gateways are in the network of the pools
+// they apply to, unless they are marked off-subnet. The network of a pool is
+// its subnet or, without subnet, the network of its start with its prefix or
+// the prefix of the IPPool. The default gateway only applies to the pools
+// without gateway whose subnet, if given, contains it, so it must be in the
+// subnet of one of them if they all have one.
+func (c *IPPool) validateGateways() field.ErrorList {
+	allErrs := field.ErrorList{}
+	specGateway := c.Spec.Gateway
+	specGatewayApplied, specGatewayChecked := false, false
+	for i, pool := range c.Spec.Pools {
+		path := field.NewPath("spec", "pools").Index(i)
+		poolRange, err := NewPoolRange(pool)
+		if err != nil {
+			continue
+		}
+		network := poolRange.ipNet
+		if network == nil {
+			prefix := pool.Prefix
+			if prefix == 0 {
+				prefix = c.Spec.Prefix
+			}
+			bitsLen := 8 * net.IPv6len
+			if ipFamily(poolRange.start) == 4 {
+				bitsLen = 8 * net.IPv4len
+			}
+			if prefix != 0 && prefix <= bitsLen {
+				mask := net.CIDRMask(prefix, bitsLen)
+				network = &net.IPNet{IP: poolRange.start.Mask(mask), Mask: mask}
+			}
+		}
+		if pool.Gateway != nil {
+			gateway := net.ParseIP(string(*pool.Gateway))
+			if !pool.OffSubnetGateway && network != nil && gateway != nil &&
+				ipFamily(gateway) == ipFamily(poolRange.start) && !network.Contains(gateway) {
+				allErrs = append(allErrs,
+					field.Invalid(path.Child("gateway"), *pool.Gateway,
+						fmt.Sprintf("is not in the network %s of the pool, set offSubnetGateway to allow it", network),
+					),
+				)
+			}
+			continue
+		}
+		if specGateway == nil || c.Spec.OffSubnetGateway {
+			continue
+		}
+		gateway := net.ParseIP(string(*specGateway))
+		if gateway == nil || ipFamily(gateway) != ipFamily(poolRange.start) {
+			continue
+		}
+		if poolRange.ipNet != nil {
+			specGatewayChecked = true
+			specGatewayApplied = specGatewayApplied || poolRange.ipNet.Contains(gateway)
+			continue
+		}
+		specGatewayApplied = true
+		if network != nil && !network.Contains(gateway) {
+			allErrs = append(allErrs,
+				field.Invalid(field.NewPath("spec", "gateway"), *specGateway,
+					fmt.Sprintf("is not in the network %s of %s, set offSubnetGateway to allow it", network, path),
+				),
+			)
+		}
+	}
+	if specGatewayChecked && !specGatewayApplied {
+		allErrs = append(allErrs,
+			field.Invalid(field.NewPath("spec", "gateway"), *specGateway,
+				"is not in the subnet of any pool, set offSubnetGateway to allow it",
+			),
+		)
+	}
+	return allErrs
+}
+
+// validatePoolBounds verifies that the start, end and subnet of the pool are
+// not IPv4-mapped IPv6 addresses
+func validatePoolBounds(path *field.Path, pool Pool) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if pool.Start != nil && isIPv4Mapped(string(*pool.Start)) {
+		allErrs = append(allErrs,
+			field.Invalid(path.Child("start"), *pool.Start, ipv4MappedMsg),
+		)
+	}
+	if pool.End != nil && isIPv4Mapped(string(*pool.End)) {
+		allErrs = append(allErrs,
+			field.Invalid(path.Child("end"), *pool.End, ipv4MappedMsg),
+		)
+	}
+	if pool.Subnet != nil && isIPv4Mapped(string(*pool.Subnet)) {
+		allErrs = append(allErrs,
+			field.Invalid(path.Child("subnet"), *pool.Subnet, ipv4MappedMsg),
+		)
+	}
+	return allErrs
+}
+
+// validateNetworkAddresses verifies the gateway and the DNS servers, with
+// family 0 accepting both IPv4 and IPv6 addresses
+func validateNetworkAddresses(path *field.Path, gateway *IPAddressStr,
+	dnsServers []IPAddressStr, family int,
+) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if gateway != nil {
+		if msg := validateIPAddressFamily(*gateway, family); msg != "" {
+			allErrs = append(allErrs,
+				field.Invalid(path.Child("gateway"), *gateway, msg),
+			)
+		}
+	}
+	for i, dnsServer := range dnsServers {
+		if msg := validateIPAddressFamily(dnsServer, family); msg != "" {
+			allErrs = append(allErrs,
+				field.Invalid(path.Child("dnsServers").Index(i), dnsServer, msg),
+			)
+		}
+	}
+	return allErrs
+}
+
+// validateDomains verifies that the search domains and the domain name are
+// valid domain names and that the ntp servers are IP addresses or valid host
+// names
+func validateDomains(path *field.Path, searchDomains []string,
+	ntpServers []string, domainName string,
+) field.ErrorList {
+	allErrs := field.ErrorList{}
+	for i, searchDomain := range searchDomains {
+		for _, msg := range validation.IsDNS1123Subdomain(searchDomain) {
+			allErrs = append(allErrs,
+				field.Invalid(path.Child("searchDomains").Index(i), searchDomain, msg),
+			)
+		}
+	}
+	for i, ntpServer := range ntpServers {
+		if net.ParseIP(ntpServer) != nil {
+			if isIPv4Mapped(ntpServer) {
+				allErrs = append(allErrs,
+					field.Invalid(path.Child("ntpServers").Index(i), ntpServer, ipv4MappedMsg),
+				)
+			}
+			continue
+		}
+		for _, msg := range validation.IsDNS1123Subdomain(ntpServer) {
+			allErrs = append(allErrs,
+				field.Invalid(path.Child("ntpServers").Index(i), ntpServer, msg),
+			)
+		}
+	}
+	if domainName != "" {
+		for _, msg := range validation.IsDNS1123Subdomain(domainName) {
+			allErrs = append(allErrs,
+				field.Invalid(path.Child("domainName"), domainName, msg),
+			)
+		}
+	}
+	return allErrs
+}
+
+// validateIPAddressFamily returns an error message if the address is not an IP
+// address of the given family
+func validateIPAddressFamily(address IPAddressStr, family int) string {
+	ip := net.ParseIP(string(address))
+	if ip == nil {
+		return "is not a valid IP address"
+	}
+	if isIPv4Mapped(string(address)) {
+		return ipv4MappedMsg
+	}
+	if family != 0 && ipFamily(ip) != family {
+		return fmt.Sprintf("is not an IPv%d address", family)
+	}
+	return ""
+}
+
+// ipv4MappedMsg is the error message of the IPv4-mapped IPv6 addresses, that
+// would be handled as IPv4 addresses in some places and as IPv6 addresses in
+// others
+const ipv4MappedMsg = "is an IPv4-mapped IPv6 address, use the IPv4 address instead"
+
+// isIPv4Mapped returns true if the address, or the address of a subnet in
+// CIDR notation, is an IPv4-mapped IPv6 address such as ::ffff:10.0.0.1
+func isIPv4Mapped(address string) bool {
+	address = strings.SplitN(address, "/", 2)[0]
+	ip := net.ParseIP(address)
+	return ip != nil && ip.To4() != nil && strings.Contains(address, ":")
+}
+
+// normalizeIPAddress returns the canonical form of the address, or the
+// address itself if it is invalid or IPv4-mapped
+func normalizeIPAddress(address IPAddressStr) IPAddressStr {
+	ip := net.ParseIP(string(address))
+	if ip == nil || isIPv4Mapped(string(address)) {
+		return address
+	}
+	return IPAddressStr(ip.String())
+}
+
+// normalizeIPAddressPtr returns a pointer to the canonical form of the
+// address, if given
+func normalizeIPAddressPtr(address *IPAddressStr) *IPAddressStr {
+	if address == nil {
+		return nil
+	}
+	normalized := normalizeIPAddress(*address)
+	return &normalized
+}
+
+// normalizeIPAddresses rewrites the addresses in their canonical form
+func normalizeIPAddresses(addresses []IPAddressStr) {
+	for i, address := range addresses {
+		addresses[i] = normalizeIPAddress(address)
+	}
+}
+
+// normalizeIPSubnetPtr returns a pointer to the subnet with its address in
+// canonical form, if given. The host bits of the address are kept, the start
+// of a pool being derived from them.
+func normalizeIPSubnetPtr(subnet *IPSubnetStr) *IPSubnetStr {
+	if subnet == nil {
+		return nil
+	}
+	parts := strings.SplitN(string(*subnet), "/", 2)
+	if _, _, err := net.ParseCIDR(string(*subnet)); err != nil || len(parts) != 2 {
+		return subnet
+	}
+	normalized := IPSubnetStr(string(normalizeIPAddress(IPAddressStr(parts[0]))) + "/" + parts[1])
+	return &normalized
+}
+
+// ipFamily returns 4 for an IPv4 address and 6 for an IPv6 address
+func ipFamily(ip net.IP) int {
+	if ip.To4() != nil {
+		return 4
+	}
+	return 6
+}
@@ -14,10 +14,22 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"context"
+	"encoding/json"
 	"testing"
+	"time"
 
 	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 )
 
 func TestIPPoolDefault(t *testing.T) {
@@ -35,6 +47,203 @@ func TestIPPoolDefault(t *testing.T) {
 	g.Expect(c.Status).To(Equal(IPPoolStatus{}))
 }
 
+func TestIPPoolDefaultIPAMConfig(t *testing.T) {
+	s := runtime.NewScheme()
+	if err := AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+	ipamConfig := &IPAMConfig{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      IPAMConfigName,
+			Namespace: "foo",
+		},
+		Spec: IPAMConfigSpec{
+			NamePrefix:         "{name}-addr",
+			DNSServers:         []IPAddressStr{"8.8.8.8"},
+			DomainName:         "example.com",
+			ReclaimPolicy:      ReclaimPolicyRetain,
+			AllocationStrategy: AllocationStrategyRandom,
+		},
+	}
+
+	tests := []struct {
+		name         string
+		namespace    string
+		spec         IPPoolSpec
+		expectedSpec IPPoolSpec
+	}{
+		{
+			name:      "should set the defaults of the IPAMConfig",
+			namespace: "foo",
+			expectedSpec: IPPoolSpec{
+				NamePrefix:         "abc-addr",
+				DNSServers:         []IPAddressStr{"8.8.8.8"},
+				DomainName:         "example.com",
+				ReclaimPolicy:      ReclaimPolicyRetain,
+				AllocationStrategy: AllocationStrategyRandom,
+			},
+		},
+		{
+			name:      "should not override the settings of the IPPool",
+			namespace: "foo",
+			spec: IPPoolSpec{
+				NamePrefix:         "abc",
+				DNSServers:         []IPAddressStr{"8.8.4.4"},
+				ReclaimPolicy:      ReclaimPolicyDelete,
+				AllocationStrategy: AllocationStrategyLeastRecentlyUsed,
+			},
+			expectedSpec: IPPoolSpec{
+				NamePrefix:         "abc",
+				DNSServers:         []IPAddressStr{"8.8.4.4"},
+				DomainName:         "example.com",
+				ReclaimPolicy:      ReclaimPolicyDelete,
+				AllocationStrategy: AllocationStrategyLeastRecentlyUsed,
+			},
+		},
+		{
+			name:      "should not set defaults without IPAMConfig",
+			namespace: "bar",
+			spec: IPPoolSpec{
+				NamePrefix: "abc",
+			},
+			expectedSpec: IPPoolSpec{
+				NamePrefix: "abc",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			ipPoolWebhookReader = fake.NewClientBuilder().WithScheme(s).WithObjects(ipamConfig).Build()
+			defer func() { ipPoolWebhookReader = nil }()
+
+			c := &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "abc",
+					Namespace: tt.namespace,
+				},
+				Spec: tt.spec,
+			}
+			c.Default()
+
+			g.Expect(c.Spec).To(Equal(tt.expectedSpec))
+		})
+	}
+}
+
+func TestIPPoolDefaultValues(t *testing.T) {
+	tests := []struct {
+		name         string
+		spec         IPPoolSpec
+		expectedSpec IPPoolSpec
+	}{
+		{
+			name: "should default the name prefix to the pool name",
+			expectedSpec: IPPoolSpec{
+				NamePrefix: "abc",
+			},
+		},
+		{
+			name: "should normalize the addresses",
+			spec: IPPoolSpec{
+				NamePrefix: "abc",
+				Pools: []Pool{
+					{
+						Start:      ipAddressStrPtr("2001:DB8::0010"),
+						End:        ipAddressStrPtr("2001:db8:0::20"),
+						Subnet:     (*IPSubnetStr)(pointer.StringPtr("2001:DB8::/64")),
+						Gateway:    ipAddressStrPtr("2001:DB8::1"),
+						DNSServers: []IPAddressStr{"2001:DB8::53"},
+						Exclude: []ExcludedRange{
+							{Start: "2001:DB8::12", End: ipAddressStrPtr("2001:DB8::0013")},
+						},
+					},
+				},
+				Gateway:         ipAddressStrPtr("2001:DB8::1"),
+				DNSServers:      []IPAddressStr{"2001:DB8::53"},
+				PreAllocations:  map[string]IPAddressStr{"abc-1": "2001:DB8::0011"},
+				MACReservations: map[string]IPAddressStr{"00:00:5e:10:00:01": "2001:DB8::0014"},
+			},
+			expectedSpec: IPPoolSpec{
+				NamePrefix: "abc",
+				Pools: []Pool{
+					{
+						Start:      ipAddressStrPtr("2001:db8::10"),
+						End:        ipAddressStrPtr("2001:db8::20"),
+						Subnet:     (*IPSubnetStr)(pointer.StringPtr("2001:db8::/64")),
+						Gateway:    ipAddressStrPtr("2001:db8::1"),
+						DNSServers: []IPAddressStr{"2001:db8::53"},
+						Exclude: []ExcludedRange{
+							{Start: "2001:db8::12", End: ipAddressStrPtr("2001:db8::13")},
+						},
+					},
+				},
+				Gateway:         ipAddressStrPtr("2001:db8::1"),
+				DNSServers:      []IPAddressStr{"2001:db8::53"},
+				PreAllocations:  map[string]IPAddressStr{"abc-1": "2001:db8::11"},
+				MACReservations: map[string]IPAddressStr{"00:00:5e:10:00:01": "2001:db8::14"},
+			},
+		},
+		{
+			name: "should keep the host bits of the subnet",
+			spec: IPPoolSpec{
+				NamePrefix: "abc",
+				Pools: []Pool{
+					{Subnet: (*IPSubnetStr)(pointer.StringPtr("2001:DB8::10/64"))},
+				},
+			},
+			expectedSpec: IPPoolSpec{
+				NamePrefix: "abc",
+				Pools: []Pool{
+					{Subnet: (*IPSubnetStr)(pointer.StringPtr("2001:db8::10/64"))},
+				},
+			},
+		},
+		{
+			name: "should not normalize invalid or IPv4-mapped addresses",
+			spec: IPPoolSpec{
+				NamePrefix: "abc",
+				Pools: []Pool{
+					{
+						Start:  ipAddressStrPtr("::FFFF:192.168.0.1"),
+						Subnet: (*IPSubnetStr)(pointer.StringPtr("2001:DB8::/129")),
+					},
+				},
+				Gateway: ipAddressStrPtr("2001:DB8::G"),
+			},
+			expectedSpec: IPPoolSpec{
+				NamePrefix: "abc",
+				Pools: []Pool{
+					{
+						Start:  ipAddressStrPtr("::FFFF:192.168.0.1"),
+						Subnet: (*IPSubnetStr)(pointer.StringPtr("2001:DB8::/129")),
+					},
+				},
+				Gateway: ipAddressStrPtr("2001:DB8::G"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			c := &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "abc",
+					Namespace: "foo",
+				},
+				Spec: tt.spec,
+			}
+			c.Default()
+
+			g.Expect(c.Spec).To(Equal(tt.expectedSpec))
+		})
+	}
+}
+
 func TestIPPoolValidation(t *testing.T) {
 
 	tests := []struct {
@@ -43,13 +252,1563 @@ func TestIPPoolValidation(t *testing.T) {
 		c         *IPPool
 	}{
 		{
-			name:      "should succeed when values and templates correct",
+			name:      "should succeed when values and templates correct",
+			expectErr: false,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{},
+			},
+		},
+		{
+			name:      "should succeed with valid gateways and dns servers",
+			expectErr: false,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{
+							Start:      ipAddressStrPtr("192.168.0.10"),
+							Gateway:    ipAddressStrPtr("192.168.0.1"),
+							DNSServers: []IPAddressStr{"8.8.8.8"},
+						},
+					},
+					Gateway:    ipAddressStrPtr("192.168.0.1"),
+					DNSServers: []IPAddressStr{"8.8.8.8", "8.8.4.4"},
+				},
+			},
+		},
+		{
+			name:      "should fail with an invalid gateway",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Gateway: ipAddressStrPtr("192.168.0"),
+				},
+			},
+		},
+		{
+			name:      "should fail with an IPv4-mapped gateway",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Gateway: ipAddressStrPtr("::ffff:192.168.0.1"),
+				},
+			},
+		},
+		{
+			name:      "should fail with an IPv4-mapped pool start",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{
+							Start: ipAddressStrPtr("::ffff:192.168.0.10"),
+							End:   ipAddressStrPtr("192.168.0.20"),
+						},
+					},
+				},
+			},
+		},
+		{
+			name:      "should fail with an IPv4-mapped pool subnet",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{
+							Subnet: (*IPSubnetStr)(pointer.StringPtr("::ffff:192.168.0.0/120")),
+						},
+					},
+				},
+			},
+		},
+		{
+			name:      "should fail with an IPv4-mapped preAllocation",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{
+							Start: ipAddressStrPtr("192.168.0.10"),
+							End:   ipAddressStrPtr("192.168.0.20"),
+						},
+					},
+					PreAllocations: map[string]IPAddressStr{
+						"abc": "::ffff:192.168.0.11",
+					},
+				},
+			},
+		},
+		{
+			name:      "should fail with an IPv4-mapped ntp server",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					NTPServers: []string{"::ffff:192.168.0.1"},
+				},
+			},
+		},
+		{
+			name:      "should fail with an invalid dns server",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					DNSServers: []IPAddressStr{"8.8.8.8", "dns.example.com"},
+				},
+			},
+		},
+		{
+			name:      "should succeed with preAllocations in the pools",
+			expectErr: false,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{
+							Start: ipAddressStrPtr("192.168.0.10"),
+							End:   ipAddressStrPtr("192.168.0.20"),
+						},
+						{
+							Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.1.0/24")),
+						},
+					},
+					PreAllocations: map[string]IPAddressStr{
+						"abc": "192.168.0.20",
+						"bcd": "192.168.1.200",
+					},
+				},
+			},
+		},
+		{
+			name:      "should fail with a preAllocation out of the pools",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{
+							Start: ipAddressStrPtr("192.168.0.10"),
+							End:   ipAddressStrPtr("192.168.0.20"),
+						},
+					},
+					PreAllocations: map[string]IPAddressStr{
+						"abc": "192.168.0.21",
+					},
+				},
+			},
+		},
+		{
+			name:      "should fail with a preAllocation out of the pool subnet",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{
+							Start:  ipAddressStrPtr("192.168.0.10"),
+							Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24")),
+						},
+					},
+					PreAllocations: map[string]IPAddressStr{
+						"abc": "192.168.1.10",
+					},
+				},
+			},
+		},
+		{
+			name:      "should fail with a preAllocation on the broadcast address of a pool subnet",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{
+							Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24")),
+						},
+					},
+					PreAllocations: map[string]IPAddressStr{
+						"abc": "192.168.0.255",
+					},
+				},
+			},
+		},
+		{
+			name:      "should fail with a pool gateway out of the pool subnet",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{
+							Subnet:  (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24")),
+							Gateway: ipAddressStrPtr("192.168.1.1"),
+						},
+					},
+				},
+			},
+		},
+		{
+			name:      "should succeed with a pool gateway marked off-subnet",
+			expectErr: false,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{
+							Subnet:           (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24")),
+							Gateway:          ipAddressStrPtr("192.168.1.1"),
+							OffSubnetGateway: true,
+						},
+					},
+				},
+			},
+		},
+		{
+			name:      "should fail with a default gateway out of the subnet of all the pools",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{
+							Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24")),
+						},
+						{
+							Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.1.0/24")),
+						},
+					},
+					Gateway: ipAddressStrPtr("192.168.2.1"),
+				},
+			},
+		},
+		{
+			name:      "should succeed with a default gateway in the subnet of a pool",
+			expectErr: false,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{
+							Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24")),
+						},
+						{
+							Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.1.0/24")),
+						},
+					},
+					Gateway: ipAddressStrPtr("192.168.1.1"),
+				},
+			},
+		},
+		{
+			name:      "should fail with a default gateway out of the network of a pool without subnet",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{
+							Start: ipAddressStrPtr("192.168.0.10"),
+							End:   ipAddressStrPtr("192.168.0.20"),
+						},
+					},
+					Prefix:  24,
+					Gateway: ipAddressStrPtr("192.168.1.1"),
+				},
+			},
+		},
+		{
+			name:      "should succeed with a default gateway marked off-subnet",
+			expectErr: false,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{
+							Start: ipAddressStrPtr("192.168.0.10"),
+							End:   ipAddressStrPtr("192.168.0.20"),
+						},
+					},
+					Prefix:           24,
+					Gateway:          ipAddressStrPtr("192.168.1.1"),
+					OffSubnetGateway: true,
+				},
+			},
+		},
+		{
+			name:      "should fail with a pool gateway of another family",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{
+							Subnet:  (*IPSubnetStr)(pointer.StringPtr("2001:db8::/64")),
+							Gateway: ipAddressStrPtr("192.168.0.1"),
+						},
+					},
+				},
+			},
+		},
+		{
+			name:      "should fail with a default dns server of another family",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{
+							Start: ipAddressStrPtr("192.168.0.10"),
+						},
+					},
+					DNSServers: []IPAddressStr{"2001:db8::53"},
+				},
+			},
+		},
+		{
+			name:      "should succeed with valid search domains",
+			expectErr: false,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{
+							Start:         ipAddressStrPtr("192.168.0.10"),
+							SearchDomains: []string{"rack1.example.com"},
+						},
+					},
+					SearchDomains: []string{"example.com", "cluster.local"},
+				},
+			},
+		},
+		{
+			name:      "should fail with an invalid search domain",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{
+							Start:         ipAddressStrPtr("192.168.0.10"),
+							SearchDomains: []string{"Example_com"},
+						},
+					},
+				},
+			},
+		},
+		{
+			name:      "should succeed with valid ntp servers and domain name",
+			expectErr: false,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{
+							Start:      ipAddressStrPtr("192.168.0.10"),
+							NTPServers: []string{"192.168.0.1"},
+							DomainName: "rack1.example.com",
+						},
+					},
+					NTPServers: []string{"ntp.example.com", "2001:db8::123"},
+					DomainName: "example.com",
+				},
+			},
+		},
+		{
+			name:      "should fail with an invalid ntp server",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					NTPServers: []string{"ntp server"},
+				},
+			},
+		},
+		{
+			name:      "should fail with an invalid domain name",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{
+							Start:      ipAddressStrPtr("192.168.0.10"),
+							DomainName: "-example.com",
+						},
+					},
+				},
+			},
+		},
+		{
+			name:      "should succeed with default settings of any family for dual-stack pools",
+			expectErr: false,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{
+							Start: ipAddressStrPtr("192.168.0.10"),
+						},
+						{
+							Start:      ipAddressStrPtr("2001:db8::10"),
+							DNSServers: []IPAddressStr{"2001:db8::53"},
+						},
+					},
+					DNSServers: []IPAddressStr{"2001:db8::53", "8.8.8.8"},
+				},
+			},
+		},
+		{
+			name:      "should succeed with a release hook URL",
+			expectErr: false,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					ReleaseHook: &ReleaseHook{
+						URL:     "https://dns.example.com/deregister",
+						Timeout: &metav1.Duration{Duration: time.Minute},
+					},
+				},
+			},
+		},
+		{
+			name:      "should succeed with a release hook job",
+			expectErr: false,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					ReleaseHook: &ReleaseHook{
+						Job: &batchv1.JobTemplateSpec{
+							Spec: batchv1.JobSpec{
+								Template: corev1.PodTemplateSpec{
+									Spec: corev1.PodSpec{
+										Containers: []corev1.Container{{Name: "deregister", Image: "deregister"}},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name:      "should fail with a release hook without URL nor job",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					ReleaseHook: &ReleaseHook{},
+				},
+			},
+		},
+		{
+			name:      "should fail with a release hook with both URL and job",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					ReleaseHook: &ReleaseHook{
+						URL: "https://dns.example.com/deregister",
+						Job: &batchv1.JobTemplateSpec{
+							Spec: batchv1.JobSpec{
+								Template: corev1.PodTemplateSpec{
+									Spec: corev1.PodSpec{
+										Containers: []corev1.Container{{Name: "deregister", Image: "deregister"}},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name:      "should fail with an invalid release hook URL",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					ReleaseHook: &ReleaseHook{
+						URL: "dns.example.com/deregister",
+					},
+				},
+			},
+		},
+		{
+			name:      "should fail with a zero release hook timeout",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					ReleaseHook: &ReleaseHook{
+						URL:     "https://dns.example.com/deregister",
+						Timeout: &metav1.Duration{},
+					},
+				},
+			},
+		},
+		{
+			name:      "should fail with a release hook job without container",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					ReleaseHook: &ReleaseHook{
+						Job: &batchv1.JobTemplateSpec{},
+					},
+				},
+			},
+		},
+		{
+			name:      "should succeed with a NetBox backend",
+			expectErr: false,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Backend: &Backend{
+						NetBox: &NetBoxBackend{
+							URL:     "https://netbox.example.com",
+							Tags:    []string{"metal3"},
+							Timeout: &metav1.Duration{Duration: time.Minute},
+						},
+					},
+				},
+			},
+		},
+		{
+			name:      "should fail with a backend without driver",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Backend: &Backend{},
+				},
+			},
+		},
+		{
+			name:      "should fail with an invalid NetBox URL",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Backend: &Backend{
+						NetBox: &NetBoxBackend{URL: "netbox.example.com"},
+					},
+				},
+			},
+		},
+		{
+			name:      "should succeed with an Infoblox backend",
+			expectErr: false,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Backend: &Backend{
+						Infoblox: &InfobloxBackend{
+							URL:               "https://infoblox.example.com/wapi/v2.11",
+							CredentialsSecret: corev1.SecretReference{Name: "infoblox"},
+						},
+					},
+				},
+			},
+		},
+		{
+			name:      "should fail with both NetBox and Infoblox backends",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Backend: &Backend{
+						NetBox: &NetBoxBackend{URL: "https://netbox.example.com"},
+						Infoblox: &InfobloxBackend{
+							URL:               "https://infoblox.example.com/wapi/v2.11",
+							CredentialsSecret: corev1.SecretReference{Name: "infoblox"},
+						},
+					},
+				},
+			},
+		},
+		{
+			name:      "should fail with an Infoblox backend without credentials",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Backend: &Backend{
+						Infoblox: &InfobloxBackend{
+							URL: "https://infoblox.example.com/wapi/v2.11",
+						},
+					},
+				},
+			},
+		},
+		{
+			name:      "should fail with an Infoblox backend without credentials namespace in a ClusterIPPool",
+			expectErr: true,
+			c: (&ClusterIPPool{
+				Spec: IPPoolSpec{
+					Backend: &Backend{
+						Infoblox: &InfobloxBackend{
+							URL:               "https://infoblox.example.com/wapi/v2.11",
+							CredentialsSecret: corev1.SecretReference{Name: "infoblox"},
+						},
+					},
+				},
+			}).AsIPPool(),
+		},
+		{
+			name:      "should fail with a backend on an externally managed pool",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					ExternallyManaged: true,
+					Backend: &Backend{
+						NetBox: &NetBoxBackend{URL: "https://netbox.example.com"},
+					},
+				},
+			},
+		},
+		{
+			name:      "should fail with a backend delegating prefixes",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{Subnet: (*IPSubnetStr)(pointer.StringPtr("2001:db8::/48"))},
+					},
+					DelegatedPrefix: 56,
+					Backend: &Backend{
+						NetBox: &NetBoxBackend{URL: "https://netbox.example.com"},
+					},
+				},
+			},
+		},
+		{
+			name:      "should succeed with adjacent pools",
+			expectErr: false,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{Start: ipAddressStrPtr("192.168.0.10"), End: ipAddressStrPtr("192.168.0.19")},
+						{Start: ipAddressStrPtr("192.168.0.20"), End: ipAddressStrPtr("192.168.0.29")},
+						{Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.1.0/24"))},
+					},
+				},
+			},
+		},
+		{
+			name:      "should succeed with pools of different families",
+			expectErr: false,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24"))},
+						{Subnet: (*IPSubnetStr)(pointer.StringPtr("2001::/64"))},
+					},
+				},
+			},
+		},
+		{
+			name:      "should fail with overlapping pools",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{Start: ipAddressStrPtr("192.168.0.10"), End: ipAddressStrPtr("192.168.0.20")},
+						{Start: ipAddressStrPtr("192.168.0.20"), End: ipAddressStrPtr("192.168.0.29")},
+					},
+				},
+			},
+		},
+		{
+			name:      "should fail with a range overlapping a subnet",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24"))},
+						{Start: ipAddressStrPtr("192.168.0.100"), End: ipAddressStrPtr("192.168.0.110"), Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24"))},
+					},
+				},
+			},
+		},
+		{
+			name:      "should succeed with a dual-stack pool",
+			expectErr: false,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24"))},
+						{Subnet: (*IPSubnetStr)(pointer.StringPtr("2001:db8::/64"))},
+					},
+					DualStack: true,
+				},
+			},
+		},
+		{
+			name:      "should fail with a dual-stack pool without IPv6 pool",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24"))},
+						{Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.1.0/24"))},
+					},
+					DualStack: true,
+				},
+			},
+		},
+		{
+			name:      "should fail with a dual-stack pool delegating prefixes",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24"))},
+						{Subnet: (*IPSubnetStr)(pointer.StringPtr("2001:db8::/64"))},
+					},
+					DualStack:       true,
+					DelegatedPrefix: 29,
+				},
+			},
+		},
+		{
+			name:      "should fail with an externally managed dual-stack pool",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24"))},
+						{Subnet: (*IPSubnetStr)(pointer.StringPtr("2001:db8::/64"))},
+					},
+					DualStack:         true,
+					ExternallyManaged: true,
+				},
+			},
+		},
+		{
+			name:      "should succeed with the EUI64 strategy and an IPv6 /64 pool",
+			expectErr: false,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{Subnet: (*IPSubnetStr)(pointer.StringPtr("2001:db8::/64"))},
+					},
+					AllocationStrategy: AllocationStrategyEUI64,
+				},
+			},
+		},
+		{
+			name:      "should fail with the EUI64 strategy without IPv6 /64 pool",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{Subnet: (*IPSubnetStr)(pointer.StringPtr("2001:db8::/80"))},
+					},
+					AllocationStrategy: AllocationStrategyEUI64,
+				},
+			},
+		},
+		{
+			name:      "should fail with the EUI64 strategy delegating prefixes",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{Subnet: (*IPSubnetStr)(pointer.StringPtr("2001:db8::/64"))},
+					},
+					AllocationStrategy: AllocationStrategyEUI64,
+					DelegatedPrefix:    80,
+				},
+			},
+		},
+		{
+			name:      "should succeed with excluded ranges",
+			expectErr: false,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{
+							Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24")),
+							Exclude: []ExcludedRange{
+								{Start: "192.168.0.1"},
+								{Start: "192.168.0.10", End: ipAddressStrPtr("192.168.0.19")},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name:      "should fail with an excluded range out of the pool",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{
+							Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24")),
+							Exclude: []ExcludedRange{
+								{Start: "192.168.1.1"},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name:      "should fail with an excluded range ending before its start",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{
+							Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24")),
+							Exclude: []ExcludedRange{
+								{Start: "192.168.0.19", End: ipAddressStrPtr("192.168.0.10")},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name:      "should fail with overlapping excluded ranges",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{
+							Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24")),
+							Exclude: []ExcludedRange{
+								{Start: "192.168.0.10", End: ipAddressStrPtr("192.168.0.19")},
+								{Start: "192.168.0.15"},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name:      "should fail with an excluded pre-allocated address",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{
+							Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24")),
+							Exclude: []ExcludedRange{
+								{Start: "192.168.0.10", End: ipAddressStrPtr("192.168.0.19")},
+							},
+						},
+					},
+					PreAllocations: map[string]IPAddressStr{
+						"abc": "192.168.0.12",
+					},
+				},
+			},
+		},
+		{
+			name:      "should fail with excluded ranges and delegated prefixes",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{
+							Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24")),
+							Exclude: []ExcludedRange{
+								{Start: "192.168.0.1"},
+							},
+						},
+					},
+					DelegatedPrefix: 28,
+				},
+			},
+		},
+		{
+			name:      "should fail with a pre-allocated gateway",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24"))},
+					},
+					Gateway: ipAddressStrPtr("192.168.0.1"),
+					PreAllocations: map[string]IPAddressStr{
+						"abc": "192.168.0.1",
+					},
+				},
+			},
+		},
+		{
+			name:      "should succeed with a pre-allocated network address allocated by the pool",
+			expectErr: false,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{
+							Subnet:                      (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24")),
+							AllocateNetworkAndBroadcast: true,
+						},
+					},
+					PreAllocations: map[string]IPAddressStr{
+						"abc": "192.168.0.0",
+					},
+				},
+			},
+		},
+		{
+			name:      "should succeed with MAC reservations",
+			expectErr: false,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24"))},
+					},
+					MACReservations: map[string]IPAddressStr{
+						"52:54:00:aa:bb:01": "192.168.0.10",
+						"52:54:00:aa:bb:02": "192.168.0.11",
+					},
+				},
+			},
+		},
+		{
+			name:      "should fail with a MAC reservation not keyed by a MAC address",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24"))},
+					},
+					MACReservations: map[string]IPAddressStr{
+						"node-0": "192.168.0.10",
+					},
+				},
+			},
+		},
+		{
+			name:      "should fail with a MAC reservation keyed by an uppercase MAC address",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24"))},
+					},
+					MACReservations: map[string]IPAddressStr{
+						"52:54:00:AA:BB:01": "192.168.0.10",
+					},
+				},
+			},
+		},
+		{
+			name:      "should fail with a MAC reservation out of the pools",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24"))},
+					},
+					MACReservations: map[string]IPAddressStr{
+						"52:54:00:aa:bb:01": "192.168.1.10",
+					},
+				},
+			},
+		},
+		{
+			name:      "should fail with a MAC reservation of a pre-allocated address",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24"))},
+					},
+					PreAllocations: map[string]IPAddressStr{
+						"abc": "192.168.0.10",
+					},
+					MACReservations: map[string]IPAddressStr{
+						"52:54:00:aa:bb:01": "192.168.0.10",
+					},
+				},
+			},
+		},
+		{
+			name:      "should fail with an address reserved for two MAC addresses",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24"))},
+					},
+					MACReservations: map[string]IPAddressStr{
+						"52:54:00:aa:bb:01": "192.168.0.10",
+						"52:54:00:aa:bb:02": "192.168.0.10",
+					},
+				},
+			},
+		},
+		{
+			name:      "should fail with MAC reservations in an externally managed pool",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24"))},
+					},
+					ExternallyManaged: true,
+					MACReservations: map[string]IPAddressStr{
+						"52:54:00:aa:bb:01": "192.168.0.10",
+					},
+				},
+			},
+		},
+		{
+			name:      "should succeed with delegated prefixes",
+			expectErr: false,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24"))},
+					},
+					PreAllocations: map[string]IPAddressStr{
+						"abc": "192.168.0.0",
+						"bcd": "192.168.0.8",
+					},
+					DelegatedPrefix: 29,
+				},
+			},
+		},
+		{
+			name:      "should fail with delegated prefixes longer than the addresses",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24"))},
+					},
+					DelegatedPrefix: 64,
+				},
+			},
+		},
+		{
+			name:      "should fail with delegated prefixes larger than a pool",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{Start: ipAddressStrPtr("192.168.0.10"), End: ipAddressStrPtr("192.168.0.20")},
+					},
+					DelegatedPrefix: 29,
+				},
+			},
+		},
+		{
+			name:      "should fail with a pre-allocated prefix not aligned",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24"))},
+					},
+					PreAllocations: map[string]IPAddressStr{
+						"abc": "192.168.0.12",
+					},
+					DelegatedPrefix: 29,
+				},
+			},
+		},
+		{
+			name:      "should fail with a pool without start nor subnet",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{End: ipAddressStrPtr("192.168.0.20")},
+					},
+				},
+			},
+		},
+		{
+			name:      "should fail with an end without start",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{End: ipAddressStrPtr("192.168.0.20"), Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24"))},
+					},
+				},
+			},
+		},
+		{
+			name:      "should fail with an end before the start",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{Start: ipAddressStrPtr("192.168.0.20"), End: ipAddressStrPtr("192.168.0.10")},
+					},
+				},
+			},
+		},
+		{
+			name:      "should fail with an end of another family",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{Start: ipAddressStrPtr("192.168.0.10"), End: ipAddressStrPtr("2001::10")},
+					},
+				},
+			},
+		},
+		{
+			name:      "should fail with a start out of the subnet",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{Start: ipAddressStrPtr("192.168.1.10"), End: ipAddressStrPtr("192.168.1.20"), Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24"))},
+					},
+				},
+			},
+		},
+		{
+			name:      "should fail with an end out of the subnet",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Pools: []Pool{
+						{Start: ipAddressStrPtr("192.168.0.10"), End: ipAddressStrPtr("192.168.1.20"), Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24"))},
+					},
+				},
+			},
+		},
+		{
+			name:      "should fail with a negative lease duration",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					LeaseDuration: &metav1.Duration{Duration: -time.Hour},
+				},
+			},
+		},
+		{
+			name:      "should succeed with a sticky allocation retention",
+			expectErr: false,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					StickyAllocationRetention: &metav1.Duration{Duration: 24 * time.Hour},
+				},
+			},
+		},
+		{
+			name:      "should fail with a negative sticky allocation retention",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					StickyAllocationRetention: &metav1.Duration{Duration: -time.Hour},
+				},
+			},
+		},
+		{
+			name:      "should fail with sticky allocations in an externally managed pool",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					ExternallyManaged:         true,
+					StickyAllocationRetention: &metav1.Duration{Duration: time.Hour},
+				},
+			},
+		},
+		{
+			name:      "should succeed with a quarantine period",
+			expectErr: false,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					QuarantinePeriod: &metav1.Duration{Duration: 10 * time.Minute},
+				},
+			},
+		},
+		{
+			name:      "should fail with a negative quarantine period",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					QuarantinePeriod: &metav1.Duration{Duration: -time.Minute},
+				},
+			},
+		},
+		{
+			name:      "should fail with a quarantine in an externally managed pool",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					ExternallyManaged: true,
+					QuarantinePeriod:  &metav1.Duration{Duration: time.Minute},
+				},
+			},
+		},
+		{
+			name:      "should succeed with a drain into another pool",
+			expectErr: false,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "abc",
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Drain: &PoolDrain{TargetPool: "bcd"},
+				},
+			},
+		},
+		{
+			name:      "should fail with a drain without target pool",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "abc",
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Drain: &PoolDrain{},
+				},
+			},
+		},
+		{
+			name:      "should fail with a drain into the pool itself",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "abc",
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					Drain: &PoolDrain{TargetPool: "abc"},
+				},
+			},
+		},
+		{
+			name:      "should fail with a drain of an externally managed pool",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "abc",
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					ExternallyManaged: true,
+					Drain:             &PoolDrain{TargetPool: "bcd"},
+				},
+			},
+		},
+		{
+			name:      "should fail with a drain of a ClusterIPPool",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "abc",
+				},
+				Spec: IPPoolSpec{
+					Drain: &PoolDrain{TargetPool: "bcd"},
+				},
+			},
+		},
+		{
+			name:      "should succeed with fallback pools",
 			expectErr: false,
 			c: &IPPool{
 				ObjectMeta: metav1.ObjectMeta{
+					Name:      "abc",
 					Namespace: "foo",
 				},
-				Spec: IPPoolSpec{},
+				Spec: IPPoolSpec{
+					FallbackPools: []string{"bcd", "cde"},
+				},
+			},
+		},
+		{
+			name:      "should fail with an empty fallback pool",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "abc",
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					FallbackPools: []string{""},
+				},
+			},
+		},
+		{
+			name:      "should fail with the pool as its own fallback pool",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "abc",
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					FallbackPools: []string{"abc"},
+				},
+			},
+		},
+		{
+			name:      "should fail with a duplicate fallback pool",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "abc",
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					FallbackPools: []string{"bcd", "bcd"},
+				},
+			},
+		},
+		{
+			name:      "should fail with fallback pools in an externally managed pool",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "abc",
+					Namespace: "foo",
+				},
+				Spec: IPPoolSpec{
+					ExternallyManaged: true,
+					FallbackPools:     []string{"bcd"},
+				},
+			},
+		},
+		{
+			name:      "should fail with fallback pools in a ClusterIPPool",
+			expectErr: true,
+			c: &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "abc",
+				},
+				Spec: IPPoolSpec{
+					FallbackPools: []string{"bcd"},
+				},
 			},
 		},
 	}
@@ -69,6 +1828,83 @@ func TestIPPoolValidation(t *testing.T) {
 	}
 }
 
+func TestIPPoolDeleteValidation(t *testing.T) {
+	tests := []struct {
+		name      string
+		expectErr bool
+		policy    DeletionPolicy
+		status    IPPoolStatus
+	}{
+		{
+			name:   "should succeed without policy",
+			status: IPPoolStatus{Allocations: map[string]IPAddressStr{"bcd": "192.168.0.10"}},
+		},
+		{
+			name:   "should succeed with Block policy and no allocation",
+			policy: DeletionPolicyBlock,
+		},
+		{
+			name:      "should fail with Block policy and allocations",
+			expectErr: true,
+			policy:    DeletionPolicyBlock,
+			status:    IPPoolStatus{Allocations: map[string]IPAddressStr{"bcd": "192.168.0.10"}},
+		},
+		{
+			name:      "should fail with Block policy and compacted allocations",
+			expectErr: true,
+			policy:    DeletionPolicyBlock,
+			status:    IPPoolStatus{AllocatedRanges: []string{"192.168.0.10-192.168.0.12"}},
+		},
+		{
+			name:      "should fail with Block policy and sharded allocations",
+			expectErr: true,
+			policy:    DeletionPolicyBlock,
+			status:    IPPoolStatus{AllocationShards: 2},
+		},
+		{
+			name:   "should succeed with Orphan policy and allocations",
+			policy: DeletionPolicyOrphan,
+			status: IPPoolStatus{Allocations: map[string]IPAddressStr{"bcd": "192.168.0.10"}},
+		},
+		{
+			name:   "should succeed with Cascade policy and allocations",
+			policy: DeletionPolicyCascade,
+			status: IPPoolStatus{Allocations: map[string]IPAddressStr{"bcd": "192.168.0.10"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			ipPool := &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "abc",
+					Namespace: "foo",
+				},
+				Spec:   IPPoolSpec{DeletionPolicy: tt.policy},
+				Status: tt.status,
+			}
+			clusterIPPool := &ClusterIPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "abc",
+				},
+				Spec:   ipPool.Spec,
+				Status: tt.status,
+			}
+
+			if tt.expectErr {
+				err := ipPool.ValidateDelete()
+				g.Expect(apierrors.IsForbidden(err)).To(BeTrue())
+				g.Expect(clusterIPPool.ValidateDelete()).NotTo(Succeed())
+			} else {
+				g.Expect(ipPool.ValidateDelete()).To(Succeed())
+				g.Expect(clusterIPPool.ValidateDelete()).To(Succeed())
+			}
+		})
+	}
+}
+
 func TestIPPoolUpdateValidation(t *testing.T) {
 
 	startAddr := IPAddressStr("192.168.0.1")
@@ -80,100 +1916,360 @@ func TestIPPoolUpdateValidation(t *testing.T) {
 		newPoolSpec   *IPPoolSpec
 		oldPoolSpec   *IPPoolSpec
 		oldPoolStatus IPPoolStatus
+		shards        []client.Object
 	}{
 		{
-			name:        "should succeed when values and templates correct",
-			expectErr:   false,
-			newPoolSpec: &IPPoolSpec{},
-			oldPoolSpec: &IPPoolSpec{},
+			name:        "should succeed when values and templates correct",
+			expectErr:   false,
+			newPoolSpec: &IPPoolSpec{},
+			oldPoolSpec: &IPPoolSpec{},
+		},
+		{
+			name:        "should fail when oldPoolSpec is nil",
+			expectErr:   true,
+			newPoolSpec: &IPPoolSpec{},
+			oldPoolSpec: nil,
+		},
+		{
+			name:      "should fail when namePrefix value changes",
+			expectErr: true,
+			newPoolSpec: &IPPoolSpec{
+				NamePrefix: "abcde",
+			},
+			oldPoolSpec: &IPPoolSpec{
+				NamePrefix: "abcd",
+			},
+		},
+		{
+			name:      "should succeed when preAllocations are correct",
+			expectErr: false,
+			newPoolSpec: &IPPoolSpec{
+				NamePrefix: "abcd",
+				Pools: []Pool{
+					{Start: &startAddr, End: &endAddr},
+				},
+				PreAllocations: map[string]IPAddressStr{
+					"alloc": IPAddressStr("192.168.0.2"),
+				},
+			},
+			oldPoolSpec: &IPPoolSpec{
+				NamePrefix: "abcd",
+			},
+			oldPoolStatus: IPPoolStatus{
+				Allocations: map[string]IPAddressStr{
+					"inuse": IPAddressStr("192.168.0.3"),
+				},
+			},
+		},
+		{
+			name:      "should fail when preAllocations are incorrect",
+			expectErr: true,
+			newPoolSpec: &IPPoolSpec{
+				NamePrefix: "abcd",
+				Pools: []Pool{
+					{Start: &startAddr, End: &endAddr},
+				},
+				PreAllocations: map[string]IPAddressStr{
+					"alloc": IPAddressStr("192.168.0.20"),
+				},
+			},
+			oldPoolSpec: &IPPoolSpec{
+				NamePrefix: "abcd",
+			},
+			oldPoolStatus: IPPoolStatus{
+				Allocations: map[string]IPAddressStr{
+					"inuse": IPAddressStr("192.168.0.3"),
+				},
+			},
+		},
+		{
+			name:      "should fail with an invalid gateway",
+			expectErr: true,
+			newPoolSpec: &IPPoolSpec{
+				NamePrefix: "abcd",
+				Gateway:    ipAddressStrPtr("192.168.0.256"),
+			},
+			oldPoolSpec: &IPPoolSpec{
+				NamePrefix: "abcd",
+			},
+		},
+		{
+			name:      "should fail when the gateway is moved out of the pool subnets",
+			expectErr: true,
+			newPoolSpec: &IPPoolSpec{
+				NamePrefix: "abcd",
+				Pools: []Pool{
+					{Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24"))},
+				},
+				Gateway: ipAddressStrPtr("192.168.1.1"),
+			},
+			oldPoolSpec: &IPPoolSpec{
+				NamePrefix: "abcd",
+				Pools: []Pool{
+					{Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24"))},
+				},
+				Gateway: ipAddressStrPtr("192.168.0.1"),
+			},
+		},
+		{
+			name:      "should succeed with an unmodified gateway out of the pool subnets",
+			expectErr: false,
+			newPoolSpec: &IPPoolSpec{
+				NamePrefix: "abcd",
+				Pools: []Pool{
+					{Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24"))},
+				},
+				Gateway:    ipAddressStrPtr("192.168.1.1"),
+				DNSServers: []IPAddressStr{"192.168.0.2"},
+			},
+			oldPoolSpec: &IPPoolSpec{
+				NamePrefix: "abcd",
+				Pools: []Pool{
+					{Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24"))},
+				},
+				Gateway: ipAddressStrPtr("192.168.1.1"),
+			},
+		},
+		{
+			name:      "should fail when a compacted ip in use is out of bonds",
+			expectErr: true,
+			newPoolSpec: &IPPoolSpec{
+				NamePrefix: "abcd",
+				Pools: []Pool{
+					{Start: &startAddr, End: &endAddr},
+				},
+			},
+			oldPoolSpec: &IPPoolSpec{
+				NamePrefix: "abcd",
+			},
+			oldPoolStatus: IPPoolStatus{
+				AllocatedRanges: []string{"192.168.0.9-192.168.0.11"},
+			},
+		},
+		{
+			name:      "should fail when ip in use",
+			expectErr: true,
+			newPoolSpec: &IPPoolSpec{
+				NamePrefix: "abcd",
+				Pools: []Pool{
+					{Start: &startAddr, End: &endAddr},
+				},
+				PreAllocations: map[string]IPAddressStr{
+					"alloc": IPAddressStr("192.168.0.2"),
+				},
+			},
+			oldPoolSpec: &IPPoolSpec{
+				NamePrefix: "abcd",
+			},
+			oldPoolStatus: IPPoolStatus{
+				Allocations: map[string]IPAddressStr{
+					"inuse": IPAddressStr("192.168.0.30"),
+				},
+			},
+		},
+		{
+			name:      "should fail when dual-stack is modified",
+			expectErr: true,
+			newPoolSpec: &IPPoolSpec{
+				Pools: []Pool{
+					{Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24"))},
+					{Subnet: (*IPSubnetStr)(pointer.StringPtr("2001:db8::/64"))},
+				},
+				DualStack: true,
+			},
+			oldPoolSpec: &IPPoolSpec{
+				Pools: []Pool{
+					{Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24"))},
+					{Subnet: (*IPSubnetStr)(pointer.StringPtr("2001:db8::/64"))},
+				},
+			},
 		},
 		{
-			name:        "should fail when oldPoolSpec is nil",
-			expectErr:   true,
-			newPoolSpec: &IPPoolSpec{},
-			oldPoolSpec: nil,
+			name:      "should fail when the delegated prefix is modified",
+			expectErr: true,
+			newPoolSpec: &IPPoolSpec{
+				Pools: []Pool{
+					{Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24"))},
+				},
+				DelegatedPrefix: 28,
+			},
+			oldPoolSpec: &IPPoolSpec{
+				Pools: []Pool{
+					{Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24"))},
+				},
+				DelegatedPrefix: 29,
+			},
 		},
 		{
-			name:      "should fail when namePrefix value changes",
+			name:      "should fail when the pools are modified to overlap",
 			expectErr: true,
 			newPoolSpec: &IPPoolSpec{
-				NamePrefix: "abcde",
+				Pools: []Pool{
+					{Start: ipAddressStrPtr("192.168.0.10"), End: ipAddressStrPtr("192.168.0.20")},
+					{Start: ipAddressStrPtr("192.168.0.15"), End: ipAddressStrPtr("192.168.0.29")},
+				},
 			},
 			oldPoolSpec: &IPPoolSpec{
-				NamePrefix: "abcd",
+				Pools: []Pool{
+					{Start: ipAddressStrPtr("192.168.0.10"), End: ipAddressStrPtr("192.168.0.20")},
+					{Start: ipAddressStrPtr("192.168.0.21"), End: ipAddressStrPtr("192.168.0.29")},
+				},
 			},
 		},
 		{
-			name:      "should succeed when preAllocations are correct",
+			name:      "should succeed when existing overlapping pools are not modified",
 			expectErr: false,
 			newPoolSpec: &IPPoolSpec{
-				NamePrefix: "abcd",
 				Pools: []Pool{
-					{Start: &startAddr, End: &endAddr},
+					{Start: ipAddressStrPtr("192.168.0.10"), End: ipAddressStrPtr("192.168.0.20")},
+					{Start: ipAddressStrPtr("192.168.0.15"), End: ipAddressStrPtr("192.168.0.29")},
 				},
-				PreAllocations: map[string]IPAddressStr{
-					"alloc": IPAddressStr("192.168.0.2"),
+				DNSServers: []IPAddressStr{"8.8.8.8"},
+			},
+			oldPoolSpec: &IPPoolSpec{
+				Pools: []Pool{
+					{Start: ipAddressStrPtr("192.168.0.10"), End: ipAddressStrPtr("192.168.0.20")},
+					{Start: ipAddressStrPtr("192.168.0.15"), End: ipAddressStrPtr("192.168.0.29")},
+				},
+			},
+		},
+		{
+			name:      "should fail when a range with allocated addresses is removed",
+			expectErr: true,
+			newPoolSpec: &IPPoolSpec{
+				Pools: []Pool{
+					{Start: ipAddressStrPtr("192.168.0.10"), End: ipAddressStrPtr("192.168.0.20")},
 				},
 			},
 			oldPoolSpec: &IPPoolSpec{
-				NamePrefix: "abcd",
+				Pools: []Pool{
+					{Start: ipAddressStrPtr("192.168.0.10"), End: ipAddressStrPtr("192.168.0.20")},
+					{Start: ipAddressStrPtr("192.168.0.21"), End: ipAddressStrPtr("192.168.0.29")},
+				},
 			},
 			oldPoolStatus: IPPoolStatus{
 				Allocations: map[string]IPAddressStr{
-					"inuse": IPAddressStr("192.168.0.3"),
+					"abc": "192.168.0.11",
+					"bcd": "192.168.0.25",
 				},
 			},
 		},
 		{
-			name:      "should fail when preAllocations are incorrect",
-			expectErr: true,
+			name:      "should succeed when a range without allocated address is removed",
+			expectErr: false,
 			newPoolSpec: &IPPoolSpec{
-				NamePrefix: "abcd",
 				Pools: []Pool{
-					{Start: &startAddr, End: &endAddr},
-				},
-				PreAllocations: map[string]IPAddressStr{
-					"alloc": IPAddressStr("192.168.0.20"),
+					{Start: ipAddressStrPtr("192.168.0.10"), End: ipAddressStrPtr("192.168.0.20")},
 				},
 			},
 			oldPoolSpec: &IPPoolSpec{
-				NamePrefix: "abcd",
+				Pools: []Pool{
+					{Start: ipAddressStrPtr("192.168.0.10"), End: ipAddressStrPtr("192.168.0.20")},
+					{Start: ipAddressStrPtr("192.168.0.21"), End: ipAddressStrPtr("192.168.0.29")},
+				},
 			},
 			oldPoolStatus: IPPoolStatus{
 				Allocations: map[string]IPAddressStr{
-					"inuse": IPAddressStr("192.168.0.3"),
+					"abc": "192.168.0.11",
 				},
 			},
 		},
 		{
-			name:      "should fail when ip in use",
+			name:      "should fail when allocated addresses are excluded",
 			expectErr: true,
 			newPoolSpec: &IPPoolSpec{
-				NamePrefix: "abcd",
 				Pools: []Pool{
-					{Start: &startAddr, End: &endAddr},
-				},
-				PreAllocations: map[string]IPAddressStr{
-					"alloc": IPAddressStr("192.168.0.2"),
+					{
+						Start: ipAddressStrPtr("192.168.0.10"), End: ipAddressStrPtr("192.168.0.20"),
+						Exclude: []ExcludedRange{{Start: "192.168.0.11", End: ipAddressStrPtr("192.168.0.12")}},
+					},
 				},
 			},
 			oldPoolSpec: &IPPoolSpec{
-				NamePrefix: "abcd",
+				Pools: []Pool{
+					{Start: ipAddressStrPtr("192.168.0.10"), End: ipAddressStrPtr("192.168.0.20")},
+				},
 			},
 			oldPoolStatus: IPPoolStatus{
 				Allocations: map[string]IPAddressStr{
-					"inuse": IPAddressStr("192.168.0.30"),
+					"abc": "192.168.0.11",
+				},
+			},
+		},
+		{
+			name:      "should fail when removing sharded allocations from the pools",
+			expectErr: true,
+			newPoolSpec: &IPPoolSpec{
+				Pools: []Pool{{Start: &startAddr, End: &endAddr}},
+			},
+			oldPoolSpec: &IPPoolSpec{
+				Pools: []Pool{{Start: &startAddr, End: (*IPAddressStr)(pointer.StringPtr("192.168.0.20"))}},
+			},
+			oldPoolStatus: IPPoolStatus{AllocationShards: 1},
+			shards: []client.Object{
+				&IPPoolAllocationShard{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "abc-allocations-0",
+						Namespace: "foo",
+						Labels:    map[string]string{IPPoolAllocationShardLabel: "abc"},
+					},
+					Spec: IPPoolAllocationShardSpec{
+						Pool:        "abc",
+						Allocations: map[string]IPAddressStr{"inuse": "192.168.0.15"},
+					},
+				},
+			},
+		},
+		{
+			name:      "should succeed when keeping the sharded allocations in the pools",
+			expectErr: false,
+			newPoolSpec: &IPPoolSpec{
+				Pools: []Pool{{Start: &startAddr, End: &endAddr}},
+			},
+			oldPoolSpec: &IPPoolSpec{
+				Pools: []Pool{{Start: &startAddr, End: (*IPAddressStr)(pointer.StringPtr("192.168.0.20"))}},
+			},
+			oldPoolStatus: IPPoolStatus{AllocationShards: 1},
+			shards: []client.Object{
+				&IPPoolAllocationShard{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "abc-allocations-0",
+						Namespace: "foo",
+						Labels:    map[string]string{IPPoolAllocationShardLabel: "abc"},
+					},
+					Spec: IPPoolAllocationShardSpec{
+						Pool:        "abc",
+						Allocations: map[string]IPAddressStr{"inuse": "192.168.0.5"},
+					},
 				},
 			},
 		},
+		{
+			name:      "should fail when modifying the pools of a sharded pool without reader",
+			expectErr: true,
+			newPoolSpec: &IPPoolSpec{
+				Pools: []Pool{{Start: &startAddr, End: &endAddr}},
+			},
+			oldPoolSpec: &IPPoolSpec{
+				Pools: []Pool{{Start: &startAddr, End: (*IPAddressStr)(pointer.StringPtr("192.168.0.20"))}},
+			},
+			oldPoolStatus: IPPoolStatus{AllocationShards: 1},
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			var newPool, oldPool *IPPool
 			g := NewWithT(t)
+			if tt.shards != nil {
+				s := runtime.NewScheme()
+				g.Expect(AddToScheme(s)).To(Succeed())
+				ipPoolWebhookReader = fake.NewClientBuilder().WithScheme(s).WithObjects(tt.shards...).Build()
+				defer func() { ipPoolWebhookReader = nil }()
+			}
 			newPool = &IPPool{
 				ObjectMeta: metav1.ObjectMeta{
+					Name:      "abc",
 					Namespace: "foo",
 				},
 				Spec: *tt.newPoolSpec,
@@ -182,6 +2278,7 @@ func TestIPPoolUpdateValidation(t *testing.T) {
 			if tt.oldPoolSpec != nil {
 				oldPool = &IPPool{
 					ObjectMeta: metav1.ObjectMeta{
+						Name:      "abc",
 						Namespace: "foo",
 					},
 					Spec:   *tt.oldPoolSpec,
@@ -199,3 +2296,312 @@ func TestIPPoolUpdateValidation(t *testing.T) {
 		})
 	}
 }
+
+func TestIPPoolUpdateWarnings(t *testing.T) {
+	s := runtime.NewScheme()
+	if err := AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+	otherIPPool := &IPPool{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "other",
+			Namespace: "foo",
+		},
+		Spec: IPPoolSpec{
+			Pools: []Pool{
+				{
+					Start: ipAddressStrPtr("192.168.1.1"),
+					End:   ipAddressStrPtr("192.168.1.10"),
+				},
+			},
+		},
+	}
+	allocated := IPPoolStatus{
+		Allocations: map[string]IPAddressStr{
+			"bcd": "192.168.0.2",
+			"cde": "192.168.0.3",
+		},
+	}
+
+	tests := []struct {
+		name             string
+		newPoolSpec      IPPoolSpec
+		oldPoolSpec      IPPoolSpec
+		oldPoolStatus    IPPoolStatus
+		expectedWarnings []string
+	}{
+		{
+			name: "no change",
+			newPoolSpec: IPPoolSpec{
+				Gateway: ipAddressStrPtr("192.168.0.1"),
+			},
+			oldPoolSpec: IPPoolSpec{
+				Gateway: ipAddressStrPtr("192.168.0.1"),
+			},
+			oldPoolStatus:    allocated,
+			expectedWarnings: []string{},
+		},
+		{
+			name: "gateway changed without allocations",
+			newPoolSpec: IPPoolSpec{
+				Gateway: ipAddressStrPtr("192.168.0.254"),
+			},
+			oldPoolSpec: IPPoolSpec{
+				Gateway: ipAddressStrPtr("192.168.0.1"),
+			},
+			expectedWarnings: []string{},
+		},
+		{
+			name: "gateway and prefix changed with allocations",
+			newPoolSpec: IPPoolSpec{
+				Gateway: ipAddressStrPtr("192.168.0.254"),
+				Prefix:  25,
+			},
+			oldPoolSpec: IPPoolSpec{
+				Prefix: 24,
+			},
+			oldPoolStatus: allocated,
+			expectedWarnings: []string{
+				"spec.gateway: the 2 addresses already allocated keep the former gateway none",
+				"spec.prefix: the 2 addresses already allocated keep the former prefix 24",
+			},
+		},
+		{
+			name: "backend changed with allocations",
+			newPoolSpec: IPPoolSpec{
+				Backend: &Backend{
+					NetBox: &NetBoxBackend{URL: "https://netbox.example.com"},
+				},
+			},
+			oldPoolStatus: allocated,
+			expectedWarnings: []string{
+				"spec.backend: the reservations of the 2 addresses already allocated are not moved to the new backend",
+			},
+		},
+		{
+			name: "pool gateway changed with allocations",
+			newPoolSpec: IPPoolSpec{
+				Pools: []Pool{
+					{
+						Start:   ipAddressStrPtr("192.168.0.1"),
+						End:     ipAddressStrPtr("192.168.0.10"),
+						Gateway: ipAddressStrPtr("192.168.0.254"),
+					},
+				},
+			},
+			oldPoolSpec: IPPoolSpec{
+				Pools: []Pool{
+					{
+						Start:   ipAddressStrPtr("192.168.0.1"),
+						End:     ipAddressStrPtr("192.168.0.10"),
+						Gateway: ipAddressStrPtr("192.168.0.1"),
+					},
+				},
+			},
+			oldPoolStatus: allocated,
+			expectedWarnings: []string{
+				"spec.pools[0].gateway: the 2 addresses already allocated keep the former gateway 192.168.0.1",
+			},
+		},
+		{
+			name: "pool gateway changed without allocations in the pool",
+			newPoolSpec: IPPoolSpec{
+				Pools: []Pool{
+					{
+						Start:   ipAddressStrPtr("192.168.2.1"),
+						Gateway: ipAddressStrPtr("192.168.2.254"),
+					},
+				},
+			},
+			oldPoolSpec: IPPoolSpec{
+				Pools: []Pool{
+					{
+						Start: ipAddressStrPtr("192.168.2.1"),
+					},
+				},
+			},
+			oldPoolStatus:    allocated,
+			expectedWarnings: []string{},
+		},
+		{
+			name: "pool enlarged into another IPPool",
+			newPoolSpec: IPPoolSpec{
+				Pools: []Pool{
+					{
+						Start: ipAddressStrPtr("192.168.0.1"),
+						End:   ipAddressStrPtr("192.168.1.5"),
+					},
+				},
+			},
+			oldPoolSpec: IPPoolSpec{
+				Pools: []Pool{
+					{
+						Start: ipAddressStrPtr("192.168.0.1"),
+						End:   ipAddressStrPtr("192.168.0.10"),
+					},
+				},
+			},
+			expectedWarnings: []string{
+				"spec.pools[0]: overlaps the pools of the IPPool other, the same addresses might be allocated by both",
+			},
+		},
+		{
+			name: "overlapping pool disabled",
+			newPoolSpec: IPPoolSpec{
+				Pools: []Pool{
+					{
+						Start:    ipAddressStrPtr("192.168.1.5"),
+						End:      ipAddressStrPtr("192.168.1.20"),
+						Disabled: true,
+					},
+				},
+			},
+			oldPoolSpec: IPPoolSpec{
+				Pools: []Pool{
+					{
+						Start: ipAddressStrPtr("192.168.1.5"),
+						End:   ipAddressStrPtr("192.168.1.20"),
+					},
+				},
+			},
+			expectedWarnings: []string{},
+		},
+		{
+			name: "pool added out of the other IPPools",
+			newPoolSpec: IPPoolSpec{
+				Pools: []Pool{
+					{
+						Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.2.0/24")),
+					},
+				},
+			},
+			expectedWarnings: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			ipPoolWebhookReader = fake.NewClientBuilder().WithScheme(s).WithObjects(otherIPPool).Build()
+			defer func() { ipPoolWebhookReader = nil }()
+
+			newPool := &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "abc",
+					Namespace: "foo",
+				},
+				Spec: tt.newPoolSpec,
+			}
+			oldPool := &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "abc",
+					Namespace: "foo",
+				},
+				Spec:   tt.oldPoolSpec,
+				Status: tt.oldPoolStatus,
+			}
+
+			g.Expect(newPool.updateWarnings(context.TODO(), oldPool)).To(Equal(tt.expectedWarnings))
+		})
+	}
+}
+
+func TestIPPoolValidatingHandler(t *testing.T) {
+	s := runtime.NewScheme()
+	if err := AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+	decoder, err := admission.NewDecoder(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldPool := &IPPool{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "IPPool",
+			APIVersion: GroupVersion.String(),
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "abc",
+			Namespace: "foo",
+		},
+		Spec: IPPoolSpec{
+			NamePrefix: "abc",
+			Gateway:    ipAddressStrPtr("192.168.0.1"),
+			Pools: []Pool{
+				{
+					Start: ipAddressStrPtr("192.168.0.2"),
+				},
+			},
+		},
+		Status: IPPoolStatus{
+			Allocations: map[string]IPAddressStr{
+				"bcd": "192.168.0.2",
+			},
+		},
+	}
+
+	tests := []struct {
+		name             string
+		newNamePrefix    string
+		newGateway       string
+		expectAllowed    bool
+		expectedWarnings []string
+	}{
+		{
+			name:          "allowed without warnings",
+			newNamePrefix: "abc",
+			newGateway:    "192.168.0.1",
+			expectAllowed: true,
+		},
+		{
+			name:          "allowed with warnings",
+			newNamePrefix: "abc",
+			newGateway:    "192.168.0.254",
+			expectAllowed: true,
+			expectedWarnings: []string{
+				"spec.gateway: the 1 addresses already allocated keep the former gateway 192.168.0.1",
+			},
+		},
+		{
+			name:          "denied without warnings",
+			newNamePrefix: "bcd",
+			newGateway:    "192.168.0.254",
+			expectAllowed: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			h := &ipPoolValidatingHandler{
+				validator: admission.ValidatingWebhookFor(&IPPool{}).Handler,
+			}
+			g.Expect(h.InjectDecoder(decoder)).To(Succeed())
+
+			newPool := oldPool.DeepCopy()
+			newPool.Spec.NamePrefix = tt.newNamePrefix
+			newPool.Spec.Gateway = ipAddressStrPtr(tt.newGateway)
+			newRaw, err := json.Marshal(newPool)
+			g.Expect(err).NotTo(HaveOccurred())
+			oldRaw, err := json.Marshal(oldPool)
+			g.Expect(err).NotTo(HaveOccurred())
+
+			response := h.Handle(context.TODO(), admission.Request{
+				AdmissionRequest: admissionv1.AdmissionRequest{
+					Operation: admissionv1.Update,
+					Object:    runtime.RawExtension{Raw: newRaw},
+					OldObject: runtime.RawExtension{Raw: oldRaw},
+				},
+			})
+			g.Expect(response.Allowed).To(Equal(tt.expectAllowed))
+			g.Expect(response.Warnings).To(Equal(tt.expectedWarnings))
+		})
+	}
+}
+
+func ipAddressStrPtr(address string) *IPAddressStr {
+	ipAddress := IPAddressStr(address)
+	return &ipAddress
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// IPPoolAllocationShardLabel is the label set on the IPPoolAllocationShard
+	// objects, with the name of their IPPool as value
+	IPPoolAllocationShardLabel = "ipam.metal3.io/ippool-name"
+)
+
+// IPPoolAllocationShardSpec defines the allocations recorded in the shard.
+type IPPoolAllocationShardSpec struct {
+
+	// Pool is the name of the IPPool whose allocations the shard records.
+	Pool string `json:"pool"`
+
+	// Index is the index of the shard among the shards of the IPPool.
+	Index int `json:"index"`
+
+	// Allocations contains the map of objects and IP addresses they have,
+	// for the allocation keys of the shard.
+	// +optional
+	Allocations map[string]IPAddressStr `json:"allocations,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:resource:path=ippoolallocationshards,scope=Namespaced,categories=cluster-api,shortName=ippas;m3ippas;metal3ippas
+// +kubebuilder:storageversion
+// +kubebuilder:object:root=true
+// +kubebuilder:printcolumn:name="Pool",type="string",JSONPath=".spec.pool",description="Name of the IPPool"
+// +kubebuilder:printcolumn:name="Index",type="integer",JSONPath=".spec.index",description="Index of the shard"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp",description="Time duration since creation of IPPoolAllocationShard"
+// IPPoolAllocationShard is the Schema for the ippoolallocationshards API. It
+// records a part of the allocations of an IPPool above the sharding
+// threshold, in place of the IPPool status.
+type IPPoolAllocationShard struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec IPPoolAllocationShardSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// IPPoolAllocationShardList contains a list of IPPoolAllocationShard
+type IPPoolAllocationShardList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []IPPoolAllocationShard `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&IPPoolAllocationShard{}, &IPPoolAllocationShardList{})
+}
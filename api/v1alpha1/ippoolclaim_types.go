@@ -0,0 +1,127 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// IPPoolClaimLabel is the label containing the name of the IPPoolClaim
+	// that created the IPPool or the IPClaim of its prefix
+	IPPoolClaimLabel = "ipam.metal3.io/ippoolclaim-name"
+
+	// IPPoolClaimReadyCondition reports that the prefix of the IPPoolClaim
+	// was carved out of its super-pool and its IPPool created.
+	IPPoolClaimReadyCondition = "Ready"
+)
+
+// IPPoolTemplate describes the IPPool created by an IPPoolClaim.
+type IPPoolTemplate struct {
+
+	// Labels are the labels of the IPPool.
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Annotations are the annotations of the IPPool.
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// Spec is the spec of the IPPool. Its pools are set to the prefix carved
+	// out of the super-pool, and its prefix defaults to the length of the
+	// carved prefix.
+	// +optional
+	Spec IPPoolSpec `json:"spec,omitempty"`
+}
+
+// IPPoolClaimSpec defines the desired state of IPPoolClaim.
+type IPPoolClaimSpec struct {
+
+	// SuperPool is the name of the ClusterIPPool the prefix is carved out of.
+	// It must delegate prefixes of the requested length and allow the
+	// namespace of the IPPoolClaim.
+	// +kubebuilder:validation:MinLength=1
+	SuperPool string `json:"superPool"`
+
+	// PrefixLength is the length of the requested prefix.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=127
+	PrefixLength int `json:"prefixLength"`
+
+	// PoolName is the name of the IPPool created in the namespace of the
+	// IPPoolClaim, the name of the IPPoolClaim by default.
+	// +optional
+	PoolName string `json:"poolName,omitempty"`
+
+	// Template is the template of the IPPool. It is only used to create the
+	// IPPool, the IPPool can then be modified directly.
+	// +optional
+	Template IPPoolTemplate `json:"template,omitempty"`
+}
+
+// IPPoolClaimStatus defines the observed state of IPPoolClaim.
+type IPPoolClaimStatus struct {
+	// LastUpdated identifies when this status was last observed.
+	// +optional
+	LastUpdated *metav1.Time `json:"lastUpdated,omitempty"`
+
+	// Prefix is the prefix carved out of the super-pool.
+	// +optional
+	Prefix *IPSubnetStr `json:"prefix,omitempty"`
+
+	// Pool is the IPPool created with the prefix.
+	// +optional
+	Pool *corev1.ObjectReference `json:"pool,omitempty"`
+
+	// Conditions defines the current service state of the IPPoolClaim.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:resource:path=ippoolclaims,scope=Namespaced,categories=cluster-api,shortName=ippc;ippoolclaim;m3ippc;m3ippoolclaim;m3ippoolclaims;metal3ippc;metal3ippoolclaim;metal3ippoolclaims
+// +kubebuilder:storageversion
+// +kubebuilder:subresource:status
+// +kubebuilder:object:root=true
+// +kubebuilder:printcolumn:name="Super-pool",type="string",JSONPath=".spec.superPool",description="ClusterIPPool the prefix is carved out of"
+// +kubebuilder:printcolumn:name="Prefix",type="string",JSONPath=".status.prefix",description="Prefix carved out of the super-pool"
+// +kubebuilder:printcolumn:name="Pool",type="string",JSONPath=".status.pool.name",description="IPPool created with the prefix"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp",description="Time duration since creation of IPPoolClaim"
+// IPPoolClaim is the Schema for the ippoolclaims API
+type IPPoolClaim struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   IPPoolClaimSpec   `json:"spec,omitempty"`
+	Status IPPoolClaimStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// IPPoolClaimList contains a list of IPPoolClaim
+type IPPoolClaimList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []IPPoolClaim `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&IPPoolClaim{}, &IPPoolClaimList{})
+}
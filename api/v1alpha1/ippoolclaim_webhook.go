@@ -0,0 +1,145 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+func (c *IPPoolClaim) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(c).
+		Complete()
+}
+
+// +kubebuilder:webhook:verbs=create;update,path=/validate-ipam-metal3-io-v1alpha4-ippoolclaim,mutating=false,failurePolicy=fail,groups=ipam.metal3.io,resources=ippoolclaims,versions=v1alpha4,name=validation.ippoolclaim.ipam.metal3.io,matchPolicy=Equivalent,sideEffects=None,admissionReviewVersions=v1;v1beta1
+// +kubebuilder:webhook:verbs=create;update,path=/mutate-ipam-metal3-io-v1alpha4-ippoolclaim,mutating=true,failurePolicy=fail,groups=ipam.metal3.io,resources=ippoolclaims,versions=v1alpha4,name=default.ippoolclaim.ipam.metal3.io,matchPolicy=Equivalent,sideEffects=None,admissionReviewVersions=v1;v1beta1
+
+var _ webhook.Defaulter = &IPPoolClaim{}
+var _ webhook.Validator = &IPPoolClaim{}
+
+func (c *IPPoolClaim) Default() {
+}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (c *IPPoolClaim) ValidateCreate() error {
+	allErrs := c.validateSpec()
+
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("IPPoolClaim").GroupKind(), c.Name, allErrs)
+}
+
+// validateSpec verifies the requested prefix and that the IPPool rendered
+// from the template is valid
+func (c *IPPoolClaim) validateSpec() field.ErrorList {
+	allErrs := field.ErrorList{}
+	if c.Spec.SuperPool == "" {
+		allErrs = append(allErrs,
+			field.Required(field.NewPath("spec", "superPool"), "the super-pool is required"),
+		)
+	}
+	if c.Spec.PrefixLength < 1 || c.Spec.PrefixLength > 127 {
+		allErrs = append(allErrs,
+			field.Invalid(
+				field.NewPath("spec", "prefixLength"),
+				c.Spec.PrefixLength,
+				"must be between 1 and 127",
+			),
+		)
+	}
+	if len(c.Spec.Template.Spec.Pools) != 0 {
+		allErrs = append(allErrs,
+			field.Forbidden(
+				field.NewPath("spec", "template", "spec", "pools"),
+				"the pools are set to the prefix carved out of the super-pool",
+			),
+		)
+	}
+	if c.Spec.Template.Spec.ExternallyManaged {
+		allErrs = append(allErrs,
+			field.Forbidden(
+				field.NewPath("spec", "template", "spec", "externallyManaged"),
+				"the IPPool of an IPPoolClaim allocates its addresses",
+			),
+		)
+	}
+	if errs := c.templateIPPool().validateSpec(); len(errs) != 0 {
+		allErrs = append(allErrs,
+			field.Invalid(
+				field.NewPath("spec", "template"),
+				c.Spec.Template,
+				errs.ToAggregate().Error(),
+			),
+		)
+	}
+	return allErrs
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (c *IPPoolClaim) ValidateUpdate(old runtime.Object) error {
+	oldIPPoolClaim, ok := old.(*IPPoolClaim)
+	if !ok || oldIPPoolClaim == nil {
+		return apierrors.NewInternalError(errors.New("unable to convert existing object"))
+	}
+
+	allErrs := c.validateSpec()
+
+	if c.Spec.SuperPool != oldIPPoolClaim.Spec.SuperPool {
+		allErrs = append(allErrs,
+			field.Invalid(
+				field.NewPath("spec", "superPool"),
+				c.Spec.SuperPool,
+				"cannot be modified",
+			),
+		)
+	}
+	if c.Spec.PrefixLength != oldIPPoolClaim.Spec.PrefixLength {
+		allErrs = append(allErrs,
+			field.Invalid(
+				field.NewPath("spec", "prefixLength"),
+				c.Spec.PrefixLength,
+				"cannot be modified",
+			),
+		)
+	}
+	if c.GetPoolName() != oldIPPoolClaim.GetPoolName() {
+		allErrs = append(allErrs,
+			field.Invalid(
+				field.NewPath("spec", "poolName"),
+				c.Spec.PoolName,
+				"cannot be modified",
+			),
+		)
+	}
+
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("IPPoolClaim").GroupKind(), c.Name, allErrs)
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (c *IPPoolClaim) ValidateDelete() error {
+	return nil
+}
@@ -0,0 +1,240 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestIPPoolClaimDefault(t *testing.T) {
+	g := NewWithT(t)
+
+	c := &IPPoolClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "foo",
+		},
+	}
+	c.Default()
+
+	g.Expect(c.Spec).To(Equal(IPPoolClaimSpec{}))
+	g.Expect(c.Status).To(Equal(IPPoolClaimStatus{}))
+}
+
+func TestIPPoolClaimCreateValidation(t *testing.T) {
+	gateway := IPAddressStr("192.168.0.1")
+	subnet := IPSubnetStr("192.168.0.0/24")
+
+	tests := []struct {
+		name      string
+		expectErr bool
+		spec      IPPoolClaimSpec
+	}{
+		{
+			name:      "should succeed with a valid spec",
+			expectErr: false,
+			spec: IPPoolClaimSpec{
+				SuperPool:    "tenants",
+				PrefixLength: 24,
+				Template: IPPoolTemplate{
+					Spec: IPPoolSpec{
+						Gateway: &gateway,
+					},
+				},
+			},
+		},
+		{
+			name:      "should fail without super-pool",
+			expectErr: true,
+			spec: IPPoolClaimSpec{
+				PrefixLength: 24,
+			},
+		},
+		{
+			name:      "should fail without prefix length",
+			expectErr: true,
+			spec: IPPoolClaimSpec{
+				SuperPool: "tenants",
+			},
+		},
+		{
+			name:      "should fail with pools in the template",
+			expectErr: true,
+			spec: IPPoolClaimSpec{
+				SuperPool:    "tenants",
+				PrefixLength: 24,
+				Template: IPPoolTemplate{
+					Spec: IPPoolSpec{
+						Pools: []Pool{{Subnet: &subnet}},
+					},
+				},
+			},
+		},
+		{
+			name:      "should fail with an externally managed template",
+			expectErr: true,
+			spec: IPPoolClaimSpec{
+				SuperPool:    "tenants",
+				PrefixLength: 24,
+				Template: IPPoolTemplate{
+					Spec: IPPoolSpec{
+						ExternallyManaged: true,
+					},
+				},
+			},
+		},
+		{
+			name:      "should fail with an invalid template",
+			expectErr: true,
+			spec: IPPoolClaimSpec{
+				SuperPool:    "tenants",
+				PrefixLength: 24,
+				Template: IPPoolTemplate{
+					Spec: IPPoolSpec{
+						Gateway: (*IPAddressStr)(&subnet),
+					},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			obj := &IPPoolClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+					Name:      "abc",
+				},
+				Spec: tt.spec,
+			}
+
+			if tt.expectErr {
+				g.Expect(obj.ValidateCreate()).NotTo(Succeed())
+			} else {
+				g.Expect(obj.ValidateCreate()).To(Succeed())
+			}
+			g.Expect(obj.ValidateDelete()).To(Succeed())
+		})
+	}
+}
+
+func TestIPPoolClaimUpdateValidation(t *testing.T) {
+	tests := []struct {
+		name      string
+		expectErr bool
+		new       *IPPoolClaimSpec
+		old       *IPPoolClaimSpec
+	}{
+		{
+			name:      "should succeed when the template changes",
+			expectErr: false,
+			new: &IPPoolClaimSpec{
+				SuperPool:    "tenants",
+				PrefixLength: 24,
+				Template: IPPoolTemplate{
+					Labels: map[string]string{"foo": "bar"},
+				},
+			},
+			old: &IPPoolClaimSpec{
+				SuperPool:    "tenants",
+				PrefixLength: 24,
+			},
+		},
+		{
+			name:      "should fail with nil old",
+			expectErr: true,
+			new: &IPPoolClaimSpec{
+				SuperPool:    "tenants",
+				PrefixLength: 24,
+			},
+			old: nil,
+		},
+		{
+			name:      "should fail when the super-pool changes",
+			expectErr: true,
+			new: &IPPoolClaimSpec{
+				SuperPool:    "tenants",
+				PrefixLength: 24,
+			},
+			old: &IPPoolClaimSpec{
+				SuperPool:    "other",
+				PrefixLength: 24,
+			},
+		},
+		{
+			name:      "should fail when the prefix length changes",
+			expectErr: true,
+			new: &IPPoolClaimSpec{
+				SuperPool:    "tenants",
+				PrefixLength: 24,
+			},
+			old: &IPPoolClaimSpec{
+				SuperPool:    "tenants",
+				PrefixLength: 26,
+			},
+		},
+		{
+			name:      "should fail when the pool name changes",
+			expectErr: true,
+			new: &IPPoolClaimSpec{
+				SuperPool:    "tenants",
+				PrefixLength: 24,
+				PoolName:     "bcd",
+			},
+			old: &IPPoolClaimSpec{
+				SuperPool:    "tenants",
+				PrefixLength: 24,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var new, old *IPPoolClaim
+			g := NewWithT(t)
+			new = &IPPoolClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "foo",
+					Name:      "abc",
+				},
+				Spec: *tt.new,
+			}
+
+			if tt.old != nil {
+				old = &IPPoolClaim{
+					ObjectMeta: metav1.ObjectMeta{
+						Namespace: "foo",
+						Name:      "abc",
+					},
+					Spec: *tt.old,
+				}
+			} else {
+				old = nil
+			}
+
+			if tt.expectErr {
+				g.Expect(new.ValidateUpdate(old)).NotTo(Succeed())
+			} else {
+				g.Expect(new.ValidateUpdate(old)).To(Succeed())
+			}
+		})
+	}
+}
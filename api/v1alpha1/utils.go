@@ -17,110 +17,1203 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"context"
+	"encoding/binary"
 	"fmt"
+	"math"
 	"math/big"
+	"math/bits"
 	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+// AsIPPool returns the IPPool view of the ClusterIPPool, without namespace,
+// managed like an IPPool. The view is a copy, its metadata and status are set
+// back on the ClusterIPPool by SetFromIPPool.
+func (c *ClusterIPPool) AsIPPool() *IPPool {
+	return &IPPool{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       ClusterIPPoolKind,
+			APIVersion: GroupVersion.String(),
+		},
+		ObjectMeta: *c.ObjectMeta.DeepCopy(),
+		Spec:       *c.Spec.DeepCopy(),
+		Status:     *c.Status.DeepCopy(),
+	}
+}
+
+// SetFromIPPool sets the metadata and status of the IPPool view back on the
+// ClusterIPPool
+func (c *ClusterIPPool) SetFromIPPool(ipPool *IPPool) {
+	c.ObjectMeta = *ipPool.ObjectMeta.DeepCopy()
+	c.Status = *ipPool.Status.DeepCopy()
+}
+
+// IsClusterScoped returns true if the IPPool is the view of a ClusterIPPool
+func (c *IPPool) IsClusterScoped() bool {
+	return c.Kind == ClusterIPPoolKind
+}
+
+// IsClusterIPPoolRef returns true if the pool reference of an IPClaim or an
+// IPAddress references a ClusterIPPool
+func IsClusterIPPoolRef(ref corev1.ObjectReference) bool {
+	return ref.Kind == ClusterIPPoolKind
+}
+
+// IsNamespaceAllowed returns true if IPClaims from the given namespace are
+// allowed to reference the pool. A ClusterIPPool without allowed namespaces
+// allows all of them.
+func (c *IPPool) IsNamespaceAllowed(namespace string) bool {
+	if namespace == c.Namespace {
+		return true
+	}
+	if c.IsClusterScoped() && len(c.Spec.AllowedNamespaces) == 0 {
+		return true
+	}
+	for _, allowedNamespace := range c.Spec.AllowedNamespaces {
+		if allowedNamespace == AllNamespaces || allowedNamespace == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// maxListedClaims is the number of claims listed in the messages about the
+// claims holding the addresses of a pool
+const maxListedClaims = 10
+
+// AllocatedClaims returns the sorted keys of the claims the addresses of the
+// pool are allocated to, without the roles of their addresses
+func (c *IPPool) AllocatedClaims() []string {
+	seen := map[string]bool{}
+	claims := []string{}
+	for key := range c.Status.Allocations {
+		key = strings.SplitN(key, ":", 2)[0]
+		if !seen[key] {
+			seen[key] = true
+			claims = append(claims, key)
+		}
+	}
+	sort.Strings(claims)
+	return claims
+}
+
+// DeletionBlockedMessage describes the allocations blocking the deletion of
+// the pool, listing the claims holding its addresses, the allocated ranges if
+// the pool compacts its allocations, or the number of shards its allocations
+// are recorded in. It is empty if no address is allocated.
+func (c *IPPool) DeletionBlockedMessage() string {
+	if claims := c.AllocatedClaims(); len(claims) != 0 {
+		return "addresses are allocated to the claims " + truncatedList(claims)
+	}
+	if len(c.Status.AllocatedRanges) != 0 {
+		return "addresses are allocated in " + truncatedList(c.Status.AllocatedRanges)
+	}
+	if c.Status.AllocationShards != 0 {
+		return fmt.Sprintf("addresses are allocated in %d allocation shards", c.Status.AllocationShards)
+	}
+	return ""
+}
+
+// OutOfRangeAddresses returns the given addresses that the pools cannot
+// contain anymore, out of their ranges or excluded, in address order
+func (c *IPPool) OutOfRangeAddresses(addresses []IPAddressStr) []IPAddressStr {
+	outOfRange := []IPAddressStr{}
+	for _, address := range addresses {
+		if !c.isAddressInBonds(address) || c.isExcluded(address) {
+			outOfRange = append(outOfRange, address)
+		}
+	}
+	sort.Slice(outOfRange, func(i, j int) bool {
+		a, b := net.ParseIP(string(outOfRange[i])), net.ParseIP(string(outOfRange[j]))
+		if a == nil || b == nil {
+			return outOfRange[i] < outOfRange[j]
+		}
+		return compareIPs(a, b) < 0
+	})
+	return outOfRange
+}
+
+// OutOfRangeMessage describes the allocations of the pool whose address is
+// out of its ranges or excluded, with the claims holding them. It is empty if
+// all the allocated addresses are in the pools.
+func (c *IPPool) OutOfRangeMessage() string {
+	claims := map[IPAddressStr]string{}
+	addresses := []IPAddressStr{}
+	for key, address := range c.Status.Allocations {
+		if net.ParseIP(string(address)) == nil {
+			continue
+		}
+		claims[address] = key
+		addresses = append(addresses, address)
+	}
+	outOfRange := []string{}
+	for _, address := range c.OutOfRangeAddresses(addresses) {
+		if claims[address] == "" {
+			outOfRange = append(outOfRange, string(address))
+			continue
+		}
+		outOfRange = append(outOfRange, fmt.Sprintf("%s (%s)", address, claims[address]))
+	}
+	if len(outOfRange) == 0 {
+		return ""
+	}
+	return "addresses out of the pools are allocated: " + truncatedList(outOfRange)
+}
+
+// truncatedList joins the first items of a list, followed by the number of
+// items left out
+func truncatedList(items []string) string {
+	if len(items) <= maxListedClaims {
+		return strings.Join(items, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(items[:maxListedClaims], ", "),
+		len(items)-maxListedClaims,
+	)
+}
+
+// PropagatedAnnotations returns the annotations of the claim that are
+// propagated to its IPAddresses by the pool, or nil if there is none
+func (c *IPPool) PropagatedAnnotations(claim *IPClaim) map[string]string {
+	var annotations map[string]string
+	for key, value := range claim.Annotations {
+		for _, propagated := range c.Spec.PropagatedAnnotations {
+			if key == propagated || (strings.HasSuffix(propagated, "*") &&
+				strings.HasPrefix(key, strings.TrimSuffix(propagated, "*"))) {
+				if annotations == nil {
+					annotations = make(map[string]string)
+				}
+				annotations[key] = value
+				break
+			}
+		}
+	}
+	return annotations
+}
+
+// FormerNames returns the former names of the pool, from the renamed-from
+// annotation
+func (c *IPPool) FormerNames() []string {
+	names := []string{}
+	for _, name := range strings.Split(c.Annotations[RenamedFromAnnotation], ",") {
+		name = strings.TrimSpace(name)
+		if name != "" && name != c.Name {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// IsNamed returns true if the name is the name or a former name of the pool
+func (c *IPPool) IsNamed(name string) bool {
+	if name == c.Name {
+		return true
+	}
+	for _, formerName := range c.FormerNames() {
+		if formerName == name {
+			return true
+		}
+	}
+	return false
+}
+
+// FindRenamedIPPool returns the IPPool of the namespace of the key that was
+// renamed from the name of the key, or nil if there is none
+func FindRenamedIPPool(ctx context.Context, reader client.Reader, key client.ObjectKey) (*IPPool, error) {
+	ipPools := IPPoolList{}
+	if err := reader.List(ctx, &ipPools, client.InNamespace(key.Namespace)); err != nil {
+		return nil, err
+	}
+	for i := range ipPools.Items {
+		ipPool := &ipPools.Items[i]
+		if ipPool.Name != key.Name && ipPool.IsNamed(key.Name) {
+			return ipPool, nil
+		}
+	}
+	return nil, nil
+}
+
+// GetIPPool returns the IPPool renamed from the name of the key if there is
+// one, as it serves the claims referencing its former names, or the IPPool
+// with the name of the key
+func GetIPPool(ctx context.Context, reader client.Reader, key client.ObjectKey) (*IPPool, error) {
+	renamedPool, err := FindRenamedIPPool(ctx, reader, key)
+	if err != nil {
+		return nil, err
+	}
+	if renamedPool != nil {
+		return renamedPool, nil
+	}
+	ipPool := &IPPool{}
+	if err := reader.Get(ctx, key, ipPool); err != nil {
+		return nil, err
+	}
+	return ipPool, nil
+}
+
+// GetClaimPool returns the IPPool referenced by the claim, as returned by
+// GetIPPool, or the IPPool view of the referenced ClusterIPPool. A claim
+// handed over by a draining pool is served by the pool of its served-by
+// annotation.
+func GetClaimPool(ctx context.Context, reader client.Reader, claim *IPClaim) (*IPPool, error) {
+	if IsClusterIPPoolRef(claim.Spec.Pool) {
+		clusterPool := &ClusterIPPool{}
+		if err := reader.Get(ctx, client.ObjectKey{Name: claim.Spec.Pool.Name}, clusterPool); err != nil {
+			return nil, err
+		}
+		return clusterPool.AsIPPool(), nil
+	}
+	namespace := claim.Spec.Pool.Namespace
+	if namespace == "" {
+		namespace = claim.Namespace
+	}
+	name := claim.Spec.Pool.Name
+	if servedBy, ok := claim.Annotations[ServedByAnnotation]; ok {
+		name = servedBy
+	}
+	return GetIPPool(ctx, reader, client.ObjectKey{
+		Name:      name,
+		Namespace: namespace,
+	})
+}
+
+// SelectClaimPool returns the IPPool, or the IPPool view of the ClusterIPPool,
+// matching the pool selector of the claim, or nil if none matches. The pools
+// being deleted and the ClusterIPPools not allowing the namespace of the claim
+// are ignored. The pools that are not exhausted are preferred, the ties are
+// broken by name.
+func SelectClaimPool(ctx context.Context, reader client.Reader, claim *IPClaim) (*IPPool, error) {
+	if claim.Spec.PoolSelector == nil {
+		return nil, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(claim.Spec.PoolSelector)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := []*IPPool{}
+	if IsClusterIPPoolRef(claim.Spec.Pool) {
+		clusterPools := ClusterIPPoolList{}
+		if err := reader.List(ctx, &clusterPools,
+			client.MatchingLabelsSelector{Selector: selector},
+		); err != nil {
+			return nil, err
+		}
+		for i := range clusterPools.Items {
+			ipPool := clusterPools.Items[i].AsIPPool()
+			if ipPool.IsNamespaceAllowed(claim.Namespace) {
+				candidates = append(candidates, ipPool)
+			}
+		}
+	} else {
+		ipPools := IPPoolList{}
+		if err := reader.List(ctx, &ipPools, client.InNamespace(claim.Namespace),
+			client.MatchingLabelsSelector{Selector: selector},
+		); err != nil {
+			return nil, err
+		}
+		for i := range ipPools.Items {
+			candidates = append(candidates, &ipPools.Items[i])
+		}
+	}
+
+	var selected *IPPool
+	for _, ipPool := range candidates {
+		if !ipPool.DeletionTimestamp.IsZero() {
+			continue
+		}
+		if selected == nil {
+			selected = ipPool
+			continue
+		}
+		exhausted := meta.IsStatusConditionTrue(ipPool.Status.Conditions, IPPoolExhaustedCondition)
+		selectedExhausted := meta.IsStatusConditionTrue(selected.Status.Conditions, IPPoolExhaustedCondition)
+		if exhausted != selectedExhausted {
+			if !exhausted {
+				selected = ipPool
+			}
+			continue
+		}
+		if ipPool.Name < selected.Name {
+			selected = ipPool
+		}
+	}
+	return selected, nil
+}
+
+// ApplyIPAMConfig sets the defaults of the IPAMConfig on the settings the
+// IPPool does not set
+func (c *IPPool) ApplyIPAMConfig(ipamConfig *IPAMConfig) {
+	defaults := ipamConfig.Spec
+	if c.Spec.NamePrefix == "" && defaults.NamePrefix != "" {
+		c.Spec.NamePrefix = strings.ReplaceAll(defaults.NamePrefix,
+			IPPoolNamePlaceholder, c.Name,
+		)
+	}
+	if len(c.Spec.DNSServers) == 0 {
+		c.Spec.DNSServers = defaults.DNSServers
+	}
+	if len(c.Spec.SearchDomains) == 0 {
+		c.Spec.SearchDomains = defaults.SearchDomains
+	}
+	if len(c.Spec.NTPServers) == 0 {
+		c.Spec.NTPServers = defaults.NTPServers
+	}
+	if c.Spec.DomainName == "" {
+		c.Spec.DomainName = defaults.DomainName
+	}
+	if len(c.Spec.AllowedNamespaces) == 0 {
+		c.Spec.AllowedNamespaces = defaults.AllowedNamespaces
+	}
+	if len(c.Spec.PropagatedAnnotations) == 0 {
+		c.Spec.PropagatedAnnotations = defaults.PropagatedAnnotations
+	}
+	if c.Spec.ReclaimPolicy == "" {
+		c.Spec.ReclaimPolicy = defaults.ReclaimPolicy
+	}
+	if c.Spec.AllocationStrategy == "" {
+		c.Spec.AllocationStrategy = defaults.AllocationStrategy
+	}
+}
+
+// GetPrefixOverride returns the prefix override of the claim, from the prefix
+// field or from the prefix annotation. The boolean is false if the claim does
+// not override the prefix.
+func (c *IPClaim) GetPrefixOverride() (int, bool, error) {
+	if c.Spec.Prefix != nil {
+		return *c.Spec.Prefix, true, nil
+	}
+	value, ok := c.Annotations[PrefixAnnotation]
+	if !ok {
+		return 0, false, nil
+	}
+	prefix, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, false, errors.Wrapf(err, "invalid %s annotation", PrefixAnnotation)
+	}
+	return prefix, true, nil
+}
+
+// GetAddressRoles returns the roles of the addresses requested by the claim.
+// The empty role is the main address of the claim.
+func (c *IPClaim) GetAddressRoles() []string {
+	roles := []string{""}
+	if c.Spec.HAAddressSet {
+		roles = append(roles, HAPeerRole, HAVIPRole)
+	}
+	for i := 1; i < c.GetAddressCount(); i++ {
+		roles = append(roles, fmt.Sprintf("%s%d", AddressCountRolePrefix, i))
+	}
+	return append(roles, c.Spec.Roles...)
+}
+
+// GetAddressCount returns the number of addresses requested by the address
+// count of the claim, one by default
+func (c *IPClaim) GetAddressCount() int {
+	if c.Spec.AddressCount == nil {
+		return 1
+	}
+	return *c.Spec.AddressCount
+}
+
+// IsAddressSet returns true if the addresses of the claim must all be
+// allocated from the same pool, for an HA address set or an address count
+func (c *IPClaim) IsAddressSet() bool {
+	return c.Spec.HAAddressSet || c.GetAddressCount() > 1
+}
+
+// GetBindingHolds returns the sorted names of the holds of the binding of the
+// claim, from its hold annotations
+func (c *IPClaim) GetBindingHolds() []string {
+	holds := []string{}
+	for annotation := range c.Annotations {
+		if strings.HasPrefix(annotation, BindingHoldAnnotationPrefix) {
+			holds = append(holds, strings.TrimPrefix(annotation, BindingHoldAnnotationPrefix))
+		}
+	}
+	sort.Strings(holds)
+	return holds
+}
+
+// GetLeaseRenewal returns the time of the last renewal of the lease of the
+// claim, from the lease renewed annotation, or the zero time if the lease was
+// never renewed
+func (c *IPClaim) GetLeaseRenewal() (time.Time, error) {
+	value, ok := c.Annotations[LeaseRenewedAnnotation]
+	if !ok {
+		return time.Time{}, nil
+	}
+	renewal, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, errors.Wrapf(err, "invalid %s annotation", LeaseRenewedAnnotation)
+	}
+	return renewal, nil
+}
+
+// GetMACAddress returns the MAC address of the claim from the MAC address
+// annotation, in the lowercase colon-separated form, or an empty string if
+// the claim has none
+func (c *IPClaim) GetMACAddress() (string, error) {
+	value, ok := c.Annotations[MACAddressAnnotation]
+	if !ok {
+		return "", nil
+	}
+	mac, err := net.ParseMAC(value)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid %s annotation", MACAddressAnnotation)
+	}
+	return mac.String(), nil
+}
+
+// claimObjectMeta returns the metadata of the claims of the set, without the
+// name
+func (c *IPClaimSet) claimObjectMeta() metav1.ObjectMeta {
+	labels := make(map[string]string, len(c.Spec.Template.Labels)+1)
+	for key, value := range c.Spec.Template.Labels {
+		labels[key] = value
+	}
+	labels[IPClaimSetLabel] = c.Name
+	annotations := make(map[string]string, len(c.Spec.Template.Annotations))
+	for key, value := range c.Spec.Template.Annotations {
+		annotations[key] = value
+	}
+	return metav1.ObjectMeta{
+		GenerateName: c.Name + "-",
+		Namespace:    c.Namespace,
+		Labels:       labels,
+		Annotations:  annotations,
+	}
+}
+
+// NewIPClaim renders a new claim of the set from the template
+func (c *IPClaimSet) NewIPClaim() *IPClaim {
+	return &IPClaim{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "IPClaim",
+			APIVersion: GroupVersion.String(),
+		},
+		ObjectMeta: c.claimObjectMeta(),
+		Spec:       *c.Spec.Template.Spec.DeepCopy(),
+	}
+}
+
+// GetPoolName returns the name of the IPPool created by the IPPoolClaim
+func (c *IPPoolClaim) GetPoolName() string {
+	if c.Spec.PoolName != "" {
+		return c.Spec.PoolName
+	}
+	return c.Name
+}
+
+// NewPrefixClaim renders the IPClaim of the prefix of the IPPoolClaim, from
+// its super-pool
+func (c *IPPoolClaim) NewPrefixClaim() *IPClaim {
+	return &IPClaim{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "IPClaim",
+			APIVersion: GroupVersion.String(),
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      c.Name,
+			Namespace: c.Namespace,
+			Labels: map[string]string{
+				IPPoolClaimLabel: c.Name,
+			},
+		},
+		Spec: IPClaimSpec{
+			Pool: corev1.ObjectReference{
+				APIVersion: GroupVersion.String(),
+				Kind:       ClusterIPPoolKind,
+				Name:       c.Spec.SuperPool,
+			},
+		},
+	}
+}
+
+// NewIPPool renders the IPPool of the IPPoolClaim from the template, with
+// the prefix carved out of the super-pool
+func (c *IPPoolClaim) NewIPPool(prefix IPSubnetStr) *IPPool {
+	ipPool := c.templateIPPool()
+	ipPool.Spec.Pools = []Pool{{Subnet: &prefix}}
+	return ipPool
+}
+
+// templateIPPool renders the IPPool of the IPPoolClaim from the template,
+// without pools
+func (c *IPPoolClaim) templateIPPool() *IPPool {
+	labels := make(map[string]string, len(c.Spec.Template.Labels)+1)
+	for key, value := range c.Spec.Template.Labels {
+		labels[key] = value
+	}
+	labels[IPPoolClaimLabel] = c.Name
+	annotations := make(map[string]string, len(c.Spec.Template.Annotations))
+	for key, value := range c.Spec.Template.Annotations {
+		annotations[key] = value
+	}
+	ipPool := &IPPool{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "IPPool",
+			APIVersion: GroupVersion.String(),
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        c.GetPoolName(),
+			Namespace:   c.Namespace,
+			Labels:      labels,
+			Annotations: annotations,
+		},
+		Spec: *c.Spec.Template.Spec.DeepCopy(),
+	}
+	if ipPool.Spec.NamePrefix == "" {
+		ipPool.Spec.NamePrefix = ipPool.Name
+	}
+	if ipPool.Spec.Prefix == 0 {
+		ipPool.Spec.Prefix = c.Spec.PrefixLength
+	}
+	return ipPool
+}
+
 // GetIPAddress renders the IP address, taking the index, offset and step into
 // account, it is IP version agnostic
 func GetIPAddress(entry Pool, index int) (IPAddressStr, error) {
+	poolRange, err := NewPoolRange(entry)
+	if err != nil {
+		return "", err
+	}
+	return poolRange.GetIPAddress(index)
+}
 
+// PoolRange is the parsed form of a Pool. It allows walking through the
+// addresses of the pool without parsing the pool definition for each index.
+// +kubebuilder:object:generate=false
+type PoolRange struct {
+	start net.IP
+	end   net.IP
+	ipNet *net.IPNet
+	// startFromSubnet is set if the start is derived from the subnet
+	startFromSubnet bool
+	// allocateNetworkAndBroadcast is set if the network and broadcast
+	// addresses of the subnet are allocated like the others
+	allocateNetworkAndBroadcast bool
+	// excluded contains the first and last addresses of the excluded ranges
+	excluded [][2]net.IP
+}
+
+// NewPoolRange parses the pool definition. If the start is not given, it is
+// derived from the subnet ip incremented by 1, or is the network address if
+// the network and broadcast addresses are allocated.
+func NewPoolRange(entry Pool) (*PoolRange, error) {
 	if entry.Start == nil && entry.Subnet == nil {
-		return "", errors.New("Either Start or Subnet is required for ipAddress")
+		return nil, errors.New("Either Start or Subnet is required for ipAddress")
+	}
+	poolRange := &PoolRange{
+		allocateNetworkAndBroadcast: entry.AllocateNetworkAndBroadcast,
 	}
-	var ip net.IP
 	var err error
-	var ipNet *net.IPNet
-	offset := index
 
-	// If start is given, use it to add the offset
+	if entry.Subnet != nil {
+		var ip net.IP
+		ip, poolRange.ipNet, err = net.ParseCIDR(string(*entry.Subnet))
+		if err != nil {
+			return nil, err
+		}
+		if entry.Start == nil && entry.AllocateNetworkAndBroadcast {
+			poolRange.start = poolRange.ipNet.IP
+			poolRange.startFromSubnet = true
+		} else if entry.Start == nil {
+			poolRange.start, err = addOffsetToIP(ip, nil, 1)
+			if err != nil {
+				return nil, err
+			}
+			poolRange.startFromSubnet = true
+		}
+	}
 	if entry.Start != nil {
-		var endIP net.IP
+		poolRange.start = net.ParseIP(string(*entry.Start))
+		if poolRange.start == nil {
+			return nil, errors.New(fmt.Sprintf("Invalid start IP address : %s", *entry.Start))
+		}
 		if entry.End != nil {
-			endIP = net.ParseIP(string(*entry.End))
+			poolRange.end = net.ParseIP(string(*entry.End))
+			if poolRange.end == nil {
+				return nil, errors.New(fmt.Sprintf("Invalid end IP address : %s", *entry.End))
+			}
 		}
-		ip, err = addOffsetToIP(net.ParseIP(string(*entry.Start)), endIP, offset)
-		if err != nil {
-			return "", err
+	}
+	for _, excluded := range entry.Exclude {
+		first := net.ParseIP(string(excluded.Start))
+		if first == nil {
+			return nil, errors.New(fmt.Sprintf("Invalid excluded IP address : %s", excluded.Start))
+		}
+		last := first
+		if excluded.End != nil {
+			if last = net.ParseIP(string(*excluded.End)); last == nil {
+				return nil, errors.New(fmt.Sprintf("Invalid excluded IP address : %s", *excluded.End))
+			}
 		}
+		poolRange.excluded = append(poolRange.excluded, [2]net.IP{first.To16(), last.To16()})
+	}
+	return poolRange, nil
+}
 
-		// Verify that the IP is in the subnet
-		if entry.Subnet != nil {
-			_, ipNet, err = net.ParseCIDR(string(*entry.Subnet))
-			if err != nil {
-				return "", err
+// IsExcluded returns true if the given address is in an excluded range of the
+// pool
+func (r *PoolRange) IsExcluded(ip net.IP) bool {
+	for _, excluded := range r.excluded {
+		if compareIPs(excluded[0], ip) <= 0 && compareIPs(ip, excluded[1]) <= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// ExclusionEnd returns the last address of the excluded ranges of the pool
+// containing the given address, following the excluded ranges that overlap,
+// so that the walk of the pool skips them at once rather than address by
+// address. It returns false if the address is not excluded.
+func (r *PoolRange) ExclusionEnd(ip net.IP) (net.IP, bool) {
+	var end net.IP
+	for extended := true; extended; {
+		extended = false
+		for _, excluded := range r.excluded {
+			if compareIPs(excluded[0], ip) > 0 || compareIPs(ip, excluded[1]) > 0 ||
+				(end != nil && compareIPs(excluded[1], end) <= 0) {
+				continue
 			}
-			if !ipNet.Contains(ip) {
-				return "", errors.New("IP address out of bonds")
+			end = excluded[1]
+			extended = true
+		}
+		if extended {
+			// The excluded ranges starting right after the end are
+			// followed too
+			next, err := addOffsetToIP(end, nil, 1)
+			if err != nil {
+				break
 			}
+			ip = next
 		}
+	}
+	return end, end != nil
+}
 
-		// If it is not given, use the CIDR ip address and increment the offset by 1
-	} else {
-		ip, ipNet, err = net.ParseCIDR(string(*entry.Subnet))
-		if err != nil {
-			return "", err
+// GetIPAddress renders the IP address at the given index in the range
+func (r *PoolRange) GetIPAddress(index int) (IPAddressStr, error) {
+	ip, err := addOffsetToIP(r.start, r.end, index)
+	if err != nil {
+		return "", err
+	}
+
+	// Verify that the IP is in the subnet
+	if r.ipNet != nil && !r.ipNet.Contains(ip) {
+		return "", errors.New("IP address out of bonds")
+	}
+	return IPAddressStr(ip.String()), nil
+}
+
+// MaxPoolIndex is the largest index of an address in a range, the indexes
+// being ints. The addresses of the larger IPv6 ranges beyond it are not
+// reachable by index.
+const MaxPoolIndex = int(^uint(0) >> 1)
+
+// IndexOf returns the index of the given address in the range, as used by
+// GetIPAddress. It returns an error if the address is before the start of the
+// range or if the index is above MaxPoolIndex. The end of the range is not
+// verified.
+func (r *PoolRange) IndexOf(ip net.IP) (int, error) {
+	if ip.To16() == nil || (ip.To4() != nil) != (r.start.To4() != nil) {
+		return 0, errors.New("IP address family mismatch")
+	}
+	high, low := ipToUint64s(ip.To16())
+	startHigh, startLow := ipToUint64s(r.start.To16())
+	diffLow, borrow := bits.Sub64(low, startLow, 0)
+	diffHigh, borrow := bits.Sub64(high, startHigh, borrow)
+	if borrow != 0 {
+		return 0, errors.New(fmt.Sprintf("IP address before the start of the range : %s", ip.String()))
+	}
+	if diffHigh != 0 || diffLow > uint64(MaxPoolIndex) {
+		return 0, errors.New(fmt.Sprintf("IP address too far from the start of the range : %s", ip.String()))
+	}
+	return int(diffLow), nil
+}
+
+// Overlaps returns true if at least one address of the range is in the
+// given subnet
+func (r *PoolRange) Overlaps(ipNet *net.IPNet) bool {
+	first := ipNet.IP.Mask(ipNet.Mask).To16()
+	last := lastIPInSubnet(ipNet)
+	return compareIPs(r.start, last) <= 0 && compareIPs(r.last(), first) >= 0
+}
+
+// Contains returns true if the given address is in the range and, if the
+// subnet is given, in the subnet
+func (r *PoolRange) Contains(ip net.IP) bool {
+	if ip.To16() == nil || (ip.To4() != nil) != (r.start.To4() != nil) {
+		return false
+	}
+	if r.ipNet != nil && !r.ipNet.Contains(ip) {
+		return false
+	}
+	return compareIPs(r.start, ip) <= 0 && compareIPs(ip, r.last()) <= 0
+}
+
+// Prefix returns the prefix length of the subnet of the range, or 0 if the
+// subnet is not given
+func (r *PoolRange) Prefix() int {
+	if r.ipNet == nil {
+		return 0
+	}
+	prefix, _ := r.ipNet.Mask.Size()
+	return prefix
+}
+
+// EUI64Address returns the address of the /64 IPv6 subnet of the range whose
+// interface identifier is the modified EUI-64 of the given MAC address, as
+// produced by SLAAC
+func (r *PoolRange) EUI64Address(mac net.HardwareAddr) (IPAddressStr, error) {
+	if r.ipNet == nil || r.ipNet.IP.To4() != nil || r.Prefix() != 64 {
+		return "", errors.New("not an IPv6 /64 subnet")
+	}
+	var identifier []byte
+	switch len(mac) {
+	case 6:
+		identifier = []byte{mac[0], mac[1], mac[2], 0xff, 0xfe, mac[3], mac[4], mac[5]}
+	case 8:
+		identifier = append([]byte{}, mac...)
+	default:
+		return "", errors.Errorf("no EUI-64 for the MAC address %s", mac)
+	}
+	// Invert the universal/local bit
+	identifier[0] ^= 0x02
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, r.ipNet.IP.To16()[:8])
+	copy(ip[8:], identifier)
+	return IPAddressStr(ip.String()), nil
+}
+
+// InSubnet returns true if the subnet of the range is not given or if it
+// contains the given address
+func (r *PoolRange) InSubnet(ip net.IP) bool {
+	return r.ipNet == nil || r.ipNet.Contains(ip)
+}
+
+// IsNetworkOrBroadcast returns true if the given address is the network
+// address of the subnet of the range or, for IPv4, its broadcast address,
+// unless they are allocated. Point-to-point subnets (/31 and /127) and single
+// addresses have none.
+func (r *PoolRange) IsNetworkOrBroadcast(ip net.IP) bool {
+	if r.ipNet == nil || r.allocateNetworkAndBroadcast || !r.ipNet.Contains(ip) {
+		return false
+	}
+	ones, bits := r.ipNet.Mask.Size()
+	if bits-ones < 2 {
+		return false
+	}
+	if ip.Equal(r.ipNet.IP.Mask(r.ipNet.Mask)) {
+		return true
+	}
+	return ip.To4() != nil && ip.To16().Equal(lastIPInSubnet(r.ipNet))
+}
+
+// OverlapsRange returns true if at least one address is in both ranges
+func (r *PoolRange) OverlapsRange(other *PoolRange) bool {
+	if (r.start.To4() != nil) != (other.start.To4() != nil) {
+		return false
+	}
+	first, last := r.Bounds()
+	otherFirst, otherLast := other.Bounds()
+	return compareIPs(first, otherLast) <= 0 && compareIPs(otherFirst, last) <= 0
+}
+
+// Bounds returns the first and last addresses of the range, restricted to
+// the subnet if given
+func (r *PoolRange) Bounds() (net.IP, net.IP) {
+	first, last := r.start.To16(), r.last()
+	if r.ipNet != nil {
+		if subnetFirst := r.ipNet.IP.Mask(r.ipNet.Mask).To16(); compareIPs(first, subnetFirst) < 0 {
+			first = subnetFirst
 		}
-		offset++
-		ip, err = addOffsetToIP(ip, nil, offset)
-		if err != nil {
-			return "", err
+		if subnetLast := lastIPInSubnet(r.ipNet).To16(); compareIPs(last, subnetLast) > 0 {
+			last = subnetLast
 		}
+	}
+	return first, last
+}
+
+// IsIPv6 returns true if the range is an IPv6 range
+func (r *PoolRange) IsIPv6() bool {
+	return r.start.To4() == nil
+}
 
-		// Verify that the ip is in the subnet
-		if !ipNet.Contains(ip) {
-			return "", errors.New("IP address out of bonds")
+// Size returns the number of addresses of the range that can be allocated,
+// the network and broadcast addresses of the subnet excluded. It saturates at
+// math.MaxUint64 for the larger IPv6 ranges, ExactSize gives their size.
+func (r *PoolRange) Size() uint64 {
+	return saturateUint64(r.ExactSize())
+}
+
+// ExactSize returns the number of addresses of the range that can be
+// allocated, the network and broadcast addresses of the subnet excluded. It
+// is computed on 128 bits, so that the IPv6 ranges have their exact size.
+func (r *PoolRange) ExactSize() *big.Int {
+	first, last := r.Bounds()
+	if compareIPs(first, last) > 0 {
+		return new(big.Int)
+	}
+	size := addressCount(first, last)
+	if r.IsNetworkOrBroadcast(first) {
+		size.Sub(size, bigOne)
+	}
+	if !last.Equal(first) && r.IsNetworkOrBroadcast(last) {
+		size.Sub(size, bigOne)
+	}
+	// The excluded addresses are not allocated, the network and broadcast
+	// addresses being already counted out
+	for _, excluded := range r.excluded {
+		excludedFirst, excludedLast := excluded[0], excluded[1]
+		if compareIPs(excludedFirst, first) < 0 {
+			excludedFirst = first
 		}
+		if compareIPs(excludedLast, last) > 0 {
+			excludedLast = last
+		}
+		if compareIPs(excludedFirst, excludedLast) > 0 {
+			continue
+		}
+		count := addressCount(excludedFirst, excludedLast)
+		if r.IsNetworkOrBroadcast(excludedFirst) {
+			count.Sub(count, bigOne)
+		}
+		if !excludedLast.Equal(excludedFirst) && r.IsNetworkOrBroadcast(excludedLast) {
+			count.Sub(count, bigOne)
+		}
+		size.Sub(size, count)
+	}
+	if size.Sign() < 0 {
+		return new(big.Int)
+	}
+	return size
+}
+
+// Offset returns the offset of the given address from the start of the
+// range, computed on 128 bits. It returns an error if the address is before
+// the start of the range. The end of the range is not verified.
+func (r *PoolRange) Offset(ip net.IP) (*big.Int, error) {
+	if ip.To16() == nil || (ip.To4() != nil) != (r.start.To4() != nil) {
+		return nil, errors.New("IP address family mismatch")
+	}
+	offset := new(big.Int).Sub(
+		new(big.Int).SetBytes(ip.To16()), new(big.Int).SetBytes(r.start.To16()),
+	)
+	if offset.Sign() < 0 {
+		return nil, errors.New(fmt.Sprintf("IP address before the start of the range : %s", ip.String()))
+	}
+	return offset, nil
+}
+
+// AddressAtOffset renders the IP address at the given offset from the start
+// of the range, computed on 128 bits. Unlike GetIPAddress, the offset can be
+// beyond MaxPoolIndex. It returns an error if the address is out of the range
+// or of its subnet.
+func (r *PoolRange) AddressAtOffset(offset *big.Int) (IPAddressStr, error) {
+	if offset.Sign() < 0 {
+		return "", errors.New(fmt.Sprintf("Invalid negative offset for : %s", r.start.String()))
+	}
+	value := new(big.Int).Add(new(big.Int).SetBytes(r.start.To16()), offset)
+	if value.Cmp(new(big.Int).SetBytes(r.last())) > 0 {
+		return "", errors.New(fmt.Sprintf("IP address out of bonds for : %s", r.start.String()))
+	}
+	ip := net.IP(value.FillBytes(make([]byte, net.IPv6len)))
+	if r.ipNet != nil && !r.ipNet.Contains(ip) {
+		return "", errors.New("IP address out of bonds")
 	}
 	return IPAddressStr(ip.String()), nil
 }
 
-// addOffsetToIP computes the value of the IP address with the offset. It is
-// IP version agnostic
-// Note that if the resulting IP address is in the format ::ffff:xxxx:xxxx then
-// ip.String will fail to select the correct type of ip
-func addOffsetToIP(ip, endIP net.IP, offset int) (net.IP, error) {
-	ip4 := false
-	//ip := net.ParseIP(ipString)
-	if ip.To4() != nil {
-		ip4 = true
+// bigOne is the constant 1, for the computations on 128 bits
+var bigOne = big.NewInt(1)
+
+// addressCount returns the number of addresses from first to last included
+func addressCount(first, last net.IP) *big.Int {
+	count := new(big.Int).Sub(
+		new(big.Int).SetBytes(last.To16()), new(big.Int).SetBytes(first.To16()),
+	)
+	return count.Add(count, bigOne)
+}
+
+// saturateUint64 converts a count to an uint64, saturating at
+// math.MaxUint64
+func saturateUint64(count *big.Int) uint64 {
+	if !count.IsUint64() {
+		return math.MaxUint64
+	}
+	return count.Uint64()
+}
+
+// PrefixAt returns the first prefix of the given length, aligned on its
+// length, that starts at or after the given address and is entirely in the
+// range. The prefixes of a range without start begin at the network address
+// of its subnet. It returns an error if there is none.
+func (r *PoolRange) PrefixAt(ip net.IP, length int) (*net.IPNet, error) {
+	bitsLen := 8 * net.IPv6len
+	if r.start.To4() != nil {
+		bitsLen = 8 * net.IPv4len
+	}
+	if length <= 0 || length > bitsLen {
+		return nil, errors.New(fmt.Sprintf("Invalid prefix length : %d", length))
+	}
+	first, last := r.Bounds()
+	if r.startFromSubnet {
+		first = r.ipNet.IP.Mask(r.ipNet.Mask).To16()
+	}
+	if ip != nil {
+		if ip.To16() == nil || (ip.To4() != nil) != (r.start.To4() != nil) {
+			return nil, errors.New("IP address family mismatch")
+		}
+		if compareIPs(ip, first) > 0 {
+			first = ip.To16()
+		}
+	}
+
+	mask := net.CIDRMask(length, bitsLen)
+	prefix := &net.IPNet{IP: first.Mask(mask).To16(), Mask: mask}
+	if !prefix.IP.Equal(first) {
+		// Move to the next aligned prefix
+		next, err := addOffsetToIP(lastIPInSubnet(prefix), nil, 1)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("No prefix of length %d left in the range", length))
+		}
+		prefix.IP = next
+	}
+	if compareIPs(lastIPInSubnet(prefix), last) > 0 {
+		return nil, errors.New(fmt.Sprintf("No prefix of length %d left in the range", length))
+	}
+	if bitsLen == 8*net.IPv4len {
+		prefix.IP = prefix.IP.To4()
+	}
+	return prefix, nil
+}
+
+// PrefixAfter returns the prefix of the range that follows the given one,
+// with the same length. It returns an error if there is none.
+func (r *PoolRange) PrefixAfter(prefix *net.IPNet) (*net.IPNet, error) {
+	length, _ := prefix.Mask.Size()
+	next, err := addOffsetToIP(lastIPInSubnet(prefix), nil, 1)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("No prefix of length %d left in the range", length))
+	}
+	return r.PrefixAt(next, length)
+}
+
+// PrefixCount returns the number of prefixes of the given length in the
+// range, as returned by PrefixAt. It saturates at math.MaxUint64.
+func (r *PoolRange) PrefixCount(length int) uint64 {
+	return saturateUint64(r.ExactPrefixCount(length))
+}
+
+// ExactPrefixCount returns the number of prefixes of the given length in the
+// range, as returned by PrefixAt, computed on 128 bits.
+func (r *PoolRange) ExactPrefixCount(length int) *big.Int {
+	prefix, err := r.PrefixAt(nil, length)
+	if err != nil {
+		return new(big.Int)
+	}
+	_, last := r.Bounds()
+	ones, bitsLen := prefix.Mask.Size()
+	count := addressCount(prefix.IP, last)
+	return count.Rsh(count, uint(bitsLen-ones))
+}
+
+// Capacity returns the number of addresses of the enabled pools, or of
+// prefixes if the IPPool delegates prefixes. It saturates at math.MaxUint64,
+// ExactCapacity gives the capacity of the larger IPv6 pools.
+func (s *IPPoolSpec) Capacity() (uint64, error) {
+	total, err := s.ExactCapacity()
+	if err != nil {
+		return 0, err
+	}
+	return saturateUint64(total), nil
+}
+
+// ExactCapacity returns the number of addresses of the enabled pools, or of
+// prefixes if the IPPool delegates prefixes, computed on 128 bits.
+func (s *IPPoolSpec) ExactCapacity() (*big.Int, error) {
+	total := new(big.Int)
+	for _, pool := range s.Pools {
+		if pool.Disabled {
+			continue
+		}
+		poolRange, err := NewPoolRange(pool)
+		if err != nil {
+			return nil, err
+		}
+		if s.DelegatedPrefix != 0 {
+			total.Add(total, poolRange.ExactPrefixCount(s.DelegatedPrefix))
+			continue
+		}
+		total.Add(total, poolRange.ExactSize())
 	}
+	return total, nil
+}
 
-	// Create big integers
-	IPInt := big.NewInt(0)
-	OffsetInt := big.NewInt(int64(offset))
+// last returns the last address of the range. If the end is not given, it
+// is the last address of the subnet or of the IP family.
+func (r *PoolRange) last() net.IP {
+	if r.end != nil {
+		return r.end.To16()
+	}
+	if r.ipNet != nil {
+		return lastIPInSubnet(r.ipNet)
+	}
+	if r.start.To4() != nil {
+		return net.IPv4bcast.To16()
+	}
+	return uint64sToIP(^uint64(0), ^uint64(0))
+}
 
-	// Transform the ip into an int. (big endian function)
-	IPInt = IPInt.SetBytes(ip)
+// allocatedRangeSeparator separates the first and last addresses of an
+// allocated range
+const allocatedRangeSeparator = "-"
 
-	// add the two integers
-	IPInt = IPInt.Add(IPInt, OffsetInt)
+// CompactAddresses renders the addresses as a sorted list of ranges of
+// contiguous addresses, in the "first-last" form, or as single addresses.
+// Invalid addresses are ignored.
+func CompactAddresses(addresses []IPAddressStr) []string {
+	ips := []net.IP{}
+	for _, address := range addresses {
+		if ip := net.ParseIP(string(address)); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	sort.Slice(ips, func(i, j int) bool { return compareIPs(ips[i], ips[j]) < 0 })
 
-	// return the bytes list
-	IPBytes := IPInt.Bytes()
+	ranges := []string{}
+	for i := 0; i < len(ips); {
+		first, last := ips[i], ips[i]
+		for i++; i < len(ips); i++ {
+			if ips[i].Equal(last) {
+				continue
+			}
+			next, err := addOffsetToIP(last, nil, 1)
+			if err != nil || !next.Equal(ips[i]) {
+				break
+			}
+			last = ips[i]
+		}
+		if first.Equal(last) {
+			ranges = append(ranges, first.String())
+			continue
+		}
+		ranges = append(ranges, first.String()+allocatedRangeSeparator+last.String())
+	}
+	return ranges
+}
 
-	IPBytesLen := len(IPBytes)
+// AllocatedAddresses returns the allocated addresses of the pool, whether
+// they are stored in the allocations or as allocated ranges. Invalid ranges
+// are ignored.
+func (s *IPPoolStatus) AllocatedAddresses() []IPAddressStr {
+	addresses := []IPAddressStr{}
+	for _, address := range s.Allocations {
+		addresses = append(addresses, address)
+	}
+	for _, allocatedRange := range s.AllocatedRanges {
+		bounds := strings.SplitN(allocatedRange, allocatedRangeSeparator, 2)
+		first := net.ParseIP(bounds[0])
+		last := first
+		if len(bounds) == 2 {
+			last = net.ParseIP(bounds[1])
+		}
+		if first == nil || last == nil || (first.To4() != nil) != (last.To4() != nil) {
+			continue
+		}
+		for ip := first; ip != nil && compareIPs(ip, last) <= 0; {
+			addresses = append(addresses, IPAddressStr(ip.String()))
+			ip, _ = addOffsetToIP(ip, nil, 1)
+		}
+	}
+	return addresses
+}
+
+// lastIPInSubnet returns the last address of the subnet in its 16 bytes form
+func lastIPInSubnet(ipNet *net.IPNet) net.IP {
+	ip := ipNet.IP
+	if ip4 := ip.To4(); ip4 != nil && len(ipNet.Mask) == net.IPv4len {
+		ip = ip4
+	}
+	last := make(net.IP, len(ip))
+	for i := range ip {
+		last[i] = ip[i] | ^ipNet.Mask[i]
+	}
+	return last.To16()
+}
+
+// compareIPs compares two IP addresses of the same family
+func compareIPs(a, b net.IP) int {
+	aHigh, aLow := ipToUint64s(a.To16())
+	bHigh, bLow := ipToUint64s(b.To16())
+	switch {
+	case aHigh < bHigh || (aHigh == bHigh && aLow < bLow):
+		return -1
+	case aHigh == bHigh && aLow == bLow:
+		return 0
+	}
+	return 1
+}
+
+// addOffsetToIP computes the value of the IP address with the offset. It is
+// IP version agnostic. The computation is done on the 128 bits of the address
+// split in two 64 bits words, and the result can not overflow the address
+// space of the IP family of the given ip.
+// The returned IP is always in its 16 bytes form.
+func addOffsetToIP(ip, endIP net.IP, offset int) (net.IP, error) {
+	if offset < 0 {
+		return nil, errors.New(fmt.Sprintf("Invalid negative offset for : %s", ip.String()))
+	}
+	ip4 := ip.To4() != nil
+	ip16 := ip.To16()
+	if ip16 == nil {
+		return nil, errors.New("Invalid IP address")
+	}
+
+	high, low := ipToUint64s(ip16)
+	var carry uint64
+	low, carry = bits.Add64(low, uint64(offset), 0)
+	high, carry = bits.Add64(high, 0, carry)
 
 	// Verify that the IPv4 or IPv6 fulfills theirs constraints
-	if (ip4 && IPBytesLen > 6 && IPBytes[4] != 255 && IPBytes[5] != 255) ||
-		(!ip4 && IPBytesLen > 16) {
+	// (IPv4 addresses are stored with the ::ffff:0:0/96 prefix)
+	if (ip4 && (high != 0 || low>>32 != 0xffff)) || (!ip4 && carry != 0) {
 		return nil, errors.New(fmt.Sprintf("IP address overflow for : %s", ip.String()))
 	}
 
-	//transform the end ip into an Int to compare
-	if endIP != nil {
-		endIPInt := big.NewInt(0)
-		endIPInt = endIPInt.SetBytes(endIP)
-		// Computed IP is higher than the end IP
-		if IPInt.Cmp(endIPInt) > 0 {
+	// Computed IP is higher than the end IP
+	if endIP != nil && endIP.To16() != nil {
+		endHigh, endLow := ipToUint64s(endIP.To16())
+		if high > endHigh || (high == endHigh && low > endLow) {
 			return nil, errors.New(fmt.Sprintf("IP address out of bonds for : %s", ip.String()))
 		}
 	}
 
-	// COpy the output back into an ip
-	copy(ip[16-IPBytesLen:], IPBytes)
-	return ip, nil
+	return uint64sToIP(high, low), nil
+}
+
+// ipToUint64s splits a 16 bytes IP address in two 64 bits words
+func ipToUint64s(ip net.IP) (uint64, uint64) {
+	return binary.BigEndian.Uint64(ip[:8]), binary.BigEndian.Uint64(ip[8:])
+}
+
+// uint64sToIP builds a 16 bytes IP address from two 64 bits words
+func uint64sToIP(high, low uint64) net.IP {
+	ip := make(net.IP, net.IPv6len)
+	binary.BigEndian.PutUint64(ip[:8], high)
+	binary.BigEndian.PutUint64(ip[8:], low)
+	return ip
 }
@@ -17,16 +17,386 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"context"
+	"math"
+	"math/big"
+	"math/rand"
 	"net"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
 
 var _ = Describe("IPPool manager", func() {
+	DescribeTable("Test IsNamespaceAllowed",
+		func(allowedNamespaces []string, namespace string, expected bool) {
+			ipPool := &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "abc",
+					Namespace: "myns",
+				},
+				Spec: IPPoolSpec{
+					AllowedNamespaces: allowedNamespaces,
+				},
+			}
+			Expect(ipPool.IsNamespaceAllowed(namespace)).To(Equal(expected))
+		},
+		Entry("Same namespace", nil, "myns", true),
+		Entry("Other namespace, denied by default", nil, "foo", false),
+		Entry("Other namespace, not in list", []string{"bar"}, "foo", false),
+		Entry("Other namespace, in list", []string{"bar", "foo"}, "foo", true),
+		Entry("Other namespace, all allowed", []string{AllNamespaces}, "foo", true),
+	)
+
+	DescribeTable("Test DeletionBlockedMessage",
+		func(status IPPoolStatus, expected string) {
+			ipPool := &IPPool{Status: status}
+			Expect(ipPool.DeletionBlockedMessage()).To(Equal(expected))
+		},
+		Entry("No allocation", IPPoolStatus{}, ""),
+		Entry("Allocations", IPPoolStatus{
+			Allocations: map[string]IPAddressStr{
+				"bcd":       "192.168.0.10",
+				"abc":       "192.168.0.11",
+				"abc:vip":   "192.168.0.12",
+				"ns/abc:gw": "192.168.0.13",
+			},
+		}, "addresses are allocated to the claims abc, bcd, ns/abc"),
+		Entry("Many allocations", IPPoolStatus{
+			Allocations: map[string]IPAddressStr{
+				"a": "192.168.0.1", "b": "192.168.0.2", "c": "192.168.0.3",
+				"d": "192.168.0.4", "e": "192.168.0.5", "f": "192.168.0.6",
+				"g": "192.168.0.7", "h": "192.168.0.8", "i": "192.168.0.9",
+				"j": "192.168.0.10", "k": "192.168.0.11", "l": "192.168.0.12",
+			},
+		}, "addresses are allocated to the claims a, b, c, d, e, f, g, h, i, j and 2 more"),
+		Entry("Compacted allocations", IPPoolStatus{
+			AllocatedRanges: []string{"192.168.0.1-192.168.0.5", "192.168.0.9"},
+		}, "addresses are allocated in 192.168.0.1-192.168.0.5, 192.168.0.9"),
+	)
+
+	DescribeTable("Test OutOfRangeMessage",
+		func(pools []Pool, allocations map[string]IPAddressStr, expected string) {
+			ipPool := &IPPool{
+				Spec:   IPPoolSpec{Pools: pools},
+				Status: IPPoolStatus{Allocations: allocations},
+			}
+			Expect(ipPool.OutOfRangeMessage()).To(Equal(expected))
+		},
+		Entry("No allocation", []Pool{
+			{Start: ipAddressStrPtr("192.168.0.10"), End: ipAddressStrPtr("192.168.0.20")},
+		}, nil, ""),
+		Entry("Allocations in range", []Pool{
+			{Start: ipAddressStrPtr("192.168.0.10"), End: ipAddressStrPtr("192.168.0.20")},
+		}, map[string]IPAddressStr{"abc": "192.168.0.11"}, ""),
+		Entry("Allocations out of range", []Pool{
+			{Start: ipAddressStrPtr("192.168.0.10"), End: ipAddressStrPtr("192.168.0.20")},
+		}, map[string]IPAddressStr{
+			"abc": "192.168.0.11",
+			"bcd": "192.168.0.30",
+			"":    "192.168.0.9",
+		}, "addresses out of the pools are allocated: 192.168.0.9, 192.168.0.30 (bcd)"),
+		Entry("Excluded allocations", []Pool{
+			{
+				Start: ipAddressStrPtr("192.168.0.10"), End: ipAddressStrPtr("192.168.0.20"),
+				Exclude: []ExcludedRange{{Start: "192.168.0.11"}},
+			},
+		}, map[string]IPAddressStr{"abc": "192.168.0.11"},
+			"addresses out of the pools are allocated: 192.168.0.11 (abc)",
+		),
+	)
+
+	DescribeTable("Test IsNamed",
+		func(renamedFrom string, name string, expected bool) {
+			ipPool := &IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "abc",
+					Namespace:   "myns",
+					Annotations: map[string]string{RenamedFromAnnotation: renamedFrom},
+				},
+			}
+			Expect(ipPool.IsNamed(name)).To(Equal(expected))
+		},
+		Entry("Name", "", "abc", true),
+		Entry("Other name", "", "bcd", false),
+		Entry("Former name", "bcd", "bcd", true),
+		Entry("Former names", "bcd, cde", "cde", true),
+		Entry("Not a former name", "bcd,cde", "def", false),
+		Entry("Empty name", "bcd,,cde", "", false),
+	)
+
+	DescribeTable("Test PropagatedAnnotations",
+		func(propagated []string, annotations map[string]string, expected map[string]string) {
+			ipPool := &IPPool{
+				Spec: IPPoolSpec{
+					PropagatedAnnotations: propagated,
+				},
+			}
+			claim := &IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: annotations,
+				},
+			}
+			Expect(ipPool.PropagatedAnnotations(claim)).To(Equal(expected))
+		},
+		Entry("No propagation", nil, map[string]string{"foo": "bar"}, nil),
+		Entry("No annotations", []string{"foo"}, nil, nil),
+		Entry("Keys", []string{"foo", "rack"},
+			map[string]string{"foo": "bar", "rack": "r1", "other": "value"},
+			map[string]string{"foo": "bar", "rack": "r1"},
+		),
+		Entry("Prefix", []string{"example.com/*"},
+			map[string]string{"example.com/rack": "r1", "example.com/ticket": "T-1", "example.org/rack": "r2"},
+			map[string]string{"example.com/rack": "r1", "example.com/ticket": "T-1"},
+		),
+	)
+
+	DescribeTable("Test GetIPPool",
+		func(key client.ObjectKey, expectedPool string) {
+			s := runtime.NewScheme()
+			Expect(AddToScheme(s)).To(Succeed())
+			c := fake.NewClientBuilder().WithScheme(s).WithObjects(
+				&IPPool{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "abc",
+						Namespace: "myns",
+					},
+				},
+				&IPPool{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "bcd",
+						Namespace: "myns",
+					},
+				},
+				&IPPool{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:        "cde",
+						Namespace:   "myns",
+						Annotations: map[string]string{RenamedFromAnnotation: "bcd,def"},
+					},
+				},
+			).Build()
+
+			ipPool, err := GetIPPool(context.TODO(), c, key)
+			if expectedPool == "" {
+				Expect(apierrors.IsNotFound(err)).To(BeTrue())
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ipPool.Name).To(Equal(expectedPool))
+		},
+		Entry("Pool", client.ObjectKey{Name: "abc", Namespace: "myns"}, "abc"),
+		Entry("Renamed pool", client.ObjectKey{Name: "def", Namespace: "myns"}, "cde"),
+		Entry("Renamed pool, former pool exists", client.ObjectKey{Name: "bcd", Namespace: "myns"}, "cde"),
+		Entry("Not found", client.ObjectKey{Name: "efg", Namespace: "myns"}, ""),
+		Entry("Other namespace", client.ObjectKey{Name: "def", Namespace: "otherns"}, ""),
+	)
+
+	DescribeTable("Test ClusterIPPool IsNamespaceAllowed",
+		func(allowedNamespaces []string, namespace string, expected bool) {
+			clusterIPPool := &ClusterIPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "abc",
+				},
+				Spec: IPPoolSpec{
+					AllowedNamespaces: allowedNamespaces,
+				},
+			}
+			ipPool := clusterIPPool.AsIPPool()
+			Expect(ipPool.IsClusterScoped()).To(BeTrue())
+			Expect(ipPool.IsNamespaceAllowed(namespace)).To(Equal(expected))
+		},
+		Entry("All allowed by default", nil, "foo", true),
+		Entry("Not in list", []string{"bar"}, "foo", false),
+		Entry("In list", []string{"bar", "foo"}, "foo", true),
+		Entry("All allowed", []string{AllNamespaces}, "foo", true),
+	)
+
+	DescribeTable("Test GetClaimPool",
+		func(pool corev1.ObjectReference, expectedPool string, expectClusterScoped bool) {
+			s := runtime.NewScheme()
+			Expect(AddToScheme(s)).To(Succeed())
+			c := fake.NewClientBuilder().WithScheme(s).WithObjects(
+				&IPPool{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "abc",
+						Namespace: "myns",
+					},
+				},
+				&ClusterIPPool{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "abc",
+					},
+				},
+			).Build()
+			claim := &IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "claim",
+					Namespace: "myns",
+				},
+				Spec: IPClaimSpec{
+					Pool: pool,
+				},
+			}
+
+			ipPool, err := GetClaimPool(context.TODO(), c, claim)
+			if expectedPool == "" {
+				Expect(apierrors.IsNotFound(err)).To(BeTrue())
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ipPool.Name).To(Equal(expectedPool))
+			Expect(ipPool.IsClusterScoped()).To(Equal(expectClusterScoped))
+		},
+		Entry("IPPool", corev1.ObjectReference{Name: "abc"}, "abc", false),
+		Entry("ClusterIPPool", corev1.ObjectReference{Name: "abc", Kind: ClusterIPPoolKind}, "abc", true),
+		Entry("ClusterIPPool not found", corev1.ObjectReference{Name: "bcd", Kind: ClusterIPPoolKind}, "", false),
+	)
+
+	type testCaseSelectClaimPool struct {
+		pool         corev1.ObjectReference
+		selector     *metav1.LabelSelector
+		expectedPool string
+		expectError  bool
+	}
+
+	DescribeTable("Test SelectClaimPool",
+		func(tc testCaseSelectClaimPool) {
+			s := runtime.NewScheme()
+			Expect(AddToScheme(s)).To(Succeed())
+			exhausted := IPPoolStatus{
+				Conditions: []metav1.Condition{{
+					Type:   IPPoolExhaustedCondition,
+					Status: metav1.ConditionTrue,
+					Reason: "NoAddressAvailable",
+				}},
+			}
+			provisioning := map[string]string{"role": "provisioning"}
+			c := fake.NewClientBuilder().WithScheme(s).WithObjects(
+				&IPPool{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "aaa",
+						Namespace: "myns",
+						Labels:    provisioning,
+					},
+					Status: exhausted,
+				},
+				&IPPool{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "ccc",
+						Namespace: "myns",
+						Labels:    provisioning,
+					},
+				},
+				&IPPool{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "bbb",
+						Namespace: "myns",
+						Labels:    provisioning,
+					},
+				},
+				&IPPool{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "abc",
+						Namespace: "otherns",
+						Labels:    provisioning,
+					},
+				},
+				&IPPool{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "ddd",
+						Namespace: "myns",
+						Labels:    map[string]string{"role": "external"},
+					},
+					Status: exhausted,
+				},
+				&ClusterIPPool{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:   "aaa",
+						Labels: provisioning,
+					},
+					Spec: IPPoolSpec{
+						AllowedNamespaces: []string{"otherns"},
+					},
+				},
+				&ClusterIPPool{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:   "bbb",
+						Labels: provisioning,
+					},
+				},
+			).Build()
+			claim := &IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "claim",
+					Namespace: "myns",
+				},
+				Spec: IPClaimSpec{
+					Pool:         tc.pool,
+					PoolSelector: tc.selector,
+				},
+			}
+
+			ipPool, err := SelectClaimPool(context.TODO(), c, claim)
+			if tc.expectError {
+				Expect(err).To(HaveOccurred())
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			if tc.expectedPool == "" {
+				Expect(ipPool).To(BeNil())
+				return
+			}
+			Expect(ipPool).NotTo(BeNil())
+			Expect(ipPool.Name).To(Equal(tc.expectedPool))
+			Expect(ipPool.IsClusterScoped()).To(Equal(IsClusterIPPoolRef(tc.pool)))
+		},
+		Entry("No selector", testCaseSelectClaimPool{}),
+		Entry("First pool not exhausted", testCaseSelectClaimPool{
+			selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"role": "provisioning"},
+			},
+			expectedPool: "bbb",
+		}),
+		Entry("Exhausted pool", testCaseSelectClaimPool{
+			selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"role": "external"},
+			},
+			expectedPool: "ddd",
+		}),
+		Entry("No pool matching", testCaseSelectClaimPool{
+			selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"role": "bmc"},
+			},
+		}),
+		Entry("ClusterIPPool allowing the namespace", testCaseSelectClaimPool{
+			pool: corev1.ObjectReference{Kind: ClusterIPPoolKind},
+			selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"role": "provisioning"},
+			},
+			expectedPool: "bbb",
+		}),
+		Entry("Invalid selector", testCaseSelectClaimPool{
+			selector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{{
+					Key:      "role",
+					Operator: "Unknown",
+				}},
+			},
+			expectError: true,
+		}),
+	)
+
 	type testCaseGetIPAddress struct {
 		ipAddress   Pool
 		index       int
@@ -34,6 +404,107 @@ var _ = Describe("IPPool manager", func() {
 		expectedIP  IPAddressStr
 	}
 
+	type testCaseGetPrefixOverride struct {
+		prefix         *int
+		annotations    map[string]string
+		expectedPrefix int
+		expectOverride bool
+		expectError    bool
+	}
+
+	DescribeTable("Test GetPrefixOverride",
+		func(tc testCaseGetPrefixOverride) {
+			claim := &IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: tc.annotations,
+				},
+				Spec: IPClaimSpec{
+					Prefix: tc.prefix,
+				},
+			}
+			prefix, ok, err := claim.GetPrefixOverride()
+			if tc.expectError {
+				Expect(err).To(HaveOccurred())
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ok).To(Equal(tc.expectOverride))
+			Expect(prefix).To(Equal(tc.expectedPrefix))
+		},
+		Entry("No override", testCaseGetPrefixOverride{}),
+		Entry("Field", testCaseGetPrefixOverride{
+			prefix:         pointer.IntPtr(24),
+			expectedPrefix: 24,
+			expectOverride: true,
+		}),
+		Entry("Annotation", testCaseGetPrefixOverride{
+			annotations:    map[string]string{PrefixAnnotation: "26"},
+			expectedPrefix: 26,
+			expectOverride: true,
+		}),
+		Entry("Field and annotation", testCaseGetPrefixOverride{
+			prefix:         pointer.IntPtr(24),
+			annotations:    map[string]string{PrefixAnnotation: "26"},
+			expectedPrefix: 24,
+			expectOverride: true,
+		}),
+		Entry("Invalid annotation", testCaseGetPrefixOverride{
+			annotations: map[string]string{PrefixAnnotation: "abc"},
+			expectError: true,
+		}),
+	)
+
+	DescribeTable("Test GetAddressRoles",
+		func(spec IPClaimSpec, expectedRoles []string) {
+			claim := &IPClaim{Spec: spec}
+			Expect(claim.GetAddressRoles()).To(Equal(expectedRoles))
+		},
+		Entry("Single address", IPClaimSpec{}, []string{""}),
+		Entry("HA address set", IPClaimSpec{HAAddressSet: true},
+			[]string{"", HAPeerRole, HAVIPRole},
+		),
+		Entry("Roles", IPClaimSpec{Roles: []string{"secondary", "bmc"}},
+			[]string{"", "secondary", "bmc"},
+		),
+		Entry("HA address set and roles", IPClaimSpec{HAAddressSet: true, Roles: []string{"bmc"}},
+			[]string{"", HAPeerRole, HAVIPRole, "bmc"},
+		),
+	)
+
+	DescribeTable("Test GetBindingHolds",
+		func(annotations map[string]string, expectedHolds []string) {
+			claim := &IPClaim{ObjectMeta: metav1.ObjectMeta{Annotations: annotations}}
+			Expect(claim.GetBindingHolds()).To(Equal(expectedHolds))
+		},
+		Entry("No annotations", nil, []string{}),
+		Entry("Other annotations", map[string]string{PrefixAnnotation: "24"}, []string{}),
+		Entry("Holds", map[string]string{
+			BindingHoldAnnotationPrefix + "security": "",
+			BindingHoldAnnotationPrefix + "network":  "team-a",
+			PrefixAnnotation:                         "24",
+		}, []string{"network", "security"}),
+	)
+
+	DescribeTable("Test GetLeaseRenewal",
+		func(annotations map[string]string, expectedRenewal time.Time, expectError bool) {
+			claim := &IPClaim{ObjectMeta: metav1.ObjectMeta{Annotations: annotations}}
+			renewal, err := claim.GetLeaseRenewal()
+			if expectError {
+				Expect(err).To(HaveOccurred())
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(renewal.Equal(expectedRenewal)).To(BeTrue())
+		},
+		Entry("Never renewed", nil, time.Time{}, false),
+		Entry("Renewed", map[string]string{
+			LeaseRenewedAnnotation: "2021-09-01T10:00:00+02:00",
+		}, time.Date(2021, 9, 1, 8, 0, 0, 0, time.UTC), false),
+		Entry("Invalid renewal", map[string]string{
+			LeaseRenewedAnnotation: "1630483200",
+		}, time.Time{}, true),
+	)
+
 	DescribeTable("Test getIPAddress",
 		func(tc testCaseGetIPAddress) {
 			result, err := GetIPAddress(tc.ipAddress, tc.index)
@@ -185,6 +656,698 @@ var _ = Describe("IPPool manager", func() {
 			offset:      100,
 			expectError: true,
 		}),
+		Entry("valid IPv6, carry over 64 bits", testCaseAddOffsetToIP{
+			ip:         "2001::FFFF:FFFF:FFFF:FFF0",
+			offset:     32,
+			expectedIP: "2001:0:0:1::10",
+		}),
+		Entry("valid IPv4, carry over octets", testCaseAddOffsetToIP{
+			ip:         "10.0.255.255",
+			offset:     1,
+			expectedIP: "10.1.0.0",
+		}),
+		Entry("valid IPv4, last address", testCaseAddOffsetToIP{
+			ip:         "255.255.255.250",
+			offset:     5,
+			expectedIP: "255.255.255.255",
+		}),
+		Entry("negative offset", testCaseAddOffsetToIP{
+			ip:          "192.168.0.10",
+			offset:      -1,
+			expectError: true,
+		}),
+	)
+
+	// referenceAddOffset computes the expected result of addOffsetToIP with
+	// math/big, returning nil if the result overflows the address space.
+	referenceAddOffset := func(ip net.IP, offset int) net.IP {
+		ipBytes := ip.To16()
+		size := 128
+		if ip.To4() != nil {
+			ipBytes = ip.To4()
+			size = 32
+		}
+		result := big.NewInt(0).SetBytes(ipBytes)
+		result.Add(result, big.NewInt(int64(offset)))
+		if result.BitLen() > size {
+			return nil
+		}
+		resultBytes := make([]byte, len(ipBytes))
+		result.FillBytes(resultBytes)
+		return net.IP(resultBytes).To16()
+	}
+
+	randomIP := func(r *rand.Rand, ipv4 bool) net.IP {
+		ip := make(net.IP, net.IPv6len)
+		if ipv4 {
+			ip = make(net.IP, net.IPv4len)
+		}
+		r.Read(ip)
+		// Make the overflows more likely
+		if r.Intn(4) == 0 {
+			for i := 0; i < len(ip)-1; i++ {
+				ip[i] = 0xff
+			}
+		}
+		return ip.To16()
+	}
+
+	DescribeTable("Test AddOffsetToIP with random addresses",
+		func(ipv4 bool) {
+			r := rand.New(rand.NewSource(GinkgoRandomSeed()))
+			for i := 0; i < 1000; i++ {
+				ip := randomIP(r, ipv4)
+				offset := r.Intn(1 << uint(r.Intn(40)+1))
+				expectedIP := referenceAddOffset(ip, offset)
+
+				result, err := addOffsetToIP(ip, nil, offset)
+				if expectedIP == nil {
+					Expect(err).To(HaveOccurred(), "ip %s offset %d", ip, offset)
+					continue
+				}
+				Expect(err).NotTo(HaveOccurred(), "ip %s offset %d", ip, offset)
+				Expect(result).To(Equal(expectedIP), "ip %s offset %d", ip, offset)
+
+				// The end IP bounds the result
+				_, err = addOffsetToIP(ip, expectedIP, offset)
+				Expect(err).NotTo(HaveOccurred())
+				if offset > 0 {
+					_, err = addOffsetToIP(ip, referenceAddOffset(ip, offset-1), offset)
+					Expect(err).To(HaveOccurred())
+				}
+			}
+		},
+		Entry("IPv4", true),
+		Entry("IPv6", false),
+	)
+
+	DescribeTable("Test PoolRange with random ranges",
+		func(ipv4 bool) {
+			r := rand.New(rand.NewSource(GinkgoRandomSeed()))
+			for i := 0; i < 100; i++ {
+				start := randomIP(r, ipv4)
+				size := r.Intn(50)
+				end := referenceAddOffset(start, size)
+				if end == nil {
+					continue
+				}
+				startStr := IPAddressStr(start.String())
+				endStr := IPAddressStr(end.String())
+				poolRange, err := NewPoolRange(Pool{Start: &startStr, End: &endStr})
+				Expect(err).NotTo(HaveOccurred())
+
+				for index := 0; index <= size; index++ {
+					address, err := poolRange.GetIPAddress(index)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(address).To(Equal(IPAddressStr(referenceAddOffset(start, index).String())))
+				}
+				_, err = poolRange.GetIPAddress(size + 1)
+				Expect(err).To(HaveOccurred())
+			}
+		},
+		Entry("IPv4", true),
+		Entry("IPv6", false),
+	)
+
+	DescribeTable("Test PoolRange OverlapsRange",
+		func(pool Pool, other Pool, expected bool) {
+			poolRange, err := NewPoolRange(pool)
+			Expect(err).NotTo(HaveOccurred())
+			otherRange, err := NewPoolRange(other)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(poolRange.OverlapsRange(otherRange)).To(Equal(expected))
+			Expect(otherRange.OverlapsRange(poolRange)).To(Equal(expected))
+		},
+		Entry("IPv4 ranges overlapping",
+			Pool{
+				Start: (*IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+				End:   (*IPAddressStr)(pointer.StringPtr("192.168.0.100")),
+			},
+			Pool{
+				Start: (*IPAddressStr)(pointer.StringPtr("192.168.0.100")),
+				End:   (*IPAddressStr)(pointer.StringPtr("192.168.0.200")),
+			},
+			true,
+		),
+		Entry("IPv4 ranges disjoint",
+			Pool{
+				Start: (*IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+				End:   (*IPAddressStr)(pointer.StringPtr("192.168.0.100")),
+			},
+			Pool{
+				Start: (*IPAddressStr)(pointer.StringPtr("192.168.0.101")),
+				End:   (*IPAddressStr)(pointer.StringPtr("192.168.0.200")),
+			},
+			false,
+		),
+		Entry("IPv4 range restricted by its subnet",
+			Pool{
+				Start:  (*IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+				Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24")),
+			},
+			Pool{
+				Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.1.0/24")),
+			},
+			false,
+		),
+		Entry("IPv4 and IPv6 ranges",
+			Pool{
+				Start: (*IPAddressStr)(pointer.StringPtr("0.0.0.1")),
+			},
+			Pool{
+				Start: (*IPAddressStr)(pointer.StringPtr("::1")),
+			},
+			false,
+		),
+	)
+
+	type testCasePoolRangeSubnet struct {
+		pool           Pool
+		address        string
+		subnet         string
+		expectedIndex  int
+		expectIndexErr bool
+		expectOverlap  bool
+	}
+
+	DescribeTable("Test PoolRange IndexOf and Overlaps",
+		func(tc testCasePoolRangeSubnet) {
+			poolRange, err := NewPoolRange(tc.pool)
+			Expect(err).NotTo(HaveOccurred())
+
+			index, err := poolRange.IndexOf(net.ParseIP(tc.address))
+			if tc.expectIndexErr {
+				Expect(err).To(HaveOccurred())
+			} else {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(index).To(Equal(tc.expectedIndex))
+			}
+
+			_, subnet, err := net.ParseCIDR(tc.subnet)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(poolRange.Overlaps(subnet)).To(Equal(tc.expectOverlap))
+		},
+		Entry("IPv4 range, subnet inside", testCasePoolRangeSubnet{
+			pool: Pool{
+				Start: (*IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+				End:   (*IPAddressStr)(pointer.StringPtr("192.168.0.100")),
+			},
+			address:       "192.168.0.32",
+			subnet:        "192.168.0.32/28",
+			expectedIndex: 22,
+			expectOverlap: true,
+		}),
+		Entry("IPv4 range, subnet partially overlapping", testCasePoolRangeSubnet{
+			pool: Pool{
+				Start: (*IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+				End:   (*IPAddressStr)(pointer.StringPtr("192.168.0.100")),
+			},
+			address:        "192.168.0.0",
+			subnet:         "192.168.0.0/28",
+			expectIndexErr: true,
+			expectOverlap:  true,
+		}),
+		Entry("IPv4 range, subnet after the range", testCasePoolRangeSubnet{
+			pool: Pool{
+				Start: (*IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+				End:   (*IPAddressStr)(pointer.StringPtr("192.168.0.100")),
+			},
+			address:       "192.168.0.128",
+			subnet:        "192.168.0.128/25",
+			expectedIndex: 118,
+			expectOverlap: false,
+		}),
+		Entry("IPv4 subnet, subnet outside", testCasePoolRangeSubnet{
+			pool: Pool{
+				Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24")),
+			},
+			address:       "192.168.0.11",
+			subnet:        "192.168.1.0/24",
+			expectedIndex: 10,
+			expectOverlap: false,
+		}),
+		Entry("IPv4 range, IPv6 address", testCasePoolRangeSubnet{
+			pool: Pool{
+				Start: (*IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+				End:   (*IPAddressStr)(pointer.StringPtr("192.168.0.100")),
+			},
+			address:        "2001::1",
+			subnet:         "192.168.0.0/16",
+			expectIndexErr: true,
+			expectOverlap:  true,
+		}),
+		Entry("IPv6 range, address too far", testCasePoolRangeSubnet{
+			pool: Pool{
+				Start: (*IPAddressStr)(pointer.StringPtr("2001::1")),
+			},
+			address:        "2001::8000:0:0:1",
+			subnet:         "2001::8000:0:0:0/80",
+			expectIndexErr: true,
+			expectOverlap:  true,
+		}),
+		Entry("IPv6 range, address far in the range", testCasePoolRangeSubnet{
+			pool: Pool{
+				Start: (*IPAddressStr)(pointer.StringPtr("2001::1")),
+			},
+			address:       "2001::1:0:0:0",
+			subnet:        "2001::1:0:0:0/80",
+			expectedIndex: 0xffffffffffff,
+			expectOverlap: true,
+		}),
+		Entry("IPv6 subnet, subnet inside", testCasePoolRangeSubnet{
+			pool: Pool{
+				Subnet: (*IPSubnetStr)(pointer.StringPtr("2001::/64")),
+			},
+			address:       "2001::100",
+			subnet:        "2001::100/120",
+			expectedIndex: 255,
+			expectOverlap: true,
+		}),
+	)
+
+	DescribeTable("Test PoolRange ExclusionEnd",
+		func(address string, expectedEnd string) {
+			poolRange, err := NewPoolRange(Pool{
+				Subnet: (*IPSubnetStr)(pointer.StringPtr("2001:db8::/64")),
+				Exclude: []ExcludedRange{
+					{
+						Start: "2001:db8::10",
+						End:   (*IPAddressStr)(pointer.StringPtr("2001:db8::ffff:ffff")),
+					},
+					{
+						Start: "2001:db8::ff00:0",
+						End:   (*IPAddressStr)(pointer.StringPtr("2001:db8::1:0:0")),
+					},
+					{
+						Start: "2001:db8::1:0:1",
+						End:   (*IPAddressStr)(pointer.StringPtr("2001:db8::1:0:ff")),
+					},
+					{Start: "2001:db8::2:0:0"},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			end, ok := poolRange.ExclusionEnd(net.ParseIP(address))
+			if expectedEnd == "" {
+				Expect(ok).To(BeFalse())
+				return
+			}
+			Expect(ok).To(BeTrue())
+			Expect(end.String()).To(Equal(expectedEnd))
+		},
+		Entry("Not excluded", "2001:db8::1", ""),
+		Entry("Overlapping and adjacent ranges", "2001:db8::20", "2001:db8::1:0:ff"),
+		Entry("Single address", "2001:db8::2:0:0", "2001:db8::2:0:0"),
+	)
+
+	DescribeTable("Test PoolRange Contains",
+		func(pool Pool, address string, expected bool) {
+			poolRange, err := NewPoolRange(pool)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(poolRange.Contains(net.ParseIP(address))).To(Equal(expected))
+		},
+		Entry("IPv4 range, inside", Pool{
+			Start: (*IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+			End:   (*IPAddressStr)(pointer.StringPtr("192.168.0.100")),
+		}, "192.168.0.100", true),
+		Entry("IPv4 range, before the start", Pool{
+			Start: (*IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+			End:   (*IPAddressStr)(pointer.StringPtr("192.168.0.100")),
+		}, "192.168.0.9", false),
+		Entry("IPv4 range, after the end", Pool{
+			Start: (*IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+			End:   (*IPAddressStr)(pointer.StringPtr("192.168.0.100")),
+		}, "192.168.0.101", false),
+		Entry("IPv4 subnet, inside", Pool{
+			Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24")),
+		}, "192.168.0.255", true),
+		Entry("IPv4 subnet, outside", Pool{
+			Start:  (*IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+			Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24")),
+		}, "192.168.1.10", false),
+		Entry("IPv4 range, IPv6 address", Pool{
+			Start: (*IPAddressStr)(pointer.StringPtr("0.0.0.1")),
+		}, "::ffff:1", false),
+		Entry("IPv6 range, inside", Pool{
+			Start: (*IPAddressStr)(pointer.StringPtr("2001::1")),
+		}, "2001::1:0:0:0", true),
+		Entry("IPv6 range, invalid address", Pool{
+			Start: (*IPAddressStr)(pointer.StringPtr("2001::1")),
+		}, "2001::1::1", false),
+	)
+
+	DescribeTable("Test PoolRange subnet",
+		func(pool Pool, address string, expectedPrefix int, expectedInSubnet, expectedReserved bool) {
+			poolRange, err := NewPoolRange(pool)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(poolRange.Prefix()).To(Equal(expectedPrefix))
+			Expect(poolRange.InSubnet(net.ParseIP(address))).To(Equal(expectedInSubnet))
+			Expect(poolRange.IsNetworkOrBroadcast(net.ParseIP(address))).To(Equal(expectedReserved))
+		},
+		Entry("IPv4 range without subnet", Pool{
+			Start: (*IPAddressStr)(pointer.StringPtr("192.168.0.0")),
+		}, "192.168.0.0", 0, true, false),
+		Entry("IPv4 subnet, host address", Pool{
+			Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24")),
+		}, "192.168.0.10", 24, true, false),
+		Entry("IPv4 subnet, network address", Pool{
+			Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24")),
+		}, "192.168.0.0", 24, true, true),
+		Entry("IPv4 subnet, allocated network address", Pool{
+			Subnet:                      (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24")),
+			AllocateNetworkAndBroadcast: true,
+		}, "192.168.0.0", 24, true, false),
+		Entry("IPv4 subnet, broadcast address", Pool{
+			Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.10/24")),
+		}, "192.168.0.255", 24, true, true),
+		Entry("IPv4 subnet, outside", Pool{
+			Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24")),
+		}, "192.168.1.0", 24, false, false),
+		Entry("IPv4 point-to-point subnet", Pool{
+			Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/31")),
+		}, "192.168.0.0", 31, true, false),
+		Entry("IPv6 subnet, network address", Pool{
+			Subnet: (*IPSubnetStr)(pointer.StringPtr("2001::/64")),
+		}, "2001::", 64, true, true),
+		Entry("IPv6 subnet, last address", Pool{
+			Subnet: (*IPSubnetStr)(pointer.StringPtr("2001::/64")),
+		}, "2001::ffff:ffff:ffff:ffff", 64, true, false),
+	)
+
+	DescribeTable("Test PoolRange Size",
+		func(pool Pool, expected uint64) {
+			poolRange, err := NewPoolRange(pool)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(poolRange.Size()).To(Equal(expected))
+		},
+		Entry("IPv4 range", Pool{
+			Start: (*IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+			End:   (*IPAddressStr)(pointer.StringPtr("192.168.0.19")),
+		}, uint64(10)),
+		Entry("IPv4 single address", Pool{
+			Start: (*IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+			End:   (*IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+		}, uint64(1)),
+		Entry("IPv4 subnet", Pool{
+			Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24")),
+		}, uint64(254)),
+		Entry("IPv4 range over the subnet", Pool{
+			Start:  (*IPAddressStr)(pointer.StringPtr("192.168.0.0")),
+			End:    (*IPAddressStr)(pointer.StringPtr("192.168.1.10")),
+			Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24")),
+		}, uint64(254)),
+		Entry("IPv4 range out of the subnet", Pool{
+			Start:  (*IPAddressStr)(pointer.StringPtr("192.168.1.0")),
+			Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24")),
+		}, uint64(0)),
+		Entry("IPv4 point-to-point subnet", Pool{
+			Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/31")),
+			Start:  (*IPAddressStr)(pointer.StringPtr("192.168.0.0")),
+		}, uint64(2)),
+		Entry("IPv4 without end", Pool{
+			Start: (*IPAddressStr)(pointer.StringPtr("255.255.255.0")),
+		}, uint64(256)),
+		Entry("IPv6 subnet", Pool{
+			Subnet: (*IPSubnetStr)(pointer.StringPtr("2001::/120")),
+		}, uint64(255)),
+		Entry("IPv6 large subnet", Pool{
+			Subnet: (*IPSubnetStr)(pointer.StringPtr("2001::/56")),
+		}, uint64(math.MaxUint64)),
+		Entry("IPv4 subnet with excluded ranges", Pool{
+			Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24")),
+			Exclude: []ExcludedRange{
+				{Start: "192.168.0.1"},
+				{Start: "192.168.0.250", End: (*IPAddressStr)(pointer.StringPtr("192.168.0.255"))},
+			},
+		}, uint64(248)),
+		Entry("IPv4 subnet with the network and broadcast addresses", Pool{
+			Subnet:                      (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24")),
+			AllocateNetworkAndBroadcast: true,
+		}, uint64(256)),
+		Entry("IPv4 range with an excluded range over its end", Pool{
+			Start: (*IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+			End:   (*IPAddressStr)(pointer.StringPtr("192.168.0.19")),
+			Exclude: []ExcludedRange{
+				{Start: "192.168.0.15", End: (*IPAddressStr)(pointer.StringPtr("192.168.0.30"))},
+			},
+		}, uint64(5)),
+	)
+
+	DescribeTable("Test PoolRange ExactSize",
+		func(pool Pool, expected string) {
+			poolRange, err := NewPoolRange(pool)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(poolRange.ExactSize().String()).To(Equal(expected))
+		},
+		Entry("IPv4 subnet", Pool{
+			Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24")),
+		}, "254"),
+		Entry("IPv6 /64 subnet", Pool{
+			Subnet: (*IPSubnetStr)(pointer.StringPtr("2001::/64")),
+		}, "18446744073709551615"),
+		Entry("IPv6 large subnet", Pool{
+			Subnet: (*IPSubnetStr)(pointer.StringPtr("2001::/56")),
+		}, "4722366482869645213695"),
+		Entry("IPv6 whole address space", Pool{
+			Start:                       (*IPAddressStr)(pointer.StringPtr("::")),
+			Subnet:                      (*IPSubnetStr)(pointer.StringPtr("::/0")),
+			AllocateNetworkAndBroadcast: true,
+		}, "340282366920938463463374607431768211456"),
+		Entry("IPv6 large subnet with a large excluded range", Pool{
+			Subnet: (*IPSubnetStr)(pointer.StringPtr("2001::/56")),
+			Exclude: []ExcludedRange{
+				{Start: "2001::1", End: (*IPAddressStr)(pointer.StringPtr("2001:0:0:fe::"))},
+			},
+		}, "36893488147419103231"),
+	)
+
+	It("Sizes the huge IPv6 pools", func() {
+		spec := IPPoolSpec{
+			Pools: []Pool{
+				{Subnet: (*IPSubnetStr)(pointer.StringPtr("2001::/56"))},
+				{Subnet: (*IPSubnetStr)(pointer.StringPtr("2001:1::/56"))},
+			},
+		}
+		total, err := spec.ExactCapacity()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(total.String()).To(Equal("9444732965739290427390"))
+		capacity, err := spec.Capacity()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(capacity).To(Equal(uint64(math.MaxUint64)))
+
+		spec.DelegatedPrefix = 120
+		total, err = spec.ExactCapacity()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(total.String()).To(Equal("36893488147419103232"))
+	})
+
+	DescribeTable("Test PoolRange Offset and AddressAtOffset",
+		func(pool Pool, address string, expectedOffset string, expectError bool) {
+			poolRange, err := NewPoolRange(pool)
+			Expect(err).NotTo(HaveOccurred())
+			offset, err := poolRange.Offset(net.ParseIP(address))
+			if expectError {
+				Expect(err).To(HaveOccurred())
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(offset.String()).To(Equal(expectedOffset))
+			ip, err := poolRange.AddressAtOffset(offset)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(net.ParseIP(string(ip)).Equal(net.ParseIP(address))).To(BeTrue())
+		},
+		Entry("IPv4 range", Pool{
+			Start: (*IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+			End:   (*IPAddressStr)(pointer.StringPtr("192.168.1.19")),
+		}, "192.168.1.2", "248", false),
+		Entry("IPv4 address before the range", Pool{
+			Start: (*IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+		}, "192.168.0.9", "", true),
+		Entry("IPv4 address in an IPv6 range", Pool{
+			Subnet: (*IPSubnetStr)(pointer.StringPtr("2001::/64")),
+		}, "192.168.0.9", "", true),
+		Entry("IPv6 address far in the subnet", Pool{
+			Subnet: (*IPSubnetStr)(pointer.StringPtr("2001::/56")),
+		}, "2001:0:0:ff:ffff:ffff:ffff:fffe", "4722366482869645213693", false),
+	)
+
+	It("Renders the address at an offset", func() {
+		poolRange, err := NewPoolRange(Pool{
+			Subnet: (*IPSubnetStr)(pointer.StringPtr("2001::/56")),
+		})
+		Expect(err).NotTo(HaveOccurred())
+		offset, ok := new(big.Int).SetString("4722366482869645213693", 10)
+		Expect(ok).To(BeTrue())
+		ip, err := poolRange.AddressAtOffset(offset)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ip).To(Equal(IPAddressStr("2001::ff:ffff:ffff:ffff:fffe")))
+		_, err = poolRange.AddressAtOffset(offset.Add(offset, big.NewInt(2)))
+		Expect(err).To(HaveOccurred())
+		_, err = poolRange.AddressAtOffset(big.NewInt(-1))
+		Expect(err).To(HaveOccurred())
+
+		poolRange, err = NewPoolRange(Pool{
+			Start: (*IPAddressStr)(pointer.StringPtr("255.255.255.250")),
+		})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = poolRange.AddressAtOffset(big.NewInt(6))
+		Expect(err).To(HaveOccurred())
+	})
+
+	DescribeTable("Test PoolRange IsExcluded",
+		func(ip string, expected bool) {
+			poolRange, err := NewPoolRange(Pool{
+				Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24")),
+				Exclude: []ExcludedRange{
+					{Start: "192.168.0.1"},
+					{Start: "192.168.0.10", End: (*IPAddressStr)(pointer.StringPtr("192.168.0.19"))},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(poolRange.IsExcluded(net.ParseIP(ip))).To(Equal(expected))
+		},
+		Entry("Single excluded address", "192.168.0.1", true),
+		Entry("Start of an excluded range", "192.168.0.10", true),
+		Entry("End of an excluded range", "192.168.0.19", true),
+		Entry("Address after an excluded range", "192.168.0.20", false),
+		Entry("Address between the excluded ranges", "192.168.0.2", false),
+	)
+
+	type testCasePrefixAt struct {
+		pool             Pool
+		ip               string
+		length           int
+		expectedPrefixes []string
+		expectedCount    uint64
+	}
+
+	DescribeTable("Test PoolRange PrefixAt and PrefixAfter",
+		func(tc testCasePrefixAt) {
+			poolRange, err := NewPoolRange(tc.pool)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(poolRange.PrefixCount(tc.length)).To(Equal(tc.expectedCount))
+
+			prefixes := []string{}
+			prefix, err := poolRange.PrefixAt(net.ParseIP(tc.ip), tc.length)
+			for err == nil && len(prefixes) < 4 {
+				prefixes = append(prefixes, prefix.String())
+				prefix, err = poolRange.PrefixAfter(prefix)
+			}
+			Expect(prefixes).To(Equal(tc.expectedPrefixes))
+		},
+		Entry("IPv4 range", testCasePrefixAt{
+			pool: Pool{
+				Start: (*IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+				End:   (*IPAddressStr)(pointer.StringPtr("192.168.0.40")),
+			},
+			length:           29,
+			expectedPrefixes: []string{"192.168.0.16/29", "192.168.0.24/29", "192.168.0.32/29"},
+			expectedCount:    3,
+		}),
+		Entry("IPv4 range, from an address", testCasePrefixAt{
+			pool: Pool{
+				Start: (*IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+				End:   (*IPAddressStr)(pointer.StringPtr("192.168.0.40")),
+			},
+			ip:               "192.168.0.24",
+			length:           29,
+			expectedPrefixes: []string{"192.168.0.24/29", "192.168.0.32/29"},
+			expectedCount:    3,
+		}),
+		Entry("IPv4 subnet, from the network address", testCasePrefixAt{
+			pool: Pool{
+				Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24")),
+			},
+			length:           26,
+			expectedPrefixes: []string{"192.168.0.0/26", "192.168.0.64/26", "192.168.0.128/26", "192.168.0.192/26"},
+			expectedCount:    4,
+		}),
+		Entry("IPv4 end of the address space", testCasePrefixAt{
+			pool: Pool{
+				Start: (*IPAddressStr)(pointer.StringPtr("255.255.255.240")),
+			},
+			length:           29,
+			expectedPrefixes: []string{"255.255.255.240/29", "255.255.255.248/29"},
+			expectedCount:    2,
+		}),
+		Entry("IPv4 range smaller than the prefixes", testCasePrefixAt{
+			pool: Pool{
+				Start: (*IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+				End:   (*IPAddressStr)(pointer.StringPtr("192.168.0.20")),
+			},
+			length:           29,
+			expectedPrefixes: []string{},
+		}),
+		Entry("IPv4 invalid length", testCasePrefixAt{
+			pool: Pool{
+				Subnet: (*IPSubnetStr)(pointer.StringPtr("192.168.0.0/24")),
+			},
+			length:           33,
+			expectedPrefixes: []string{},
+		}),
+		Entry("IPv6 subnet", testCasePrefixAt{
+			pool: Pool{
+				Subnet: (*IPSubnetStr)(pointer.StringPtr("2001:db8::/56")),
+			},
+			length:           64,
+			expectedPrefixes: []string{"2001:db8::/64", "2001:db8:0:1::/64", "2001:db8:0:2::/64", "2001:db8:0:3::/64"},
+			expectedCount:    256,
+		}),
+		Entry("IPv6 large subnet", testCasePrefixAt{
+			pool: Pool{
+				Subnet: (*IPSubnetStr)(pointer.StringPtr("2001:db8::/32")),
+			},
+			ip:               "2001:db8:ffff:ffff::",
+			length:           64,
+			expectedPrefixes: []string{"2001:db8:ffff:ffff::/64"},
+			expectedCount:    1 << 32,
+		}),
+	)
+
+	DescribeTable("Test CompactAddresses",
+		func(addresses []IPAddressStr, expected []string) {
+			Expect(CompactAddresses(addresses)).To(Equal(expected))
+		},
+		Entry("No addresses", []IPAddressStr{}, []string{}),
+		Entry("Single address", []IPAddressStr{"192.168.0.10"},
+			[]string{"192.168.0.10"},
+		),
+		Entry("Ranges", []IPAddressStr{
+			"192.168.0.12", "192.168.0.10", "192.168.0.11", "192.168.0.20",
+			"192.168.0.255", "192.168.1.0", "192.168.0.11", "invalid",
+		}, []string{
+			"192.168.0.10-192.168.0.12", "192.168.0.20",
+			"192.168.0.255-192.168.1.0",
+		}),
+		Entry("Mixed families", []IPAddressStr{
+			"2001::ffff", "255.255.255.255", "2001::1:0", "::1:0:0:0", "2001::1",
+		}, []string{
+			"255.255.255.255", "::1:0:0:0", "2001::1",
+			"2001::ffff-2001::1:0",
+		}),
+	)
+
+	DescribeTable("Test AllocatedAddresses",
+		func(status IPPoolStatus, expected []IPAddressStr) {
+			Expect(status.AllocatedAddresses()).To(Equal(expected))
+		},
+		Entry("No allocations", IPPoolStatus{}, []IPAddressStr{}),
+		Entry("Allocations", IPPoolStatus{
+			Allocations: map[string]IPAddressStr{
+				"abc": "192.168.0.10",
+			},
+		}, []IPAddressStr{"192.168.0.10"}),
+		Entry("Allocated ranges", IPPoolStatus{
+			AllocatedRanges: []string{
+				"192.168.0.10-192.168.0.12", "192.168.0.20", "2001::ffff-2001::1:0",
+				"invalid", "192.168.0.30-2001::1", "192.168.0.40-192.168.0.39",
+			},
+		}, []IPAddressStr{
+			"192.168.0.10", "192.168.0.11", "192.168.0.12", "192.168.0.20",
+			"2001::ffff", "2001::1:0",
+		}),
+		Entry("Allocated ranges up to the last address", IPPoolStatus{
+			AllocatedRanges: []string{"255.255.255.254-255.255.255.255"},
+		}, []IPAddressStr{"255.255.255.254", "255.255.255.255"}),
 	)
 
 })
@@ -1,3 +1,4 @@
+//go:build !ignore_autogenerated
 // +build !ignore_autogenerated
 
 /*
@@ -21,10 +22,340 @@ limitations under the License.
 package v1alpha1
 
 import (
-	"k8s.io/api/core/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AffectedAllocation) DeepCopyInto(out *AffectedAllocation) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AffectedAllocation.
+func (in *AffectedAllocation) DeepCopy() *AffectedAllocation {
+	if in == nil {
+		return nil
+	}
+	out := new(AffectedAllocation)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Backend) DeepCopyInto(out *Backend) {
+	*out = *in
+	if in.NetBox != nil {
+		in, out := &in.NetBox, &out.NetBox
+		*out = new(NetBoxBackend)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Infoblox != nil {
+		in, out := &in.Infoblox, &out.Infoblox
+		*out = new(InfobloxBackend)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Backend.
+func (in *Backend) DeepCopy() *Backend {
+	if in == nil {
+		return nil
+	}
+	out := new(Backend)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterIPPool) DeepCopyInto(out *ClusterIPPool) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterIPPool.
+func (in *ClusterIPPool) DeepCopy() *ClusterIPPool {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterIPPool)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ClusterIPPool) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterIPPoolList) DeepCopyInto(out *ClusterIPPoolList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ClusterIPPool, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterIPPoolList.
+func (in *ClusterIPPoolList) DeepCopy() *ClusterIPPoolList {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterIPPoolList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ClusterIPPoolList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ControllerConfig) DeepCopyInto(out *ControllerConfig) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ControllerConfig.
+func (in *ControllerConfig) DeepCopy() *ControllerConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(ControllerConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ControllerConfig) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ControllerConfigList) DeepCopyInto(out *ControllerConfigList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ControllerConfig, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ControllerConfigList.
+func (in *ControllerConfigList) DeepCopy() *ControllerConfigList {
+	if in == nil {
+		return nil
+	}
+	out := new(ControllerConfigList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ControllerConfigList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ControllerConfigSpec) DeepCopyInto(out *ControllerConfigSpec) {
+	*out = *in
+	if in.RequeueInterval != nil {
+		in, out := &in.RequeueInterval, &out.RequeueInterval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.StaleClaimThreshold != nil {
+		in, out := &in.StaleClaimThreshold, &out.StaleClaimThreshold
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.LogVerbosity != nil {
+		in, out := &in.LogVerbosity, &out.LogVerbosity
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ControllerConfigSpec.
+func (in *ControllerConfigSpec) DeepCopy() *ControllerConfigSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ControllerConfigSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ControllerConfigStatus) DeepCopyInto(out *ControllerConfigStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ControllerConfigStatus.
+func (in *ControllerConfigStatus) DeepCopy() *ControllerConfigStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ControllerConfigStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ExcludedRange) DeepCopyInto(out *ExcludedRange) {
+	*out = *in
+	if in.End != nil {
+		in, out := &in.End, &out.End
+		*out = new(IPAddressStr)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ExcludedRange.
+func (in *ExcludedRange) DeepCopy() *ExcludedRange {
+	if in == nil {
+		return nil
+	}
+	out := new(ExcludedRange)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IPAMConfig) DeepCopyInto(out *IPAMConfig) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPAMConfig.
+func (in *IPAMConfig) DeepCopy() *IPAMConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(IPAMConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *IPAMConfig) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IPAMConfigList) DeepCopyInto(out *IPAMConfigList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]IPAMConfig, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPAMConfigList.
+func (in *IPAMConfigList) DeepCopy() *IPAMConfigList {
+	if in == nil {
+		return nil
+	}
+	out := new(IPAMConfigList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *IPAMConfigList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IPAMConfigSpec) DeepCopyInto(out *IPAMConfigSpec) {
+	*out = *in
+	if in.DNSServers != nil {
+		in, out := &in.DNSServers, &out.DNSServers
+		*out = make([]IPAddressStr, len(*in))
+		copy(*out, *in)
+	}
+	if in.SearchDomains != nil {
+		in, out := &in.SearchDomains, &out.SearchDomains
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.NTPServers != nil {
+		in, out := &in.NTPServers, &out.NTPServers
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AllowedNamespaces != nil {
+		in, out := &in.AllowedNamespaces, &out.AllowedNamespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.PropagatedAnnotations != nil {
+		in, out := &in.PropagatedAnnotations, &out.PropagatedAnnotations
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPAMConfigSpec.
+func (in *IPAMConfigSpec) DeepCopy() *IPAMConfigSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(IPAMConfigSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *IPAddress) DeepCopyInto(out *IPAddress) {
 	*out = *in
@@ -84,53 +415,563 @@ func (in *IPAddressList) DeepCopyObject() runtime.Object {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *IPAddressSpec) DeepCopyInto(out *IPAddressSpec) {
+func (in *IPAddressSpec) DeepCopyInto(out *IPAddressSpec) {
+	*out = *in
+	out.Claim = in.Claim
+	out.Pool = in.Pool
+	if in.Gateway != nil {
+		in, out := &in.Gateway, &out.Gateway
+		*out = new(IPAddressStr)
+		**out = **in
+	}
+	if in.DelegatedPrefix != nil {
+		in, out := &in.DelegatedPrefix, &out.DelegatedPrefix
+		*out = new(IPSubnetStr)
+		**out = **in
+	}
+	if in.DNSServers != nil {
+		in, out := &in.DNSServers, &out.DNSServers
+		*out = make([]IPAddressStr, len(*in))
+		copy(*out, *in)
+	}
+	if in.SearchDomains != nil {
+		in, out := &in.SearchDomains, &out.SearchDomains
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.NTPServers != nil {
+		in, out := &in.NTPServers, &out.NTPServers
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPAddressSpec.
+func (in *IPAddressSpec) DeepCopy() *IPAddressSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(IPAddressSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IPClaim) DeepCopyInto(out *IPClaim) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPClaim.
+func (in *IPClaim) DeepCopy() *IPClaim {
+	if in == nil {
+		return nil
+	}
+	out := new(IPClaim)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *IPClaim) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IPClaimList) DeepCopyInto(out *IPClaimList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]IPClaim, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPClaimList.
+func (in *IPClaimList) DeepCopy() *IPClaimList {
+	if in == nil {
+		return nil
+	}
+	out := new(IPClaimList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *IPClaimList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IPClaimSet) DeepCopyInto(out *IPClaimSet) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPClaimSet.
+func (in *IPClaimSet) DeepCopy() *IPClaimSet {
+	if in == nil {
+		return nil
+	}
+	out := new(IPClaimSet)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *IPClaimSet) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IPClaimSetList) DeepCopyInto(out *IPClaimSetList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]IPClaimSet, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPClaimSetList.
+func (in *IPClaimSetList) DeepCopy() *IPClaimSetList {
+	if in == nil {
+		return nil
+	}
+	out := new(IPClaimSetList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *IPClaimSetList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IPClaimSetSpec) DeepCopyInto(out *IPClaimSetSpec) {
+	*out = *in
+	in.Template.DeepCopyInto(&out.Template)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPClaimSetSpec.
+func (in *IPClaimSetSpec) DeepCopy() *IPClaimSetSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(IPClaimSetSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IPClaimSetStatus) DeepCopyInto(out *IPClaimSetStatus) {
+	*out = *in
+	if in.LastUpdated != nil {
+		in, out := &in.LastUpdated, &out.LastUpdated
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPClaimSetStatus.
+func (in *IPClaimSetStatus) DeepCopy() *IPClaimSetStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(IPClaimSetStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IPClaimSpec) DeepCopyInto(out *IPClaimSpec) {
+	*out = *in
+	out.Pool = in.Pool
+	if in.PoolSelector != nil {
+		in, out := &in.PoolSelector, &out.PoolSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Subnet != nil {
+		in, out := &in.Subnet, &out.Subnet
+		*out = new(IPSubnetStr)
+		**out = **in
+	}
+	if in.PreferredAddress != nil {
+		in, out := &in.PreferredAddress, &out.PreferredAddress
+		*out = new(PreferredAddress)
+		**out = **in
+	}
+	if in.Prefix != nil {
+		in, out := &in.Prefix, &out.Prefix
+		*out = new(int)
+		**out = **in
+	}
+	if in.Roles != nil {
+		in, out := &in.Roles, &out.Roles
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AddressCount != nil {
+		in, out := &in.AddressCount, &out.AddressCount
+		*out = new(int)
+		**out = **in
+	}
+	if in.BindingDeadline != nil {
+		in, out := &in.BindingDeadline, &out.BindingDeadline
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.LeaseDuration != nil {
+		in, out := &in.LeaseDuration, &out.LeaseDuration
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPClaimSpec.
+func (in *IPClaimSpec) DeepCopy() *IPClaimSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(IPClaimSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IPClaimStatus) DeepCopyInto(out *IPClaimStatus) {
+	*out = *in
+	if in.Address != nil {
+		in, out := &in.Address, &out.Address
+		*out = new(corev1.ObjectReference)
+		**out = **in
+	}
+	if in.Addresses != nil {
+		in, out := &in.Addresses, &out.Addresses
+		*out = make(map[string]corev1.ObjectReference, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Pool != nil {
+		in, out := &in.Pool, &out.Pool
+		*out = new(corev1.ObjectReference)
+		**out = **in
+	}
+	if in.ErrorMessage != nil {
+		in, out := &in.ErrorMessage, &out.ErrorMessage
+		*out = new(string)
+		**out = **in
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPClaimStatus.
+func (in *IPClaimStatus) DeepCopy() *IPClaimStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(IPClaimStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IPClaimTemplate) DeepCopyInto(out *IPClaimTemplate) {
+	*out = *in
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Annotations != nil {
+		in, out := &in.Annotations, &out.Annotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPClaimTemplate.
+func (in *IPClaimTemplate) DeepCopy() *IPClaimTemplate {
+	if in == nil {
+		return nil
+	}
+	out := new(IPClaimTemplate)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IPOverlapReport) DeepCopyInto(out *IPOverlapReport) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPOverlapReport.
+func (in *IPOverlapReport) DeepCopy() *IPOverlapReport {
+	if in == nil {
+		return nil
+	}
+	out := new(IPOverlapReport)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *IPOverlapReport) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IPOverlapReportList) DeepCopyInto(out *IPOverlapReportList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]IPOverlapReport, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPOverlapReportList.
+func (in *IPOverlapReportList) DeepCopy() *IPOverlapReportList {
+	if in == nil {
+		return nil
+	}
+	out := new(IPOverlapReportList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *IPOverlapReportList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IPOverlapReportSpec) DeepCopyInto(out *IPOverlapReportSpec) {
+	*out = *in
+	if in.Interval != nil {
+		in, out := &in.Interval, &out.Interval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPOverlapReportSpec.
+func (in *IPOverlapReportSpec) DeepCopy() *IPOverlapReportSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(IPOverlapReportSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IPOverlapReportStatus) DeepCopyInto(out *IPOverlapReportStatus) {
+	*out = *in
+	if in.LastUpdated != nil {
+		in, out := &in.LastUpdated, &out.LastUpdated
+		*out = (*in).DeepCopy()
+	}
+	if in.Overlaps != nil {
+		in, out := &in.Overlaps, &out.Overlaps
+		*out = make([]IPRangeOverlap, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPOverlapReportStatus.
+func (in *IPOverlapReportStatus) DeepCopy() *IPOverlapReportStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(IPOverlapReportStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IPPool) DeepCopyInto(out *IPPool) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPPool.
+func (in *IPPool) DeepCopy() *IPPool {
+	if in == nil {
+		return nil
+	}
+	out := new(IPPool)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *IPPool) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IPPoolAllocationShard) DeepCopyInto(out *IPPoolAllocationShard) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPPoolAllocationShard.
+func (in *IPPoolAllocationShard) DeepCopy() *IPPoolAllocationShard {
+	if in == nil {
+		return nil
+	}
+	out := new(IPPoolAllocationShard)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *IPPoolAllocationShard) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IPPoolAllocationShardList) DeepCopyInto(out *IPPoolAllocationShardList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]IPPoolAllocationShard, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPPoolAllocationShardList.
+func (in *IPPoolAllocationShardList) DeepCopy() *IPPoolAllocationShardList {
+	if in == nil {
+		return nil
+	}
+	out := new(IPPoolAllocationShardList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *IPPoolAllocationShardList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IPPoolAllocationShardSpec) DeepCopyInto(out *IPPoolAllocationShardSpec) {
 	*out = *in
-	out.Claim = in.Claim
-	out.Pool = in.Pool
-	if in.Gateway != nil {
-		in, out := &in.Gateway, &out.Gateway
-		*out = new(IPAddressStr)
-		**out = **in
-	}
-	if in.DNSServers != nil {
-		in, out := &in.DNSServers, &out.DNSServers
-		*out = make([]IPAddressStr, len(*in))
-		copy(*out, *in)
+	if in.Allocations != nil {
+		in, out := &in.Allocations, &out.Allocations
+		*out = make(map[string]IPAddressStr, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
 	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPAddressSpec.
-func (in *IPAddressSpec) DeepCopy() *IPAddressSpec {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPPoolAllocationShardSpec.
+func (in *IPPoolAllocationShardSpec) DeepCopy() *IPPoolAllocationShardSpec {
 	if in == nil {
 		return nil
 	}
-	out := new(IPAddressSpec)
+	out := new(IPPoolAllocationShardSpec)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *IPClaim) DeepCopyInto(out *IPClaim) {
+func (in *IPPoolClaim) DeepCopyInto(out *IPPoolClaim) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
 	in.Status.DeepCopyInto(&out.Status)
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPClaim.
-func (in *IPClaim) DeepCopy() *IPClaim {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPPoolClaim.
+func (in *IPPoolClaim) DeepCopy() *IPPoolClaim {
 	if in == nil {
 		return nil
 	}
-	out := new(IPClaim)
+	out := new(IPPoolClaim)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
-func (in *IPClaim) DeepCopyObject() runtime.Object {
+func (in *IPPoolClaim) DeepCopyObject() runtime.Object {
 	if c := in.DeepCopy(); c != nil {
 		return c
 	}
@@ -138,31 +979,31 @@ func (in *IPClaim) DeepCopyObject() runtime.Object {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *IPClaimList) DeepCopyInto(out *IPClaimList) {
+func (in *IPPoolClaimList) DeepCopyInto(out *IPPoolClaimList) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ListMeta.DeepCopyInto(&out.ListMeta)
 	if in.Items != nil {
 		in, out := &in.Items, &out.Items
-		*out = make([]IPClaim, len(*in))
+		*out = make([]IPPoolClaim, len(*in))
 		for i := range *in {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPClaimList.
-func (in *IPClaimList) DeepCopy() *IPClaimList {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPPoolClaimList.
+func (in *IPPoolClaimList) DeepCopy() *IPPoolClaimList {
 	if in == nil {
 		return nil
 	}
-	out := new(IPClaimList)
+	out := new(IPPoolClaimList)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
-func (in *IPClaimList) DeepCopyObject() runtime.Object {
+func (in *IPPoolClaimList) DeepCopyObject() runtime.Object {
 	if c := in.DeepCopy(); c != nil {
 		return c
 	}
@@ -170,73 +1011,57 @@ func (in *IPClaimList) DeepCopyObject() runtime.Object {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *IPClaimSpec) DeepCopyInto(out *IPClaimSpec) {
+func (in *IPPoolClaimSpec) DeepCopyInto(out *IPPoolClaimSpec) {
 	*out = *in
-	out.Pool = in.Pool
+	in.Template.DeepCopyInto(&out.Template)
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPClaimSpec.
-func (in *IPClaimSpec) DeepCopy() *IPClaimSpec {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPPoolClaimSpec.
+func (in *IPPoolClaimSpec) DeepCopy() *IPPoolClaimSpec {
 	if in == nil {
 		return nil
 	}
-	out := new(IPClaimSpec)
+	out := new(IPPoolClaimSpec)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *IPClaimStatus) DeepCopyInto(out *IPClaimStatus) {
+func (in *IPPoolClaimStatus) DeepCopyInto(out *IPPoolClaimStatus) {
 	*out = *in
-	if in.Address != nil {
-		in, out := &in.Address, &out.Address
-		*out = new(v1.ObjectReference)
+	if in.LastUpdated != nil {
+		in, out := &in.LastUpdated, &out.LastUpdated
+		*out = (*in).DeepCopy()
+	}
+	if in.Prefix != nil {
+		in, out := &in.Prefix, &out.Prefix
+		*out = new(IPSubnetStr)
 		**out = **in
 	}
-	if in.ErrorMessage != nil {
-		in, out := &in.ErrorMessage, &out.ErrorMessage
-		*out = new(string)
+	if in.Pool != nil {
+		in, out := &in.Pool, &out.Pool
+		*out = new(corev1.ObjectReference)
 		**out = **in
 	}
-}
-
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPClaimStatus.
-func (in *IPClaimStatus) DeepCopy() *IPClaimStatus {
-	if in == nil {
-		return nil
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
 	}
-	out := new(IPClaimStatus)
-	in.DeepCopyInto(out)
-	return out
-}
-
-// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *IPPool) DeepCopyInto(out *IPPool) {
-	*out = *in
-	out.TypeMeta = in.TypeMeta
-	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	in.Spec.DeepCopyInto(&out.Spec)
-	in.Status.DeepCopyInto(&out.Status)
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPPool.
-func (in *IPPool) DeepCopy() *IPPool {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPPoolClaimStatus.
+func (in *IPPoolClaimStatus) DeepCopy() *IPPoolClaimStatus {
 	if in == nil {
 		return nil
 	}
-	out := new(IPPool)
+	out := new(IPPoolClaimStatus)
 	in.DeepCopyInto(out)
 	return out
 }
 
-// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
-func (in *IPPool) DeepCopyObject() runtime.Object {
-	if c := in.DeepCopy(); c != nil {
-		return c
-	}
-	return nil
-}
-
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *IPPoolList) DeepCopyInto(out *IPPoolList) {
 	*out = *in
@@ -291,6 +1116,13 @@ func (in *IPPoolSpec) DeepCopyInto(out *IPPoolSpec) {
 			(*out)[key] = val
 		}
 	}
+	if in.MACReservations != nil {
+		in, out := &in.MACReservations, &out.MACReservations
+		*out = make(map[string]IPAddressStr, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
 	if in.Gateway != nil {
 		in, out := &in.Gateway, &out.Gateway
 		*out = new(IPAddressStr)
@@ -301,6 +1133,66 @@ func (in *IPPoolSpec) DeepCopyInto(out *IPPoolSpec) {
 		*out = make([]IPAddressStr, len(*in))
 		copy(*out, *in)
 	}
+	if in.SearchDomains != nil {
+		in, out := &in.SearchDomains, &out.SearchDomains
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.NTPServers != nil {
+		in, out := &in.NTPServers, &out.NTPServers
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AllowedNamespaces != nil {
+		in, out := &in.AllowedNamespaces, &out.AllowedNamespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.PropagatedAnnotations != nil {
+		in, out := &in.PropagatedAnnotations, &out.PropagatedAnnotations
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ClaimBindingDeadline != nil {
+		in, out := &in.ClaimBindingDeadline, &out.ClaimBindingDeadline
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.LeaseDuration != nil {
+		in, out := &in.LeaseDuration, &out.LeaseDuration
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.ReleaseHook != nil {
+		in, out := &in.ReleaseHook, &out.ReleaseHook
+		*out = new(ReleaseHook)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Backend != nil {
+		in, out := &in.Backend, &out.Backend
+		*out = new(Backend)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.StickyAllocationRetention != nil {
+		in, out := &in.StickyAllocationRetention, &out.StickyAllocationRetention
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.QuarantinePeriod != nil {
+		in, out := &in.QuarantinePeriod, &out.QuarantinePeriod
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.Drain != nil {
+		in, out := &in.Drain, &out.Drain
+		*out = new(PoolDrain)
+		**out = **in
+	}
+	if in.FallbackPools != nil {
+		in, out := &in.FallbackPools, &out.FallbackPools
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPPoolSpec.
@@ -327,6 +1219,46 @@ func (in *IPPoolStatus) DeepCopyInto(out *IPPoolStatus) {
 			(*out)[key] = val
 		}
 	}
+	if in.AllocatedRanges != nil {
+		in, out := &in.AllocatedRanges, &out.AllocatedRanges
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AffinityGroups != nil {
+		in, out := &in.AffinityGroups, &out.AffinityGroups
+		*out = make(map[string]int, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.RetainedAddresses != nil {
+		in, out := &in.RetainedAddresses, &out.RetainedAddresses
+		*out = make(map[string]RetainedAddress, len(*in))
+		for key, val := range *in {
+			(*out)[key] = *val.DeepCopy()
+		}
+	}
+	if in.QuarantinedAddresses != nil {
+		in, out := &in.QuarantinedAddresses, &out.QuarantinedAddresses
+		*out = make(map[string]v1.Time, len(*in))
+		for key, val := range *in {
+			(*out)[key] = *val.DeepCopy()
+		}
+	}
+	if in.ReleasedAddresses != nil {
+		in, out := &in.ReleasedAddresses, &out.ReleasedAddresses
+		*out = make(map[string]v1.Time, len(*in))
+		for key, val := range *in {
+			(*out)[key] = *val.DeepCopy()
+		}
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPPoolStatus.
@@ -339,6 +1271,122 @@ func (in *IPPoolStatus) DeepCopy() *IPPoolStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IPPoolTemplate) DeepCopyInto(out *IPPoolTemplate) {
+	*out = *in
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Annotations != nil {
+		in, out := &in.Annotations, &out.Annotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPPoolTemplate.
+func (in *IPPoolTemplate) DeepCopy() *IPPoolTemplate {
+	if in == nil {
+		return nil
+	}
+	out := new(IPPoolTemplate)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IPRangeOverlap) DeepCopyInto(out *IPRangeOverlap) {
+	*out = *in
+	if in.Ranges != nil {
+		in, out := &in.Ranges, &out.Ranges
+		*out = make([]OverlappingRange, len(*in))
+		copy(*out, *in)
+	}
+	if in.AffectedAllocations != nil {
+		in, out := &in.AffectedAllocations, &out.AffectedAllocations
+		*out = make([]AffectedAllocation, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPRangeOverlap.
+func (in *IPRangeOverlap) DeepCopy() *IPRangeOverlap {
+	if in == nil {
+		return nil
+	}
+	out := new(IPRangeOverlap)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *InfobloxBackend) DeepCopyInto(out *InfobloxBackend) {
+	*out = *in
+	out.CredentialsSecret = in.CredentialsSecret
+	if in.Timeout != nil {
+		in, out := &in.Timeout, &out.Timeout
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InfobloxBackend.
+func (in *InfobloxBackend) DeepCopy() *InfobloxBackend {
+	if in == nil {
+		return nil
+	}
+	out := new(InfobloxBackend)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NetBoxBackend) DeepCopyInto(out *NetBoxBackend) {
+	*out = *in
+	if in.Tags != nil {
+		in, out := &in.Tags, &out.Tags
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Timeout != nil {
+		in, out := &in.Timeout, &out.Timeout
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NetBoxBackend.
+func (in *NetBoxBackend) DeepCopy() *NetBoxBackend {
+	if in == nil {
+		return nil
+	}
+	out := new(NetBoxBackend)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OverlappingRange) DeepCopyInto(out *OverlappingRange) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OverlappingRange.
+func (in *OverlappingRange) DeepCopy() *OverlappingRange {
+	if in == nil {
+		return nil
+	}
+	out := new(OverlappingRange)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Pool) DeepCopyInto(out *Pool) {
 	*out = *in
@@ -367,6 +1415,23 @@ func (in *Pool) DeepCopyInto(out *Pool) {
 		*out = make([]IPAddressStr, len(*in))
 		copy(*out, *in)
 	}
+	if in.SearchDomains != nil {
+		in, out := &in.SearchDomains, &out.SearchDomains
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.NTPServers != nil {
+		in, out := &in.NTPServers, &out.NTPServers
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Exclude != nil {
+		in, out := &in.Exclude, &out.Exclude
+		*out = make([]ExcludedRange, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Pool.
@@ -378,3 +1443,74 @@ func (in *Pool) DeepCopy() *Pool {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PoolDrain) DeepCopyInto(out *PoolDrain) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PoolDrain.
+func (in *PoolDrain) DeepCopy() *PoolDrain {
+	if in == nil {
+		return nil
+	}
+	out := new(PoolDrain)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PreferredAddress) DeepCopyInto(out *PreferredAddress) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PreferredAddress.
+func (in *PreferredAddress) DeepCopy() *PreferredAddress {
+	if in == nil {
+		return nil
+	}
+	out := new(PreferredAddress)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReleaseHook) DeepCopyInto(out *ReleaseHook) {
+	*out = *in
+	if in.Timeout != nil {
+		in, out := &in.Timeout, &out.Timeout
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.Job != nil {
+		in, out := &in.Job, &out.Job
+		*out = new(batchv1.JobTemplateSpec)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReleaseHook.
+func (in *ReleaseHook) DeepCopy() *ReleaseHook {
+	if in == nil {
+		return nil
+	}
+	out := new(ReleaseHook)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RetainedAddress) DeepCopyInto(out *RetainedAddress) {
+	*out = *in
+	in.ReleasedAt.DeepCopyInto(&out.ReleasedAt)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RetainedAddress.
+func (in *RetainedAddress) DeepCopy() *RetainedAddress {
+	if in == nil {
+		return nil
+	}
+	out := new(RetainedAddress)
+	in.DeepCopyInto(out)
+	return out
+}
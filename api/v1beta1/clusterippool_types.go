@@ -0,0 +1,56 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:resource:path=clusterippools,scope=Cluster,categories=cluster-api,shortName=cipp;clusterippool
+// +kubebuilder:subresource:status
+// +kubebuilder:object:root=true
+// +kubebuilder:printcolumn:name="Total",type="integer",JSONPath=".status.capacity.total",description="Number of addresses of the pools"
+// +kubebuilder:printcolumn:name="Allocated",type="integer",JSONPath=".status.capacity.allocated",description="Number of allocated addresses"
+// +kubebuilder:printcolumn:name="Available",type="integer",JSONPath=".status.capacity.available",description="Number of addresses that can still be allocated"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp",description="Time duration since creation of ClusterIPPool"
+// ClusterIPPool is the Schema for the clusterippools API. It is a
+// cluster-scoped IPPool that the IPClaims of any namespace can reference,
+// its IPAddresses are created in the namespace of their IPClaim.
+type ClusterIPPool struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	// +kubebuilder:validation:XValidation:rule="self.namePrefix == oldSelf.namePrefix",message="namePrefix cannot be modified"
+	// +kubebuilder:validation:XValidation:rule="(has(self.dualStack) && self.dualStack) == (has(oldSelf.dualStack) && oldSelf.dualStack)",message="dualStack cannot be modified"
+	// +kubebuilder:validation:XValidation:rule="(has(self.delegatedPrefix) ? self.delegatedPrefix : 0) == (has(oldSelf.delegatedPrefix) ? oldSelf.delegatedPrefix : 0)",message="delegatedPrefix cannot be modified"
+	Spec   IPPoolSpec   `json:"spec,omitempty"`
+	Status IPPoolStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ClusterIPPoolList contains a list of ClusterIPPool
+type ClusterIPPoolList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterIPPool `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ClusterIPPool{}, &ClusterIPPoolList{})
+}
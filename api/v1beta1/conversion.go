@@ -0,0 +1,525 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"strings"
+
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+)
+
+// allocatedRangeSeparator separates the first and last addresses of the
+// allocated ranges of the v1alpha1 IPPools
+const allocatedRangeSeparator = "-"
+
+// ConvertTo converts the IPPool to the v1alpha1 hub version
+func (src *IPPool) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*ipamv1.IPPool)
+	dst.ObjectMeta = src.ObjectMeta
+	convertIPPoolSpecTo(&src.Spec, &dst.Spec)
+	convertIPPoolStatusTo(&src.Status, &dst.Status)
+	return nil
+}
+
+// ConvertFrom converts the IPPool from the v1alpha1 hub version
+func (dst *IPPool) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*ipamv1.IPPool)
+	dst.ObjectMeta = src.ObjectMeta
+	convertIPPoolSpecFrom(&src.Spec, &dst.Spec)
+	convertIPPoolStatusFrom(&src.Status, &dst.Status)
+	return nil
+}
+
+// ConvertTo converts the ClusterIPPool to the v1alpha1 hub version
+func (src *ClusterIPPool) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*ipamv1.ClusterIPPool)
+	dst.ObjectMeta = src.ObjectMeta
+	convertIPPoolSpecTo(&src.Spec, &dst.Spec)
+	convertIPPoolStatusTo(&src.Status, &dst.Status)
+	return nil
+}
+
+// ConvertFrom converts the ClusterIPPool from the v1alpha1 hub version
+func (dst *ClusterIPPool) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*ipamv1.ClusterIPPool)
+	dst.ObjectMeta = src.ObjectMeta
+	convertIPPoolSpecFrom(&src.Spec, &dst.Spec)
+	convertIPPoolStatusFrom(&src.Status, &dst.Status)
+	return nil
+}
+
+// ConvertTo converts the IPClaim to the v1alpha1 hub version. The
+// AllocationFailed condition is converted to the error message.
+func (src *IPClaim) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*ipamv1.IPClaim)
+	dst.ObjectMeta = src.ObjectMeta
+
+	dst.Spec = ipamv1.IPClaimSpec{
+		Pool:            src.Spec.Pool,
+		PoolSelector:    src.Spec.PoolSelector,
+		Subnet:          subnetPtrTo(src.Spec.Subnet),
+		Prefix:          src.Spec.Prefix,
+		AffinityGroup:   src.Spec.AffinityGroup,
+		HAAddressSet:    src.Spec.HAAddressSet,
+		Roles:           src.Spec.Roles,
+		AddressCount:    src.Spec.AddressCount,
+		BindingDeadline: src.Spec.BindingDeadline,
+		LeaseDuration:   src.Spec.LeaseDuration,
+	}
+	if src.Spec.PreferredAddress != nil {
+		dst.Spec.PreferredAddress = &ipamv1.PreferredAddress{
+			Address: ipamv1.IPAddressStr(src.Spec.PreferredAddress.Address),
+			Strict:  src.Spec.PreferredAddress.Strict,
+		}
+	}
+
+	dst.Status = ipamv1.IPClaimStatus{
+		Address:   src.Status.Address,
+		Addresses: src.Status.Addresses,
+		Pool:      src.Status.Pool,
+	}
+	for _, condition := range src.Status.Conditions {
+		if condition.Type == IPClaimAllocationFailedCondition {
+			if condition.Status == metav1.ConditionTrue {
+				message := condition.Message
+				dst.Status.ErrorMessage = &message
+			}
+			continue
+		}
+		dst.Status.Conditions = append(dst.Status.Conditions, condition)
+	}
+	return nil
+}
+
+// ConvertFrom converts the IPClaim from the v1alpha1 hub version. The error
+// message is converted to the AllocationFailed condition. Its time is not
+// recorded by the hub version, the creation time of the claim is used.
+func (dst *IPClaim) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*ipamv1.IPClaim)
+	dst.ObjectMeta = src.ObjectMeta
+
+	dst.Spec = IPClaimSpec{
+		Pool:            src.Spec.Pool,
+		PoolSelector:    src.Spec.PoolSelector,
+		Subnet:          subnetPtrFrom(src.Spec.Subnet),
+		Prefix:          src.Spec.Prefix,
+		AffinityGroup:   src.Spec.AffinityGroup,
+		HAAddressSet:    src.Spec.HAAddressSet,
+		Roles:           src.Spec.Roles,
+		AddressCount:    src.Spec.AddressCount,
+		BindingDeadline: src.Spec.BindingDeadline,
+		LeaseDuration:   src.Spec.LeaseDuration,
+	}
+	if src.Spec.PreferredAddress != nil {
+		dst.Spec.PreferredAddress = &PreferredAddress{
+			Address: IPAddressStr(src.Spec.PreferredAddress.Address),
+			Strict:  src.Spec.PreferredAddress.Strict,
+		}
+	}
+
+	dst.Status = IPClaimStatus{
+		Address:    src.Status.Address,
+		Addresses:  src.Status.Addresses,
+		Pool:       src.Status.Pool,
+		Conditions: src.Status.Conditions,
+	}
+	if src.Status.ErrorMessage != nil {
+		dst.Status.Conditions = append(append([]metav1.Condition{}, src.Status.Conditions...),
+			metav1.Condition{
+				Type:               IPClaimAllocationFailedCondition,
+				Status:             metav1.ConditionTrue,
+				Reason:             IPClaimAllocationFailedCondition,
+				Message:            *src.Status.ErrorMessage,
+				LastTransitionTime: src.CreationTimestamp,
+			},
+		)
+	}
+	return nil
+}
+
+// ConvertTo converts the IPAddress to the v1alpha1 hub version
+func (src *IPAddress) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*ipamv1.IPAddress)
+	dst.ObjectMeta = src.ObjectMeta
+	dst.Spec = ipamv1.IPAddressSpec{
+		Claim:           src.Spec.Claim,
+		Pool:            src.Spec.Pool,
+		Prefix:          src.Spec.Prefix,
+		Gateway:         addressPtrTo(src.Spec.Gateway),
+		Address:         ipamv1.IPAddressStr(src.Spec.Address),
+		DelegatedPrefix: subnetPtrTo(src.Spec.DelegatedPrefix),
+		DNSServers:      addressesTo(src.Spec.DNSServers),
+		SearchDomains:   src.Spec.SearchDomains,
+		NTPServers:      src.Spec.NTPServers,
+		DomainName:      src.Spec.DomainName,
+	}
+	return nil
+}
+
+// ConvertFrom converts the IPAddress from the v1alpha1 hub version
+func (dst *IPAddress) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*ipamv1.IPAddress)
+	dst.ObjectMeta = src.ObjectMeta
+	dst.Spec = IPAddressSpec{
+		Claim:           src.Spec.Claim,
+		Pool:            src.Spec.Pool,
+		Prefix:          src.Spec.Prefix,
+		Gateway:         addressPtrFrom(src.Spec.Gateway),
+		Address:         IPAddressStr(src.Spec.Address),
+		DelegatedPrefix: subnetPtrFrom(src.Spec.DelegatedPrefix),
+		DNSServers:      addressesFrom(src.Spec.DNSServers),
+		SearchDomains:   src.Spec.SearchDomains,
+		NTPServers:      src.Spec.NTPServers,
+		DomainName:      src.Spec.DomainName,
+	}
+	return nil
+}
+
+func convertIPPoolSpecTo(in *IPPoolSpec, out *ipamv1.IPPoolSpec) {
+	*out = ipamv1.IPPoolSpec{
+		ClusterName:               in.ClusterName,
+		PreAllocations:            addressMapTo(in.PreAllocations),
+		MACReservations:           addressMapTo(in.MACReservations),
+		Prefix:                    in.Prefix,
+		DelegatedPrefix:           in.DelegatedPrefix,
+		DualStack:                 in.DualStack,
+		Gateway:                   addressPtrTo(in.Gateway),
+		OffSubnetGateway:          in.OffSubnetGateway,
+		DNSServers:                addressesTo(in.DNSServers),
+		SearchDomains:             in.SearchDomains,
+		NTPServers:                in.NTPServers,
+		DomainName:                in.DomainName,
+		NamePrefix:                in.NamePrefix,
+		AllowedNamespaces:         in.AllowedNamespaces,
+		PropagatedAnnotations:     in.PropagatedAnnotations,
+		CompactAllocations:        in.CompactAllocations,
+		ClaimBindingDeadline:      in.ClaimBindingDeadline,
+		LeaseDuration:             in.LeaseDuration,
+		ExternallyManaged:         in.ExternallyManaged,
+		StickyAllocationRetention: in.StickyAllocationRetention,
+		ReclaimPolicy:             ipamv1.ReclaimPolicy(in.ReclaimPolicy),
+		QuarantinePeriod:          in.QuarantinePeriod,
+		AllocationStrategy:        ipamv1.AllocationStrategy(in.AllocationStrategy),
+		FallbackPools:             in.FallbackPools,
+		DeletionPolicy:            ipamv1.DeletionPolicy(in.DeletionPolicy),
+	}
+	if in.Pools != nil {
+		out.Pools = make([]ipamv1.Pool, len(in.Pools))
+		for i, pool := range in.Pools {
+			out.Pools[i] = ipamv1.Pool{
+				Start:                       addressPtrTo(pool.Start),
+				End:                         addressPtrTo(pool.End),
+				Subnet:                      subnetPtrTo(pool.Subnet),
+				AllocateNetworkAndBroadcast: pool.AllocateNetworkAndBroadcast,
+				Prefix:                      pool.Prefix,
+				Gateway:                     addressPtrTo(pool.Gateway),
+				OffSubnetGateway:            pool.OffSubnetGateway,
+				DNSServers:                  addressesTo(pool.DNSServers),
+				SearchDomains:               pool.SearchDomains,
+				NTPServers:                  pool.NTPServers,
+				DomainName:                  pool.DomainName,
+				Disabled:                    pool.Disabled,
+			}
+			if pool.Exclude != nil {
+				out.Pools[i].Exclude = make([]ipamv1.ExcludedRange, len(pool.Exclude))
+				for j, excluded := range pool.Exclude {
+					out.Pools[i].Exclude[j] = ipamv1.ExcludedRange{
+						Start: ipamv1.IPAddressStr(excluded.Start),
+						End:   addressPtrTo(excluded.End),
+					}
+				}
+			}
+		}
+	}
+	if in.ReleaseHook != nil {
+		out.ReleaseHook = &ipamv1.ReleaseHook{
+			URL:     in.ReleaseHook.URL,
+			Timeout: in.ReleaseHook.Timeout,
+			Job:     in.ReleaseHook.Job,
+		}
+	}
+	if in.Backend != nil {
+		out.Backend = &ipamv1.Backend{}
+		if in.Backend.NetBox != nil {
+			out.Backend.NetBox = &ipamv1.NetBoxBackend{
+				URL:     in.Backend.NetBox.URL,
+				Status:  in.Backend.NetBox.Status,
+				Tags:    in.Backend.NetBox.Tags,
+				Timeout: in.Backend.NetBox.Timeout,
+			}
+		}
+		if in.Backend.Infoblox != nil {
+			out.Backend.Infoblox = &ipamv1.InfobloxBackend{
+				URL:               in.Backend.Infoblox.URL,
+				NetworkView:       in.Backend.Infoblox.NetworkView,
+				CredentialsSecret: in.Backend.Infoblox.CredentialsSecret,
+				Timeout:           in.Backend.Infoblox.Timeout,
+			}
+		}
+	}
+	if in.Drain != nil {
+		out.Drain = &ipamv1.PoolDrain{TargetPool: in.Drain.TargetPool}
+	}
+}
+
+func convertIPPoolSpecFrom(in *ipamv1.IPPoolSpec, out *IPPoolSpec) {
+	*out = IPPoolSpec{
+		ClusterName:               in.ClusterName,
+		PreAllocations:            addressMapFrom(in.PreAllocations),
+		MACReservations:           addressMapFrom(in.MACReservations),
+		Prefix:                    in.Prefix,
+		DelegatedPrefix:           in.DelegatedPrefix,
+		DualStack:                 in.DualStack,
+		Gateway:                   addressPtrFrom(in.Gateway),
+		OffSubnetGateway:          in.OffSubnetGateway,
+		DNSServers:                addressesFrom(in.DNSServers),
+		SearchDomains:             in.SearchDomains,
+		NTPServers:                in.NTPServers,
+		DomainName:                in.DomainName,
+		NamePrefix:                in.NamePrefix,
+		AllowedNamespaces:         in.AllowedNamespaces,
+		PropagatedAnnotations:     in.PropagatedAnnotations,
+		CompactAllocations:        in.CompactAllocations,
+		ClaimBindingDeadline:      in.ClaimBindingDeadline,
+		LeaseDuration:             in.LeaseDuration,
+		ExternallyManaged:         in.ExternallyManaged,
+		StickyAllocationRetention: in.StickyAllocationRetention,
+		ReclaimPolicy:             ReclaimPolicy(in.ReclaimPolicy),
+		QuarantinePeriod:          in.QuarantinePeriod,
+		AllocationStrategy:        AllocationStrategy(in.AllocationStrategy),
+		FallbackPools:             in.FallbackPools,
+		DeletionPolicy:            DeletionPolicy(in.DeletionPolicy),
+	}
+	if in.Pools != nil {
+		out.Pools = make([]Pool, len(in.Pools))
+		for i, pool := range in.Pools {
+			out.Pools[i] = Pool{
+				Start:                       addressPtrFrom(pool.Start),
+				End:                         addressPtrFrom(pool.End),
+				Subnet:                      subnetPtrFrom(pool.Subnet),
+				AllocateNetworkAndBroadcast: pool.AllocateNetworkAndBroadcast,
+				Prefix:                      pool.Prefix,
+				Gateway:                     addressPtrFrom(pool.Gateway),
+				OffSubnetGateway:            pool.OffSubnetGateway,
+				DNSServers:                  addressesFrom(pool.DNSServers),
+				SearchDomains:               pool.SearchDomains,
+				NTPServers:                  pool.NTPServers,
+				DomainName:                  pool.DomainName,
+				Disabled:                    pool.Disabled,
+			}
+			if pool.Exclude != nil {
+				out.Pools[i].Exclude = make([]ExcludedRange, len(pool.Exclude))
+				for j, excluded := range pool.Exclude {
+					out.Pools[i].Exclude[j] = ExcludedRange{
+						Start: IPAddressStr(excluded.Start),
+						End:   addressPtrFrom(excluded.End),
+					}
+				}
+			}
+		}
+	}
+	if in.ReleaseHook != nil {
+		out.ReleaseHook = &ReleaseHook{
+			URL:     in.ReleaseHook.URL,
+			Timeout: in.ReleaseHook.Timeout,
+			Job:     in.ReleaseHook.Job,
+		}
+	}
+	if in.Backend != nil {
+		out.Backend = &Backend{}
+		if in.Backend.NetBox != nil {
+			out.Backend.NetBox = &NetBoxBackend{
+				URL:     in.Backend.NetBox.URL,
+				Status:  in.Backend.NetBox.Status,
+				Tags:    in.Backend.NetBox.Tags,
+				Timeout: in.Backend.NetBox.Timeout,
+			}
+		}
+		if in.Backend.Infoblox != nil {
+			out.Backend.Infoblox = &InfobloxBackend{
+				URL:               in.Backend.Infoblox.URL,
+				NetworkView:       in.Backend.Infoblox.NetworkView,
+				CredentialsSecret: in.Backend.Infoblox.CredentialsSecret,
+				Timeout:           in.Backend.Infoblox.Timeout,
+			}
+		}
+	}
+	if in.Drain != nil {
+		out.Drain = &PoolDrain{TargetPool: in.Drain.TargetPool}
+	}
+}
+
+// convertIPPoolStatusTo converts the status of a pool to the hub version,
+// where the capacity is flattened and the allocated ranges are rendered in
+// the "first-last" form
+func convertIPPoolStatusTo(in *IPPoolStatus, out *ipamv1.IPPoolStatus) {
+	*out = ipamv1.IPPoolStatus{
+		LastUpdated:          in.LastUpdated,
+		Allocations:          addressMapTo(in.Allocations),
+		AllocationShards:     in.AllocationShards,
+		AffinityGroups:       in.AffinityGroups,
+		QuarantinedAddresses: in.QuarantinedAddresses,
+		ReleasedAddresses:    in.ReleasedAddresses,
+		Conditions:           in.Conditions,
+	}
+	if in.AllocatedRanges != nil {
+		out.AllocatedRanges = make([]string, len(in.AllocatedRanges))
+		for i, allocatedRange := range in.AllocatedRanges {
+			out.AllocatedRanges[i] = string(allocatedRange.Start)
+			if allocatedRange.End != nil {
+				out.AllocatedRanges[i] += allocatedRangeSeparator + string(*allocatedRange.End)
+			}
+		}
+	}
+	if in.RetainedAddresses != nil {
+		out.RetainedAddresses = make(map[string]ipamv1.RetainedAddress, len(in.RetainedAddresses))
+		for key, retained := range in.RetainedAddresses {
+			out.RetainedAddresses[key] = ipamv1.RetainedAddress{
+				Address:    ipamv1.IPAddressStr(retained.Address),
+				ReleasedAt: retained.ReleasedAt,
+			}
+		}
+	}
+	if in.Capacity != nil {
+		out.TotalCount = in.Capacity.Total
+		out.AllocatedCount = in.Capacity.Allocated
+		out.AvailableCount = in.Capacity.Available
+		out.ExactTotalCount = in.Capacity.ExactTotal
+		out.ExactAvailableCount = in.Capacity.ExactAvailable
+	}
+}
+
+// convertIPPoolStatusFrom converts the status of a pool from the hub version.
+// The capacity is only set if the hub version has any count.
+func convertIPPoolStatusFrom(in *ipamv1.IPPoolStatus, out *IPPoolStatus) {
+	*out = IPPoolStatus{
+		LastUpdated:          in.LastUpdated,
+		Allocations:          addressMapFrom(in.Allocations),
+		AllocationShards:     in.AllocationShards,
+		AffinityGroups:       in.AffinityGroups,
+		QuarantinedAddresses: in.QuarantinedAddresses,
+		ReleasedAddresses:    in.ReleasedAddresses,
+		Conditions:           in.Conditions,
+	}
+	if in.AllocatedRanges != nil {
+		out.AllocatedRanges = make([]AddressRange, len(in.AllocatedRanges))
+		for i, allocatedRange := range in.AllocatedRanges {
+			bounds := strings.SplitN(allocatedRange, allocatedRangeSeparator, 2)
+			out.AllocatedRanges[i] = AddressRange{Start: IPAddressStr(bounds[0])}
+			if len(bounds) == 2 {
+				end := IPAddressStr(bounds[1])
+				out.AllocatedRanges[i].End = &end
+			}
+		}
+	}
+	if in.RetainedAddresses != nil {
+		out.RetainedAddresses = make(map[string]RetainedAddress, len(in.RetainedAddresses))
+		for key, retained := range in.RetainedAddresses {
+			out.RetainedAddresses[key] = RetainedAddress{
+				Address:    IPAddressStr(retained.Address),
+				ReleasedAt: retained.ReleasedAt,
+			}
+		}
+	}
+	if in.TotalCount != 0 || in.AllocatedCount != 0 || in.AvailableCount != 0 {
+		out.Capacity = &PoolCapacity{
+			Total:          in.TotalCount,
+			Allocated:      in.AllocatedCount,
+			Available:      in.AvailableCount,
+			ExactTotal:     in.ExactTotalCount,
+			ExactAvailable: in.ExactAvailableCount,
+		}
+	}
+}
+
+func addressPtrTo(in *IPAddressStr) *ipamv1.IPAddressStr {
+	if in == nil {
+		return nil
+	}
+	out := ipamv1.IPAddressStr(*in)
+	return &out
+}
+
+func addressPtrFrom(in *ipamv1.IPAddressStr) *IPAddressStr {
+	if in == nil {
+		return nil
+	}
+	out := IPAddressStr(*in)
+	return &out
+}
+
+func subnetPtrTo(in *IPSubnetStr) *ipamv1.IPSubnetStr {
+	if in == nil {
+		return nil
+	}
+	out := ipamv1.IPSubnetStr(*in)
+	return &out
+}
+
+func subnetPtrFrom(in *ipamv1.IPSubnetStr) *IPSubnetStr {
+	if in == nil {
+		return nil
+	}
+	out := IPSubnetStr(*in)
+	return &out
+}
+
+func addressesTo(in []IPAddressStr) []ipamv1.IPAddressStr {
+	if in == nil {
+		return nil
+	}
+	out := make([]ipamv1.IPAddressStr, len(in))
+	for i, address := range in {
+		out[i] = ipamv1.IPAddressStr(address)
+	}
+	return out
+}
+
+func addressesFrom(in []ipamv1.IPAddressStr) []IPAddressStr {
+	if in == nil {
+		return nil
+	}
+	out := make([]IPAddressStr, len(in))
+	for i, address := range in {
+		out[i] = IPAddressStr(address)
+	}
+	return out
+}
+
+func addressMapTo(in map[string]IPAddressStr) map[string]ipamv1.IPAddressStr {
+	if in == nil {
+		return nil
+	}
+	out := make(map[string]ipamv1.IPAddressStr, len(in))
+	for key, address := range in {
+		out[key] = ipamv1.IPAddressStr(address)
+	}
+	return out
+}
+
+func addressMapFrom(in map[string]ipamv1.IPAddressStr) map[string]IPAddressStr {
+	if in == nil {
+		return nil
+	}
+	out := make(map[string]IPAddressStr, len(in))
+	for key, address := range in {
+		out[key] = IPAddressStr(address)
+	}
+	return out
+}
@@ -0,0 +1,350 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"testing"
+	"time"
+
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/conversion"
+)
+
+var (
+	creationTime = metav1.NewTime(time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC))
+	releaseTime  = metav1.NewTime(time.Date(2021, 6, 2, 0, 0, 0, 0, time.UTC))
+)
+
+func hubAddressPtr(address string) *ipamv1.IPAddressStr {
+	ipAddress := ipamv1.IPAddressStr(address)
+	return &ipAddress
+}
+
+func hubIPPoolSpec() ipamv1.IPPoolSpec {
+	return ipamv1.IPPoolSpec{
+		ClusterName: pointer.StringPtr("abc"),
+		Pools: []ipamv1.Pool{
+			{
+				Start:                       hubAddressPtr("192.168.0.10"),
+				End:                         hubAddressPtr("192.168.0.100"),
+				Subnet:                      (*ipamv1.IPSubnetStr)(pointer.StringPtr("192.168.0.0/24")),
+				AllocateNetworkAndBroadcast: true,
+				Prefix:                      24,
+				Gateway:                     hubAddressPtr("192.168.0.1"),
+				OffSubnetGateway:            true,
+				DNSServers:                  []ipamv1.IPAddressStr{"8.8.8.8"},
+				SearchDomains:               []string{"example.com"},
+				NTPServers:                  []string{"ntp.example.com"},
+				DomainName:                  "example.com",
+				Disabled:                    true,
+				Exclude: []ipamv1.ExcludedRange{
+					{Start: "192.168.0.20", End: hubAddressPtr("192.168.0.29")},
+					{Start: "192.168.0.30"},
+				},
+			},
+		},
+		PreAllocations:            map[string]ipamv1.IPAddressStr{"abc-1": "192.168.0.11"},
+		MACReservations:           map[string]ipamv1.IPAddressStr{"00:00:5e:10:00:01": "192.168.0.12"},
+		Prefix:                    24,
+		DelegatedPrefix:           28,
+		DualStack:                 true,
+		Gateway:                   hubAddressPtr("192.168.0.1"),
+		OffSubnetGateway:          true,
+		DNSServers:                []ipamv1.IPAddressStr{"8.8.4.4"},
+		SearchDomains:             []string{"example.org"},
+		NTPServers:                []string{"192.168.0.2"},
+		DomainName:                "example.org",
+		NamePrefix:                "abc",
+		AllowedNamespaces:         []string{ipamv1.AllNamespaces},
+		PropagatedAnnotations:     []string{"example.com/*"},
+		CompactAllocations:        true,
+		ClaimBindingDeadline:      &metav1.Duration{Duration: time.Minute},
+		LeaseDuration:             &metav1.Duration{Duration: time.Hour},
+		ExternallyManaged:         true,
+		StickyAllocationRetention: &metav1.Duration{Duration: 2 * time.Hour},
+		ReclaimPolicy:             ipamv1.ReclaimPolicyRetain,
+		QuarantinePeriod:          &metav1.Duration{Duration: 3 * time.Hour},
+		AllocationStrategy:        ipamv1.AllocationStrategyHash,
+		ReleaseHook: &ipamv1.ReleaseHook{
+			URL:     "https://hook.example.com",
+			Timeout: &metav1.Duration{Duration: time.Second},
+			Job:     &batchv1.JobTemplateSpec{ObjectMeta: metav1.ObjectMeta{Name: "release"}},
+		},
+		Backend: &ipamv1.Backend{
+			NetBox: &ipamv1.NetBoxBackend{
+				URL:     "https://netbox.example.com",
+				Status:  "reserved",
+				Tags:    []string{"metal3"},
+				Timeout: &metav1.Duration{Duration: time.Second},
+			},
+			Infoblox: &ipamv1.InfobloxBackend{
+				URL:               "https://infoblox.example.com/wapi/v2.11",
+				NetworkView:       "default",
+				CredentialsSecret: corev1.SecretReference{Name: "infoblox", Namespace: "foo"},
+				Timeout:           &metav1.Duration{Duration: time.Second},
+			},
+		},
+		Drain:          &ipamv1.PoolDrain{TargetPool: "def"},
+		FallbackPools:  []string{"ghi"},
+		DeletionPolicy: ipamv1.DeletionPolicyCascade,
+	}
+}
+
+func hubIPPoolStatus() ipamv1.IPPoolStatus {
+	return ipamv1.IPPoolStatus{
+		LastUpdated:     &releaseTime,
+		Allocations:     map[string]ipamv1.IPAddressStr{"abc-1": "192.168.0.11"},
+		AllocatedRanges: []string{"192.168.0.12-192.168.0.15", "192.168.0.17"},
+		AffinityGroups:  map[string]int{"rack-1": 0},
+		RetainedAddresses: map[string]ipamv1.RetainedAddress{
+			"foo/abc-2": {Address: "192.168.0.16", ReleasedAt: releaseTime},
+		},
+		QuarantinedAddresses: map[string]metav1.Time{"192.168.0.18": releaseTime},
+		ReleasedAddresses:    map[string]metav1.Time{"192.168.0.19": releaseTime},
+		TotalCount:           91,
+		AllocatedCount:       6,
+		AvailableCount:       85,
+		Conditions: []metav1.Condition{
+			{
+				Type:               ipamv1.IPPoolReadyCondition,
+				Status:             metav1.ConditionTrue,
+				Reason:             "Reconciled",
+				LastTransitionTime: releaseTime,
+			},
+		},
+	}
+}
+
+func TestIPPoolConversion(t *testing.T) {
+	g := NewWithT(t)
+
+	hub := &ipamv1.IPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "abc", Namespace: "foo"},
+		Spec:       hubIPPoolSpec(),
+		Status:     hubIPPoolStatus(),
+	}
+
+	spoke := &IPPool{}
+	g.Expect(spoke.ConvertFrom(hub.DeepCopy())).To(Succeed())
+	g.Expect(spoke.ObjectMeta).To(Equal(hub.ObjectMeta))
+	g.Expect(spoke.Status.Allocations).To(Equal(map[string]IPAddressStr{"abc-1": "192.168.0.11"}))
+	g.Expect(spoke.Status.Capacity).To(Equal(&PoolCapacity{Total: 91, Allocated: 6, Available: 85}))
+	g.Expect(spoke.Status.AllocatedRanges).To(Equal([]AddressRange{
+		{Start: "192.168.0.12", End: addressPtr("192.168.0.15")},
+		{Start: "192.168.0.17"},
+	}))
+
+	restored := &ipamv1.IPPool{}
+	g.Expect(spoke.DeepCopy().ConvertTo(restored)).To(Succeed())
+	g.Expect(restored).To(Equal(hub))
+
+	again := &IPPool{}
+	g.Expect(again.ConvertFrom(restored)).To(Succeed())
+	g.Expect(again).To(Equal(spoke))
+}
+
+func TestIPPoolConversionWithoutCapacity(t *testing.T) {
+	g := NewWithT(t)
+
+	hub := &ipamv1.IPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "abc", Namespace: "foo"},
+		Spec:       ipamv1.IPPoolSpec{NamePrefix: "abc"},
+	}
+
+	spoke := &IPPool{}
+	g.Expect(spoke.ConvertFrom(hub.DeepCopy())).To(Succeed())
+	g.Expect(spoke.Spec).To(Equal(IPPoolSpec{NamePrefix: "abc"}))
+	g.Expect(spoke.Status).To(Equal(IPPoolStatus{}))
+
+	restored := &ipamv1.IPPool{}
+	g.Expect(spoke.ConvertTo(restored)).To(Succeed())
+	g.Expect(restored).To(Equal(hub))
+}
+
+func TestClusterIPPoolConversion(t *testing.T) {
+	g := NewWithT(t)
+
+	hub := &ipamv1.ClusterIPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "abc"},
+		Spec:       hubIPPoolSpec(),
+		Status:     hubIPPoolStatus(),
+	}
+
+	spoke := &ClusterIPPool{}
+	g.Expect(spoke.ConvertFrom(hub.DeepCopy())).To(Succeed())
+	g.Expect(spoke.Status.Capacity).To(Equal(&PoolCapacity{Total: 91, Allocated: 6, Available: 85}))
+
+	restored := &ipamv1.ClusterIPPool{}
+	g.Expect(spoke.DeepCopy().ConvertTo(restored)).To(Succeed())
+	g.Expect(restored).To(Equal(hub))
+}
+
+func TestIPClaimConversion(t *testing.T) {
+	staleCondition := metav1.Condition{
+		Type:               ipamv1.IPClaimStaleCondition,
+		Status:             metav1.ConditionFalse,
+		Reason:             "Allocated",
+		LastTransitionTime: releaseTime,
+	}
+
+	tests := []struct {
+		name               string
+		errorMessage       *string
+		expectedConditions []metav1.Condition
+	}{
+		{
+			name:               "should keep the conditions without error message",
+			expectedConditions: []metav1.Condition{staleCondition},
+		},
+		{
+			name:         "should convert the error message to a condition",
+			errorMessage: pointer.StringPtr("Exhausted IP Pools"),
+			expectedConditions: []metav1.Condition{
+				staleCondition,
+				{
+					Type:               IPClaimAllocationFailedCondition,
+					Status:             metav1.ConditionTrue,
+					Reason:             IPClaimAllocationFailedCondition,
+					Message:            "Exhausted IP Pools",
+					LastTransitionTime: creationTime,
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			hub := &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              "abc-1",
+					Namespace:         "foo",
+					CreationTimestamp: creationTime,
+				},
+				Spec: ipamv1.IPClaimSpec{
+					Pool: corev1.ObjectReference{Name: "abc", Namespace: "foo"},
+					PoolSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"role": "provisioning"},
+					},
+					Subnet:           (*ipamv1.IPSubnetStr)(pointer.StringPtr("192.168.0.0/25")),
+					PreferredAddress: &ipamv1.PreferredAddress{Address: "192.168.0.11", Strict: true},
+					Prefix:           pointer.IntPtr(25),
+					AffinityGroup:    "rack-1",
+					HAAddressSet:     true,
+					Roles:            []string{"bmc"},
+					AddressCount:     pointer.IntPtr(2),
+					BindingDeadline:  &metav1.Duration{Duration: time.Minute},
+					LeaseDuration:    &metav1.Duration{Duration: time.Hour},
+				},
+				Status: ipamv1.IPClaimStatus{
+					Address: &corev1.ObjectReference{Name: "abc-192-168-0-11"},
+					Addresses: map[string]corev1.ObjectReference{
+						"bmc": {Name: "abc-192-168-0-12"},
+					},
+					Pool:         &corev1.ObjectReference{Name: "abc", Namespace: "foo"},
+					ErrorMessage: tt.errorMessage,
+					Conditions:   []metav1.Condition{staleCondition},
+				},
+			}
+
+			spoke := &IPClaim{}
+			g.Expect(spoke.ConvertFrom(hub.DeepCopy())).To(Succeed())
+			g.Expect(spoke.Status.Conditions).To(Equal(tt.expectedConditions))
+
+			restored := &ipamv1.IPClaim{}
+			g.Expect(spoke.DeepCopy().ConvertTo(restored)).To(Succeed())
+			g.Expect(restored).To(Equal(hub))
+		})
+	}
+}
+
+func TestIPClaimConversionResolvedFailure(t *testing.T) {
+	g := NewWithT(t)
+
+	spoke := &IPClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "abc-1", Namespace: "foo"},
+		Spec: IPClaimSpec{
+			Pool: corev1.ObjectReference{Name: "abc", Namespace: "foo"},
+		},
+		Status: IPClaimStatus{
+			Conditions: []metav1.Condition{
+				{
+					Type:   IPClaimAllocationFailedCondition,
+					Status: metav1.ConditionFalse,
+					Reason: IPClaimAllocationFailedCondition,
+				},
+			},
+		},
+	}
+
+	hub := &ipamv1.IPClaim{}
+	g.Expect(spoke.ConvertTo(hub)).To(Succeed())
+	g.Expect(hub.Status.ErrorMessage).To(BeNil())
+	g.Expect(hub.Status.Conditions).To(BeEmpty())
+}
+
+func TestIPAddressConversion(t *testing.T) {
+	g := NewWithT(t)
+
+	hub := &ipamv1.IPAddress{
+		ObjectMeta: metav1.ObjectMeta{Name: "abc-192-168-0-11", Namespace: "foo"},
+		Spec: ipamv1.IPAddressSpec{
+			Claim:           corev1.ObjectReference{Name: "abc-1", Namespace: "foo"},
+			Pool:            corev1.ObjectReference{Name: "abc", Namespace: "foo"},
+			Prefix:          24,
+			Gateway:         hubAddressPtr("192.168.0.1"),
+			Address:         "192.168.0.11",
+			DelegatedPrefix: (*ipamv1.IPSubnetStr)(pointer.StringPtr("192.168.0.16/28")),
+			DNSServers:      []ipamv1.IPAddressStr{"8.8.8.8"},
+			SearchDomains:   []string{"example.com"},
+			NTPServers:      []string{"ntp.example.com"},
+			DomainName:      "example.com",
+		},
+	}
+
+	spoke := &IPAddress{}
+	g.Expect(spoke.ConvertFrom(hub.DeepCopy())).To(Succeed())
+	g.Expect(spoke.Spec.Address).To(Equal(IPAddressStr("192.168.0.11")))
+
+	restored := &ipamv1.IPAddress{}
+	g.Expect(spoke.DeepCopy().ConvertTo(restored)).To(Succeed())
+	g.Expect(restored).To(Equal(hub))
+}
+
+func TestConvertible(t *testing.T) {
+	g := NewWithT(t)
+
+	s := runtime.NewScheme()
+	g.Expect(ipamv1.AddToScheme(s)).To(Succeed())
+	g.Expect(AddToScheme(s)).To(Succeed())
+
+	for _, obj := range []runtime.Object{&IPPool{}, &ClusterIPPool{}, &IPClaim{}, &IPAddress{}} {
+		g.Expect(conversion.IsConvertible(s, obj)).To(BeTrue())
+	}
+}
+
+func addressPtr(address string) *IPAddressStr {
+	ipAddress := IPAddressStr(address)
+	return &ipAddress
+}
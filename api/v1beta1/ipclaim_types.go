@@ -0,0 +1,166 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// IPClaimAllocationFailedCondition reports the claims whose addresses
+	// could not be allocated, with the error in its message.
+	IPClaimAllocationFailedCondition = "AllocationFailed"
+)
+
+// IPClaimSpec defines the desired state of IPClaim.
+type IPClaimSpec struct {
+
+	// Pool is the IPPool this was generated from.
+	Pool corev1.ObjectReference `json:"pool"`
+
+	// PoolSelector selects the IPPool of the claim by its labels when the
+	// pool name is not given, among the IPPools of the namespace of the claim,
+	// or among the ClusterIPPools if the pool kind is ClusterIPPool. The
+	// selected pool is then recorded in the pool reference.
+	// +optional
+	PoolSelector *metav1.LabelSelector `json:"poolSelector,omitempty"`
+
+	// Subnet restricts the allocation to the addresses of the pool that are
+	// in this subnet. It must overlap with at least one of the pools.
+	// +optional
+	Subnet *IPSubnetStr `json:"subnet,omitempty"`
+
+	// PreferredAddress is an address the allocator tries first for the claim
+	// address, such as the address a brown-field host already owns.
+	// +optional
+	PreferredAddress *PreferredAddress `json:"preferredAddress,omitempty"`
+
+	// Prefix overrides the prefix of the pools for the allocated address.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=128
+	// +optional
+	Prefix *int `json:"prefix,omitempty"`
+
+	// AffinityGroup is a key shared by claims whose addresses must all be
+	// allocated from the same pool of the IPPool. The first allocation of the
+	// group selects the pool.
+	// +optional
+	AffinityGroup string `json:"affinityGroup,omitempty"`
+
+	// HAAddressSet requests a set of addresses for a pair of HA gateways from
+	// the same pool: the claim address for the first node, an address for the
+	// peer node and a virtual IP. The addresses are released together.
+	// +optional
+	HAAddressSet bool `json:"haAddressSet,omitempty"`
+
+	// Roles is the list of the roles of the additional addresses requested by
+	// the claim, such as secondary or bmc. The claim address is the primary
+	// one. An IPAddress labelled with its role is created for each of them.
+	// +optional
+	Roles []string `json:"roles,omitempty"`
+
+	// AddressCount is the number of addresses requested by the claim, all
+	// allocated from the same pool of the IPPool. The claim address is the
+	// first one, the others get the address-1 to address-N-1 roles.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=256
+	// +optional
+	AddressCount *int `json:"addressCount,omitempty"`
+
+	// BindingDeadline is the duration, from the creation of the claim, after
+	// which the claim is marked failed if it has no address. It defaults to
+	// the claimBindingDeadline of the IPPool. Zero disables the deadline.
+	// +optional
+	BindingDeadline *metav1.Duration `json:"bindingDeadline,omitempty"`
+
+	// LeaseDuration is the duration after which the addresses of the claim
+	// are released if the lease is not renewed, from the creation of the
+	// claim or its last renewal. It defaults to the leaseDuration of the
+	// IPPool. Zero disables the lease.
+	// +optional
+	LeaseDuration *metav1.Duration `json:"leaseDuration,omitempty"`
+}
+
+// PreferredAddress is the address preferred by a claim.
+type PreferredAddress struct {
+
+	// Address is the preferred address of the claim.
+	Address IPAddressStr `json:"address"`
+
+	// Strict fails the allocation when the address is not available, instead
+	// of allocating another address.
+	// +optional
+	Strict bool `json:"strict,omitempty"`
+}
+
+// IPClaimStatus defines the observed state of IPClaim.
+type IPClaimStatus struct {
+
+	// Address is the IPAddress that was generated for this claim.
+	Address *corev1.ObjectReference `json:"address,omitempty"`
+
+	// Addresses contains the additional IPAddresses generated for this claim,
+	// by role.
+	Addresses map[string]corev1.ObjectReference `json:"addresses,omitempty"`
+
+	// Pool is the IPPool, or ClusterIPPool, that allocated the addresses of
+	// the claim. It differs from the referenced pool when the claim was
+	// handed over to a fallback pool or to the target pool of a drain.
+	// +optional
+	Pool *corev1.ObjectReference `json:"pool,omitempty"`
+
+	// Conditions defines the current service state of the IPClaim. The
+	// errors of the allocation are reported by the AllocationFailed
+	// condition.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:resource:path=ipclaims,scope=Namespaced,categories=cluster-api,shortName=ipc;ipclaim;m3ipc;m3ipclaim;m3ipclaims;metal3ipc;metal3ipclaim;metal3ipclaims
+// +kubebuilder:subresource:status
+// +kubebuilder:object:root=true
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp",description="Time duration since creation of Metal3IPClaim"
+// IPClaim is the Schema for the ipclaims API
+type IPClaim struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	// +kubebuilder:validation:XValidation:rule="!has(oldSelf.pool.name) || (has(self.pool.name) && self.pool.name == oldSelf.pool.name)",message="pool cannot be modified"
+	// +kubebuilder:validation:XValidation:rule="has(self.prefix) == has(oldSelf.prefix) && (!has(self.prefix) || self.prefix == oldSelf.prefix)",message="prefix cannot be modified"
+	// +kubebuilder:validation:XValidation:rule="has(self.affinityGroup) == has(oldSelf.affinityGroup) && (!has(self.affinityGroup) || self.affinityGroup == oldSelf.affinityGroup)",message="affinityGroup cannot be modified"
+	// +kubebuilder:validation:XValidation:rule="(has(self.haAddressSet) && self.haAddressSet) == (has(oldSelf.haAddressSet) && oldSelf.haAddressSet)",message="haAddressSet cannot be modified"
+	// +kubebuilder:validation:XValidation:rule="has(self.addressCount) == has(oldSelf.addressCount) && (!has(self.addressCount) || self.addressCount == oldSelf.addressCount)",message="addressCount cannot be modified"
+	Spec   IPClaimSpec   `json:"spec,omitempty"`
+	Status IPClaimStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// IPClaimList contains a list of IPClaim
+type IPClaimList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []IPClaim `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&IPClaim{}, &IPClaimList{})
+}
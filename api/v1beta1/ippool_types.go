@@ -0,0 +1,560 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ReclaimPolicy is what happens to the IPAddresses of an IPClaim once the
+// claim is deleted.
+type ReclaimPolicy string
+
+const (
+	// ReclaimPolicyDelete deletes the IPAddresses, their addresses are
+	// available again.
+	ReclaimPolicyDelete ReclaimPolicy = "Delete"
+
+	// ReclaimPolicyRetain keeps the IPAddresses for an IPClaim recreated with
+	// the same namespace and name.
+	ReclaimPolicyRetain ReclaimPolicy = "Retain"
+)
+
+// DeletionPolicy is what happens when an IPPool with allocated addresses is
+// deleted.
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyBlock refuses the deletion of the pool while it has
+	// allocated addresses.
+	DeletionPolicyBlock DeletionPolicy = "Block"
+
+	// DeletionPolicyOrphan deletes the pool without waiting for its claims,
+	// which keep their IPAddresses.
+	DeletionPolicyOrphan DeletionPolicy = "Orphan"
+
+	// DeletionPolicyCascade deletes the claims of the pool along with it,
+	// releasing their addresses.
+	DeletionPolicyCascade DeletionPolicy = "Cascade"
+)
+
+// AllocationStrategy is the order in which the free addresses of an IPPool
+// are allocated.
+type AllocationStrategy string
+
+const (
+	// AllocationStrategySequential allocates the first free address of the
+	// pools, in order.
+	AllocationStrategySequential AllocationStrategy = "Sequential"
+
+	// AllocationStrategyRandom allocates the first free address from a random
+	// address of each pool, to avoid the addresses of the hosts configured
+	// statically at the beginning of the pools.
+	AllocationStrategyRandom AllocationStrategy = "Random"
+
+	// AllocationStrategyLeastRecentlyUsed allocates the addresses never
+	// released first, then the ones released the longest ago.
+	AllocationStrategyLeastRecentlyUsed AllocationStrategy = "LeastRecentlyUsed"
+
+	// AllocationStrategyHash allocates the first free address from an address
+	// of each pool derived from a hash of the claim identity, so that the
+	// same claims get the same addresses when they are all created again.
+	AllocationStrategyHash AllocationStrategy = "Hash"
+
+	// AllocationStrategyEUI64 allocates to the claims with the MAC address
+	// annotation the address of the first IPv6 /64 pool derived from the MAC
+	// address, as SLAAC would. The other addresses are allocated
+	// sequentially.
+	AllocationStrategyEUI64 AllocationStrategy = "EUI64"
+)
+
+// MetaDataIPAddress contains the info to render th ip address. It is IP-version
+// agnostic
+// +kubebuilder:validation:XValidation:rule="has(self.start) || has(self.subnet)",message="either start or subnet is required"
+// +kubebuilder:validation:XValidation:rule="!has(self.end) || has(self.start)",message="end is ignored without start"
+// +kubebuilder:validation:XValidation:rule="!has(self.start) || !has(self.end) || self.start.contains(':') == self.end.contains(':')",message="end must be of the family of the start"
+// +kubebuilder:validation:XValidation:rule="!has(self.start) || !has(self.end) || self.start.contains(':') || self.end.contains(':') || int(self.start.split('.')[0]) * 16777216 + int(self.start.split('.')[1]) * 65536 + int(self.start.split('.')[2]) * 256 + int(self.start.split('.')[3]) <= int(self.end.split('.')[0]) * 16777216 + int(self.end.split('.')[1]) * 65536 + int(self.end.split('.')[2]) * 256 + int(self.end.split('.')[3])",message="end must not be before the start"
+// +kubebuilder:validation:XValidation:rule="!has(self.start) || !has(self.subnet) || self.start.contains(':') == self.subnet.contains(':')",message="start must be of the family of the subnet"
+type Pool struct {
+
+	// Start is the first ip address that can be rendered
+	Start *IPAddressStr `json:"start,omitempty"`
+
+	// End is the last IP address that can be rendered. It is used as a validation
+	// that the rendered IP is in bound.
+	End *IPAddressStr `json:"end,omitempty"`
+
+	// Subnet is used to validate that the rendered IP is in bounds. In case the
+	// Start value is not given, it is derived from the subnet ip incremented by 1
+	// (`192.168.0.1` for `192.168.0.0/24`). The network and broadcast
+	// addresses of the subnet are not rendered, unless
+	// AllocateNetworkAndBroadcast is set, and the prefix of the subnet is the
+	// default prefix of the pool.
+	Subnet *IPSubnetStr `json:"subnet,omitempty"`
+
+	// AllocateNetworkAndBroadcast allows the allocation of the network and
+	// broadcast addresses of the subnet, for the networks whose hosts can use
+	// them. The start then defaults to the network address.
+	// +optional
+	AllocateNetworkAndBroadcast bool `json:"allocateNetworkAndBroadcast,omitempty"`
+
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=128
+	// Prefix is the mask of the network as integer (max 128). It defaults to
+	// the prefix of the subnet if given, to the prefix of the IPPool otherwise.
+	Prefix int `json:"prefix,omitempty"`
+
+	// Gateway is the gateway ip address. It defaults to the gateway of the
+	// IPPool if the subnet is not given or contains it.
+	Gateway *IPAddressStr `json:"gateway,omitempty"`
+
+	// OffSubnetGateway allows a gateway out of the network of the pool,
+	// reached through an on-link route.
+	// +optional
+	OffSubnetGateway bool `json:"offSubnetGateway,omitempty"`
+
+	// DNSServers is the list of dns servers
+	DNSServers []IPAddressStr `json:"dnsServers,omitempty"`
+
+	// SearchDomains is the list of dns search domains
+	SearchDomains []string `json:"searchDomains,omitempty"`
+
+	// NTPServers is the list of ntp servers, as IP addresses or host names
+	NTPServers []string `json:"ntpServers,omitempty"`
+
+	// DomainName is the domain name of the network
+	DomainName string `json:"domainName,omitempty"`
+
+	// Disabled disables the allocation of new addresses from the pool, to
+	// drain it. The addresses already allocated from it are kept.
+	// +optional
+	Disabled bool `json:"disabled,omitempty"`
+
+	// Exclude lists the ranges of the pool that are never allocated, such as
+	// the virtual addresses of the routers. The addresses already allocated
+	// from them are kept.
+	// +optional
+	Exclude []ExcludedRange `json:"exclude,omitempty"`
+}
+
+// ExcludedRange is a range of addresses excluded from a pool.
+type ExcludedRange struct {
+
+	// Start is the first excluded address.
+	Start IPAddressStr `json:"start"`
+
+	// End is the last excluded address. It defaults to the start, excluding a
+	// single address.
+	// +optional
+	End *IPAddressStr `json:"end,omitempty"`
+}
+
+// IPPoolSpec defines the desired state of IPPool.
+type IPPoolSpec struct {
+
+	// ClusterName is the name of the Cluster this object belongs to.
+	ClusterName *string `json:"clusterName,omitempty"`
+
+	//Pools contains the list of IP addresses pools
+	// +kubebuilder:validation:MaxItems=256
+	Pools []Pool `json:"pools,omitempty"`
+
+	// PreAllocations contains the preallocated IP addresses
+	PreAllocations map[string]IPAddressStr `json:"preAllocations,omitempty"`
+
+	// MACReservations contains the addresses reserved for the claims with the
+	// MAC address annotation, by lowercase colon-separated MAC address, like
+	// DHCP host reservations.
+	// +optional
+	MACReservations map[string]IPAddressStr `json:"macReservations,omitempty"`
+
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=128
+	// Prefix is the mask of the network as integer (max 128)
+	Prefix int `json:"prefix,omitempty"`
+
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=127
+	// DelegatedPrefix is the length of the prefixes delegated to the claims.
+	// If set, each claim is allocated a whole prefix of this length, aligned
+	// on its length, instead of a single address. It cannot be modified.
+	DelegatedPrefix int `json:"delegatedPrefix,omitempty"`
+
+	// DualStack allocates an IPv4 and an IPv6 address to each claim, all or
+	// none. The claim addresses are allocated from the IPv4 pools, and the
+	// address of the ipv6 role from the IPv6 pools. It cannot be modified.
+	// +optional
+	DualStack bool `json:"dualStack,omitempty"`
+
+	// Gateway is the gateway ip address
+	Gateway *IPAddressStr `json:"gateway,omitempty"`
+
+	// OffSubnetGateway allows a default gateway out of the network of the
+	// pools it applies to, reached through an on-link route.
+	// +optional
+	OffSubnetGateway bool `json:"offSubnetGateway,omitempty"`
+
+	// DNSServers is the list of dns servers
+	DNSServers []IPAddressStr `json:"dnsServers,omitempty"`
+
+	// SearchDomains is the list of dns search domains
+	SearchDomains []string `json:"searchDomains,omitempty"`
+
+	// NTPServers is the list of ntp servers, as IP addresses or host names
+	NTPServers []string `json:"ntpServers,omitempty"`
+
+	// DomainName is the domain name of the network
+	DomainName string `json:"domainName,omitempty"`
+
+	// +kubebuilder:validation:MinLength=1
+	// namePrefix is the prefix used to generate the IPAddress object names
+	NamePrefix string `json:"namePrefix"`
+
+	// AllowedNamespaces is the list of namespaces, other than the namespace of
+	// the pool, whose IPClaims are allowed to reference this pool. The value
+	// "*" allows all namespaces. Cross-namespace references are denied by
+	// default.
+	AllowedNamespaces []string `json:"allowedNamespaces,omitempty"`
+
+	// PropagatedAnnotations is the list of annotations copied from the
+	// IPClaims to their IPAddresses. An entry ending with "*" matches all the
+	// annotations starting with the entry without "*".
+	PropagatedAnnotations []string `json:"propagatedAnnotations,omitempty"`
+
+	// CompactAllocations stores the allocated addresses in the status as
+	// ranges of contiguous addresses instead of one entry per claim, to
+	// reduce the size of the pools with many sequential allocations.
+	CompactAllocations bool `json:"compactAllocations,omitempty"`
+
+	// ClaimBindingDeadline is the default bindingDeadline of the IPClaims of
+	// the pool, the duration after which a claim without an address is
+	// marked failed. Unset or zero disables the deadline.
+	// +optional
+	ClaimBindingDeadline *metav1.Duration `json:"claimBindingDeadline,omitempty"`
+
+	// LeaseDuration is the default leaseDuration of the IPClaims of the pool,
+	// the duration after which the addresses of a claim whose lease is not
+	// renewed are released. Unset or zero disables the leases.
+	// +optional
+	LeaseDuration *metav1.Duration `json:"leaseDuration,omitempty"`
+
+	// ExternallyManaged marks the pool as a read-only mirror of an external
+	// IPAM. Its IPAddress objects are imported from the external system, the
+	// controller binds the IPClaims to the IPAddresses referencing them and
+	// propagates the metadata of the claims, but never allocates nor deletes
+	// an IPAddress.
+	// +optional
+	ExternallyManaged bool `json:"externallyManaged,omitempty"`
+
+	// ReleaseHook deregisters the released addresses from external systems,
+	// such as DNS, DHCP or firewalls. An address is only available again
+	// once the hook succeeded.
+	// +optional
+	ReleaseHook *ReleaseHook `json:"releaseHook,omitempty"`
+
+	// Backend reserves the allocated addresses in an external IPAM. An
+	// address is reserved before its IPAddress is created, and released
+	// before being available again. The addresses already in use in the
+	// backend are skipped.
+	// +optional
+	Backend *Backend `json:"backend,omitempty"`
+
+	// StickyAllocationRetention is the duration during which the addresses
+	// of a deleted IPClaim are kept for it. An IPClaim recreated with the
+	// same namespace and name within that duration gets them back, the other
+	// claims do not get them. Unset or zero disables the sticky allocations.
+	// +optional
+	StickyAllocationRetention *metav1.Duration `json:"stickyAllocationRetention,omitempty"`
+
+	// ReclaimPolicy is what happens to the IPAddresses of a deleted IPClaim.
+	// Delete, the default, deletes them. Retain keeps them, labelled as
+	// retained, until an IPClaim with the same namespace and name adopts
+	// them or the policy is changed to Delete.
+	// +kubebuilder:validation:Enum=Delete;Retain
+	// +optional
+	ReclaimPolicy ReclaimPolicy `json:"reclaimPolicy,omitempty"`
+
+	// QuarantinePeriod is the duration during which a released address can
+	// not be allocated again, so that the ARP and DNS entries of its former
+	// host expire first. Unset or zero disables the quarantine.
+	// +optional
+	QuarantinePeriod *metav1.Duration `json:"quarantinePeriod,omitempty"`
+
+	// AllocationStrategy is the order in which the free addresses are
+	// allocated. Sequential, the default, allocates the first free address.
+	// Random starts from a random address of each pool. LeastRecentlyUsed
+	// allocates the addresses released the longest ago last. Hash starts from
+	// an address derived from the namespace, name and role of the claim.
+	// EUI64 derives the IPv6 address of a claim from its MAC address.
+	// +kubebuilder:validation:Enum=Sequential;Random;LeastRecentlyUsed;Hash;EUI64
+	// +optional
+	AllocationStrategy AllocationStrategy `json:"allocationStrategy,omitempty"`
+
+	// Drain hands the claims of the pool over to a target pool, to renumber
+	// them onto a new subnet. The pool does not allocate any address anymore.
+	// +optional
+	Drain *PoolDrain `json:"drain,omitempty"`
+
+	// FallbackPools are the names of the IPPools, in the namespace of the
+	// pool, serving the claims the pool has no address for, in order. A claim
+	// is handed over to the first of them that is not exhausted.
+	// +optional
+	FallbackPools []string `json:"fallbackPools,omitempty"`
+
+	// DeletionPolicy is what happens when the pool is deleted while it has
+	// allocated addresses. By default, the pool waits for its claims to be
+	// deleted. Block also refuses the deletion at admission, Orphan deletes
+	// the pool without waiting, the claims keeping their IPAddresses, and
+	// Cascade deletes the claims of the pool.
+	// +kubebuilder:validation:Enum=Block;Orphan;Cascade
+	// +optional
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+}
+
+// PoolDrain is the drain of a pool into a target pool. The claims without an
+// address, such as the ones recreated when their machine is reprovisioned,
+// and the claims with the migrate annotation get their address from the
+// target pool. The other claims keep their address until then.
+type PoolDrain struct {
+	// TargetPool is the name of the IPPool, in the namespace of the drained
+	// pool, serving its claims
+	// +kubebuilder:validation:MinLength=1
+	TargetPool string `json:"targetPool"`
+}
+
+// Backend is an external IPAM in which the addresses of a pool are reserved.
+// Exactly one backend must be given.
+// +kubebuilder:validation:XValidation:rule="has(self.netbox) != has(self.infoblox)",message="exactly one of netbox and infoblox must be given"
+type Backend struct {
+
+	// NetBox reserves the addresses as IP addresses of NetBox.
+	// +optional
+	NetBox *NetBoxBackend `json:"netbox,omitempty"`
+
+	// Infoblox reserves the addresses as fixed addresses of Infoblox.
+	// +optional
+	Infoblox *InfobloxBackend `json:"infoblox,omitempty"`
+}
+
+// NetBoxBackend reserves the addresses through the REST API of NetBox. The
+// API token is given to the controller by its --netbox-token-file flag.
+type NetBoxBackend struct {
+
+	// URL is the base URL of NetBox, such as https://netbox.example.com
+	URL string `json:"url"`
+
+	// +kubebuilder:validation:Enum=active;reserved;dhcp
+	// Status is the status of the reserved addresses in NetBox, active by
+	// default.
+	// +optional
+	Status string `json:"status,omitempty"`
+
+	// Tags are the names of the NetBox tags set on the reserved addresses,
+	// along with the tags of the backend-tags annotation of the claims. The
+	// tags must exist in NetBox.
+	// +optional
+	Tags []string `json:"tags,omitempty"`
+
+	// Timeout is the timeout of the requests to NetBox, 10 seconds by
+	// default.
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+}
+
+// InfobloxBackend reserves the addresses through the WAPI of Infoblox. The
+// IPv4 addresses are reserved as fixedaddress records, and the IPv6
+// addresses as ipv6fixedaddress records.
+type InfobloxBackend struct {
+
+	// URL is the base URL of the WAPI, including its version, such as
+	// https://infoblox.example.com/wapi/v2.11
+	URL string `json:"url"`
+
+	// NetworkView is the network view of the reserved addresses, "default"
+	// by default.
+	// +optional
+	NetworkView string `json:"networkView,omitempty"`
+
+	// CredentialsSecret references the Secret containing the username and
+	// password of the WAPI, in its username and password keys. Its namespace
+	// is the namespace of the IPPool by default, and is required for the
+	// ClusterIPPools.
+	CredentialsSecret corev1.SecretReference `json:"credentialsSecret"`
+
+	// Timeout is the timeout of the requests to Infoblox, 10 seconds by
+	// default.
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+}
+
+// ReleaseHook is called for each released address, before it is available
+// again. Exactly one of URL and Job must be given.
+// +kubebuilder:validation:XValidation:rule="has(self.url) != has(self.job)",message="exactly one of url and job must be given"
+type ReleaseHook struct {
+
+	// URL receives a POST request with the released address as JSON. Any
+	// 2xx status is a success.
+	// +optional
+	URL string `json:"url,omitempty"`
+
+	// Timeout is the timeout of the requests to the URL, 10 seconds by
+	// default.
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:validation:Type=object
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// Job is the template of a Job run in the namespace of the pool for each
+	// released address. The released address is given to its containers
+	// through the IPAM_* environment variables.
+	// +optional
+	Job *batchv1.JobTemplateSpec `json:"job,omitempty"`
+}
+
+// IPPoolStatus defines the observed state of IPPool.
+type IPPoolStatus struct {
+	// LastUpdated identifies when this status was last observed.
+	// +optional
+	LastUpdated *metav1.Time `json:"lastUpdated,omitempty"`
+
+	// Allocations contains the map of objects and IP addresses they have
+	// +optional
+	Allocations map[string]IPAddressStr `json:"allocations,omitempty"`
+
+	// AllocatedRanges contains the allocated addresses, as ranges of
+	// contiguous addresses, if the pool compacts its allocations. The
+	// allocations are then empty.
+	// +optional
+	AllocatedRanges []AddressRange `json:"allocatedRanges,omitempty"`
+
+	// AllocationShards is the number of IPPoolAllocationShard objects the
+	// allocations are recorded in, if the pool is above the sharding
+	// threshold. The allocations and allocated ranges are then empty.
+	// +optional
+	AllocationShards int `json:"allocationShards,omitempty"`
+
+	// AffinityGroups contains the map of the affinity groups of the claims and
+	// the index, in the pools list, of the pool their addresses are allocated
+	// from
+	// +optional
+	AffinityGroups map[string]int `json:"affinityGroups,omitempty"`
+
+	// RetainedAddresses contains the addresses of the deleted claims kept for
+	// them, by allocation key, if the pool has sticky allocations
+	// +optional
+	RetainedAddresses map[string]RetainedAddress `json:"retainedAddresses,omitempty"`
+
+	// QuarantinedAddresses contains the released addresses in quarantine,
+	// with their release time, if the pool has a quarantine period
+	// +optional
+	QuarantinedAddresses map[string]metav1.Time `json:"quarantinedAddresses,omitempty"`
+
+	// ReleasedAddresses contains the free addresses with their release time,
+	// if the pool has the LeastRecentlyUsed allocation strategy
+	// +optional
+	ReleasedAddresses map[string]metav1.Time `json:"releasedAddresses,omitempty"`
+
+	// Capacity contains the numbers of addresses of the pool, or of prefixes
+	// if the IPPool delegates prefixes.
+	// +optional
+	Capacity *PoolCapacity `json:"capacity,omitempty"`
+
+	// Conditions defines the current service state of the IPPool.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// AddressRange is a range of contiguous addresses
+type AddressRange struct {
+	// Start is the first address of the range
+	Start IPAddressStr `json:"start"`
+
+	// End is the last address of the range. It is not set for a range of a
+	// single address.
+	// +optional
+	End *IPAddressStr `json:"end,omitempty"`
+}
+
+// PoolCapacity contains the numbers of addresses, or prefixes, of a pool
+type PoolCapacity struct {
+	// Total is the number of addresses of the enabled pools.
+	Total int64 `json:"total"`
+
+	// Allocated is the number of allocated addresses.
+	Allocated int64 `json:"allocated"`
+
+	// Available is the number of addresses that can still be allocated.
+	Available int64 `json:"available"`
+
+	// ExactTotal is the number of addresses of the enabled pools, in
+	// decimal, set when it overflows Total, as for the large IPv6 pools.
+	// +optional
+	ExactTotal string `json:"exactTotal,omitempty"`
+
+	// ExactAvailable is the number of addresses that can still be
+	// allocated, in decimal, set when it overflows Available.
+	// +optional
+	ExactAvailable string `json:"exactAvailable,omitempty"`
+}
+
+// RetainedAddress is an address of a deleted claim kept for it
+type RetainedAddress struct {
+	// Address is the retained address
+	Address IPAddressStr `json:"address"`
+
+	// ReleasedAt is when the claim released the address
+	ReleasedAt metav1.Time `json:"releasedAt"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:resource:path=ippools,scope=Namespaced,categories=cluster-api,shortName=ipp;ippool;m3ipp;m3ippool;m3ippools;metal3ipp;metal3ippool;metal3ippools
+// +kubebuilder:subresource:status
+// +kubebuilder:object:root=true
+// +kubebuilder:printcolumn:name="Cluster",type="string",JSONPath=".metadata.labels.cluster\\.x-k8s\\.io/cluster-name",description="Cluster to which this template belongs"
+// +kubebuilder:printcolumn:name="Total",type="integer",JSONPath=".status.capacity.total",description="Number of addresses of the pools"
+// +kubebuilder:printcolumn:name="Allocated",type="integer",JSONPath=".status.capacity.allocated",description="Number of allocated addresses"
+// +kubebuilder:printcolumn:name="Available",type="integer",JSONPath=".status.capacity.available",description="Number of addresses that can still be allocated"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp",description="Time duration since creation of Metal3IPPool"
+// IPPool is the Schema for the ippools API
+type IPPool struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	// +kubebuilder:validation:XValidation:rule="self.namePrefix == oldSelf.namePrefix",message="namePrefix cannot be modified"
+	// +kubebuilder:validation:XValidation:rule="(has(self.dualStack) && self.dualStack) == (has(oldSelf.dualStack) && oldSelf.dualStack)",message="dualStack cannot be modified"
+	// +kubebuilder:validation:XValidation:rule="(has(self.delegatedPrefix) ? self.delegatedPrefix : 0) == (has(oldSelf.delegatedPrefix) ? oldSelf.delegatedPrefix : 0)",message="delegatedPrefix cannot be modified"
+	Spec   IPPoolSpec   `json:"spec,omitempty"`
+	Status IPPoolStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// IPPoolList contains a list of IPPool
+type IPPoolList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []IPPool `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&IPPool{}, &IPPoolList{})
+}
@@ -0,0 +1,209 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// kubectl-metal3ipam is a kubectl plugin rendering the pools, addresses and
+// claims of the IP address manager as tables.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-logr/logr"
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	"github.com/metal3-io/ip-address-manager/ipam"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/duration"
+	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
+	"k8s.io/klog/v2/klogr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const usage = `Inspect the pools of the metal3 IP address manager.
+
+Usage:
+  kubectl metal3ipam pool usage [-n NAMESPACE | -A]
+  kubectl metal3ipam who-has IP
+  kubectl metal3ipam claims --pool NAME [-n NAMESPACE | --cluster-pool]
+
+The flags come before the arguments. --kubeconfig selects the cluster.
+`
+
+var myscheme = runtime.NewScheme()
+
+func init() {
+	_ = ipamv1.AddToScheme(myscheme)
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout))
+}
+
+// run runs the subcommand of the arguments, printing the table to out. It
+// returns the exit code of the plugin.
+func run(args []string, out io.Writer) int {
+	switch {
+	case len(args) >= 2 && args[0] == "pool" && args[1] == "usage":
+		return poolUsage(args[2:], out)
+	case len(args) >= 1 && args[0] == "who-has":
+		return whoHas(args[1:], out)
+	case len(args) >= 1 && args[0] == "claims":
+		return claims(args[1:], out)
+	}
+	fmt.Fprint(os.Stderr, usage)
+	return 1
+}
+
+// poolUsage prints the capacity and the allocated addresses of the IPPools
+// and of the ClusterIPPools
+func poolUsage(args []string, out io.Writer) int {
+	var namespace string
+	var allNamespaces bool
+	fs := flag.NewFlagSet("pool usage", flag.ExitOnError)
+	fs.StringVar(&namespace, "n", "default", "Namespace of the IPPools.")
+	fs.BoolVar(&allNamespaces, "A", false, "List the IPPools of all namespaces.")
+	cl, ctx, log := setup(fs, args)
+	if cl == nil {
+		return 1
+	}
+	if allNamespaces {
+		namespace = ""
+	}
+
+	usages, err := ipam.PoolUsages(ctx, cl, namespace)
+	if err != nil {
+		log.Error(err, "unable to list the pools")
+		return 1
+	}
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "POOL\tKIND\tTOTAL\tALLOCATED\tAVAILABLE\tUSED")
+	for _, usage := range usages {
+		if usage.Error != "" {
+			fmt.Fprintf(w, "%s\t%s\t-\t%d\t-\t%s\n", usage.Pool, usage.Kind,
+				usage.Allocated, usage.Error)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%.1f%%\n", usage.Pool, usage.Kind,
+			usage.Total, usage.Allocated, usage.Available, usage.UsedPercent())
+	}
+	_ = w.Flush()
+	return 0
+}
+
+// whoHas prints the IPAddresses holding the address of the argument
+func whoHas(args []string, out io.Writer) int {
+	fs := flag.NewFlagSet("who-has", flag.ExitOnError)
+	cl, ctx, log := setup(fs, args)
+	if cl == nil {
+		return 1
+	}
+	if fs.NArg() != 1 {
+		log.Error(nil, "the address is required")
+		return 1
+	}
+
+	holders, err := ipam.WhoHas(ctx, cl, fs.Arg(0))
+	if err != nil {
+		log.Error(err, "unable to list the addresses")
+		return 1
+	}
+	if len(holders) == 0 {
+		fmt.Fprintf(os.Stderr, "%s is not allocated\n", fs.Arg(0))
+		return 1
+	}
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ADDRESS\tIPADDRESS\tPOOL\tCLAIM\tROLE")
+	for _, holder := range holders {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", holder.Address, holder.IPAddress,
+			holder.Pool, holder.Claim, orNone(holder.Role))
+	}
+	_ = w.Flush()
+	return 0
+}
+
+// claims prints the IPClaims of a pool with their addresses
+func claims(args []string, out io.Writer) int {
+	var namespace, pool string
+	var clusterPool bool
+	fs := flag.NewFlagSet("claims", flag.ExitOnError)
+	fs.StringVar(&namespace, "n", "default", "Namespace of the IPPool.")
+	fs.StringVar(&pool, "pool", "", "Name of the pool.")
+	fs.BoolVar(&clusterPool, "cluster-pool", false, "The pool is a ClusterIPPool.")
+	cl, ctx, log := setup(fs, args)
+	if cl == nil {
+		return 1
+	}
+	if pool == "" {
+		log.Error(nil, "the pool is required")
+		return 1
+	}
+	if clusterPool {
+		namespace = ""
+	}
+
+	summaries, err := ipam.ListPoolClaims(ctx, cl,
+		client.ObjectKey{Name: pool, Namespace: namespace}, log)
+	if err != nil {
+		log.Error(err, "unable to list the claims")
+		return 1
+	}
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "CLAIM\tSTATE\tADDRESSES\tAGE\tMESSAGE")
+	for _, summary := range summaries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", summary.Claim, summary.State,
+			orNone(strings.Join(summary.Addresses, ",")),
+			duration.HumanDuration(time.Since(summary.Age.Time)), summary.Message)
+	}
+	_ = w.Flush()
+	return 0
+}
+
+// setup parses the flags of the subcommand and creates the client. It returns
+// a nil client if the client could not be created.
+func setup(fs *flag.FlagSet, args []string) (client.Client, context.Context, logr.Logger) {
+	if kubeconfig := flag.Lookup("kubeconfig"); kubeconfig != nil {
+		fs.Var(kubeconfig.Value, kubeconfig.Name, kubeconfig.Usage)
+	}
+	_ = fs.Parse(args)
+
+	log := klogr.New().WithName("kubectl-metal3ipam")
+	config, err := ctrl.GetConfig()
+	if err != nil {
+		log.Error(err, "unable to load the kubeconfig")
+		return nil, nil, log
+	}
+	cl, err := client.New(config, client.Options{Scheme: myscheme})
+	if err != nil {
+		log.Error(err, "unable to create client")
+		return nil, nil, log
+	}
+	return cl, ctrl.SetupSignalHandler(), log
+}
+
+// orNone returns the value, or <none> if it is empty
+func orNone(value string) string {
+	if value == "" {
+		return "<none>"
+	}
+	return value
+}
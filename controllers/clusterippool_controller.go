@@ -0,0 +1,171 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/cluster-api/util/annotations"
+	"sigs.k8s.io/cluster-api/util/patch"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const (
+	clusterIPPoolControllerName = "ClusterIPPool-controller"
+)
+
+// ClusterIPPoolReconciler reconciles a ClusterIPPool object. The pool is
+// managed through its IPPool view, as an IPPool without cluster.
+type ClusterIPPoolReconciler struct {
+	IPPoolReconciler
+}
+
+// +kubebuilder:rbac:groups=ipam.metal3.io,resources=clusterippools,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=ipam.metal3.io,resources=clusterippools/status,verbs=get;update;patch
+
+// Reconcile handles ClusterIPPool events
+func (r *ClusterIPPoolReconciler) Reconcile(ctx context.Context, req ctrl.Request) (_ ctrl.Result, rerr error) {
+	metadataLog := r.Log.WithName(clusterIPPoolControllerName).WithValues("metal3-clusterippool", req.Name)
+
+	// Fetch the ClusterIPPool instance.
+	clusterIPPool := &ipamv1.ClusterIPPool{}
+	if err := r.Client.Get(ctx, req.NamespacedName, clusterIPPool); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	ipamv1IPPool := clusterIPPool.AsIPPool()
+	patchPool, err := newPoolPatcher(ipamv1IPPool, r.Client)
+	if err != nil {
+		return ctrl.Result{}, errors.Wrap(err, "failed to init patch helper")
+	}
+	// Always patch the ClusterIPPool exiting this function so we can persist
+	// any change of its view.
+	defer func() {
+		err := patchPool(ctx)
+		if err != nil {
+			metadataLog.Info("failed to Patch ClusterIPPool")
+		}
+	}()
+
+	if annotations.HasPaused(ipamv1IPPool) {
+		metadataLog.Info("reconciliation is paused for this object")
+		setPausedCondition(ipamv1IPPool, true)
+		return ctrl.Result{Requeue: true, RequeueAfter: r.Settings.JitteredRequeueAfter()}, nil
+	}
+
+	// Create a helper for managing the pool.
+	ipPoolMgr, err := r.ManagerFactory.NewIPPoolManager(ipamv1IPPool, metadataLog)
+	if err != nil {
+		return ctrl.Result{}, errors.Wrapf(err, "failed to create helper for managing the IP pool")
+	}
+
+	setPausedCondition(ipamv1IPPool, false)
+
+	// Handle deleted pools
+	if !ipamv1IPPool.ObjectMeta.DeletionTimestamp.IsZero() {
+		setNotReadyCondition(ipamv1IPPool, "Deleting", "")
+		return r.reconcileDelete(ctx, ipPoolMgr, ipamv1IPPool.Spec.DeletionPolicy)
+	}
+
+	res, err := r.reconcileNormal(ctx, ipPoolMgr)
+	setReadyCondition(ipamv1IPPool, err)
+	return res, err
+}
+
+// SetupWithManager will add watches for this controller
+func (r *ClusterIPPoolReconciler) SetupWithManager(ctx context.Context, mgr ctrl.Manager, options controller.Options) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&ipamv1.ClusterIPPool{}).
+		Watches(
+			&source.Kind{Type: &ipamv1.IPClaim{}},
+			enqueueRequestsFromMapFuncDebounced(r.IPClaimToClusterIPPool, r.ClaimDebounce),
+			builder.WithPredicates(ipClaimDeletedPredicate()),
+		).
+		Watches(
+			&source.Kind{Type: &ipamv1.IPAddress{}},
+			handler.EnqueueRequestsFromMapFunc(r.IPAddressToClusterIPPool),
+		).
+		WithEventFilter(resourceNotPausedAndHasFilterLabel(ctrl.LoggerFrom(ctx), r.WatchFilterValue)).
+		WithOptions(options).
+		Complete(r)
+}
+
+// IPClaimToClusterIPPool will return a reconcile request for a ClusterIPPool
+// if the event is for an IPClaim that references it
+func (r *ClusterIPPoolReconciler) IPClaimToClusterIPPool(obj client.Object) []ctrl.Request {
+	claim, ok := obj.(*ipamv1.IPClaim)
+	if !ok || claim.Spec.Pool.Name == "" || !ipamv1.IsClusterIPPoolRef(claim.Spec.Pool) {
+		return []ctrl.Request{}
+	}
+	return []ctrl.Request{{
+		NamespacedName: types.NamespacedName{Name: claim.Spec.Pool.Name},
+	}}
+}
+
+// IPAddressToClusterIPPool will return a reconcile request for the
+// ClusterIPPool of an IPAddress if the event is for an IPAddress imported into
+// an externally managed ClusterIPPool, to bind its claim
+func (r *ClusterIPPoolReconciler) IPAddressToClusterIPPool(obj client.Object) []ctrl.Request {
+	address, ok := obj.(*ipamv1.IPAddress)
+	if !ok || address.Spec.Claim.Name == "" || !ipamv1.IsClusterIPPoolRef(address.Spec.Pool) {
+		return []ctrl.Request{}
+	}
+	clusterIPPool := &ipamv1.ClusterIPPool{}
+	err := r.Client.Get(context.Background(), client.ObjectKey{Name: address.Spec.Pool.Name}, clusterIPPool)
+	if err != nil || !clusterIPPool.Spec.ExternallyManaged {
+		return []ctrl.Request{}
+	}
+	return []ctrl.Request{{
+		NamespacedName: types.NamespacedName{Name: clusterIPPool.Name},
+	}}
+}
+
+// newPoolPatcher returns a function patching the changes made to an IPPool
+// since the call. The changes made to the view of a ClusterIPPool are patched
+// on the ClusterIPPool.
+func newPoolPatcher(ipPool *ipamv1.IPPool, cl client.Client) (func(context.Context) error, error) {
+	if !ipPool.IsClusterScoped() {
+		helper, err := patch.NewHelper(ipPool, cl)
+		if err != nil {
+			return nil, err
+		}
+		return func(ctx context.Context) error {
+			return helper.Patch(ctx, ipPool)
+		}, nil
+	}
+	clusterIPPool := &ipamv1.ClusterIPPool{Spec: *ipPool.Spec.DeepCopy()}
+	clusterIPPool.SetFromIPPool(ipPool)
+	helper, err := patch.NewHelper(clusterIPPool, cl)
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context) error {
+		clusterIPPool.SetFromIPPool(ipPool)
+		return helper.Patch(ctx, clusterIPPool)
+	}, nil
+}
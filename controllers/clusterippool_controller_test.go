@@ -0,0 +1,208 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/go-logr/logr"
+	"github.com/golang/mock/gomock"
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	"github.com/metal3-io/ip-address-manager/ipam"
+	ipam_mocks "github.com/metal3-io/ip-address-manager/ipam/mocks"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2/klogr"
+	capi "sigs.k8s.io/cluster-api/api/v1alpha4"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var _ = Describe("ClusterIPPool controller", func() {
+
+	type testCaseClusterReconcile struct {
+		clusterIPPool       *ipamv1.ClusterIPPool
+		expectManager       bool
+		managerError        bool
+		reconcileError      bool
+		expectError         bool
+		expectRequeue       bool
+		expectedAllocations map[string]ipamv1.IPAddressStr
+	}
+
+	DescribeTable("Test Reconcile",
+		func(tc testCaseClusterReconcile) {
+			gomockCtrl := gomock.NewController(GinkgoT())
+			f := ipam_mocks.NewMockManagerFactoryInterface(gomockCtrl)
+			m := ipam_mocks.NewMockIPPoolManagerInterface(gomockCtrl)
+
+			objects := []client.Object{}
+			if tc.clusterIPPool != nil {
+				objects = append(objects, tc.clusterIPPool)
+			}
+			c := fake.NewClientBuilder().WithScheme(setupScheme()).WithObjects(objects...).Build()
+
+			// The manager updates the IPPool view of the ClusterIPPool
+			var view *ipamv1.IPPool
+			if tc.managerError {
+				f.EXPECT().NewIPPoolManager(gomock.Any(), gomock.Any()).Return(nil, errors.New(""))
+			} else if tc.expectManager {
+				f.EXPECT().NewIPPoolManager(gomock.Any(), gomock.Any()).DoAndReturn(
+					func(ipPool *ipamv1.IPPool, _ logr.Logger) (ipam.IPPoolManagerInterface, error) {
+						view = ipPool
+						return m, nil
+					},
+				)
+				updateAddresses := func(context.Context) (int, error) {
+					Expect(view.IsClusterScoped()).To(BeTrue())
+					view.Status.Allocations = tc.expectedAllocations
+					if tc.reconcileError {
+						return 0, errors.New("")
+					}
+					return len(view.Status.Allocations), nil
+				}
+				if tc.clusterIPPool.DeletionTimestamp.IsZero() {
+					m.EXPECT().SetFinalizer()
+					m.EXPECT().UpdateAddresses(gomock.Any()).DoAndReturn(updateAddresses)
+				} else {
+					m.EXPECT().UpdateAddresses(gomock.Any()).DoAndReturn(updateAddresses)
+					if !tc.reconcileError {
+						m.EXPECT().UnsetFinalizer()
+					}
+				}
+			}
+
+			r := &ClusterIPPoolReconciler{
+				IPPoolReconciler: IPPoolReconciler{
+					Client:         c,
+					ManagerFactory: f,
+					Log:            klogr.New(),
+				},
+			}
+			req := reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: "abc"},
+			}
+
+			result, err := r.Reconcile(context.Background(), req)
+			if tc.expectError {
+				Expect(err).To(HaveOccurred())
+			} else {
+				Expect(err).NotTo(HaveOccurred())
+			}
+			Expect(result.Requeue).To(Equal(tc.expectRequeue))
+
+			if tc.expectedAllocations != nil {
+				savedPool := &ipamv1.ClusterIPPool{}
+				Expect(c.Get(context.TODO(), client.ObjectKey{Name: "abc"}, savedPool)).To(Succeed())
+				Expect(savedPool.Status.Allocations).To(Equal(tc.expectedAllocations))
+			}
+			gomockCtrl.Finish()
+		},
+		Entry("ClusterIPPool not found", testCaseClusterReconcile{}),
+		Entry("Paused ClusterIPPool", testCaseClusterReconcile{
+			clusterIPPool: &ipamv1.ClusterIPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "abc",
+					Annotations: map[string]string{
+						capi.PausedAnnotation: "true",
+					},
+				},
+			},
+			expectRequeue: true,
+		}),
+		Entry("Error in manager", testCaseClusterReconcile{
+			clusterIPPool: &ipamv1.ClusterIPPool{
+				ObjectMeta: metav1.ObjectMeta{Name: "abc"},
+			},
+			managerError: true,
+			expectError:  true,
+		}),
+		Entry("Reconcile normal, status patched", testCaseClusterReconcile{
+			clusterIPPool: &ipamv1.ClusterIPPool{
+				ObjectMeta: metav1.ObjectMeta{Name: "abc"},
+			},
+			expectManager: true,
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"myns/abc": "192.168.0.10",
+			},
+		}),
+		Entry("Reconcile normal error", testCaseClusterReconcile{
+			clusterIPPool: &ipamv1.ClusterIPPool{
+				ObjectMeta: metav1.ObjectMeta{Name: "abc"},
+			},
+			expectManager:  true,
+			reconcileError: true,
+			expectError:    true,
+		}),
+		Entry("Reconcile delete", testCaseClusterReconcile{
+			clusterIPPool: &ipamv1.ClusterIPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              "abc",
+					DeletionTimestamp: &timestampNow,
+					Finalizers:        []string{ipamv1.IPPoolFinalizer},
+				},
+			},
+			expectManager: true,
+		}),
+	)
+
+	type testCaseClaimToClusterIPPool struct {
+		ipClaim       *ipamv1.IPClaim
+		expectRequest bool
+	}
+
+	DescribeTable("IPClaim To ClusterIPPool tests",
+		func(tc testCaseClaimToClusterIPPool) {
+			r := ClusterIPPoolReconciler{}
+			reqs := r.IPClaimToClusterIPPool(tc.ipClaim)
+			if !tc.expectRequest {
+				Expect(reqs).To(BeEmpty())
+				return
+			}
+			Expect(reqs).To(Equal([]reconcile.Request{{
+				NamespacedName: types.NamespacedName{Name: tc.ipClaim.Spec.Pool.Name},
+			}}))
+		},
+		Entry("No pool in Spec", testCaseClaimToClusterIPPool{
+			ipClaim: &ipamv1.IPClaim{ObjectMeta: testObjectMeta},
+		}),
+		Entry("IPPool in Spec", testCaseClaimToClusterIPPool{
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: testObjectMeta,
+				Spec: ipamv1.IPClaimSpec{
+					Pool: corev1.ObjectReference{Name: "abc"},
+				},
+			},
+		}),
+		Entry("ClusterIPPool in Spec", testCaseClaimToClusterIPPool{
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: testObjectMeta,
+				Spec: ipamv1.IPClaimSpec{
+					Pool: corev1.ObjectReference{Name: "abc", Kind: ipamv1.ClusterIPPoolKind},
+				},
+			},
+			expectRequest: true,
+		}),
+	)
+})
@@ -0,0 +1,106 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	"github.com/metal3-io/ip-address-manager/ipam"
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/cluster-api/util/patch"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+)
+
+const (
+	controllerConfigControllerName = "ControllerConfig-controller"
+)
+
+// ControllerConfigReconciler applies the ControllerConfig to the settings of
+// the running controllers
+type ControllerConfigReconciler struct {
+	Client   client.Client
+	Log      logr.Logger
+	Settings *ipam.Settings
+}
+
+// +kubebuilder:rbac:groups=ipam.metal3.io,resources=controllerconfigs,verbs=get;list;watch
+// +kubebuilder:rbac:groups=ipam.metal3.io,resources=controllerconfigs/status,verbs=get;update;patch
+
+// Reconcile handles ControllerConfig events
+func (r *ControllerConfigReconciler) Reconcile(ctx context.Context, req ctrl.Request) (_ ctrl.Result, rerr error) {
+	configLog := r.Log.WithName(controllerConfigControllerName).WithValues("controllerconfig", req.Name)
+
+	// Only one ControllerConfig is used
+	if req.Name != ipamv1.ControllerConfigName {
+		return ctrl.Result{}, nil
+	}
+
+	// Fetch the ControllerConfig instance, the defaults apply without it.
+	config := &ipamv1.ControllerConfig{}
+	if err := r.Client.Get(ctx, req.NamespacedName, config); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+		r.Settings.Apply(nil)
+		configLog.Info("default settings applied", "settings", r.Settings.String())
+		return ctrl.Result{}, nil
+	}
+
+	helper, err := patch.NewHelper(config, r.Client)
+	if err != nil {
+		return ctrl.Result{}, errors.Wrap(err, "failed to init patch helper")
+	}
+	// Always patch the ControllerConfig exiting this function so that the
+	// applied generation is reported.
+	defer func() {
+		err := helper.Patch(ctx, config)
+		if err != nil {
+			configLog.Info("failed to Patch ControllerConfig")
+			if rerr == nil {
+				rerr = err
+			}
+		}
+	}()
+
+	r.Settings.Apply(config)
+	configLog.Info("settings applied", "settings", r.Settings.String())
+
+	config.Status.ObservedGeneration = config.Generation
+	meta.SetStatusCondition(&config.Status.Conditions, metav1.Condition{
+		Type:               ipamv1.ControllerConfigAppliedCondition,
+		Status:             metav1.ConditionTrue,
+		Reason:             "SettingsApplied",
+		Message:            r.Settings.String(),
+		ObservedGeneration: config.Generation,
+	})
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager will add watches for this controller
+func (r *ControllerConfigReconciler) SetupWithManager(ctx context.Context, mgr ctrl.Manager, options controller.Options) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&ipamv1.ControllerConfig{}).
+		WithOptions(options).
+		Complete(r)
+}
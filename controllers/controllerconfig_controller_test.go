@@ -0,0 +1,110 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	"github.com/metal3-io/ip-address-manager/ipam"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2/klogr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var _ = Describe("ControllerConfig controller", func() {
+
+	type testCaseReconcileConfig struct {
+		configName           string
+		config               *ipamv1.ControllerConfig
+		expectedRequeueAfter time.Duration
+		expectApplied        bool
+	}
+
+	DescribeTable("Test Reconcile",
+		func(tc testCaseReconcileConfig) {
+			objects := []client.Object{}
+			if tc.config != nil {
+				objects = append(objects, tc.config)
+			}
+			c := fake.NewClientBuilder().WithScheme(setupScheme()).WithObjects(objects...).Build()
+
+			settings := ipam.NewSettings(time.Minute, 0, 0)
+			settings.Apply(&ipamv1.ControllerConfig{
+				Spec: ipamv1.ControllerConfigSpec{
+					RequeueInterval: &metav1.Duration{Duration: time.Hour},
+				},
+			})
+			r := &ControllerConfigReconciler{
+				Client:   c,
+				Log:      klogr.New(),
+				Settings: settings,
+			}
+
+			result, err := r.Reconcile(context.TODO(), reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: tc.configName},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Requeue).To(BeFalse())
+			Expect(settings.RequeueAfter()).To(Equal(tc.expectedRequeueAfter))
+
+			if tc.expectApplied {
+				config := &ipamv1.ControllerConfig{}
+				Expect(c.Get(context.TODO(), client.ObjectKey{Name: tc.configName}, config)).To(Succeed())
+				Expect(config.Status.ObservedGeneration).To(Equal(tc.config.Generation))
+				condition := meta.FindStatusCondition(config.Status.Conditions,
+					ipamv1.ControllerConfigAppliedCondition,
+				)
+				Expect(condition).NotTo(BeNil())
+				Expect(condition.Status).To(Equal(metav1.ConditionTrue))
+				Expect(condition.ObservedGeneration).To(Equal(tc.config.Generation))
+				Expect(condition.Message).To(Equal(settings.String()))
+			}
+		},
+		Entry("Other ControllerConfig", testCaseReconcileConfig{
+			configName:           "abc",
+			expectedRequeueAfter: time.Hour,
+		}),
+		Entry("ControllerConfig not found", testCaseReconcileConfig{
+			configName:           ipamv1.ControllerConfigName,
+			expectedRequeueAfter: time.Minute,
+		}),
+		Entry("ControllerConfig", testCaseReconcileConfig{
+			configName: ipamv1.ControllerConfigName,
+			config: &ipamv1.ControllerConfig{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:       ipamv1.ControllerConfigName,
+					Generation: 3,
+				},
+				Spec: ipamv1.ControllerConfigSpec{
+					RequeueInterval: &metav1.Duration{Duration: 5 * time.Second},
+				},
+			},
+			expectedRequeueAfter: 5 * time.Second,
+			expectApplied:        true,
+		}),
+	)
+})
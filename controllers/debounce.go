@@ -0,0 +1,62 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+)
+
+// enqueueRequestsFromMapFuncDebounced is handler.EnqueueRequestsFromMapFunc,
+// except that the requests are added to the queue once the debounce window
+// is over. The queue keeps a single delayed request per object, at the
+// earliest time it was added for, so that a burst of events mapped to the
+// same object within the window ends up in a single reconcile. A zero window
+// enqueues the requests immediately.
+func enqueueRequestsFromMapFuncDebounced(fn handler.MapFunc, window time.Duration) handler.EventHandler {
+	if window <= 0 {
+		return handler.EnqueueRequestsFromMapFunc(fn)
+	}
+	enqueue := func(q workqueue.RateLimitingInterface, objects ...client.Object) {
+		for _, obj := range objects {
+			if obj == nil {
+				continue
+			}
+			for _, req := range fn(obj) {
+				q.AddAfter(req, window)
+			}
+		}
+	}
+	return handler.Funcs{
+		CreateFunc: func(e event.CreateEvent, q workqueue.RateLimitingInterface) {
+			enqueue(q, e.Object)
+		},
+		UpdateFunc: func(e event.UpdateEvent, q workqueue.RateLimitingInterface) {
+			enqueue(q, e.ObjectOld, e.ObjectNew)
+		},
+		DeleteFunc: func(e event.DeleteEvent, q workqueue.RateLimitingInterface) {
+			enqueue(q, e.Object)
+		},
+		GenericFunc: func(e event.GenericEvent, q workqueue.RateLimitingInterface) {
+			enqueue(q, e.Object)
+		},
+	}
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+var _ = Describe("Debounced event handler", func() {
+
+	DescribeTable("Test enqueueRequestsFromMapFuncDebounced",
+		func(window time.Duration, expectImmediate bool) {
+			ipPool := &ipamv1.IPPool{
+				ObjectMeta: metav1.ObjectMeta{Name: "pool1", Namespace: "myns"},
+			}
+			c := fake.NewClientBuilder().WithScheme(setupScheme()).WithObjects(ipPool).Build()
+			r := IPPoolReconciler{Client: c}
+			h := enqueueRequestsFromMapFuncDebounced(r.IPClaimToIPPool, window)
+			q := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+			defer q.ShutDown()
+
+			for _, name := range []string{"abc", "bcd", "cde"} {
+				h.Delete(event.DeleteEvent{Object: &ipamv1.IPClaim{
+					ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "myns"},
+					Spec: ipamv1.IPClaimSpec{
+						Pool: corev1.ObjectReference{Name: "pool1"},
+					},
+				}}, q)
+			}
+
+			if expectImmediate {
+				Expect(q.Len()).To(Equal(1))
+			} else {
+				Expect(q.Len()).To(Equal(0))
+				Eventually(q.Len).Should(Equal(1))
+			}
+			item, _ := q.Get()
+			Expect(item).To(Equal(ctrl.Request{NamespacedName: types.NamespacedName{
+				Name: "pool1", Namespace: "myns",
+			}}))
+			q.Done(item)
+			Consistently(q.Len, 2*window+50*time.Millisecond).Should(Equal(0))
+		},
+		Entry("Debounced", 100*time.Millisecond, false),
+		Entry("Not debounced", time.Duration(0), true),
+	)
+})
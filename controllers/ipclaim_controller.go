@@ -0,0 +1,195 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	"github.com/metal3-io/ip-address-manager/ipam"
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/cluster-api/util/annotations"
+	"sigs.k8s.io/cluster-api/util/patch"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+const (
+	ipClaimControllerName = "IPClaim-controller"
+	noPoolSelectedMessage = "No IPPool matches the pool selector"
+)
+
+// IPClaimReconciler allocates the addresses of a single IPClaim per
+// reconciliation, and releases them when it is deleted. The IPPool state is
+// not recomputed for each claim, so that busy pools are not reconciled for
+// every event of their claims.
+type IPClaimReconciler struct {
+	Client           client.Client
+	ManagerFactory   ipam.ManagerFactoryInterface
+	Log              logr.Logger
+	WatchFilterValue string
+	// Settings are the operator-wide settings, the defaults are used if nil
+	Settings *ipam.Settings
+}
+
+// Reconcile handles IPClaim events
+func (r *IPClaimReconciler) Reconcile(ctx context.Context, req ctrl.Request) (_ ctrl.Result, rerr error) {
+	claimLog := r.Log.WithName(ipClaimControllerName).WithValues("metal3-ipclaim", req.NamespacedName)
+
+	// Fetch the IPClaim instance.
+	ipamv1IPClaim := &ipamv1.IPClaim{}
+
+	if err := r.Client.Get(ctx, req.NamespacedName, ipamv1IPClaim); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	// Nothing to release if the claim was already released
+	if !ipamv1IPClaim.DeletionTimestamp.IsZero() &&
+		!ipam.Contains(ipamv1IPClaim.Finalizers, ipamv1.IPClaimFinalizer) {
+		return ctrl.Result{}, nil
+	}
+
+	// Bind the claim to the pool matching its pool selector
+	if ipamv1IPClaim.Spec.Pool.Name == "" && ipamv1IPClaim.Spec.PoolSelector != nil {
+		selected, err := r.selectPool(ctx, ipamv1IPClaim, claimLog)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if !selected {
+			return ctrl.Result{Requeue: true, RequeueAfter: r.Settings.JitteredRequeueAfter()}, nil
+		}
+	}
+
+	// Fetch the IPPool instance, the pool might have been renamed. A
+	// ClusterIPPool is managed through its IPPool view.
+	ipamv1IPPool, err := ipamv1.GetClaimPool(ctx, r.Client, ipamv1IPClaim)
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+		// The claim is served once its pool is created
+		if ipamv1IPClaim.DeletionTimestamp.IsZero() {
+			claimLog.Info("IPPool not found, waiting for its creation")
+			return ctrl.Result{}, nil
+		}
+		// The pool is gone, the IPAddress is garbage collected through its
+		// owner references, only the finalizer needs to be removed.
+		claimLog.Info("IPPool not found, removing the finalizer")
+		helper, err := patch.NewHelper(ipamv1IPClaim, r.Client)
+		if err != nil {
+			return ctrl.Result{}, errors.Wrap(err, "failed to init patch helper")
+		}
+		ipamv1IPClaim.Finalizers = ipam.Filter(ipamv1IPClaim.Finalizers,
+			ipamv1.IPClaimFinalizer,
+		)
+		return ctrl.Result{}, helper.Patch(ctx, ipamv1IPClaim)
+	}
+
+	if annotations.HasPaused(ipamv1IPPool) {
+		claimLog.Info("reconciliation is paused for the IPPool")
+		return ctrl.Result{Requeue: true, RequeueAfter: r.Settings.JitteredRequeueAfter()}, nil
+	}
+
+	patchPool, err := newPoolPatcher(ipamv1IPPool, r.Client)
+	if err != nil {
+		return ctrl.Result{}, errors.Wrap(err, "failed to init patch helper")
+	}
+	// Always patch ipamv1IPPool exiting this function so we can persist any IPPool changes.
+	defer func() {
+		err := patchPool(ctx)
+		if err != nil {
+			claimLog.Info("failed to Patch ipamv1IPPool")
+		}
+	}()
+
+	ipPoolMgr, err := r.ManagerFactory.NewIPPoolManager(ipamv1IPPool, claimLog)
+	if err != nil {
+		return ctrl.Result{}, errors.Wrapf(err, "failed to create helper for managing the IP pool")
+	}
+
+	if err := ipPoolMgr.UpdateClaim(ctx, ipamv1IPClaim); err != nil {
+		return checkRequeueError(err, "Failed to update the addresses of the claim")
+	}
+	return ctrl.Result{}, nil
+}
+
+// selectPool records the pool matching the pool selector of the claim in its
+// pool reference. It returns false if no pool matches, the claim then gets an
+// error message until a matching pool is created.
+func (r *IPClaimReconciler) selectPool(ctx context.Context, ipamv1IPClaim *ipamv1.IPClaim,
+	claimLog logr.Logger,
+) (bool, error) {
+	ipamv1IPPool, err := ipamv1.SelectClaimPool(ctx, r.Client, ipamv1IPClaim)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to select the IPPool")
+	}
+	helper, err := patch.NewHelper(ipamv1IPClaim, r.Client)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to init patch helper")
+	}
+	if ipamv1IPPool == nil {
+		claimLog.Info("No IPPool matches the pool selector")
+		ipamv1IPClaim.Status.ErrorMessage = pointer.StringPtr(noPoolSelectedMessage)
+		return false, helper.Patch(ctx, ipamv1IPClaim)
+	}
+	claimLog.Info("IPPool selected", "IPPool", ipamv1IPPool.Name)
+	ipamv1IPClaim.Spec.Pool.Name = ipamv1IPPool.Name
+	if !ipamv1IPPool.IsClusterScoped() {
+		ipamv1IPClaim.Spec.Pool.Namespace = ipamv1IPPool.Namespace
+	}
+	ipamv1IPClaim.Status.ErrorMessage = nil
+	return true, helper.Patch(ctx, ipamv1IPClaim)
+}
+
+// SetupWithManager will add watches for this controller
+func (r *IPClaimReconciler) SetupWithManager(ctx context.Context, mgr ctrl.Manager, options controller.Options) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&ipamv1.IPClaim{}).
+		WithEventFilter(resourceNotPausedAndHasFilterLabel(ctrl.LoggerFrom(ctx), r.WatchFilterValue)).
+		WithOptions(options).
+		Complete(r)
+}
+
+// ipClaimDeletedPredicate only lets through the events of the IPClaims that
+// are gone. The pools watch them to serve their pending claims with the
+// released addresses, the other events of the claims are handled by the
+// IPClaimReconciler.
+func ipClaimDeletedPredicate() predicate.Funcs {
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			return false
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return false
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return true
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			return false
+		},
+	}
+}
@@ -0,0 +1,294 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/golang/mock/gomock"
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	"github.com/metal3-io/ip-address-manager/ipam"
+	ipam_mocks "github.com/metal3-io/ip-address-manager/ipam/mocks"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2/klogr"
+	"k8s.io/utils/pointer"
+	capi "sigs.k8s.io/cluster-api/api/v1alpha4"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var _ = Describe("IPClaim controller", func() {
+
+	type testCaseReconcileClaim struct {
+		ipClaim              *ipamv1.IPClaim
+		ipPool               *ipamv1.IPPool
+		expectManager        bool
+		updateError          error
+		expectError          bool
+		expectRequeue        bool
+		expectFinalizerUnset bool
+		expectedPool         string
+		expectedErrorMessage *string
+	}
+
+	deletingClaim := func() *ipamv1.IPClaim {
+		return &ipamv1.IPClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              "abc",
+				Namespace:         "myns",
+				DeletionTimestamp: &timestampNow,
+				Finalizers:        []string{ipamv1.IPClaimFinalizer},
+			},
+			Spec: ipamv1.IPClaimSpec{
+				Pool: corev1.ObjectReference{
+					Name: "abc",
+				},
+			},
+		}
+	}
+
+	DescribeTable("Test Reconcile",
+		func(tc testCaseReconcileClaim) {
+			gomockCtrl := gomock.NewController(GinkgoT())
+			f := ipam_mocks.NewMockManagerFactoryInterface(gomockCtrl)
+			m := ipam_mocks.NewMockIPPoolManagerInterface(gomockCtrl)
+
+			objects := []client.Object{}
+			if tc.ipClaim != nil {
+				objects = append(objects, tc.ipClaim)
+			}
+			if tc.ipPool != nil {
+				objects = append(objects, tc.ipPool)
+			}
+			c := fake.NewClientBuilder().WithScheme(setupScheme()).WithObjects(objects...).Build()
+
+			if tc.expectManager {
+				f.EXPECT().NewIPPoolManager(gomock.Any(), gomock.Any()).Return(m, nil)
+				m.EXPECT().UpdateClaim(gomock.Any(), gomock.Any()).Return(tc.updateError)
+			}
+
+			r := &IPClaimReconciler{
+				Client:         c,
+				ManagerFactory: f,
+				Log:            klogr.New(),
+			}
+
+			req := reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Name:      "abc",
+					Namespace: "myns",
+				},
+			}
+
+			result, err := r.Reconcile(context.TODO(), req)
+			gomockCtrl.Finish()
+
+			if tc.expectError {
+				Expect(err).To(HaveOccurred())
+			} else {
+				Expect(err).NotTo(HaveOccurred())
+			}
+			Expect(result.Requeue).To(Equal(tc.expectRequeue))
+
+			if tc.expectedPool != "" || tc.expectedErrorMessage != nil {
+				claim := &ipamv1.IPClaim{}
+				Expect(c.Get(context.TODO(), req.NamespacedName, claim)).To(Succeed())
+				Expect(claim.Spec.Pool.Name).To(Equal(tc.expectedPool))
+				Expect(claim.Status.ErrorMessage).To(Equal(tc.expectedErrorMessage))
+			}
+
+			if tc.expectFinalizerUnset {
+				claim := &ipamv1.IPClaim{}
+				err = c.Get(context.TODO(), req.NamespacedName, claim)
+				if err == nil {
+					Expect(claim.Finalizers).NotTo(ContainElement(ipamv1.IPClaimFinalizer))
+				} else {
+					Expect(apierrors.IsNotFound(err)).To(BeTrue())
+				}
+			}
+		},
+		Entry("IPClaim not found", testCaseReconcileClaim{}),
+		Entry("IPClaim not deleted, IPPool not found", testCaseReconcileClaim{
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: testObjectMeta,
+				Spec: ipamv1.IPClaimSpec{
+					Pool: corev1.ObjectReference{
+						Name: "abc",
+					},
+				},
+			},
+		}),
+		Entry("IPClaim already released", testCaseReconcileClaim{
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              "abc",
+					Namespace:         "myns",
+					DeletionTimestamp: &timestampNow,
+					Finalizers:        []string{"foo"},
+				},
+				Spec: ipamv1.IPClaimSpec{
+					Pool: corev1.ObjectReference{
+						Name: "abc",
+					},
+				},
+			},
+			ipPool: &ipamv1.IPPool{
+				ObjectMeta: testObjectMeta,
+			},
+		}),
+		Entry("Allocation", testCaseReconcileClaim{
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: testObjectMeta,
+				Spec: ipamv1.IPClaimSpec{
+					Pool: corev1.ObjectReference{
+						Name: "abc",
+					},
+				},
+			},
+			ipPool: &ipamv1.IPPool{
+				ObjectMeta: testObjectMeta,
+			},
+			expectManager: true,
+		}),
+		Entry("Allocation error", testCaseReconcileClaim{
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: testObjectMeta,
+				Spec: ipamv1.IPClaimSpec{
+					Pool: corev1.ObjectReference{
+						Name: "abc",
+					},
+				},
+			},
+			ipPool: &ipamv1.IPPool{
+				ObjectMeta: testObjectMeta,
+			},
+			expectManager: true,
+			updateError:   errors.New("Exhausted IP Pools"),
+			expectError:   true,
+		}),
+		Entry("Lease requeue", testCaseReconcileClaim{
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: testObjectMeta,
+				Spec: ipamv1.IPClaimSpec{
+					Pool: corev1.ObjectReference{
+						Name: "abc",
+					},
+				},
+			},
+			ipPool: &ipamv1.IPPool{
+				ObjectMeta: testObjectMeta,
+			},
+			expectManager: true,
+			updateError:   &ipam.RequeueAfterError{RequeueAfter: time.Minute},
+			expectRequeue: true,
+		}),
+		Entry("IPPool selected", testCaseReconcileClaim{
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: testObjectMeta,
+				Spec: ipamv1.IPClaimSpec{
+					PoolSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"role": "provisioning"},
+					},
+				},
+			},
+			ipPool: &ipamv1.IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "abc",
+					Namespace: "myns",
+					Labels:    map[string]string{"role": "provisioning"},
+				},
+			},
+			expectManager: true,
+			expectedPool:  "abc",
+		}),
+		Entry("No IPPool selected", testCaseReconcileClaim{
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: testObjectMeta,
+				Spec: ipamv1.IPClaimSpec{
+					PoolSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"role": "bmc"},
+					},
+				},
+			},
+			ipPool: &ipamv1.IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "abc",
+					Namespace: "myns",
+					Labels:    map[string]string{"role": "provisioning"},
+				},
+			},
+			expectRequeue:        true,
+			expectedErrorMessage: pointer.StringPtr(noPoolSelectedMessage),
+		}),
+		Entry("IPPool not found", testCaseReconcileClaim{
+			ipClaim:              deletingClaim(),
+			expectFinalizerUnset: true,
+		}),
+		Entry("IPPool paused", testCaseReconcileClaim{
+			ipClaim: deletingClaim(),
+			ipPool: &ipamv1.IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "abc",
+					Namespace: "myns",
+					Annotations: map[string]string{
+						capi.PausedAnnotation: "",
+					},
+				},
+			},
+			expectRequeue: true,
+		}),
+		Entry("Release error", testCaseReconcileClaim{
+			ipClaim: deletingClaim(),
+			ipPool: &ipamv1.IPPool{
+				ObjectMeta: testObjectMeta,
+			},
+			expectManager: true,
+			updateError:   errors.New(""),
+			expectError:   true,
+		}),
+		Entry("Release", testCaseReconcileClaim{
+			ipClaim: deletingClaim(),
+			ipPool: &ipamv1.IPPool{
+				ObjectMeta: testObjectMeta,
+			},
+			expectManager: true,
+		}),
+	)
+
+	DescribeTable("Test deleted predicate",
+		func(claim *ipamv1.IPClaim) {
+			p := ipClaimDeletedPredicate()
+			Expect(p.Create(event.CreateEvent{Object: claim})).To(BeFalse())
+			Expect(p.Update(event.UpdateEvent{ObjectOld: claim, ObjectNew: claim})).To(BeFalse())
+			Expect(p.Generic(event.GenericEvent{Object: claim})).To(BeFalse())
+			Expect(p.Delete(event.DeleteEvent{Object: claim})).To(BeTrue())
+		},
+		Entry("Not deleted", &ipamv1.IPClaim{ObjectMeta: testObjectMeta}),
+		Entry("Deleted", deletingClaim()),
+	)
+})
@@ -0,0 +1,106 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	"github.com/metal3-io/ip-address-manager/ipam"
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/cluster-api/util/annotations"
+	"sigs.k8s.io/cluster-api/util/patch"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+)
+
+const (
+	ipClaimSetControllerName = "IPClaimSet-controller"
+)
+
+// IPClaimSetReconciler reconciles a IPClaimSet object
+type IPClaimSetReconciler struct {
+	Client           client.Client
+	ManagerFactory   ipam.ManagerFactoryInterface
+	Log              logr.Logger
+	WatchFilterValue string
+	// Settings are the operator-wide settings, the defaults are used if nil
+	Settings *ipam.Settings
+}
+
+// +kubebuilder:rbac:groups=ipam.metal3.io,resources=ipclaimsets,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=ipam.metal3.io,resources=ipclaimsets/status,verbs=get;update;patch
+
+// Reconcile handles IPClaimSet events
+func (r *IPClaimSetReconciler) Reconcile(ctx context.Context, req ctrl.Request) (_ ctrl.Result, rerr error) {
+	claimSetLog := r.Log.WithName(ipClaimSetControllerName).WithValues("metal3-ipclaimset", req.NamespacedName)
+
+	// Fetch the IPClaimSet instance.
+	ipamv1IPClaimSet := &ipamv1.IPClaimSet{}
+
+	if err := r.Client.Get(ctx, req.NamespacedName, ipamv1IPClaimSet); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	// The claims are garbage collected through their owner references
+	if !ipamv1IPClaimSet.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	if annotations.HasPaused(ipamv1IPClaimSet) {
+		claimSetLog.Info("reconciliation is paused for this object")
+		return ctrl.Result{Requeue: true, RequeueAfter: r.Settings.JitteredRequeueAfter()}, nil
+	}
+
+	helper, err := patch.NewHelper(ipamv1IPClaimSet, r.Client)
+	if err != nil {
+		return ctrl.Result{}, errors.Wrap(err, "failed to init patch helper")
+	}
+	// Always patch ipamv1IPClaimSet exiting this function so we can persist any IPClaimSet changes.
+	defer func() {
+		err := helper.Patch(ctx, ipamv1IPClaimSet)
+		if err != nil {
+			claimSetLog.Info("failed to Patch ipamv1IPClaimSet")
+		}
+	}()
+
+	ipClaimSetMgr, err := r.ManagerFactory.NewIPClaimSetManager(ipamv1IPClaimSet, claimSetLog)
+	if err != nil {
+		return ctrl.Result{}, errors.Wrapf(err, "failed to create helper for managing the IP claim set")
+	}
+
+	if err := ipClaimSetMgr.UpdateClaims(ctx); err != nil {
+		return checkRequeueError(err, "Failed to update the claims")
+	}
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager will add watches for this controller
+func (r *IPClaimSetReconciler) SetupWithManager(ctx context.Context, mgr ctrl.Manager, options controller.Options) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&ipamv1.IPClaimSet{}).
+		Owns(&ipamv1.IPClaim{}).
+		WithEventFilter(resourceNotPausedAndHasFilterLabel(ctrl.LoggerFrom(ctx), r.WatchFilterValue)).
+		WithOptions(options).
+		Complete(r)
+}
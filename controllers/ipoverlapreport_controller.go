@@ -0,0 +1,120 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	"github.com/metal3-io/ip-address-manager/ipam"
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/cluster-api/util/patch"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+const (
+	ipOverlapReportControllerName = "IPOverlapReport-controller"
+
+	// defaultOverlapReportInterval is the interval between two generations
+	// of a report that does not set it
+	defaultOverlapReportInterval = time.Hour
+)
+
+// IPOverlapReportReconciler periodically generates the IPOverlapReports from
+// the IPPools of all namespaces and the ClusterIPPools
+type IPOverlapReportReconciler struct {
+	Client client.Client
+	Log    logr.Logger
+}
+
+// +kubebuilder:rbac:groups=ipam.metal3.io,resources=ipoverlapreports,verbs=get;list;watch
+// +kubebuilder:rbac:groups=ipam.metal3.io,resources=ipoverlapreports/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=ipam.metal3.io,resources=ippools,verbs=get;list;watch
+// +kubebuilder:rbac:groups=ipam.metal3.io,resources=clusterippools,verbs=get;list;watch
+
+// Reconcile handles IPOverlapReport events
+func (r *IPOverlapReportReconciler) Reconcile(ctx context.Context, req ctrl.Request) (_ ctrl.Result, rerr error) {
+	reportLog := r.Log.WithName(ipOverlapReportControllerName).WithValues("ipoverlapreport", req.Name)
+
+	// Fetch the IPOverlapReport instance.
+	report := &ipamv1.IPOverlapReport{}
+	if err := r.Client.Get(ctx, req.NamespacedName, report); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	helper, err := patch.NewHelper(report, r.Client)
+	if err != nil {
+		return ctrl.Result{}, errors.Wrap(err, "failed to init patch helper")
+	}
+	// Always patch the IPOverlapReport exiting this function so that the
+	// report is persisted.
+	defer func() {
+		err := helper.Patch(ctx, report)
+		if err != nil {
+			reportLog.Info("failed to Patch IPOverlapReport")
+			if rerr == nil {
+				rerr = err
+			}
+		}
+	}()
+
+	ipPools := ipamv1.IPPoolList{}
+	if err := r.Client.List(ctx, &ipPools); err != nil {
+		return ctrl.Result{}, errors.Wrap(err, "failed to list the IPPools")
+	}
+	clusterIPPools := ipamv1.ClusterIPPoolList{}
+	if err := r.Client.List(ctx, &clusterIPPools); err != nil {
+		return ctrl.Result{}, errors.Wrap(err, "failed to list the ClusterIPPools")
+	}
+	for i := range clusterIPPools.Items {
+		ipPools.Items = append(ipPools.Items, *clusterIPPools.Items[i].AsIPPool())
+	}
+	now := metav1.Now()
+	report.Status = ipam.BuildOverlapReport(ipPools.Items, reportLog)
+	report.Status.LastUpdated = &now
+	reportLog.Info("overlap report generated", "pools", report.Status.Pools,
+		"overlaps", len(report.Status.Overlaps), "critical", report.Status.Critical,
+		"warning", report.Status.Warning,
+	)
+
+	interval := defaultOverlapReportInterval
+	if report.Spec.Interval != nil {
+		interval = report.Spec.Interval.Duration
+	}
+	return ctrl.Result{RequeueAfter: interval}, nil
+}
+
+// SetupWithManager will add watches for this controller. The reports are
+// generated again when their spec changes and after their interval, not on
+// their status updates.
+func (r *IPOverlapReportReconciler) SetupWithManager(ctx context.Context, mgr ctrl.Manager, options controller.Options) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&ipamv1.IPOverlapReport{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		WithOptions(options).
+		Complete(r)
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2/klogr"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var _ = Describe("IPOverlapReport controller", func() {
+
+	type testCaseReconcileReport struct {
+		report               *ipamv1.IPOverlapReport
+		expectedRequeueAfter time.Duration
+		expectReport         bool
+	}
+
+	newPool := func(namespace string) *ipamv1.IPPool {
+		return &ipamv1.IPPool{
+			ObjectMeta: metav1.ObjectMeta{Name: "abc", Namespace: namespace},
+			Spec: ipamv1.IPPoolSpec{
+				Pools: []ipamv1.Pool{
+					{
+						Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.1")),
+						End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+					},
+				},
+			},
+		}
+	}
+
+	DescribeTable("Test Reconcile",
+		func(tc testCaseReconcileReport) {
+			objects := []client.Object{newPool("myns"), newPool("otherns")}
+			if tc.report != nil {
+				objects = append(objects, tc.report)
+			}
+			c := fake.NewClientBuilder().WithScheme(setupScheme()).WithObjects(objects...).Build()
+
+			r := &IPOverlapReportReconciler{
+				Client: c,
+				Log:    klogr.New(),
+			}
+
+			result, err := r.Reconcile(context.TODO(), reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: "abc"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(Equal(tc.expectedRequeueAfter))
+
+			if tc.expectReport {
+				report := &ipamv1.IPOverlapReport{}
+				Expect(c.Get(context.TODO(), client.ObjectKey{Name: "abc"}, report)).To(Succeed())
+				Expect(report.Status.LastUpdated).NotTo(BeNil())
+				Expect(report.Status.Pools).To(Equal(2))
+				Expect(report.Status.Overlaps).To(HaveLen(1))
+				Expect(report.Status.Overlaps[0].Ranges).To(Equal([]ipamv1.OverlappingRange{
+					{Namespace: "myns", Name: "abc"},
+					{Namespace: "otherns", Name: "abc"},
+				}))
+			}
+		},
+		Entry("IPOverlapReport not found", testCaseReconcileReport{}),
+		Entry("IPOverlapReport with the default interval", testCaseReconcileReport{
+			report: &ipamv1.IPOverlapReport{
+				ObjectMeta: metav1.ObjectMeta{Name: "abc"},
+			},
+			expectedRequeueAfter: time.Hour,
+			expectReport:         true,
+		}),
+		Entry("IPOverlapReport with an interval", testCaseReconcileReport{
+			report: &ipamv1.IPOverlapReport{
+				ObjectMeta: metav1.ObjectMeta{Name: "abc"},
+				Spec: ipamv1.IPOverlapReportSpec{
+					Interval: &metav1.Duration{Duration: 10 * time.Minute},
+				},
+			},
+			expectedRequeueAfter: 10 * time.Minute,
+			expectReport:         true,
+		}),
+	)
+})
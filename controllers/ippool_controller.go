@@ -25,20 +25,22 @@ import (
 	"github.com/metal3-io/ip-address-manager/ipam"
 	"github.com/pkg/errors"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	capi "sigs.k8s.io/cluster-api/api/v1alpha4"
 	"sigs.k8s.io/cluster-api/util/annotations"
 	"sigs.k8s.io/cluster-api/util/patch"
-	"sigs.k8s.io/cluster-api/util/predicates"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 const (
 	ipPoolControllerName = "IPPool-controller"
-	requeueAfter         = time.Second * 30
 )
 
 // IPPoolReconciler reconciles a IPPool object
@@ -47,6 +49,12 @@ type IPPoolReconciler struct {
 	ManagerFactory   ipam.ManagerFactoryInterface
 	Log              logr.Logger
 	WatchFilterValue string
+	// Settings are the operator-wide settings, the defaults are used if nil
+	Settings *ipam.Settings
+	// ClaimDebounce is the window during which the events of the IPClaims
+	// of a pool are aggregated into a single reconcile of the pool. Zero
+	// reconciles the pool for each event.
+	ClaimDebounce time.Duration
 }
 
 // +kubebuilder:rbac:groups=ipam.metal3.io,resources=ippools,verbs=get;list;watch;create;update;patch;delete
@@ -55,10 +63,13 @@ type IPPoolReconciler struct {
 // +kubebuilder:rbac:groups=ipam.metal3.io,resources=ipclaims/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=ipam.metal3.io,resources=ipaddresses,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=ipam.metal3.io,resources=ipaddresses/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=ipam.metal3.io,resources=ippoolallocationshards,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=ipam.metal3.io,resources=ipamconfigs,verbs=get;list;watch
 // +kubebuilder:rbac:groups=cluster.x-k8s.io,resources=clusters,verbs=get;list;watch
 // +kubebuilder:rbac:groups=cluster.x-k8s.io,resources=clusters/status,verbs=get
 // +kubebuilder:rbac:groups="",resources=events,verbs=get;list;watch;create;update;patch
-// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;delete
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get
 
 // Reconcile handles Metal3Machine events
 func (r *IPPoolReconciler) Reconcile(ctx context.Context, req ctrl.Request) (_ ctrl.Result, rerr error) {
@@ -104,6 +115,7 @@ func (r *IPPoolReconciler) Reconcile(ctx context.Context, req ctrl.Request) (_ c
 		if ipamv1IPPool.ObjectMeta.DeletionTimestamp.IsZero() {
 			if err != nil {
 				metadataLog.Info("Error fetching cluster. It might not exist yet, Requeuing")
+				setNotReadyCondition(ipamv1IPPool, "ClusterNotFound", "Waiting for the cluster to exist")
 				return ctrl.Result{}, nil
 			}
 		}
@@ -124,19 +136,32 @@ func (r *IPPoolReconciler) Reconcile(ctx context.Context, req ctrl.Request) (_ c
 		}
 
 		// Return early if the Metadata or Cluster is paused.
-		if annotations.IsPaused(cluster, ipamv1IPPool) {
+		if cluster.Spec.Paused || annotations.HasPaused(ipamv1IPPool) {
 			metadataLog.Info("reconciliation is paused for this object")
-			return ctrl.Result{Requeue: true, RequeueAfter: requeueAfter}, nil
+			setPausedCondition(ipamv1IPPool, true)
+			return ctrl.Result{Requeue: true, RequeueAfter: r.Settings.JitteredRequeueAfter()}, nil
+		}
+
+		// Release the addresses of the cluster as soon as it is being deleted
+		if !cluster.DeletionTimestamp.IsZero() {
+			if err := ipPoolMgr.DeleteClusterClaims(ctx); err != nil {
+				return checkRequeueError(err, "Failed to delete the claims of the cluster")
+			}
 		}
 	}
 
+	setPausedCondition(ipamv1IPPool, false)
+
 	// Handle deleted metadata
 	if !ipamv1IPPool.ObjectMeta.DeletionTimestamp.IsZero() {
-		return r.reconcileDelete(ctx, ipPoolMgr)
+		setNotReadyCondition(ipamv1IPPool, "Deleting", "")
+		return r.reconcileDelete(ctx, ipPoolMgr, ipamv1IPPool.Spec.DeletionPolicy)
 	}
 
 	// Handle non-deleted machines
-	return r.reconcileNormal(ctx, ipPoolMgr)
+	res, err := r.reconcileNormal(ctx, ipPoolMgr)
+	setReadyCondition(ipamv1IPPool, err)
+	return res, err
 }
 
 func (r *IPPoolReconciler) reconcileNormal(ctx context.Context,
@@ -153,13 +178,26 @@ func (r *IPPoolReconciler) reconcileNormal(ctx context.Context,
 	return ctrl.Result{}, nil
 }
 
+// reconcileDelete releases the addresses of the deleted pool and removes its
+// finalizer once none is allocated. A pool orphaning its claims is released
+// right away, a pool cascading to its claims deletes them first.
 func (r *IPPoolReconciler) reconcileDelete(ctx context.Context,
-	ipPoolMgr ipam.IPPoolManagerInterface,
+	ipPoolMgr ipam.IPPoolManagerInterface, policy ipamv1.DeletionPolicy,
 ) (ctrl.Result, error) {
 
+	switch policy {
+	case ipamv1.DeletionPolicyOrphan:
+		ipPoolMgr.UnsetFinalizer()
+		return ctrl.Result{}, nil
+	case ipamv1.DeletionPolicyCascade:
+		if err := ipPoolMgr.DeletePoolClaims(ctx); err != nil {
+			return checkRequeueError(err, "Failed to delete the claims of the pool")
+		}
+	}
+
 	allocationsNb, err := ipPoolMgr.UpdateAddresses(ctx)
 	if err != nil {
-		return checkRequeueError(err, "Failed to delete the old secrets")
+		return checkRequeueError(err, "Failed to delete the old addresses")
 	}
 
 	if allocationsNb == 0 {
@@ -172,14 +210,24 @@ func (r *IPPoolReconciler) reconcileDelete(ctx context.Context,
 }
 
 // SetupWithManager will add watches for this controller
-func (r *IPPoolReconciler) SetupWithManager(ctx context.Context, mgr ctrl.Manager) error {
+func (r *IPPoolReconciler) SetupWithManager(ctx context.Context, mgr ctrl.Manager, options controller.Options) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&ipamv1.IPPool{}).
 		Watches(
 			&source.Kind{Type: &ipamv1.IPClaim{}},
-			handler.EnqueueRequestsFromMapFunc(r.IPClaimToIPPool),
+			enqueueRequestsFromMapFuncDebounced(r.IPClaimToIPPool, r.ClaimDebounce),
+			builder.WithPredicates(ipClaimDeletedPredicate()),
 		).
-		WithEventFilter(predicates.ResourceNotPausedAndHasFilterLabel(ctrl.LoggerFrom(ctx), r.WatchFilterValue)).
+		Watches(
+			&source.Kind{Type: &capi.Cluster{}},
+			handler.EnqueueRequestsFromMapFunc(r.ClusterToIPPools),
+		).
+		Watches(
+			&source.Kind{Type: &ipamv1.IPAddress{}},
+			handler.EnqueueRequestsFromMapFunc(r.IPAddressToIPPool),
+		).
+		WithEventFilter(resourceNotPausedAndHasFilterLabel(ctrl.LoggerFrom(ctx), r.WatchFilterValue)).
+		WithOptions(options).
 		Complete(r)
 }
 
@@ -188,24 +236,144 @@ func (r *IPPoolReconciler) SetupWithManager(ctx context.Context, mgr ctrl.Manage
 // IPClaim and that IPClaim references a Metal3DataTemplate
 func (r *IPPoolReconciler) IPClaimToIPPool(obj client.Object) []ctrl.Request {
 	if m3ipc, ok := obj.(*ipamv1.IPClaim); ok {
-		if m3ipc.Spec.Pool.Name != "" {
+		// The ClusterIPPools have their own reconciler
+		if m3ipc.Spec.Pool.Name != "" && !ipamv1.IsClusterIPPoolRef(m3ipc.Spec.Pool) {
 			namespace := m3ipc.Spec.Pool.Namespace
 			if namespace == "" {
 				namespace = m3ipc.Namespace
 			}
-			return []ctrl.Request{
-				{
+			key := types.NamespacedName{
+				Name:      m3ipc.Spec.Pool.Name,
+				Namespace: namespace,
+			}
+			requests := []ctrl.Request{{NamespacedName: key}}
+			// The pool might have been renamed
+			renamedPool, err := ipamv1.FindRenamedIPPool(context.Background(), r.Client, key)
+			if err == nil && renamedPool != nil {
+				requests = append(requests, ctrl.Request{
 					NamespacedName: types.NamespacedName{
-						Name:      m3ipc.Spec.Pool.Name,
+						Name:      renamedPool.Name,
+						Namespace: renamedPool.Namespace,
+					},
+				})
+			}
+			// The claims handed over by a draining pool are served by the
+			// target pool
+			if servedBy, ok := m3ipc.Annotations[ipamv1.ServedByAnnotation]; ok && servedBy != key.Name {
+				requests = append(requests, ctrl.Request{
+					NamespacedName: types.NamespacedName{
+						Name:      servedBy,
 						Namespace: namespace,
 					},
-				},
+				})
 			}
+			return requests
 		}
 	}
 	return []ctrl.Request{}
 }
 
+// IPAddressToIPPool will return a reconcile request for the IPPool of an
+// IPAddress if the event is for an IPAddress imported into an externally
+// managed IPPool, to bind its claim
+func (r *IPPoolReconciler) IPAddressToIPPool(obj client.Object) []ctrl.Request {
+	address, ok := obj.(*ipamv1.IPAddress)
+	if !ok || address.Spec.Pool.Name == "" || address.Spec.Claim.Name == "" ||
+		ipamv1.IsClusterIPPoolRef(address.Spec.Pool) {
+		return []ctrl.Request{}
+	}
+	ipPool, err := ipamv1.GetIPPool(context.Background(), r.Client, client.ObjectKey{
+		Name:      address.Spec.Pool.Name,
+		Namespace: address.Namespace,
+	})
+	if err != nil || !ipPool.Spec.ExternallyManaged {
+		return []ctrl.Request{}
+	}
+	return []ctrl.Request{{
+		NamespacedName: types.NamespacedName{
+			Name:      ipPool.Name,
+			Namespace: ipPool.Namespace,
+		},
+	}}
+}
+
+// ClusterToIPPools will return reconcile requests for the IPPools of a
+// Cluster if the event is for a Cluster
+func (r *IPPoolReconciler) ClusterToIPPools(obj client.Object) []ctrl.Request {
+	requests := []ctrl.Request{}
+	cluster, ok := obj.(*capi.Cluster)
+	if !ok {
+		return requests
+	}
+	ipPools := ipamv1.IPPoolList{}
+	opts := []client.ListOption{
+		client.InNamespace(cluster.Namespace),
+		client.MatchingLabels{capi.ClusterLabelName: cluster.Name},
+	}
+	if err := r.Client.List(context.Background(), &ipPools, opts...); err != nil {
+		return requests
+	}
+	for _, ipPool := range ipPools.Items {
+		requests = append(requests, ctrl.Request{
+			NamespacedName: types.NamespacedName{
+				Name:      ipPool.Name,
+				Namespace: ipPool.Namespace,
+			},
+		})
+	}
+	return requests
+}
+
+// setPausedCondition sets the Paused condition of the pool. A paused pool is
+// not ready.
+func setPausedCondition(ipPool *ipamv1.IPPool, paused bool) {
+	if !paused {
+		meta.SetStatusCondition(&ipPool.Status.Conditions, metav1.Condition{
+			Type:   ipamv1.IPPoolPausedCondition,
+			Status: metav1.ConditionFalse,
+			Reason: "NotPaused",
+		})
+		return
+	}
+	meta.SetStatusCondition(&ipPool.Status.Conditions, metav1.Condition{
+		Type:    ipamv1.IPPoolPausedCondition,
+		Status:  metav1.ConditionTrue,
+		Reason:  "ReconciliationPaused",
+		Message: "The IPPool or its cluster is paused",
+	})
+	setNotReadyCondition(ipPool, "Paused", "The reconciliation is paused")
+}
+
+// setReadyCondition sets the Ready condition of the pool from the result of
+// its reconciliation and from its InvalidSpec condition
+func setReadyCondition(ipPool *ipamv1.IPPool, err error) {
+	if err != nil {
+		setNotReadyCondition(ipPool, "ReconcileFailed", err.Error())
+		return
+	}
+	if invalid := meta.FindStatusCondition(ipPool.Status.Conditions,
+		ipamv1.IPPoolInvalidSpecCondition,
+	); invalid != nil && invalid.Status == metav1.ConditionTrue {
+		setNotReadyCondition(ipPool, "InvalidSpec", invalid.Message)
+		return
+	}
+	meta.SetStatusCondition(&ipPool.Status.Conditions, metav1.Condition{
+		Type:   ipamv1.IPPoolReadyCondition,
+		Status: metav1.ConditionTrue,
+		Reason: "Reconciled",
+	})
+}
+
+// setNotReadyCondition sets the Ready condition of the pool to false
+func setNotReadyCondition(ipPool *ipamv1.IPPool, reason, message string) {
+	meta.SetStatusCondition(&ipPool.Status.Conditions, metav1.Condition{
+		Type:    ipamv1.IPPoolReadyCondition,
+		Status:  metav1.ConditionFalse,
+		Reason:  reason,
+		Message: message,
+	})
+}
+
 func checkRequeueError(err error, errMessage string) (ctrl.Result, error) {
 	if err == nil {
 		return ctrl.Result{}, nil
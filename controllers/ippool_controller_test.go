@@ -29,6 +29,7 @@ import (
 	ipam_mocks "github.com/metal3-io/ip-address-manager/ipam/mocks"
 	"github.com/pkg/errors"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/klog/v2/klogr"
@@ -59,6 +60,7 @@ var _ = Describe("IPPool controller", func() {
 		reconcileNormalError bool
 		reconcileDeleteError bool
 		setOwnerRefError     bool
+		deleteClaimsError    bool
 	}
 
 	DescribeTable("Test Reconcile",
@@ -92,6 +94,10 @@ var _ = Describe("IPPool controller", func() {
 				}
 			}
 
+			if tc.deleteClaimsError {
+				m.EXPECT().DeleteClusterClaims(gomock.Any()).Return(errors.New(""))
+			}
+
 			if tc.m3ipp != nil && !tc.m3ipp.DeletionTimestamp.IsZero() && tc.reconcileDeleteError {
 				m.EXPECT().UpdateAddresses(context.TODO()).Return(0, errors.New(""))
 			} else if tc.m3ipp != nil && !tc.m3ipp.DeletionTimestamp.IsZero() {
@@ -211,6 +217,22 @@ var _ = Describe("IPPool controller", func() {
 			reconcileNormal: false,
 			expectManager:   false,
 		}),
+		Entry("Cluster being deleted, error", testCaseReconcile{
+			m3ipp: &ipamv1.IPPool{
+				ObjectMeta: testObjectMeta,
+				Spec:       ipamv1.IPPoolSpec{ClusterName: pointer.StringPtr("abc")},
+			},
+			cluster: &capi.Cluster{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              "abc",
+					Namespace:         "myns",
+					DeletionTimestamp: &timestampNow,
+				},
+			},
+			expectManager:     true,
+			deleteClaimsError: true,
+			expectError:       true,
+		}),
 		Entry("Reconcile normal no error", testCaseReconcile{
 			m3ipp: &ipamv1.IPPool{
 				ObjectMeta: testObjectMeta,
@@ -281,6 +303,8 @@ var _ = Describe("IPPool controller", func() {
 		ExpectRequeue bool
 		DeleteReady   bool
 		DeleteError   bool
+		Policy        ipamv1.DeletionPolicy
+		CascadeError  bool
 	}
 
 	DescribeTable("ReconcileDelete tests",
@@ -295,7 +319,18 @@ var _ = Describe("IPPool controller", func() {
 			}
 			m := ipam_mocks.NewMockIPPoolManagerInterface(gomockCtrl)
 
-			if !tc.DeleteError && tc.DeleteReady {
+			if tc.Policy == ipamv1.DeletionPolicyCascade {
+				if tc.CascadeError {
+					m.EXPECT().DeletePoolClaims(context.TODO()).Return(errors.New(""))
+				} else {
+					m.EXPECT().DeletePoolClaims(context.TODO()).Return(nil)
+				}
+			}
+			if tc.Policy == ipamv1.DeletionPolicyOrphan {
+				m.EXPECT().UnsetFinalizer()
+			} else if tc.CascadeError {
+				// The addresses are not updated
+			} else if !tc.DeleteError && tc.DeleteReady {
 				m.EXPECT().UpdateAddresses(context.TODO()).Return(0, nil)
 				m.EXPECT().UnsetFinalizer()
 			} else if !tc.DeleteError {
@@ -304,7 +339,7 @@ var _ = Describe("IPPool controller", func() {
 				m.EXPECT().UpdateAddresses(context.TODO()).Return(0, errors.New(""))
 			}
 
-			res, err := ipPoolReconcile.reconcileDelete(context.TODO(), m)
+			res, err := ipPoolReconcile.reconcileDelete(context.TODO(), m, tc.Policy)
 			gomockCtrl.Finish()
 
 			if tc.ExpectError {
@@ -333,19 +368,64 @@ var _ = Describe("IPPool controller", func() {
 			ExpectRequeue: false,
 			DeleteReady:   true,
 		}),
+		Entry("Block policy, waiting for the claims", reconcileDeleteTestCase{
+			Policy:        ipamv1.DeletionPolicyBlock,
+			ExpectError:   false,
+			ExpectRequeue: false,
+		}),
+		Entry("Orphan policy", reconcileDeleteTestCase{
+			Policy:        ipamv1.DeletionPolicyOrphan,
+			ExpectError:   false,
+			ExpectRequeue: false,
+		}),
+		Entry("Cascade policy", reconcileDeleteTestCase{
+			Policy:        ipamv1.DeletionPolicyCascade,
+			ExpectError:   false,
+			ExpectRequeue: false,
+			DeleteReady:   true,
+		}),
+		Entry("Cascade policy, delete error", reconcileDeleteTestCase{
+			Policy:        ipamv1.DeletionPolicyCascade,
+			CascadeError:  true,
+			ExpectError:   true,
+			ExpectRequeue: false,
+		}),
 	)
 
 	type TestCaseM3IPCToM3IPP struct {
-		IPClaim       *ipamv1.IPClaim
-		ExpectRequest bool
+		IPClaim        *ipamv1.IPClaim
+		IPPools        []*ipamv1.IPPool
+		ExpectRequest  bool
+		ExpectRenamed  string
+		ExpectServedBy string
 	}
 
 	DescribeTable("IPClaim To IPPool tests",
 		func(tc TestCaseM3IPCToM3IPP) {
-			r := IPPoolReconciler{}
+			objects := []client.Object{}
+			for _, ipPool := range tc.IPPools {
+				objects = append(objects, ipPool)
+			}
+			c := fake.NewClientBuilder().WithScheme(setupScheme()).WithObjects(objects...).Build()
+			r := IPPoolReconciler{Client: c}
 			obj := client.Object(tc.IPClaim)
 			reqs := r.IPClaimToIPPool(obj)
 
+			if tc.ExpectRenamed != "" {
+				Expect(len(reqs)).To(Equal(2), "Expected 2 requests, found %d", len(reqs))
+				Expect(reqs[1].NamespacedName.Name).To(Equal(tc.ExpectRenamed))
+				reqs = reqs[:1]
+			}
+
+			if tc.ExpectServedBy != "" {
+				Expect(len(reqs)).To(Equal(2), "Expected 2 requests, found %d", len(reqs))
+				Expect(reqs[1].NamespacedName).To(Equal(types.NamespacedName{
+					Name:      tc.ExpectServedBy,
+					Namespace: tc.IPClaim.Namespace,
+				}))
+				reqs = reqs[:1]
+			}
+
 			if tc.ExpectRequest {
 				Expect(len(reqs)).To(Equal(1), "Expected 1 request, found %d", len(reqs))
 
@@ -401,5 +481,238 @@ var _ = Describe("IPPool controller", func() {
 				ExpectRequest: true,
 			},
 		),
+		Entry("IPPool in Spec, renamed",
+			TestCaseM3IPCToM3IPP{
+				IPClaim: &ipamv1.IPClaim{
+					ObjectMeta: testObjectMeta,
+					Spec: ipamv1.IPClaimSpec{
+						Pool: corev1.ObjectReference{
+							Name: "abc",
+						},
+					},
+				},
+				IPPools: []*ipamv1.IPPool{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "bcd",
+							Namespace: "myns",
+							Annotations: map[string]string{
+								ipamv1.RenamedFromAnnotation: "abc",
+							},
+						},
+					},
+				},
+				ExpectRequest: true,
+				ExpectRenamed: "bcd",
+			},
+		),
+		Entry("IPPool in Spec, served by the target pool of a drain",
+			TestCaseM3IPCToM3IPP{
+				IPClaim: &ipamv1.IPClaim{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "abc",
+						Namespace: "myns",
+						Annotations: map[string]string{
+							ipamv1.ServedByAnnotation: "bcd",
+						},
+					},
+					Spec: ipamv1.IPClaimSpec{
+						Pool: corev1.ObjectReference{
+							Name: "abc",
+						},
+					},
+				},
+				ExpectRequest:  true,
+				ExpectServedBy: "bcd",
+			},
+		),
+		Entry("ClusterIPPool in Spec",
+			TestCaseM3IPCToM3IPP{
+				IPClaim: &ipamv1.IPClaim{
+					ObjectMeta: testObjectMeta,
+					Spec: ipamv1.IPClaimSpec{
+						Pool: corev1.ObjectReference{
+							Name: "abc",
+							Kind: ipamv1.ClusterIPPoolKind,
+						},
+					},
+				},
+				ExpectRequest: false,
+			},
+		),
+	)
+
+	DescribeTable("Cluster To IPPools tests",
+		func(obj client.Object, ipPools []*ipamv1.IPPool, expectedPools []string) {
+			objects := []client.Object{}
+			for _, ipPool := range ipPools {
+				objects = append(objects, ipPool)
+			}
+			c := fake.NewClientBuilder().WithScheme(setupScheme()).WithObjects(objects...).Build()
+			r := IPPoolReconciler{Client: c}
+			reqs := r.ClusterToIPPools(obj)
+
+			names := []string{}
+			for _, req := range reqs {
+				Expect(req.NamespacedName.Namespace).To(Equal("myns"))
+				names = append(names, req.NamespacedName.Name)
+			}
+			Expect(names).To(ConsistOf(expectedPools))
+		},
+		Entry("Not a cluster", &ipamv1.IPClaim{ObjectMeta: testObjectMeta},
+			[]*ipamv1.IPPool{}, []string{},
+		),
+		Entry("Cluster with pools", &capi.Cluster{ObjectMeta: testObjectMeta},
+			[]*ipamv1.IPPool{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "pool1",
+						Namespace: "myns",
+						Labels:    map[string]string{capi.ClusterLabelName: "abc"},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "pool2",
+						Namespace: "myns",
+						Labels:    map[string]string{capi.ClusterLabelName: "bcd"},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "pool3",
+						Namespace: "otherns",
+						Labels:    map[string]string{capi.ClusterLabelName: "abc"},
+					},
+				},
+			}, []string{"pool1"},
+		),
+	)
+
+	DescribeTable("IPAddress To IPPool tests",
+		func(obj client.Object, externallyManaged bool, expectedPools []string) {
+			ipPool := &ipamv1.IPPool{
+				ObjectMeta: testObjectMeta,
+				Spec: ipamv1.IPPoolSpec{
+					ExternallyManaged: externallyManaged,
+				},
+			}
+			c := fake.NewClientBuilder().WithScheme(setupScheme()).WithObjects(ipPool).Build()
+			r := IPPoolReconciler{Client: c}
+			reqs := r.IPAddressToIPPool(obj)
+
+			names := []string{}
+			for _, req := range reqs {
+				Expect(req.NamespacedName.Namespace).To(Equal("myns"))
+				names = append(names, req.NamespacedName.Name)
+			}
+			Expect(names).To(ConsistOf(expectedPools))
+		},
+		Entry("Not an IPAddress", &ipamv1.IPClaim{ObjectMeta: testObjectMeta},
+			true, []string{},
+		),
+		Entry("IPAddress without claim", &ipamv1.IPAddress{
+			ObjectMeta: testObjectMeta,
+			Spec: ipamv1.IPAddressSpec{
+				Pool: corev1.ObjectReference{Name: "abc"},
+			},
+		}, true, []string{}),
+		Entry("IPAddress of a managed pool", &ipamv1.IPAddress{
+			ObjectMeta: testObjectMeta,
+			Spec: ipamv1.IPAddressSpec{
+				Pool:  corev1.ObjectReference{Name: "abc"},
+				Claim: corev1.ObjectReference{Name: "bcd"},
+			},
+		}, false, []string{}),
+		Entry("IPAddress of an externally managed pool", &ipamv1.IPAddress{
+			ObjectMeta: testObjectMeta,
+			Spec: ipamv1.IPAddressSpec{
+				Pool:  corev1.ObjectReference{Name: "abc"},
+				Claim: corev1.ObjectReference{Name: "bcd"},
+			},
+		}, true, []string{"abc"}),
+		Entry("IPAddress of a missing pool", &ipamv1.IPAddress{
+			ObjectMeta: testObjectMeta,
+			Spec: ipamv1.IPAddressSpec{
+				Pool:  corev1.ObjectReference{Name: "cde"},
+				Claim: corev1.ObjectReference{Name: "bcd"},
+			},
+		}, true, []string{}),
+	)
+
+	type testCaseConditions struct {
+		conditions      []metav1.Condition
+		paused          bool
+		reconcileErr    error
+		expectedReady   metav1.ConditionStatus
+		expectedReason  string
+		expectedPaused  metav1.ConditionStatus
+		expectedMessage string
+	}
+
+	DescribeTable("Test the Ready and Paused conditions",
+		func(tc testCaseConditions) {
+			ipPool := &ipamv1.IPPool{
+				Status: ipamv1.IPPoolStatus{Conditions: tc.conditions},
+			}
+			setPausedCondition(ipPool, tc.paused)
+			if !tc.paused {
+				setReadyCondition(ipPool, tc.reconcileErr)
+			}
+			paused := meta.FindStatusCondition(ipPool.Status.Conditions, ipamv1.IPPoolPausedCondition)
+			Expect(paused).NotTo(BeNil())
+			Expect(paused.Status).To(Equal(tc.expectedPaused))
+			ready := meta.FindStatusCondition(ipPool.Status.Conditions, ipamv1.IPPoolReadyCondition)
+			Expect(ready).NotTo(BeNil())
+			Expect(ready.Status).To(Equal(tc.expectedReady))
+			Expect(ready.Reason).To(Equal(tc.expectedReason))
+			Expect(ready.Message).To(Equal(tc.expectedMessage))
+		},
+		Entry("Reconciled", testCaseConditions{
+			expectedReady:  metav1.ConditionTrue,
+			expectedReason: "Reconciled",
+			expectedPaused: metav1.ConditionFalse,
+		}),
+		Entry("Paused", testCaseConditions{
+			conditions: []metav1.Condition{{
+				Type:   ipamv1.IPPoolReadyCondition,
+				Status: metav1.ConditionTrue,
+				Reason: "Reconciled",
+			}},
+			paused:          true,
+			expectedReady:   metav1.ConditionFalse,
+			expectedReason:  "Paused",
+			expectedPaused:  metav1.ConditionTrue,
+			expectedMessage: "The reconciliation is paused",
+		}),
+		Entry("Reconcile failed", testCaseConditions{
+			reconcileErr:    errors.New("Failed"),
+			expectedReady:   metav1.ConditionFalse,
+			expectedReason:  "ReconcileFailed",
+			expectedPaused:  metav1.ConditionFalse,
+			expectedMessage: "Failed",
+		}),
+		Entry("Invalid spec", testCaseConditions{
+			conditions: []metav1.Condition{{
+				Type:    ipamv1.IPPoolInvalidSpecCondition,
+				Status:  metav1.ConditionTrue,
+				Reason:  "InvalidPool",
+				Message: "Invalid pool",
+			}},
+			expectedReady:   metav1.ConditionFalse,
+			expectedReason:  "InvalidSpec",
+			expectedPaused:  metav1.ConditionFalse,
+			expectedMessage: "Invalid pool",
+		}),
+		Entry("Valid spec", testCaseConditions{
+			conditions: []metav1.Condition{{
+				Type:   ipamv1.IPPoolInvalidSpecCondition,
+				Status: metav1.ConditionFalse,
+				Reason: "ValidSpec",
+			}},
+			expectedReady:  metav1.ConditionTrue,
+			expectedReason: "Reconciled",
+			expectedPaused: metav1.ConditionFalse,
+		}),
 	)
 })
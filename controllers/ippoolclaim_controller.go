@@ -0,0 +1,111 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	"github.com/metal3-io/ip-address-manager/ipam"
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/cluster-api/util/annotations"
+	"sigs.k8s.io/cluster-api/util/patch"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+)
+
+const (
+	ipPoolClaimControllerName = "IPPoolClaim-controller"
+)
+
+// IPPoolClaimReconciler reconciles a IPPoolClaim object
+type IPPoolClaimReconciler struct {
+	Client           client.Client
+	ManagerFactory   ipam.ManagerFactoryInterface
+	Log              logr.Logger
+	WatchFilterValue string
+	// Settings are the operator-wide settings, the defaults are used if nil
+	Settings *ipam.Settings
+}
+
+// +kubebuilder:rbac:groups=ipam.metal3.io,resources=ippoolclaims,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=ipam.metal3.io,resources=ippoolclaims/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=ipam.metal3.io,resources=ippools,verbs=get;list;watch;create
+// +kubebuilder:rbac:groups=ipam.metal3.io,resources=ipclaims,verbs=get;list;watch;create;update
+// +kubebuilder:rbac:groups=ipam.metal3.io,resources=clusterippools,verbs=get;list;watch
+
+// Reconcile handles IPPoolClaim events
+func (r *IPPoolClaimReconciler) Reconcile(ctx context.Context, req ctrl.Request) (_ ctrl.Result, rerr error) {
+	poolClaimLog := r.Log.WithName(ipPoolClaimControllerName).WithValues("metal3-ippoolclaim", req.NamespacedName)
+
+	// Fetch the IPPoolClaim instance.
+	ipamv1IPPoolClaim := &ipamv1.IPPoolClaim{}
+
+	if err := r.Client.Get(ctx, req.NamespacedName, ipamv1IPPoolClaim); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	// The IPPool and the IPClaim of the prefix are garbage collected through
+	// their owner references
+	if !ipamv1IPPoolClaim.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	if annotations.HasPaused(ipamv1IPPoolClaim) {
+		poolClaimLog.Info("reconciliation is paused for this object")
+		return ctrl.Result{Requeue: true, RequeueAfter: r.Settings.JitteredRequeueAfter()}, nil
+	}
+
+	helper, err := patch.NewHelper(ipamv1IPPoolClaim, r.Client)
+	if err != nil {
+		return ctrl.Result{}, errors.Wrap(err, "failed to init patch helper")
+	}
+	// Always patch ipamv1IPPoolClaim exiting this function so we can persist any IPPoolClaim changes.
+	defer func() {
+		err := helper.Patch(ctx, ipamv1IPPoolClaim)
+		if err != nil {
+			poolClaimLog.Info("failed to Patch ipamv1IPPoolClaim")
+		}
+	}()
+
+	ipPoolClaimMgr, err := r.ManagerFactory.NewIPPoolClaimManager(ipamv1IPPoolClaim, poolClaimLog)
+	if err != nil {
+		return ctrl.Result{}, errors.Wrapf(err, "failed to create helper for managing the IP pool claim")
+	}
+
+	if err := ipPoolClaimMgr.UpdatePool(ctx); err != nil {
+		return checkRequeueError(err, "Failed to update the pool")
+	}
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager will add watches for this controller
+func (r *IPPoolClaimReconciler) SetupWithManager(ctx context.Context, mgr ctrl.Manager, options controller.Options) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&ipamv1.IPPoolClaim{}).
+		Owns(&ipamv1.IPClaim{}).
+		Owns(&ipamv1.IPPool{}).
+		WithEventFilter(resourceNotPausedAndHasFilterLabel(ctrl.LoggerFrom(ctx), r.WatchFilterValue)).
+		WithOptions(options).
+		Complete(r)
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/golang/mock/gomock"
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	"github.com/metal3-io/ip-address-manager/ipam"
+	ipam_mocks "github.com/metal3-io/ip-address-manager/ipam/mocks"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2/klogr"
+	capi "sigs.k8s.io/cluster-api/api/v1alpha4"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var _ = Describe("IPPoolClaim controller", func() {
+
+	type testCaseReconcilePoolClaim struct {
+		ipPoolClaim   *ipamv1.IPPoolClaim
+		expectManager bool
+		updateError   error
+		expectError   bool
+		expectRequeue bool
+	}
+
+	DescribeTable("Test Reconcile",
+		func(tc testCaseReconcilePoolClaim) {
+			gomockCtrl := gomock.NewController(GinkgoT())
+			f := ipam_mocks.NewMockManagerFactoryInterface(gomockCtrl)
+			m := ipam_mocks.NewMockIPPoolClaimManagerInterface(gomockCtrl)
+
+			objects := []client.Object{}
+			if tc.ipPoolClaim != nil {
+				objects = append(objects, tc.ipPoolClaim)
+			}
+			c := fake.NewClientBuilder().WithScheme(setupScheme()).WithObjects(objects...).Build()
+
+			if tc.expectManager {
+				f.EXPECT().NewIPPoolClaimManager(gomock.Any(), gomock.Any()).Return(m, nil)
+				m.EXPECT().UpdatePool(gomock.Any()).Return(tc.updateError)
+			}
+
+			r := &IPPoolClaimReconciler{
+				Client:         c,
+				ManagerFactory: f,
+				Log:            klogr.New(),
+			}
+
+			req := reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Name:      "abc",
+					Namespace: "myns",
+				},
+			}
+
+			result, err := r.Reconcile(context.TODO(), req)
+			gomockCtrl.Finish()
+
+			if tc.expectError {
+				Expect(err).To(HaveOccurred())
+			} else {
+				Expect(err).NotTo(HaveOccurred())
+			}
+			Expect(result.Requeue).To(Equal(tc.expectRequeue))
+		},
+		Entry("IPPoolClaim not found", testCaseReconcilePoolClaim{}),
+		Entry("IPPoolClaim deleted", testCaseReconcilePoolClaim{
+			ipPoolClaim: &ipamv1.IPPoolClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              "abc",
+					Namespace:         "myns",
+					DeletionTimestamp: &timestampNow,
+				},
+			},
+		}),
+		Entry("IPPoolClaim paused", testCaseReconcilePoolClaim{
+			ipPoolClaim: &ipamv1.IPPoolClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "abc",
+					Namespace: "myns",
+					Annotations: map[string]string{
+						capi.PausedAnnotation: "",
+					},
+				},
+			},
+			expectRequeue: true,
+		}),
+		Entry("Update error", testCaseReconcilePoolClaim{
+			ipPoolClaim: &ipamv1.IPPoolClaim{
+				ObjectMeta: testObjectMeta,
+			},
+			expectManager: true,
+			updateError:   errors.New(""),
+			expectError:   true,
+		}),
+		Entry("Update requeue", testCaseReconcilePoolClaim{
+			ipPoolClaim: &ipamv1.IPPoolClaim{
+				ObjectMeta: testObjectMeta,
+			},
+			expectManager: true,
+			updateError:   &ipam.RequeueAfterError{},
+			expectRequeue: true,
+		}),
+		Entry("Update", testCaseReconcilePoolClaim{
+			ipPoolClaim: &ipamv1.IPPoolClaim{
+				ObjectMeta: testObjectMeta,
+			},
+			expectManager: true,
+		}),
+	)
+})
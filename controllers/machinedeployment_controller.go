@@ -0,0 +1,176 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	"github.com/metal3-io/ip-address-manager/ipam"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+	capi "sigs.k8s.io/cluster-api/api/v1alpha4"
+	"sigs.k8s.io/cluster-api/util/annotations"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+)
+
+const (
+	machineDeploymentControllerName = "MachineDeployment-controller"
+)
+
+// MachineDeploymentReconciler maintains an IPClaimSet for the
+// MachineDeployments carrying the claim pool annotation, so that addresses are
+// reserved for the Machines before they are created.
+type MachineDeploymentReconciler struct {
+	Client           client.Client
+	Log              logr.Logger
+	WatchFilterValue string
+	// Settings are the operator-wide settings, the defaults are used if nil
+	Settings *ipam.Settings
+}
+
+// +kubebuilder:rbac:groups=cluster.x-k8s.io,resources=machinedeployments,verbs=get;list;watch
+// +kubebuilder:rbac:groups=ipam.metal3.io,resources=ipclaimsets,verbs=get;list;watch;create;update;patch;delete
+
+// Reconcile handles MachineDeployment events
+func (r *MachineDeploymentReconciler) Reconcile(ctx context.Context, req ctrl.Request) (_ ctrl.Result, rerr error) {
+	mdLog := r.Log.WithName(machineDeploymentControllerName).WithValues("machinedeployment", req.NamespacedName)
+
+	// Fetch the MachineDeployment instance.
+	machineDeployment := &capi.MachineDeployment{}
+
+	if err := r.Client.Get(ctx, req.NamespacedName, machineDeployment); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	// The IPClaimSet is garbage collected through its owner references
+	if !machineDeployment.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	if annotations.HasPaused(machineDeployment) {
+		mdLog.Info("reconciliation is paused for this object")
+		return ctrl.Result{Requeue: true, RequeueAfter: r.Settings.JitteredRequeueAfter()}, nil
+	}
+
+	// Fetch the IPClaimSet of the MachineDeployment, if any.
+	claimSet := &ipamv1.IPClaimSet{}
+	if err := r.Client.Get(ctx, req.NamespacedName, claimSet); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+		claimSet = nil
+	} else if !metav1.IsControlledBy(claimSet, machineDeployment) {
+		return ctrl.Result{}, errors.Errorf(
+			"IPClaimSet %s exists and is not controlled by the MachineDeployment",
+			claimSet.Name,
+		)
+	}
+
+	poolName, ok := machineDeployment.Annotations[ipamv1.ClaimPoolAnnotation]
+	if !ok || poolName == "" {
+		// The MachineDeployment opted out, release the reserved addresses
+		if claimSet != nil && claimSet.DeletionTimestamp.IsZero() {
+			mdLog.Info("Deleting the IPClaimSet")
+			if err := r.Client.Delete(ctx, claimSet); err != nil && !apierrors.IsNotFound(err) {
+				return ctrl.Result{}, errors.Wrap(err, "failed to delete the IPClaimSet")
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+
+	desired := newMachineDeploymentClaimSet(machineDeployment, poolName)
+	if claimSet == nil {
+		mdLog.Info("Creating the IPClaimSet")
+		if err := r.Client.Create(ctx, desired); err != nil {
+			return ctrl.Result{}, errors.Wrap(err, "failed to create the IPClaimSet")
+		}
+		return ctrl.Result{}, nil
+	}
+
+	if claimSet.Spec.Replicas == desired.Spec.Replicas {
+		return ctrl.Result{}, nil
+	}
+	// Only the number of replicas is updated, the pool of the claims is
+	// immutable.
+	mdLog.Info("Scaling the IPClaimSet", "replicas", desired.Spec.Replicas)
+	claimSet.Spec.Replicas = desired.Spec.Replicas
+	if err := r.Client.Update(ctx, claimSet); err != nil {
+		return ctrl.Result{}, errors.Wrap(err, "failed to update the IPClaimSet")
+	}
+	return ctrl.Result{}, nil
+}
+
+// newMachineDeploymentClaimSet returns the IPClaimSet reserving addresses from
+// the given pool for the machines of the MachineDeployment
+func newMachineDeploymentClaimSet(md *capi.MachineDeployment, poolName string) *ipamv1.IPClaimSet {
+	replicas := int32(1)
+	if md.Spec.Replicas != nil {
+		replicas = *md.Spec.Replicas
+	}
+	labels := map[string]string{
+		capi.ClusterLabelName:           md.Spec.ClusterName,
+		capi.MachineDeploymentLabelName: md.Name,
+	}
+	return &ipamv1.IPClaimSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      md.Name,
+			Namespace: md.Namespace,
+			Labels:    labels,
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					APIVersion:         capi.GroupVersion.String(),
+					Kind:               "MachineDeployment",
+					Name:               md.Name,
+					UID:                md.UID,
+					Controller:         pointer.BoolPtr(true),
+					BlockOwnerDeletion: pointer.BoolPtr(true),
+				},
+			},
+		},
+		Spec: ipamv1.IPClaimSetSpec{
+			Replicas: replicas,
+			Template: ipamv1.IPClaimTemplate{
+				Labels: labels,
+				Spec: ipamv1.IPClaimSpec{
+					Pool: corev1.ObjectReference{
+						Name: poolName,
+					},
+				},
+			},
+		},
+	}
+}
+
+// SetupWithManager will add watches for this controller
+func (r *MachineDeploymentReconciler) SetupWithManager(ctx context.Context, mgr ctrl.Manager, options controller.Options) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&capi.MachineDeployment{}).
+		Owns(&ipamv1.IPClaimSet{}).
+		WithEventFilter(resourceNotPausedAndHasFilterLabel(ctrl.LoggerFrom(ctx), r.WatchFilterValue)).
+		WithOptions(options).
+		Complete(r)
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2/klogr"
+	"k8s.io/utils/pointer"
+	capi "sigs.k8s.io/cluster-api/api/v1alpha4"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var _ = Describe("MachineDeployment controller", func() {
+
+	type testCaseReconcileMD struct {
+		machineDeployment *capi.MachineDeployment
+		ipClaimSet        *ipamv1.IPClaimSet
+		expectError       bool
+		expectRequeue     bool
+		expectClaimSet    bool
+		expectedReplicas  int32
+	}
+
+	machineDeployment := func(annotations map[string]string, replicas *int32) *capi.MachineDeployment {
+		return &capi.MachineDeployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "abc",
+				Namespace:   "myns",
+				UID:         "abc-uid",
+				Annotations: annotations,
+			},
+			Spec: capi.MachineDeploymentSpec{
+				ClusterName: "cluster",
+				Replicas:    replicas,
+			},
+		}
+	}
+
+	ownedClaimSet := func(replicas int32) *ipamv1.IPClaimSet {
+		return newMachineDeploymentClaimSet(
+			machineDeployment(nil, pointer.Int32Ptr(replicas)), "pool1",
+		)
+	}
+
+	DescribeTable("Test Reconcile",
+		func(tc testCaseReconcileMD) {
+			objects := []client.Object{}
+			if tc.machineDeployment != nil {
+				objects = append(objects, tc.machineDeployment)
+			}
+			if tc.ipClaimSet != nil {
+				objects = append(objects, tc.ipClaimSet)
+			}
+			c := fake.NewClientBuilder().WithScheme(setupScheme()).WithObjects(objects...).Build()
+
+			r := &MachineDeploymentReconciler{
+				Client: c,
+				Log:    klogr.New(),
+			}
+
+			req := reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Name:      "abc",
+					Namespace: "myns",
+				},
+			}
+
+			result, err := r.Reconcile(context.TODO(), req)
+
+			if tc.expectError {
+				Expect(err).To(HaveOccurred())
+			} else {
+				Expect(err).NotTo(HaveOccurred())
+			}
+			Expect(result.Requeue).To(Equal(tc.expectRequeue))
+
+			claimSet := &ipamv1.IPClaimSet{}
+			err = c.Get(context.TODO(), req.NamespacedName, claimSet)
+			if tc.expectClaimSet {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(claimSet.Spec.Replicas).To(Equal(tc.expectedReplicas))
+				Expect(claimSet.Spec.Template.Spec.Pool.Name).To(Equal("pool1"))
+				Expect(claimSet.Spec.Template.Labels).To(HaveKeyWithValue(
+					capi.ClusterLabelName, "cluster",
+				))
+				Expect(metav1.IsControlledBy(claimSet, tc.machineDeployment)).To(BeTrue())
+			} else if !tc.expectError {
+				Expect(apierrors.IsNotFound(err)).To(BeTrue())
+			}
+		},
+		Entry("MachineDeployment not found", testCaseReconcileMD{}),
+		Entry("MachineDeployment without annotation", testCaseReconcileMD{
+			machineDeployment: machineDeployment(nil, pointer.Int32Ptr(3)),
+		}),
+		Entry("MachineDeployment paused", testCaseReconcileMD{
+			machineDeployment: machineDeployment(map[string]string{
+				capi.PausedAnnotation:      "",
+				ipamv1.ClaimPoolAnnotation: "pool1",
+			}, pointer.Int32Ptr(3)),
+			expectRequeue: true,
+		}),
+		Entry("MachineDeployment with annotation", testCaseReconcileMD{
+			machineDeployment: machineDeployment(map[string]string{
+				ipamv1.ClaimPoolAnnotation: "pool1",
+			}, pointer.Int32Ptr(3)),
+			expectClaimSet:   true,
+			expectedReplicas: 3,
+		}),
+		Entry("MachineDeployment with annotation, default replicas", testCaseReconcileMD{
+			machineDeployment: machineDeployment(map[string]string{
+				ipamv1.ClaimPoolAnnotation: "pool1",
+			}, nil),
+			expectClaimSet:   true,
+			expectedReplicas: 1,
+		}),
+		Entry("MachineDeployment scaled", testCaseReconcileMD{
+			machineDeployment: machineDeployment(map[string]string{
+				ipamv1.ClaimPoolAnnotation: "pool1",
+			}, pointer.Int32Ptr(5)),
+			ipClaimSet:       ownedClaimSet(3),
+			expectClaimSet:   true,
+			expectedReplicas: 5,
+		}),
+		Entry("MachineDeployment annotation removed", testCaseReconcileMD{
+			machineDeployment: machineDeployment(nil, pointer.Int32Ptr(3)),
+			ipClaimSet:        ownedClaimSet(3),
+		}),
+		Entry("IPClaimSet not controlled by the MachineDeployment", testCaseReconcileMD{
+			machineDeployment: machineDeployment(map[string]string{
+				ipamv1.ClaimPoolAnnotation: "pool1",
+			}, pointer.Int32Ptr(3)),
+			ipClaimSet: &ipamv1.IPClaimSet{
+				ObjectMeta: testObjectMeta,
+			},
+			expectError: true,
+		}),
+	)
+})
@@ -0,0 +1,74 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"strings"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	capi "sigs.k8s.io/cluster-api/api/v1alpha4"
+	"sigs.k8s.io/cluster-api/util/predicates"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// WatchFilterSelector returns the selector of the labels of the objects the
+// watch filter lets through. The watch filter is either a comma-separated
+// list of values of the cluster.x-k8s.io/watch-filter label, or a label
+// selector expression, told apart by its operators, such as
+// "cluster.x-k8s.io/watch-filter notin (canary)" to reconcile the objects
+// left by another instance of the controller. An empty watch filter lets all
+// the objects through.
+func WatchFilterSelector(watchFilterValue string) (labels.Selector, error) {
+	if watchFilterValue == "" {
+		return labels.Everything(), nil
+	}
+	if strings.ContainsAny(watchFilterValue, "=!() ") {
+		return labels.Parse(watchFilterValue)
+	}
+	values := []string{}
+	for _, value := range strings.Split(watchFilterValue, ",") {
+		if value != "" {
+			values = append(values, value)
+		}
+	}
+	requirement, err := labels.NewRequirement(capi.WatchLabel, selection.In, values)
+	if err != nil {
+		return nil, err
+	}
+	return labels.NewSelector().Add(*requirement), nil
+}
+
+// resourceNotPausedAndHasFilterLabel is
+// predicates.ResourceNotPausedAndHasFilterLabel, with a watch filter that can
+// be a list of values or a label selector, see WatchFilterSelector. No object
+// is let through if the watch filter is invalid.
+func resourceNotPausedAndHasFilterLabel(logger logr.Logger, watchFilterValue string) predicate.Funcs {
+	selector, err := WatchFilterSelector(watchFilterValue)
+	if err != nil {
+		logger.Error(err, "Invalid watch filter, no object is reconciled", "watchFilter", watchFilterValue)
+		selector = labels.Nothing()
+	}
+	return predicates.All(logger,
+		predicates.ResourceNotPaused(logger),
+		predicate.NewPredicateFuncs(func(obj client.Object) bool {
+			return selector.Matches(labels.Set(obj.GetLabels()))
+		}),
+	)
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/klogr"
+	capi "sigs.k8s.io/cluster-api/api/v1alpha4"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+var _ = Describe("Watch filter", func() {
+
+	DescribeTable("Test resourceNotPausedAndHasFilterLabel",
+		func(watchFilterValue string, objectLabels map[string]string, paused bool, expected bool) {
+			ipPool := &ipamv1.IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "abc",
+					Namespace: "myns",
+					Labels:    objectLabels,
+				},
+			}
+			if paused {
+				ipPool.Annotations = map[string]string{capi.PausedAnnotation: ""}
+			}
+			p := resourceNotPausedAndHasFilterLabel(klogr.New(), watchFilterValue)
+			Expect(p.Create(event.CreateEvent{Object: ipPool})).To(Equal(expected))
+			Expect(p.Update(event.UpdateEvent{ObjectOld: ipPool, ObjectNew: ipPool})).To(Equal(expected))
+			Expect(p.Delete(event.DeleteEvent{Object: ipPool})).To(Equal(expected))
+			Expect(p.Generic(event.GenericEvent{Object: ipPool})).To(Equal(expected))
+		},
+		Entry("No filter", "", nil, false, true),
+		Entry("No filter, paused", "", nil, true, false),
+		Entry("Single value, matching", "abc",
+			map[string]string{capi.WatchLabel: "abc"}, false, true,
+		),
+		Entry("Single value, matching, paused", "abc",
+			map[string]string{capi.WatchLabel: "abc"}, true, false,
+		),
+		Entry("Single value, not matching", "abc",
+			map[string]string{capi.WatchLabel: "bcd"}, false, false,
+		),
+		Entry("Single value, no label", "abc", nil, false, false),
+		Entry("Several values, matching", "abc,bcd",
+			map[string]string{capi.WatchLabel: "bcd"}, false, true,
+		),
+		Entry("Several values, not matching", "abc,bcd",
+			map[string]string{capi.WatchLabel: "cde"}, false, false,
+		),
+		Entry("Selector, matching", capi.WatchLabel+" notin (abc,bcd)",
+			map[string]string{capi.WatchLabel: "cde"}, false, true,
+		),
+		Entry("Selector, no label", capi.WatchLabel+" notin (abc,bcd)", nil, false, true),
+		Entry("Selector, not matching", capi.WatchLabel+" notin (abc,bcd)",
+			map[string]string{capi.WatchLabel: "abc"}, false, false,
+		),
+		Entry("Invalid selector", capi.WatchLabel+" notin (abc", nil, false, false),
+		Entry("Invalid value", "abc,b/c", nil, false, false),
+	)
+})
@@ -0,0 +1,56 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package feature holds the feature gates of the controller, toggling its
+// features per installation with the --feature-gates flag, such as
+// --feature-gates=LeaseExpiry=false.
+package feature
+
+import (
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/component-base/featuregate"
+)
+
+const (
+	// LeaseExpiry releases the addresses of the IPClaims whose lease
+	// expired, the leases are ignored when it is disabled.
+	LeaseExpiry featuregate.Feature = "LeaseExpiry"
+
+	// ExternalBackends synchronizes the allocations of the IPPools with
+	// their NetBox or Infoblox backend. The IPPools with a backend fail to
+	// allocate addresses when it is disabled.
+	ExternalBackends featuregate.Feature = "ExternalBackends"
+)
+
+var (
+	// MutableGates is a mutable version of Gates, to be set from the
+	// --feature-gates flag
+	MutableGates featuregate.MutableFeatureGate = featuregate.NewFeatureGate()
+
+	// Gates is a shared global FeatureGate
+	Gates featuregate.FeatureGate = MutableGates
+)
+
+func init() {
+	runtime.Must(MutableGates.Add(defaultFeatureGates))
+}
+
+// defaultFeatureGates consists of all known feature keys. To add a new
+// feature, define a key for it above and add it here.
+var defaultFeatureGates = map[featuregate.Feature]featuregate.FeatureSpec{
+	LeaseExpiry:      {Default: false, PreRelease: featuregate.Alpha},
+	ExternalBackends: {Default: false, PreRelease: featuregate.Alpha},
+}
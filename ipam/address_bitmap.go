@@ -0,0 +1,141 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"math/bits"
+
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+)
+
+// usedRange is the range of the addresses of a pool, restricted to its
+// subnet, with the index of its first address in the pool
+type usedRange struct {
+	addrRange
+	firstIndex int
+}
+
+// addressBitmap is the set of the used indexes of a pool range, stored by
+// words of 64 indexes. The words without any used index are not stored, so
+// that the sparse IPv6 ranges take little memory.
+type addressBitmap struct {
+	words map[int]uint64
+}
+
+func newAddressBitmap() *addressBitmap {
+	return &addressBitmap{words: make(map[int]uint64)}
+}
+
+// set marks the index as used
+func (b *addressBitmap) set(index int) {
+	b.words[index>>6] |= 1 << uint(index&63)
+}
+
+// clear marks the index as free
+func (b *addressBitmap) clear(index int) {
+	word := b.words[index>>6] &^ (1 << uint(index&63))
+	if word == 0 {
+		delete(b.words, index>>6)
+		return
+	}
+	b.words[index>>6] = word
+}
+
+// nextFree returns the first index not marked as used from the given one,
+// skipping the full words at once
+func (b *addressBitmap) nextFree(index int) int {
+	for {
+		free := ^b.words[index>>6] >> uint(index&63)
+		if free != 0 {
+			return index + bits.TrailingZeros64(free)
+		}
+		index = (index>>6 + 1) << 6
+	}
+}
+
+// buildUsedIndexes builds the bitmaps of the used indexes of the pools from
+// the addresses in use, listed from the cache. The walk of the pools skips the
+// used indexes at once instead of checking them one by one. The bitmaps are
+// only a hint: a free index is always checked against the addresses in use,
+// some of them being only reserved in a copy of the addresses, while the
+// released addresses are marked as free right away. An index is never marked
+// as used while its address is free.
+func (m *IPPoolManager) buildUsedIndexes(addresses map[ipamv1.IPAddressStr]string) {
+	m.usedRanges = make([]*usedRange, len(m.IPPool.Spec.Pools))
+	m.usedIndexes = make([]*addressBitmap, len(m.IPPool.Spec.Pools))
+	// The prefixes are delegated from the pools rather than walked
+	if m.IPPool.Spec.DelegatedPrefix != 0 {
+		return
+	}
+	for i, pool := range m.IPPool.Spec.Pools {
+		poolRange, err := ipamv1.NewPoolRange(pool)
+		if err != nil {
+			continue
+		}
+		first, last := poolRange.Bounds()
+		firstIndex, err := poolRange.IndexOf(first)
+		if err != nil {
+			continue
+		}
+		firstAddr, _ := addrFromIP(first)
+		lastAddr, _ := addrFromIP(last)
+		m.usedRanges[i] = &usedRange{
+			addrRange:  addrRange{first: firstAddr, last: lastAddr},
+			firstIndex: firstIndex,
+		}
+		m.usedIndexes[i] = newAddressBitmap()
+	}
+	for address := range addresses {
+		m.markAddress(address, true)
+	}
+}
+
+// markAddress marks the address as used or free in the bitmaps of the pools
+// containing it
+func (m *IPPoolManager) markAddress(address ipamv1.IPAddressStr, used bool) {
+	if len(m.usedIndexes) == 0 {
+		return
+	}
+	addr, ok := parseAddr(string(address))
+	if !ok {
+		return
+	}
+	for i, usedRange := range m.usedRanges {
+		if usedRange == nil || !usedRange.contains(addr) {
+			continue
+		}
+		offset, _ := addr.sub(usedRange.first)
+		if offset > uint64(ipamv1.MaxPoolIndex-usedRange.firstIndex) {
+			continue
+		}
+		index := usedRange.firstIndex + int(offset)
+		if used {
+			m.usedIndexes[i].set(index)
+		} else {
+			m.usedIndexes[i].clear(index)
+		}
+	}
+}
+
+// poolBitmap returns the bitmap of the used indexes of the pool, nil if the
+// pool is walked address by address, for example to explain the allocation
+func (m *IPPoolManager) poolBitmap(poolIndex int) *addressBitmap {
+	if m.trace != nil || poolIndex >= len(m.usedIndexes) {
+		return nil
+	}
+	return m.usedIndexes[poolIndex]
+}
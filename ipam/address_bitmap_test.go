@@ -0,0 +1,94 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/klogr"
+	"k8s.io/utils/pointer"
+)
+
+var _ = Describe("Address bitmap", func() {
+
+	It("Finds the next free index", func() {
+		bitmap := newAddressBitmap()
+		Expect(bitmap.nextFree(0)).To(Equal(0))
+		for i := 0; i < 130; i++ {
+			bitmap.set(i)
+		}
+		bitmap.set(131)
+		Expect(bitmap.nextFree(0)).To(Equal(130))
+		Expect(bitmap.nextFree(131)).To(Equal(132))
+		Expect(bitmap.nextFree(200)).To(Equal(200))
+
+		bitmap.clear(64)
+		Expect(bitmap.nextFree(0)).To(Equal(64))
+		bitmap.clear(131)
+		Expect(bitmap.words).To(HaveLen(3))
+		for i := 128; i < 130; i++ {
+			bitmap.clear(i)
+		}
+		Expect(bitmap.words).To(HaveLen(2))
+	})
+
+	It("Skips the used addresses of the pools", func() {
+		ipPool := &ipamv1.IPPool{
+			ObjectMeta: metav1.ObjectMeta{Name: "abc", Namespace: "myns"},
+			Spec: ipamv1.IPPoolSpec{
+				Pools: []ipamv1.Pool{
+					{
+						Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("10.0.0.1")),
+						End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("10.0.255.254")),
+					},
+				},
+			},
+		}
+		ipPoolMgr, err := NewIPPoolManager(nil, ipPool, klogr.New())
+		Expect(err).NotTo(HaveOccurred())
+
+		addresses := map[ipamv1.IPAddressStr]string{}
+		for i := 1; i <= 1000; i++ {
+			addresses[ipamv1.IPAddressStr(fmt.Sprintf("10.0.%d.%d", i/256, i%256))] = fmt.Sprintf("claim-%d", i)
+		}
+		ipPoolMgr.buildUsedIndexes(addresses)
+		Expect(ipPoolMgr.poolBitmap(0).nextFree(0)).To(Equal(1000))
+
+		claim := &ipamv1.IPClaim{ObjectMeta: metav1.ObjectMeta{Name: "bcd", Namespace: "myns"}}
+		allocation, _, err := ipPoolMgr.allocateRoleAddress(claim, "", addresses, anyPool)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(allocation.address).To(Equal(ipamv1.IPAddressStr("10.0.3.233")))
+
+		// An address allocated after the bitmap was built is still skipped
+		addresses["10.0.3.233"] = "bcd"
+		allocation, _, err = ipPoolMgr.allocateRoleAddress(claim, "", addresses, anyPool)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(allocation.address).To(Equal(ipamv1.IPAddressStr("10.0.3.234")))
+
+		// A released address is allocated again
+		delete(addresses, "10.0.0.10")
+		ipPoolMgr.markAddress("10.0.0.10", false)
+		allocation, _, err = ipPoolMgr.allocateRoleAddress(claim, "", addresses, anyPool)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(allocation.address).To(Equal(ipamv1.IPAddressStr("10.0.0.10")))
+	})
+})
@@ -0,0 +1,410 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/metal3-io/ip-address-manager/api/ipamclient"
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// DefaultAllocationBindTimeout is the default duration an allocation
+	// request waits for its address
+	DefaultAllocationBindTimeout = 30 * time.Second
+	// allocationAPIPrefix is the path prefix of the allocation API
+	allocationAPIPrefix = "/v1/namespaces/"
+	// allocationShutdownTimeout is the duration the allocation API waits for
+	// the requests in progress when the manager stops
+	allocationShutdownTimeout = 5 * time.Second
+	// allocationReadHeaderTimeout and allocationReadTimeout bound the
+	// duration of reading the headers and the body of the requests
+	allocationReadHeaderTimeout = 10 * time.Second
+	allocationReadTimeout       = 30 * time.Second
+	// allocationMaxBodySize is the maximum size of the body of the requests
+	allocationMaxBodySize = 64 * 1024
+)
+
+// reservedLabelDomains are the domains of the labels reserved to Cluster API
+// and to the controller, that the clients of the allocation API can not set
+// on their claims. They would otherwise pull the addresses into the deletion
+// of a cluster, or into the watch filter of the controller.
+var reservedLabelDomains = []string{"cluster.x-k8s.io", "ipam.metal3.io"}
+
+// AllocationRequest is the body of the allocation requests
+type AllocationRequest struct {
+	// Name is the name of the IPClaim created for the allocation
+	Name string `json:"name"`
+	// Pool is the name of the IPPool, in the namespace of the allocation, or
+	// of the ClusterIPPool
+	Pool string `json:"pool"`
+	// PoolKind is IPPool, the default, or ClusterIPPool
+	PoolKind string `json:"poolKind,omitempty"`
+	// Labels are the labels of the IPClaim, copied to its IPAddress
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// Allocation is an address allocated through the allocation API. The address
+// is empty while the allocation is pending, and the error reports why.
+type Allocation struct {
+	Name       string                `json:"name"`
+	Namespace  string                `json:"namespace"`
+	Pool       string                `json:"pool"`
+	Address    ipamv1.IPAddressStr   `json:"address,omitempty"`
+	Prefix     int                   `json:"prefix,omitempty"`
+	Gateway    *ipamv1.IPAddressStr  `json:"gateway,omitempty"`
+	DNSServers []ipamv1.IPAddressStr `json:"dnsServers,omitempty"`
+	Error      string                `json:"error,omitempty"`
+}
+
+// AllocationServer serves the allocation API, letting the systems outside of
+// the cluster allocate and release addresses of the IPPools over HTTP. Each
+// allocation is an IPClaim created on their behalf and labelled with the
+// AllocationAPILabel. The clients authenticate with a bearer token, and can
+// only allocate in the namespaces of the allow-list.
+type AllocationServer struct {
+	client *ipamclient.Client
+	// Addr is the address the API listens on
+	Addr string
+	// TokenFile is the file containing the bearer token of the clients, read
+	// at each request so that it can be rotated
+	TokenFile string
+	// Namespaces are the namespaces the clients can allocate in, the
+	// requests for the other namespaces are forbidden
+	Namespaces []string
+	// CertFile and KeyFile are the TLS certificate and key of the API
+	CertFile string
+	KeyFile  string
+	// Insecure serves the API over plain HTTP when no TLS certificate is
+	// given, sending the bearer token in clear
+	Insecure bool
+	// BindTimeout is the duration a request waits for the address to be
+	// allocated, or released, before returning the pending allocation
+	BindTimeout time.Duration
+	Log         logr.Logger
+}
+
+// NewAllocationServer returns the allocation API listening on the address and
+// serving the namespaces
+func NewAllocationServer(cl client.Client, addr, tokenFile string, namespaces []string,
+	log logr.Logger,
+) *AllocationServer {
+	return &AllocationServer{
+		client:      ipamclient.New(cl),
+		Addr:        addr,
+		TokenFile:   tokenFile,
+		Namespaces:  namespaces,
+		BindTimeout: DefaultAllocationBindTimeout,
+		Log:         log,
+	}
+}
+
+// Start serves the API until the context is done, it implements the Runnable
+// of the manager
+func (s *AllocationServer) Start(ctx context.Context) error {
+	if s.CertFile == "" && !s.Insecure {
+		return errors.New("the allocation API requires a TLS certificate unless it is insecure")
+	}
+	server := &http.Server{
+		Addr:              s.Addr,
+		Handler:           s,
+		ReadHeaderTimeout: allocationReadHeaderTimeout,
+		ReadTimeout:       allocationReadTimeout,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), allocationShutdownTimeout)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+	s.Log.Info("Serving the allocation API", "address", s.Addr, "tls", s.CertFile != "")
+	var err error
+	if s.CertFile != "" {
+		err = server.ListenAndServeTLS(s.CertFile, s.KeyFile)
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// NeedLeaderElection returns false, the API is served by all the replicas
+func (s *AllocationServer) NeedLeaderElection() bool {
+	return false
+}
+
+// ServeHTTP routes the requests of the allocation API:
+// POST /v1/namespaces/<namespace>/allocations allocates an address,
+// GET and DELETE /v1/namespaces/<namespace>/allocations/<name> read and
+// release it
+func (s *AllocationServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authenticated(r) {
+		writeAllocationError(w, http.StatusUnauthorized, "invalid bearer token")
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, allocationAPIPrefix), "/")
+	if !strings.HasPrefix(r.URL.Path, allocationAPIPrefix) || len(parts) < 2 || len(parts) > 3 ||
+		parts[0] == "" || parts[1] != "allocations" || (len(parts) == 3 && parts[2] == "") {
+		writeAllocationError(w, http.StatusNotFound, "unknown path")
+		return
+	}
+	namespace := parts[0]
+	if !s.namespaceAllowed(namespace) {
+		writeAllocationError(w, http.StatusForbidden, "namespace "+namespace+" not allowed")
+		return
+	}
+	switch {
+	case len(parts) == 2 && r.Method == http.MethodPost:
+		s.allocate(w, r, namespace)
+	case len(parts) == 3 && r.Method == http.MethodGet:
+		s.get(w, r, client.ObjectKey{Name: parts[2], Namespace: namespace})
+	case len(parts) == 3 && r.Method == http.MethodDelete:
+		s.release(w, r, client.ObjectKey{Name: parts[2], Namespace: namespace})
+	default:
+		writeAllocationError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// authenticated returns true if the request carries the bearer token
+func (s *AllocationServer) authenticated(r *http.Request) bool {
+	token, err := ioutil.ReadFile(s.TokenFile)
+	if err != nil {
+		s.Log.Error(err, "Unable to read the token of the allocation API")
+		return false
+	}
+	expected := "Bearer " + strings.TrimSpace(string(token))
+	if expected == "Bearer " {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(expected)) == 1
+}
+
+// namespaceAllowed returns true if the namespace is in the allow-list
+func (s *AllocationServer) namespaceAllowed(namespace string) bool {
+	for _, allowed := range s.Namespaces {
+		if allowed == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// allocate creates the IPClaim of the allocation, or reuses the one of a
+// previous request, and waits for its address
+func (s *AllocationServer) allocate(w http.ResponseWriter, r *http.Request, namespace string) {
+	request := AllocationRequest{}
+	r.Body = http.MaxBytesReader(w, r.Body, allocationMaxBodySize)
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeAllocationError(w, http.StatusBadRequest, "invalid request: "+err.Error())
+		return
+	}
+	if request.Name == "" || request.Pool == "" {
+		writeAllocationError(w, http.StatusBadRequest, "the name and pool are required")
+		return
+	}
+	if request.PoolKind != "" && request.PoolKind != ipamv1.ClusterIPPoolKind && request.PoolKind != "IPPool" {
+		writeAllocationError(w, http.StatusBadRequest, "the pool kind must be IPPool or ClusterIPPool")
+		return
+	}
+	for labelKey := range request.Labels {
+		if reservedLabel(labelKey) {
+			writeAllocationError(w, http.StatusBadRequest, "the label "+labelKey+" is reserved")
+			return
+		}
+	}
+
+	key := client.ObjectKey{Name: request.Name, Namespace: namespace}
+	claim := &ipamv1.IPClaim{}
+	err := s.client.Get(r.Context(), key, claim)
+	if err != nil && !apierrors.IsNotFound(err) {
+		writeAllocationAPIError(w, err)
+		return
+	}
+	if err == nil && claim.Labels[ipamv1.AllocationAPILabel] != "true" {
+		writeAllocationError(w, http.StatusConflict, "the IPClaim exists and was not created by the allocation API")
+		return
+	}
+	if apierrors.IsNotFound(err) {
+		claim = newAllocationClaim(key, request)
+		if err := s.client.Create(r.Context(), claim); err != nil && !apierrors.IsAlreadyExists(err) {
+			writeAllocationAPIError(w, err)
+			return
+		}
+		s.Log.Info("Allocation requested", "IPClaim", key.String(), "pool", request.Pool)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.BindTimeout)
+	defer cancel()
+	address, err := s.client.WaitForBinding(ctx, key)
+	allocation := Allocation{Name: key.Name, Namespace: key.Namespace, Pool: claim.Spec.Pool.Name}
+	if err != nil {
+		allocation.Error = err.Error()
+		writeAllocation(w, http.StatusAccepted, allocation)
+		return
+	}
+	setAllocationAddress(&allocation, address)
+	writeAllocation(w, http.StatusCreated, allocation)
+}
+
+// get returns the allocation of the IPClaim
+func (s *AllocationServer) get(w http.ResponseWriter, r *http.Request, key client.ObjectKey) {
+	claim, ok := s.getClaim(w, r, key)
+	if !ok {
+		return
+	}
+	allocation := Allocation{Name: key.Name, Namespace: key.Namespace, Pool: claim.Spec.Pool.Name}
+	if claim.Status.ErrorMessage != nil {
+		allocation.Error = *claim.Status.ErrorMessage
+	}
+	if claim.Status.Address != nil {
+		address := &ipamv1.IPAddress{}
+		addressKey := client.ObjectKey{
+			Name:      claim.Status.Address.Name,
+			Namespace: claim.Status.Address.Namespace,
+		}
+		if addressKey.Namespace == "" {
+			addressKey.Namespace = claim.Namespace
+		}
+		if err := s.client.Get(r.Context(), addressKey, address); err != nil {
+			writeAllocationAPIError(w, err)
+			return
+		}
+		setAllocationAddress(&allocation, address)
+	}
+	writeAllocation(w, http.StatusOK, allocation)
+}
+
+// release deletes the IPClaim and waits until its address is released
+func (s *AllocationServer) release(w http.ResponseWriter, r *http.Request, key client.ObjectKey) {
+	if _, ok := s.getClaim(w, r, key); !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), s.BindTimeout)
+	defer cancel()
+	s.Log.Info("Release requested", "IPClaim", key.String())
+	if err := s.client.ReleaseClaim(ctx, key); err != nil {
+		writeAllocation(w, http.StatusAccepted, Allocation{
+			Name: key.Name, Namespace: key.Namespace, Error: err.Error(),
+		})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getClaim returns the IPClaim of an allocation. The claims not created by
+// the allocation API are not found.
+func (s *AllocationServer) getClaim(w http.ResponseWriter, r *http.Request,
+	key client.ObjectKey,
+) (*ipamv1.IPClaim, bool) {
+	claim := &ipamv1.IPClaim{}
+	if err := s.client.Get(r.Context(), key, claim); err != nil {
+		writeAllocationAPIError(w, err)
+		return nil, false
+	}
+	if claim.Labels[ipamv1.AllocationAPILabel] != "true" {
+		writeAllocationError(w, http.StatusNotFound, "no allocation "+key.String())
+		return nil, false
+	}
+	return claim, true
+}
+
+// reservedLabel returns true if the domain of the label key is, or is a
+// subdomain of, one of the reserved domains
+func reservedLabel(key string) bool {
+	slash := strings.Index(key, "/")
+	if slash < 0 {
+		return false
+	}
+	domain := key[:slash]
+	for _, reserved := range reservedLabelDomains {
+		if domain == reserved || strings.HasSuffix(domain, "."+reserved) {
+			return true
+		}
+	}
+	return false
+}
+
+// newAllocationClaim returns the IPClaim of the allocation request
+func newAllocationClaim(key client.ObjectKey, request AllocationRequest) *ipamv1.IPClaim {
+	labels := make(map[string]string, len(request.Labels)+1)
+	for labelKey, value := range request.Labels {
+		labels[labelKey] = value
+	}
+	labels[ipamv1.AllocationAPILabel] = "true"
+	pool := corev1.ObjectReference{Name: request.Pool, Namespace: key.Namespace}
+	if request.PoolKind == ipamv1.ClusterIPPoolKind {
+		pool = corev1.ObjectReference{Name: request.Pool, Kind: ipamv1.ClusterIPPoolKind}
+	}
+	return &ipamv1.IPClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      key.Name,
+			Namespace: key.Namespace,
+			Labels:    labels,
+		},
+		Spec: ipamv1.IPClaimSpec{
+			Pool: pool,
+		},
+	}
+}
+
+// setAllocationAddress sets the allocated address from the IPAddress
+func setAllocationAddress(allocation *Allocation, address *ipamv1.IPAddress) {
+	allocation.Address = address.Spec.Address
+	allocation.Prefix = address.Spec.Prefix
+	allocation.Gateway = address.Spec.Gateway
+	allocation.DNSServers = address.Spec.DNSServers
+	allocation.Error = ""
+}
+
+// writeAllocation writes the allocation as JSON with the status code
+func writeAllocation(w http.ResponseWriter, code int, allocation Allocation) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(allocation)
+}
+
+// writeAllocationAPIError writes the error of the Kubernetes API with its
+// status code
+func writeAllocationAPIError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	if status, ok := errors.Cause(err).(apierrors.APIStatus); ok && status.Status().Code != 0 {
+		code = int(status.Status().Code)
+	}
+	writeAllocationError(w, code, err.Error())
+}
+
+// writeAllocationError writes the error as JSON with the status code
+func writeAllocationError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
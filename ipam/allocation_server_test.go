@@ -0,0 +1,328 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/klogr"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Allocation API", func() {
+
+	apiClaim := func(name string, bound bool) *ipamv1.IPClaim {
+		claim := &ipamv1.IPClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "myns",
+				Labels:    map[string]string{ipamv1.AllocationAPILabel: "true"},
+			},
+			Spec: ipamv1.IPClaimSpec{
+				Pool: corev1.ObjectReference{Name: "pool1", Namespace: "myns"},
+			},
+		}
+		if bound {
+			claim.Status.Address = &corev1.ObjectReference{Name: "pool1-192-168-0-11"}
+		}
+		return claim
+	}
+
+	type testCaseAllocationAPI struct {
+		method             string
+		path               string
+		token              string
+		body               interface{}
+		objects            []client.Object
+		expectedCode       int
+		expectedAllocation *Allocation
+		expectedClaim      *ipamv1.IPClaim
+		expectClaimDeleted bool
+	}
+
+	DescribeTable("Test the allocation API",
+		func(tc testCaseAllocationAPI) {
+			dir, err := ioutil.TempDir("", "allocation")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(dir)
+			tokenFile := filepath.Join(dir, "token")
+			Expect(ioutil.WriteFile(tokenFile, []byte("api-token\n"), 0600)).To(Succeed())
+
+			objects := append([]client.Object{
+				&ipamv1.IPAddress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "pool1-192-168-0-11",
+						Namespace: "myns",
+					},
+					Spec: ipamv1.IPAddressSpec{
+						Address:    "192.168.0.11",
+						Prefix:     24,
+						Gateway:    (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.1")),
+						DNSServers: []ipamv1.IPAddressStr{"8.8.8.8"},
+					},
+				},
+			}, tc.objects...)
+			c := fakeclient.NewClientBuilder().WithScheme(setupScheme()).WithObjects(objects...).Build()
+			server := NewAllocationServer(c, "", tokenFile, []string{"myns"}, klogr.New())
+			server.BindTimeout = 50 * time.Millisecond
+			server.client.PollInterval = 10 * time.Millisecond
+
+			body := []byte{}
+			if tc.body != nil {
+				body, err = json.Marshal(tc.body)
+				Expect(err).NotTo(HaveOccurred())
+			}
+			request := httptest.NewRequest(tc.method, tc.path, bytes.NewReader(body))
+			request.Header.Set("Authorization", "Bearer "+tc.token)
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, request)
+
+			Expect(recorder.Code).To(Equal(tc.expectedCode))
+			if tc.expectedAllocation != nil {
+				allocation := Allocation{}
+				Expect(json.NewDecoder(recorder.Body).Decode(&allocation)).To(Succeed())
+				if tc.expectedAllocation.Error != "" {
+					Expect(allocation.Error).NotTo(BeEmpty())
+					allocation.Error = tc.expectedAllocation.Error
+				}
+				Expect(allocation).To(Equal(*tc.expectedAllocation))
+			}
+			if tc.expectedClaim != nil {
+				claim := &ipamv1.IPClaim{}
+				Expect(c.Get(context.TODO(), client.ObjectKeyFromObject(tc.expectedClaim), claim)).To(Succeed())
+				Expect(claim.Labels).To(Equal(tc.expectedClaim.Labels))
+				Expect(claim.Spec.Pool).To(Equal(tc.expectedClaim.Spec.Pool))
+			}
+			if tc.expectClaimDeleted {
+				err := c.Get(context.TODO(), client.ObjectKey{Name: "node-1", Namespace: "myns"}, &ipamv1.IPClaim{})
+				Expect(apierrors.IsNotFound(err)).To(BeTrue())
+			}
+		},
+		Entry("Invalid token", testCaseAllocationAPI{
+			method:       http.MethodGet,
+			path:         "/v1/namespaces/myns/allocations/node-1",
+			token:        "other",
+			expectedCode: http.StatusUnauthorized,
+		}),
+		Entry("Unknown path", testCaseAllocationAPI{
+			method:       http.MethodGet,
+			path:         "/v1/namespaces/myns/claims/node-1",
+			token:        "api-token",
+			expectedCode: http.StatusNotFound,
+		}),
+		Entry("Namespace not allowed", testCaseAllocationAPI{
+			method:       http.MethodPost,
+			path:         "/v1/namespaces/kube-system/allocations",
+			token:        "api-token",
+			body:         AllocationRequest{Name: "node-1", Pool: "pool1"},
+			expectedCode: http.StatusForbidden,
+		}),
+		Entry("Method not allowed", testCaseAllocationAPI{
+			method:       http.MethodPut,
+			path:         "/v1/namespaces/myns/allocations/node-1",
+			token:        "api-token",
+			expectedCode: http.StatusMethodNotAllowed,
+		}),
+		Entry("Allocation pending", testCaseAllocationAPI{
+			method: http.MethodPost,
+			path:   "/v1/namespaces/myns/allocations",
+			token:  "api-token",
+			body: AllocationRequest{
+				Name:   "node-1",
+				Pool:   "pool1",
+				Labels: map[string]string{"site": "lab"},
+			},
+			expectedCode: http.StatusAccepted,
+			expectedAllocation: &Allocation{
+				Name: "node-1", Namespace: "myns", Pool: "pool1", Error: "not bound",
+			},
+			expectedClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "node-1",
+					Namespace: "myns",
+					Labels: map[string]string{
+						"site":                    "lab",
+						ipamv1.AllocationAPILabel: "true",
+					},
+				},
+				Spec: ipamv1.IPClaimSpec{
+					Pool: corev1.ObjectReference{Name: "pool1", Namespace: "myns"},
+				},
+			},
+		}),
+		Entry("Allocation from a ClusterIPPool pending", testCaseAllocationAPI{
+			method: http.MethodPost,
+			path:   "/v1/namespaces/myns/allocations",
+			token:  "api-token",
+			body: AllocationRequest{
+				Name:     "node-1",
+				Pool:     "pool1",
+				PoolKind: ipamv1.ClusterIPPoolKind,
+			},
+			expectedCode: http.StatusAccepted,
+			expectedAllocation: &Allocation{
+				Name: "node-1", Namespace: "myns", Pool: "pool1", Error: "not bound",
+			},
+			expectedClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "node-1",
+					Namespace: "myns",
+					Labels:    map[string]string{ipamv1.AllocationAPILabel: "true"},
+				},
+				Spec: ipamv1.IPClaimSpec{
+					Pool: corev1.ObjectReference{Name: "pool1", Kind: ipamv1.ClusterIPPoolKind},
+				},
+			},
+		}),
+		Entry("Allocation repeated once bound", testCaseAllocationAPI{
+			method:       http.MethodPost,
+			path:         "/v1/namespaces/myns/allocations",
+			token:        "api-token",
+			body:         AllocationRequest{Name: "node-1", Pool: "pool1"},
+			objects:      []client.Object{apiClaim("node-1", true)},
+			expectedCode: http.StatusCreated,
+			expectedAllocation: &Allocation{
+				Name:       "node-1",
+				Namespace:  "myns",
+				Pool:       "pool1",
+				Address:    "192.168.0.11",
+				Prefix:     24,
+				Gateway:    (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.1")),
+				DNSServers: []ipamv1.IPAddressStr{"8.8.8.8"},
+			},
+		}),
+		Entry("Allocation of an IPClaim not created by the API", testCaseAllocationAPI{
+			method: http.MethodPost,
+			path:   "/v1/namespaces/myns/allocations",
+			token:  "api-token",
+			body:   AllocationRequest{Name: "node-1", Pool: "pool1"},
+			objects: []client.Object{
+				&ipamv1.IPClaim{
+					ObjectMeta: metav1.ObjectMeta{Name: "node-1", Namespace: "myns"},
+				},
+			},
+			expectedCode: http.StatusConflict,
+		}),
+		Entry("Allocation without pool", testCaseAllocationAPI{
+			method:       http.MethodPost,
+			path:         "/v1/namespaces/myns/allocations",
+			token:        "api-token",
+			body:         AllocationRequest{Name: "node-1"},
+			expectedCode: http.StatusBadRequest,
+		}),
+		Entry("Allocation with a reserved label", testCaseAllocationAPI{
+			method: http.MethodPost,
+			path:   "/v1/namespaces/myns/allocations",
+			token:  "api-token",
+			body: AllocationRequest{
+				Name:   "node-1",
+				Pool:   "pool1",
+				Labels: map[string]string{"cluster.x-k8s.io/cluster-name": "cluster1"},
+			},
+			expectedCode: http.StatusBadRequest,
+		}),
+		Entry("Allocation with a label of a reserved subdomain", testCaseAllocationAPI{
+			method: http.MethodPost,
+			path:   "/v1/namespaces/myns/allocations",
+			token:  "api-token",
+			body: AllocationRequest{
+				Name:   "node-1",
+				Pool:   "pool1",
+				Labels: map[string]string{"hold.ipam.metal3.io/backup": "true"},
+			},
+			expectedCode: http.StatusBadRequest,
+		}),
+		Entry("Allocation with a body too large", testCaseAllocationAPI{
+			method: http.MethodPost,
+			path:   "/v1/namespaces/myns/allocations",
+			token:  "api-token",
+			body: AllocationRequest{
+				Name:   "node-1",
+				Pool:   "pool1",
+				Labels: map[string]string{"site": strings.Repeat("a", allocationMaxBodySize)},
+			},
+			expectedCode: http.StatusBadRequest,
+		}),
+		Entry("Allocation read", testCaseAllocationAPI{
+			method:       http.MethodGet,
+			path:         "/v1/namespaces/myns/allocations/node-1",
+			token:        "api-token",
+			objects:      []client.Object{apiClaim("node-1", true)},
+			expectedCode: http.StatusOK,
+			expectedAllocation: &Allocation{
+				Name:       "node-1",
+				Namespace:  "myns",
+				Pool:       "pool1",
+				Address:    "192.168.0.11",
+				Prefix:     24,
+				Gateway:    (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.1")),
+				DNSServers: []ipamv1.IPAddressStr{"8.8.8.8"},
+			},
+		}),
+		Entry("Allocation not found", testCaseAllocationAPI{
+			method:       http.MethodGet,
+			path:         "/v1/namespaces/myns/allocations/node-1",
+			token:        "api-token",
+			expectedCode: http.StatusNotFound,
+		}),
+		Entry("Allocation of an IPClaim not created by the API hidden", testCaseAllocationAPI{
+			method: http.MethodGet,
+			path:   "/v1/namespaces/myns/allocations/node-1",
+			token:  "api-token",
+			objects: []client.Object{
+				&ipamv1.IPClaim{
+					ObjectMeta: metav1.ObjectMeta{Name: "node-1", Namespace: "myns"},
+				},
+			},
+			expectedCode: http.StatusNotFound,
+		}),
+		Entry("Allocation released", testCaseAllocationAPI{
+			method:             http.MethodDelete,
+			path:               "/v1/namespaces/myns/allocations/node-1",
+			token:              "api-token",
+			objects:            []client.Object{apiClaim("node-1", true)},
+			expectedCode:       http.StatusNoContent,
+			expectClaimDeleted: true,
+		}),
+	)
+
+	It("Refuses to serve over plain HTTP unless insecure", func() {
+		server := NewAllocationServer(fakeclient.NewClientBuilder().Build(), "127.0.0.1:0", "token",
+			[]string{"myns"}, klogr.New(),
+		)
+		Expect(server.Start(context.TODO())).NotTo(Succeed())
+	})
+})
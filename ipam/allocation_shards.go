@@ -0,0 +1,190 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// allocationShardSize is the number of allocations a shard is sized for, to
+// keep it well under the size limit of the objects. The allocations are
+// spread over the shards by a hash of their key, and the number of shards is
+// doubled whenever they would hold more allocations on average, so that an
+// allocation only updates the shard of its key.
+const allocationShardSize = 5000
+
+// allocationShardName returns the name of the shard of the IPPool
+func allocationShardName(pool string, index int) string {
+	return fmt.Sprintf("%s-allocations-%d", pool, index)
+}
+
+// allocationShardIndex returns the index of the shard of the allocation key
+func allocationShardIndex(key string, shards int) int {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(key))
+	return int(hash.Sum32() % uint32(shards))
+}
+
+// allocationShardCount returns the number of shards the allocations of the
+// pool are recorded in, zero if they are recorded in the status. Only the
+// IPPools are sharded, the shards being namespaced.
+func (m *IPPoolManager) allocationShardCount() int {
+	allocations := len(m.IPPool.Status.Allocations)
+	if m.ShardingThreshold == 0 || m.IPPool.IsClusterScoped() || allocations <= m.ShardingThreshold {
+		return 0
+	}
+	shards := 1
+	for allocations > shards*allocationShardSize {
+		shards *= 2
+	}
+	return shards
+}
+
+// shardAllocations records the allocations of the pool in
+// IPPoolAllocationShard objects if they are above the sharding threshold, and
+// removes them from the status. The shards of a pool back under the
+// threshold are deleted. The allocations are left in the status if the
+// shards cannot be written, to be sharded again by the next reconcile.
+func (m *IPPoolManager) shardAllocations(ctx context.Context) {
+	shards := m.allocationShardCount()
+	if shards == 0 && m.IPPool.Status.AllocationShards == 0 {
+		return
+	}
+	if err := m.writeAllocationShards(ctx, shards); err != nil {
+		m.Log.Info("Unable to record the allocations in shards", "Error", err.Error())
+		return
+	}
+	m.IPPool.Status.AllocationShards = shards
+	if shards != 0 {
+		m.IPPool.Status.Allocations = nil
+	}
+}
+
+// writeAllocationShards spreads the allocations of the pool over the given
+// number of shards. Only the shards whose allocations changed are updated,
+// the other shards of the pool are deleted.
+func (m *IPPoolManager) writeAllocationShards(ctx context.Context, shards int) error {
+	allocations := make([]map[string]ipamv1.IPAddressStr, shards)
+	for i := range allocations {
+		allocations[i] = make(map[string]ipamv1.IPAddressStr)
+	}
+	if shards != 0 {
+		for key, address := range m.IPPool.Status.Allocations {
+			index := allocationShardIndex(key, shards)
+			allocations[index][key] = address
+		}
+	}
+
+	existing, err := m.listAllocationShards(ctx)
+	if err != nil {
+		return err
+	}
+	written := make([]bool, shards)
+	for i := range existing {
+		shard := &existing[i]
+		index := shard.Spec.Index
+		if index >= shards || shard.Name != allocationShardName(m.IPPool.Name, index) {
+			if err := m.client.Delete(ctx, shard); err != nil && !apierrors.IsNotFound(err) {
+				return err
+			}
+			continue
+		}
+		written[index] = true
+		if reflect.DeepEqual(shard.Spec.Allocations, allocations[index]) {
+			continue
+		}
+		shard.Spec.Allocations = allocations[index]
+		if err := m.client.Update(ctx, shard); err != nil {
+			return err
+		}
+	}
+	for index := range written {
+		if written[index] {
+			continue
+		}
+		shard := &ipamv1.IPPoolAllocationShard{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      allocationShardName(m.IPPool.Name, index),
+				Namespace: m.IPPool.Namespace,
+				Labels: map[string]string{
+					ipamv1.IPPoolAllocationShardLabel: m.IPPool.Name,
+				},
+				OwnerReferences: []metav1.OwnerReference{
+					{
+						APIVersion: ipamv1.GroupVersion.String(),
+						Kind:       "IPPool",
+						Name:       m.IPPool.Name,
+						UID:        m.IPPool.UID,
+						Controller: pointer.BoolPtr(true),
+					},
+				},
+			},
+			Spec: ipamv1.IPPoolAllocationShardSpec{
+				Pool:        m.IPPool.Name,
+				Index:       index,
+				Allocations: allocations[index],
+			},
+		}
+		if err := m.client.Create(ctx, shard); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listAllocationShards lists the shards of the pool
+func (m *IPPoolManager) listAllocationShards(ctx context.Context) ([]ipamv1.IPPoolAllocationShard, error) {
+	shards := ipamv1.IPPoolAllocationShardList{}
+	if err := m.client.List(ctx, &shards, client.InNamespace(m.IPPool.Namespace),
+		client.MatchingLabels{ipamv1.IPPoolAllocationShardLabel: m.IPPool.Name},
+	); err != nil {
+		return nil, err
+	}
+	return shards.Items, nil
+}
+
+// loadShardedAllocations reads the allocations of the pool back from its
+// shards into its status, for the readers of the allocations that do not
+// rebuild them from the IPAddress objects
+func (m *IPPoolManager) loadShardedAllocations(ctx context.Context) error {
+	if m.IPPool.Status.AllocationShards == 0 || len(m.IPPool.Status.Allocations) != 0 {
+		return nil
+	}
+	shards, err := m.listAllocationShards(ctx)
+	if err != nil {
+		return err
+	}
+	m.IPPool.Status.Allocations = make(map[string]ipamv1.IPAddressStr)
+	for _, shard := range shards {
+		if shard.Spec.Index >= m.IPPool.Status.AllocationShards {
+			continue
+		}
+		for key, address := range shard.Spec.Allocations {
+			m.IPPool.Status.Allocations[key] = address
+		}
+	}
+	return nil
+}
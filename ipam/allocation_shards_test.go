@@ -0,0 +1,150 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"context"
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/klogr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Allocation shards", func() {
+
+	newAllocations := func(count int) map[string]ipamv1.IPAddressStr {
+		allocations := make(map[string]ipamv1.IPAddressStr, count)
+		for i := 0; i < count; i++ {
+			allocations[fmt.Sprintf("claim-%d", i)] = ipamv1.IPAddressStr(
+				fmt.Sprintf("10.0.%d.%d", i/256, i%256),
+			)
+		}
+		return allocations
+	}
+
+	listShards := func(c client.Client) []ipamv1.IPPoolAllocationShard {
+		shards := ipamv1.IPPoolAllocationShardList{}
+		Expect(c.List(context.TODO(), &shards, client.InNamespace("myns"))).To(Succeed())
+		return shards.Items
+	}
+
+	It("Records the allocations above the threshold in shards", func() {
+		allocations := newAllocations(allocationShardSize + 1000)
+		ipPool := &ipamv1.IPPool{
+			ObjectMeta: metav1.ObjectMeta{Name: "abc", Namespace: "myns", UID: "abc-uid"},
+			Status: ipamv1.IPPoolStatus{
+				Allocations: newAllocations(allocationShardSize + 1000),
+			},
+		}
+		c := fakeclient.NewClientBuilder().WithScheme(setupScheme()).Build()
+		ipPoolMgr, err := NewIPPoolManager(c, ipPool, klogr.New())
+		Expect(err).NotTo(HaveOccurred())
+		ipPoolMgr.ShardingThreshold = 100
+
+		ipPoolMgr.shardAllocations(context.TODO())
+		Expect(ipPool.Status.AllocationShards).To(Equal(2))
+		Expect(ipPool.Status.Allocations).To(BeNil())
+		shards := listShards(c)
+		Expect(shards).To(HaveLen(2))
+		versions := map[string]string{}
+		for _, shard := range shards {
+			Expect(shard.Name).To(Equal(fmt.Sprintf("abc-allocations-%d", shard.Spec.Index)))
+			Expect(shard.Labels[ipamv1.IPPoolAllocationShardLabel]).To(Equal("abc"))
+			Expect(shard.OwnerReferences).To(HaveLen(1))
+			Expect(shard.OwnerReferences[0].UID).To(BeEquivalentTo("abc-uid"))
+			versions[shard.Name] = shard.ResourceVersion
+		}
+
+		Expect(ipPoolMgr.loadShardedAllocations(context.TODO())).To(Succeed())
+		Expect(ipPool.Status.Allocations).To(Equal(allocations))
+
+		// Only the shard of a new allocation is updated
+		ipPool.Status.Allocations["claim-new"] = "10.1.0.1"
+		ipPoolMgr.shardAllocations(context.TODO())
+		updated := allocationShardName("abc", allocationShardIndex("claim-new", 2))
+		for _, shard := range listShards(c) {
+			if shard.Name == updated {
+				Expect(shard.Spec.Allocations).To(HaveKey("claim-new"))
+				Expect(shard.ResourceVersion).NotTo(Equal(versions[shard.Name]))
+			} else {
+				Expect(shard.ResourceVersion).To(Equal(versions[shard.Name]))
+			}
+		}
+
+		// The shards are deleted once the pool is back under the threshold
+		ipPool.Status.Allocations = newAllocations(50)
+		ipPoolMgr.shardAllocations(context.TODO())
+		Expect(ipPool.Status.AllocationShards).To(Equal(0))
+		Expect(ipPool.Status.Allocations).To(HaveLen(50))
+		Expect(listShards(c)).To(BeEmpty())
+	})
+
+	It("Does not shard the allocations of the ClusterIPPools", func() {
+		clusterIPPool := &ipamv1.ClusterIPPool{
+			ObjectMeta: metav1.ObjectMeta{Name: "abc"},
+			Status: ipamv1.IPPoolStatus{
+				Allocations: newAllocations(200),
+			},
+		}
+		ipPool := clusterIPPool.AsIPPool()
+		c := fakeclient.NewClientBuilder().WithScheme(setupScheme()).Build()
+		ipPoolMgr, err := NewIPPoolManager(c, ipPool, klogr.New())
+		Expect(err).NotTo(HaveOccurred())
+		ipPoolMgr.ShardingThreshold = 100
+
+		ipPoolMgr.shardAllocations(context.TODO())
+		Expect(ipPool.Status.AllocationShards).To(Equal(0))
+		Expect(ipPool.Status.Allocations).To(HaveLen(200))
+	})
+
+	It("Verifies the sharded allocations", func() {
+		ipPool := &ipamv1.IPPool{
+			ObjectMeta: metav1.ObjectMeta{Name: "abc", Namespace: "myns"},
+			Status: ipamv1.IPPoolStatus{
+				AllocationShards: 1,
+			},
+		}
+		c := fakeclient.NewClientBuilder().WithScheme(setupScheme()).WithObjects(
+			&ipamv1.IPPoolAllocationShard{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "abc-allocations-0",
+					Namespace: "myns",
+					Labels:    map[string]string{ipamv1.IPPoolAllocationShardLabel: "abc"},
+				},
+				Spec: ipamv1.IPPoolAllocationShardSpec{
+					Pool:        "abc",
+					Allocations: map[string]ipamv1.IPAddressStr{"bcd": "192.168.0.11"},
+				},
+			},
+		).Build()
+		ipPoolMgr, err := NewIPPoolManager(c, ipPool, klogr.New())
+		Expect(err).NotTo(HaveOccurred())
+
+		discrepancies, err := ipPoolMgr.Verify(context.TODO())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(discrepancies).To(HaveLen(1))
+		Expect(discrepancies[0].Message).To(Equal(
+			"status.allocations[bcd] is 192.168.0.11 but there is no IPAddress for it",
+		))
+	})
+})
@@ -0,0 +1,286 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	"github.com/metal3-io/ip-address-manager/feature"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// maxBackendConflicts is the number of addresses found in use in the backend
+// that are skipped for a claim before failing its allocation
+const maxBackendConflicts = 16
+
+// ErrAddressInUse is returned by the backends reserving an address already
+// reserved for another owner
+var ErrAddressInUse = errors.New("the address is in use in the backend")
+
+// Backend is an external IPAM in which the allocated addresses are reserved
+type Backend interface {
+	// Reserve reserves the address and returns the identifier of the
+	// reservation. The reservation of the identifier, or of the owner if the
+	// identifier is unknown, is updated with the description and tags. It
+	// returns ErrAddressInUse if the address is reserved for another owner.
+	Reserve(ctx context.Context, address BackendAddress) (string, error)
+	// Release releases the reservation of the identifier, or of the owner if
+	// the identifier is unknown. Releasing an address not reserved succeeds.
+	Release(ctx context.Context, address BackendAddress) error
+}
+
+// BackendAddress is an address reserved in the backend of a pool
+type BackendAddress struct {
+	// ID identifies the reservation in the backend, empty if unknown
+	ID      string
+	Address ipamv1.IPAddressStr
+	Prefix  int
+	// Owner is "<namespace>/<name>" of the claim, followed by the role of the
+	// address if any. It is the default description, identifying the
+	// reservations whose identifier was lost.
+	Owner       string
+	Description string
+	Tags        []string
+}
+
+// metadataHash returns the hash of the description and tags of the address
+func (a BackendAddress) metadataHash() string {
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(a.Description + "\n" + strings.Join(a.Tags, ",")))
+	return strconv.FormatUint(hash.Sum64(), 16)
+}
+
+// BackendCredentials locates the credentials of the backends
+type BackendCredentials struct {
+	// NetBoxTokenFile is the file containing the API token of NetBox, given
+	// to the controller by its flags
+	NetBoxTokenFile string
+	// SecretReader reads the Secrets referenced by the backends, without
+	// caching them. The backends reading their credentials from Secrets are
+	// disabled without it.
+	SecretReader client.Reader
+}
+
+// NewBackend returns the driver of the backend, reading its credentials. The
+// namespace is the default namespace of the Secrets referenced by the
+// backend.
+func NewBackend(ctx context.Context, backend *ipamv1.Backend, namespace string,
+	credentials *BackendCredentials,
+) (Backend, error) {
+	switch {
+	case backend.NetBox != nil:
+		if credentials == nil || credentials.NetBoxTokenFile == "" {
+			return nil, errors.New("no NetBox token file given to the controller")
+		}
+		token, err := ioutil.ReadFile(credentials.NetBoxTokenFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read the NetBox token")
+		}
+		return newNetBoxBackend(backend.NetBox, strings.TrimSpace(string(token))), nil
+	case backend.Infoblox != nil:
+		if credentials == nil || credentials.SecretReader == nil {
+			return nil, errors.New("the controller does not read the Secrets, the Infoblox credentials cannot be read")
+		}
+		secret := &corev1.Secret{}
+		key := client.ObjectKey{
+			Name:      backend.Infoblox.CredentialsSecret.Name,
+			Namespace: backend.Infoblox.CredentialsSecret.Namespace,
+		}
+		if key.Namespace == "" {
+			key.Namespace = namespace
+		}
+		if err := credentials.SecretReader.Get(ctx, key, secret); err != nil {
+			return nil, errors.Wrap(err, "failed to get the Infoblox credentials")
+		}
+		username := string(secret.Data[corev1.BasicAuthUsernameKey])
+		password := string(secret.Data[corev1.BasicAuthPasswordKey])
+		if username == "" || password == "" {
+			return nil, errors.New(fmt.Sprintf(
+				"the Secret %s/%s does not contain the Infoblox username and password", key.Namespace, key.Name,
+			))
+		}
+		return newInfobloxBackend(backend.Infoblox, username, password), nil
+	}
+	return nil, errors.New("no backend given")
+}
+
+// getBackend returns the backend of the pool, nil if it has none
+func (m *IPPoolManager) getBackend(ctx context.Context) (Backend, error) {
+	if m.backend != nil || m.IPPool.Spec.Backend == nil {
+		return m.backend, nil
+	}
+	if !feature.Gates.Enabled(feature.ExternalBackends) {
+		return nil, errors.Errorf("the IPPool has a backend, but the %s feature gate is disabled",
+			feature.ExternalBackends,
+		)
+	}
+	backend, err := NewBackend(ctx, m.IPPool.Spec.Backend, m.IPPool.Namespace, m.BackendCredentials)
+	if err != nil {
+		return nil, err
+	}
+	m.backend = backend
+	return backend, nil
+}
+
+// newBackendAddress returns the address of the given role of a claim in the
+// backend, with the description and tags from the metadata of the claim
+func (m *IPPoolManager) newBackendAddress(addressClaim *ipamv1.IPClaim,
+	role string, address ipamv1.IPAddressStr, prefix int,
+) BackendAddress {
+	owner := addressClaim.Namespace + "/" + addressClaim.Name
+	if role != "" {
+		owner += " " + role
+	}
+	backendAddress := BackendAddress{
+		Address:     address,
+		Prefix:      prefix,
+		Owner:       owner,
+		Description: owner,
+	}
+	if description := addressClaim.Annotations[ipamv1.BackendDescriptionAnnotation]; description != "" {
+		backendAddress.Description = description
+	}
+	tags := []string{}
+	if m.IPPool.Spec.Backend.NetBox != nil {
+		tags = append(tags, m.IPPool.Spec.Backend.NetBox.Tags...)
+	}
+	for _, tag := range strings.Split(addressClaim.Annotations[ipamv1.BackendTagsAnnotation], ",") {
+		if tag = strings.TrimSpace(tag); tag != "" && !Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	backendAddress.Tags = tags
+	return backendAddress
+}
+
+// reserveAddresses reserves the addresses allocated to a claim in the backend
+// and records the reservations in the annotations of the IPAddresses. It
+// returns the first address found in use in the backend, if any.
+func (m *IPPoolManager) reserveAddresses(ctx context.Context, backend Backend,
+	addressClaim *ipamv1.IPClaim, roles []string,
+	allocations map[string]addressAllocation, annotations map[string]map[string]string,
+) (ipamv1.IPAddressStr, error) {
+	for _, role := range roles {
+		allocation := allocations[role]
+		backendAddress := m.newBackendAddress(addressClaim, role, allocation.address, allocation.prefix)
+		id, err := backend.Reserve(ctx, backendAddress)
+		if errors.Cause(err) == ErrAddressInUse {
+			m.Log.Info("Address in use in the backend", "Claim", addressClaim.Name, "address", allocation.address)
+			m.explain("address %s skipped, in use in the backend", allocation.address)
+			return allocation.address, nil
+		}
+		if err != nil {
+			addressClaim.Status.ErrorMessage = pointer.StringPtr("Failed to reserve the address in the backend")
+			return "", errors.Wrap(err, "failed to reserve the address in the backend")
+		}
+		annotations[role] = map[string]string{
+			ipamv1.BackendIDAnnotation:       id,
+			ipamv1.BackendMetadataAnnotation: backendAddress.metadataHash(),
+		}
+	}
+	return "", nil
+}
+
+// syncBackendMetadata updates the reservations of the addresses of a bound
+// claim whose description or tags changed since they were reserved. The hash
+// of the metadata recorded on the IPAddresses is compared first, so that the
+// backend is only reached when the metadata changed, not at each reconcile.
+func (m *IPPoolManager) syncBackendMetadata(ctx context.Context, addressClaim *ipamv1.IPClaim) error {
+	if m.IPPool.Spec.Backend == nil {
+		return nil
+	}
+	references := map[string]string{}
+	if addressClaim.Status.Address != nil {
+		references[""] = addressClaim.Status.Address.Name
+	}
+	for role, reference := range addressClaim.Status.Addresses {
+		references[role] = reference.Name
+	}
+	outdated := map[string]*ipamv1.IPAddress{}
+	for role, name := range references {
+		addressObject := &ipamv1.IPAddress{}
+		err := m.client.Get(ctx, client.ObjectKey{
+			Name: name, Namespace: m.addressNamespace(addressClaim.Namespace),
+		}, addressObject)
+		if err != nil {
+			return client.IgnoreNotFound(err)
+		}
+		backendAddress := m.newBackendAddress(addressClaim, role,
+			addressObject.Spec.Address, addressObject.Spec.Prefix,
+		)
+		if addressObject.Annotations[ipamv1.BackendMetadataAnnotation] != backendAddress.metadataHash() {
+			outdated[role] = addressObject
+		}
+	}
+	if len(outdated) == 0 {
+		return nil
+	}
+	backend, err := m.getBackend(ctx)
+	if err != nil || backend == nil {
+		return err
+	}
+	for role, addressObject := range outdated {
+		backendAddress := m.newBackendAddress(addressClaim, role,
+			addressObject.Spec.Address, addressObject.Spec.Prefix,
+		)
+		hash := backendAddress.metadataHash()
+		backendAddress.ID = addressObject.Annotations[ipamv1.BackendIDAnnotation]
+		id, err := backend.Reserve(ctx, backendAddress)
+		if err != nil {
+			return errors.Wrap(err, "failed to update the reservation in the backend")
+		}
+		if addressObject.Annotations == nil {
+			addressObject.Annotations = make(map[string]string)
+		}
+		addressObject.Annotations[ipamv1.BackendIDAnnotation] = id
+		addressObject.Annotations[ipamv1.BackendMetadataAnnotation] = hash
+		if err := m.applyAddressMetadata(ctx, addressObject, "backend", nil, map[string]string{
+			ipamv1.BackendIDAnnotation:       id,
+			ipamv1.BackendMetadataAnnotation: hash,
+		}); err != nil {
+			return err
+		}
+		m.Log.Info("Reservation updated in the backend", "Claim", addressClaim.Name, "address", addressObject.Spec.Address)
+	}
+	return nil
+}
+
+// releaseBackendAddress releases the address of the given role of a claim in
+// the backend. The IPAddress is nil if it was not found.
+func (m *IPPoolManager) releaseBackendAddress(ctx context.Context, backend Backend,
+	addressClaim *ipamv1.IPClaim, role string, address ipamv1.IPAddressStr,
+	addressObject *ipamv1.IPAddress,
+) error {
+	backendAddress := m.newBackendAddress(addressClaim, role, address, 0)
+	if addressObject != nil {
+		backendAddress.ID = addressObject.Annotations[ipamv1.BackendIDAnnotation]
+		backendAddress.Prefix = addressObject.Spec.Prefix
+	}
+	if err := backend.Release(ctx, backendAddress); err != nil {
+		return errors.Wrap(err, "failed to release the address in the backend")
+	}
+	return nil
+}
@@ -0,0 +1,483 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	"github.com/metal3-io/ip-address-manager/feature"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/klogr"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// fakeBackend records the reservations, reserving the addresses of inUse
+// for other owners
+type fakeBackend struct {
+	inUse    map[ipamv1.IPAddressStr]bool
+	err      error
+	reserved []BackendAddress
+	released []BackendAddress
+}
+
+func (b *fakeBackend) Reserve(ctx context.Context, address BackendAddress) (string, error) {
+	if b.err != nil {
+		return "", b.err
+	}
+	if b.inUse[address.Address] {
+		return "", ErrAddressInUse
+	}
+	b.reserved = append(b.reserved, address)
+	if address.ID != "" {
+		return address.ID, nil
+	}
+	return "id-" + string(address.Address), nil
+}
+
+func (b *fakeBackend) Release(ctx context.Context, address BackendAddress) error {
+	if b.err != nil {
+		return b.err
+	}
+	b.released = append(b.released, address)
+	return nil
+}
+
+var _ = Describe("Backend", func() {
+
+	backendPool := func() *ipamv1.IPPool {
+		return &ipamv1.IPPool{
+			ObjectMeta: testObjectMeta,
+			Spec: ipamv1.IPPoolSpec{
+				Pools: []ipamv1.Pool{
+					{
+						Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.11")),
+						End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.13")),
+					},
+				},
+				NamePrefix: "abcpref",
+				Backend: &ipamv1.Backend{
+					NetBox: &ipamv1.NetBoxBackend{
+						URL:  "https://netbox.example.com",
+						Tags: []string{"metal3"},
+					},
+				},
+			},
+			Status: ipamv1.IPPoolStatus{
+				Allocations: map[string]ipamv1.IPAddressStr{},
+			},
+		}
+	}
+
+	type testCaseBackendCreate struct {
+		inUse               []ipamv1.IPAddressStr
+		backendErr          error
+		annotations         map[string]string
+		expectError         bool
+		expectedAddress     ipamv1.IPAddressStr
+		expectedDescription string
+		expectedTags        []string
+	}
+
+	DescribeTable("Test createAddress with a backend",
+		func(tc testCaseBackendCreate) {
+			backend := &fakeBackend{
+				inUse: map[ipamv1.IPAddressStr]bool{},
+				err:   tc.backendErr,
+			}
+			for _, address := range tc.inUse {
+				backend.inUse[address] = true
+			}
+			ipClaim := &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "abc",
+					Namespace:   "myns",
+					Annotations: tc.annotations,
+				},
+			}
+			c := fakeclient.NewClientBuilder().WithScheme(setupScheme()).Build()
+			ipPoolMgr, err := NewIPPoolManager(c, backendPool(), klogr.New())
+			Expect(err).NotTo(HaveOccurred())
+			ipPoolMgr.backend = backend
+
+			_, err = ipPoolMgr.createAddress(context.TODO(), ipClaim,
+				map[ipamv1.IPAddressStr]string{},
+			)
+			addressObjects := ipamv1.IPAddressList{}
+			Expect(c.List(context.TODO(), &addressObjects)).To(Succeed())
+			if tc.expectError {
+				Expect(err).To(HaveOccurred())
+				Expect(addressObjects.Items).To(BeEmpty())
+				Expect(ipClaim.Status.ErrorMessage).NotTo(BeNil())
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(addressObjects.Items).To(HaveLen(1))
+			address := addressObjects.Items[0]
+			Expect(address.Spec.Address).To(Equal(tc.expectedAddress))
+			Expect(backend.reserved).To(HaveLen(1))
+			Expect(backend.reserved[0].Address).To(Equal(tc.expectedAddress))
+			Expect(backend.reserved[0].Owner).To(Equal("myns/abc"))
+			Expect(backend.reserved[0].Description).To(Equal(tc.expectedDescription))
+			Expect(backend.reserved[0].Tags).To(Equal(tc.expectedTags))
+			Expect(address.Annotations[ipamv1.BackendIDAnnotation]).To(Equal("id-" + string(tc.expectedAddress)))
+			Expect(address.Annotations[ipamv1.BackendMetadataAnnotation]).To(
+				Equal(backend.reserved[0].metadataHash()),
+			)
+		},
+		Entry("Reserved", testCaseBackendCreate{
+			expectedAddress:     "192.168.0.11",
+			expectedDescription: "myns/abc",
+			expectedTags:        []string{"metal3"},
+		}),
+		Entry("Reserved with the metadata of the claim", testCaseBackendCreate{
+			annotations: map[string]string{
+				ipamv1.BackendDescriptionAnnotation: "web server",
+				ipamv1.BackendTagsAnnotation:        "web, metal3,,prod",
+			},
+			expectedAddress:     "192.168.0.11",
+			expectedDescription: "web server",
+			expectedTags:        []string{"metal3", "web", "prod"},
+		}),
+		Entry("Addresses in use in the backend skipped", testCaseBackendCreate{
+			inUse:               []ipamv1.IPAddressStr{"192.168.0.11", "192.168.0.12"},
+			expectedAddress:     "192.168.0.13",
+			expectedDescription: "myns/abc",
+			expectedTags:        []string{"metal3"},
+		}),
+		Entry("All addresses in use in the backend", testCaseBackendCreate{
+			inUse:       []ipamv1.IPAddressStr{"192.168.0.11", "192.168.0.12", "192.168.0.13"},
+			expectError: true,
+		}),
+		Entry("Backend error", testCaseBackendCreate{
+			backendErr:  errors.New("unreachable"),
+			expectError: true,
+		}),
+	)
+
+	It("Fails to get the backend with the feature gate disabled", func() {
+		Expect(feature.MutableGates.Set("ExternalBackends=false")).To(Succeed())
+		defer func() {
+			Expect(feature.MutableGates.Set("ExternalBackends=true")).To(Succeed())
+		}()
+		c := fakeclient.NewClientBuilder().WithScheme(setupScheme()).Build()
+		ipPoolMgr, err := NewIPPoolManager(c, backendPool(), klogr.New())
+		Expect(err).NotTo(HaveOccurred())
+		_, err = ipPoolMgr.getBackend(context.TODO())
+		Expect(err).To(MatchError(ContainSubstring("ExternalBackends")))
+	})
+
+	type testCaseBackendDelete struct {
+		backendErr     error
+		addressMissing bool
+		expectReleased bool
+		expectedID     string
+		expectedPrefix int
+		expectedReason string
+		expectedStatus metav1.ConditionStatus
+	}
+
+	DescribeTable("Test deleteAddress with a backend",
+		func(tc testCaseBackendDelete) {
+			backend := &fakeBackend{err: tc.backendErr}
+			ipPool := backendPool()
+			ipPool.Status.Allocations["abc"] = "192.168.0.11"
+			ipClaim := &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:       "abc",
+					Namespace:  "myns",
+					Finalizers: []string{ipamv1.IPClaimFinalizer},
+				},
+			}
+			objects := []client.Object{}
+			if !tc.addressMissing {
+				objects = append(objects, &ipamv1.IPAddress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "abcpref-192-168-0-11",
+						Namespace: "myns",
+						Annotations: map[string]string{
+							ipamv1.BackendIDAnnotation: "42",
+						},
+					},
+					Spec: ipamv1.IPAddressSpec{
+						Address: "192.168.0.11",
+						Prefix:  24,
+					},
+				})
+			}
+			c := fakeclient.NewClientBuilder().WithScheme(setupScheme()).WithObjects(objects...).Build()
+			ipPoolMgr, err := NewIPPoolManager(c, ipPool, klogr.New())
+			Expect(err).NotTo(HaveOccurred())
+			ipPoolMgr.backend = backend
+
+			_, err = ipPoolMgr.deleteAddress(context.TODO(), ipClaim,
+				map[ipamv1.IPAddressStr]string{"192.168.0.11": "abc"},
+			)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ipPoolMgr.releasePending).To(Equal(!tc.expectReleased))
+			if tc.expectReleased {
+				Expect(backend.released).To(HaveLen(1))
+				Expect(backend.released[0].ID).To(Equal(tc.expectedID))
+				Expect(backend.released[0].Prefix).To(Equal(tc.expectedPrefix))
+				Expect(backend.released[0].Owner).To(Equal("myns/abc"))
+				Expect(ipPool.Status.Allocations).To(BeEmpty())
+				Expect(ipClaim.Finalizers).To(BeEmpty())
+				return
+			}
+			Expect(ipPool.Status.Allocations).To(HaveKey("abc"))
+			Expect(ipClaim.Finalizers).To(ContainElement(ipamv1.IPClaimFinalizer))
+			condition := meta.FindStatusCondition(ipClaim.Status.Conditions,
+				ipamv1.IPClaimDeregistrationFailedCondition,
+			)
+			Expect(condition).NotTo(BeNil())
+			Expect(condition.Status).To(Equal(tc.expectedStatus))
+			Expect(condition.Reason).To(Equal(tc.expectedReason))
+			address := &ipamv1.IPAddress{}
+			Expect(c.Get(context.TODO(), client.ObjectKey{
+				Name: "abcpref-192-168-0-11", Namespace: "myns",
+			}, address)).To(Succeed())
+		},
+		Entry("Released by identifier", testCaseBackendDelete{
+			expectReleased: true,
+			expectedID:     "42",
+			expectedPrefix: 24,
+		}),
+		Entry("Released by owner without IPAddress", testCaseBackendDelete{
+			addressMissing: true,
+			expectReleased: true,
+		}),
+		Entry("Release failed", testCaseBackendDelete{
+			backendErr:     errors.New("unreachable"),
+			expectedReason: "BackendReleaseFailed",
+			expectedStatus: metav1.ConditionTrue,
+		}),
+	)
+
+	type testCaseSyncBackend struct {
+		annotations    map[string]string
+		synced         bool
+		noBackend      bool
+		expectReserved bool
+	}
+
+	DescribeTable("Test syncBackendMetadata",
+		func(tc testCaseSyncBackend) {
+			backend := &fakeBackend{}
+			ipPool := backendPool()
+			ipClaim := &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "abc",
+					Namespace:   "myns",
+					Annotations: tc.annotations,
+				},
+				Status: ipamv1.IPClaimStatus{
+					Address: &corev1.ObjectReference{
+						Name:      "abcpref-192-168-0-11",
+						Namespace: "myns",
+					},
+				},
+			}
+			c := fakeclient.NewClientBuilder().WithScheme(setupScheme()).Build()
+			ipPoolMgr, err := NewIPPoolManager(c, ipPool, klogr.New())
+			Expect(err).NotTo(HaveOccurred())
+			if !tc.noBackend {
+				ipPoolMgr.backend = backend
+			}
+
+			hash := "outdated"
+			if tc.synced {
+				hash = ipPoolMgr.newBackendAddress(ipClaim, "", "192.168.0.11", 24).metadataHash()
+			}
+			Expect(c.Create(context.TODO(), &ipamv1.IPAddress{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "abcpref-192-168-0-11",
+					Namespace: "myns",
+					Annotations: map[string]string{
+						ipamv1.BackendIDAnnotation:       "42",
+						ipamv1.BackendMetadataAnnotation: hash,
+					},
+				},
+				Spec: ipamv1.IPAddressSpec{
+					Address: "192.168.0.11",
+					Prefix:  24,
+				},
+			})).To(Succeed())
+
+			Expect(ipPoolMgr.syncBackendMetadata(context.TODO(), ipClaim)).To(Succeed())
+			address := &ipamv1.IPAddress{}
+			Expect(c.Get(context.TODO(), client.ObjectKey{
+				Name: "abcpref-192-168-0-11", Namespace: "myns",
+			}, address)).To(Succeed())
+			if !tc.expectReserved {
+				Expect(backend.reserved).To(BeEmpty())
+				Expect(address.Annotations[ipamv1.BackendMetadataAnnotation]).To(Equal(hash))
+				return
+			}
+			Expect(backend.reserved).To(HaveLen(1))
+			Expect(backend.reserved[0].ID).To(Equal("42"))
+			Expect(backend.reserved[0].Tags).To(Equal([]string{"metal3", "web"}))
+			Expect(address.Annotations[ipamv1.BackendMetadataAnnotation]).To(
+				Equal(backend.reserved[0].metadataHash()),
+			)
+		},
+		Entry("Metadata unchanged", testCaseSyncBackend{
+			synced: true,
+		}),
+		Entry("Metadata unchanged, the backend is not reached", testCaseSyncBackend{
+			synced:    true,
+			noBackend: true,
+		}),
+		Entry("Metadata changed", testCaseSyncBackend{
+			annotations: map[string]string{
+				ipamv1.BackendTagsAnnotation: "web",
+			},
+			expectReserved: true,
+		}),
+	)
+
+	type testCaseNewBackend struct {
+		tokenFile   bool
+		credentials bool
+		expectError bool
+	}
+
+	DescribeTable("Test NewBackend",
+		func(tc testCaseNewBackend) {
+			dir, err := ioutil.TempDir("", "backend")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(dir)
+			tokenFile := filepath.Join(dir, "token")
+			if tc.tokenFile {
+				Expect(ioutil.WriteFile(tokenFile, []byte("secret-token\n"), 0600)).To(Succeed())
+			}
+			var credentials *BackendCredentials
+			if tc.credentials {
+				credentials = &BackendCredentials{NetBoxTokenFile: tokenFile}
+			}
+
+			backend, err := NewBackend(context.TODO(), backendPool().Spec.Backend, "myns", credentials)
+			if tc.expectError {
+				Expect(err).To(HaveOccurred())
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(backend).To(BeAssignableToTypeOf(&netBoxBackend{}))
+			Expect(backend.(*netBoxBackend).token).To(Equal("secret-token"))
+			Expect(backend.(*netBoxBackend).status).To(Equal(defaultNetBoxStatus))
+		},
+		Entry("Token read", testCaseNewBackend{
+			tokenFile:   true,
+			credentials: true,
+		}),
+		Entry("Token file missing", testCaseNewBackend{
+			credentials: true,
+			expectError: true,
+		}),
+		Entry("No credentials", testCaseNewBackend{
+			expectError: true,
+		}),
+	)
+
+	type testCaseNewInfobloxBackend struct {
+		secretNamespace string
+		secretData      map[string][]byte
+		noReader        bool
+		expectError     bool
+	}
+
+	DescribeTable("Test NewBackend with Infoblox",
+		func(tc testCaseNewInfobloxBackend) {
+			secret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "infoblox",
+					Namespace: "myns",
+				},
+				Data: tc.secretData,
+			}
+			s := setupScheme()
+			Expect(corev1.AddToScheme(s)).To(Succeed())
+			c := fakeclient.NewClientBuilder().WithScheme(s).WithObjects(secret).Build()
+			credentials := &BackendCredentials{SecretReader: c}
+			if tc.noReader {
+				credentials = nil
+			}
+			backendSpec := &ipamv1.Backend{
+				Infoblox: &ipamv1.InfobloxBackend{
+					URL: "https://infoblox.example.com/wapi/v2.11",
+					CredentialsSecret: corev1.SecretReference{
+						Name:      "infoblox",
+						Namespace: tc.secretNamespace,
+					},
+				},
+			}
+
+			backend, err := NewBackend(context.TODO(), backendSpec, "myns", credentials)
+			if tc.expectError {
+				Expect(err).To(HaveOccurred())
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(backend).To(BeAssignableToTypeOf(&infobloxBackend{}))
+			Expect(backend.(*infobloxBackend).username).To(Equal("admin"))
+			Expect(backend.(*infobloxBackend).password).To(Equal("infoblox"))
+			Expect(backend.(*infobloxBackend).networkView).To(Equal(defaultInfobloxNetworkView))
+		},
+		Entry("Credentials read from the namespace of the pool", testCaseNewInfobloxBackend{
+			secretData: map[string][]byte{
+				corev1.BasicAuthUsernameKey: []byte("admin"),
+				corev1.BasicAuthPasswordKey: []byte("infoblox"),
+			},
+		}),
+		Entry("Credentials read from the namespace of the reference", testCaseNewInfobloxBackend{
+			secretNamespace: "myns",
+			secretData: map[string][]byte{
+				corev1.BasicAuthUsernameKey: []byte("admin"),
+				corev1.BasicAuthPasswordKey: []byte("infoblox"),
+			},
+		}),
+		Entry("Secret in another namespace not found", testCaseNewInfobloxBackend{
+			secretNamespace: "otherns",
+			secretData: map[string][]byte{
+				corev1.BasicAuthUsernameKey: []byte("admin"),
+				corev1.BasicAuthPasswordKey: []byte("infoblox"),
+			},
+			expectError: true,
+		}),
+		Entry("Password missing", testCaseNewInfobloxBackend{
+			secretData: map[string][]byte{
+				corev1.BasicAuthUsernameKey: []byte("admin"),
+			},
+			expectError: true,
+		}),
+		Entry("No Secret reader", testCaseNewInfobloxBackend{
+			noReader:    true,
+			expectError: true,
+		}),
+	)
+})
@@ -0,0 +1,91 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"context"
+
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ClaimPoolIndex is the field index of the IPClaims on the keys of the pools
+// they reference. Once registered in the cache of the manager, the pool
+// managers list the claims of their pool instead of all the claims of the
+// namespaces allowed to reference it.
+const ClaimPoolIndex = "spec.pool.key"
+
+// claimPoolKey returns the index key of a pool. A ClusterIPPool is keyed by
+// its kind, so that it is not mistaken for an IPPool of the same name.
+func claimPoolKey(clusterScoped bool, namespace, name string) string {
+	if clusterScoped {
+		return ipamv1.ClusterIPPoolKind + "/" + name
+	}
+	return namespace + "/" + name
+}
+
+// ClaimPoolIndexFunc returns the keys of the pools an IPClaim references, the
+// pool of its spec and the pool named by its served-by annotation
+func ClaimPoolIndexFunc(obj client.Object) []string {
+	claim, ok := obj.(*ipamv1.IPClaim)
+	if !ok || claim.Spec.Pool.Name == "" {
+		return nil
+	}
+	if ipamv1.IsClusterIPPoolRef(claim.Spec.Pool) {
+		return []string{claimPoolKey(true, "", claim.Spec.Pool.Name)}
+	}
+	namespace := claim.Spec.Pool.Namespace
+	if namespace == "" {
+		namespace = claim.Namespace
+	}
+	keys := []string{claimPoolKey(false, namespace, claim.Spec.Pool.Name)}
+	if servedBy, ok := claim.Annotations[ipamv1.ServedByAnnotation]; ok && servedBy != claim.Spec.Pool.Name {
+		keys = append(keys, claimPoolKey(false, namespace, servedBy))
+	}
+	return keys
+}
+
+// RegisterClaimPoolIndex registers the ClaimPoolIndex in the field indexer
+func RegisterClaimPoolIndex(ctx context.Context, indexer client.FieldIndexer) error {
+	return indexer.IndexField(ctx, &ipamv1.IPClaim{}, ClaimPoolIndex, ClaimPoolIndexFunc)
+}
+
+// listIndexedClaims lists the IPClaims referencing the pool, under its name or
+// one of its former names, with the ClaimPoolIndex
+func (m *IPPoolManager) listIndexedClaims(ctx context.Context) ([]ipamv1.IPClaim, error) {
+	names := append([]string{m.IPPool.Name}, m.IPPool.FormerNames()...)
+	listed := make(map[client.ObjectKey]bool)
+	claims := []ipamv1.IPClaim{}
+	for _, name := range names {
+		addressClaimObjects := ipamv1.IPClaimList{}
+		key := claimPoolKey(m.IPPool.IsClusterScoped(), m.IPPool.Namespace, name)
+		if err := m.client.List(ctx, &addressClaimObjects,
+			client.MatchingFields{ClaimPoolIndex: key},
+		); err != nil {
+			return nil, err
+		}
+		for _, claim := range addressClaimObjects.Items {
+			claimKey := client.ObjectKeyFromObject(&claim)
+			if listed[claimKey] {
+				continue
+			}
+			listed[claimKey] = true
+			claims = append(claims, claim)
+		}
+	}
+	return claims, nil
+}
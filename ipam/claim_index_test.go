@@ -0,0 +1,103 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/klogr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Claim pool index", func() {
+
+	DescribeTable("Test ClaimPoolIndexFunc",
+		func(obj client.Object, expected []string) {
+			Expect(ClaimPoolIndexFunc(obj)).To(Equal(expected))
+		},
+		Entry("No pool", &ipamv1.IPClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "abc", Namespace: "myns"},
+		}, nil),
+		Entry("IPPool of the namespace", &ipamv1.IPClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "abc", Namespace: "myns"},
+			Spec: ipamv1.IPClaimSpec{
+				Pool: corev1.ObjectReference{Name: "pool1"},
+			},
+		}, []string{"myns/pool1"}),
+		Entry("IPPool of another namespace", &ipamv1.IPClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "abc", Namespace: "myns"},
+			Spec: ipamv1.IPClaimSpec{
+				Pool: corev1.ObjectReference{Name: "pool1", Namespace: "otherns"},
+			},
+		}, []string{"otherns/pool1"}),
+		Entry("IPPool served by another pool", &ipamv1.IPClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "abc",
+				Namespace:   "myns",
+				Annotations: map[string]string{ipamv1.ServedByAnnotation: "pool2"},
+			},
+			Spec: ipamv1.IPClaimSpec{
+				Pool: corev1.ObjectReference{Name: "pool1"},
+			},
+		}, []string{"myns/pool1", "myns/pool2"}),
+		Entry("ClusterIPPool", &ipamv1.IPClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "abc", Namespace: "myns"},
+			Spec: ipamv1.IPClaimSpec{
+				Pool: corev1.ObjectReference{Name: "pool1", Kind: ipamv1.ClusterIPPoolKind},
+			},
+		}, []string{"ClusterIPPool/pool1"}),
+		Entry("Not a claim", &ipamv1.IPAddress{}, nil),
+	)
+
+	It("Lists the indexed claims of the pool once", func() {
+		claim := func(name string, pool string) *ipamv1.IPClaim {
+			return &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "myns"},
+				Spec: ipamv1.IPClaimSpec{
+					Pool: corev1.ObjectReference{Name: pool},
+				},
+			}
+		}
+		ipPool := &ipamv1.IPPool{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "pool1",
+				Namespace:   "myns",
+				Annotations: map[string]string{ipamv1.RenamedFromAnnotation: "pool0"},
+			},
+		}
+		c := fakeclient.NewClientBuilder().WithScheme(setupScheme()).WithObjects(
+			claim("abc", "pool1"), claim("bcd", "pool0"),
+		).Build()
+		ipPoolMgr, err := NewIPPoolManager(c, ipPool, klogr.New())
+		Expect(err).NotTo(HaveOccurred())
+		ipPoolMgr.IndexedClaims = true
+
+		// The fake client ignores the field selectors, the claims listed
+		// under both names of the pool are only returned once
+		claims, err := ipPoolMgr.listClaims(context.TODO())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(claims).To(HaveLen(2))
+	})
+})
@@ -0,0 +1,311 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// DefaultConfigFileInterval is the default interval at which the
+	// configuration file is checked for changes
+	DefaultConfigFileInterval = 10 * time.Second
+	// configRequeueIntervalKey, configStaleClaimThresholdKey and
+	// configLogVerbosityKey are the keys of the configuration file applied to
+	// the running controllers
+	configRequeueIntervalKey     = "requeue-interval"
+	configStaleClaimThresholdKey = "stale-claim-threshold"
+	configLogVerbosityKey        = "v"
+)
+
+// reloadableConfigKeys are the keys of the configuration file applied to the
+// running controllers when the file changes, through their Settings. The
+// other keys are only read at startup.
+var reloadableConfigKeys = map[string]bool{
+	configRequeueIntervalKey:     true,
+	configStaleClaimThresholdKey: true,
+	configLogVerbosityKey:        true,
+}
+
+// ReadConfigFile reads the configuration file of the controller, a YAML map of
+// the names of its flags to their values, such as a ConfigMap mounted in the
+// pod. The lists are joined with commas, for the flags taking comma-separated
+// lists, and the maps are joined as comma-separated key=value pairs, for the
+// feature gates.
+func ReadConfigFile(path string) (map[string]string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the configuration file")
+	}
+	fields := map[string]interface{}{}
+	if err := yaml.Unmarshal(content, &fields); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse the configuration file %s", path)
+	}
+	values := make(map[string]string, len(fields))
+	for key, field := range fields {
+		value, err := configValue(field)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid value of %s in the configuration file %s", key, path)
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+// configValue renders a value of the configuration file as a flag value
+func configValue(field interface{}) (string, error) {
+	switch value := field.(type) {
+	case string:
+		return value, nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	case nil:
+		return "", nil
+	case map[string]interface{}:
+		// The maps, such as the feature gates, are joined as key=value pairs
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		pairs := make([]string, 0, len(value))
+		for _, key := range keys {
+			switch value[key].(type) {
+			case []interface{}, map[string]interface{}:
+				return "", fmt.Errorf("nested maps and lists are not supported")
+			}
+			rendered, err := configValue(value[key])
+			if err != nil {
+				return "", err
+			}
+			pairs = append(pairs, key+"="+rendered)
+		}
+		return strings.Join(pairs, ","), nil
+	case []interface{}:
+		items := make([]string, 0, len(value))
+		for _, item := range value {
+			switch item.(type) {
+			case []interface{}, map[string]interface{}:
+				return "", fmt.Errorf("nested maps and lists are not supported")
+			}
+			rendered, err := configValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, rendered)
+		}
+		return strings.Join(items, ","), nil
+	}
+	return "", fmt.Errorf("unsupported value %v", field)
+}
+
+// ApplyConfig sets the flags of the flag set to the values of the
+// configuration file. The flags given on the command line, in commandLine,
+// keep their value. It fails on the keys that are not flags, and on the
+// invalid values.
+func ApplyConfig(flags *flag.FlagSet, values map[string]string, commandLine map[string]bool) error {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if key == "config" {
+			return fmt.Errorf("the configuration file cannot give another configuration file")
+		}
+		if flags.Lookup(key) == nil {
+			return fmt.Errorf("unknown key %s in the configuration file", key)
+		}
+		if commandLine[key] {
+			continue
+		}
+		if err := flags.Set(key, values[key]); err != nil {
+			return errors.Wrapf(err, "invalid value of %s in the configuration file", key)
+		}
+	}
+	return nil
+}
+
+// CommandLineFlags returns the names of the flags given on the command line
+func CommandLineFlags(flags *flag.FlagSet) map[string]bool {
+	commandLine := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) {
+		commandLine[f.Name] = true
+	})
+	return commandLine
+}
+
+// ConfigFileWatcher applies the changes of the configuration file of the
+// controller to its running controllers: the requeue interval, the stale
+// claim threshold and the log verbosity become the default values of the
+// Settings. The changes of the other keys are only logged, they are applied
+// when the controller restarts. It is a Runnable of the manager.
+type ConfigFileWatcher struct {
+	// Path is the path of the configuration file
+	Path string
+	// Flags are the flags of the controller, set from the configuration file
+	// at startup
+	Flags *flag.FlagSet
+	// CommandLine are the flags given on the command line, the configuration
+	// file does not override them
+	CommandLine map[string]bool
+	Settings    *Settings
+	// Interval is the interval at which the file is checked for changes
+	Interval time.Duration
+	Log      logr.Logger
+	// values are the values of the configuration file applied last
+	values map[string]string
+}
+
+// NewConfigFileWatcher returns the watcher of the configuration file, whose
+// values were applied to the flags at startup
+func NewConfigFileWatcher(path string, flags *flag.FlagSet, values map[string]string,
+	commandLine map[string]bool, settings *Settings, log logr.Logger,
+) *ConfigFileWatcher {
+	return &ConfigFileWatcher{
+		Path:        path,
+		Flags:       flags,
+		CommandLine: commandLine,
+		Settings:    settings,
+		Interval:    DefaultConfigFileInterval,
+		Log:         log,
+		values:      values,
+	}
+}
+
+// Start checks the configuration file for changes until the context is done,
+// it implements the Runnable of the manager
+func (w *ConfigFileWatcher) Start(ctx context.Context) error {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := w.Reload(); err != nil {
+				w.Log.Error(err, "Configuration file not reloaded")
+			}
+		}
+	}
+}
+
+// NeedLeaderElection returns false, the settings of all the replicas follow
+// the configuration file
+func (w *ConfigFileWatcher) NeedLeaderElection() bool {
+	return false
+}
+
+// Reload reads the configuration file again, and applies the changes of its
+// reloadable keys. A key removed from the file gets the default value of its
+// flag back. The file is left for the next check if it is invalid.
+func (w *ConfigFileWatcher) Reload() error {
+	values, err := ReadConfigFile(w.Path)
+	if err != nil {
+		return err
+	}
+	changed := changedConfigKeys(w.values, values)
+	if len(changed) == 0 {
+		return nil
+	}
+	reload := map[string]string{}
+	for _, key := range changed {
+		configFlag := w.Flags.Lookup(key)
+		if configFlag == nil {
+			return fmt.Errorf("unknown key %s in the configuration file", key)
+		}
+		if w.CommandLine[key] {
+			w.Log.Info("Configuration key given on the command line, not reloaded", "key", key)
+			continue
+		}
+		if !reloadableConfigKeys[key] {
+			w.Log.Info("Configuration key modified, applied when the controller restarts", "key", key)
+			continue
+		}
+		value, ok := values[key]
+		if !ok {
+			value = configFlag.DefValue
+		}
+		reload[key] = value
+	}
+	if err := ApplyConfig(w.Flags, reload, w.CommandLine); err != nil {
+		return err
+	}
+	w.values = values
+	if len(reload) == 0 {
+		return nil
+	}
+	requeueAfter, err := w.durationFlag(configRequeueIntervalKey)
+	if err != nil {
+		return err
+	}
+	staleClaimThreshold, err := w.durationFlag(configStaleClaimThresholdKey)
+	if err != nil {
+		return err
+	}
+	logVerbosity := int64(0)
+	if verbosityFlag := w.Flags.Lookup(configLogVerbosityKey); verbosityFlag != nil {
+		logVerbosity, err = strconv.ParseInt(verbosityFlag.Value.String(), 10, 32)
+		if err != nil {
+			return errors.Wrap(err, "invalid log verbosity")
+		}
+	}
+	w.Settings.SetDefaults(requeueAfter, staleClaimThreshold, int32(logVerbosity))
+	w.Log.Info("Configuration file reloaded", "settings", w.Settings.String())
+	return nil
+}
+
+// durationFlag returns the value of a duration flag
+func (w *ConfigFileWatcher) durationFlag(name string) (time.Duration, error) {
+	durationFlag := w.Flags.Lookup(name)
+	if durationFlag == nil {
+		return 0, fmt.Errorf("no %s flag", name)
+	}
+	duration, err := time.ParseDuration(durationFlag.Value.String())
+	return duration, errors.Wrapf(err, "invalid %s", name)
+}
+
+// changedConfigKeys returns the keys added, removed or modified between two
+// versions of the configuration file, sorted
+func changedConfigKeys(previous, current map[string]string) []string {
+	changed := []string{}
+	for key, value := range current {
+		if previousValue, ok := previous[key]; !ok || previousValue != value {
+			changed = append(changed, key)
+		}
+	}
+	for key := range previous {
+		if _, ok := current[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
@@ -0,0 +1,172 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/klog/v2/klogr"
+)
+
+var _ = Describe("Configuration file", func() {
+
+	var configDir string
+
+	BeforeEach(func() {
+		var err error
+		configDir, err = ioutil.TempDir("", "ipam-config")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(configDir)
+	})
+
+	writeConfig := func(content string) string {
+		path := filepath.Join(configDir, "config.yaml")
+		Expect(ioutil.WriteFile(path, []byte(content), 0600)).To(Succeed())
+		return path
+	}
+
+	newFlags := func() *flag.FlagSet {
+		flags := flag.NewFlagSet("test", flag.ContinueOnError)
+		flags.Duration("requeue-interval", DefaultRequeueAfter, "")
+		flags.Duration("stale-claim-threshold", 15*time.Minute, "")
+		flags.Int("v", 0, "")
+		flags.Int("ippool-concurrency", 1, "")
+		flags.Bool("server-side-apply", true, "")
+		flags.String("watch-namespaces", "", "")
+		flags.String("feature-gates", "", "")
+		flags.String("config", "", "")
+		return flags
+	}
+
+	type testCaseApplyConfig struct {
+		content        string
+		commandLine    []string
+		expectError    bool
+		expectedValues map[string]string
+	}
+
+	DescribeTable("Test ReadConfigFile and ApplyConfig",
+		func(tc testCaseApplyConfig) {
+			flags := newFlags()
+			Expect(flags.Parse(tc.commandLine)).To(Succeed())
+			values, err := ReadConfigFile(writeConfig(tc.content))
+			if err == nil {
+				err = ApplyConfig(flags, values, CommandLineFlags(flags))
+			}
+			if tc.expectError {
+				Expect(err).To(HaveOccurred())
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			for name, value := range tc.expectedValues {
+				Expect(flags.Lookup(name).Value.String()).To(Equal(value), name)
+			}
+		},
+		Entry("Empty file", testCaseApplyConfig{
+			expectedValues: map[string]string{"requeue-interval": "30s"},
+		}),
+		Entry("Values of all types", testCaseApplyConfig{
+			content: "requeue-interval: 1m\nippool-concurrency: 4\nserver-side-apply: false\n" +
+				"watch-namespaces:\n- ns1\n- ns2\n",
+			expectedValues: map[string]string{
+				"requeue-interval":   "1m0s",
+				"ippool-concurrency": "4",
+				"server-side-apply":  "false",
+				"watch-namespaces":   "ns1,ns2",
+			},
+		}),
+		Entry("Command line takes precedence", testCaseApplyConfig{
+			content:     "requeue-interval: 1m\nippool-concurrency: 4\n",
+			commandLine: []string{"--ippool-concurrency=8"},
+			expectedValues: map[string]string{
+				"requeue-interval":   "1m0s",
+				"ippool-concurrency": "8",
+			},
+		}),
+		Entry("Unknown key", testCaseApplyConfig{
+			content:     "unknown: 1\n",
+			expectError: true,
+		}),
+		Entry("Invalid value", testCaseApplyConfig{
+			content:     "requeue-interval: abc\n",
+			expectError: true,
+		}),
+		Entry("Nested configuration file", testCaseApplyConfig{
+			content:     "config: other.yaml\n",
+			expectError: true,
+		}),
+		Entry("Map value", testCaseApplyConfig{
+			content: "feature-gates:\n  LeaseExpiry: true\n  ExternalBackends: false\n",
+			expectedValues: map[string]string{
+				"feature-gates": "ExternalBackends=false,LeaseExpiry=true",
+			},
+		}),
+		Entry("Nested map value", testCaseApplyConfig{
+			content:     "feature-gates:\n  LeaseExpiry:\n  - abc\n",
+			expectError: true,
+		}),
+		Entry("Not a map", testCaseApplyConfig{
+			content:     "- abc\n",
+			expectError: true,
+		}),
+	)
+
+	It("Reloads the reloadable keys", func() {
+		flags := newFlags()
+		Expect(flags.Parse([]string{"--stale-claim-threshold=5m"})).To(Succeed())
+		commandLine := CommandLineFlags(flags)
+		path := writeConfig("requeue-interval: 1m\nippool-concurrency: 4\n")
+		values, err := ReadConfigFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ApplyConfig(flags, values, commandLine)).To(Succeed())
+		settings := NewSettings(time.Minute, 5*time.Minute, 0)
+		watcher := NewConfigFileWatcher(path, flags, values, commandLine, settings, klogr.New())
+
+		// Nothing changed
+		Expect(watcher.Reload()).To(Succeed())
+		Expect(settings.RequeueAfter()).To(Equal(time.Minute))
+
+		// The keys given on the command line and the keys that are not
+		// reloadable are left untouched
+		writeConfig("requeue-interval: 2m\nstale-claim-threshold: 1h\nippool-concurrency: 8\n")
+		Expect(watcher.Reload()).To(Succeed())
+		Expect(settings.RequeueAfter()).To(Equal(2 * time.Minute))
+		Expect(settings.StaleClaimThreshold()).To(Equal(5 * time.Minute))
+		Expect(flags.Lookup("ippool-concurrency").Value.String()).To(Equal("4"))
+
+		// A removed key gets its default value back
+		writeConfig("ippool-concurrency: 8\n")
+		Expect(watcher.Reload()).To(Succeed())
+		Expect(settings.RequeueAfter()).To(Equal(DefaultRequeueAfter))
+
+		// An invalid file is not applied
+		writeConfig("requeue-interval: abc\n")
+		Expect(watcher.Reload()).NotTo(Succeed())
+		Expect(settings.RequeueAfter()).To(Equal(DefaultRequeueAfter))
+	})
+})
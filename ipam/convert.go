@@ -0,0 +1,463 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/go-logr/logr"
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// ConvertToInCluster converts an IPPool to a CAPI InClusterIPPool
+	ConvertToInCluster = "capi"
+	// ConvertToMetal3 converts a CAPI InClusterIPPool to an IPPool
+	ConvertToMetal3 = "metal3"
+)
+
+var (
+	// InClusterIPPoolGVK is the kind of the pools of the CAPI in-cluster IPAM
+	// provider
+	InClusterIPPoolGVK = schema.GroupVersionKind{
+		Group:   "ipam.cluster.x-k8s.io",
+		Version: "v1alpha2",
+		Kind:    "InClusterIPPool",
+	}
+	// CAPIIPAddressGVK is the kind of the CAPI IP addresses
+	CAPIIPAddressGVK = schema.GroupVersionKind{
+		Group:   "ipam.cluster.x-k8s.io",
+		Version: "v1alpha1",
+		Kind:    "IPAddress",
+	}
+	// CAPIIPAddressClaimGVK is the kind of the CAPI IP address claims
+	CAPIIPAddressClaimGVK = schema.GroupVersionKind{
+		Group:   "ipam.cluster.x-k8s.io",
+		Version: "v1alpha1",
+		Kind:    "IPAddressClaim",
+	}
+)
+
+// ConvertPool converts the pool with the given key, with its addresses and
+// claims, to the given provider. The objects returned are ordered for their
+// creation: the pool, the addresses, then the claims, so that the claims are
+// bound to their former addresses. It returns an error if an allocation
+// cannot be converted or would be lost, and warnings for the settings without
+// an equivalent. It does not modify anything.
+func ConvertPool(ctx context.Context, cl client.Client, key client.ObjectKey,
+	target string, log logr.Logger,
+) ([]client.Object, []string, error) {
+	switch target {
+	case ConvertToInCluster:
+		return convertToInCluster(ctx, cl, key, log)
+	case ConvertToMetal3:
+		return convertToMetal3(ctx, cl, key, log)
+	}
+	return nil, nil, errors.New(fmt.Sprintf("unknown conversion target %s", target))
+}
+
+// convertToInCluster converts an IPPool to an InClusterIPPool, its IPAddresses
+// and IPClaims to CAPI IPAddresses and IPAddressClaims
+func convertToInCluster(ctx context.Context, cl client.Client, key client.ObjectKey,
+	log logr.Logger,
+) ([]client.Object, []string, error) {
+	ipPool := &ipamv1.IPPool{}
+	if err := cl.Get(ctx, key, ipPool); err != nil {
+		return nil, nil, err
+	}
+	if ipPool.Spec.DelegatedPrefix != 0 {
+		return nil, nil, errors.New("the IPPool delegates prefixes, a CAPI claim has a single address")
+	}
+	warnings := inClusterWarnings(ipPool)
+
+	addresses := []interface{}{}
+	prefix, gateway := 0, ""
+	for i, pool := range ipPool.Spec.Pools {
+		poolRange, err := ipamv1.NewPoolRange(pool)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "invalid pool %d", i)
+		}
+		poolPrefix := pool.Prefix
+		if poolPrefix == 0 {
+			poolPrefix = poolRange.Prefix()
+		}
+		if poolPrefix == 0 {
+			poolPrefix = ipPool.Spec.Prefix
+		}
+		poolGateway := pool.Gateway
+		if poolGateway == nil {
+			poolGateway = ipPool.Spec.Gateway
+		}
+		if i == 0 {
+			prefix, gateway = poolPrefix, formatGateway(poolGateway)
+		} else if poolPrefix != prefix || formatGateway(poolGateway) != gateway {
+			return nil, nil, errors.New(fmt.Sprintf(
+				"pool %d has another prefix or gateway than pool 0, an InClusterIPPool has a single prefix and gateway", i,
+			))
+		}
+		addresses = append(addresses, formatInClusterRange(pool, poolRange))
+	}
+	if prefix == 0 {
+		return nil, nil, errors.New("the IPPool has no prefix")
+	}
+
+	inClusterPool := newUnstructured(InClusterIPPoolGVK, ipPool.Name, ipPool.Namespace)
+	inClusterPool.Object["spec"] = map[string]interface{}{
+		"addresses": addresses,
+		"prefix":    int64(prefix),
+	}
+	if gateway != "" {
+		inClusterPool.Object["spec"].(map[string]interface{})["gateway"] = gateway
+	}
+	poolRef := map[string]interface{}{
+		"apiGroup": InClusterIPPoolGVK.Group,
+		"kind":     InClusterIPPoolGVK.Kind,
+		"name":     ipPool.Name,
+	}
+	objects := []client.Object{inClusterPool}
+
+	// The addresses are named after their claims, as the in-cluster provider
+	// does
+	addressObjects := ipamv1.IPAddressList{}
+	if err := listPages(ctx, cl, &addressObjects, listPageSize, client.InNamespace(ipPool.Namespace)); err != nil {
+		return nil, nil, err
+	}
+	for _, addressObject := range addressObjects.Items {
+		if !ipPool.IsNamed(addressObject.Spec.Pool.Name) {
+			continue
+		}
+		if err := checkInClusterAddress(ipPool, &addressObject); err != nil {
+			return nil, nil, err
+		}
+		address := newUnstructured(CAPIIPAddressGVK, addressObject.Spec.Claim.Name, ipPool.Namespace)
+		address.Object["spec"] = map[string]interface{}{
+			"claimRef": map[string]interface{}{"name": addressObject.Spec.Claim.Name},
+			"poolRef":  poolRef,
+			"address":  string(addressObject.Spec.Address),
+			"prefix":   int64(addressObject.Spec.Prefix),
+			"gateway":  formatGateway(addressObject.Spec.Gateway),
+		}
+		objects = append(objects, address)
+		log.Info("Converted the IPAddress", "IPAddress", addressObject.Name, "address", addressObject.Spec.Address)
+	}
+
+	claims := ipamv1.IPClaimList{}
+	if err := listPages(ctx, cl, &claims, listPageSize); err != nil {
+		return nil, nil, err
+	}
+	for _, claim := range claims.Items {
+		poolNamespace := claim.Spec.Pool.Namespace
+		if poolNamespace == "" {
+			poolNamespace = claim.Namespace
+		}
+		if !ipPool.IsNamed(claim.Spec.Pool.Name) || poolNamespace != ipPool.Namespace {
+			continue
+		}
+		if claim.Namespace != ipPool.Namespace {
+			return nil, nil, errors.New(fmt.Sprintf(
+				"the IPClaim %s/%s is from another namespace than the IPPool", claim.Namespace, claim.Name,
+			))
+		}
+		addressClaim := newUnstructured(CAPIIPAddressClaimGVK, claim.Name, claim.Namespace)
+		addressClaim.SetLabels(claim.Labels)
+		addressClaim.Object["spec"] = map[string]interface{}{
+			"poolRef": poolRef,
+		}
+		objects = append(objects, addressClaim)
+	}
+
+	return objects, warnings, verifyConversion(objects, ipPool.Namespace)
+}
+
+// inClusterWarnings returns the settings of the IPPool without an equivalent
+// in the InClusterIPPools
+func inClusterWarnings(ipPool *ipamv1.IPPool) []string {
+	warnings := []string{}
+	if len(ipPool.Spec.DNSServers) != 0 || len(ipPool.Spec.SearchDomains) != 0 ||
+		len(ipPool.Spec.NTPServers) != 0 || ipPool.Spec.DomainName != "" {
+		warnings = append(warnings, "the DNS, NTP and domain settings are not converted")
+	}
+	if len(ipPool.Spec.PreAllocations) != 0 {
+		warnings = append(warnings, "the preAllocations are not converted, only their IPAddresses")
+	}
+	if len(ipPool.Spec.AllowedNamespaces) != 0 {
+		warnings = append(warnings, "the allowedNamespaces are not converted")
+	}
+	return warnings
+}
+
+// checkInClusterAddress verifies that the IPAddress can be converted to a
+// CAPI IPAddress
+func checkInClusterAddress(ipPool *ipamv1.IPPool, addressObject *ipamv1.IPAddress) error {
+	switch {
+	case addressObject.Spec.Claim.Name == "":
+		return errors.New(fmt.Sprintf("the IPAddress %s has no claim", addressObject.Name))
+	case addressObject.Spec.Claim.Namespace != "" && addressObject.Spec.Claim.Namespace != ipPool.Namespace:
+		return errors.New(fmt.Sprintf("the IPAddress %s is claimed from another namespace", addressObject.Name))
+	case addressObject.Labels[ipamv1.AddressRoleLabel] != "":
+		return errors.New(fmt.Sprintf("the IPAddress %s has a role, a CAPI claim has a single address", addressObject.Name))
+	}
+	return nil
+}
+
+// formatInClusterRange renders a pool as an entry of the addresses of an
+// InClusterIPPool: a subnet, a range or a single address
+func formatInClusterRange(pool ipamv1.Pool, poolRange *ipamv1.PoolRange) string {
+	if pool.Start == nil && pool.End == nil {
+		return string(*pool.Subnet)
+	}
+	first, last := poolRange.Bounds()
+	if first.Equal(last) {
+		return first.String()
+	}
+	return first.String() + "-" + last.String()
+}
+
+// formatGateway renders an optional gateway
+func formatGateway(gateway *ipamv1.IPAddressStr) string {
+	if gateway == nil {
+		return ""
+	}
+	return string(*gateway)
+}
+
+// convertToMetal3 converts an InClusterIPPool to an IPPool, its CAPI
+// IPAddresses and IPAddressClaims to IPAddresses and IPClaims
+func convertToMetal3(ctx context.Context, cl client.Client, key client.ObjectKey,
+	log logr.Logger,
+) ([]client.Object, []string, error) {
+	inClusterPool := newUnstructured(InClusterIPPoolGVK, key.Name, key.Namespace)
+	if err := cl.Get(ctx, key, inClusterPool); err != nil {
+		return nil, nil, err
+	}
+	ranges, _, err := unstructured.NestedStringSlice(inClusterPool.Object, "spec", "addresses")
+	if err != nil {
+		return nil, nil, err
+	}
+	prefix, _, err := unstructured.NestedInt64(inClusterPool.Object, "spec", "prefix")
+	if err != nil {
+		return nil, nil, err
+	}
+	gateway, _, err := unstructured.NestedString(inClusterPool.Object, "spec", "gateway")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ipPool := &ipamv1.IPPool{
+		Spec: ipamv1.IPPoolSpec{
+			NamePrefix: key.Name,
+			Prefix:     int(prefix),
+		},
+	}
+	ipPool.TypeMeta, ipPool.ObjectMeta = convertedMeta("IPPool", key.Name, key.Namespace, inClusterPool.GetLabels())
+	if gateway != "" {
+		ipPool.Spec.Gateway = (*ipamv1.IPAddressStr)(&gateway)
+	}
+	for _, addressRange := range ranges {
+		pool, err := parseInClusterRange(addressRange)
+		if err != nil {
+			return nil, nil, err
+		}
+		ipPool.Spec.Pools = append(ipPool.Spec.Pools, pool)
+	}
+	ipPoolMgr, err := NewIPPoolManager(cl, ipPool, log)
+	if err != nil {
+		return nil, nil, err
+	}
+	objects := []client.Object{ipPool}
+
+	capiAddresses := &unstructured.UnstructuredList{}
+	capiAddresses.SetGroupVersionKind(CAPIIPAddressGVK.GroupVersion().WithKind(CAPIIPAddressGVK.Kind + "List"))
+	if err := listPages(ctx, cl, capiAddresses, listPageSize, client.InNamespace(key.Namespace)); err != nil {
+		return nil, nil, err
+	}
+	for _, capiAddress := range capiAddresses.Items {
+		if !isInClusterPoolRef(capiAddress.Object, key.Name) {
+			continue
+		}
+		claimName, _, _ := unstructured.NestedString(capiAddress.Object, "spec", "claimRef", "name")
+		address, _, _ := unstructured.NestedString(capiAddress.Object, "spec", "address")
+		addressPrefix, _, _ := unstructured.NestedInt64(capiAddress.Object, "spec", "prefix")
+		addressGateway, _, _ := unstructured.NestedString(capiAddress.Object, "spec", "gateway")
+		if claimName == "" || address == "" {
+			return nil, nil, errors.New(fmt.Sprintf("the IPAddress %s has no claim or address", capiAddress.GetName()))
+		}
+		addressObject := &ipamv1.IPAddress{
+			Spec: ipamv1.IPAddressSpec{
+				Pool:    corev1.ObjectReference{Name: key.Name, Namespace: key.Namespace},
+				Claim:   corev1.ObjectReference{Name: claimName, Namespace: key.Namespace},
+				Address: ipamv1.IPAddressStr(address),
+				Prefix:  int(addressPrefix),
+			},
+		}
+		addressObject.TypeMeta, addressObject.ObjectMeta = convertedMeta("IPAddress",
+			ipPoolMgr.formatAddressName(ipamv1.IPAddressStr(address)), key.Namespace, capiAddress.GetLabels(),
+		)
+		if addressGateway != "" {
+			addressObject.Spec.Gateway = (*ipamv1.IPAddressStr)(&addressGateway)
+		}
+		objects = append(objects, addressObject)
+		log.Info("Converted the IPAddress", "IPAddress", capiAddress.GetName(), "address", address)
+	}
+
+	capiClaims := &unstructured.UnstructuredList{}
+	capiClaims.SetGroupVersionKind(CAPIIPAddressClaimGVK.GroupVersion().WithKind(CAPIIPAddressClaimGVK.Kind + "List"))
+	if err := listPages(ctx, cl, capiClaims, listPageSize, client.InNamespace(key.Namespace)); err != nil {
+		return nil, nil, err
+	}
+	for _, capiClaim := range capiClaims.Items {
+		if !isInClusterPoolRef(capiClaim.Object, key.Name) {
+			continue
+		}
+		claim := &ipamv1.IPClaim{
+			Spec: ipamv1.IPClaimSpec{
+				Pool: corev1.ObjectReference{Name: key.Name, Namespace: key.Namespace},
+			},
+		}
+		claim.TypeMeta, claim.ObjectMeta = convertedMeta("IPClaim", capiClaim.GetName(), key.Namespace, capiClaim.GetLabels())
+		objects = append(objects, claim)
+	}
+
+	return objects, []string{}, verifyConversion(objects, key.Namespace)
+}
+
+// parseInClusterRange parses an entry of the addresses of an InClusterIPPool:
+// a subnet, a range or a single address
+func parseInClusterRange(addressRange string) (ipamv1.Pool, error) {
+	addressRange = strings.TrimSpace(addressRange)
+	if strings.Contains(addressRange, "/") {
+		if _, _, err := net.ParseCIDR(addressRange); err != nil {
+			return ipamv1.Pool{}, errors.Wrapf(err, "invalid subnet %s", addressRange)
+		}
+		subnet := ipamv1.IPSubnetStr(addressRange)
+		return ipamv1.Pool{Subnet: &subnet}, nil
+	}
+	bounds := strings.SplitN(addressRange, "-", 2)
+	for _, bound := range bounds {
+		if net.ParseIP(strings.TrimSpace(bound)) == nil {
+			return ipamv1.Pool{}, errors.New(fmt.Sprintf("invalid address range %s", addressRange))
+		}
+	}
+	start := ipamv1.IPAddressStr(strings.TrimSpace(bounds[0]))
+	end := ipamv1.IPAddressStr(strings.TrimSpace(bounds[len(bounds)-1]))
+	return ipamv1.Pool{Start: &start, End: &end}, nil
+}
+
+// isInClusterPoolRef returns true if the pool reference of the CAPI object
+// is the InClusterIPPool with the given name
+func isInClusterPoolRef(object map[string]interface{}, poolName string) bool {
+	kind, _, _ := unstructured.NestedString(object, "spec", "poolRef", "kind")
+	name, _, _ := unstructured.NestedString(object, "spec", "poolRef", "name")
+	return kind == InClusterIPPoolGVK.Kind && name == poolName
+}
+
+// verifyConversion verifies that no allocation is lost by the conversion:
+// each address is in the ranges of the converted pool, allocated once, and
+// its claim is converted
+func verifyConversion(objects []client.Object, namespace string) error {
+	poolRanges := []*ipamv1.PoolRange{}
+	claims := map[string]bool{}
+	addresses := map[string]string{}
+	for _, object := range objects {
+		switch typed := object.(type) {
+		case *ipamv1.IPPool:
+			for _, pool := range typed.Spec.Pools {
+				poolRange, err := ipamv1.NewPoolRange(pool)
+				if err != nil {
+					return err
+				}
+				poolRanges = append(poolRanges, poolRange)
+			}
+		case *ipamv1.IPClaim:
+			claims[typed.Name] = true
+		case *ipamv1.IPAddress:
+			addresses[string(typed.Spec.Address)] = typed.Spec.Claim.Name
+		case *unstructured.Unstructured:
+			switch typed.GroupVersionKind() {
+			case InClusterIPPoolGVK:
+				ranges, _, _ := unstructured.NestedStringSlice(typed.Object, "spec", "addresses")
+				for _, addressRange := range ranges {
+					pool, err := parseInClusterRange(addressRange)
+					if err != nil {
+						return err
+					}
+					poolRange, err := ipamv1.NewPoolRange(pool)
+					if err != nil {
+						return err
+					}
+					poolRanges = append(poolRanges, poolRange)
+				}
+			case CAPIIPAddressClaimGVK:
+				claims[typed.GetName()] = true
+			case CAPIIPAddressGVK:
+				address, _, _ := unstructured.NestedString(typed.Object, "spec", "address")
+				claim, _, _ := unstructured.NestedString(typed.Object, "spec", "claimRef", "name")
+				if _, ok := addresses[address]; ok {
+					return errors.New(fmt.Sprintf("the address %s is allocated twice", address))
+				}
+				addresses[address] = claim
+			}
+		}
+	}
+
+	for address, claim := range addresses {
+		inRange := false
+		for _, poolRange := range poolRanges {
+			if poolRange.Contains(net.ParseIP(address)) {
+				inRange = true
+				break
+			}
+		}
+		if !inRange {
+			return errors.New(fmt.Sprintf("the address %s is out of the ranges of the converted pool", address))
+		}
+		if !claims[claim] {
+			return errors.New(fmt.Sprintf("the claim %s/%s of the address %s is not converted", namespace, claim, address))
+		}
+	}
+	return nil
+}
+
+// convertedMeta returns the type and object metadata of a converted object
+func convertedMeta(kind, name, namespace string, labels map[string]string) (metav1.TypeMeta, metav1.ObjectMeta) {
+	return metav1.TypeMeta{
+		APIVersion: ipamv1.GroupVersion.String(),
+		Kind:       kind,
+	}, metav1.ObjectMeta{
+		Name:      name,
+		Namespace: namespace,
+		Labels:    labels,
+	}
+}
+
+// newUnstructured returns an unstructured object of the given kind
+func newUnstructured(gvk schema.GroupVersionKind, name, namespace string) *unstructured.Unstructured {
+	object := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	object.SetGroupVersionKind(gvk)
+	object.SetName(name)
+	object.SetNamespace(namespace)
+	return object
+}
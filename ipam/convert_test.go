@@ -0,0 +1,265 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog/v2/klogr"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Convert", func() {
+
+	type testCaseConvert struct {
+		objects          []client.Object
+		target           string
+		expectError      bool
+		expectedWarnings []string
+		expectedObjects  []client.Object
+	}
+
+	convertScheme := func() *runtime.Scheme {
+		s := setupScheme()
+		for _, gvk := range []struct{ kind, version string }{
+			{InClusterIPPoolGVK.Kind, InClusterIPPoolGVK.Version},
+			{CAPIIPAddressGVK.Kind, CAPIIPAddressGVK.Version},
+			{CAPIIPAddressClaimGVK.Kind, CAPIIPAddressClaimGVK.Version},
+		} {
+			gv := InClusterIPPoolGVK.GroupVersion()
+			gv.Version = gvk.version
+			s.AddKnownTypeWithName(gv.WithKind(gvk.kind), &unstructured.Unstructured{})
+			s.AddKnownTypeWithName(gv.WithKind(gvk.kind+"List"), &unstructured.UnstructuredList{})
+		}
+		return s
+	}
+
+	ipPool := func(pools []ipamv1.Pool, dnsServers []ipamv1.IPAddressStr) *ipamv1.IPPool {
+		return &ipamv1.IPPool{
+			ObjectMeta: testObjectMeta,
+			Spec: ipamv1.IPPoolSpec{
+				NamePrefix: "abc",
+				Pools:      pools,
+				Prefix:     24,
+				Gateway:    (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.254")),
+				DNSServers: dnsServers,
+			},
+		}
+	}
+
+	ipAddress := func(name, claim, address string, labels map[string]string) *ipamv1.IPAddress {
+		return &ipamv1.IPAddress{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "myns",
+				Labels:    labels,
+			},
+			Spec: ipamv1.IPAddressSpec{
+				Pool:    corev1.ObjectReference{Name: "abc", Namespace: "myns"},
+				Claim:   corev1.ObjectReference{Name: claim, Namespace: "myns"},
+				Address: ipamv1.IPAddressStr(address),
+				Prefix:  24,
+				Gateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.254")),
+			},
+		}
+	}
+
+	ipClaim := func(name string) *ipamv1.IPClaim {
+		claim := &ipamv1.IPClaim{
+			Spec: ipamv1.IPClaimSpec{
+				Pool: corev1.ObjectReference{Name: "abc", Namespace: "myns"},
+			},
+		}
+		claim.TypeMeta, claim.ObjectMeta = convertedMeta("IPClaim", name, "myns", nil)
+		return claim
+	}
+
+	poolRef := map[string]interface{}{
+		"apiGroup": "ipam.cluster.x-k8s.io",
+		"kind":     "InClusterIPPool",
+		"name":     "abc",
+	}
+
+	inClusterPool := func(addresses ...interface{}) *unstructured.Unstructured {
+		pool := newUnstructured(InClusterIPPoolGVK, "abc", "myns")
+		pool.Object["spec"] = map[string]interface{}{
+			"addresses": addresses,
+			"prefix":    int64(24),
+			"gateway":   "192.168.0.254",
+		}
+		return pool
+	}
+
+	capiAddress := func(claim, address string) *unstructured.Unstructured {
+		capiAddress := newUnstructured(CAPIIPAddressGVK, claim, "myns")
+		capiAddress.Object["spec"] = map[string]interface{}{
+			"claimRef": map[string]interface{}{"name": claim},
+			"poolRef":  poolRef,
+			"address":  address,
+			"prefix":   int64(24),
+			"gateway":  "192.168.0.254",
+		}
+		return capiAddress
+	}
+
+	capiClaim := func(name string) *unstructured.Unstructured {
+		claim := newUnstructured(CAPIIPAddressClaimGVK, name, "myns")
+		claim.Object["spec"] = map[string]interface{}{
+			"poolRef": poolRef,
+		}
+		return claim
+	}
+
+	rangePools := []ipamv1.Pool{
+		{
+			Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+			End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.20")),
+		},
+		{
+			Subnet: (*ipamv1.IPSubnetStr)(pointer.StringPtr("192.168.1.0/24")),
+		},
+	}
+
+	DescribeTable("Test ConvertPool",
+		func(tc testCaseConvert) {
+			c := fakeclient.NewClientBuilder().WithScheme(convertScheme()).WithObjects(
+				tc.objects...,
+			).Build()
+
+			objects, warnings, err := ConvertPool(context.TODO(), c,
+				client.ObjectKey{Name: "abc", Namespace: "myns"}, tc.target, klogr.New(),
+			)
+			if tc.expectError {
+				Expect(err).To(HaveOccurred())
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(Equal(tc.expectedWarnings))
+			Expect(objects).To(Equal(tc.expectedObjects))
+		},
+		Entry("Unknown target", testCaseConvert{
+			objects:     []client.Object{ipPool(rangePools, nil)},
+			target:      "other",
+			expectError: true,
+		}),
+		Entry("IPPool not found", testCaseConvert{
+			target:      ConvertToInCluster,
+			expectError: true,
+		}),
+		Entry("IPPool to InClusterIPPool", testCaseConvert{
+			objects: []client.Object{
+				ipPool(rangePools, []ipamv1.IPAddressStr{"8.8.8.8"}),
+				ipAddress("abc-192-168-0-11", "bcd", "192.168.0.11", nil),
+				ipClaim("bcd"),
+			},
+			target:           ConvertToInCluster,
+			expectedWarnings: []string{"the DNS, NTP and domain settings are not converted"},
+			expectedObjects: []client.Object{
+				inClusterPool("192.168.0.10-192.168.0.20", "192.168.1.0/24"),
+				capiAddress("bcd", "192.168.0.11"),
+				capiClaim("bcd"),
+			},
+		}),
+		Entry("IPPool to InClusterIPPool, address with a role", testCaseConvert{
+			objects: []client.Object{
+				ipPool(rangePools, nil),
+				ipAddress("abc-192-168-0-11", "bcd", "192.168.0.11",
+					map[string]string{ipamv1.AddressRoleLabel: "storage"},
+				),
+				ipClaim("bcd"),
+			},
+			target:      ConvertToInCluster,
+			expectError: true,
+		}),
+		Entry("IPPool to InClusterIPPool, claim not found", testCaseConvert{
+			objects: []client.Object{
+				ipPool(rangePools, nil),
+				ipAddress("abc-192-168-0-11", "bcd", "192.168.0.11", nil),
+			},
+			target:      ConvertToInCluster,
+			expectError: true,
+		}),
+		Entry("IPPool to InClusterIPPool, pools with different prefixes", testCaseConvert{
+			objects: []client.Object{
+				ipPool([]ipamv1.Pool{
+					rangePools[0],
+					{
+						Start:  (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.10")),
+						End:    (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.20")),
+						Prefix: 25,
+					},
+				}, nil),
+			},
+			target:      ConvertToInCluster,
+			expectError: true,
+		}),
+		Entry("InClusterIPPool to IPPool", testCaseConvert{
+			objects: []client.Object{
+				inClusterPool("192.168.0.10-192.168.0.20", "192.168.1.0/24", "192.168.0.30"),
+				capiAddress("bcd", "192.168.0.11"),
+				capiClaim("bcd"),
+			},
+			target:           ConvertToMetal3,
+			expectedWarnings: []string{},
+			expectedObjects: []client.Object{
+				func() client.Object {
+					pool := ipPool(append(rangePools, ipamv1.Pool{
+						Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.30")),
+						End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.30")),
+					}), nil)
+					pool.TypeMeta, pool.ObjectMeta = convertedMeta("IPPool", "abc", "myns", nil)
+					return pool
+				}(),
+				func() client.Object {
+					address := ipAddress("abc-192-168-0-11", "bcd", "192.168.0.11", nil)
+					address.TypeMeta, address.ObjectMeta = convertedMeta("IPAddress",
+						"abc-192-168-0-11", "myns", nil,
+					)
+					return address
+				}(),
+				ipClaim("bcd"),
+			},
+		}),
+		Entry("InClusterIPPool to IPPool, address out of the ranges", testCaseConvert{
+			objects: []client.Object{
+				inClusterPool("192.168.0.10-192.168.0.20"),
+				capiAddress("bcd", "192.168.0.31"),
+				capiClaim("bcd"),
+			},
+			target:      ConvertToMetal3,
+			expectError: true,
+		}),
+		Entry("InClusterIPPool to IPPool, invalid range", testCaseConvert{
+			objects: []client.Object{
+				inClusterPool("192.168.0.10-abc"),
+			},
+			target:      ConvertToMetal3,
+			expectError: true,
+		}),
+	)
+})
@@ -0,0 +1,106 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ExplainAllocation describes the allocation of the addresses of the claim:
+// the addresses it already has and, for the roles without an address, the
+// pools considered, the candidates skipped and why, and the final choice. It
+// runs the allocator without modifying anything.
+func ExplainAllocation(ctx context.Context, cl client.Client, key client.ObjectKey,
+	log logr.Logger,
+) ([]string, error) {
+	addressClaim := &ipamv1.IPClaim{}
+	if err := cl.Get(ctx, key, addressClaim); err != nil {
+		return nil, err
+	}
+	ipPool, err := ipamv1.GetClaimPool(ctx, cl, addressClaim)
+	if err != nil {
+		return nil, err
+	}
+
+	poolDesc := fmt.Sprintf("IPPool %s", client.ObjectKeyFromObject(ipPool))
+	if ipPool.IsClusterScoped() {
+		poolDesc = fmt.Sprintf("ClusterIPPool %s", ipPool.Name)
+	}
+	steps := []string{
+		fmt.Sprintf("IPClaim %s, %s", key, poolDesc),
+	}
+	// The IPAddress objects adopted while fetching the allocations are not
+	// updated
+	ipPoolMgr, err := NewIPPoolManager(client.NewDryRunClient(cl), ipPool,
+		log.WithValues("metal3-ippool", client.ObjectKeyFromObject(ipPool)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	ipPoolMgr.trace = func(step string) {
+		steps = append(steps, step)
+	}
+
+	if !ipPoolMgr.isClaimForPool(addressClaim) {
+		return append(steps, "no address allocated: the namespace of the claim is not allowed by the pool"), nil
+	}
+	addresses, err := ipPoolMgr.getIndexes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	claimKey := ipPoolMgr.allocationKey(addressClaim.Name, addressClaim.Namespace)
+	missingRoles := []string{}
+	for _, role := range ipPoolMgr.addressRoles(addressClaim) {
+		address, ok := ipPool.Status.Allocations[addressKey(claimKey, role)]
+		if !ok {
+			missingRoles = append(missingRoles, role)
+			continue
+		}
+		steps = append(steps, fmt.Sprintf("%s already allocated%s", address, describeRole(role)))
+	}
+	if len(missingRoles) == 0 {
+		return steps, nil
+	}
+
+	if ipPool.Spec.ExternallyManaged {
+		return append(steps, "no address allocated: the pool is externally managed, "+
+			"the IPAddresses of the claim are not imported yet"), nil
+	}
+	for _, role := range missingRoles {
+		steps = append(steps, "allocating an address"+describeRole(role))
+	}
+	// Allocate on a copy, the allocator records its errors in the claim
+	_, err = ipPoolMgr.allocateAddressSet(addressClaim.DeepCopy(), missingRoles, addresses)
+	if err != nil && addressClaim.IsAddressSet() && len(missingRoles) > 1 {
+		steps = append(steps, "no address allocated: "+err.Error())
+	}
+	return steps, nil
+}
+
+// describeRole renders the role of an address, if any
+func describeRole(role string) string {
+	if role == "" {
+		return ""
+	}
+	return fmt.Sprintf(" for the role %s", role)
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/klogr"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Explain", func() {
+
+	type testCaseExplain struct {
+		claim          string
+		preAllocations map[string]ipamv1.IPAddressStr
+		expectError    bool
+		expectedSteps  []string
+	}
+
+	newClaim := func(name string) *ipamv1.IPClaim {
+		return &ipamv1.IPClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "myns",
+			},
+			Spec: ipamv1.IPClaimSpec{
+				Pool: corev1.ObjectReference{Name: "abc"},
+			},
+		}
+	}
+
+	DescribeTable("Test ExplainAllocation",
+		func(tc testCaseExplain) {
+			ipPool := &ipamv1.IPPool{
+				ObjectMeta: testObjectMeta,
+				Spec: ipamv1.IPPoolSpec{
+					NamePrefix: "abc",
+					Pools: []ipamv1.Pool{
+						{
+							Start:  (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.0")),
+							End:    (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.3")),
+							Subnet: (*ipamv1.IPSubnetStr)(pointer.StringPtr("192.168.0.0/30")),
+						},
+					},
+					PreAllocations: tc.preAllocations,
+				},
+				Status: ipamv1.IPPoolStatus{
+					Allocations: map[string]ipamv1.IPAddressStr{
+						"bcd": "192.168.0.1",
+					},
+				},
+			}
+			address := &ipamv1.IPAddress{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "abc-192-168-0-1",
+					Namespace: "myns",
+				},
+				Spec: ipamv1.IPAddressSpec{
+					Pool:    corev1.ObjectReference{Name: "abc"},
+					Claim:   corev1.ObjectReference{Name: "bcd"},
+					Address: "192.168.0.1",
+				},
+			}
+			c := fakeclient.NewClientBuilder().WithScheme(setupScheme()).WithObjects(
+				ipPool, address, newClaim("bcd"), newClaim("cde"),
+			).Build()
+
+			steps, err := ExplainAllocation(context.TODO(), c,
+				client.ObjectKey{Name: tc.claim, Namespace: "myns"}, klogr.New(),
+			)
+			if tc.expectError {
+				Expect(err).To(HaveOccurred())
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(steps).To(Equal(tc.expectedSteps))
+
+			// Nothing was modified
+			pool := &ipamv1.IPPool{}
+			err = c.Get(context.TODO(), client.ObjectKeyFromObject(ipPool), pool)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pool.Status.Allocations).To(Equal(ipPool.Status.Allocations))
+			addresses := ipamv1.IPAddressList{}
+			Expect(c.List(context.TODO(), &addresses)).To(Succeed())
+			Expect(addresses.Items).To(HaveLen(1))
+		},
+		Entry("Claim not found", testCaseExplain{
+			claim:       "def",
+			expectError: true,
+		}),
+		Entry("Address already allocated", testCaseExplain{
+			claim: "bcd",
+			expectedSteps: []string{
+				"IPClaim myns/bcd, IPPool myns/abc",
+				"192.168.0.1 already allocated",
+			},
+		}),
+		Entry("Address allocated", testCaseExplain{
+			claim: "cde",
+			expectedSteps: []string{
+				"IPClaim myns/cde, IPPool myns/abc",
+				"allocating an address",
+				"pool 0 considered: start 192.168.0.0, end 192.168.0.3, subnet 192.168.0.0/30",
+				"192.168.0.0 skipped: network or broadcast address",
+				"192.168.0.1 skipped: allocated to bcd",
+				"192.168.0.2 allocated from pool 0, prefix 30, gateway none",
+			},
+		}),
+		Entry("Pool exhausted", testCaseExplain{
+			claim: "cde",
+			preAllocations: map[string]ipamv1.IPAddressStr{
+				"def": "192.168.0.2",
+			},
+			expectedSteps: []string{
+				"IPClaim myns/cde, IPPool myns/abc",
+				"allocating an address",
+				"pool 0 considered: start 192.168.0.0, end 192.168.0.3, subnet 192.168.0.0/30",
+				"192.168.0.0 skipped: network or broadcast address",
+				"192.168.0.1 skipped: allocated to bcd",
+				"192.168.0.2 skipped: pre-allocated or reserved",
+				"192.168.0.3 skipped: network or broadcast address",
+				"pool 0 exhausted",
+				"no address allocated: Exhausted IP Pools",
+			},
+		}),
+		Entry("Pre-allocated address", testCaseExplain{
+			claim: "cde",
+			preAllocations: map[string]ipamv1.IPAddressStr{
+				"cde": "192.168.0.2",
+			},
+			expectedSteps: []string{
+				"IPClaim myns/cde, IPPool myns/abc",
+				"allocating an address",
+				"192.168.0.2 is pre-allocated to cde",
+				"pool 0 considered: start 192.168.0.0, end 192.168.0.3, subnet 192.168.0.0/30",
+				"192.168.0.2 allocated from pool 0, prefix 30, gateway none",
+			},
+		}),
+	)
+})
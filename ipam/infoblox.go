@@ -0,0 +1,256 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultInfobloxTimeout is the timeout of the requests to Infoblox of the
+	// backends that do not set it
+	defaultInfobloxTimeout = 10 * time.Second
+	// defaultInfobloxNetworkView is the network view of the addresses
+	// reserved in Infoblox by the backends that do not set it
+	defaultInfobloxNetworkView = "default"
+	// infobloxReservedMAC is the MAC address of the IPv4 fixed addresses,
+	// reserving them without serving them to a DHCP client
+	infobloxReservedMAC = "00:00:00:00:00:00"
+	// infobloxReservedDUID is the DUID of the IPv6 fixed addresses
+	infobloxReservedDUID = "00:00"
+)
+
+// infobloxBackend reserves the addresses as fixed addresses of Infoblox
+type infobloxBackend struct {
+	url         string
+	networkView string
+	username    string
+	password    string
+	timeout     time.Duration
+}
+
+// infobloxFixedAddress is a fixedaddress or ipv6fixedaddress of Infoblox
+type infobloxFixedAddress struct {
+	Ref         string `json:"_ref,omitempty"`
+	IPv4Address string `json:"ipv4addr,omitempty"`
+	IPv6Address string `json:"ipv6addr,omitempty"`
+	MAC         string `json:"mac,omitempty"`
+	DUID        string `json:"duid,omitempty"`
+	NetworkView string `json:"network_view,omitempty"`
+	Comment     string `json:"comment"`
+}
+
+// infobloxError is an unsuccessful response of Infoblox
+type infobloxError struct {
+	method     string
+	path       string
+	status     string
+	statusCode int
+	text       string
+}
+
+func (e *infobloxError) Error() string {
+	return fmt.Sprintf("Infoblox returned %s to %s %s: %s", e.status, e.method, e.path, e.text)
+}
+
+// isInfobloxNotFound returns true if Infoblox did not find the object
+func isInfobloxNotFound(err error) bool {
+	infobloxErr, ok := errors.Cause(err).(*infobloxError)
+	return ok && (infobloxErr.statusCode == http.StatusNotFound ||
+		strings.Contains(infobloxErr.text, "AdmConDataNotFoundError"))
+}
+
+// newInfobloxBackend returns the Infoblox driver of the backend
+func newInfobloxBackend(backend *ipamv1.InfobloxBackend, username, password string) *infobloxBackend {
+	infoblox := &infobloxBackend{
+		url:         strings.TrimRight(backend.URL, "/"),
+		networkView: backend.NetworkView,
+		username:    username,
+		password:    password,
+		timeout:     defaultInfobloxTimeout,
+	}
+	if infoblox.networkView == "" {
+		infoblox.networkView = defaultInfobloxNetworkView
+	}
+	if backend.Timeout != nil {
+		infoblox.timeout = backend.Timeout.Duration
+	}
+	return infoblox
+}
+
+// Reserve creates or updates the fixed address in Infoblox. Its comment is
+// the description of the address, the tags are not used.
+func (b *infobloxBackend) Reserve(ctx context.Context, address BackendAddress) (string, error) {
+	if address.ID != "" {
+		ref := ""
+		err := b.request(ctx, http.MethodPut, address.ID, nil,
+			infobloxFixedAddress{Comment: address.Description}, &ref,
+		)
+		if !isInfobloxNotFound(err) {
+			return ref, err
+		}
+		// The fixed address was deleted in Infoblox, it is reserved again
+	}
+	existing, err := b.findAddresses(ctx, address.Address)
+	if err != nil {
+		return "", err
+	}
+	for _, existingRecord := range existing {
+		if existingRecord.Comment == address.Owner {
+			ref := ""
+			return ref, b.request(ctx, http.MethodPut, existingRecord.Ref, nil,
+				infobloxFixedAddress{Comment: address.Description}, &ref,
+			)
+		}
+	}
+	if len(existing) != 0 {
+		return "", ErrAddressInUse
+	}
+	record := infobloxFixedAddress{
+		NetworkView: b.networkView,
+		Comment:     address.Description,
+	}
+	if isIPv4(address.Address) {
+		record.IPv4Address = string(address.Address)
+		record.MAC = infobloxReservedMAC
+	} else {
+		record.IPv6Address = string(address.Address)
+		record.DUID = infobloxReservedDUID
+	}
+	ref := ""
+	if err := b.request(ctx, http.MethodPost, infobloxObjectType(address.Address), nil, record, &ref); err != nil {
+		return "", err
+	}
+	return ref, nil
+}
+
+// Release deletes the fixed address from Infoblox
+func (b *infobloxBackend) Release(ctx context.Context, address BackendAddress) error {
+	refs := []string{}
+	if address.ID != "" {
+		refs = append(refs, address.ID)
+	} else {
+		existing, err := b.findAddresses(ctx, address.Address)
+		if err != nil {
+			return err
+		}
+		for _, existingRecord := range existing {
+			if existingRecord.Comment == address.Owner {
+				refs = append(refs, existingRecord.Ref)
+			}
+		}
+	}
+	for _, ref := range refs {
+		err := b.request(ctx, http.MethodDelete, ref, nil, nil, nil)
+		if err != nil && !isInfobloxNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// findAddresses returns the fixed addresses of Infoblox with the given
+// address in the network view of the backend
+func (b *infobloxBackend) findAddresses(ctx context.Context, address ipamv1.IPAddressStr) ([]infobloxFixedAddress, error) {
+	query := url.Values{
+		"network_view":   []string{b.networkView},
+		"_return_fields": []string{"comment"},
+	}
+	if isIPv4(address) {
+		query.Set("ipv4addr", string(address))
+	} else {
+		query.Set("ipv6addr", string(address))
+	}
+	existing := []infobloxFixedAddress{}
+	err := b.request(ctx, http.MethodGet, infobloxObjectType(address), query, nil, &existing)
+	return existing, err
+}
+
+// infobloxObjectType returns the WAPI object type of the fixed address
+func infobloxObjectType(address ipamv1.IPAddressStr) string {
+	if isIPv4(address) {
+		return "fixedaddress"
+	}
+	return "ipv6fixedaddress"
+}
+
+// isIPv4 returns true if the address is an IPv4 address
+func isIPv4(address ipamv1.IPAddressStr) bool {
+	addr, ok := parseAddr(string(address))
+	return ok && addr.is4
+}
+
+// request sends a request to the WAPI and decodes the response in the
+// result, if not nil. The path is an object type or reference.
+func (b *infobloxBackend) request(ctx context.Context, method, path string,
+	query url.Values, body, result interface{},
+) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	requestURL := b.url + "/" + path
+	if query != nil {
+		requestURL += "?" + query.Encode()
+	}
+	ctx, cancel := context.WithTimeout(ctx, b.timeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, method, requestURL, reader)
+	if err != nil {
+		return err
+	}
+	request.SetBasicAuth(b.username, b.password)
+	request.Header.Set("Accept", "application/json")
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		text, _ := ioutil.ReadAll(io.LimitReader(response.Body, 512))
+		return &infobloxError{
+			method:     method,
+			path:       path,
+			status:     response.Status,
+			statusCode: response.StatusCode,
+			text:       strings.TrimSpace(string(text)),
+		}
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(result)
+}
@@ -0,0 +1,278 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+)
+
+// fakeInfoblox serves the fixed addresses of the WAPI from memory, keyed by
+// their reference
+type fakeInfoblox struct {
+	addresses map[string]infobloxFixedAddress
+	nextID    int
+	failing   bool
+}
+
+func (n *fakeInfoblox) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if username, password, ok := r.BasicAuth(); !ok || username != "admin" || password != "infoblox" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if n.failing {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/wapi/v2.11/")
+	switch {
+	case r.Method == http.MethodGet:
+		Expect(r.URL.Query().Get("network_view")).To(Equal("default"))
+		address := r.URL.Query().Get("ipv4addr") + r.URL.Query().Get("ipv6addr")
+		list := []infobloxFixedAddress{}
+		for _, existing := range n.addresses {
+			if strings.HasPrefix(existing.Ref, path+"/") && existing.IPv4Address+existing.IPv6Address == address {
+				list = append(list, existing)
+			}
+		}
+		_ = json.NewEncoder(w).Encode(list)
+	case r.Method == http.MethodPost:
+		record := infobloxFixedAddress{}
+		Expect(json.NewDecoder(r.Body).Decode(&record)).To(Succeed())
+		n.nextID++
+		record.Ref = fmt.Sprintf("%s/%d", path, n.nextID)
+		n.addresses[record.Ref] = record
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(record.Ref)
+	default:
+		existing, ok := n.addresses[path]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"Error": "AdmConDataNotFoundError: Reference not found"}`))
+			return
+		}
+		if r.Method == http.MethodDelete {
+			delete(n.addresses, path)
+			_ = json.NewEncoder(w).Encode(path)
+			return
+		}
+		update := infobloxFixedAddress{}
+		Expect(json.NewDecoder(r.Body).Decode(&update)).To(Succeed())
+		existing.Comment = update.Comment
+		n.addresses[path] = existing
+		_ = json.NewEncoder(w).Encode(path)
+	}
+}
+
+var _ = Describe("Infoblox backend", func() {
+
+	type testCaseInfoblox struct {
+		existing          []infobloxFixedAddress
+		failing           bool
+		release           bool
+		address           BackendAddress
+		expectInUse       bool
+		expectError       bool
+		expectedID        string
+		expectedAddresses map[string]infobloxFixedAddress
+	}
+
+	DescribeTable("Test the Infoblox backend",
+		func(tc testCaseInfoblox) {
+			infoblox := &fakeInfoblox{
+				addresses: map[string]infobloxFixedAddress{},
+				nextID:    10,
+				failing:   tc.failing,
+			}
+			for _, address := range tc.existing {
+				infoblox.addresses[address.Ref] = address
+			}
+			server := httptest.NewServer(infoblox)
+			defer server.Close()
+			backend := newInfobloxBackend(&ipamv1.InfobloxBackend{URL: server.URL + "/wapi/v2.11/"},
+				"admin", "infoblox",
+			)
+
+			var err error
+			id := ""
+			if tc.release {
+				err = backend.Release(context.TODO(), tc.address)
+			} else {
+				id, err = backend.Reserve(context.TODO(), tc.address)
+			}
+			if tc.expectInUse {
+				Expect(err).To(Equal(ErrAddressInUse))
+			} else if tc.expectError {
+				Expect(err).To(HaveOccurred())
+			} else {
+				Expect(err).NotTo(HaveOccurred())
+			}
+			Expect(id).To(Equal(tc.expectedID))
+			Expect(infoblox.addresses).To(Equal(tc.expectedAddresses))
+		},
+		Entry("IPv4 address reserved", testCaseInfoblox{
+			address: BackendAddress{
+				Address:     "192.168.0.11",
+				Prefix:      24,
+				Owner:       "myns/abc",
+				Description: "myns/abc",
+			},
+			expectedID: "fixedaddress/11",
+			expectedAddresses: map[string]infobloxFixedAddress{
+				"fixedaddress/11": {
+					Ref:         "fixedaddress/11",
+					IPv4Address: "192.168.0.11",
+					MAC:         infobloxReservedMAC,
+					NetworkView: "default",
+					Comment:     "myns/abc",
+				},
+			},
+		}),
+		Entry("IPv6 address reserved", testCaseInfoblox{
+			address: BackendAddress{
+				Address:     "2001:db8::11",
+				Owner:       "myns/abc",
+				Description: "myns/abc",
+			},
+			expectedID: "ipv6fixedaddress/11",
+			expectedAddresses: map[string]infobloxFixedAddress{
+				"ipv6fixedaddress/11": {
+					Ref:         "ipv6fixedaddress/11",
+					IPv6Address: "2001:db8::11",
+					DUID:        infobloxReservedDUID,
+					NetworkView: "default",
+					Comment:     "myns/abc",
+				},
+			},
+		}),
+		Entry("Address in use", testCaseInfoblox{
+			existing: []infobloxFixedAddress{
+				{Ref: "fixedaddress/3", IPv4Address: "192.168.0.11", Comment: "router"},
+			},
+			address: BackendAddress{
+				Address:     "192.168.0.11",
+				Owner:       "myns/abc",
+				Description: "myns/abc",
+			},
+			expectInUse: true,
+			expectedAddresses: map[string]infobloxFixedAddress{
+				"fixedaddress/3": {Ref: "fixedaddress/3", IPv4Address: "192.168.0.11", Comment: "router"},
+			},
+		}),
+		Entry("Reservation of the owner adopted", testCaseInfoblox{
+			existing: []infobloxFixedAddress{
+				{Ref: "fixedaddress/3", IPv4Address: "192.168.0.11", Comment: "myns/abc"},
+			},
+			address: BackendAddress{
+				Address:     "192.168.0.11",
+				Owner:       "myns/abc",
+				Description: "web server",
+			},
+			expectedID: "fixedaddress/3",
+			expectedAddresses: map[string]infobloxFixedAddress{
+				"fixedaddress/3": {Ref: "fixedaddress/3", IPv4Address: "192.168.0.11", Comment: "web server"},
+			},
+		}),
+		Entry("Reservation updated", testCaseInfoblox{
+			existing: []infobloxFixedAddress{
+				{Ref: "fixedaddress/3", IPv4Address: "192.168.0.11", Comment: "myns/abc"},
+			},
+			address: BackendAddress{
+				ID:          "fixedaddress/3",
+				Address:     "192.168.0.11",
+				Owner:       "myns/abc",
+				Description: "web server",
+			},
+			expectedID: "fixedaddress/3",
+			expectedAddresses: map[string]infobloxFixedAddress{
+				"fixedaddress/3": {Ref: "fixedaddress/3", IPv4Address: "192.168.0.11", Comment: "web server"},
+			},
+		}),
+		Entry("Reservation deleted in Infoblox reserved again", testCaseInfoblox{
+			address: BackendAddress{
+				ID:          "fixedaddress/3",
+				Address:     "192.168.0.11",
+				Owner:       "myns/abc",
+				Description: "myns/abc",
+			},
+			expectedID: "fixedaddress/11",
+			expectedAddresses: map[string]infobloxFixedAddress{
+				"fixedaddress/11": {
+					Ref:         "fixedaddress/11",
+					IPv4Address: "192.168.0.11",
+					MAC:         infobloxReservedMAC,
+					NetworkView: "default",
+					Comment:     "myns/abc",
+				},
+			},
+		}),
+		Entry("Infoblox failing", testCaseInfoblox{
+			failing: true,
+			address: BackendAddress{
+				Address: "192.168.0.11",
+				Owner:   "myns/abc",
+			},
+			expectError:       true,
+			expectedAddresses: map[string]infobloxFixedAddress{},
+		}),
+		Entry("Released by reference", testCaseInfoblox{
+			release: true,
+			existing: []infobloxFixedAddress{
+				{Ref: "fixedaddress/3", IPv4Address: "192.168.0.11", Comment: "web server"},
+			},
+			address: BackendAddress{
+				ID:      "fixedaddress/3",
+				Address: "192.168.0.11",
+				Owner:   "myns/abc",
+			},
+			expectedAddresses: map[string]infobloxFixedAddress{},
+		}),
+		Entry("Released by owner", testCaseInfoblox{
+			release: true,
+			existing: []infobloxFixedAddress{
+				{Ref: "fixedaddress/3", IPv4Address: "192.168.0.11", Comment: "myns/abc"},
+				{Ref: "fixedaddress/4", IPv4Address: "192.168.0.12", Comment: "myns/abc"},
+			},
+			address: BackendAddress{
+				Address: "192.168.0.11",
+				Owner:   "myns/abc",
+			},
+			expectedAddresses: map[string]infobloxFixedAddress{
+				"fixedaddress/4": {Ref: "fixedaddress/4", IPv4Address: "192.168.0.12", Comment: "myns/abc"},
+			},
+		}),
+		Entry("Released while already deleted", testCaseInfoblox{
+			release: true,
+			address: BackendAddress{
+				ID:      "fixedaddress/3",
+				Address: "192.168.0.11",
+				Owner:   "myns/abc",
+			},
+			expectedAddresses: map[string]infobloxFixedAddress{},
+		}),
+	)
+})
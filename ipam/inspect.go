@@ -0,0 +1,285 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-logr/logr"
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// PoolUsage is the usage of an IPPool or a ClusterIPPool
+type PoolUsage struct {
+	// Pool is the namespace and name of the IPPool, or the name of the
+	// ClusterIPPool
+	Pool string
+	Kind string
+	// Total is the number of addresses, or prefixes, of the enabled pools
+	Total uint64
+	// Allocated is the number of allocated addresses, or prefixes
+	Allocated uint64
+	// Available is the number of addresses, or prefixes, left to allocate
+	Available uint64
+	// Error is the reason why the capacity is unknown, if any
+	Error string
+}
+
+// UsedPercent returns the percentage of the capacity that is allocated
+func (u PoolUsage) UsedPercent() float64 {
+	if u.Total == 0 {
+		return 0
+	}
+	return float64(u.Allocated) * 100 / float64(u.Total)
+}
+
+// AddressHolder is an IPAddress holding an address
+type AddressHolder struct {
+	// IPAddress is the namespace and name of the IPAddress
+	IPAddress string
+	// Address is the allocated address, or delegated prefix
+	Address string
+	// Pool is the name of the pool of the IPAddress, followed by its kind for
+	// a ClusterIPPool
+	Pool string
+	// Claim is the namespace and name of the IPClaim of the address
+	Claim string
+	Role  string
+}
+
+// ClaimSummary summarizes the binding of an IPClaim
+type ClaimSummary struct {
+	// Claim is the namespace and name of the IPClaim
+	Claim string
+	// Addresses are the addresses of the claim, the additional ones prefixed
+	// with their role
+	Addresses []string
+	// State is Bound, Pending, Held, Failed or Deleting
+	State   string
+	Message string
+	Age     metav1.Time
+}
+
+// PoolUsages returns the usage of the IPPools of the namespace, or of all
+// namespaces if empty, and of the ClusterIPPools
+func PoolUsages(ctx context.Context, cl client.Client, namespace string) ([]PoolUsage, error) {
+	pools := ipamv1.IPPoolList{}
+	if err := cl.List(ctx, &pools, &client.ListOptions{Namespace: namespace}); err != nil {
+		return nil, err
+	}
+	clusterPools := ipamv1.ClusterIPPoolList{}
+	if err := cl.List(ctx, &clusterPools); err != nil {
+		return nil, err
+	}
+	usages := []PoolUsage{}
+	for i := range pools.Items {
+		usages = append(usages, poolUsage(&pools.Items[i]))
+	}
+	for i := range clusterPools.Items {
+		usages = append(usages, poolUsage(clusterPools.Items[i].AsIPPool()))
+	}
+	return usages, nil
+}
+
+// poolUsage returns the usage of the pool from its spec and status
+func poolUsage(ipPool *ipamv1.IPPool) PoolUsage {
+	usage := PoolUsage{
+		Pool:      client.ObjectKeyFromObject(ipPool).String(),
+		Kind:      "IPPool",
+		Allocated: uint64(len(ipPool.Status.AllocatedAddresses())),
+	}
+	if ipPool.IsClusterScoped() {
+		usage.Pool = ipPool.Name
+		usage.Kind = ipamv1.ClusterIPPoolKind
+	}
+	// The sharded allocations are only counted in the status
+	if ipPool.Status.AllocationShards != 0 {
+		usage.Allocated = uint64(ipPool.Status.AllocatedCount)
+	}
+	total, err := ipPool.Spec.Capacity()
+	if err != nil {
+		usage.Error = err.Error()
+		return usage
+	}
+	usage.Total = total
+	if total > usage.Allocated {
+		usage.Available = total - usage.Allocated
+	}
+	return usage
+}
+
+// WhoHas returns the IPAddresses of all namespaces holding the address, or
+// whose delegated prefix contains it
+func WhoHas(ctx context.Context, cl client.Client, address string) ([]AddressHolder, error) {
+	addr, ok := parseAddr(address)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an IP address", address)
+	}
+	addresses := ipamv1.IPAddressList{}
+	if err := listPages(ctx, cl, &addresses, listPageSize); err != nil {
+		return nil, err
+	}
+	holders := []AddressHolder{}
+	for i := range addresses.Items {
+		addressObject := &addresses.Items[i]
+		held := addressObject.Spec.Address
+		heldAddr, ok := parseAddr(string(held))
+		matches := ok && heldAddr == addr
+		if !matches && addressObject.Spec.DelegatedPrefix != nil {
+			prefix, ok := parsePrefix(string(*addressObject.Spec.DelegatedPrefix))
+			if ok && prefix.contains(addr) {
+				held = ipamv1.IPAddressStr(*addressObject.Spec.DelegatedPrefix)
+				matches = true
+			}
+		}
+		if !matches {
+			continue
+		}
+		claimNamespace := addressObject.Spec.Claim.Namespace
+		if claimNamespace == "" {
+			claimNamespace = addressObject.Namespace
+		}
+		pool := addressObject.Spec.Pool.Name
+		if ipamv1.IsClusterIPPoolRef(addressObject.Spec.Pool) {
+			pool += " (" + ipamv1.ClusterIPPoolKind + ")"
+		}
+		holders = append(holders, AddressHolder{
+			IPAddress: client.ObjectKeyFromObject(addressObject).String(),
+			Address:   string(held),
+			Pool:      pool,
+			Claim:     claimNamespace + "/" + addressObject.Spec.Claim.Name,
+			Role:      addressObject.Labels[ipamv1.AddressRoleLabel],
+		})
+	}
+	sort.Slice(holders, func(i, j int) bool {
+		return holders[i].IPAddress < holders[j].IPAddress
+	})
+	return holders, nil
+}
+
+// ListPoolClaims returns the summaries of the IPClaims of the IPPool, or of
+// the ClusterIPPool if the namespace is empty, sorted by namespace and name
+func ListPoolClaims(ctx context.Context, cl client.Client, key client.ObjectKey,
+	log logr.Logger,
+) ([]ClaimSummary, error) {
+	ipPool := &ipamv1.IPPool{}
+	if key.Namespace == "" {
+		clusterPool := &ipamv1.ClusterIPPool{}
+		if err := cl.Get(ctx, key, clusterPool); err != nil {
+			return nil, err
+		}
+		ipPool = clusterPool.AsIPPool()
+	} else if err := cl.Get(ctx, key, ipPool); err != nil {
+		return nil, err
+	}
+	ipPoolMgr, err := NewIPPoolManager(cl, ipPool, log)
+	if err != nil {
+		return nil, err
+	}
+	ipPoolMgr.ListPageSize = listPageSize
+	claims, err := ipPoolMgr.listClaims(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := []ClaimSummary{}
+	for i := range claims {
+		claim := &claims[i]
+		if !ipPoolMgr.isClaimForPool(claim) {
+			continue
+		}
+		summary, err := summarizeClaim(ctx, cl, claim)
+		if err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].Claim < summaries[j].Claim
+	})
+	return summaries, nil
+}
+
+// summarizeClaim returns the summary of the claim, reading its IPAddresses
+func summarizeClaim(ctx context.Context, cl client.Client, claim *ipamv1.IPClaim) (ClaimSummary, error) {
+	summary := ClaimSummary{
+		Claim:     client.ObjectKeyFromObject(claim).String(),
+		Addresses: []string{},
+		State:     "Pending",
+		Age:       claim.CreationTimestamp,
+	}
+	if claim.Status.ErrorMessage != nil {
+		summary.Message = *claim.Status.ErrorMessage
+	}
+	references := map[string]string{}
+	if claim.Status.Address != nil {
+		references[""] = claim.Status.Address.Name
+	}
+	for role, reference := range claim.Status.Addresses {
+		references[role] = reference.Name
+	}
+	for role, name := range references {
+		addressObject := &ipamv1.IPAddress{}
+		if err := cl.Get(ctx, client.ObjectKey{Name: name, Namespace: claim.Namespace}, addressObject); err != nil {
+			if client.IgnoreNotFound(err) != nil {
+				return summary, err
+			}
+			continue
+		}
+		address := string(addressObject.Spec.Address)
+		if addressObject.Spec.DelegatedPrefix != nil {
+			address = string(*addressObject.Spec.DelegatedPrefix)
+		}
+		if role != "" {
+			address = role + "=" + address
+		}
+		summary.Addresses = append(summary.Addresses, address)
+	}
+	sort.Slice(summary.Addresses, func(i, j int) bool {
+		// The primary address, without role, comes first
+		iRole := strings.Contains(summary.Addresses[i], "=")
+		jRole := strings.Contains(summary.Addresses[j], "=")
+		if iRole != jRole {
+			return !iRole
+		}
+		return summary.Addresses[i] < summary.Addresses[j]
+	})
+
+	switch {
+	case !claim.DeletionTimestamp.IsZero():
+		summary.State = "Deleting"
+	case claim.Status.Address != nil:
+		summary.State = "Bound"
+	case isConditionTrue(claim.Status.Conditions, ipamv1.IPClaimBindingFailedCondition):
+		summary.State = "Failed"
+	case isConditionTrue(claim.Status.Conditions, ipamv1.IPClaimBindingHeldCondition):
+		summary.State = "Held"
+	}
+	return summary, nil
+}
+
+// isConditionTrue returns true if the condition is set to true
+func isConditionTrue(conditions []metav1.Condition, conditionType string) bool {
+	condition := meta.FindStatusCondition(conditions, conditionType)
+	return condition != nil && condition.Status == metav1.ConditionTrue
+}
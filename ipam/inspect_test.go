@@ -0,0 +1,248 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/klogr"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Inspect", func() {
+
+	newPool := func() ipamv1.IPPoolSpec {
+		return ipamv1.IPPoolSpec{
+			Pools: []ipamv1.Pool{
+				{
+					Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+					End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.13")),
+				},
+			},
+			Prefix: 24,
+		}
+	}
+
+	newAddress := func(name, pool, claim string, address ipamv1.IPAddressStr,
+		prefix *ipamv1.IPSubnetStr, labels map[string]string,
+	) *ipamv1.IPAddress {
+		return &ipamv1.IPAddress{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "myns",
+				Labels:    labels,
+			},
+			Spec: ipamv1.IPAddressSpec{
+				Pool:            corev1.ObjectReference{Name: pool},
+				Claim:           corev1.ObjectReference{Name: claim},
+				Address:         address,
+				DelegatedPrefix: prefix,
+			},
+		}
+	}
+
+	newClaim := func(name string, status ipamv1.IPClaimStatus) *ipamv1.IPClaim {
+		return &ipamv1.IPClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "myns",
+			},
+			Spec: ipamv1.IPClaimSpec{
+				Pool: corev1.ObjectReference{Name: "abc"},
+			},
+			Status: status,
+		}
+	}
+
+	It("Test PoolUsages", func() {
+		c := fakeclient.NewClientBuilder().WithScheme(setupScheme()).WithObjects(
+			&ipamv1.IPPool{
+				ObjectMeta: testObjectMeta,
+				Spec:       newPool(),
+				Status: ipamv1.IPPoolStatus{
+					Allocations: map[string]ipamv1.IPAddressStr{
+						"bcd": "192.168.0.10",
+					},
+				},
+			},
+			&ipamv1.IPPool{
+				ObjectMeta: metav1.ObjectMeta{Name: "abc", Namespace: "otherns"},
+				Spec:       newPool(),
+			},
+			&ipamv1.ClusterIPPool{
+				ObjectMeta: metav1.ObjectMeta{Name: "cluster"},
+				Spec: ipamv1.IPPoolSpec{
+					Pools: []ipamv1.Pool{
+						{Subnet: (*ipamv1.IPSubnetStr)(pointer.StringPtr("10.0.0.0/30"))},
+					},
+				},
+				Status: ipamv1.IPPoolStatus{
+					AllocatedRanges: []string{"10.0.0.1-10.0.0.2"},
+				},
+			},
+		).Build()
+
+		usages, err := PoolUsages(context.TODO(), c, "myns")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(usages).To(HaveLen(2))
+		Expect(usages[0]).To(Equal(PoolUsage{
+			Pool: "myns/abc", Kind: "IPPool", Total: 4, Allocated: 1, Available: 3,
+		}))
+		Expect(usages[0].UsedPercent()).To(Equal(25.0))
+		Expect(usages[1].Pool).To(Equal("cluster"))
+		Expect(usages[1].Kind).To(Equal(ipamv1.ClusterIPPoolKind))
+		Expect(usages[1].Allocated).To(Equal(uint64(2)))
+
+		usages, err = PoolUsages(context.TODO(), c, "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(usages).To(HaveLen(3))
+	})
+
+	type testCaseWhoHas struct {
+		address         string
+		expectError     bool
+		expectedHolders []AddressHolder
+	}
+
+	DescribeTable("Test WhoHas",
+		func(tc testCaseWhoHas) {
+			c := fakeclient.NewClientBuilder().WithScheme(setupScheme()).WithObjects(
+				newAddress("abc-192-168-0-10", "abc", "bcd", "192.168.0.10", nil, nil),
+				newAddress("abc-192-168-0-11", "abc", "cde", "192.168.0.11", nil,
+					map[string]string{ipamv1.AddressRoleLabel: "storage"},
+				),
+				newAddress("pd-2001-db8--", "pd", "def", "2001:db8::",
+					(*ipamv1.IPSubnetStr)(pointer.StringPtr("2001:db8::/64")), nil,
+				),
+			).Build()
+
+			holders, err := WhoHas(context.TODO(), c, tc.address)
+			if tc.expectError {
+				Expect(err).To(HaveOccurred())
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(holders).To(Equal(tc.expectedHolders))
+		},
+		Entry("Invalid address", testCaseWhoHas{
+			address:     "192.168.0",
+			expectError: true,
+		}),
+		Entry("Not allocated", testCaseWhoHas{
+			address:         "192.168.0.12",
+			expectedHolders: []AddressHolder{},
+		}),
+		Entry("Allocated address", testCaseWhoHas{
+			address: "192.168.0.10",
+			expectedHolders: []AddressHolder{
+				{
+					IPAddress: "myns/abc-192-168-0-10",
+					Address:   "192.168.0.10",
+					Pool:      "abc",
+					Claim:     "myns/bcd",
+				},
+			},
+		}),
+		Entry("Allocated address with a role", testCaseWhoHas{
+			address: "192.168.0.11",
+			expectedHolders: []AddressHolder{
+				{
+					IPAddress: "myns/abc-192-168-0-11",
+					Address:   "192.168.0.11",
+					Pool:      "abc",
+					Claim:     "myns/cde",
+					Role:      "storage",
+				},
+			},
+		}),
+		Entry("Address in a delegated prefix", testCaseWhoHas{
+			address: "2001:db8::5",
+			expectedHolders: []AddressHolder{
+				{
+					IPAddress: "myns/pd-2001-db8--",
+					Address:   "2001:db8::/64",
+					Pool:      "pd",
+					Claim:     "myns/def",
+				},
+			},
+		}),
+	)
+
+	It("Test ListPoolClaims", func() {
+		c := fakeclient.NewClientBuilder().WithScheme(setupScheme()).WithObjects(
+			&ipamv1.IPPool{
+				ObjectMeta: testObjectMeta,
+				Spec:       newPool(),
+			},
+			newAddress("abc-192-168-0-10", "abc", "bcd", "192.168.0.10", nil, nil),
+			newAddress("abc-192-168-0-11", "abc", "bcd", "192.168.0.11", nil,
+				map[string]string{ipamv1.AddressRoleLabel: "storage"},
+			),
+			newClaim("bcd", ipamv1.IPClaimStatus{
+				Address: &corev1.ObjectReference{Name: "abc-192-168-0-10"},
+				Addresses: map[string]corev1.ObjectReference{
+					"storage": {Name: "abc-192-168-0-11"},
+				},
+			}),
+			newClaim("cde", ipamv1.IPClaimStatus{
+				ErrorMessage: pointer.StringPtr("Failed to allocate"),
+				Conditions: []metav1.Condition{
+					{
+						Type:   ipamv1.IPClaimBindingFailedCondition,
+						Status: metav1.ConditionTrue,
+					},
+				},
+			}),
+			newClaim("def", ipamv1.IPClaimStatus{}),
+			&ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{Name: "efg", Namespace: "myns"},
+				Spec: ipamv1.IPClaimSpec{
+					Pool: corev1.ObjectReference{Name: "other"},
+				},
+			},
+		).Build()
+
+		summaries, err := ListPoolClaims(context.TODO(), c,
+			client.ObjectKey{Name: "abc", Namespace: "myns"}, klogr.New(),
+		)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(summaries).To(HaveLen(3))
+		Expect(summaries[0].Claim).To(Equal("myns/bcd"))
+		Expect(summaries[0].State).To(Equal("Bound"))
+		Expect(summaries[0].Addresses).To(Equal(
+			[]string{"192.168.0.10", "storage=192.168.0.11"},
+		))
+		Expect(summaries[1].State).To(Equal("Failed"))
+		Expect(summaries[1].Message).To(Equal("Failed to allocate"))
+		Expect(summaries[1].Addresses).To(BeEmpty())
+		Expect(summaries[2].State).To(Equal("Pending"))
+
+		_, err = ListPoolClaims(context.TODO(), c,
+			client.ObjectKey{Name: "abc"}, klogr.New(),
+		)
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,316 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"encoding/binary"
+	"math/bits"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ipAddr is an IP address held in two 64 bits words, an IPv4 address in the
+// low bits of the low word. Unlike net.IP, it is a comparable value that does
+// not allocate, and an IPv4 address is distinct from its IPv4-mapped IPv6
+// form, ::ffff:a.b.c.d, which is an IPv6 address. It is the form of the
+// addresses compared and indexed during the reconciles. The net/netip
+// package of Go 1.18 is not used, the controller being built with Go 1.16.
+type ipAddr struct {
+	high, low uint64
+	is4       bool
+}
+
+// parseAddr parses an IPv4 address in the dotted decimal form or an IPv6
+// address, without zone. It returns false if the address is invalid.
+func parseAddr(s string) (ipAddr, bool) {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '.':
+			return parseIPv4(s)
+		case ':':
+			return parseIPv6(s)
+		}
+	}
+	return ipAddr{}, false
+}
+
+// parseIPv4 parses an IPv4 address in the dotted decimal form. The fields
+// with leading zeros are rejected, as they are ambiguous.
+func parseIPv4(s string) (ipAddr, bool) {
+	var value uint64
+	fields, field, digits := 0, 0, 0
+	for i := 0; i <= len(s); i++ {
+		if i == len(s) || s[i] == '.' {
+			if digits == 0 || fields == 4 {
+				return ipAddr{}, false
+			}
+			value = value<<8 | uint64(field)
+			fields++
+			field, digits = 0, 0
+			continue
+		}
+		if s[i] < '0' || s[i] > '9' || (digits == 1 && field == 0) {
+			return ipAddr{}, false
+		}
+		field = field*10 + int(s[i]-'0')
+		digits++
+		if field > 255 {
+			return ipAddr{}, false
+		}
+	}
+	if fields != 4 {
+		return ipAddr{}, false
+	}
+	return ipAddr{low: value, is4: true}, true
+}
+
+// parseIPv6 parses an IPv6 address, with an optional "::" and an optional
+// trailing IPv4 address
+func parseIPv6(s string) (ipAddr, bool) {
+	var words [8]uint16
+	ellipsis, n, i := -1, 0, 0
+	if strings.HasPrefix(s, "::") {
+		ellipsis, i = 0, 2
+		if i == len(s) {
+			return ipAddr{}, true
+		}
+	}
+	for i < len(s) {
+		var word uint32
+		digits := 0
+		for ; i+digits < len(s); digits++ {
+			digit, ok := hexDigit(s[i+digits])
+			if !ok {
+				break
+			}
+			if digits == 4 {
+				return ipAddr{}, false
+			}
+			word = word<<4 | digit
+		}
+		if digits == 0 {
+			return ipAddr{}, false
+		}
+		// A trailing IPv4 address fills the last two words
+		if i+digits < len(s) && s[i+digits] == '.' {
+			if n > 6 || (ellipsis < 0 && n != 6) {
+				return ipAddr{}, false
+			}
+			ip4, ok := parseIPv4(s[i:])
+			if !ok {
+				return ipAddr{}, false
+			}
+			words[n] = uint16(ip4.low >> 16)
+			words[n+1] = uint16(ip4.low)
+			n += 2
+			break
+		}
+		words[n] = uint16(word)
+		n++
+		i += digits
+		if i == len(s) {
+			break
+		}
+		if s[i] != ':' || i+1 == len(s) {
+			return ipAddr{}, false
+		}
+		i++
+		if s[i] == ':' {
+			if ellipsis >= 0 {
+				return ipAddr{}, false
+			}
+			ellipsis = n
+			i++
+			if i == len(s) {
+				break
+			}
+		}
+		if n == 8 {
+			return ipAddr{}, false
+		}
+	}
+	if ellipsis < 0 && n != 8 || ellipsis >= 0 && n == 8 {
+		return ipAddr{}, false
+	}
+	if ellipsis >= 0 {
+		// Move the words after the "::" to the end
+		gap := 8 - n
+		copy(words[ellipsis+gap:], words[ellipsis:n])
+		for j := ellipsis; j < ellipsis+gap; j++ {
+			words[j] = 0
+		}
+	}
+	addr := ipAddr{}
+	for j := 0; j < 4; j++ {
+		addr.high = addr.high<<16 | uint64(words[j])
+		addr.low = addr.low<<16 | uint64(words[j+4])
+	}
+	return addr, true
+}
+
+// hexDigit returns the value of the hexadecimal digit
+func hexDigit(c byte) (uint32, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return uint32(c - '0'), true
+	case 'a' <= c && c <= 'f':
+		return uint32(c-'a') + 10, true
+	case 'A' <= c && c <= 'F':
+		return uint32(c-'A') + 10, true
+	}
+	return 0, false
+}
+
+// addrFromIP converts a net.IP. The 16 bytes form of an IPv4 address is an
+// IPv4 address, net.IP not telling it apart from the IPv4-mapped address.
+func addrFromIP(ip net.IP) (ipAddr, bool) {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ipAddr{low: uint64(binary.BigEndian.Uint32(ip4)), is4: true}, true
+	}
+	if len(ip) != net.IPv6len {
+		return ipAddr{}, false
+	}
+	return ipAddr{
+		high: binary.BigEndian.Uint64(ip[:8]),
+		low:  binary.BigEndian.Uint64(ip[8:]),
+	}, true
+}
+
+// ip converts the address to a net.IP, for the functions taking one
+func (a ipAddr) ip() net.IP {
+	if a.is4 {
+		ip := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, uint32(a.low))
+		return ip
+	}
+	ip := make(net.IP, net.IPv6len)
+	binary.BigEndian.PutUint64(ip[:8], a.high)
+	binary.BigEndian.PutUint64(ip[8:], a.low)
+	return ip
+}
+
+// String renders the address like net.IP, except for the IPv4-mapped IPv6
+// addresses, rendered in the ::ffff:a.b.c.d form
+func (a ipAddr) String() string {
+	if !a.is4 && a.high == 0 && a.low>>32 == 0xffff {
+		return "::ffff:" + ipAddr{low: a.low & 0xffffffff, is4: true}.String()
+	}
+	if a.is4 {
+		return strconv.Itoa(int(a.low>>24&0xff)) + "." + strconv.Itoa(int(a.low>>16&0xff)) + "." +
+			strconv.Itoa(int(a.low>>8&0xff)) + "." + strconv.Itoa(int(a.low&0xff))
+	}
+	return a.ip().String()
+}
+
+// compare returns -1, 0 or 1 whether the address is before, equal to or
+// after the other one. The IPv4 addresses are before the IPv6 addresses.
+func (a ipAddr) compare(b ipAddr) int {
+	switch {
+	case a.is4 != b.is4:
+		if a.is4 {
+			return -1
+		}
+		return 1
+	case a.high < b.high || (a.high == b.high && a.low < b.low):
+		return -1
+	case a == b:
+		return 0
+	}
+	return 1
+}
+
+// sub returns the number of addresses from the other address to this one,
+// and false if the other address is after it, of another family or too far
+// for an uint64
+func (a ipAddr) sub(b ipAddr) (uint64, bool) {
+	if a.is4 != b.is4 {
+		return 0, false
+	}
+	low, borrow := bits.Sub64(a.low, b.low, 0)
+	high, borrow := bits.Sub64(a.high, b.high, borrow)
+	return low, borrow == 0 && high == 0
+}
+
+// addrRange is a range of addresses, from first to last included
+type addrRange struct {
+	first, last ipAddr
+}
+
+// contains returns true if the address is in the range
+func (r addrRange) contains(a ipAddr) bool {
+	return a.is4 == r.first.is4 && r.first.compare(a) <= 0 && a.compare(r.last) <= 0
+}
+
+// ipPrefix is an IP prefix, its address masked
+type ipPrefix struct {
+	addr ipAddr
+	bits int
+}
+
+// parsePrefix parses a prefix in the CIDR form. The host bits of the
+// address are cleared.
+func parsePrefix(s string) (ipPrefix, bool) {
+	slash := strings.LastIndexByte(s, '/')
+	if slash < 0 {
+		return ipPrefix{}, false
+	}
+	addr, ok := parseAddr(s[:slash])
+	if !ok {
+		return ipPrefix{}, false
+	}
+	length, err := strconv.Atoi(s[slash+1:])
+	maxLength := 128
+	if addr.is4 {
+		maxLength = 32
+	}
+	if err != nil || length < 0 || length > maxLength {
+		return ipPrefix{}, false
+	}
+	prefix := ipPrefix{addr: addr, bits: length}
+	high, low := prefix.mask()
+	prefix.addr.high &= high
+	prefix.addr.low &= low
+	return prefix, true
+}
+
+// mask returns the mask of the prefix, in the words of the addresses
+func (p ipPrefix) mask() (uint64, uint64) {
+	length := p.bits
+	if p.addr.is4 {
+		// The IPv4 addresses are in the low 32 bits
+		length += 96
+	}
+	switch {
+	case length == 0:
+		return 0, 0
+	case length <= 64:
+		return ^uint64(0) << uint(64-length), 0
+	case length == 128:
+		return ^uint64(0), ^uint64(0)
+	}
+	return ^uint64(0), ^uint64(0) << uint(128-length)
+}
+
+// contains returns true if the address is in the prefix
+func (p ipPrefix) contains(a ipAddr) bool {
+	if a.is4 != p.addr.is4 {
+		return false
+	}
+	high, low := p.mask()
+	return a.high&high == p.addr.high && a.low&low == p.addr.low
+}
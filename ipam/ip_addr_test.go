@@ -0,0 +1,109 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"net"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("IP addresses", func() {
+
+	DescribeTable("Test parseAddr",
+		func(address string, expectValid bool, expectIPv4 bool) {
+			addr, ok := parseAddr(address)
+			Expect(ok).To(Equal(expectValid))
+			if !expectValid {
+				return
+			}
+			Expect(addr.is4).To(Equal(expectIPv4))
+			Expect(addr.ip().Equal(net.ParseIP(address))).To(BeTrue())
+			roundTrip, ok := addrFromIP(net.ParseIP(addr.String()))
+			Expect(ok).To(BeTrue())
+			if !expectIPv4 && addr.high == 0 && addr.low>>32 == 0xffff {
+				// net.IP does not tell the IPv4-mapped addresses apart
+				Expect(addr.String()).To(HavePrefix("::ffff:"))
+				return
+			}
+			Expect(roundTrip).To(Equal(addr))
+		},
+		Entry("IPv4", "192.168.0.1", true, true),
+		Entry("IPv4, zero", "0.0.0.0", true, true),
+		Entry("IPv4, leading zero", "192.168.0.01", false, false),
+		Entry("IPv4, field too large", "192.168.0.256", false, false),
+		Entry("IPv4, missing field", "192.168.0", false, false),
+		Entry("IPv4, extra field", "192.168.0.1.1", false, false),
+		Entry("IPv6", "2001:db8:0:1:2:3:4:5", true, false),
+		Entry("IPv6, compressed", "2001:db8::1", true, false),
+		Entry("IPv6, unspecified", "::", true, false),
+		Entry("IPv6, trailing ellipsis", "2001:db8::", true, false),
+		Entry("IPv6, IPv4-mapped", "::ffff:192.168.0.1", true, false),
+		Entry("IPv6, trailing IPv4", "2001:db8:0:0:0:0:192.168.0.1", true, false),
+		Entry("IPv6, two ellipses", "2001::1::1", false, false),
+		Entry("IPv6, too many words", "1:2:3:4:5:6:7:8:9", false, false),
+		Entry("IPv6, ellipsis for no word", "1:2:3:4::5:6:7:8", false, false),
+		Entry("IPv6, word too long", "2001:db8::12345", false, false),
+		Entry("IPv6, trailing colon", "2001:db8::1:", false, false),
+		Entry("IPv6, zone", "fe80::1%eth0", false, false),
+		Entry("Empty", "", false, false),
+	)
+
+	It("Compares and subtracts the addresses", func() {
+		parse := func(address string) ipAddr {
+			addr, ok := parseAddr(address)
+			Expect(ok).To(BeTrue())
+			return addr
+		}
+		Expect(parse("192.168.0.1").compare(parse("192.168.0.2"))).To(Equal(-1))
+		Expect(parse("192.168.0.1").compare(parse("::ffff:192.168.0.1"))).To(Equal(-1))
+		Expect(parse("2001:db8::1:0:0:0").compare(parse("2001:db8::ffff"))).To(Equal(1))
+		Expect(parse("2001:db8::1").compare(parse("2001:db8:0::1"))).To(Equal(0))
+
+		offset, ok := parse("2001:db8::1:0:0:0").sub(parse("2001:db8::1"))
+		Expect(ok).To(BeTrue())
+		Expect(offset).To(Equal(uint64(0xffffffffffff)))
+		_, ok = parse("2001:db8::1").sub(parse("2001:db8::2"))
+		Expect(ok).To(BeFalse())
+		_, ok = parse("2001:db9::1").sub(parse("2001:db8::1"))
+		Expect(ok).To(BeFalse())
+		_, ok = parse("::ffff:192.168.0.2").sub(parse("192.168.0.1"))
+		Expect(ok).To(BeFalse())
+	})
+
+	DescribeTable("Test parsePrefix and contains",
+		func(prefix string, address string, expected bool) {
+			ipPrefix, ok := parsePrefix(prefix)
+			Expect(ok).To(BeTrue())
+			addr, ok := parseAddr(address)
+			Expect(ok).To(BeTrue())
+			Expect(ipPrefix.contains(addr)).To(Equal(expected))
+		},
+		Entry("IPv4, inside", "192.168.0.0/24", "192.168.0.42", true),
+		Entry("IPv4, host bits set", "192.168.0.12/24", "192.168.0.42", true),
+		Entry("IPv4, outside", "192.168.0.0/24", "192.168.1.1", false),
+		Entry("IPv4, all addresses", "0.0.0.0/0", "10.0.0.1", true),
+		Entry("IPv4, IPv4-mapped address", "192.168.0.0/24", "::ffff:192.168.0.42", false),
+		Entry("IPv6, inside", "2001:db8::/64", "2001:db8::ffff:1", true),
+		Entry("IPv6, long prefix", "2001:db8::100/120", "2001:db8::1ff", true),
+		Entry("IPv6, outside", "2001:db8::/64", "2001:db8:0:1::1", false),
+		Entry("IPv6, single address", "2001:db8::1/128", "2001:db8::1", true),
+		Entry("IPv6, IPv4 address", "::/0", "192.168.0.1", false),
+	)
+})
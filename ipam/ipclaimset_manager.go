@@ -0,0 +1,135 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"context"
+	"sort"
+
+	"github.com/go-logr/logr"
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// IPClaimSetManagerInterface is an interface for a IPClaimSetManager
+type IPClaimSetManagerInterface interface {
+	UpdateClaims(context.Context) error
+}
+
+// IPClaimSetManager is responsible for maintaining the claims of an IPClaimSet
+type IPClaimSetManager struct {
+	client     client.Client
+	IPClaimSet *ipamv1.IPClaimSet
+	Log        logr.Logger
+}
+
+// NewIPClaimSetManager returns a new helper for managing an ipClaimSet object
+func NewIPClaimSetManager(client client.Client,
+	ipClaimSet *ipamv1.IPClaimSet, ipClaimSetLog logr.Logger) (*IPClaimSetManager, error) {
+
+	return &IPClaimSetManager{
+		client:     client,
+		IPClaimSet: ipClaimSet,
+		Log:        ipClaimSetLog,
+	}, nil
+}
+
+// UpdateClaims creates or deletes IPClaims to match the number of replicas of
+// the set, and updates the status of the set
+func (m *IPClaimSetManager) UpdateClaims(ctx context.Context) error {
+	claims, err := m.getClaims(ctx)
+	if err != nil {
+		return err
+	}
+
+	replicas := int(m.IPClaimSet.Spec.Replicas)
+	if len(claims) < replicas {
+		m.Log.Info("Creating claims", "number", replicas-len(claims))
+		for i := len(claims); i < replicas; i++ {
+			claim := m.IPClaimSet.NewIPClaim()
+			claim.OwnerReferences = []metav1.OwnerReference{
+				{
+					APIVersion:         ipamv1.GroupVersion.String(),
+					Kind:               "IPClaimSet",
+					Name:               m.IPClaimSet.Name,
+					UID:                m.IPClaimSet.UID,
+					Controller:         pointer.BoolPtr(true),
+					BlockOwnerDeletion: pointer.BoolPtr(true),
+				},
+			}
+			if err := m.client.Create(ctx, claim); err != nil {
+				return err
+			}
+			claims = append(claims, *claim)
+		}
+	} else if len(claims) > replicas {
+		m.Log.Info("Deleting claims", "number", len(claims)-replicas)
+		// Delete the claims without address first, then the newest ones
+		sort.SliceStable(claims, func(i, j int) bool {
+			iReady := claims[i].Status.Address != nil
+			jReady := claims[j].Status.Address != nil
+			if iReady != jReady {
+				return !iReady
+			}
+			return claims[j].CreationTimestamp.Before(&claims[i].CreationTimestamp)
+		})
+		for i := range claims[:len(claims)-replicas] {
+			if err := deleteObject(m.client, ctx, &claims[i]); err != nil {
+				return err
+			}
+		}
+		claims = claims[len(claims)-replicas:]
+	}
+
+	readyReplicas := 0
+	for _, claim := range claims {
+		if claim.Status.Address != nil {
+			readyReplicas++
+		}
+	}
+	m.IPClaimSet.Status.Replicas = int32(len(claims))
+	m.IPClaimSet.Status.ReadyReplicas = int32(readyReplicas)
+	now := metav1.Now()
+	m.IPClaimSet.Status.LastUpdated = &now
+	return nil
+}
+
+// getClaims returns the claims of the set that are not being deleted
+func (m *IPClaimSetManager) getClaims(ctx context.Context) ([]ipamv1.IPClaim, error) {
+	claimList := ipamv1.IPClaimList{}
+	opts := []client.ListOption{
+		client.InNamespace(m.IPClaimSet.Namespace),
+		client.MatchingLabels{ipamv1.IPClaimSetLabel: m.IPClaimSet.Name},
+	}
+	if err := m.client.List(ctx, &claimList, opts...); err != nil {
+		return nil, err
+	}
+
+	claims := []ipamv1.IPClaim{}
+	for _, claim := range claimList.Items {
+		if !metav1.IsControlledBy(&claim, m.IPClaimSet) {
+			continue
+		}
+		if !claim.DeletionTimestamp.IsZero() {
+			continue
+		}
+		claims = append(claims, claim)
+	}
+	return claims, nil
+}
@@ -0,0 +1,176 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2/klogr"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("IPClaimSet manager", func() {
+
+	claimSet := func(replicas int32) *ipamv1.IPClaimSet {
+		return &ipamv1.IPClaimSet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "abc",
+				Namespace: "myns",
+				UID:       types.UID("abc-uid"),
+			},
+			Spec: ipamv1.IPClaimSetSpec{
+				Replicas: replicas,
+				Template: ipamv1.IPClaimTemplate{
+					Labels: map[string]string{
+						"foo": "bar",
+					},
+					Spec: ipamv1.IPClaimSpec{
+						Pool: corev1.ObjectReference{
+							Name: "pool1",
+						},
+					},
+				},
+			},
+		}
+	}
+
+	setClaim := func(name string, ready bool, age time.Duration) *ipamv1.IPClaim {
+		claim := &ipamv1.IPClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				Namespace:         "myns",
+				CreationTimestamp: metav1.NewTime(timeNow.Add(-age)),
+				Labels: map[string]string{
+					ipamv1.IPClaimSetLabel: "abc",
+				},
+				OwnerReferences: []metav1.OwnerReference{
+					{
+						APIVersion: ipamv1.GroupVersion.String(),
+						Kind:       "IPClaimSet",
+						Name:       "abc",
+						UID:        types.UID("abc-uid"),
+						Controller: pointer.BoolPtr(true),
+					},
+				},
+			},
+			Spec: ipamv1.IPClaimSpec{
+				Pool: corev1.ObjectReference{
+					Name: "pool1",
+				},
+			},
+		}
+		if ready {
+			claim.Status.Address = &corev1.ObjectReference{
+				Name: name,
+			}
+		}
+		return claim
+	}
+
+	type testCaseUpdateClaims struct {
+		ipClaimSet            *ipamv1.IPClaimSet
+		ipClaims              []*ipamv1.IPClaim
+		expectedClaims        []string
+		expectedNbClaims      int
+		expectedReplicas      int32
+		expectedReadyReplicas int32
+	}
+
+	DescribeTable("Test UpdateClaims",
+		func(tc testCaseUpdateClaims) {
+			objects := []client.Object{}
+			for _, claim := range tc.ipClaims {
+				objects = append(objects, claim)
+			}
+			c := fakeclient.NewClientBuilder().WithScheme(setupScheme()).WithObjects(objects...).Build()
+			ipClaimSetMgr, err := NewIPClaimSetManager(c, tc.ipClaimSet,
+				klogr.New(),
+			)
+			Expect(err).NotTo(HaveOccurred())
+
+			err = ipClaimSetMgr.UpdateClaims(context.TODO())
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(tc.ipClaimSet.Status.LastUpdated.IsZero()).To(BeFalse())
+			Expect(tc.ipClaimSet.Status.Replicas).To(Equal(tc.expectedReplicas))
+			Expect(tc.ipClaimSet.Status.ReadyReplicas).To(Equal(tc.expectedReadyReplicas))
+
+			claimObjects := ipamv1.IPClaimList{}
+			err = c.List(context.TODO(), &claimObjects, &client.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(len(claimObjects.Items)).To(Equal(tc.expectedNbClaims))
+			for _, claim := range claimObjects.Items {
+				Expect(metav1.IsControlledBy(&claim, tc.ipClaimSet)).To(BeTrue())
+				Expect(claim.Labels[ipamv1.IPClaimSetLabel]).To(Equal("abc"))
+				Expect(claim.Spec.Pool.Name).To(Equal("pool1"))
+			}
+			for _, name := range tc.expectedClaims {
+				claim := &ipamv1.IPClaim{}
+				err = c.Get(context.TODO(), client.ObjectKey{Name: name, Namespace: "myns"}, claim)
+				Expect(err).NotTo(HaveOccurred())
+			}
+		},
+		Entry("No claims", testCaseUpdateClaims{
+			ipClaimSet: claimSet(0),
+		}),
+		Entry("Scale up", testCaseUpdateClaims{
+			ipClaimSet: claimSet(3),
+			ipClaims: []*ipamv1.IPClaim{
+				setClaim("abc-1", true, time.Hour),
+			},
+			expectedClaims:        []string{"abc-1"},
+			expectedNbClaims:      3,
+			expectedReplicas:      3,
+			expectedReadyReplicas: 1,
+		}),
+		Entry("Scale down", testCaseUpdateClaims{
+			ipClaimSet: claimSet(2),
+			ipClaims: []*ipamv1.IPClaim{
+				setClaim("abc-1", true, 3*time.Hour),
+				setClaim("abc-2", true, 2*time.Hour),
+				setClaim("abc-3", false, 4*time.Hour),
+				setClaim("abc-4", true, time.Hour),
+			},
+			expectedClaims:        []string{"abc-1", "abc-2"},
+			expectedNbClaims:      2,
+			expectedReplicas:      2,
+			expectedReadyReplicas: 2,
+		}),
+		Entry("Steady", testCaseUpdateClaims{
+			ipClaimSet: claimSet(2),
+			ipClaims: []*ipamv1.IPClaim{
+				setClaim("abc-1", true, 3*time.Hour),
+				setClaim("abc-2", false, 2*time.Hour),
+			},
+			expectedClaims:        []string{"abc-1", "abc-2"},
+			expectedNbClaims:      2,
+			expectedReplicas:      2,
+			expectedReadyReplicas: 1,
+		}),
+	)
+})
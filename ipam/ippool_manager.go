@@ -18,15 +18,27 @@ package ipam
 
 import (
 	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/big"
+	"math/rand"
+	"net"
 	"reflect"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/go-logr/logr"
 	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	"github.com/metal3-io/ip-address-manager/feature"
 	"github.com/pkg/errors"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/utils/pointer"
 	capi "sigs.k8s.io/cluster-api/api/v1alpha4"
 	"sigs.k8s.io/cluster-api/util/patch"
@@ -39,6 +51,10 @@ type IPPoolManagerInterface interface {
 	UnsetFinalizer()
 	SetClusterOwnerRef(*capi.Cluster) error
 	UpdateAddresses(context.Context) (int, error)
+	ReleaseAddress(context.Context, *ipamv1.IPClaim) error
+	UpdateClaim(context.Context, *ipamv1.IPClaim) error
+	DeleteClusterClaims(context.Context) error
+	DeletePoolClaims(context.Context) error
 }
 
 // IPPoolManager is responsible for performing machine reconciliation
@@ -46,6 +62,63 @@ type IPPoolManager struct {
 	client client.Client
 	IPPool *ipamv1.IPPool
 	Log    logr.Logger
+	// StaleClaimThreshold is the duration after which a claim without an
+	// address is reported as stale, zero disables the reporting
+	StaleClaimThreshold time.Duration
+	// Recorder records the events of the claims, no event is recorded if nil
+	Recorder record.EventRecorder
+	// BackendCredentials locates the credentials of the backend of the pool
+	BackendCredentials *BackendCredentials
+	// ConflictScope is the set of pools the pool is compared with to detect
+	// conflicts, the other pools of its namespace if empty
+	ConflictScope ConflictScope
+	// CompactionThreshold is the number of allocations above which the
+	// allocations are compacted in the status, even if the pool does not
+	// compact them. Zero disables it.
+	CompactionThreshold int
+	// ShardingThreshold is the number of allocations above which the
+	// allocations are recorded in IPPoolAllocationShard objects instead of
+	// the status. Zero disables it.
+	ShardingThreshold int
+	// IndexedClaims is true if the ClaimPoolIndex is registered in the cache
+	// of the client, to list the claims of the pool only
+	IndexedClaims bool
+	// AllocationBatchSize is the number of pending claims UpdateClaim
+	// allocates at once, the claim being reconciled included, so that their
+	// allocations are persisted with a single patch of the pool. Zero or one
+	// disables it.
+	AllocationBatchSize int
+	// ServerSideApply is true if the IPAddresses are updated with a
+	// server-side apply instead of an update
+	ServerSideApply bool
+	// ListPageSize is the number of IPAddresses and IPClaims per page of
+	// their lists, for the tools reading from the API server. Zero lists them
+	// at once, as from the cache of the controller.
+	ListPageSize int64
+	// reservations, if set, are the addresses allocated by the managers of
+	// the same factory, whose IPAddresses may not be in the cache yet
+	reservations *addressReservations
+	// usedIndexes are the bitmaps of the used indexes of the pools, built
+	// with their ranges from the addresses in use
+	usedIndexes []*addressBitmap
+	usedRanges  []*usedRange
+	// backend, if set, is the driver of the backend of the pool
+	backend Backend
+	// trace, if set, receives the steps of the allocations
+	trace func(step string)
+	// releasePending is set when a release waits for the release hook
+	releasePending bool
+	// leaseRequeue is the duration until the next lease expiry, zero if none
+	leaseRequeue time.Duration
+	// quarantineRequeue is the duration until the end of the next
+	// quarantine, zero if none
+	quarantineRequeue time.Duration
+	// retainedClaims are the deleted claims whose IPAddresses are retained,
+	// by allocation key
+	retainedClaims map[string]corev1.ObjectReference
+	// randomIndex, if set, replaces the random choice of the first index of
+	// the walk of a pool with the Random allocation strategy
+	randomIndex func(size int64) int64
 }
 
 // NewIPPoolManager returns a new helper for managing a ipPool object
@@ -59,6 +132,13 @@ func NewIPPoolManager(client client.Client,
 	}, nil
 }
 
+// explain records a step of an allocation if the allocations are traced
+func (m *IPPoolManager) explain(format string, args ...interface{}) {
+	if m.trace != nil {
+		m.trace(fmt.Sprintf(format, args...))
+	}
+}
+
 // SetFinalizer sets finalizer
 func (m *IPPoolManager) SetFinalizer() {
 	// If the Metal3Machine doesn't have finalizer, add it.
@@ -112,10 +192,14 @@ func (m *IPPoolManager) getIndexes(ctx context.Context) (map[ipamv1.IPAddressStr
 	updatedAllocations := make(map[string]ipamv1.IPAddressStr)
 
 	addresses := make(map[ipamv1.IPAddressStr]string)
+	m.retainedClaims = make(map[string]corev1.ObjectReference)
 
 	for _, address := range m.IPPool.Spec.PreAllocations {
 		addresses[address] = ""
 	}
+	for _, address := range m.IPPool.Spec.MACReservations {
+		addresses[address] = ""
+	}
 
 	// get list of IPAddress objects
 	addressObjects := ipamv1.IPAddressList{}
@@ -124,19 +208,17 @@ func (m *IPPoolManager) getIndexes(ctx context.Context) (map[ipamv1.IPAddressStr
 		Namespace: m.IPPool.Namespace,
 	}
 
-	err := m.client.List(ctx, &addressObjects, opts)
+	err := listPages(ctx, m.client, &addressObjects, m.ListPageSize, opts)
 	if err != nil {
 		return addresses, err
 	}
 
 	// Iterate over the IPAddress objects to find all addresses and objects
+	listed := make(map[ipamv1.IPAddressStr]string)
 	for _, addressObject := range addressObjects.Items {
 
 		// If IPPool does not point to this object, discard
-		if addressObject.Spec.Pool.Name == "" {
-			continue
-		}
-		if addressObject.Spec.Pool.Name != m.IPPool.Name {
+		if !m.isPoolAddress(&addressObject) {
 			continue
 		}
 
@@ -144,10 +226,56 @@ func (m *IPPoolManager) getIndexes(ctx context.Context) (map[ipamv1.IPAddressStr
 		// index being used, to avoid conflicts
 		claimName := ""
 		if addressObject.Spec.Claim.Name != "" {
-			claimName = addressObject.Spec.Claim.Name
+			claimName = addressKey(m.allocationKey(addressObject.Spec.Claim.Name,
+				addressObject.Spec.Claim.Namespace,
+			), addressObject.Labels[ipamv1.AddressRoleLabel])
 		}
 		updatedAllocations[claimName] = addressObject.Spec.Address
 		addresses[addressObject.Spec.Address] = claimName
+		listed[addressObject.Spec.Address] = claimName
+		if _, ok := addressObject.Labels[ipamv1.RetainedAddressLabel]; ok && claimName != "" {
+			claimNamespace := addressObject.Spec.Claim.Namespace
+			if claimNamespace == "" {
+				claimNamespace = addressObject.Namespace
+			}
+			m.retainedClaims[m.allocationKey(addressObject.Spec.Claim.Name,
+				addressObject.Spec.Claim.Namespace,
+			)] = corev1.ObjectReference{
+				Name:      addressObject.Spec.Claim.Name,
+				Namespace: claimNamespace,
+			}
+		}
+
+		// Adopt the IPAddress objects created before the cluster was set or
+		// before the pool was renamed
+		labelled := m.setClusterLabel(&addressObject.ObjectMeta)
+		adopted := addressObject.Spec.Pool.Name != m.IPPool.Name && m.adoptAddress(&addressObject)
+		if adopted {
+			// The owner reference of the former pool is removed
+			if err := updateObject(m.client, ctx, &addressObject); err != nil {
+				return addresses, err
+			}
+		} else if labelled {
+			if err := m.applyAddressMetadata(ctx, &addressObject, "cluster",
+				map[string]string{capi.ClusterLabelName: *m.IPPool.Spec.ClusterName}, nil,
+			); err != nil {
+				return addresses, err
+			}
+		}
+	}
+
+	// The addresses allocated by the previous or concurrent reconciles are
+	// allocated until their IPAddresses are in the cache
+	if m.reservations != nil {
+		for address, key := range m.reservations.pending(poolName(m.IPPool), listed) {
+			if _, ok := addresses[address]; ok {
+				continue
+			}
+			addresses[address] = key
+			if _, ok := updatedAllocations[key]; !ok {
+				updatedAllocations[key] = address
+			}
+		}
 	}
 
 	if !reflect.DeepEqual(updatedAllocations, m.IPPool.Status.Allocations) {
@@ -155,295 +283,2674 @@ func (m *IPPoolManager) getIndexes(ctx context.Context) (map[ipamv1.IPAddressStr
 		m.updateStatusTimestamp()
 	}
 
+	m.setPreAllocationConflicts(addresses)
+	m.setOutOfRangeAllocations()
+	m.retainAddresses(addresses)
+	m.quarantineAddresses(addresses)
+	m.forgetReleasedAddresses()
+	m.buildUsedIndexes(addresses)
+
 	return addresses, nil
 }
 
-func (m *IPPoolManager) updateStatusTimestamp() {
-	now := metav1.Now()
-	m.IPPool.Status.LastUpdated = &now
+// stickyRetention returns the duration during which the addresses of the
+// deleted claims are kept for them, zero if the allocations are not sticky
+func (m *IPPoolManager) stickyRetention() time.Duration {
+	if m.IPPool.Spec.StickyAllocationRetention == nil {
+		return 0
+	}
+	return m.IPPool.Spec.StickyAllocationRetention.Duration
 }
 
-// UpdateAddresses manages the claims and creates or deletes IPAddress accordingly.
-// It returns the number of current allocations
-func (m *IPPoolManager) UpdateAddresses(ctx context.Context) (int, error) {
-
-	addresses, err := m.getIndexes(ctx)
-	if err != nil {
-		return 0, err
+// retainAddresses reserves the addresses retained for the deleted claims, and
+// forgets the ones expired, allocated again or allocated to another claim. A
+// pool being deleted retains no address.
+func (m *IPPoolManager) retainAddresses(addresses map[ipamv1.IPAddressStr]string) {
+	retention := m.stickyRetention()
+	for key, retained := range m.IPPool.Status.RetainedAddresses {
+		_, allocated := m.IPPool.Status.Allocations[key]
+		owner, inUse := addresses[retained.Address]
+		if retention == 0 || !m.IPPool.DeletionTimestamp.IsZero() || allocated || (inUse && owner != key) ||
+			time.Since(retained.ReleasedAt.Time) >= retention {
+			delete(m.IPPool.Status.RetainedAddresses, key)
+			m.updateStatusTimestamp()
+			continue
+		}
+		addresses[retained.Address] = key
 	}
-
-	// get list of IPClaim objects
-	addressClaimObjects := ipamv1.IPClaimList{}
-	// without this ListOption, all namespaces would be including in the listing
-	opts := &client.ListOptions{
-		Namespace: m.IPPool.Namespace,
+	if len(m.IPPool.Status.RetainedAddresses) == 0 {
+		m.IPPool.Status.RetainedAddresses = nil
 	}
+}
 
-	err = m.client.List(ctx, &addressClaimObjects, opts)
-	if err != nil {
-		return 0, err
+// retainAddress keeps the address released by a deleted claim for it, if the
+// allocations are sticky
+func (m *IPPoolManager) retainAddress(addressClaim *ipamv1.IPClaim, key string,
+	address ipamv1.IPAddressStr, addresses map[ipamv1.IPAddressStr]string,
+) {
+	if m.stickyRetention() == 0 || addressClaim.DeletionTimestamp.IsZero() ||
+		!m.IPPool.DeletionTimestamp.IsZero() {
+		return
+	}
+	if m.IPPool.Status.RetainedAddresses == nil {
+		m.IPPool.Status.RetainedAddresses = make(map[string]ipamv1.RetainedAddress)
 	}
+	m.IPPool.Status.RetainedAddresses[key] = ipamv1.RetainedAddress{
+		Address:    address,
+		ReleasedAt: metav1.Now(),
+	}
+	addresses[address] = key
+}
 
-	// Iterate over the IPClaim objects to find all addresses and objects
-	for _, addressClaim := range addressClaimObjects.Items {
-		// If IPPool does not point to this object, discard
-		if addressClaim.Spec.Pool.Name != m.IPPool.Name {
-			continue
-		}
+// quarantinePeriod returns the duration during which the released addresses
+// can not be allocated again, zero if there is no quarantine
+func (m *IPPoolManager) quarantinePeriod() time.Duration {
+	if m.IPPool.Spec.QuarantinePeriod == nil {
+		return 0
+	}
+	return m.IPPool.Spec.QuarantinePeriod.Duration
+}
 
-		if addressClaim.Status.Address != nil && addressClaim.DeletionTimestamp.IsZero() {
+// quarantineAddresses reserves the released addresses in quarantine, and
+// forgets the ones whose quarantine is over. A pool being deleted quarantines
+// no address.
+func (m *IPPoolManager) quarantineAddresses(addresses map[ipamv1.IPAddressStr]string) {
+	period := m.quarantinePeriod()
+	for address, releasedAt := range m.IPPool.Status.QuarantinedAddresses {
+		remaining := period - time.Since(releasedAt.Time)
+		if remaining <= 0 || !m.IPPool.DeletionTimestamp.IsZero() {
+			delete(m.IPPool.Status.QuarantinedAddresses, address)
+			m.updateStatusTimestamp()
 			continue
 		}
-		addresses, err = m.updateAddress(ctx, &addressClaim, addresses)
-		if err != nil {
-			return 0, err
+		if _, ok := addresses[ipamv1.IPAddressStr(address)]; !ok {
+			addresses[ipamv1.IPAddressStr(address)] = ""
 		}
+		m.setQuarantineRequeue(remaining)
+	}
+	if len(m.IPPool.Status.QuarantinedAddresses) == 0 {
+		m.IPPool.Status.QuarantinedAddresses = nil
+	}
+}
+
+// quarantineAddress puts a released address in quarantine, if the pool has a
+// quarantine period
+func (m *IPPoolManager) quarantineAddress(address ipamv1.IPAddressStr,
+	addresses map[ipamv1.IPAddressStr]string,
+) {
+	period := m.quarantinePeriod()
+	if period == 0 || !m.IPPool.DeletionTimestamp.IsZero() {
+		return
+	}
+	if m.IPPool.Status.QuarantinedAddresses == nil {
+		m.IPPool.Status.QuarantinedAddresses = make(map[string]metav1.Time)
+	}
+	m.IPPool.Status.QuarantinedAddresses[string(address)] = metav1.Now()
+	if _, ok := addresses[address]; !ok {
+		addresses[address] = ""
+	}
+	m.setQuarantineRequeue(period)
+}
+
+// recordRelease records the release time of a released address, if the pool
+// allocates the least recently used addresses
+func (m *IPPoolManager) recordRelease(address ipamv1.IPAddressStr) {
+	if m.IPPool.Spec.AllocationStrategy != ipamv1.AllocationStrategyLeastRecentlyUsed {
+		return
 	}
+	if m.IPPool.Status.ReleasedAddresses == nil {
+		m.IPPool.Status.ReleasedAddresses = make(map[string]metav1.Time)
+	}
+	m.IPPool.Status.ReleasedAddresses[string(address)] = metav1.Now()
+}
+
+// forgetReleasedAddresses forgets the release times once the pool does not
+// allocate the least recently used addresses anymore
+func (m *IPPoolManager) forgetReleasedAddresses() {
+	if m.IPPool.Spec.AllocationStrategy == ipamv1.AllocationStrategyLeastRecentlyUsed ||
+		m.IPPool.Status.ReleasedAddresses == nil {
+		return
+	}
+	m.IPPool.Status.ReleasedAddresses = nil
 	m.updateStatusTimestamp()
-	return len(addresses), nil
 }
 
-func (m *IPPoolManager) updateAddress(ctx context.Context,
-	addressClaim *ipamv1.IPClaim, addresses map[ipamv1.IPAddressStr]string,
-) (map[ipamv1.IPAddressStr]string, error) {
-	helper, err := patch.NewHelper(addressClaim, m.client)
-	if err != nil {
-		return addresses, errors.Wrap(err, "failed to init patch helper")
+// firstIndex returns the index the walk of a pool starts from, random with
+// the Random allocation strategy, or derived from the allocation key of the
+// address with the Hash allocation strategy
+func (m *IPPoolManager) firstIndex(poolRange *ipamv1.PoolRange, key string) int {
+	strategy := m.IPPool.Spec.AllocationStrategy
+	if strategy != ipamv1.AllocationStrategyRandom && strategy != ipamv1.AllocationStrategyHash {
+		return 0
 	}
-	// Always patch addressClaim exiting this function so we can persist any changes.
-	defer func() {
-		err := helper.Patch(ctx, addressClaim)
-		if err != nil {
-			m.Log.Info("failed to Patch IPClaim")
-		}
-	}()
+	size := poolRange.Size()
+	if size > uint64(ipamv1.MaxPoolIndex) {
+		size = uint64(ipamv1.MaxPoolIndex)
+	}
+	if size <= 1 {
+		return 0
+	}
+	if strategy == ipamv1.AllocationStrategyHash {
+		hash := fnv.New64a()
+		_, _ = hash.Write([]byte(key))
+		return int(hash.Sum64() % size)
+	}
+	if m.randomIndex != nil {
+		return int(m.randomIndex(int64(size)))
+	}
+	return int(rand.Int63n(int64(size)))
+}
 
-	addressClaim.Status.ErrorMessage = nil
+// setQuarantineRequeue records the end of a quarantine, to reconcile the pool
+// again when its address is available
+func (m *IPPoolManager) setQuarantineRequeue(remaining time.Duration) {
+	if m.quarantineRequeue == 0 || remaining < m.quarantineRequeue {
+		m.quarantineRequeue = remaining
+	}
+}
 
-	if addressClaim.DeletionTimestamp.IsZero() {
-		addresses, err = m.createAddress(ctx, addressClaim, addresses)
-		if err != nil {
-			return addresses, err
-		}
-	} else {
-		addresses, err = m.deleteAddress(ctx, addressClaim, addresses)
-		if err != nil {
-			return addresses, err
-		}
+// forgetRetainedAddress forgets the address retained for the role of the
+// claim, that can not be allocated back, and allocates another address
+func (m *IPPoolManager) forgetRetainedAddress(addressClaim *ipamv1.IPClaim,
+	role string, addresses map[ipamv1.IPAddressStr]string, poolFilter int,
+	reason string,
+) (addressAllocation, int, error) {
+	key := addressKey(m.allocationKey(addressClaim.Name, addressClaim.Namespace), role)
+	retained := m.IPPool.Status.RetainedAddresses[key]
+	m.explain("retained %s not allocated: %s", retained.Address, reason)
+	m.Log.Info("Retained address not allocated", "Address", retained.Address, "Reason", reason)
+	if addresses[retained.Address] == key {
+		delete(addresses, retained.Address)
+		m.markAddress(retained.Address, false)
 	}
-	return addresses, nil
+	delete(m.IPPool.Status.RetainedAddresses, key)
+	m.updateStatusTimestamp()
+	return m.allocateRoleAddress(addressClaim, role, addresses, poolFilter)
 }
 
-func (m *IPPoolManager) allocateAddress(addressClaim *ipamv1.IPClaim,
-	addresses map[ipamv1.IPAddressStr]string,
-) (ipamv1.IPAddressStr, int, *ipamv1.IPAddressStr, []ipamv1.IPAddressStr, error) {
-	var allocatedAddress ipamv1.IPAddressStr = ""
-	var err error
+// skipPreferredAddress allocates another address than the preferred address
+// of the claim, that is not available, or fails if the preference is strict
+func (m *IPPoolManager) skipPreferredAddress(addressClaim *ipamv1.IPClaim,
+	role string, addresses map[ipamv1.IPAddressStr]string, poolFilter int,
+	reason string,
+) (addressAllocation, int, error) {
+	preferred := addressClaim.Spec.PreferredAddress
+	if preferred.Strict {
+		message := fmt.Sprintf("Preferred IP %s %s", preferred.Address, reason)
+		addressClaim.Status.ErrorMessage = pointer.StringPtr(message)
+		m.explain("no address allocated: %s", message)
+		return addressAllocation{}, anyPool, errors.New(message)
+	}
+	m.explain("preferred %s not allocated: %s", preferred.Address, reason)
+	m.Log.Info("Preferred address not allocated", "Address", preferred.Address, "Reason", reason)
+	// Allocate on a copy without preference, the allocator records its
+	// errors in the claim
+	claim := addressClaim.DeepCopy()
+	claim.Spec.PreferredAddress = nil
+	allocation, poolIndex, err := m.allocateRoleAddress(claim, role, addresses, poolFilter)
+	addressClaim.Status.ErrorMessage = claim.Status.ErrorMessage
+	return allocation, poolIndex, err
+}
 
-	// Get pre-allocated addresses
-	preAllocatedAddress, ipPreAllocated := m.IPPool.Spec.PreAllocations[addressClaim.Name]
-	// If the IP is pre-allocated, the default prefix and gateway are used
-	prefix := m.IPPool.Spec.Prefix
+// poolGateway returns the gateway of the addresses of the pool, its own or
+// the default gateway if the subnet of the pool contains it or if it is
+// marked off-subnet
+func (m *IPPoolManager) poolGateway(pool ipamv1.Pool, poolRange *ipamv1.PoolRange,
+) *ipamv1.IPAddressStr {
+	if pool.Gateway != nil {
+		return pool.Gateway
+	}
 	gateway := m.IPPool.Spec.Gateway
-	dnsServers := m.IPPool.Spec.DNSServers
+	if gateway != nil && !m.IPPool.Spec.OffSubnetGateway && !poolRange.InSubnet(net.ParseIP(string(*gateway))) {
+		return nil
+	}
+	return gateway
+}
 
-	ipAllocated := false
+// isPoolGateway returns true if the address is the gateway of the pool, which
+// is never allocated
+func (m *IPPoolManager) isPoolGateway(pool ipamv1.Pool, poolRange *ipamv1.PoolRange,
+	address ipamv1.IPAddressStr,
+) bool {
+	gateway := m.poolGateway(pool, poolRange)
+	if gateway == nil {
+		return false
+	}
+	gatewayAddr, ok := parseAddr(string(*gateway))
+	addr, addrOK := parseAddr(string(address))
+	return ok && addrOK && gatewayAddr == addr
+}
 
-	for _, pool := range m.IPPool.Spec.Pools {
-		if ipAllocated {
-			break
-		}
-		index := 0
-		for !ipAllocated {
-			allocatedAddress, err = ipamv1.GetIPAddress(pool, index)
-			if err != nil {
-				break
-			}
-			index++
-			// We have a pre-allocated ip, we just need to ensure that it matches the current address
-			// if it does not, continue and try the next address
-			if ipPreAllocated && allocatedAddress != preAllocatedAddress {
-				continue
-			}
-			// Here the two addresses match, so we continue with that one
-			if ipPreAllocated {
-				ipAllocated = true
-			}
-			// If we have a preallocated address, this is useless, otherwise, check if the
-			// ip is free
-			if _, ok := addresses[allocatedAddress]; !ok && allocatedAddress != "" {
-				ipAllocated = true
-			}
-			if !ipAllocated {
-				continue
-			}
+// eui64Role returns the role of the addresses derived from the MAC address
+// of the claims, the IPv6 one in a dual-stack pool
+func (m *IPPoolManager) eui64Role() string {
+	if m.IPPool.Spec.DualStack {
+		return ipamv1.DualStackIPv6Role
+	}
+	return ""
+}
 
-			if pool.Prefix != 0 {
-				prefix = pool.Prefix
-			}
-			if pool.Gateway != nil {
-				gateway = pool.Gateway
-			}
-			if len(pool.DNSServers) != 0 {
-				dnsServers = pool.DNSServers
-			}
-		}
+// eui64Address returns the address of the first enabled IPv6 /64 pool derived
+// from the MAC address of the claim, if the claim has one
+func (m *IPPoolManager) eui64Address(addressClaim *ipamv1.IPClaim,
+) (ipamv1.IPAddressStr, bool, error) {
+	mac, err := addressClaim.GetMACAddress()
+	if err != nil || mac == "" {
+		return "", false, err
 	}
-	// We have a preallocated IP but we did not find it in the pools! It means it is
-	// misconfigured
-	if !ipAllocated && ipPreAllocated {
-		addressClaim.Status.ErrorMessage = pointer.StringPtr("Pre-allocated IP out of bond")
-		return "", 0, nil, []ipamv1.IPAddressStr{}, errors.New("Pre-allocated IP out of bond")
+	hardwareAddr, err := net.ParseMAC(mac)
+	if err != nil {
+		return "", false, err
 	}
-	if !ipAllocated {
-		addressClaim.Status.ErrorMessage = pointer.StringPtr("Exhausted IP Pools")
-		return "", 0, nil, []ipamv1.IPAddressStr{}, errors.New("Exhausted IP Pools")
+	for _, pool := range m.IPPool.Spec.Pools {
+		if pool.Disabled {
+			continue
+		}
+		poolRange, err := ipamv1.NewPoolRange(pool)
+		if err != nil || !poolRange.IsIPv6() || poolRange.Prefix() != 64 {
+			continue
+		}
+		address, err := poolRange.EUI64Address(hardwareAddr)
+		if err != nil {
+			return "", false, err
+		}
+		return address, true, nil
 	}
-	return allocatedAddress, prefix, gateway, dnsServers, nil
+	return "", false, nil
 }
 
-func (m *IPPoolManager) createAddress(ctx context.Context,
-	addressClaim *ipamv1.IPClaim, addresses map[ipamv1.IPAddressStr]string,
-) (map[ipamv1.IPAddressStr]string, error) {
-	if !Contains(addressClaim.Finalizers, ipamv1.IPClaimFinalizer) {
-		addressClaim.Finalizers = append(addressClaim.Finalizers,
-			ipamv1.IPClaimFinalizer,
-		)
+// isMACReserved returns true if the address is reserved for a MAC address
+func (m *IPPoolManager) isMACReserved(address ipamv1.IPAddressStr) bool {
+	for _, reserved := range m.IPPool.Spec.MACReservations {
+		if reserved == address {
+			return true
+		}
 	}
+	return false
+}
 
-	if allocatedAddress, ok := m.IPPool.Status.Allocations[addressClaim.Name]; ok {
-		addressClaim.Status.Address = &corev1.ObjectReference{
-			Name:      m.formatAddressName(allocatedAddress),
-			Namespace: m.IPPool.Namespace,
+// setPreAllocationConflicts sets the pre-allocation conflict condition of the
+// pool, naming the preAllocations whose address is allocated to another claim
+func (m *IPPoolManager) setPreAllocationConflicts(addresses map[ipamv1.IPAddressStr]string) {
+	conflicts := []string{}
+	for key, address := range m.IPPool.Spec.PreAllocations {
+		owner := addresses[address]
+		if owner == "" || owner == key {
+			continue
 		}
-		return addresses, nil
+		conflicts = append(conflicts, fmt.Sprintf(
+			"%s: %s is allocated to %s", key, address, owner,
+		))
 	}
+	sort.Strings(conflicts)
 
-	// Get a new index for this machine
-	m.Log.Info("Getting address", "Claim", addressClaim.Name)
-	// Get a new IP for this owner
-	allocatedAddress, prefix, gateway, dnsServers, err := m.allocateAddress(addressClaim, addresses)
-	if err != nil {
-		return addresses, err
+	if len(conflicts) == 0 {
+		if meta.FindStatusCondition(m.IPPool.Status.Conditions, ipamv1.PreAllocationConflictCondition) == nil {
+			return
+		}
+		meta.SetStatusCondition(&m.IPPool.Status.Conditions, metav1.Condition{
+			Type:   ipamv1.PreAllocationConflictCondition,
+			Status: metav1.ConditionFalse,
+			Reason: "NoConflict",
+		})
+		return
 	}
+	m.Log.Info("Pre-allocation conflicts", "conflicts", conflicts)
+	meta.SetStatusCondition(&m.IPPool.Status.Conditions, metav1.Condition{
+		Type:    ipamv1.PreAllocationConflictCondition,
+		Status:  metav1.ConditionTrue,
+		Reason:  "AddressAllocated",
+		Message: strings.Join(conflicts, ", "),
+	})
+}
 
-	// Set the index and IPAddress names
-	addressName := m.formatAddressName(allocatedAddress)
-
-	m.Log.Info("Address allocated", "Claim", addressClaim.Name, "address", allocatedAddress)
+// setOutOfRangeAllocations sets the out of range allocations condition of the
+// pool, listing the allocated addresses that its pools do not contain
+// anymore. They stay allocated until their claims are deleted.
+func (m *IPPoolManager) setOutOfRangeAllocations() {
+	message := m.IPPool.OutOfRangeMessage()
+	if message == "" {
+		if meta.FindStatusCondition(m.IPPool.Status.Conditions, ipamv1.IPPoolOutOfRangeAllocationsCondition) == nil {
+			return
+		}
+		meta.SetStatusCondition(&m.IPPool.Status.Conditions, metav1.Condition{
+			Type:   ipamv1.IPPoolOutOfRangeAllocationsCondition,
+			Status: metav1.ConditionFalse,
+			Reason: "InRange",
+		})
+		return
+	}
+	m.Log.Info("Allocations out of the pools", "message", message)
+	meta.SetStatusCondition(&m.IPPool.Status.Conditions, metav1.Condition{
+		Type:    ipamv1.IPPoolOutOfRangeAllocationsCondition,
+		Status:  metav1.ConditionTrue,
+		Reason:  "AddressesOutOfRange",
+		Message: message,
+	})
+}
 
-	ownerRefs := addressClaim.OwnerReferences
-	ownerRefs = append(ownerRefs,
-		metav1.OwnerReference{
+// adoptAddress replaces the owner reference of an IPAddress of a former name
+// of the pool by an owner reference to the pool, so that the IPAddress is not
+// garbage collected with the former pool. It returns true if the owner
+// references were modified.
+func (m *IPPoolManager) adoptAddress(addressObject *ipamv1.IPAddress) bool {
+	ownerRefs := []metav1.OwnerReference{}
+	adopted := false
+	modified := false
+	for _, ownerRef := range addressObject.OwnerReferences {
+		if ownerRef.Kind == "IPPool" && ownerRef.Name == addressObject.Spec.Pool.Name {
+			modified = true
+			continue
+		}
+		if ownerRef.Kind == "IPPool" && ownerRef.Name == m.IPPool.Name {
+			adopted = true
+		}
+		ownerRefs = append(ownerRefs, ownerRef)
+	}
+	if !adopted {
+		ownerRefs = append(ownerRefs, metav1.OwnerReference{
 			APIVersion: m.IPPool.APIVersion,
 			Kind:       m.IPPool.Kind,
 			Name:       m.IPPool.Name,
 			UID:        m.IPPool.UID,
-		},
-		metav1.OwnerReference{
-			APIVersion: addressClaim.APIVersion,
-			Kind:       addressClaim.Kind,
-			Name:       addressClaim.Name,
-			UID:        addressClaim.UID,
-		},
-	)
-
-	// Create the IPAddress object, with an Owner ref to the Metal3Machine
-	// (curOwnerRef) and to the IPPool
-	addressObject := &ipamv1.IPAddress{
-		TypeMeta: metav1.TypeMeta{
-			Kind:       "IPAddress",
-			APIVersion: ipamv1.GroupVersion.String(),
-		},
-		ObjectMeta: metav1.ObjectMeta{
-			Name:            addressName,
-			Namespace:       m.IPPool.Namespace,
-			OwnerReferences: ownerRefs,
-			Labels:          addressClaim.Labels,
-		},
-		Spec: ipamv1.IPAddressSpec{
-			Address: allocatedAddress,
-			Pool: corev1.ObjectReference{
-				Name:      m.IPPool.Name,
-				Namespace: m.IPPool.Namespace,
-			},
-			Claim: corev1.ObjectReference{
-				Name:      addressClaim.Name,
-				Namespace: m.IPPool.Namespace,
-			},
-			Prefix:     prefix,
-			Gateway:    gateway,
-			DNSServers: dnsServers,
-		},
+		})
+		modified = true
 	}
-
-	// Create the IPAddress object. If we get a conflict (that will set
-	// HasRequeueAfterError), then requeue to retrigger the reconciliation with
-	// the new state
-	if err := createObject(m.client, ctx, addressObject); err != nil {
-		if _, ok := err.(*RequeueAfterError); !ok {
-			addressClaim.Status.ErrorMessage = pointer.StringPtr("Failed to create associated IPAddress object")
-		}
-		return addresses, err
+	if modified {
+		addressObject.OwnerReferences = ownerRefs
 	}
+	return modified
+}
 
-	m.IPPool.Status.Allocations[addressClaim.Name] = allocatedAddress
-	addresses[allocatedAddress] = addressClaim.Name
-
-	addressClaim.Status.Address = &corev1.ObjectReference{
-		Name:      addressName,
+// countOwnedAddresses returns the number of IPAddresses still owned by the
+// pool
+func (m *IPPoolManager) countOwnedAddresses(ctx context.Context) (int, error) {
+	addressObjects := ipamv1.IPAddressList{}
+	opts := &client.ListOptions{
 		Namespace: m.IPPool.Namespace,
 	}
+	if err := listPages(ctx, m.client, &addressObjects, m.ListPageSize, opts); err != nil {
+		return 0, err
+	}
+	owned := 0
+	for _, addressObject := range addressObjects.Items {
+		for _, ownerRef := range addressObject.OwnerReferences {
+			if ownerRef.Kind == "IPPool" && ownerRef.Name == m.IPPool.Name {
+				owned++
+				break
+			}
+		}
+	}
+	return owned, nil
+}
 
-	return addresses, nil
+func (m *IPPoolManager) updateStatusTimestamp() {
+	now := metav1.Now()
+	m.IPPool.Status.LastUpdated = &now
 }
 
-// DeleteDatas deletes old secrets
-func (m *IPPoolManager) deleteAddress(ctx context.Context,
-	addressClaim *ipamv1.IPClaim, addresses map[ipamv1.IPAddressStr]string,
-) (map[ipamv1.IPAddressStr]string, error) {
+// UpdateAddresses manages the claims and creates or deletes IPAddress accordingly.
+// It returns the number of current allocations
+func (m *IPPoolManager) UpdateAddresses(ctx context.Context) (int, error) {
 
-	m.Log.Info("Deleting Claim", "IPClaim", addressClaim.Name)
+	// A pool renamed to another one only waits for its IPAddresses to be
+	// adopted by the renamed pool, which serves its claims. The ClusterIPPools
+	// are not renamed.
+	var renamedPool *ipamv1.IPPool
+	if !m.IPPool.IsClusterScoped() {
+		var err error
+		renamedPool, err = ipamv1.FindRenamedIPPool(ctx, m.client,
+			client.ObjectKey{Name: m.IPPool.Name, Namespace: m.IPPool.Namespace},
+		)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if renamedPool != nil {
+		m.Log.Info("IPPool renamed, the claims are served by the renamed pool", "IPPool", renamedPool.Name)
+		return m.countOwnedAddresses(ctx)
+	}
 
-	allocatedAddress, ok := m.IPPool.Status.Allocations[addressClaim.Name]
-	if ok {
-		// Try to get the IPAddress. if it succeeds, delete it
-		tmpM3Data := &ipamv1.IPAddress{}
-		key := client.ObjectKey{
-			Name:      m.formatAddressName(allocatedAddress),
-			Namespace: m.IPPool.Namespace,
+	// The repair of the pool is requested with an annotation, removed once
+	// the pool is repaired
+	if _, ok := m.IPPool.Annotations[ipamv1.RepairAnnotation]; ok {
+		repaired, err := m.Repair(ctx)
+		if err != nil {
+			return 0, err
 		}
-		err := m.client.Get(ctx, key, tmpM3Data)
-		if err != nil && !apierrors.IsNotFound(err) {
-			addressClaim.Status.ErrorMessage = pointer.StringPtr("Failed to get associated IPAddress object")
-			return addresses, err
-		} else if err == nil {
-			// Delete the secret with metadata
-			err = deleteObject(m.client, ctx, tmpM3Data)
-			if err != nil {
-				addressClaim.Status.ErrorMessage = pointer.StringPtr("Failed to delete associated IPAddress object")
-				return addresses, err
-			}
+		delete(m.IPPool.Annotations, ipamv1.RepairAnnotation)
+		m.Log.Info("Repaired IPPool", "discrepancies", repaired)
+		if m.Recorder != nil {
+			m.Recorder.Eventf(m.IPPool, corev1.EventTypeNormal, "Repaired",
+				"Repaired %d discrepancies", repaired,
+			)
 		}
+	}
 
+	addresses, err := m.getIndexes(ctx)
+	if err != nil {
+		return 0, err
 	}
-	addressClaim.Status.Address = nil
-	addressClaim.Finalizers = Filter(addressClaim.Finalizers,
-		ipamv1.IPClaimFinalizer,
-	)
+	if err := m.setConflictCondition(ctx); err != nil {
+		return 0, err
+	}
+	defer m.compactAllocations()
+	defer m.shardAllocations(ctx)
+	defer m.updateCapacity()
 
-	m.Log.Info("Deleted Claim", "IPClaim", addressClaim.Name)
+	addressClaimObjects, err := m.listClaims(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	// Split the claims between the ones being deleted and the ones waiting for
+	// an allocation, so that releases are always processed first. This way an
+	// allocation error (such as an exhausted pool) cannot starve the deletions
+	// and the released addresses are available for the pending claims.
+	deletingClaims := []*ipamv1.IPClaim{}
+	pendingClaims := []*ipamv1.IPClaim{}
+	affinityGroups := map[string]bool{}
+	claimKeys := map[string]bool{}
+	for i := range addressClaimObjects {
+		addressClaim := &addressClaimObjects[i]
+		// If IPPool does not point to this object, discard
+		if !m.isClaimForPool(addressClaim) {
+			continue
+		}
+		claimKeys[m.allocationKey(addressClaim.Name, addressClaim.Namespace)] = true
+
+		if !addressClaim.DeletionTimestamp.IsZero() {
+			deletingClaims = append(deletingClaims, addressClaim)
+			continue
+		}
+		if addressClaim.Spec.AffinityGroup != "" {
+			affinityGroups[addressClaim.Spec.AffinityGroup] = true
+		}
+		// The addresses of the expired leases are released with the deleted
+		// claims
+		if expiry, ok := m.leaseExpiry(addressClaim); ok && addressClaim.Status.Address != nil {
+			if remaining := time.Until(expiry); remaining > 0 {
+				if m.leaseRequeue == 0 || remaining < m.leaseRequeue {
+					m.leaseRequeue = remaining
+				}
+			} else {
+				deletingClaims = append(deletingClaims, addressClaim)
+				continue
+			}
+		}
+		if addressClaim.Status.Address == nil || m.missingClusterLabel(addressClaim.Labels) ||
+			m.isDrainingClaim(addressClaim) {
+			pendingClaims = append(pendingClaims, addressClaim)
+		}
+	}
+
+	// Unbind the affinity groups without any remaining member
+	for group := range m.IPPool.Status.AffinityGroups {
+		if !affinityGroups[group] {
+			delete(m.IPPool.Status.AffinityGroups, group)
+		}
+	}
+
+	// The IPAddresses retained for the deleted claims are released once the
+	// pool no longer retains them. The ones of a recreated claim are adopted
+	// by the claim instead.
+	if !m.retainsAddresses() {
+		for key, claimRef := range m.retainedClaims {
+			if claimKeys[key] {
+				continue
+			}
+			addresses, err = m.deleteAddress(ctx, &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      claimRef.Name,
+					Namespace: claimRef.Namespace,
+				},
+			}, addresses)
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	for _, addressClaim := range append(deletingClaims, pendingClaims...) {
+		addresses, err = m.updateAddress(ctx, addressClaim, addresses)
+		if err != nil {
+			return 0, err
+		}
+	}
+	m.updateStatusTimestamp()
+	requeueAfter := m.leaseRequeue
+	if m.quarantineRequeue > 0 && (requeueAfter == 0 || m.quarantineRequeue < requeueAfter) {
+		requeueAfter = m.quarantineRequeue
+	}
+	if m.releasePending && (requeueAfter == 0 || releaseHookRetryInterval < requeueAfter) {
+		requeueAfter = releaseHookRetryInterval
+	}
+	if requeueAfter > 0 {
+		return len(addresses), &RequeueAfterError{RequeueAfter: requeueAfter}
+	}
+	return len(addresses), nil
+}
+
+// ReleaseAddress releases the address allocated to a claim being deleted,
+// without recomputing the state of the whole pool. It deletes the IPAddress,
+// removes the allocation from the pool status and the finalizer from the claim.
+func (m *IPPoolManager) ReleaseAddress(ctx context.Context, addressClaim *ipamv1.IPClaim) error {
+	if addressClaim.DeletionTimestamp.IsZero() {
+		return nil
+	}
+	// Compacted or sharded allocations do not give the addresses of the
+	// claim, they are rebuilt from the IPAddress objects
+	if m.IPPool.Spec.CompactAllocations || len(m.IPPool.Status.AllocatedRanges) != 0 ||
+		m.IPPool.Status.AllocationShards != 0 {
+		if _, err := m.getIndexes(ctx); err != nil {
+			return err
+		}
+	}
+	defer m.compactAllocations()
+	defer m.shardAllocations(ctx)
+	defer m.updateCapacity()
+	_, err := m.updateAddress(ctx, addressClaim, map[ipamv1.IPAddressStr]string{})
+	if err == nil && m.releasePending {
+		return &RequeueAfterError{RequeueAfter: releaseHookRetryInterval}
+	}
+	return err
+}
+
+// UpdateClaim allocates the addresses of a single claim, or releases them if
+// the claim is being deleted or its lease expired, without going through all
+// the claims of the pool. The bound claims are left untouched, a claim with a
+// lease is requeued until its expiry. The claims of a draining pool are handed
+// over to the target pool once unbound or migrated.
+func (m *IPPoolManager) UpdateClaim(ctx context.Context, addressClaim *ipamv1.IPClaim) error {
+	if !addressClaim.DeletionTimestamp.IsZero() {
+		return m.ReleaseAddress(ctx, addressClaim)
+	}
+	if !m.isClaimForPool(addressClaim) {
+		return nil
+	}
+	if addressClaim.Status.Address != nil && !m.missingClusterLabel(addressClaim.Labels) &&
+		!m.isDrainingClaim(addressClaim) {
+		if err := m.syncBackendMetadata(ctx, addressClaim); err != nil {
+			return err
+		}
+		expiry, ok := m.leaseExpiry(addressClaim)
+		if !ok {
+			return nil
+		}
+		if remaining := time.Until(expiry); remaining > 0 {
+			return &RequeueAfterError{RequeueAfter: remaining}
+		}
+	}
+
+	addresses, err := m.getIndexes(ctx)
+	if err != nil {
+		return err
+	}
+	defer m.compactAllocations()
+	defer m.shardAllocations(ctx)
+	defer m.updateCapacity()
+
+	// The claims being deleted are released before allocating the claim
+	// from an exhausted pool, instead of waiting for their turn in the queue
+	// behind the allocation retries of the pending claims
+	if addressClaim.Status.Address == nil &&
+		meta.IsStatusConditionTrue(m.IPPool.Status.Conditions, ipamv1.IPPoolExhaustedCondition) {
+		addresses, err = m.releaseDeletingClaims(ctx, addresses)
+		if err != nil {
+			return err
+		}
+	}
+
+	addresses, err = m.updateAddress(ctx, addressClaim, addresses)
+	if err != nil {
+		return err
+	}
+	if addressClaim.Status.Address != nil {
+		m.allocateBatch(ctx, addressClaim, addresses)
+	}
+	m.updateStatusTimestamp()
+	if m.releasePending {
+		return &RequeueAfterError{RequeueAfter: releaseHookRetryInterval}
+	}
+	return nil
+}
+
+// releaseDeletingClaims releases the addresses of the claims of the pool that
+// are being deleted and still hold the finalizer
+func (m *IPPoolManager) releaseDeletingClaims(ctx context.Context,
+	addresses map[ipamv1.IPAddressStr]string,
+) (map[ipamv1.IPAddressStr]string, error) {
+	claims, err := m.listClaims(ctx)
+	if err != nil {
+		return addresses, err
+	}
+	for i := range claims {
+		claim := &claims[i]
+		if claim.DeletionTimestamp.IsZero() || !m.isClaimForPool(claim) ||
+			!Contains(claim.Finalizers, ipamv1.IPClaimFinalizer) {
+			continue
+		}
+		m.Log.Info("Releasing the addresses of a deleted claim first", "IPClaim", claim.Name)
+		addresses, err = m.updateAddress(ctx, claim, addresses)
+		if err != nil {
+			return addresses, err
+		}
+	}
+	return addresses, nil
+}
+
+// allocateBatch allocates the addresses of the other pending claims of the
+// pool along with the claim being reconciled, up to the allocation batch
+// size, so that the pool status is patched once for all of them. The batch
+// stops at the first claim that cannot be allocated, that claim being left to
+// its own reconcile. The claims in error or held are not batched.
+func (m *IPPoolManager) allocateBatch(ctx context.Context, addressClaim *ipamv1.IPClaim,
+	addresses map[ipamv1.IPAddressStr]string,
+) {
+	if m.AllocationBatchSize <= 1 {
+		return
+	}
+	claims, err := m.listClaims(ctx)
+	if err != nil {
+		m.Log.Info("Unable to list the claims to allocate in batch", "Error", err.Error())
+		return
+	}
+	batched := 1
+	for i := range claims {
+		if batched == m.AllocationBatchSize {
+			return
+		}
+		claim := &claims[i]
+		if claim.Name == addressClaim.Name && claim.Namespace == addressClaim.Namespace {
+			continue
+		}
+		if !m.isClaimForPool(claim) || !claim.DeletionTimestamp.IsZero() ||
+			claim.Status.Address != nil || claim.Status.ErrorMessage != nil ||
+			len(claim.GetBindingHolds()) != 0 {
+			continue
+		}
+		addresses, err = m.updateAddress(ctx, claim, addresses)
+		if err != nil || claim.Status.Address == nil {
+			m.Log.Info("Stopping the batch allocation", "Claim", claim.Name)
+			return
+		}
+		batched++
+	}
+}
+
+// compactAllocations stores the allocations of the pool in the status as
+// ranges of addresses if the pool compacts its allocations, or if they are
+// above the compaction threshold, to keep the large pools under the size
+// limit of the objects. Otherwise the ranges are dropped, the allocations
+// having been rebuilt from the IPAddress objects.
+func (m *IPPoolManager) compactAllocations() {
+	if !m.IPPool.Spec.CompactAllocations &&
+		(m.CompactionThreshold == 0 || len(m.IPPool.Status.Allocations) <= m.CompactionThreshold) {
+		m.IPPool.Status.AllocatedRanges = nil
+		return
+	}
+	addresses := make([]ipamv1.IPAddressStr, 0, len(m.IPPool.Status.Allocations))
+	for _, address := range m.IPPool.Status.Allocations {
+		addresses = append(addresses, address)
+	}
+	m.IPPool.Status.AllocatedRanges = ipamv1.CompactAddresses(addresses)
+	m.IPPool.Status.Allocations = nil
+}
+
+// updateCapacity sets the number of total, allocated and available addresses,
+// or prefixes, in the status of the pool, along with the InvalidSpec and
+// Exhausted conditions. The drained and deletion blocked conditions are set
+// there too, the allocations being final.
+func (m *IPPoolManager) updateCapacity() {
+	m.setDrainedCondition()
+	m.setDeletionBlockedCondition()
+	total, err := m.IPPool.Spec.ExactCapacity()
+	if err != nil {
+		m.Log.Info("Unable to compute the capacity of the IPPool", "Error", err.Error())
+		meta.SetStatusCondition(&m.IPPool.Status.Conditions, metav1.Condition{
+			Type:    ipamv1.IPPoolInvalidSpecCondition,
+			Status:  metav1.ConditionTrue,
+			Reason:  "InvalidPool",
+			Message: err.Error(),
+		})
+		meta.RemoveStatusCondition(&m.IPPool.Status.Conditions, ipamv1.IPPoolExhaustedCondition)
+		return
+	}
+	meta.SetStatusCondition(&m.IPPool.Status.Conditions, metav1.Condition{
+		Type:   ipamv1.IPPoolInvalidSpecCondition,
+		Status: metav1.ConditionFalse,
+		Reason: "ValidSpec",
+	})
+	allocated := uint64(len(m.IPPool.Status.AllocatedAddresses()))
+	// The retained addresses and the free addresses in quarantine are not
+	// available to the other claims
+	reserved := allocated + uint64(len(m.IPPool.Status.RetainedAddresses))
+	if len(m.IPPool.Status.QuarantinedAddresses) != 0 {
+		allocatedSet := make(map[ipamv1.IPAddressStr]bool)
+		for _, address := range m.IPPool.Status.AllocatedAddresses() {
+			allocatedSet[address] = true
+		}
+		for _, retained := range m.IPPool.Status.RetainedAddresses {
+			allocatedSet[retained.Address] = true
+		}
+		for address := range m.IPPool.Status.QuarantinedAddresses {
+			if !allocatedSet[ipamv1.IPAddressStr(address)] {
+				reserved++
+			}
+		}
+	}
+	available := new(big.Int).Sub(total, new(big.Int).SetUint64(reserved))
+	if available.Sign() < 0 {
+		available.SetInt64(0)
+	}
+	m.IPPool.Status.TotalCount, m.IPPool.Status.ExactTotalCount = capacityCount(total)
+	m.IPPool.Status.AllocatedCount, _ = capacityCount(new(big.Int).SetUint64(allocated))
+	m.IPPool.Status.AvailableCount, m.IPPool.Status.ExactAvailableCount = capacityCount(available)
+
+	if available.Sign() == 0 {
+		meta.SetStatusCondition(&m.IPPool.Status.Conditions, metav1.Condition{
+			Type:    ipamv1.IPPoolExhaustedCondition,
+			Status:  metav1.ConditionTrue,
+			Reason:  "NoAddressAvailable",
+			Message: fmt.Sprintf("%d of %d allocated", allocated, total),
+		})
+		return
+	}
+	meta.SetStatusCondition(&m.IPPool.Status.Conditions, metav1.Condition{
+		Type:   ipamv1.IPPoolExhaustedCondition,
+		Status: metav1.ConditionFalse,
+		Reason: "AddressesAvailable",
+	})
+}
+
+// capacityCount converts a number of addresses to the int64 of the status,
+// saturating at math.MaxInt64 for the larger IPv6 pools. The exact number is
+// then also returned in decimal, and is empty otherwise.
+func capacityCount(count *big.Int) (int64, string) {
+	if !count.IsInt64() {
+		return math.MaxInt64, count.String()
+	}
+	return count.Int64(), ""
+}
+
+// DeleteClusterClaims deletes the IPClaims of the pool labelled with the
+// cluster of the pool. It is called when the cluster is being deleted, so that
+// the addresses are released without waiting for the owners of the claims to
+// be deleted. The addresses are released by UpdateAddresses.
+func (m *IPPoolManager) DeleteClusterClaims(ctx context.Context) error {
+	if m.IPPool.Spec.ClusterName == nil {
+		return nil
+	}
+	return m.deleteClaims(ctx, "Deleting claim of the deleted cluster",
+		func(addressClaim *ipamv1.IPClaim) bool {
+			return addressClaim.Labels[capi.ClusterLabelName] == *m.IPPool.Spec.ClusterName
+		},
+	)
+}
+
+// DeletePoolClaims deletes all the IPClaims of the pool. It is called when a
+// pool with the Cascade deletion policy is being deleted. The addresses are
+// released by UpdateAddresses.
+func (m *IPPoolManager) DeletePoolClaims(ctx context.Context) error {
+	return m.deleteClaims(ctx, "Deleting claim of the deleted pool",
+		func(*ipamv1.IPClaim) bool { return true },
+	)
+}
+
+// deleteClaims deletes the IPClaims of the pool, not being deleted yet, that
+// match the filter
+func (m *IPPoolManager) deleteClaims(ctx context.Context, message string,
+	filter func(*ipamv1.IPClaim) bool,
+) error {
+	addressClaimObjects, err := m.listClaims(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i := range addressClaimObjects {
+		addressClaim := &addressClaimObjects[i]
+		if !m.isClaimForPool(addressClaim) {
+			continue
+		}
+		if !addressClaim.DeletionTimestamp.IsZero() {
+			continue
+		}
+		if !filter(addressClaim) {
+			continue
+		}
+		m.Log.Info(message, "Claim", addressClaim.Name)
+		if err := deleteObject(m.client, ctx, addressClaim); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listClaims lists the IPClaims in the namespace of the pool and in the
+// namespaces allowed to reference the pool, or only the IPClaims referencing
+// the pool if they are indexed
+func (m *IPPoolManager) listClaims(ctx context.Context) ([]ipamv1.IPClaim, error) {
+	if m.IndexedClaims {
+		return m.listIndexedClaims(ctx)
+	}
+	namespaces := []string{m.IPPool.Namespace}
+	for _, namespace := range m.IPPool.Spec.AllowedNamespaces {
+		if namespace == ipamv1.AllNamespaces {
+			// An empty namespace lists the claims of all namespaces
+			namespaces = []string{""}
+			break
+		}
+		if !Contains(namespaces, namespace) {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+
+	claims := []ipamv1.IPClaim{}
+	for _, namespace := range namespaces {
+		// get list of IPClaim objects
+		addressClaimObjects := ipamv1.IPClaimList{}
+		// without this ListOption, all namespaces would be including in the listing
+		opts := &client.ListOptions{
+			Namespace: namespace,
+		}
+
+		err := listPages(ctx, m.client, &addressClaimObjects, m.ListPageSize, opts)
+		if err != nil {
+			return nil, err
+		}
+		claims = append(claims, addressClaimObjects.Items...)
+	}
+	return claims, nil
+}
+
+// isClaimForPool returns true if the claim references this pool and is
+// allowed to do so. A claim handed over by a draining pool is only served by
+// the target pool named in its served-by annotation.
+func (m *IPPoolManager) isClaimForPool(addressClaim *ipamv1.IPClaim) bool {
+	if ipamv1.IsClusterIPPoolRef(addressClaim.Spec.Pool) != m.IPPool.IsClusterScoped() {
+		return false
+	}
+	if servedBy, ok := addressClaim.Annotations[ipamv1.ServedByAnnotation]; ok && !m.IPPool.IsClusterScoped() {
+		if servedBy != m.IPPool.Name {
+			return false
+		}
+	} else if !m.IPPool.IsNamed(addressClaim.Spec.Pool.Name) {
+		return false
+	}
+	if m.IPPool.IsClusterScoped() {
+		return m.IPPool.IsNamespaceAllowed(addressClaim.Namespace)
+	}
+	poolNamespace := addressClaim.Spec.Pool.Namespace
+	if poolNamespace == "" {
+		poolNamespace = addressClaim.Namespace
+	}
+	if poolNamespace != m.IPPool.Namespace {
+		return false
+	}
+	return m.IPPool.IsNamespaceAllowed(addressClaim.Namespace)
+}
+
+// isPoolAddress returns true if the IPAddress was allocated from this pool.
+// The IPAddresses of a ClusterIPPool reference it by kind, so that they are
+// not mistaken for the ones of an IPPool of the same name.
+func (m *IPPoolManager) isPoolAddress(addressObject *ipamv1.IPAddress) bool {
+	if addressObject.Spec.Pool.Name == "" || !m.IPPool.IsNamed(addressObject.Spec.Pool.Name) {
+		return false
+	}
+	return ipamv1.IsClusterIPPoolRef(addressObject.Spec.Pool) == m.IPPool.IsClusterScoped()
+}
+
+// addressNamespace returns the namespace of the IPAddresses of a claim. A
+// ClusterIPPool has no namespace, its IPAddresses are created in the namespace
+// of their claim.
+func (m *IPPoolManager) addressNamespace(claimNamespace string) string {
+	if m.IPPool.IsClusterScoped() {
+		return claimNamespace
+	}
+	return m.IPPool.Namespace
+}
+
+// addressRoles returns the roles of the addresses of the claim in the pool,
+// with the IPv6 address of a dual-stack pool, unless the claim requests it
+// already
+func (m *IPPoolManager) addressRoles(addressClaim *ipamv1.IPClaim) []string {
+	roles := addressClaim.GetAddressRoles()
+	if m.IPPool.Spec.DualStack && !Contains(roles, ipamv1.DualStackIPv6Role) {
+		roles = append(roles, ipamv1.DualStackIPv6Role)
+	}
+	return roles
+}
+
+// poolRef returns the reference to the pool set in its IPAddresses
+func (m *IPPoolManager) poolRef() corev1.ObjectReference {
+	if m.IPPool.IsClusterScoped() {
+		return corev1.ObjectReference{
+			APIVersion: ipamv1.GroupVersion.String(),
+			Kind:       ipamv1.ClusterIPPoolKind,
+			Name:       m.IPPool.Name,
+		}
+	}
+	return corev1.ObjectReference{
+		Name:      m.IPPool.Name,
+		Namespace: m.IPPool.Namespace,
+	}
+}
+
+// allocationKey returns the key of the claim in the allocations. Claims from
+// the namespace of the pool are identified by their name, claims from other
+// namespaces by their namespace and name.
+func (m *IPPoolManager) allocationKey(name, namespace string) string {
+	if namespace == "" || namespace == m.IPPool.Namespace {
+		return name
+	}
+	return namespace + "/" + name
+}
+
+func (m *IPPoolManager) updateAddress(ctx context.Context,
+	addressClaim *ipamv1.IPClaim, addresses map[ipamv1.IPAddressStr]string,
+) (map[ipamv1.IPAddressStr]string, error) {
+	helper, err := patch.NewHelper(addressClaim, m.client)
+	if err != nil {
+		return addresses, errors.Wrap(err, "failed to init patch helper")
+	}
+	// Always patch addressClaim exiting this function so we can persist any changes.
+	defer func() {
+		err := helper.Patch(ctx, addressClaim)
+		if err != nil {
+			m.Log.Info("failed to Patch IPClaim")
+		}
+	}()
+
+	addressClaim.Status.ErrorMessage = nil
+
+	if addressClaim.DeletionTimestamp.IsZero() {
+		m.setClusterLabel(&addressClaim.ObjectMeta)
+		// No address is allocated to a failed claim anymore
+		if failed := meta.FindStatusCondition(addressClaim.Status.Conditions,
+			ipamv1.IPClaimBindingFailedCondition,
+		); failed != nil && failed.Status == metav1.ConditionTrue {
+			addressClaim.Status.ErrorMessage = pointer.StringPtr(failed.Message)
+			return addresses, nil
+		}
+		// The claims of a draining pool are served by its target pool
+		if m.isDrainingClaim(addressClaim) {
+			return m.handOverClaim(ctx, addressClaim, addresses,
+				m.IPPool.Spec.Drain.TargetPool, "is drained",
+			)
+		}
+		// The addresses of an expired lease are released until it is renewed
+		if m.setLeaseExpiredCondition(addressClaim) {
+			addresses, err = m.deleteAddress(ctx, addressClaim, addresses)
+			if err != nil {
+				return addresses, err
+			}
+			expired := meta.FindStatusCondition(addressClaim.Status.Conditions,
+				ipamv1.IPClaimLeaseExpiredCondition,
+			)
+			addressClaim.Status.ErrorMessage = pointer.StringPtr(expired.Message)
+			return addresses, nil
+		}
+		addresses, err = m.createAddress(ctx, addressClaim, addresses)
+		// The claims the pool has no address for are served by the first
+		// fallback pool that is not exhausted
+		if err != nil && addressClaim.Status.Address == nil && isExhaustedClaim(addressClaim) {
+			fallbackPool, fallbackErr := m.fallbackPool(ctx, addressClaim)
+			if fallbackErr != nil {
+				return addresses, fallbackErr
+			}
+			if fallbackPool != "" {
+				addressClaim.Status.ErrorMessage = nil
+				return m.handOverClaim(ctx, addressClaim, addresses, fallbackPool, "is exhausted")
+			}
+		}
+		m.setStaleCondition(addressClaim)
+		if m.setBindingFailedCondition(addressClaim) {
+			return addresses, nil
+		}
+		if err != nil {
+			return addresses, err
+		}
+	} else {
+		addresses, err = m.deleteAddress(ctx, addressClaim, addresses)
+		if err != nil {
+			return addresses, err
+		}
+	}
+	return addresses, nil
+}
+
+// isDrainingClaim returns true if the claim is handed over to the target pool
+// of the drain of the pool: it has no address, or its migration is requested
+func (m *IPPoolManager) isDrainingClaim(addressClaim *ipamv1.IPClaim) bool {
+	if m.IPPool.Spec.Drain == nil || m.IPPool.IsClusterScoped() || !addressClaim.DeletionTimestamp.IsZero() {
+		return false
+	}
+	if addressClaim.Status.Address == nil {
+		return true
+	}
+	_, migrate := addressClaim.Annotations[ipamv1.MigrateAnnotation]
+	return migrate
+}
+
+// handOverClaim releases the addresses of a claim, then annotates the claim to
+// be served by the target pool of the drain or a fallback pool. The claim is
+// only handed over once its addresses are released. The reason completes the
+// message of the event, after the name of the pool.
+func (m *IPPoolManager) handOverClaim(ctx context.Context,
+	addressClaim *ipamv1.IPClaim, addresses map[ipamv1.IPAddressStr]string,
+	targetPool string, reason string,
+) (map[ipamv1.IPAddressStr]string, error) {
+	claimKey := m.allocationKey(addressClaim.Name, addressClaim.Namespace)
+	if len(m.claimAllocationKeys(claimKey)) != 0 {
+		var err error
+		addresses, err = m.deleteAddress(ctx, addressClaim, addresses)
+		if err != nil {
+			return addresses, err
+		}
+		if len(m.claimAllocationKeys(claimKey)) != 0 {
+			return addresses, nil
+		}
+	}
+	if addressClaim.Annotations == nil {
+		addressClaim.Annotations = map[string]string{}
+	}
+	addressClaim.Annotations[ipamv1.ServedByAnnotation] = targetPool
+	delete(addressClaim.Annotations, ipamv1.MigrateAnnotation)
+	addressClaim.Status.Address = nil
+	addressClaim.Status.Addresses = nil
+	addressClaim.Status.Pool = nil
+	m.Log.Info("Claim handed over", "IPClaim", addressClaim.Name, "IPPool", targetPool)
+	if m.Recorder != nil {
+		m.Recorder.Eventf(addressClaim, corev1.EventTypeNormal, "HandedOver",
+			"IPPool %s %s, the claim is served by IPPool %s", m.IPPool.Name, reason, targetPool,
+		)
+	}
+	return addresses, nil
+}
+
+// isExhaustedClaim returns true if the pool has no address left for the claim
+func isExhaustedClaim(addressClaim *ipamv1.IPClaim) bool {
+	if addressClaim.Status.ErrorMessage == nil {
+		return false
+	}
+	errorMessage := *addressClaim.Status.ErrorMessage
+	return errorMessage == exhaustedMessage || errorMessage == noAddressSetMessage
+}
+
+// fallbackPool returns the name of the first fallback pool able to serve the
+// claim, or an empty string if none is. The fallback pools that are missing,
+// being deleted, drained, externally managed, exhausted or not allowing the
+// namespace of the claim are skipped.
+func (m *IPPoolManager) fallbackPool(ctx context.Context, addressClaim *ipamv1.IPClaim) (string, error) {
+	if m.IPPool.IsClusterScoped() {
+		return "", nil
+	}
+	for _, name := range m.IPPool.Spec.FallbackPools {
+		fallbackPool := &ipamv1.IPPool{}
+		err := m.client.Get(ctx, client.ObjectKey{Name: name, Namespace: m.IPPool.Namespace}, fallbackPool)
+		if apierrors.IsNotFound(err) {
+			m.Log.Info("Fallback pool not found", "IPPool", name)
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if !fallbackPool.DeletionTimestamp.IsZero() || fallbackPool.Spec.Drain != nil ||
+			fallbackPool.Spec.ExternallyManaged || !fallbackPool.IsNamespaceAllowed(addressClaim.Namespace) ||
+			meta.IsStatusConditionTrue(fallbackPool.Status.Conditions, ipamv1.IPPoolExhaustedCondition) {
+			continue
+		}
+		return name, nil
+	}
+	return "", nil
+}
+
+// setDrainedCondition sets the drained condition of a draining pool, true
+// once no address is allocated to its claims anymore. It is removed from the
+// pools that are not drained.
+func (m *IPPoolManager) setDrainedCondition() {
+	if m.IPPool.Spec.Drain == nil {
+		meta.RemoveStatusCondition(&m.IPPool.Status.Conditions, ipamv1.IPPoolDrainedCondition)
+		return
+	}
+	if left := len(m.IPPool.Status.Allocations); left != 0 {
+		meta.SetStatusCondition(&m.IPPool.Status.Conditions, metav1.Condition{
+			Type:    ipamv1.IPPoolDrainedCondition,
+			Status:  metav1.ConditionFalse,
+			Reason:  "Draining",
+			Message: fmt.Sprintf("%d addresses left to move to IPPool %s", left, m.IPPool.Spec.Drain.TargetPool),
+		})
+		return
+	}
+	meta.SetStatusCondition(&m.IPPool.Status.Conditions, metav1.Condition{
+		Type:    ipamv1.IPPoolDrainedCondition,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Drained",
+		Message: fmt.Sprintf("the claims are served by IPPool %s", m.IPPool.Spec.Drain.TargetPool),
+	})
+}
+
+// setDeletionBlockedCondition sets the deletion blocked condition of a pool
+// being deleted, true with the claims holding its addresses until they are all
+// released. It is removed from the pools that are not deleted and the ones
+// orphaning their claims.
+func (m *IPPoolManager) setDeletionBlockedCondition() {
+	if m.IPPool.DeletionTimestamp.IsZero() ||
+		m.IPPool.Spec.DeletionPolicy == ipamv1.DeletionPolicyOrphan {
+		meta.RemoveStatusCondition(&m.IPPool.Status.Conditions, ipamv1.IPPoolDeletionBlockedCondition)
+		return
+	}
+	if message := m.IPPool.DeletionBlockedMessage(); message != "" {
+		meta.SetStatusCondition(&m.IPPool.Status.Conditions, metav1.Condition{
+			Type:    ipamv1.IPPoolDeletionBlockedCondition,
+			Status:  metav1.ConditionTrue,
+			Reason:  "AddressesAllocated",
+			Message: message,
+		})
+		return
+	}
+	meta.SetStatusCondition(&m.IPPool.Status.Conditions, metav1.Condition{
+		Type:   ipamv1.IPPoolDeletionBlockedCondition,
+		Status: metav1.ConditionFalse,
+		Reason: "AddressesReleased",
+	})
+}
+
+// setStaleCondition sets the stale condition of a claim left without an
+// address for longer than the stale claim threshold. The condition is false if
+// the pools are exhausted, to tell the exhaustion apart from controller
+// problems.
+func (m *IPPoolManager) setStaleCondition(addressClaim *ipamv1.IPClaim) {
+	if addressClaim.Status.Address != nil {
+		if meta.FindStatusCondition(addressClaim.Status.Conditions, ipamv1.IPClaimStaleCondition) != nil {
+			meta.SetStatusCondition(&addressClaim.Status.Conditions, metav1.Condition{
+				Type:   ipamv1.IPClaimStaleCondition,
+				Status: metav1.ConditionFalse,
+				Reason: "AddressAllocated",
+			})
+		}
+		return
+	}
+	if m.StaleClaimThreshold == 0 ||
+		time.Since(addressClaim.CreationTimestamp.Time) < m.StaleClaimThreshold {
+		return
+	}
+
+	errorMessage := ""
+	if addressClaim.Status.ErrorMessage != nil {
+		errorMessage = *addressClaim.Status.ErrorMessage
+	}
+	if errorMessage == exhaustedMessage || errorMessage == noAddressSetMessage {
+		meta.SetStatusCondition(&addressClaim.Status.Conditions, metav1.Condition{
+			Type:    ipamv1.IPClaimStaleCondition,
+			Status:  metav1.ConditionFalse,
+			Reason:  "PoolExhausted",
+			Message: errorMessage,
+		})
+		return
+	}
+	message := fmt.Sprintf("No address allocated for more than %s", m.StaleClaimThreshold)
+	if errorMessage != "" {
+		message += ": " + errorMessage
+	}
+	m.Log.Info("Stale claim", "IPClaim", addressClaim.Name, "message", message)
+	meta.SetStatusCondition(&addressClaim.Status.Conditions, metav1.Condition{
+		Type:    ipamv1.IPClaimStaleCondition,
+		Status:  metav1.ConditionTrue,
+		Reason:  "AddressNotAllocated",
+		Message: message,
+	})
+}
+
+// bindingDeadline returns the binding deadline of the claim, defaulting to
+// the one of the pool, zero if none
+func (m *IPPoolManager) bindingDeadline(addressClaim *ipamv1.IPClaim) time.Duration {
+	if addressClaim.Spec.BindingDeadline != nil {
+		return addressClaim.Spec.BindingDeadline.Duration
+	}
+	if m.IPPool.Spec.ClaimBindingDeadline != nil {
+		return m.IPPool.Spec.ClaimBindingDeadline.Duration
+	}
+	return 0
+}
+
+// leaseExpiry returns the time the lease of the claim expires, its duration
+// after the creation of the claim or its last renewal. The boolean is false if
+// the claim has no lease. The imported addresses of an externally managed
+// pool are never released.
+func (m *IPPoolManager) leaseExpiry(addressClaim *ipamv1.IPClaim) (time.Time, bool) {
+	duration := time.Duration(0)
+	if addressClaim.Spec.LeaseDuration != nil {
+		duration = addressClaim.Spec.LeaseDuration.Duration
+	} else if m.IPPool.Spec.LeaseDuration != nil {
+		duration = m.IPPool.Spec.LeaseDuration.Duration
+	}
+	if duration <= 0 || m.IPPool.Spec.ExternallyManaged || !feature.Gates.Enabled(feature.LeaseExpiry) {
+		return time.Time{}, false
+	}
+	start := addressClaim.CreationTimestamp.Time
+	// An invalid renewal is rejected by the webhook, it is ignored here
+	if renewal, err := addressClaim.GetLeaseRenewal(); err == nil && renewal.After(start) {
+		start = renewal
+	}
+	return start.Add(duration), true
+}
+
+// setLeaseExpiredCondition marks the lease of the claim expired, with an
+// event, if it was not renewed before its expiry. The condition is set to
+// false once the lease is renewed. It returns true if the lease expired.
+func (m *IPPoolManager) setLeaseExpiredCondition(addressClaim *ipamv1.IPClaim) bool {
+	expired := meta.FindStatusCondition(addressClaim.Status.Conditions,
+		ipamv1.IPClaimLeaseExpiredCondition,
+	)
+	expiry, ok := m.leaseExpiry(addressClaim)
+	if !ok || time.Now().Before(expiry) {
+		if expired != nil && expired.Status == metav1.ConditionTrue {
+			meta.SetStatusCondition(&addressClaim.Status.Conditions, metav1.Condition{
+				Type:   ipamv1.IPClaimLeaseExpiredCondition,
+				Status: metav1.ConditionFalse,
+				Reason: "Renewed",
+			})
+		}
+		return false
+	}
+	if expired != nil && expired.Status == metav1.ConditionTrue {
+		return true
+	}
+
+	message := fmt.Sprintf("Lease expired at %s", expiry.UTC().Format(time.RFC3339))
+	m.Log.Info("Claim lease expired", "IPClaim", addressClaim.Name, "message", message)
+	meta.SetStatusCondition(&addressClaim.Status.Conditions, metav1.Condition{
+		Type:    ipamv1.IPClaimLeaseExpiredCondition,
+		Status:  metav1.ConditionTrue,
+		Reason:  "NotRenewed",
+		Message: message,
+	})
+	if m.Recorder != nil {
+		m.Recorder.Event(addressClaim, corev1.EventTypeWarning, "LeaseExpired", message)
+	}
+	return true
+}
+
+// setBindingFailedCondition marks the claim failed, with an event, if it is
+// left without an address after its binding deadline. It returns true if the
+// claim failed.
+func (m *IPPoolManager) setBindingFailedCondition(addressClaim *ipamv1.IPClaim) bool {
+	deadline := m.bindingDeadline(addressClaim)
+	if addressClaim.Status.Address != nil || deadline <= 0 ||
+		time.Since(addressClaim.CreationTimestamp.Time) < deadline {
+		return false
+	}
+
+	message := fmt.Sprintf("No address allocated within the binding deadline of %s", deadline)
+	if addressClaim.Status.ErrorMessage != nil {
+		message += ": " + *addressClaim.Status.ErrorMessage
+	}
+	m.Log.Info("Claim binding failed", "IPClaim", addressClaim.Name, "message", message)
+	meta.SetStatusCondition(&addressClaim.Status.Conditions, metav1.Condition{
+		Type:    ipamv1.IPClaimBindingFailedCondition,
+		Status:  metav1.ConditionTrue,
+		Reason:  "DeadlineExceeded",
+		Message: message,
+	})
+	addressClaim.Status.ErrorMessage = pointer.StringPtr(message)
+	if m.Recorder != nil {
+		m.Recorder.Event(addressClaim, corev1.EventTypeWarning, "BindingFailed", message)
+	}
+	return true
+}
+
+// addressAllocation is an address allocated to a claim, with the network
+// settings of the pool it was allocated from
+type addressAllocation struct {
+	address ipamv1.IPAddressStr
+	prefix  int
+	// delegatedPrefix is the prefix starting at the address, if the pool
+	// delegates prefixes
+	delegatedPrefix *ipamv1.IPSubnetStr
+	gateway         *ipamv1.IPAddressStr
+	dnsServers      []ipamv1.IPAddressStr
+	searchDomains   []string
+	ntpServers      []string
+	domainName      string
+}
+
+// anyPool allows the allocation from any pool of the IPPool
+const anyPool = -1
+
+const (
+	// exhaustedMessage is the error message of the claims for which no
+	// address is available
+	exhaustedMessage = "Exhausted IP Pools"
+	// noAddressSetMessage is the error message of the claims of an address
+	// set for which no pool has enough addresses available
+	noAddressSetMessage = "No pool with enough addresses for the address set"
+	// notImportedMessage is the error message of the claims of an externally
+	// managed pool whose IPAddress is not imported yet
+	notImportedMessage = "Waiting for the IPAddress to be imported"
+	// preAllocatedDisabledMessage is the error message of the claims whose
+	// pre-allocated address is in a disabled pool
+	preAllocatedDisabledMessage = "Pre-allocated IP in a disabled pool"
+)
+
+// allocateAddress allocates the main address of the claim
+func (m *IPPoolManager) allocateAddress(addressClaim *ipamv1.IPClaim,
+	addresses map[ipamv1.IPAddressStr]string,
+) (ipamv1.IPAddressStr, int, *ipamv1.IPAddressStr, []ipamv1.IPAddressStr, error) {
+	allocation, poolIndex, err := m.allocateRoleAddress(addressClaim, "", addresses, anyPool)
+	if err != nil {
+		return "", 0, nil, []ipamv1.IPAddressStr{}, err
+	}
+	m.bindAffinityGroup(addressClaim, poolIndex)
+	return allocation.address, allocation.prefix, allocation.gateway, allocation.dnsServers, nil
+}
+
+// allocateAddressSet allocates the addresses of the claim for the given roles,
+// all of them or none. The IPv6 address of a dual-stack pool is allocated
+// apart, from the IPv6 pools.
+func (m *IPPoolManager) allocateAddressSet(addressClaim *ipamv1.IPClaim,
+	roles []string, addresses map[ipamv1.IPAddressStr]string,
+) (map[string]addressAllocation, error) {
+	ipv6Role := false
+	if m.IPPool.Spec.DualStack {
+		familyRoles := make([]string, 0, len(roles))
+		for _, role := range roles {
+			if role == ipamv1.DualStackIPv6Role {
+				ipv6Role = true
+				continue
+			}
+			familyRoles = append(familyRoles, role)
+		}
+		roles = familyRoles
+	}
+
+	allocations := map[string]addressAllocation{}
+	if len(roles) != 0 {
+		var err error
+		allocations, err = m.allocateFamilySet(addressClaim, roles, addresses)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !ipv6Role {
+		return allocations, nil
+	}
+
+	// The addresses of the set are not reserved yet
+	claimKey := m.allocationKey(addressClaim.Name, addressClaim.Namespace)
+	taken := make(map[ipamv1.IPAddressStr]string, len(addresses)+len(allocations))
+	for address, key := range addresses {
+		taken[address] = key
+	}
+	for role, allocation := range allocations {
+		taken[allocation.address] = addressKey(claimKey, role)
+	}
+	allocation, _, err := m.allocateRoleAddress(addressClaim, ipamv1.DualStackIPv6Role, taken, anyPool)
+	if err != nil {
+		return nil, err
+	}
+	allocations[ipamv1.DualStackIPv6Role] = allocation
+	return allocations, nil
+}
+
+// allocateFamilySet allocates the addresses of the claim for the given roles,
+// all of them or none. The addresses of an HA address set, or of a claim with
+// an address count, are all allocated from the same pool.
+func (m *IPPoolManager) allocateFamilySet(addressClaim *ipamv1.IPClaim,
+	roles []string, addresses map[ipamv1.IPAddressStr]string,
+) (map[string]addressAllocation, error) {
+	if addressClaim.IsAddressSet() && len(roles) > 1 {
+		for poolIndex := range m.IPPool.Spec.Pools {
+			allocations, _, err := m.allocateRoles(addressClaim, roles, addresses, poolIndex)
+			if err == nil {
+				addressClaim.Status.ErrorMessage = nil
+				m.bindAffinityGroup(addressClaim, poolIndex)
+				return allocations, nil
+			}
+		}
+		addressClaim.Status.ErrorMessage = pointer.StringPtr(noAddressSetMessage)
+		return nil, errors.New(noAddressSetMessage)
+	}
+
+	allocations, poolIndex, err := m.allocateRoles(addressClaim, roles, addresses, anyPool)
+	if err != nil {
+		return nil, err
+	}
+	m.bindAffinityGroup(addressClaim, poolIndex)
+	return allocations, nil
+}
+
+// allocateRoles allocates an address for each role from the given pool or
+// from any pool. It returns the index of the pool of the first address.
+func (m *IPPoolManager) allocateRoles(addressClaim *ipamv1.IPClaim,
+	roles []string, addresses map[ipamv1.IPAddressStr]string, poolFilter int,
+) (map[string]addressAllocation, int, error) {
+	// Allocate on a copy, to not reserve the addresses of an incomplete set
+	if len(roles) > 1 {
+		claimAddresses := make(map[ipamv1.IPAddressStr]string, len(addresses))
+		for address, key := range addresses {
+			claimAddresses[address] = key
+		}
+		addresses = claimAddresses
+	}
+
+	claimKey := m.allocationKey(addressClaim.Name, addressClaim.Namespace)
+	allocations := make(map[string]addressAllocation, len(roles))
+	firstPool := anyPool
+	for i, role := range roles {
+		allocation, poolIndex, err := m.allocateRoleAddress(addressClaim, role, addresses, poolFilter)
+		if err != nil {
+			return nil, anyPool, err
+		}
+		if i == 0 {
+			firstPool = poolIndex
+		}
+		allocations[role] = allocation
+		if len(roles) > 1 {
+			addresses[allocation.address] = addressKey(claimKey, role)
+		}
+	}
+	return allocations, firstPool, nil
+}
+
+// bindAffinityGroup binds the affinity group of the claim to the pool of its
+// first allocation
+func (m *IPPoolManager) bindAffinityGroup(addressClaim *ipamv1.IPClaim, poolIndex int) {
+	// The binding of a held claim is not committed
+	if addressClaim.Spec.AffinityGroup == "" || len(addressClaim.GetBindingHolds()) != 0 {
+		return
+	}
+	if _, ok := m.IPPool.Status.AffinityGroups[addressClaim.Spec.AffinityGroup]; ok {
+		return
+	}
+	if m.IPPool.Status.AffinityGroups == nil {
+		m.IPPool.Status.AffinityGroups = make(map[string]int)
+	}
+	m.IPPool.Status.AffinityGroups[addressClaim.Spec.AffinityGroup] = poolIndex
+	m.updateStatusTimestamp()
+}
+
+// allocateRoleAddress allocates an address for the role of the claim, from
+// the given pool or from any pool. It returns the index of the pool the
+// address was allocated from.
+func (m *IPPoolManager) allocateRoleAddress(addressClaim *ipamv1.IPClaim,
+	role string, addresses map[ipamv1.IPAddressStr]string, poolFilter int,
+) (addressAllocation, int, error) {
+	var allocatedAddress ipamv1.IPAddressStr = ""
+	allocatedPool := anyPool
+
+	// Get pre-allocated addresses
+	preAllocationKey := addressKey(
+		m.allocationKey(addressClaim.Name, addressClaim.Namespace), role,
+	)
+	preAllocatedAddress, ipPreAllocated := m.IPPool.Spec.PreAllocations[preAllocationKey]
+	// The address reserved for the MAC address of the claim is allocated
+	// like a pre-allocated one
+	if !ipPreAllocated && role == "" && len(m.IPPool.Spec.MACReservations) != 0 {
+		mac, err := addressClaim.GetMACAddress()
+		if err != nil {
+			addressClaim.Status.ErrorMessage = pointer.StringPtr("Invalid MAC address")
+			return addressAllocation{}, anyPool, err
+		}
+		if reserved, ok := m.IPPool.Spec.MACReservations[mac]; ok && mac != "" {
+			preAllocatedAddress, ipPreAllocated = reserved, true
+			m.explain("%s is reserved for the MAC address %s", preAllocatedAddress, mac)
+		}
+	}
+	// With the EUI64 strategy, the IPv6 address of a claim with a MAC address
+	// is derived from it and allocated like a pre-allocated one
+	if !ipPreAllocated && m.IPPool.Spec.AllocationStrategy == ipamv1.AllocationStrategyEUI64 &&
+		role == m.eui64Role() {
+		address, ok, err := m.eui64Address(addressClaim)
+		if err != nil {
+			addressClaim.Status.ErrorMessage = pointer.StringPtr("Invalid MAC address")
+			return addressAllocation{}, anyPool, err
+		}
+		if ok {
+			preAllocatedAddress, ipPreAllocated = address, true
+			m.explain("%s is derived from the MAC address of the claim", preAllocatedAddress)
+		}
+	}
+	// The address retained for a deleted claim of the same name is allocated
+	// back like a pre-allocated one, or forgotten if it can not be
+	retained, ipRetained := m.IPPool.Status.RetainedAddresses[preAllocationKey]
+	if ipPreAllocated {
+		ipRetained = false
+	} else if ipRetained {
+		preAllocatedAddress, ipPreAllocated = retained.Address, true
+		m.explain("%s is retained for %s", preAllocatedAddress, preAllocationKey)
+	}
+	// The preferred address of the claim is tried first, like a pre-allocated
+	// one, and skipped if it is not available unless it is strict
+	ipPreferred := false
+	if !ipPreAllocated && role == "" && addressClaim.Spec.PreferredAddress != nil {
+		preAllocatedAddress, ipPreAllocated = addressClaim.Spec.PreferredAddress.Address, true
+		ipPreferred = true
+		m.explain("%s is preferred by the claim", preAllocatedAddress)
+		if owner, ok := addresses[preAllocatedAddress]; ok && owner != preAllocationKey {
+			if owner == "" {
+				return m.skipPreferredAddress(addressClaim, role, addresses, poolFilter,
+					"pre-allocated or reserved",
+				)
+			}
+			return m.skipPreferredAddress(addressClaim, role, addresses, poolFilter,
+				"allocated to "+owner,
+			)
+		}
+	}
+	// Refuse to assign an address allocated to another claim
+	if ipPreAllocated {
+		if !ipRetained {
+			m.explain("%s is pre-allocated to %s", preAllocatedAddress, preAllocationKey)
+		}
+		if owner := addresses[preAllocatedAddress]; owner != "" && owner != preAllocationKey {
+			if ipRetained {
+				return m.forgetRetainedAddress(addressClaim, role, addresses, poolFilter,
+					"allocated to "+owner,
+				)
+			}
+			message := fmt.Sprintf("Pre-allocated IP already allocated to %s", owner)
+			addressClaim.Status.ErrorMessage = pointer.StringPtr(message)
+			m.explain("no address allocated: %s", message)
+			return addressAllocation{}, anyPool, errors.New(message)
+		}
+	}
+	// If the IP is pre-allocated, the default prefix and gateway are used
+	prefix := m.IPPool.Spec.Prefix
+	gateway := m.IPPool.Spec.Gateway
+	dnsServers := m.IPPool.Spec.DNSServers
+	searchDomains := m.IPPool.Spec.SearchDomains
+	ntpServers := m.IPPool.Spec.NTPServers
+	domainName := m.IPPool.Spec.DomainName
+
+	ipAllocated := false
+	preAllocatedDisabled := false
+
+	// selectPool sets the network settings of the pool of the allocated
+	// address. The subnet of the pool takes precedence over the defaults of
+	// the IPPool, that might be for the subnet of another pool.
+	selectPool := func(poolIndex int, pool ipamv1.Pool, poolRange *ipamv1.PoolRange) {
+		if pool.Prefix != 0 {
+			prefix = pool.Prefix
+		} else if subnetPrefix := poolRange.Prefix(); subnetPrefix != 0 {
+			prefix = subnetPrefix
+		}
+		gateway = m.poolGateway(pool, poolRange)
+		if len(pool.DNSServers) != 0 {
+			dnsServers = pool.DNSServers
+		}
+		if len(pool.SearchDomains) != 0 {
+			searchDomains = pool.SearchDomains
+		}
+		if len(pool.NTPServers) != 0 {
+			ntpServers = pool.NTPServers
+		}
+		if pool.DomainName != "" {
+			domainName = pool.DomainName
+		}
+		allocatedPool = poolIndex
+	}
+
+	// The least recently used address, if no address that was never released
+	// is free
+	var lruAddress ipamv1.IPAddressStr
+	var lruReleasedAt metav1.Time
+	lruPool := anyPool
+
+	// Get the pool the affinity group of the claim is bound to, if any
+	groupPool, groupBound := anyPool, false
+	ipv6Role := m.IPPool.Spec.DualStack && role == ipamv1.DualStackIPv6Role
+	if addressClaim.Spec.AffinityGroup != "" && !ipv6Role {
+		groupPool, groupBound = m.IPPool.Status.AffinityGroups[addressClaim.Spec.AffinityGroup]
+		if groupBound && groupPool >= len(m.IPPool.Spec.Pools) {
+			// The pools were modified, the group is bound to a pool again
+			groupBound = false
+		}
+		if groupBound {
+			m.explain("affinity group %s is bound to pool %d", addressClaim.Spec.AffinityGroup, groupPool)
+		}
+	}
+
+	// Get the subnet the claim is restricted to
+	var claimSubnet *net.IPNet
+	if addressClaim.Spec.Subnet != nil {
+		var err error
+		_, claimSubnet, err = net.ParseCIDR(string(*addressClaim.Spec.Subnet))
+		if err != nil {
+			addressClaim.Status.ErrorMessage = pointer.StringPtr("Invalid subnet")
+			return addressAllocation{}, anyPool, errors.Wrap(err, "Invalid subnet")
+		}
+		m.explain("the claim is restricted to the subnet %s", claimSubnet)
+		if ones, _ := claimSubnet.Mask.Size(); m.IPPool.Spec.DelegatedPrefix != 0 && ones > m.IPPool.Spec.DelegatedPrefix {
+			addressClaim.Status.ErrorMessage = pointer.StringPtr("Claim subnet smaller than the delegated prefixes")
+			m.explain("no address allocated: the claim subnet is smaller than the delegated prefixes")
+			return addressAllocation{}, anyPool, errors.New("Claim subnet smaller than the delegated prefixes")
+		}
+		if ipPreAllocated && !claimSubnet.Contains(net.ParseIP(string(preAllocatedAddress))) {
+			if ipPreferred {
+				return m.skipPreferredAddress(addressClaim, role, addresses, poolFilter,
+					"out of the claim subnet",
+				)
+			}
+			if ipRetained {
+				return m.forgetRetainedAddress(addressClaim, role, addresses, poolFilter,
+					"out of the claim subnet",
+				)
+			}
+			addressClaim.Status.ErrorMessage = pointer.StringPtr("Pre-allocated IP out of the claim subnet")
+			m.explain("no address allocated: pre-allocated IP out of the claim subnet")
+			return addressAllocation{}, anyPool, errors.New("Pre-allocated IP out of the claim subnet")
+		}
+	}
+
+	for poolIndex, pool := range m.IPPool.Spec.Pools {
+		if ipAllocated {
+			break
+		}
+		if groupBound && poolIndex != groupPool {
+			m.explain("pool %d skipped: not the pool of the affinity group", poolIndex)
+			continue
+		}
+		if poolFilter != anyPool && poolIndex != poolFilter {
+			m.explain("pool %d skipped: the address set is allocated from pool %d", poolIndex, poolFilter)
+			continue
+		}
+		poolRange, err := ipamv1.NewPoolRange(pool)
+		if err != nil {
+			m.explain("pool %d skipped: %s", poolIndex, err)
+			continue
+		}
+		// The IPv6 address of a dual-stack pool is allocated from the IPv6
+		// pools, and the other addresses from the IPv4 pools
+		if m.IPPool.Spec.DualStack && poolRange.IsIPv6() != ipv6Role {
+			m.explain("pool %d skipped: not a pool of the IP family of the address", poolIndex)
+			continue
+		}
+		if pool.Disabled {
+			if ipPreAllocated && poolRange.Contains(net.ParseIP(string(preAllocatedAddress))) {
+				preAllocatedDisabled = true
+			}
+			m.explain("pool %d skipped: disabled", poolIndex)
+			continue
+		}
+		m.explain("pool %d considered: %s", poolIndex, describePool(pool))
+		// A pre-allocated address is looked up in the pool rather than
+		// walked up to, the derived IPv6 addresses being far in their pools
+		if ipPreAllocated && m.IPPool.Spec.DelegatedPrefix == 0 {
+			ip := net.ParseIP(string(preAllocatedAddress))
+			if ip == nil || ip.String() != string(preAllocatedAddress) ||
+				!poolRange.Contains(ip) || poolRange.IsNetworkOrBroadcast(ip) || poolRange.IsExcluded(ip) ||
+				m.isPoolGateway(pool, poolRange, preAllocatedAddress) {
+				continue
+			}
+			allocatedAddress, ipAllocated = preAllocatedAddress, true
+			selectPool(poolIndex, pool, poolRange)
+			continue
+		}
+		index := 0
+		// In prefix delegation, the walk goes through the prefixes of the pool,
+		// identified by their first address
+		var delegatedPrefix *net.IPNet
+		if claimSubnet != nil {
+			if !poolRange.Overlaps(claimSubnet) {
+				m.explain("pool %d skipped: out of the claim subnet", poolIndex)
+				continue
+			}
+			// Start directly from the beginning of the subnet if it is
+			// inside the range
+			if subnetIndex, err := poolRange.IndexOf(claimSubnet.IP); err == nil {
+				index = subnetIndex
+			}
+		}
+		// The walk of the Random and Hash strategies starts at a random or
+		// hashed index and wraps around to the beginning of the pool
+		firstIndex, wrapped := 0, false
+		if claimSubnet == nil && !ipPreAllocated && m.IPPool.Spec.DelegatedPrefix == 0 {
+			firstIndex = m.firstIndex(poolRange, preAllocationKey)
+			index = firstIndex
+		}
+		used := m.poolBitmap(poolIndex)
+		for !ipAllocated {
+			if m.IPPool.Spec.DelegatedPrefix != 0 {
+				delegatedPrefix, err = nextDelegatedPrefix(poolRange, delegatedPrefix,
+					claimSubnet, m.IPPool.Spec.DelegatedPrefix,
+				)
+				if err != nil {
+					m.explain("pool %d exhausted", poolIndex)
+					break
+				}
+				allocatedAddress = ipamv1.IPAddressStr(delegatedPrefix.IP.String())
+			} else {
+				// The addresses known to be in use are skipped at once
+				if used != nil {
+					index = used.nextFree(index)
+				}
+				if wrapped && index >= firstIndex {
+					m.explain("pool %d exhausted", poolIndex)
+					break
+				}
+				allocatedAddress, err = poolRange.GetIPAddress(index)
+				if err != nil {
+					if firstIndex != 0 && !wrapped {
+						index, wrapped = 0, true
+						continue
+					}
+					m.explain("pool %d exhausted", poolIndex)
+					break
+				}
+				index++
+			}
+			// The address is parsed once for all the checks of the walk
+			ip := net.ParseIP(string(allocatedAddress))
+			// The walk started in the subnet, once out of it, the following
+			// addresses of this pool are all out of it
+			if claimSubnet != nil && !claimSubnet.Contains(ip) {
+				m.explain("pool %d exhausted: %s is out of the claim subnet", poolIndex, allocatedAddress)
+				break
+			}
+			// The network and broadcast addresses of the subnet of the pool
+			// are not usable by the hosts, the delegated prefixes are routed
+			if delegatedPrefix == nil && poolRange.IsNetworkOrBroadcast(ip) {
+				m.explain("%s skipped: network or broadcast address", allocatedAddress)
+				continue
+			}
+			// The excluded ranges are skipped at once, they can span most
+			// of an IPv6 pool
+			if end, ok := poolRange.ExclusionEnd(ip); ok {
+				m.explain("%s skipped: excluded from the pool up to %s", allocatedAddress, end)
+				if delegatedPrefix != nil {
+					continue
+				}
+				endIndex, err := poolRange.IndexOf(end)
+				if err == nil && endIndex < ipamv1.MaxPoolIndex {
+					index = endIndex + 1
+					continue
+				}
+				if firstIndex != 0 && !wrapped {
+					index, wrapped = 0, true
+					continue
+				}
+				m.explain("pool %d exhausted", poolIndex)
+				break
+			}
+			if delegatedPrefix == nil && m.isPoolGateway(pool, poolRange, allocatedAddress) {
+				m.explain("%s skipped: gateway", allocatedAddress)
+				continue
+			}
+			// We have a pre-allocated ip, we just need to ensure that it matches the current address
+			// if it does not, continue and try the next address
+			if ipPreAllocated && allocatedAddress != preAllocatedAddress {
+				continue
+			}
+			// Here the two addresses match, so we continue with that one
+			if ipPreAllocated {
+				ipAllocated = true
+			}
+			// If we have a preallocated address, this is useless, otherwise, check if the
+			// ip is free
+			if _, ok := addresses[allocatedAddress]; !ok && allocatedAddress != "" {
+				ipAllocated = true
+			}
+			if !ipAllocated {
+				owner := addresses[allocatedAddress]
+				if retained, ok := m.IPPool.Status.RetainedAddresses[owner]; ok && retained.Address == allocatedAddress {
+					m.explain("%s skipped: retained for %s", allocatedAddress, owner)
+				} else if owner != "" {
+					m.explain("%s skipped: allocated to %s", allocatedAddress, owner)
+				} else if releasedAt, ok := m.IPPool.Status.QuarantinedAddresses[string(allocatedAddress)]; ok {
+					m.explain("%s skipped: in quarantine since %s", allocatedAddress,
+						releasedAt.UTC().Format(time.RFC3339),
+					)
+				} else {
+					m.explain("%s skipped: pre-allocated or reserved", allocatedAddress)
+				}
+				continue
+			}
+
+			// The least recently used addresses are allocated once no
+			// address that was never released is left
+			if releasedAt, ok := m.IPPool.Status.ReleasedAddresses[string(allocatedAddress)]; ok &&
+				!ipPreAllocated && m.IPPool.Spec.AllocationStrategy == ipamv1.AllocationStrategyLeastRecentlyUsed {
+				if lruPool == anyPool || releasedAt.Before(&lruReleasedAt) {
+					lruAddress, lruReleasedAt, lruPool = allocatedAddress, releasedAt, poolIndex
+				}
+				ipAllocated = false
+				m.explain("%s skipped: released at %s", allocatedAddress,
+					releasedAt.UTC().Format(time.RFC3339),
+				)
+				continue
+			}
+
+			selectPool(poolIndex, pool, poolRange)
+		}
+	}
+	if !ipAllocated && lruPool != anyPool {
+		poolRange, err := ipamv1.NewPoolRange(m.IPPool.Spec.Pools[lruPool])
+		if err == nil {
+			allocatedAddress = lruAddress
+			ipAllocated = true
+			m.explain("%s is the least recently used address", allocatedAddress)
+			selectPool(lruPool, m.IPPool.Spec.Pools[lruPool], poolRange)
+		}
+	}
+	if !ipAllocated && ipRetained {
+		return m.forgetRetainedAddress(addressClaim, role, addresses, poolFilter,
+			"out of the pools",
+		)
+	}
+	if !ipAllocated && ipPreferred {
+		return m.skipPreferredAddress(addressClaim, role, addresses, poolFilter,
+			"out of the available pools",
+		)
+	}
+	// The pre-allocated IP is in a pool that is being drained
+	if !ipAllocated && preAllocatedDisabled {
+		addressClaim.Status.ErrorMessage = pointer.StringPtr(preAllocatedDisabledMessage)
+		m.explain("no address allocated: %s", preAllocatedDisabledMessage)
+		return addressAllocation{}, anyPool, errors.New(preAllocatedDisabledMessage)
+	}
+	// We have a preallocated IP but we did not find it in the pools! It means it is
+	// misconfigured
+	if !ipAllocated && ipPreAllocated {
+		addressClaim.Status.ErrorMessage = pointer.StringPtr("Pre-allocated IP out of bond")
+		m.explain("no address allocated: pre-allocated IP out of the pools")
+		return addressAllocation{}, anyPool, errors.New("Pre-allocated IP out of bond")
+	}
+	if !ipAllocated {
+		addressClaim.Status.ErrorMessage = pointer.StringPtr(exhaustedMessage)
+		m.explain("no address allocated: %s", exhaustedMessage)
+		return addressAllocation{}, anyPool, errors.New(exhaustedMessage)
+	}
+
+	// The claim prefix overrides the prefix of the pools
+	prefixOverride, ok, err := addressClaim.GetPrefixOverride()
+	if err != nil {
+		addressClaim.Status.ErrorMessage = pointer.StringPtr("Invalid prefix override")
+		return addressAllocation{}, anyPool, err
+	}
+	if ok {
+		maxPrefix := 8 * net.IPv6len
+		if addr, ok := parseAddr(string(allocatedAddress)); ok && addr.is4 {
+			maxPrefix = 8 * net.IPv4len
+		}
+		if prefixOverride < 0 || prefixOverride > maxPrefix {
+			addressClaim.Status.ErrorMessage = pointer.StringPtr("Invalid prefix override")
+			return addressAllocation{}, anyPool, errors.New("Invalid prefix override")
+		}
+		prefix = prefixOverride
+		m.explain("prefix overridden by the claim: %d", prefix)
+	}
+
+	gatewayStr := "none"
+	if gateway != nil {
+		gatewayStr = string(*gateway)
+	}
+	allocation := addressAllocation{
+		address:       allocatedAddress,
+		prefix:        prefix,
+		gateway:       gateway,
+		dnsServers:    dnsServers,
+		searchDomains: searchDomains,
+		ntpServers:    ntpServers,
+		domainName:    domainName,
+	}
+	if m.IPPool.Spec.DelegatedPrefix != 0 {
+		delegated := ipamv1.IPSubnetStr(fmt.Sprintf("%s/%d", allocatedAddress, m.IPPool.Spec.DelegatedPrefix))
+		allocation.delegatedPrefix = &delegated
+		m.explain("%s delegated", delegated)
+	}
+	m.explain("%s allocated from pool %d, prefix %d, gateway %s",
+		allocatedAddress, allocatedPool, prefix, gatewayStr,
+	)
+	return allocation, allocatedPool, nil
+}
+
+// nextDelegatedPrefix returns the prefix of the range following the given
+// one, or the first one of the range, or of the claim subnet if given
+func nextDelegatedPrefix(poolRange *ipamv1.PoolRange, previous *net.IPNet,
+	claimSubnet *net.IPNet, length int,
+) (*net.IPNet, error) {
+	if previous != nil {
+		return poolRange.PrefixAfter(previous)
+	}
+	if claimSubnet != nil {
+		return poolRange.PrefixAt(claimSubnet.IP, length)
+	}
+	return poolRange.PrefixAt(nil, length)
+}
 
-	if ok {
-		if _, ok := m.IPPool.Spec.PreAllocations[addressClaim.Name]; !ok {
+// describePool renders the bounds of a pool
+func describePool(pool ipamv1.Pool) string {
+	bounds := []string{}
+	if pool.Start != nil {
+		bounds = append(bounds, "start "+string(*pool.Start))
+	}
+	if pool.End != nil {
+		bounds = append(bounds, "end "+string(*pool.End))
+	}
+	if pool.Subnet != nil {
+		bounds = append(bounds, "subnet "+string(*pool.Subnet))
+	}
+	return strings.Join(bounds, ", ")
+}
+
+func (m *IPPoolManager) createAddress(ctx context.Context,
+	addressClaim *ipamv1.IPClaim, addresses map[ipamv1.IPAddressStr]string,
+) (map[ipamv1.IPAddressStr]string, error) {
+	if !Contains(addressClaim.Finalizers, ipamv1.IPClaimFinalizer) {
+		addressClaim.Finalizers = append(addressClaim.Finalizers,
+			ipamv1.IPClaimFinalizer,
+		)
+	}
+
+	claimKey := m.allocationKey(addressClaim.Name, addressClaim.Namespace)
+
+	// Get the roles that do not have an address yet
+	missingRoles := []string{}
+	for _, role := range m.addressRoles(addressClaim) {
+		if _, ok := m.IPPool.Status.Allocations[addressKey(claimKey, role)]; !ok {
+			missingRoles = append(missingRoles, role)
+		}
+	}
+	if len(missingRoles) == 0 {
+		if m.IPPool.Spec.ExternallyManaged {
+			bound, err := m.propagateClaimMetadata(ctx, addressClaim, claimKey)
+			if err != nil || !bound {
+				return addresses, err
+			}
+		}
+		if addressClaim.Status.Address == nil {
+			if err := m.adoptRetainedAddresses(ctx, addressClaim, claimKey); err != nil {
+				return addresses, err
+			}
+		}
+		m.setClaimAddresses(addressClaim, claimKey)
+		return addresses, m.syncBackendMetadata(ctx, addressClaim)
+	}
+
+	// The addresses of an externally managed pool are only imported
+	if m.IPPool.Spec.ExternallyManaged {
+		m.Log.Info("Waiting for the IPAddress to be imported", "Claim", addressClaim.Name)
+		addressClaim.Status.ErrorMessage = pointer.StringPtr(notImportedMessage)
+		return addresses, nil
+	}
+
+	// Get a new index for this machine
+	m.Log.Info("Getting address", "Claim", addressClaim.Name)
+	// Get a new IP for this owner
+	allocations, err := m.allocateAddressSet(addressClaim, missingRoles, addresses)
+	if err != nil {
+		return addresses, err
+	}
+
+	// The binding of a held claim is only proposed
+	if holds := addressClaim.GetBindingHolds(); len(holds) != 0 {
+		m.setBindingHeldCondition(addressClaim, missingRoles, allocations, holds)
+		return addresses, nil
+	}
+
+	// The addresses are reserved in the backend of the pool before being
+	// allocated, the addresses in use in the backend are skipped
+	backendAnnotations := map[string]map[string]string{}
+	backend, err := m.getBackend(ctx)
+	if err != nil {
+		addressClaim.Status.ErrorMessage = pointer.StringPtr("Failed to reach the backend")
+		return addresses, err
+	}
+	if backend != nil {
+		taken := make(map[ipamv1.IPAddressStr]string, len(addresses))
+		for address, owner := range addresses {
+			taken[address] = owner
+		}
+		for conflicts := 0; ; conflicts++ {
+			inUse, err := m.reserveAddresses(ctx, backend, addressClaim,
+				missingRoles, allocations, backendAnnotations,
+			)
+			if err != nil {
+				return addresses, err
+			}
+			if inUse == "" {
+				break
+			}
+			if conflicts == maxBackendConflicts {
+				addressClaim.Status.ErrorMessage = pointer.StringPtr("Too many addresses in use in the backend")
+				return addresses, errors.New(fmt.Sprintf(
+					"%d addresses found in use in the backend", conflicts+1,
+				))
+			}
+			taken[inUse] = "backend"
+			allocations, err = m.allocateAddressSet(addressClaim, missingRoles, taken)
+			if err != nil {
+				return addresses, err
+			}
+		}
+	}
+
+	ownerRefs := m.addressOwnerRefs(addressClaim)
+
+	// The addresses of the claim are allocated together: if one of them
+	// cannot be created, the addresses of the set allocated by this call are
+	// rolled back, not to bind a partial set to the claim
+	allocated := &partialAllocation{backend: backend, backendAnnotations: backendAnnotations}
+	for _, role := range missingRoles {
+		allocation := allocations[role]
+
+		// Set the index and IPAddress names
+		addressName := m.formatAddressName(allocation.address)
+
+		m.Log.Info("Address allocated", "Claim", addressClaim.Name, "address", allocation.address)
+
+		annotations := m.IPPool.PropagatedAnnotations(addressClaim)
+		if len(backendAnnotations[role]) != 0 && annotations == nil {
+			annotations = make(map[string]string)
+		}
+		for key, value := range backendAnnotations[role] {
+			annotations[key] = value
+		}
+
+		labels := make(map[string]string, len(addressClaim.Labels)+2)
+		for key, value := range addressClaim.Labels {
+			labels[key] = value
+		}
+		if role != "" {
+			labels[ipamv1.AddressRoleLabel] = role
+		}
+		if m.IPPool.Spec.ClusterName != nil {
+			labels[capi.ClusterLabelName] = *m.IPPool.Spec.ClusterName
+		}
+
+		// Create the IPAddress object, with an Owner ref to the Metal3Machine
+		// (curOwnerRef) and to the IPPool
+		addressObject := &ipamv1.IPAddress{
+			TypeMeta: metav1.TypeMeta{
+				Kind:       "IPAddress",
+				APIVersion: ipamv1.GroupVersion.String(),
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            addressName,
+				Namespace:       m.addressNamespace(addressClaim.Namespace),
+				OwnerReferences: ownerRefs,
+				Labels:          labels,
+				Annotations:     annotations,
+			},
+			Spec: ipamv1.IPAddressSpec{
+				Address: allocation.address,
+				Pool:    m.poolRef(),
+				Claim: corev1.ObjectReference{
+					Name:      addressClaim.Name,
+					Namespace: addressClaim.Namespace,
+				},
+				Prefix:          allocation.prefix,
+				DelegatedPrefix: allocation.delegatedPrefix,
+				Gateway:         allocation.gateway,
+				DNSServers:      allocation.dnsServers,
+				SearchDomains:   allocation.searchDomains,
+				NTPServers:      allocation.ntpServers,
+				DomainName:      allocation.domainName,
+			},
+		}
+
+		// Reserve the address, so that the concurrent reconciles of the pool
+		// do not allocate it until its IPAddress is in their cache
+		key := addressKey(claimKey, role)
+		if m.reservations != nil {
+			if owner, ok := m.reservations.reserve(poolName(m.IPPool), allocation.address, key); !ok {
+				m.Log.Info("Address reserved by a concurrent allocation", "Claim", addressClaim.Name,
+					"address", allocation.address, "owner", owner,
+				)
+				m.rollbackAllocation(ctx, addressClaim, missingRoles, allocations, allocated)
+				return addresses, &RequeueAfterError{}
+			}
+			allocated.reserved = append(allocated.reserved, allocation.address)
+		}
+
+		// Create the IPAddress object, the deterministic name making its
+		// creation the linearization point of the allocations of an address.
+		// If we get a conflict (that will set HasRequeueAfterError), the
+		// IPAddress is either the one of the claim, created by a previous
+		// reconcile and not in the cache yet, or the one of another claim. In
+		// that case requeue to retrigger the reconciliation with the new state
+		if err := createObject(m.client, ctx, addressObject); err != nil {
+			if _, ok := err.(*RequeueAfterError); !ok {
+				addressClaim.Status.ErrorMessage = pointer.StringPtr("Failed to create associated IPAddress object")
+				m.rollbackAllocation(ctx, addressClaim, missingRoles, allocations, allocated)
+				return addresses, err
+			}
+			if !m.isExistingClaimAddress(ctx, addressObject) {
+				m.rollbackAllocation(ctx, addressClaim, missingRoles, allocations, allocated)
+				return addresses, err
+			}
+			m.Log.Info("IPAddress already created", "Claim", addressClaim.Name, "IPAddress", addressName)
+			allocated.existing = append(allocated.existing, role)
+			continue
+		}
+		allocated.created = append(allocated.created, addressObject)
+	}
+
+	for _, role := range missingRoles {
+		address := allocations[role].address
+		key := addressKey(claimKey, role)
+		m.IPPool.Status.Allocations[key] = address
+		addresses[address] = key
+		m.markAddress(address, true)
+		delete(m.IPPool.Status.RetainedAddresses, key)
+		delete(m.IPPool.Status.ReleasedAddresses, string(address))
+	}
+
+	if meta.FindStatusCondition(addressClaim.Status.Conditions, ipamv1.IPClaimBindingHeldCondition) != nil {
+		meta.SetStatusCondition(&addressClaim.Status.Conditions, metav1.Condition{
+			Type:   ipamv1.IPClaimBindingHeldCondition,
+			Status: metav1.ConditionFalse,
+			Reason: "HoldsRemoved",
+		})
+	}
+	m.setClaimAddresses(addressClaim, claimKey)
+
+	return addresses, nil
+}
+
+// partialAllocation records what an allocation of the addresses of a claim
+// did before failing, to roll it back
+type partialAllocation struct {
+	// created are the IPAddresses created
+	created []*ipamv1.IPAddress
+	// reserved are the addresses reserved for the concurrent reconciles
+	reserved []ipamv1.IPAddressStr
+	// existing are the roles whose IPAddress was created by a previous
+	// reconcile, kept since the next reconcile completes the set with them
+	existing []string
+	// backend and backendAnnotations are the backend of the pool, if any,
+	// and the reservations of the addresses of each role in it
+	backend            Backend
+	backendAnnotations map[string]map[string]string
+}
+
+// rollbackAllocation deletes the IPAddresses created by an allocation that
+// failed partway, and releases its reservations and the reservations of the
+// addresses in the backend. The failures are only logged, the error of the
+// allocation being returned.
+func (m *IPPoolManager) rollbackAllocation(ctx context.Context, addressClaim *ipamv1.IPClaim,
+	roles []string, allocations map[string]addressAllocation, allocated *partialAllocation,
+) {
+	for _, addressObject := range allocated.created {
+		if err := deleteObject(m.client, ctx, addressObject); err != nil {
+			m.Log.Info("Unable to delete the IPAddress of a partial allocation", "Claim", addressClaim.Name,
+				"IPAddress", addressObject.Name, "Error", err.Error(),
+			)
+		}
+	}
+	for _, address := range allocated.reserved {
+		m.releaseReservation(address)
+	}
+	if allocated.backend == nil {
+		return
+	}
+	for _, role := range roles {
+		if Contains(allocated.existing, role) || allocated.backendAnnotations[role] == nil {
+			continue
+		}
+		allocation := allocations[role]
+		reservation := &ipamv1.IPAddress{
+			ObjectMeta: metav1.ObjectMeta{Annotations: allocated.backendAnnotations[role]},
+			Spec:       ipamv1.IPAddressSpec{Prefix: allocation.prefix},
+		}
+		if err := m.releaseBackendAddress(ctx, allocated.backend, addressClaim, role,
+			allocation.address, reservation,
+		); err != nil {
+			m.Log.Info("Unable to release the address of a partial allocation", "Claim", addressClaim.Name,
+				"address", allocation.address, "Error", err.Error(),
+			)
+		}
+	}
+	m.Log.Info("Partial allocation rolled back", "Claim", addressClaim.Name,
+		"deleted", len(allocated.created),
+	)
+}
+
+// isExistingClaimAddress returns true if the existing IPAddress with the name
+// of the given one holds the same address for the same claim and role
+func (m *IPPoolManager) isExistingClaimAddress(ctx context.Context, addressObject *ipamv1.IPAddress) bool {
+	existing := &ipamv1.IPAddress{}
+	if err := m.client.Get(ctx, client.ObjectKeyFromObject(addressObject), existing); err != nil {
+		return false
+	}
+	return m.isPoolAddress(existing) && existing.DeletionTimestamp.IsZero() &&
+		existing.Spec.Address == addressObject.Spec.Address &&
+		m.allocationKey(existing.Spec.Claim.Name, existing.Spec.Claim.Namespace) ==
+			m.allocationKey(addressObject.Spec.Claim.Name, addressObject.Spec.Claim.Namespace) &&
+		existing.Labels[ipamv1.AddressRoleLabel] == addressObject.Labels[ipamv1.AddressRoleLabel]
+}
+
+// releaseReservation forgets the reservation of the address, if any
+func (m *IPPoolManager) releaseReservation(address ipamv1.IPAddressStr) {
+	if m.reservations != nil {
+		m.reservations.release(poolName(m.IPPool), address)
+	}
+}
+
+// addressOwnerRefs returns the owner references of the IPAddresses of the
+// claim: the owners of the claim, the pool and the claim
+func (m *IPPoolManager) addressOwnerRefs(addressClaim *ipamv1.IPClaim) []metav1.OwnerReference {
+	ownerRefs := []metav1.OwnerReference{}
+	// Owner references can not cross namespaces, a claim from another
+	// namespace only keeps its IPAddress through its finalizer.
+	if addressClaim.Namespace == m.addressNamespace(addressClaim.Namespace) {
+		ownerRefs = append(ownerRefs, addressClaim.OwnerReferences...)
+	}
+	ownerRefs = append(ownerRefs, m.poolOwnerRef())
+	if addressClaim.Namespace == m.addressNamespace(addressClaim.Namespace) {
+		ownerRefs = append(ownerRefs,
+			metav1.OwnerReference{
+				APIVersion: addressClaim.APIVersion,
+				Kind:       addressClaim.Kind,
+				Name:       addressClaim.Name,
+				UID:        addressClaim.UID,
+			},
+		)
+	}
+	return ownerRefs
+}
+
+// poolOwnerRef returns the owner reference of the IPAddresses to the pool
+func (m *IPPoolManager) poolOwnerRef() metav1.OwnerReference {
+	return metav1.OwnerReference{
+		APIVersion: m.IPPool.APIVersion,
+		Kind:       m.IPPool.Kind,
+		Name:       m.IPPool.Name,
+		UID:        m.IPPool.UID,
+	}
+}
+
+// applyAddressMetadata writes the labels and annotations of an IPAddress set
+// by the controller for a concern, with a server-side apply as the field
+// manager of the concern if enabled, so that the labels and annotations of
+// the other actors are left to them. Each concern has its field manager,
+// owning the labels and annotations it applies, since an apply removes the
+// fields of its field manager that it does not set. The IPAddress is updated
+// otherwise, with the labels and annotations already set.
+func (m *IPPoolManager) applyAddressMetadata(ctx context.Context, addressObject *ipamv1.IPAddress,
+	concern string, labels, annotations map[string]string,
+) error {
+	if !m.ServerSideApply {
+		return updateObject(m.client, ctx, addressObject)
+	}
+	metadata := map[string]interface{}{
+		"name":      addressObject.Name,
+		"namespace": addressObject.Namespace,
+	}
+	if len(labels) != 0 {
+		metadata["labels"] = labels
+	}
+	if len(annotations) != 0 {
+		metadata["annotations"] = annotations
+	}
+	applied := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": ipamv1.GroupVersion.String(),
+		"kind":       "IPAddress",
+		"metadata":   metadata,
+	}}
+	return applyObject(m.client, ctx, applied, FieldManager+"-"+concern)
+}
+
+// retainsAddresses returns true if the IPAddresses of the deleted claims are
+// retained. A pool being deleted retains no IPAddress.
+func (m *IPPoolManager) retainsAddresses() bool {
+	return m.IPPool.Spec.ReclaimPolicy == ipamv1.ReclaimPolicyRetain &&
+		m.IPPool.DeletionTimestamp.IsZero()
+}
+
+// retainClaimAddresses keeps the IPAddresses of a deleted claim, labelled as
+// retained and only owned by the pool, so that they are not garbage collected
+// with the claim. They stay allocated to the claim.
+func (m *IPPoolManager) retainClaimAddresses(ctx context.Context,
+	addressClaim *ipamv1.IPClaim, allocationKeys []string,
+) error {
+	for _, key := range allocationKeys {
+		addressObject := &ipamv1.IPAddress{}
+		objectKey := client.ObjectKey{
+			Name:      m.formatAddressName(m.IPPool.Status.Allocations[key]),
+			Namespace: m.addressNamespace(addressClaim.Namespace),
+		}
+		if err := m.client.Get(ctx, objectKey, addressObject); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			addressClaim.Status.ErrorMessage = pointer.StringPtr("Failed to get associated IPAddress object")
+			return err
+		}
+		if addressObject.Labels == nil {
+			addressObject.Labels = make(map[string]string)
+		}
+		addressObject.Labels[ipamv1.RetainedAddressLabel] = "true"
+		addressObject.OwnerReferences = []metav1.OwnerReference{m.poolOwnerRef()}
+		// The owner reference of the claim is removed
+		if err := updateObject(m.client, ctx, addressObject); err != nil {
+			addressClaim.Status.ErrorMessage = pointer.StringPtr("Failed to update associated IPAddress object")
+			return err
+		}
+		m.Log.Info("Address retained", "Claim", addressClaim.Name, "address", addressObject.Spec.Address)
+	}
+	return nil
+}
+
+// adoptRetainedAddresses binds the IPAddresses retained for a deleted claim to
+// the claim recreated with the same namespace and name
+func (m *IPPoolManager) adoptRetainedAddresses(ctx context.Context,
+	addressClaim *ipamv1.IPClaim, claimKey string,
+) error {
+	for _, role := range m.addressRoles(addressClaim) {
+		addressObject := &ipamv1.IPAddress{}
+		objectKey := client.ObjectKey{
+			Name:      m.formatAddressName(m.IPPool.Status.Allocations[addressKey(claimKey, role)]),
+			Namespace: m.addressNamespace(addressClaim.Namespace),
+		}
+		if err := m.client.Get(ctx, objectKey, addressObject); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			addressClaim.Status.ErrorMessage = pointer.StringPtr("Failed to get associated IPAddress object")
+			return err
+		}
+		if _, ok := addressObject.Labels[ipamv1.RetainedAddressLabel]; !ok {
+			continue
+		}
+		delete(addressObject.Labels, ipamv1.RetainedAddressLabel)
+		addressObject.OwnerReferences = m.addressOwnerRefs(addressClaim)
+		// The retained label is removed
+		if err := updateObject(m.client, ctx, addressObject); err != nil {
+			addressClaim.Status.ErrorMessage = pointer.StringPtr("Failed to update associated IPAddress object")
+			return err
+		}
+		m.Log.Info("Retained address adopted", "Claim", addressClaim.Name, "address", addressObject.Spec.Address)
+	}
+	return nil
+}
+
+// setBindingHeldCondition reports the addresses proposed to a claim whose
+// binding is held, and the holds
+func (m *IPPoolManager) setBindingHeldCondition(addressClaim *ipamv1.IPClaim,
+	roles []string, allocations map[string]addressAllocation, holds []string,
+) {
+	proposed := make([]string, 0, len(roles))
+	for _, role := range roles {
+		if role == "" {
+			proposed = append(proposed, string(allocations[role].address))
+		} else {
+			proposed = append(proposed, role+" "+string(allocations[role].address))
+		}
+	}
+	message := fmt.Sprintf("Proposed %s, held by %s",
+		strings.Join(proposed, ", "), strings.Join(holds, ", "),
+	)
+	m.Log.Info("Claim binding held", "IPClaim", addressClaim.Name, "message", message)
+	meta.SetStatusCondition(&addressClaim.Status.Conditions, metav1.Condition{
+		Type:    ipamv1.IPClaimBindingHeldCondition,
+		Status:  metav1.ConditionTrue,
+		Reason:  "HoldAnnotations",
+		Message: message,
+	})
+}
+
+// missingClusterLabel returns true if the pool belongs to a cluster and the
+// labels do not contain the cluster name label
+func (m *IPPoolManager) missingClusterLabel(labels map[string]string) bool {
+	if m.IPPool.Spec.ClusterName == nil {
+		return false
+	}
+	return labels[capi.ClusterLabelName] != *m.IPPool.Spec.ClusterName
+}
+
+// setClusterLabel sets the cluster name label of the pool on the object, so
+// that the IPAM objects are seen as part of the cluster. It returns true if
+// the labels were modified.
+func (m *IPPoolManager) setClusterLabel(objMeta *metav1.ObjectMeta) bool {
+	if !m.missingClusterLabel(objMeta.Labels) {
+		return false
+	}
+	if objMeta.Labels == nil {
+		objMeta.Labels = make(map[string]string)
+	}
+	objMeta.Labels[capi.ClusterLabelName] = *m.IPPool.Spec.ClusterName
+	return true
+}
+
+// propagateClaimMetadata copies the labels of the claim and its propagated
+// annotations to the imported IPAddresses of the claim. It returns false if
+// an IPAddress is not found under the name the controller would give it.
+func (m *IPPoolManager) propagateClaimMetadata(ctx context.Context,
+	addressClaim *ipamv1.IPClaim, claimKey string,
+) (bool, error) {
+	for _, role := range m.addressRoles(addressClaim) {
+		addressName := m.formatAddressName(m.IPPool.Status.Allocations[addressKey(claimKey, role)])
+		addressObject := &ipamv1.IPAddress{}
+		err := m.client.Get(ctx, client.ObjectKey{
+			Name: addressName, Namespace: m.addressNamespace(addressClaim.Namespace),
+		}, addressObject)
+		if apierrors.IsNotFound(err) {
+			addressClaim.Status.ErrorMessage = pointer.StringPtr(
+				fmt.Sprintf("Imported IPAddress not named %s", addressName),
+			)
+			return false, nil
+		}
+		if err != nil {
+			addressClaim.Status.ErrorMessage = pointer.StringPtr("Failed to get associated IPAddress object")
+			return false, err
+		}
+
+		updated := false
+		for key, value := range addressClaim.Labels {
+			if current, ok := addressObject.Labels[key]; !ok || current != value {
+				if addressObject.Labels == nil {
+					addressObject.Labels = make(map[string]string)
+				}
+				addressObject.Labels[key] = value
+				updated = true
+			}
+		}
+		for key, value := range m.IPPool.PropagatedAnnotations(addressClaim) {
+			if current, ok := addressObject.Annotations[key]; !ok || current != value {
+				if addressObject.Annotations == nil {
+					addressObject.Annotations = make(map[string]string)
+				}
+				addressObject.Annotations[key] = value
+				updated = true
+			}
+		}
+		if !updated {
+			continue
+		}
+		if err := m.applyAddressMetadata(ctx, addressObject, "metadata",
+			addressClaim.Labels, m.IPPool.PropagatedAnnotations(addressClaim),
+		); err != nil {
+			addressClaim.Status.ErrorMessage = pointer.StringPtr("Failed to update associated IPAddress object")
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// setClaimAddresses sets the references to the IPAddresses of the claim in
+// its status
+func (m *IPPoolManager) setClaimAddresses(addressClaim *ipamv1.IPClaim, claimKey string) {
+	addressClaim.Status.Address = nil
+	addressClaim.Status.Addresses = nil
+	addressClaim.Status.Pool = nil
+	for _, role := range m.addressRoles(addressClaim) {
+		allocatedAddress := m.IPPool.Status.Allocations[addressKey(claimKey, role)]
+		addressRef := corev1.ObjectReference{
+			Name:      m.formatAddressName(allocatedAddress),
+			Namespace: m.addressNamespace(addressClaim.Namespace),
+		}
+		if role == "" {
+			addressClaim.Status.Address = &addressRef
+			poolRef := m.poolRef()
+			addressClaim.Status.Pool = &poolRef
+			continue
+		}
+		if addressClaim.Status.Addresses == nil {
+			addressClaim.Status.Addresses = make(map[string]corev1.ObjectReference)
+		}
+		addressClaim.Status.Addresses[role] = addressRef
+	}
+}
+
+// claimAllocationKeys returns the keys of all the allocations of a claim,
+// whatever their role
+func (m *IPPoolManager) claimAllocationKeys(claimKey string) []string {
+	allocationKeys := []string{}
+	for key := range m.IPPool.Status.Allocations {
+		if key == claimKey || strings.HasPrefix(key, claimKey+roleSeparator) {
+			allocationKeys = append(allocationKeys, key)
+		}
+	}
+	return allocationKeys
+}
+
+// deleteAddress deletes the IPAddresses of a claim being deleted
+func (m *IPPoolManager) deleteAddress(ctx context.Context,
+	addressClaim *ipamv1.IPClaim, addresses map[ipamv1.IPAddressStr]string,
+) (map[ipamv1.IPAddressStr]string, error) {
+
+	m.Log.Info("Deleting Claim", "IPClaim", addressClaim.Name)
+
+	claimKey := m.allocationKey(addressClaim.Name, addressClaim.Namespace)
+
+	// The imported IPAddresses of an externally managed pool are kept
+	allocationKeys := []string{}
+	if !m.IPPool.Spec.ExternallyManaged {
+		allocationKeys = m.claimAllocationKeys(claimKey)
+	}
+
+	// The IPAddresses of a deleted claim are kept if the pool retains them
+	if m.retainsAddresses() && !addressClaim.DeletionTimestamp.IsZero() {
+		if err := m.retainClaimAddresses(ctx, addressClaim, allocationKeys); err != nil {
+			return addresses, err
+		}
+		addressClaim.Status.Address = nil
+		addressClaim.Status.Addresses = nil
+		addressClaim.Status.Pool = nil
+		addressClaim.Finalizers = Filter(addressClaim.Finalizers,
+			ipamv1.IPClaimFinalizer,
+		)
+		m.Log.Info("Retained the addresses of the Claim", "IPClaim", addressClaim.Name)
+		m.updateStatusTimestamp()
+		return addresses, nil
+	}
+
+	// The addresses are only released once the release hook succeeded, and
+	// they were released from the backend
+	deregistrationPending := false
+	var deregistrationErr error
+	deregistrationReason := ""
+	backend, err := m.getBackend(ctx)
+	if err != nil {
+		addressClaim.Status.ErrorMessage = pointer.StringPtr("Failed to reach the backend")
+		return addresses, err
+	}
+	for _, key := range allocationKeys {
+		allocatedAddress := m.IPPool.Status.Allocations[key]
+		// Try to get the IPAddress. if it succeeds, delete it
+		tmpM3Data := &ipamv1.IPAddress{}
+		objectKey := client.ObjectKey{
+			Name:      m.formatAddressName(allocatedAddress),
+			Namespace: m.addressNamespace(addressClaim.Namespace),
+		}
+		err := m.client.Get(ctx, objectKey, tmpM3Data)
+		if err != nil && !apierrors.IsNotFound(err) {
+			addressClaim.Status.ErrorMessage = pointer.StringPtr("Failed to get associated IPAddress object")
+			return addresses, err
+		}
+		if m.IPPool.Spec.ReleaseHook != nil {
+			addressObject := tmpM3Data
+			if err != nil {
+				addressObject = nil
+			}
+			role := strings.TrimPrefix(strings.TrimPrefix(key, claimKey), roleSeparator)
+			deregistered, hookErr := m.deregisterAddress(ctx,
+				m.newReleasedAddress(addressClaim, role, allocatedAddress, addressObject),
+			)
+			if hookErr != nil {
+				deregistrationErr = hookErr
+				deregistrationReason = "ReleaseHookFailed"
+			}
+			if !deregistered || hookErr != nil {
+				deregistrationPending = true
+				continue
+			}
+		}
+		if backend != nil {
+			addressObject := tmpM3Data
+			if err != nil {
+				addressObject = nil
+			}
+			role := strings.TrimPrefix(strings.TrimPrefix(key, claimKey), roleSeparator)
+			if backendErr := m.releaseBackendAddress(ctx, backend, addressClaim,
+				role, allocatedAddress, addressObject,
+			); backendErr != nil {
+				deregistrationErr = backendErr
+				deregistrationReason = "BackendReleaseFailed"
+				deregistrationPending = true
+				continue
+			}
+		}
+		if err == nil {
+			// Delete the IPAddress
+			err = deleteObject(m.client, ctx, tmpM3Data)
+			if err != nil {
+				addressClaim.Status.ErrorMessage = pointer.StringPtr("Failed to delete associated IPAddress object")
+				return addresses, err
+			}
+		}
+		m.releaseReservation(allocatedAddress)
+
+		if _, ok := m.IPPool.Spec.PreAllocations[key]; !ok && !m.isMACReserved(allocatedAddress) {
 			delete(addresses, allocatedAddress)
+			m.markAddress(allocatedAddress, false)
+			m.retainAddress(addressClaim, key, allocatedAddress, addresses)
+			m.quarantineAddress(allocatedAddress, addresses)
+			m.recordRelease(allocatedAddress)
 		}
-		delete(m.IPPool.Status.Allocations, addressClaim.Name)
+		delete(m.IPPool.Status.Allocations, key)
 	}
+	if deregistrationErr != nil || !deregistrationPending {
+		m.setDeregistrationCondition(addressClaim, deregistrationReason, deregistrationErr)
+	}
+	if deregistrationPending {
+		m.releasePending = true
+		m.updateStatusTimestamp()
+		return addresses, nil
+	}
+	addressClaim.Status.Address = nil
+	addressClaim.Status.Addresses = nil
+	addressClaim.Status.Pool = nil
+	addressClaim.Finalizers = Filter(addressClaim.Finalizers,
+		ipamv1.IPClaimFinalizer,
+	)
+
+	m.Log.Info("Deleted Claim", "IPClaim", addressClaim.Name)
+	if !addressClaim.DeletionTimestamp.IsZero() {
+		addressReleaseDuration.WithLabelValues(
+			m.IPPool.Namespace, m.IPPool.Name,
+		).Observe(time.Since(addressClaim.DeletionTimestamp.Time).Seconds())
+	}
+
 	m.updateStatusTimestamp()
 	return addresses, nil
 }
 
+// roleSeparator separates the claim key and the role in the allocation keys,
+// it can not be part of an object name
+const roleSeparator = ":"
+
+// addressKey returns the key of the address of the given role of a claim in
+// the allocations
+func addressKey(claimKey, role string) string {
+	if role == "" {
+		return claimKey
+	}
+	return claimKey + roleSeparator + role
+}
+
 // formatAddressName renders the name of the IPAddress objects
 func (m *IPPoolManager) formatAddressName(address ipamv1.IPAddressStr) string {
 	return strings.TrimRight(m.IPPool.Spec.NamePrefix+"-"+strings.Replace(
@@ -19,15 +19,25 @@ package ipam
 import (
 	"context"
 	"fmt"
+	"math"
 	"reflect"
+	"strings"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 
 	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	"github.com/metal3-io/ip-address-manager/feature"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2/klogr"
 	"k8s.io/utils/pointer"
 	capi "sigs.k8s.io/cluster-api/api/v1alpha4"
@@ -155,6 +165,10 @@ var _ = Describe("IPPool manager", func() {
 		expectError         bool
 		expectedAddresses   map[ipamv1.IPAddressStr]string
 		expectedAllocations map[string]ipamv1.IPAddressStr
+		expectClusterLabel  bool
+		expectAdopted       []string
+		expectedConflicts   string
+		expectedOutOfRange  string
 	}
 
 	DescribeTable("Test getIndexes",
@@ -188,6 +202,54 @@ var _ = Describe("IPPool manager", func() {
 				Expect(tc.ipPool.Status.LastUpdated.IsZero()).To(BeTrue())
 			}
 
+			for _, name := range tc.expectAdopted {
+				address := &ipamv1.IPAddress{}
+				err = c.Get(context.TODO(), client.ObjectKey{Name: name, Namespace: "myns"}, address)
+				Expect(err).NotTo(HaveOccurred())
+				poolOwners := []string{}
+				for _, ownerRef := range address.OwnerReferences {
+					if ownerRef.Kind == "IPPool" {
+						poolOwners = append(poolOwners, ownerRef.Name)
+					}
+				}
+				Expect(poolOwners).To(Equal([]string{tc.ipPool.Name}))
+			}
+
+			condition := meta.FindStatusCondition(tc.ipPool.Status.Conditions,
+				ipamv1.PreAllocationConflictCondition,
+			)
+			if tc.expectedConflicts != "" {
+				Expect(condition).NotTo(BeNil())
+				Expect(condition.Status).To(Equal(metav1.ConditionTrue))
+				Expect(condition.Message).To(Equal(tc.expectedConflicts))
+			} else if condition != nil {
+				Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+			}
+
+			condition = meta.FindStatusCondition(tc.ipPool.Status.Conditions,
+				ipamv1.IPPoolOutOfRangeAllocationsCondition,
+			)
+			if tc.expectedOutOfRange != "" {
+				Expect(condition).NotTo(BeNil())
+				Expect(condition.Status).To(Equal(metav1.ConditionTrue))
+				Expect(condition.Message).To(Equal(tc.expectedOutOfRange))
+			} else if condition != nil {
+				Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+			}
+
+			if tc.expectClusterLabel {
+				addressObjects := ipamv1.IPAddressList{}
+				err = c.List(context.TODO(), &addressObjects)
+				Expect(err).NotTo(HaveOccurred())
+				for _, address := range addressObjects.Items {
+					if address.Spec.Pool.Name != tc.ipPool.Name {
+						continue
+					}
+					Expect(address.Labels).To(HaveKeyWithValue(
+						capi.ClusterLabelName, *tc.ipPool.Spec.ClusterName,
+					))
+				}
+			}
 		},
 		Entry("No addresses", testGetIndexes{
 			ipPool:              &ipamv1.IPPool{},
@@ -265,6 +327,241 @@ var _ = Describe("IPPool manager", func() {
 				"abc": ipamv1.IPAddressStr("abcd1"),
 			},
 		}),
+		Entry("addresses adopted by the cluster", testGetIndexes{
+			ipPool: &ipamv1.IPPool{
+				ObjectMeta: testObjectMeta,
+				Spec: ipamv1.IPPoolSpec{
+					ClusterName: pointer.StringPtr("abc-cluster"),
+				},
+			},
+			addresses: []*ipamv1.IPAddress{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "abc-0",
+						Namespace: "myns",
+					},
+					Spec: ipamv1.IPAddressSpec{
+						Address: "abcd1",
+						Pool:    *testObjectReference,
+						Claim:   *testObjectReference,
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "abc-1",
+						Namespace: "myns",
+						Labels: map[string]string{
+							capi.ClusterLabelName: "abc-cluster",
+						},
+					},
+					Spec: ipamv1.IPAddressSpec{
+						Address: "abcd2",
+						Pool:    *testObjectReference,
+						Claim: corev1.ObjectReference{
+							Name:      "bcd",
+							Namespace: "myns",
+						},
+					},
+				},
+			},
+			expectedAddresses: map[ipamv1.IPAddressStr]string{
+				ipamv1.IPAddressStr("abcd1"): "abc",
+				ipamv1.IPAddressStr("abcd2"): "bcd",
+			},
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"abc": ipamv1.IPAddressStr("abcd1"),
+				"bcd": ipamv1.IPAddressStr("abcd2"),
+			},
+			expectClusterLabel: true,
+		}),
+		Entry("addresses of a former name", testGetIndexes{
+			ipPool: &ipamv1.IPPool{
+				TypeMeta: metav1.TypeMeta{
+					Kind:       "IPPool",
+					APIVersion: ipamv1.GroupVersion.String(),
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "abc",
+					Namespace: "myns",
+					Annotations: map[string]string{
+						ipamv1.RenamedFromAnnotation: "old",
+					},
+				},
+			},
+			addresses: []*ipamv1.IPAddress{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "old-0",
+						Namespace: "myns",
+						OwnerReferences: []metav1.OwnerReference{
+							{
+								APIVersion: ipamv1.GroupVersion.String(),
+								Kind:       "IPPool",
+								Name:       "old",
+							},
+						},
+					},
+					Spec: ipamv1.IPAddressSpec{
+						Address: "abcd1",
+						Pool: corev1.ObjectReference{
+							Name:      "old",
+							Namespace: "myns",
+						},
+						Claim: *testObjectReference,
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "other-0",
+						Namespace: "myns",
+					},
+					Spec: ipamv1.IPAddressSpec{
+						Address: "abcd2",
+						Pool: corev1.ObjectReference{
+							Name:      "other",
+							Namespace: "myns",
+						},
+						Claim: corev1.ObjectReference{
+							Name:      "bcd",
+							Namespace: "myns",
+						},
+					},
+				},
+			},
+			expectedAddresses: map[ipamv1.IPAddressStr]string{
+				ipamv1.IPAddressStr("abcd1"): "abc",
+			},
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"abc": ipamv1.IPAddressStr("abcd1"),
+			},
+			expectAdopted: []string{"old-0"},
+		}),
+		Entry("pre-allocation conflicts", testGetIndexes{
+			ipPool: &ipamv1.IPPool{
+				ObjectMeta: testObjectMeta,
+				Spec: ipamv1.IPPoolSpec{
+					PreAllocations: map[string]ipamv1.IPAddressStr{
+						"abc": ipamv1.IPAddressStr("abcd1"),
+						"bcd": ipamv1.IPAddressStr("abcd1"),
+						"cde": ipamv1.IPAddressStr("abcd3"),
+					},
+				},
+				Status: ipamv1.IPPoolStatus{
+					Conditions: []metav1.Condition{
+						{
+							Type:   ipamv1.PreAllocationConflictCondition,
+							Status: metav1.ConditionFalse,
+							Reason: "NoConflict",
+						},
+					},
+				},
+			},
+			addresses: []*ipamv1.IPAddress{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "abc-0",
+						Namespace: "myns",
+					},
+					Spec: ipamv1.IPAddressSpec{
+						Address: "abcd1",
+						Pool:    *testObjectReference,
+						Claim:   *testObjectReference,
+					},
+				},
+			},
+			expectedAddresses: map[ipamv1.IPAddressStr]string{
+				ipamv1.IPAddressStr("abcd1"): "abc",
+				ipamv1.IPAddressStr("abcd3"): "",
+			},
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"abc": ipamv1.IPAddressStr("abcd1"),
+			},
+			expectedConflicts: "bcd: abcd1 is allocated to abc",
+		}),
+		Entry("pre-allocation conflicts resolved", testGetIndexes{
+			ipPool: &ipamv1.IPPool{
+				ObjectMeta: testObjectMeta,
+				Spec: ipamv1.IPPoolSpec{
+					PreAllocations: map[string]ipamv1.IPAddressStr{
+						"abc": ipamv1.IPAddressStr("abcd1"),
+					},
+				},
+				Status: ipamv1.IPPoolStatus{
+					Conditions: []metav1.Condition{
+						{
+							Type:    ipamv1.PreAllocationConflictCondition,
+							Status:  metav1.ConditionTrue,
+							Reason:  "AddressAllocated",
+							Message: "bcd: abcd1 is allocated to abc",
+						},
+					},
+				},
+			},
+			expectedAddresses: map[ipamv1.IPAddressStr]string{
+				ipamv1.IPAddressStr("abcd1"): "",
+			},
+			expectedAllocations: map[string]ipamv1.IPAddressStr{},
+		}),
+		Entry("allocations out of range", testGetIndexes{
+			ipPool: &ipamv1.IPPool{
+				ObjectMeta: testObjectMeta,
+				Spec: ipamv1.IPPoolSpec{
+					Pools: []ipamv1.Pool{
+						{
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.20")),
+						},
+					},
+				},
+			},
+			addresses: []*ipamv1.IPAddress{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "abc-0",
+						Namespace: "myns",
+					},
+					Spec: ipamv1.IPAddressSpec{
+						Address: "192.168.0.30",
+						Pool:    *testObjectReference,
+						Claim:   *testObjectReference,
+					},
+				},
+			},
+			expectedAddresses: map[ipamv1.IPAddressStr]string{
+				ipamv1.IPAddressStr("192.168.0.30"): "abc",
+			},
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"abc": ipamv1.IPAddressStr("192.168.0.30"),
+			},
+			expectedOutOfRange: "addresses out of the pools are allocated: 192.168.0.30 (abc)",
+		}),
+	)
+
+	DescribeTable("Test setClusterLabel",
+		func(clusterName *string, labels map[string]string, expectedLabels map[string]string, expectModified bool) {
+			ipPoolMgr, err := NewIPPoolManager(nil, &ipamv1.IPPool{
+				Spec: ipamv1.IPPoolSpec{
+					ClusterName: clusterName,
+				},
+			}, klogr.New())
+			Expect(err).NotTo(HaveOccurred())
+
+			objMeta := metav1.ObjectMeta{Labels: labels}
+			Expect(ipPoolMgr.setClusterLabel(&objMeta)).To(Equal(expectModified))
+			Expect(objMeta.Labels).To(Equal(expectedLabels))
+		},
+		Entry("No cluster", nil, nil, nil, false),
+		Entry("No labels", pointer.StringPtr("abc-cluster"), nil,
+			map[string]string{capi.ClusterLabelName: "abc-cluster"}, true,
+		),
+		Entry("Other labels", pointer.StringPtr("abc-cluster"),
+			map[string]string{"foo": "bar"},
+			map[string]string{"foo": "bar", capi.ClusterLabelName: "abc-cluster"}, true,
+		),
+		Entry("Already labelled", pointer.StringPtr("abc-cluster"),
+			map[string]string{capi.ClusterLabelName: "abc-cluster"},
+			map[string]string{capi.ClusterLabelName: "abc-cluster"}, false,
+		),
 	)
 
 	var ipPoolMeta = metav1.ObjectMeta{
@@ -273,13 +570,14 @@ var _ = Describe("IPPool manager", func() {
 	}
 
 	type testCaseUpdateAddresses struct {
-		ipPool                *ipamv1.IPPool
-		ipClaims              []*ipamv1.IPClaim
-		ipAddresses           []*ipamv1.IPAddress
-		expectRequeue         bool
-		expectError           bool
-		expectedNbAllocations int
-		expectedAllocations   map[string]ipamv1.IPAddressStr
+		ipPool                 *ipamv1.IPPool
+		ipClaims               []*ipamv1.IPClaim
+		ipAddresses            []*ipamv1.IPAddress
+		expectRequeue          bool
+		expectError            bool
+		expectedNbAllocations  int
+		expectedAllocations    map[string]ipamv1.IPAddressStr
+		expectedAffinityGroups map[string]int
 	}
 
 	DescribeTable("Test UpdateAddresses",
@@ -311,6 +609,7 @@ var _ = Describe("IPPool manager", func() {
 			Expect(nbAllocations).To(Equal(tc.expectedNbAllocations))
 			Expect(tc.ipPool.Status.LastUpdated.IsZero()).To(BeFalse())
 			Expect(tc.ipPool.Status.Allocations).To(Equal(tc.expectedAllocations))
+			Expect(tc.ipPool.Status.AffinityGroups).To(Equal(tc.expectedAffinityGroups))
 
 			// get list of IPAddress objects
 			addressObjects := ipamv1.IPClaimList{}
@@ -470,289 +769,4797 @@ var _ = Describe("IPPool manager", func() {
 			},
 			expectedNbAllocations: 2,
 		}),
-	)
-
-	type testCaseCreateAddresses struct {
-		ipPool              *ipamv1.IPPool
-		ipClaim             *ipamv1.IPClaim
-		ipAddresses         []*ipamv1.IPAddress
-		addresses           map[ipamv1.IPAddressStr]string
-		expectRequeue       bool
-		expectError         bool
-		expectedIPAddresses []string
-		expectedAddresses   map[ipamv1.IPAddressStr]string
-		expectedAllocations map[string]ipamv1.IPAddressStr
-	}
-
-	DescribeTable("Test CreateAddresses",
-		func(tc testCaseCreateAddresses) {
-			objects := []client.Object{}
-			for _, address := range tc.ipAddresses {
-				objects = append(objects, address)
-			}
-			c := fakeclient.NewClientBuilder().WithScheme(setupScheme()).WithObjects(objects...).Build()
-			ipPoolMgr, err := NewIPPoolManager(c, tc.ipPool,
-				klogr.New(),
-			)
-			Expect(err).NotTo(HaveOccurred())
-
-			allocatedMap, err := ipPoolMgr.createAddress(context.TODO(), tc.ipClaim,
-				tc.addresses,
-			)
-			if tc.expectRequeue || tc.expectError {
-				Expect(err).To(HaveOccurred())
-				if tc.expectRequeue {
-					Expect(err).To(BeAssignableToTypeOf(&RequeueAfterError{}))
-				} else {
-					Expect(err).NotTo(BeAssignableToTypeOf(&RequeueAfterError{}))
-				}
-			} else {
-				Expect(err).NotTo(HaveOccurred())
-			}
-			// get list of IPAddress objects
-			addressObjects := ipamv1.IPAddressList{}
-			opts := &client.ListOptions{}
-			err = c.List(context.TODO(), &addressObjects, opts)
-			Expect(err).NotTo(HaveOccurred())
-
-			Expect(len(tc.expectedIPAddresses)).To(Equal(len(addressObjects.Items)))
-			// Iterate over the IPAddress objects to find all indexes and objects
-			for _, address := range addressObjects.Items {
-				Expect(tc.expectedIPAddresses).To(ContainElement(address.Name))
-				// TODO add further testing later
-			}
-			Expect(len(tc.ipClaim.Finalizers)).To(Equal(1))
-
-			Expect(allocatedMap).To(Equal(tc.expectedAddresses))
-			Expect(tc.ipPool.Status.Allocations).To(Equal(tc.expectedAllocations))
-		},
-		Entry("Already exists", testCaseCreateAddresses{
+		Entry("Claim from allowed namespace", testCaseUpdateAddresses{
 			ipPool: &ipamv1.IPPool{
 				ObjectMeta: ipPoolMeta,
-				Status: ipamv1.IPPoolStatus{
-					Allocations: map[string]ipamv1.IPAddressStr{
-						"abc": ipamv1.IPAddressStr("foo-0"),
+				Spec: ipamv1.IPPoolSpec{
+					NamePrefix:        "abcpref",
+					AllowedNamespaces: []string{"otherns"},
+					Pools: []ipamv1.Pool{
+						{
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.10")),
+						},
 					},
 				},
 			},
-			ipClaim: &ipamv1.IPClaim{
-				ObjectMeta: metav1.ObjectMeta{
-					Name: "abc",
+			ipClaims: []*ipamv1.IPClaim{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "abc",
+						Namespace: "otherns",
+					},
+					Spec: ipamv1.IPClaimSpec{
+						Pool: corev1.ObjectReference{
+							Name:      "abc",
+							Namespace: "myns",
+						},
+					},
 				},
 			},
 			expectedAllocations: map[string]ipamv1.IPAddressStr{
-				"abc": ipamv1.IPAddressStr("foo-0"),
+				"otherns/abc": ipamv1.IPAddressStr("192.168.1.10"),
 			},
+			expectedNbAllocations: 1,
 		}),
-		Entry("Not allocated yet, pre-allocated", testCaseCreateAddresses{
+		Entry("Deletion processed before allocation", testCaseUpdateAddresses{
 			ipPool: &ipamv1.IPPool{
 				ObjectMeta: ipPoolMeta,
 				Spec: ipamv1.IPPoolSpec{
+					NamePrefix: "abcpref",
 					Pools: []ipamv1.Pool{
 						{
-							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.11")),
-							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.20")),
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.13")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.13")),
 						},
 					},
-					PreAllocations: map[string]ipamv1.IPAddressStr{
-						"abc": ipamv1.IPAddressStr("192.168.0.15"),
+				},
+				Status: ipamv1.IPPoolStatus{
+					Allocations: map[string]ipamv1.IPAddressStr{
+						"abcf": ipamv1.IPAddressStr("192.168.1.13"),
+					},
+				},
+			},
+			ipClaims: []*ipamv1.IPClaim{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "abca",
+						Namespace: "myns",
+					},
+					Spec: ipamv1.IPClaimSpec{
+						Pool: corev1.ObjectReference{
+							Name:      "abc",
+							Namespace: "myns",
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:              "abcf",
+						Namespace:         "myns",
+						DeletionTimestamp: &timeNow,
+					},
+					Spec: ipamv1.IPClaimSpec{
+						Pool: corev1.ObjectReference{
+							Name:      "abc",
+							Namespace: "myns",
+						},
+					},
+					Status: ipamv1.IPClaimStatus{
+						Address: &corev1.ObjectReference{
+							Name:      "abcpref-192-168-1-13",
+							Namespace: "myns",
+						},
+					},
+				},
+			},
+			ipAddresses: []*ipamv1.IPAddress{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "abcpref-192-168-1-13",
+						Namespace: "myns",
 					},
+					Spec: ipamv1.IPAddressSpec{
+						Pool: corev1.ObjectReference{
+							Name:      "abc",
+							Namespace: "myns",
+						},
+						Claim: corev1.ObjectReference{
+							Name:      "abcf",
+							Namespace: "myns",
+						},
+						Address: ipamv1.IPAddressStr("192.168.1.13"),
+					},
+				},
+			},
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"abca": ipamv1.IPAddressStr("192.168.1.13"),
+			},
+			expectedNbAllocations: 1,
+		}),
+		Entry("Claims in an affinity group", testCaseUpdateAddresses{
+			ipPool: &ipamv1.IPPool{
+				ObjectMeta: ipPoolMeta,
+				Spec: ipamv1.IPPoolSpec{
 					NamePrefix: "abcpref",
+					Pools: []ipamv1.Pool{
+						{
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.20")),
+						},
+						{
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.10")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.20")),
+						},
+					},
 				},
 				Status: ipamv1.IPPoolStatus{
-					Allocations: map[string]ipamv1.IPAddressStr{},
+					AffinityGroups: map[string]int{
+						"storage": 1,
+						"old":     0,
+					},
 				},
 			},
-			addresses: map[ipamv1.IPAddressStr]string{},
-			ipClaim: &ipamv1.IPClaim{
-				ObjectMeta: metav1.ObjectMeta{
-					Name: "abc",
+			ipClaims: []*ipamv1.IPClaim{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "abc",
+						Namespace: "myns",
+					},
+					Spec: ipamv1.IPClaimSpec{
+						Pool: corev1.ObjectReference{
+							Name:      "abc",
+							Namespace: "myns",
+						},
+						AffinityGroup: "storage",
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "abcd",
+						Namespace: "myns",
+					},
+					Spec: ipamv1.IPClaimSpec{
+						Pool: corev1.ObjectReference{
+							Name:      "abc",
+							Namespace: "myns",
+						},
+						AffinityGroup: "storage",
+					},
 				},
 			},
 			expectedAllocations: map[string]ipamv1.IPAddressStr{
-				"abc": ipamv1.IPAddressStr("192.168.0.15"),
+				"abc":  ipamv1.IPAddressStr("192.168.1.10"),
+				"abcd": ipamv1.IPAddressStr("192.168.1.11"),
 			},
-			expectedAddresses: map[ipamv1.IPAddressStr]string{
-				ipamv1.IPAddressStr("192.168.0.15"): "abc",
+			expectedAffinityGroups: map[string]int{
+				"storage": 1,
 			},
-			expectedIPAddresses: []string{"abcpref-192-168-0-15"},
+			expectedNbAllocations: 2,
 		}),
-		Entry("Not allocated yet", testCaseCreateAddresses{
+		Entry("Claim with HA address set", testCaseUpdateAddresses{
 			ipPool: &ipamv1.IPPool{
 				ObjectMeta: ipPoolMeta,
 				Spec: ipamv1.IPPoolSpec{
+					NamePrefix: "abcpref",
 					Pools: []ipamv1.Pool{
 						{
-							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.11")),
-							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.20")),
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.10")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.20")),
+						},
+					},
+				},
+			},
+			ipClaims: []*ipamv1.IPClaim{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "abc",
+						Namespace: "myns",
+					},
+					Spec: ipamv1.IPClaimSpec{
+						Pool: corev1.ObjectReference{
+							Name:      "abc",
+							Namespace: "myns",
 						},
+						HAAddressSet: true,
 					},
+				},
+			},
+			ipAddresses: []*ipamv1.IPAddress{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "abcpref-192-168-1-10",
+						Namespace: "myns",
+						Labels: map[string]string{
+							ipamv1.AddressRoleLabel: ipamv1.HAPeerRole,
+						},
+					},
+					Spec: ipamv1.IPAddressSpec{
+						Pool: corev1.ObjectReference{
+							Name:      "abc",
+							Namespace: "myns",
+						},
+						Claim: corev1.ObjectReference{
+							Name:      "abc",
+							Namespace: "myns",
+						},
+						Address: ipamv1.IPAddressStr("192.168.1.10"),
+					},
+				},
+			},
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"abc:peer": ipamv1.IPAddressStr("192.168.1.10"),
+				"abc":      ipamv1.IPAddressStr("192.168.1.11"),
+				"abc:vip":  ipamv1.IPAddressStr("192.168.1.12"),
+			},
+			expectedNbAllocations: 3,
+		}),
+		Entry("Claim with an address count", testCaseUpdateAddresses{
+			ipPool: &ipamv1.IPPool{
+				ObjectMeta: ipPoolMeta,
+				Spec: ipamv1.IPPoolSpec{
 					NamePrefix: "abcpref",
+					Pools: []ipamv1.Pool{
+						{
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.10")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.20")),
+						},
+					},
 				},
-				Status: ipamv1.IPPoolStatus{
-					Allocations: map[string]ipamv1.IPAddressStr{},
+			},
+			ipClaims: []*ipamv1.IPClaim{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "abc",
+						Namespace: "myns",
+					},
+					Spec: ipamv1.IPClaimSpec{
+						Pool: corev1.ObjectReference{
+							Name:      "abc",
+							Namespace: "myns",
+						},
+						AddressCount: pointer.IntPtr(3),
+					},
 				},
 			},
-			addresses: map[ipamv1.IPAddressStr]string{
-				ipamv1.IPAddressStr("192.168.0.11"): "bcd",
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"abc":           ipamv1.IPAddressStr("192.168.1.10"),
+				"abc:address-1": ipamv1.IPAddressStr("192.168.1.11"),
+				"abc:address-2": ipamv1.IPAddressStr("192.168.1.12"),
+			},
+			expectedNbAllocations: 3,
+		}),
+		Entry("Claim of a dual-stack pool", testCaseUpdateAddresses{
+			ipPool: &ipamv1.IPPool{
+				ObjectMeta: ipPoolMeta,
+				Spec: ipamv1.IPPoolSpec{
+					NamePrefix: "abcpref",
+					Pools: []ipamv1.Pool{
+						{
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.10")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.20")),
+						},
+						{
+							Subnet: (*ipamv1.IPSubnetStr)(pointer.StringPtr("2001:db8::/64")),
+						},
+					},
+					DualStack: true,
+				},
+			},
+			ipClaims: []*ipamv1.IPClaim{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "abc",
+						Namespace: "myns",
+					},
+					Spec: ipamv1.IPClaimSpec{
+						Pool: corev1.ObjectReference{
+							Name:      "abc",
+							Namespace: "myns",
+						},
+					},
+				},
+			},
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"abc":      ipamv1.IPAddressStr("192.168.1.10"),
+				"abc:ipv6": ipamv1.IPAddressStr("2001:db8::1"),
+			},
+			expectedNbAllocations: 2,
+		}),
+	)
+
+	DescribeTable("Test UpdateAddresses of a renamed pool",
+		func(ownedAddresses int, adoptedAddresses int) {
+			objects := []client.Object{
+				&ipamv1.IPPool{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "new",
+						Namespace: "myns",
+						Annotations: map[string]string{
+							ipamv1.RenamedFromAnnotation: "abc",
+						},
+					},
+				},
+			}
+			for i := 0; i < ownedAddresses+adoptedAddresses; i++ {
+				owner := "abc"
+				if i >= ownedAddresses {
+					owner = "new"
+				}
+				objects = append(objects, &ipamv1.IPAddress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      fmt.Sprintf("abc-%d", i),
+						Namespace: "myns",
+						OwnerReferences: []metav1.OwnerReference{
+							{
+								APIVersion: ipamv1.GroupVersion.String(),
+								Kind:       "IPPool",
+								Name:       owner,
+							},
+						},
+					},
+					Spec: ipamv1.IPAddressSpec{
+						Address: ipamv1.IPAddressStr(fmt.Sprintf("192.168.0.%d", i)),
+						Pool:    *testObjectReference,
+					},
+				})
+			}
+			c := fakeclient.NewClientBuilder().WithScheme(setupScheme()).WithObjects(objects...).Build()
+			ipPool := &ipamv1.IPPool{
+				ObjectMeta: ipPoolMeta,
+			}
+			ipPoolMgr, err := NewIPPoolManager(c, ipPool, klogr.New())
+			Expect(err).NotTo(HaveOccurred())
+
+			nbAllocations, err := ipPoolMgr.UpdateAddresses(context.TODO())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nbAllocations).To(Equal(ownedAddresses))
+			Expect(ipPool.Status.Allocations).To(BeNil())
+		},
+		Entry("No addresses", 0, 0),
+		Entry("Addresses not adopted yet", 2, 1),
+		Entry("Addresses adopted", 0, 3),
+	)
+
+	DescribeTable("Test isClaimForPool",
+		func(claim *ipamv1.IPClaim, allowedNamespaces []string, expected bool, expectedKey string) {
+			ipPool := &ipamv1.IPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "abc",
+					Namespace: "myns",
+					Annotations: map[string]string{
+						ipamv1.RenamedFromAnnotation: "old",
+					},
+				},
+				Spec: ipamv1.IPPoolSpec{
+					AllowedNamespaces: allowedNamespaces,
+				},
+			}
+			ipPoolMgr, err := NewIPPoolManager(nil, ipPool, klogr.New())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ipPoolMgr.isClaimForPool(claim)).To(Equal(expected))
+			Expect(ipPoolMgr.allocationKey(claim.Name, claim.Namespace)).To(Equal(expectedKey))
+		},
+		Entry("Same namespace", &ipamv1.IPClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "abc", Namespace: "myns"},
+			Spec: ipamv1.IPClaimSpec{
+				Pool: corev1.ObjectReference{Name: "abc"},
+			},
+		}, nil, true, "abc"),
+		Entry("Other pool", &ipamv1.IPClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "abc", Namespace: "myns"},
+			Spec: ipamv1.IPClaimSpec{
+				Pool: corev1.ObjectReference{Name: "abc", Namespace: "otherns"},
+			},
+		}, nil, false, "abc"),
+		Entry("Other namespace, denied", &ipamv1.IPClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "abc", Namespace: "otherns"},
+			Spec: ipamv1.IPClaimSpec{
+				Pool: corev1.ObjectReference{Name: "abc", Namespace: "myns"},
+			},
+		}, nil, false, "otherns/abc"),
+		Entry("Other namespace, allowed", &ipamv1.IPClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "abc", Namespace: "otherns"},
+			Spec: ipamv1.IPClaimSpec{
+				Pool: corev1.ObjectReference{Name: "abc", Namespace: "myns"},
+			},
+		}, []string{ipamv1.AllNamespaces}, true, "otherns/abc"),
+		Entry("Former name", &ipamv1.IPClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "abc", Namespace: "myns"},
+			Spec: ipamv1.IPClaimSpec{
+				Pool: corev1.ObjectReference{Name: "old"},
+			},
+		}, nil, true, "abc"),
+	)
+
+	type testCaseDeleteClusterClaims struct {
+		ipPool          *ipamv1.IPPool
+		ipClaims        []*ipamv1.IPClaim
+		expectedDeleted []string
+	}
+
+	DescribeTable("Test DeleteClusterClaims",
+		func(tc testCaseDeleteClusterClaims) {
+			objects := []client.Object{}
+			for _, claim := range tc.ipClaims {
+				objects = append(objects, claim)
+			}
+			c := fakeclient.NewClientBuilder().WithScheme(setupScheme()).WithObjects(objects...).Build()
+			ipPoolMgr, err := NewIPPoolManager(c, tc.ipPool,
+				klogr.New(),
+			)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(ipPoolMgr.DeleteClusterClaims(context.TODO())).To(Succeed())
+
+			for _, claim := range tc.ipClaims {
+				claimObject := &ipamv1.IPClaim{}
+				err := c.Get(context.TODO(), client.ObjectKeyFromObject(claim), claimObject)
+				Expect(err).NotTo(HaveOccurred())
+				if Contains(tc.expectedDeleted, claim.Name) {
+					Expect(claimObject.DeletionTimestamp.IsZero()).To(BeFalse())
+				} else {
+					Expect(claimObject.DeletionTimestamp.IsZero()).To(BeTrue())
+				}
+			}
+		},
+		Entry("No cluster", testCaseDeleteClusterClaims{
+			ipPool: &ipamv1.IPPool{
+				ObjectMeta: ipPoolMeta,
+			},
+			ipClaims: []*ipamv1.IPClaim{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:       "abc",
+						Namespace:  "myns",
+						Finalizers: []string{ipamv1.IPClaimFinalizer},
+						Labels:     map[string]string{capi.ClusterLabelName: "abc-cluster"},
+					},
+					Spec: ipamv1.IPClaimSpec{
+						Pool: *testObjectReference,
+					},
+				},
+			},
+		}),
+		Entry("Claims of the cluster", testCaseDeleteClusterClaims{
+			ipPool: &ipamv1.IPPool{
+				ObjectMeta: ipPoolMeta,
+				Spec: ipamv1.IPPoolSpec{
+					ClusterName: pointer.StringPtr("abc-cluster"),
+				},
+			},
+			ipClaims: []*ipamv1.IPClaim{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:       "abc",
+						Namespace:  "myns",
+						Finalizers: []string{ipamv1.IPClaimFinalizer},
+						Labels:     map[string]string{capi.ClusterLabelName: "abc-cluster"},
+					},
+					Spec: ipamv1.IPClaimSpec{
+						Pool: *testObjectReference,
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:       "bcd",
+						Namespace:  "myns",
+						Finalizers: []string{ipamv1.IPClaimFinalizer},
+						Labels:     map[string]string{capi.ClusterLabelName: "bcd-cluster"},
+					},
+					Spec: ipamv1.IPClaimSpec{
+						Pool: *testObjectReference,
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:       "cde",
+						Namespace:  "myns",
+						Finalizers: []string{ipamv1.IPClaimFinalizer},
+						Labels:     map[string]string{capi.ClusterLabelName: "abc-cluster"},
+					},
+					Spec: ipamv1.IPClaimSpec{
+						Pool: corev1.ObjectReference{
+							Name: "other",
+						},
+					},
+				},
+			},
+			expectedDeleted: []string{"abc"},
+		}),
+	)
+
+	type testCaseReleaseAddress struct {
+		ipPool              *ipamv1.IPPool
+		ipClaim             *ipamv1.IPClaim
+		ipAddresses         []*ipamv1.IPAddress
+		expectedAllocations map[string]ipamv1.IPAddressStr
+		expectReleased      bool
+		expectedRanges      []string
+	}
+
+	DescribeTable("Test ReleaseAddress",
+		func(tc testCaseReleaseAddress) {
+			objects := []client.Object{tc.ipClaim}
+			for _, address := range tc.ipAddresses {
+				objects = append(objects, address)
+			}
+			c := fakeclient.NewClientBuilder().WithScheme(setupScheme()).WithObjects(objects...).Build()
+			ipPoolMgr, err := NewIPPoolManager(c, tc.ipPool,
+				klogr.New(),
+			)
+			Expect(err).NotTo(HaveOccurred())
+
+			releaseCount := func() uint64 {
+				metric := &dto.Metric{}
+				err := addressReleaseDuration.WithLabelValues(
+					tc.ipPool.Namespace, tc.ipPool.Name,
+				).(prometheus.Metric).Write(metric)
+				Expect(err).NotTo(HaveOccurred())
+				return metric.GetHistogram().GetSampleCount()
+			}
+			previousReleaseCount := releaseCount()
+
+			err = ipPoolMgr.ReleaseAddress(context.TODO(), tc.ipClaim)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tc.ipPool.Status.Allocations).To(Equal(tc.expectedAllocations))
+			Expect(tc.ipPool.Status.AllocatedRanges).To(Equal(tc.expectedRanges))
+
+			addressObjects := ipamv1.IPAddressList{}
+			err = c.List(context.TODO(), &addressObjects, &client.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			if tc.expectReleased {
+				Expect(len(addressObjects.Items)).To(Equal(0))
+				Expect(tc.ipClaim.Finalizers).NotTo(ContainElement(ipamv1.IPClaimFinalizer))
+				Expect(tc.ipClaim.Status.Address).To(BeNil())
+				Expect(releaseCount()).To(Equal(previousReleaseCount + 1))
+			} else {
+				Expect(len(addressObjects.Items)).To(Equal(len(tc.ipAddresses)))
+				Expect(tc.ipClaim.Finalizers).To(ContainElement(ipamv1.IPClaimFinalizer))
+				Expect(releaseCount()).To(Equal(previousReleaseCount))
+			}
+		},
+		Entry("Claim not deleted", testCaseReleaseAddress{
+			ipPool: &ipamv1.IPPool{
+				ObjectMeta: ipPoolMeta,
+				Spec: ipamv1.IPPoolSpec{
+					NamePrefix: "abcpref",
+				},
+				Status: ipamv1.IPPoolStatus{
+					Allocations: map[string]ipamv1.IPAddressStr{
+						"abc": ipamv1.IPAddressStr("192.168.1.11"),
+					},
+				},
+			},
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:       "abc",
+					Namespace:  "myns",
+					Finalizers: []string{ipamv1.IPClaimFinalizer},
+				},
+			},
+			ipAddresses: []*ipamv1.IPAddress{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "abcpref-192-168-1-11",
+						Namespace: "myns",
+					},
+				},
+			},
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"abc": ipamv1.IPAddressStr("192.168.1.11"),
+			},
+		}),
+		Entry("Claim deleted", testCaseReleaseAddress{
+			ipPool: &ipamv1.IPPool{
+				ObjectMeta: ipPoolMeta,
+				Spec: ipamv1.IPPoolSpec{
+					NamePrefix: "abcpref",
+				},
+				Status: ipamv1.IPPoolStatus{
+					Allocations: map[string]ipamv1.IPAddressStr{
+						"abc":  ipamv1.IPAddressStr("192.168.1.11"),
+						"abcd": ipamv1.IPAddressStr("192.168.1.12"),
+					},
+				},
+			},
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              "abc",
+					Namespace:         "myns",
+					DeletionTimestamp: &timeNow,
+					Finalizers:        []string{ipamv1.IPClaimFinalizer},
+				},
+			},
+			ipAddresses: []*ipamv1.IPAddress{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "abcpref-192-168-1-11",
+						Namespace: "myns",
+					},
+				},
+			},
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"abcd": ipamv1.IPAddressStr("192.168.1.12"),
+			},
+			expectReleased: true,
+		}),
+		Entry("Claim deleted, compacted allocations", testCaseReleaseAddress{
+			ipPool: &ipamv1.IPPool{
+				ObjectMeta: ipPoolMeta,
+				Spec: ipamv1.IPPoolSpec{
+					NamePrefix:         "abcpref",
+					CompactAllocations: true,
+				},
+				Status: ipamv1.IPPoolStatus{
+					AllocatedRanges: []string{"192.168.1.11-192.168.1.12"},
+				},
+			},
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              "abc",
+					Namespace:         "myns",
+					DeletionTimestamp: &timeNow,
+					Finalizers:        []string{ipamv1.IPClaimFinalizer},
+				},
+			},
+			ipAddresses: []*ipamv1.IPAddress{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "abcpref-192-168-1-11",
+						Namespace: "myns",
+					},
+					Spec: ipamv1.IPAddressSpec{
+						Address: "192.168.1.11",
+						Pool:    corev1.ObjectReference{Name: "abc"},
+						Claim:   corev1.ObjectReference{Name: "abc"},
+					},
+				},
+			},
+			expectReleased: true,
+			expectedRanges: []string{},
+		}),
+	)
+
+	type testCaseUpdateClaim struct {
+		poolName        string
+		lease           *metav1.Duration
+		bound           bool
+		deleted         bool
+		expectAddress   bool
+		expectUnchanged bool
+		expectRequeue   bool
+	}
+
+	DescribeTable("Test UpdateClaim",
+		func(tc testCaseUpdateClaim) {
+			poolName := tc.poolName
+			if poolName == "" {
+				poolName = "abc"
+			}
+			ipClaim := &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              "abc",
+					Namespace:         "myns",
+					CreationTimestamp: metav1.NewTime(time.Now().Add(-time.Hour)),
+					Finalizers:        []string{ipamv1.IPClaimFinalizer},
+				},
+				Spec: ipamv1.IPClaimSpec{
+					Pool:          corev1.ObjectReference{Name: poolName},
+					LeaseDuration: tc.lease,
+				},
+			}
+			if tc.deleted {
+				ipClaim.DeletionTimestamp = &timeNow
+			}
+			ipPool := &ipamv1.IPPool{
+				ObjectMeta: ipPoolMeta,
+				Spec: ipamv1.IPPoolSpec{
+					NamePrefix: "abcpref",
+					Pools: []ipamv1.Pool{
+						{
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.19")),
+						},
+					},
+				},
+				Status: ipamv1.IPPoolStatus{
+					Allocations: map[string]ipamv1.IPAddressStr{},
+				},
+			}
+			objects := []client.Object{ipClaim}
+			if tc.bound {
+				ipClaim.Status.Address = &corev1.ObjectReference{
+					Name:      "abcpref-192-168-0-10",
+					Namespace: "myns",
+				}
+				ipPool.Status.Allocations["abc"] = "192.168.0.10"
+				objects = append(objects, &ipamv1.IPAddress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "abcpref-192-168-0-10",
+						Namespace: "myns",
+					},
+					Spec: ipamv1.IPAddressSpec{
+						Address: "192.168.0.10",
+						Pool:    corev1.ObjectReference{Name: "abc", Namespace: "myns"},
+						Claim:   corev1.ObjectReference{Name: "abc", Namespace: "myns"},
+					},
+				})
+			}
+			c := fakeclient.NewClientBuilder().WithScheme(setupScheme()).WithObjects(objects...).Build()
+			ipPoolMgr, err := NewIPPoolManager(c, ipPool, klogr.New())
+			Expect(err).NotTo(HaveOccurred())
+
+			err = ipPoolMgr.UpdateClaim(context.TODO(), ipClaim)
+			if tc.expectRequeue {
+				Expect(err).To(BeAssignableToTypeOf(&RequeueAfterError{}))
+			} else {
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			if tc.expectUnchanged {
+				Expect(ipPool.Status.LastUpdated).To(BeNil())
+				Expect(ipPool.Status.TotalCount).To(BeZero())
+			} else {
+				Expect(ipPool.Status.LastUpdated).NotTo(BeNil())
+				Expect(ipPool.Status.TotalCount).To(Equal(int64(10)))
+			}
+			addressObjects := ipamv1.IPAddressList{}
+			Expect(c.List(context.TODO(), &addressObjects)).To(Succeed())
+			if tc.expectAddress {
+				Expect(ipClaim.Status.Address).NotTo(BeNil())
+				Expect(ipPool.Status.Allocations).To(HaveLen(1))
+				Expect(addressObjects.Items).To(HaveLen(1))
+			} else {
+				Expect(ipClaim.Status.Address).To(BeNil())
+				Expect(ipPool.Status.Allocations).To(BeEmpty())
+				Expect(addressObjects.Items).To(BeEmpty())
+			}
+		},
+		Entry("Claim of another pool", testCaseUpdateClaim{
+			poolName:        "bcd",
+			expectUnchanged: true,
+		}),
+		Entry("Pending claim", testCaseUpdateClaim{
+			expectAddress: true,
+		}),
+		Entry("Bound claim", testCaseUpdateClaim{
+			bound:           true,
+			expectAddress:   true,
+			expectUnchanged: true,
+		}),
+		Entry("Bound claim, lease not expired", testCaseUpdateClaim{
+			lease:           &metav1.Duration{Duration: 2 * time.Hour},
+			bound:           true,
+			expectAddress:   true,
+			expectUnchanged: true,
+			expectRequeue:   true,
+		}),
+		Entry("Bound claim, lease expired", testCaseUpdateClaim{
+			lease: &metav1.Duration{Duration: 30 * time.Minute},
+			bound: true,
+		}),
+		Entry("Deleted claim", testCaseUpdateClaim{
+			bound:   true,
+			deleted: true,
+		}),
+	)
+
+	type testCaseUpdateClaimBatch struct {
+		batchSize           int
+		poolEnd             string
+		expectedAllocated   int
+		expectedIPAddresses int
+	}
+
+	DescribeTable("Test UpdateClaim in batch",
+		func(tc testCaseUpdateClaimBatch) {
+			newClaim := func(name string, pool string) *ipamv1.IPClaim {
+				return &ipamv1.IPClaim{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:       name,
+						Namespace:  "myns",
+						Finalizers: []string{ipamv1.IPClaimFinalizer},
+					},
+					Spec: ipamv1.IPClaimSpec{
+						Pool: corev1.ObjectReference{Name: pool},
+					},
+				}
+			}
+			ipClaim := newClaim("abc", "abc")
+			failedClaim := newClaim("failed", "abc")
+			failedClaim.Status.ErrorMessage = pointer.StringPtr("Failed")
+			objects := []client.Object{
+				ipClaim, failedClaim, newClaim("other", "bcd"),
+				newClaim("pending-1", "abc"), newClaim("pending-2", "abc"),
+				newClaim("pending-3", "abc"), newClaim("pending-4", "abc"),
+			}
+			ipPool := &ipamv1.IPPool{
+				ObjectMeta: ipPoolMeta,
+				Spec: ipamv1.IPPoolSpec{
+					NamePrefix: "abcpref",
+					Pools: []ipamv1.Pool{
+						{
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr(tc.poolEnd)),
+						},
+					},
+				},
+				Status: ipamv1.IPPoolStatus{
+					Allocations: map[string]ipamv1.IPAddressStr{},
+				},
+			}
+			c := fakeclient.NewClientBuilder().WithScheme(setupScheme()).WithObjects(objects...).Build()
+			ipPoolMgr, err := NewIPPoolManager(c, ipPool, klogr.New())
+			Expect(err).NotTo(HaveOccurred())
+			ipPoolMgr.AllocationBatchSize = tc.batchSize
+
+			Expect(ipPoolMgr.UpdateClaim(context.TODO(), ipClaim)).To(Succeed())
+			Expect(ipClaim.Status.Address).NotTo(BeNil())
+			Expect(ipPool.Status.Allocations).To(HaveLen(tc.expectedAllocated))
+			Expect(ipPool.Status.Allocations).NotTo(HaveKey("failed"))
+			Expect(ipPool.Status.Allocations).NotTo(HaveKey("other"))
+			addressObjects := ipamv1.IPAddressList{}
+			Expect(c.List(context.TODO(), &addressObjects)).To(Succeed())
+			Expect(addressObjects.Items).To(HaveLen(tc.expectedIPAddresses))
+
+			// The batched claims are bound
+			claims := ipamv1.IPClaimList{}
+			Expect(c.List(context.TODO(), &claims)).To(Succeed())
+			bound := 0
+			for _, claim := range claims.Items {
+				if claim.Status.Address != nil {
+					bound++
+				}
+			}
+			Expect(bound).To(Equal(tc.expectedIPAddresses))
+		},
+		Entry("Disabled", testCaseUpdateClaimBatch{
+			poolEnd:             "192.168.0.19",
+			expectedAllocated:   1,
+			expectedIPAddresses: 1,
+		}),
+		Entry("Batch smaller than the pending claims", testCaseUpdateClaimBatch{
+			batchSize:           3,
+			poolEnd:             "192.168.0.19",
+			expectedAllocated:   3,
+			expectedIPAddresses: 3,
+		}),
+		Entry("All the pending claims", testCaseUpdateClaimBatch{
+			batchSize:           20,
+			poolEnd:             "192.168.0.19",
+			expectedAllocated:   5,
+			expectedIPAddresses: 5,
+		}),
+		Entry("Pool exhausted by the batch", testCaseUpdateClaimBatch{
+			batchSize:           20,
+			poolEnd:             "192.168.0.12",
+			expectedAllocated:   3,
+			expectedIPAddresses: 3,
+		}),
+	)
+
+	DescribeTable("Test UpdateClaim with an exhausted pool",
+		func(exhausted bool, expectReleased bool) {
+			deletedClaim := &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              "deleted",
+					Namespace:         "myns",
+					DeletionTimestamp: &timeNow,
+					Finalizers:        []string{ipamv1.IPClaimFinalizer},
+				},
+				Spec: ipamv1.IPClaimSpec{
+					Pool: corev1.ObjectReference{Name: "abc"},
+				},
+			}
+			ipClaim := &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:       "abc",
+					Namespace:  "myns",
+					Finalizers: []string{ipamv1.IPClaimFinalizer},
+				},
+				Spec: ipamv1.IPClaimSpec{
+					Pool: corev1.ObjectReference{Name: "abc"},
+				},
+			}
+			ipAddress := &ipamv1.IPAddress{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "abcpref-192-168-0-10",
+					Namespace: "myns",
+				},
+				Spec: ipamv1.IPAddressSpec{
+					Address: "192.168.0.10",
+					Pool:    corev1.ObjectReference{Name: "abc"},
+					Claim:   corev1.ObjectReference{Name: "deleted"},
+				},
+			}
+			ipPool := &ipamv1.IPPool{
+				ObjectMeta: ipPoolMeta,
+				Spec: ipamv1.IPPoolSpec{
+					NamePrefix: "abcpref",
+					Pools: []ipamv1.Pool{
+						{
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+						},
+					},
+				},
+				Status: ipamv1.IPPoolStatus{
+					Allocations: map[string]ipamv1.IPAddressStr{
+						"deleted": ipamv1.IPAddressStr("192.168.0.10"),
+					},
+				},
+			}
+			if exhausted {
+				meta.SetStatusCondition(&ipPool.Status.Conditions, metav1.Condition{
+					Type:   ipamv1.IPPoolExhaustedCondition,
+					Status: metav1.ConditionTrue,
+					Reason: "NoAddressAvailable",
+				})
+			}
+			c := fakeclient.NewClientBuilder().WithScheme(setupScheme()).WithObjects(
+				deletedClaim, ipClaim, ipAddress,
+			).Build()
+			ipPoolMgr, err := NewIPPoolManager(c, ipPool, klogr.New())
+			Expect(err).NotTo(HaveOccurred())
+
+			err = ipPoolMgr.UpdateClaim(context.TODO(), ipClaim)
+			if !expectReleased {
+				Expect(err).To(HaveOccurred())
+				Expect(ipClaim.Status.Address).To(BeNil())
+				Expect(ipPool.Status.Allocations).To(HaveKey("deleted"))
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ipClaim.Status.Address).NotTo(BeNil())
+			Expect(ipPool.Status.Allocations).To(Equal(map[string]ipamv1.IPAddressStr{
+				"abc": ipamv1.IPAddressStr("192.168.0.10"),
+			}))
+			// The deleted claim is released, and gone without its finalizer
+			err = c.Get(context.TODO(), client.ObjectKeyFromObject(deletedClaim), deletedClaim)
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		},
+		Entry("Pool exhausted, the deleted claim is released first", true, true),
+		Entry("Pool not reported exhausted", false, false),
+	)
+
+	type testCaseCreateAddresses struct {
+		ipPool              *ipamv1.IPPool
+		ipClaim             *ipamv1.IPClaim
+		ipAddresses         []*ipamv1.IPAddress
+		addresses           map[ipamv1.IPAddressStr]string
+		expectRequeue       bool
+		expectError         bool
+		expectedIPAddresses []string
+		expectedAddresses   map[ipamv1.IPAddressStr]string
+		expectedAllocations map[string]ipamv1.IPAddressStr
+		expectedAnnotations map[string]string
+		expectedHeld        *metav1.Condition
+		expectedDelegated   *ipamv1.IPSubnetStr
+	}
+
+	DescribeTable("Test CreateAddresses",
+		func(tc testCaseCreateAddresses) {
+			objects := []client.Object{}
+			for _, address := range tc.ipAddresses {
+				objects = append(objects, address)
+			}
+			c := fakeclient.NewClientBuilder().WithScheme(setupScheme()).WithObjects(objects...).Build()
+			ipPoolMgr, err := NewIPPoolManager(c, tc.ipPool,
+				klogr.New(),
+			)
+			Expect(err).NotTo(HaveOccurred())
+
+			allocatedMap, err := ipPoolMgr.createAddress(context.TODO(), tc.ipClaim,
+				tc.addresses,
+			)
+			if tc.expectRequeue || tc.expectError {
+				Expect(err).To(HaveOccurred())
+				if tc.expectRequeue {
+					Expect(err).To(BeAssignableToTypeOf(&RequeueAfterError{}))
+				} else {
+					Expect(err).NotTo(BeAssignableToTypeOf(&RequeueAfterError{}))
+				}
+			} else {
+				Expect(err).NotTo(HaveOccurred())
+			}
+			// get list of IPAddress objects
+			addressObjects := ipamv1.IPAddressList{}
+			opts := &client.ListOptions{}
+			err = c.List(context.TODO(), &addressObjects, opts)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(len(tc.expectedIPAddresses)).To(Equal(len(addressObjects.Items)))
+			// Iterate over the IPAddress objects to find all indexes and objects
+			for _, address := range addressObjects.Items {
+				Expect(tc.expectedIPAddresses).To(ContainElement(address.Name))
+				Expect(address.Annotations).To(Equal(tc.expectedAnnotations))
+				Expect(address.Spec.DelegatedPrefix).To(Equal(tc.expectedDelegated))
+				// TODO add further testing later
+			}
+			Expect(len(tc.ipClaim.Finalizers)).To(Equal(1))
+
+			Expect(allocatedMap).To(Equal(tc.expectedAddresses))
+			Expect(tc.ipPool.Status.Allocations).To(Equal(tc.expectedAllocations))
+			held := meta.FindStatusCondition(tc.ipClaim.Status.Conditions,
+				ipamv1.IPClaimBindingHeldCondition,
+			)
+			if tc.expectedHeld == nil {
+				Expect(held).To(BeNil())
+			} else {
+				Expect(held).NotTo(BeNil())
+				Expect(held.Status).To(Equal(tc.expectedHeld.Status))
+				Expect(held.Message).To(Equal(tc.expectedHeld.Message))
+				if held.Status == metav1.ConditionTrue {
+					// Nothing is committed for a held claim
+					Expect(tc.ipPool.Status.AffinityGroups).To(BeEmpty())
+				}
+			}
+		},
+		Entry("Already exists", testCaseCreateAddresses{
+			ipPool: &ipamv1.IPPool{
+				ObjectMeta: ipPoolMeta,
+				Status: ipamv1.IPPoolStatus{
+					Allocations: map[string]ipamv1.IPAddressStr{
+						"abc": ipamv1.IPAddressStr("foo-0"),
+					},
+				},
+			},
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "abc",
+				},
+			},
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"abc": ipamv1.IPAddressStr("foo-0"),
+			},
+		}),
+		Entry("Not allocated yet, pre-allocated", testCaseCreateAddresses{
+			ipPool: &ipamv1.IPPool{
+				ObjectMeta: ipPoolMeta,
+				Spec: ipamv1.IPPoolSpec{
+					Pools: []ipamv1.Pool{
+						{
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.11")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.20")),
+						},
+					},
+					PreAllocations: map[string]ipamv1.IPAddressStr{
+						"abc": ipamv1.IPAddressStr("192.168.0.15"),
+					},
+					NamePrefix: "abcpref",
+				},
+				Status: ipamv1.IPPoolStatus{
+					Allocations: map[string]ipamv1.IPAddressStr{},
+				},
+			},
+			addresses: map[ipamv1.IPAddressStr]string{},
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "abc",
+				},
+			},
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"abc": ipamv1.IPAddressStr("192.168.0.15"),
+			},
+			expectedAddresses: map[ipamv1.IPAddressStr]string{
+				ipamv1.IPAddressStr("192.168.0.15"): "abc",
+			},
+			expectedIPAddresses: []string{"abcpref-192-168-0-15"},
+		}),
+		Entry("Not allocated yet, delegated prefix", testCaseCreateAddresses{
+			ipPool: &ipamv1.IPPool{
+				ObjectMeta: ipPoolMeta,
+				Spec: ipamv1.IPPoolSpec{
+					Pools: []ipamv1.Pool{
+						{
+							Subnet: (*ipamv1.IPSubnetStr)(pointer.StringPtr("192.168.0.0/24")),
+						},
+					},
+					DelegatedPrefix: 28,
+					NamePrefix:      "abcpref",
+				},
+				Status: ipamv1.IPPoolStatus{
+					Allocations: map[string]ipamv1.IPAddressStr{},
+				},
+			},
+			addresses: map[ipamv1.IPAddressStr]string{
+				ipamv1.IPAddressStr("192.168.0.0"): "bcd",
+			},
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "abc",
+				},
+			},
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"abc": ipamv1.IPAddressStr("192.168.0.16"),
+			},
+			expectedAddresses: map[ipamv1.IPAddressStr]string{
+				ipamv1.IPAddressStr("192.168.0.0"):  "bcd",
+				ipamv1.IPAddressStr("192.168.0.16"): "abc",
+			},
+			expectedIPAddresses: []string{"abcpref-192-168-0-16"},
+			expectedDelegated:   (*ipamv1.IPSubnetStr)(pointer.StringPtr("192.168.0.16/28")),
+		}),
+		Entry("Not allocated yet, binding held", testCaseCreateAddresses{
+			ipPool: &ipamv1.IPPool{
+				ObjectMeta: ipPoolMeta,
+				Spec: ipamv1.IPPoolSpec{
+					Pools: []ipamv1.Pool{
+						{
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.11")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.20")),
+						},
+					},
+					NamePrefix: "abcpref",
+				},
+				Status: ipamv1.IPPoolStatus{
+					Allocations: map[string]ipamv1.IPAddressStr{},
+				},
+			},
+			addresses: map[ipamv1.IPAddressStr]string{},
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "abc",
+					Annotations: map[string]string{
+						ipamv1.BindingHoldAnnotationPrefix + "security": "",
+						ipamv1.BindingHoldAnnotationPrefix + "network":  "",
+					},
+				},
+				Spec: ipamv1.IPClaimSpec{
+					Roles:         []string{"bmc"},
+					AffinityGroup: "rack1",
+				},
+			},
+			expectedAllocations: map[string]ipamv1.IPAddressStr{},
+			expectedAddresses:   map[ipamv1.IPAddressStr]string{},
+			expectedHeld: &metav1.Condition{
+				Status:  metav1.ConditionTrue,
+				Message: "Proposed 192.168.0.11, bmc 192.168.0.12, held by network, security",
+			},
+		}),
+		Entry("Not allocated yet, binding hold removed", testCaseCreateAddresses{
+			ipPool: &ipamv1.IPPool{
+				ObjectMeta: ipPoolMeta,
+				Spec: ipamv1.IPPoolSpec{
+					Pools: []ipamv1.Pool{
+						{
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.11")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.20")),
+						},
+					},
+					NamePrefix: "abcpref",
+				},
+				Status: ipamv1.IPPoolStatus{
+					Allocations: map[string]ipamv1.IPAddressStr{},
+				},
+			},
+			addresses: map[ipamv1.IPAddressStr]string{},
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "abc",
+				},
+				Status: ipamv1.IPClaimStatus{
+					Conditions: []metav1.Condition{
+						{
+							Type:    ipamv1.IPClaimBindingHeldCondition,
+							Status:  metav1.ConditionTrue,
+							Reason:  "HoldAnnotations",
+							Message: "Proposed 192.168.0.11, held by network",
+						},
+					},
+				},
+			},
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"abc": ipamv1.IPAddressStr("192.168.0.11"),
+			},
+			expectedAddresses: map[ipamv1.IPAddressStr]string{
+				ipamv1.IPAddressStr("192.168.0.11"): "abc",
+			},
+			expectedIPAddresses: []string{"abcpref-192-168-0-11"},
+			expectedHeld: &metav1.Condition{
+				Status: metav1.ConditionFalse,
+			},
+		}),
+		Entry("Not allocated yet, annotations propagated", testCaseCreateAddresses{
+			ipPool: &ipamv1.IPPool{
+				ObjectMeta: ipPoolMeta,
+				Spec: ipamv1.IPPoolSpec{
+					Pools: []ipamv1.Pool{
+						{
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.11")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.20")),
+						},
+					},
+					NamePrefix:            "abcpref",
+					PropagatedAnnotations: []string{"rack", "example.com/*"},
+				},
+				Status: ipamv1.IPPoolStatus{
+					Allocations: map[string]ipamv1.IPAddressStr{},
+				},
+			},
+			addresses: map[ipamv1.IPAddressStr]string{},
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "abc",
+					Annotations: map[string]string{
+						"rack":               "r1",
+						"example.com/ticket": "T-1",
+						"other":              "value",
+					},
+				},
+			},
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"abc": ipamv1.IPAddressStr("192.168.0.11"),
+			},
+			expectedAddresses: map[ipamv1.IPAddressStr]string{
+				ipamv1.IPAddressStr("192.168.0.11"): "abc",
+			},
+			expectedIPAddresses: []string{"abcpref-192-168-0-11"},
+			expectedAnnotations: map[string]string{
+				"rack":               "r1",
+				"example.com/ticket": "T-1",
+			},
+		}),
+		Entry("Not allocated yet", testCaseCreateAddresses{
+			ipPool: &ipamv1.IPPool{
+				ObjectMeta: ipPoolMeta,
+				Spec: ipamv1.IPPoolSpec{
+					Pools: []ipamv1.Pool{
+						{
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.11")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.20")),
+						},
+					},
+					NamePrefix: "abcpref",
+				},
+				Status: ipamv1.IPPoolStatus{
+					Allocations: map[string]ipamv1.IPAddressStr{},
+				},
+			},
+			addresses: map[ipamv1.IPAddressStr]string{
+				ipamv1.IPAddressStr("192.168.0.11"): "bcd",
+			},
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "abc",
+				},
+			},
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"abc": ipamv1.IPAddressStr("192.168.0.12"),
+			},
+			expectedAddresses: map[ipamv1.IPAddressStr]string{
+				ipamv1.IPAddressStr("192.168.0.12"): "abc",
+				ipamv1.IPAddressStr("192.168.0.11"): "bcd",
+			},
+			expectedIPAddresses: []string{"abcpref-192-168-0-12"},
+		}),
+		Entry("Not allocated yet, conflict", testCaseCreateAddresses{
+			ipPool: &ipamv1.IPPool{
+				ObjectMeta: ipPoolMeta,
+				Spec: ipamv1.IPPoolSpec{
+					Pools: []ipamv1.Pool{
+						{
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.11")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.20")),
+						},
+					},
+					NamePrefix: "abcpref",
+				},
+				Status: ipamv1.IPPoolStatus{
+					Allocations: map[string]ipamv1.IPAddressStr{},
+				},
+			},
+			addresses: map[ipamv1.IPAddressStr]string{},
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "abc",
+				},
+			},
+			ipAddresses: []*ipamv1.IPAddress{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "abcpref-192-168-0-11",
+						Namespace: "myns",
+					},
+					Spec: ipamv1.IPAddressSpec{
+						Address: "192.168.0.11",
+						Pool: corev1.ObjectReference{
+							Name: "abc",
+						},
+						Claim: corev1.ObjectReference{
+							Name: "bcd",
+						},
+					},
+				},
+			},
+			expectedAllocations: map[string]ipamv1.IPAddressStr{},
+			expectedAddresses:   map[ipamv1.IPAddressStr]string{},
+			expectedIPAddresses: []string{"abcpref-192-168-0-11"},
+			expectRequeue:       true,
+		}),
+		Entry("Not allocated yet, created by a previous reconcile", testCaseCreateAddresses{
+			ipPool: &ipamv1.IPPool{
+				ObjectMeta: ipPoolMeta,
+				Spec: ipamv1.IPPoolSpec{
+					Pools: []ipamv1.Pool{
+						{
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.11")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.20")),
+						},
+					},
+					NamePrefix: "abcpref",
+				},
+				Status: ipamv1.IPPoolStatus{
+					Allocations: map[string]ipamv1.IPAddressStr{},
+				},
+			},
+			addresses: map[ipamv1.IPAddressStr]string{},
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "abc",
+				},
+			},
+			ipAddresses: []*ipamv1.IPAddress{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "abcpref-192-168-0-11",
+						Namespace: "myns",
+					},
+					Spec: ipamv1.IPAddressSpec{
+						Address: "192.168.0.11",
+						Pool: corev1.ObjectReference{
+							Name: "abc",
+						},
+						Claim: corev1.ObjectReference{
+							Name: "abc",
+						},
+					},
+				},
+			},
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"abc": ipamv1.IPAddressStr("192.168.0.11"),
+			},
+			expectedAddresses: map[ipamv1.IPAddressStr]string{
+				ipamv1.IPAddressStr("192.168.0.11"): "abc",
+			},
+			expectedIPAddresses: []string{"abcpref-192-168-0-11"},
+		}),
+		Entry("Not allocated yet, exhausted pool", testCaseCreateAddresses{
+			ipPool: &ipamv1.IPPool{
+				ObjectMeta: ipPoolMeta,
+				Spec: ipamv1.IPPoolSpec{
+					Pools: []ipamv1.Pool{
+						{
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.11")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.11")),
+						},
+					},
+					NamePrefix: "abcpref",
+				},
+				Status: ipamv1.IPPoolStatus{
+					Allocations: map[string]ipamv1.IPAddressStr{},
+				},
+			},
+			addresses: map[ipamv1.IPAddressStr]string{
+				ipamv1.IPAddressStr("192.168.0.11"): "bcd",
+			},
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "abc",
+				},
+			},
+			expectedAllocations: map[string]ipamv1.IPAddressStr{},
+			expectedAddresses: map[ipamv1.IPAddressStr]string{
+				ipamv1.IPAddressStr("192.168.0.11"): "bcd",
+			},
+			expectedIPAddresses: []string{},
+			expectError:         true,
+		}),
+	)
+
+	It("Rolls back the addresses of the set when a role fails", func() {
+		ipPool := &ipamv1.IPPool{
+			ObjectMeta: ipPoolMeta,
+			Spec: ipamv1.IPPoolSpec{
+				Pools: []ipamv1.Pool{
+					{
+						Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.11")),
+						End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.20")),
+					},
+				},
+				NamePrefix: "abcpref",
+				Backend: &ipamv1.Backend{
+					NetBox: &ipamv1.NetBoxBackend{URL: "https://netbox.example.com"},
+				},
+			},
+			Status: ipamv1.IPPoolStatus{
+				Allocations: map[string]ipamv1.IPAddressStr{},
+			},
+		}
+		ipClaim := &ipamv1.IPClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "abc",
+			},
+			Spec: ipamv1.IPClaimSpec{
+				Roles: []string{"bmc"},
+			},
+		}
+		c := &failingCreateClient{
+			Client: fakeclient.NewClientBuilder().WithScheme(setupScheme()).Build(),
+			failOn: "abcpref-192-168-0-12",
+		}
+		ipPoolMgr, err := NewIPPoolManager(c, ipPool, klogr.New())
+		Expect(err).NotTo(HaveOccurred())
+		backend := &fakeBackend{}
+		ipPoolMgr.backend = backend
+		ipPoolMgr.reservations = newAddressReservations()
+
+		addresses, err := ipPoolMgr.createAddress(context.TODO(), ipClaim,
+			map[ipamv1.IPAddressStr]string{},
+		)
+		Expect(err).To(HaveOccurred())
+
+		// The IPAddress of the first role is deleted, nothing is allocated
+		addressObjects := ipamv1.IPAddressList{}
+		Expect(c.List(context.TODO(), &addressObjects)).To(Succeed())
+		Expect(addressObjects.Items).To(BeEmpty())
+		Expect(addresses).To(BeEmpty())
+		Expect(ipPool.Status.Allocations).To(BeEmpty())
+		Expect(ipClaim.Status.Address).To(BeNil())
+		Expect(ipClaim.Status.Addresses).To(BeEmpty())
+		// The reservations of both roles are released
+		Expect(backend.released).To(HaveLen(2))
+		_, ok := ipPoolMgr.reservations.reserve(poolName(ipPool), "192.168.0.11", "other")
+		Expect(ok).To(BeTrue())
+	})
+
+	type testCaseAllocateAddress struct {
+		ipPool             *ipamv1.IPPool
+		ipClaim            *ipamv1.IPClaim
+		addresses          map[ipamv1.IPAddressStr]string
+		expectedAddress    ipamv1.IPAddressStr
+		expectedPrefix     int
+		expectedGateway    *ipamv1.IPAddressStr
+		expectedDNSServers []ipamv1.IPAddressStr
+		expectedGroups     map[string]int
+		expectError        bool
+	}
+
+	DescribeTable("Test AllocateAddress",
+		func(tc testCaseAllocateAddress) {
+			ipPoolMgr, err := NewIPPoolManager(nil, tc.ipPool,
+				klogr.New(),
+			)
+			Expect(err).NotTo(HaveOccurred())
+			allocatedAddress, prefix, gateway, dnsServers, err := ipPoolMgr.allocateAddress(
+				tc.ipClaim, tc.addresses,
+			)
+			if tc.expectError {
+				Expect(err).To(HaveOccurred())
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(allocatedAddress).To(Equal(tc.expectedAddress))
+			Expect(prefix).To(Equal(tc.expectedPrefix))
+			Expect(gateway).To(Equal(tc.expectedGateway))
+			Expect(dnsServers).To(Equal(tc.expectedDNSServers))
+			Expect(tc.ipPool.Status.AffinityGroups).To(Equal(tc.expectedGroups))
+		},
+		Entry("Empty pools", testCaseAllocateAddress{
+			ipPool: &ipamv1.IPPool{
+				Spec: ipamv1.IPPoolSpec{},
+			},
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "abc",
+				},
+			},
+			expectError: true,
+		}),
+		Entry("Two pools, first disabled", testCaseAllocateAddress{
+			ipPool: &ipamv1.IPPool{
+				Spec: ipamv1.IPPoolSpec{
+					Pools: []ipamv1.Pool{
+						{
+							Start:    (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.11")),
+							End:      (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.20")),
+							Disabled: true,
+						},
+						{
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.21")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.30")),
+						},
+					},
+					Prefix: 24,
+				},
+			},
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "abc",
+				},
+			},
+			expectedAddress: ipamv1.IPAddressStr("192.168.0.21"),
+			expectedPrefix:  24,
+		}),
+		Entry("One pool, disabled", testCaseAllocateAddress{
+			ipPool: &ipamv1.IPPool{
+				Spec: ipamv1.IPPoolSpec{
+					Pools: []ipamv1.Pool{
+						{
+							Start:    (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.11")),
+							End:      (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.20")),
+							Disabled: true,
+						},
+					},
+					Prefix: 24,
+				},
+			},
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "abc",
+				},
+			},
+			expectError: true,
+		}),
+		Entry("One pool, disabled, pre-allocated", testCaseAllocateAddress{
+			ipPool: &ipamv1.IPPool{
+				Spec: ipamv1.IPPoolSpec{
+					Pools: []ipamv1.Pool{
+						{
+							Start:    (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.11")),
+							End:      (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.20")),
+							Disabled: true,
+						},
+					},
+					PreAllocations: map[string]ipamv1.IPAddressStr{
+						"TestRef": ipamv1.IPAddressStr("192.168.0.15"),
+					},
+					Prefix: 24,
+				},
+			},
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "TestRef",
+				},
+			},
+			expectError: true,
+		}),
+		Entry("One pool, pre-allocated", testCaseAllocateAddress{
+			ipPool: &ipamv1.IPPool{
+				Spec: ipamv1.IPPoolSpec{
+					Pools: []ipamv1.Pool{
+						{
+							Start:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.11")),
+							End:     (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.20")),
+							Prefix:  26,
+							Gateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.1")),
+							DNSServers: []ipamv1.IPAddressStr{
+								ipamv1.IPAddressStr("8.8.8.8"),
+							},
+						},
+						{
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.21")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.30")),
+						},
+					},
+					PreAllocations: map[string]ipamv1.IPAddressStr{
+						"TestRef": ipamv1.IPAddressStr("192.168.0.21"),
+					},
+					Prefix:  24,
+					Gateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.1")),
+					DNSServers: []ipamv1.IPAddressStr{
+						ipamv1.IPAddressStr("8.8.4.4"),
+					},
+				},
+			},
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "TestRef",
+				},
+			},
+			expectedAddress: ipamv1.IPAddressStr("192.168.0.21"),
+			expectedGateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.1")),
+			expectedDNSServers: []ipamv1.IPAddressStr{
+				ipamv1.IPAddressStr("8.8.4.4"),
+			},
+			expectedPrefix: 24,
+		}),
+		Entry("One pool, pre-allocated, allocated to another claim", testCaseAllocateAddress{
+			ipPool: &ipamv1.IPPool{
+				Spec: ipamv1.IPPoolSpec{
+					Pools: []ipamv1.Pool{
+						{
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.11")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.20")),
+						},
+					},
+					PreAllocations: map[string]ipamv1.IPAddressStr{
+						"TestRef": ipamv1.IPAddressStr("192.168.0.15"),
+					},
+					Prefix: 24,
+				},
+			},
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "TestRef",
+				},
+			},
+			addresses: map[ipamv1.IPAddressStr]string{
+				ipamv1.IPAddressStr("192.168.0.15"): "OtherRef",
+			},
+			expectError: true,
+		}),
+		Entry("One pool, pre-allocated, with overrides", testCaseAllocateAddress{
+			ipPool: &ipamv1.IPPool{
+				Spec: ipamv1.IPPoolSpec{
+					Pools: []ipamv1.Pool{
+						{
+							Start:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.11")),
+							End:     (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.20")),
+							Prefix:  26,
+							Gateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.1")),
+							DNSServers: []ipamv1.IPAddressStr{
+								ipamv1.IPAddressStr("8.8.8.8"),
+							},
+						},
+					},
+					PreAllocations: map[string]ipamv1.IPAddressStr{
+						"TestRef": ipamv1.IPAddressStr("192.168.0.15"),
+					},
+					Prefix:  24,
+					Gateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.1")),
+					DNSServers: []ipamv1.IPAddressStr{
+						ipamv1.IPAddressStr("8.8.4.4"),
+					},
+				},
+			},
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "TestRef",
+				},
+			},
+			expectedAddress: ipamv1.IPAddressStr("192.168.0.15"),
+			expectedGateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.1")),
+			expectedDNSServers: []ipamv1.IPAddressStr{
+				ipamv1.IPAddressStr("8.8.8.8"),
+			},
+			expectedPrefix: 26,
+		}),
+		Entry("One pool, pre-allocated, out of bonds", testCaseAllocateAddress{
+			ipPool: &ipamv1.IPPool{
+				Spec: ipamv1.IPPoolSpec{
+					Pools: []ipamv1.Pool{
+						{
+							Start:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.11")),
+							End:     (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.20")),
+							Prefix:  26,
+							Gateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.1")),
+							DNSServers: []ipamv1.IPAddressStr{
+								ipamv1.IPAddressStr("8.8.8.8"),
+							},
+						},
+					},
+					PreAllocations: map[string]ipamv1.IPAddressStr{
+						"TestRef": ipamv1.IPAddressStr("192.168.0.21"),
+					},
+					Prefix:  24,
+					Gateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.1")),
+					DNSServers: []ipamv1.IPAddressStr{
+						ipamv1.IPAddressStr("8.8.4.4"),
+					},
+				},
+			},
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "TestRef",
+				},
+			},
+			expectError: true,
+		}),
+		Entry("One pool, with start and existing address", testCaseAllocateAddress{
+			ipPool: &ipamv1.IPPool{
+				Spec: ipamv1.IPPoolSpec{
+					Pools: []ipamv1.Pool{
+						{
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.11")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.20")),
+						},
+					},
+					Prefix:  24,
+					Gateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.1")),
+				},
+			},
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "TestRef",
+				},
+			},
+			addresses: map[ipamv1.IPAddressStr]string{
+				ipamv1.IPAddressStr("192.168.0.12"): "bcde",
+				ipamv1.IPAddressStr("192.168.0.11"): "abcd",
+			},
+			expectedAddress: ipamv1.IPAddressStr("192.168.0.13"),
+			expectedGateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.1")),
+			expectedPrefix:  24,
+		}),
+		Entry("One pool, with subnet and override prefix", testCaseAllocateAddress{
+			ipPool: &ipamv1.IPPool{
+				Spec: ipamv1.IPPoolSpec{
+					Pools: []ipamv1.Pool{
+						{
+							Start:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.11")),
+							End:     (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.20")),
+							Prefix:  24,
+							Gateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.1")),
+							DNSServers: []ipamv1.IPAddressStr{
+								ipamv1.IPAddressStr("8.8.8.8"),
+							},
+						},
+					},
+					Prefix:  26,
+					Gateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.1")),
+					DNSServers: []ipamv1.IPAddressStr{
+						ipamv1.IPAddressStr("8.8.4.4"),
+					},
+				},
+			},
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "TestRef",
+				},
+			},
+			addresses: map[ipamv1.IPAddressStr]string{
+				ipamv1.IPAddressStr("192.168.0.12"): "bcde",
+				ipamv1.IPAddressStr("192.168.0.11"): "abcd",
+			},
+			expectedAddress: ipamv1.IPAddressStr("192.168.0.13"),
+			expectedGateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.1")),
+			expectedDNSServers: []ipamv1.IPAddressStr{
+				ipamv1.IPAddressStr("8.8.8.8"),
+			},
+			expectedPrefix: 24,
+		}),
+		Entry("two pools, with subnet and override prefix in first", testCaseAllocateAddress{
+			ipPool: &ipamv1.IPPool{
+				Spec: ipamv1.IPPoolSpec{
+					Pools: []ipamv1.Pool{
+						{
+							Start:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+							End:     (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+							Prefix:  24,
+							Gateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.1")),
+							DNSServers: []ipamv1.IPAddressStr{
+								ipamv1.IPAddressStr("8.8.8.8"),
+							},
+						},
+						{
+							Subnet: (*ipamv1.IPSubnetStr)(pointer.StringPtr("192.168.1.10/24")),
+						},
+					},
+					Prefix:  26,
+					Gateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.2.1")),
+					DNSServers: []ipamv1.IPAddressStr{
+						ipamv1.IPAddressStr("8.8.4.4"),
+					},
+				},
+			},
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "TestRef",
+				},
+			},
+			addresses: map[ipamv1.IPAddressStr]string{
+				ipamv1.IPAddressStr("192.168.1.11"): "bcde",
+				ipamv1.IPAddressStr("192.168.0.10"): "abcd",
+			},
+			expectedAddress: ipamv1.IPAddressStr("192.168.1.12"),
+			expectedDNSServers: []ipamv1.IPAddressStr{
+				ipamv1.IPAddressStr("8.8.4.4"),
+			},
+			expectedPrefix: 24,
+		}),
+		Entry("two pools, with subnet and override prefix", testCaseAllocateAddress{
+			ipPool: &ipamv1.IPPool{
+				Spec: ipamv1.IPPoolSpec{
+					Pools: []ipamv1.Pool{
+						{
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+						},
+						{
+							Subnet:  (*ipamv1.IPSubnetStr)(pointer.StringPtr("192.168.1.10/24")),
+							Prefix:  24,
+							Gateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.1")),
+							DNSServers: []ipamv1.IPAddressStr{
+								ipamv1.IPAddressStr("8.8.8.8"),
+							},
+						},
+					},
+					Prefix:  26,
+					Gateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.2.1")),
+					DNSServers: []ipamv1.IPAddressStr{
+						ipamv1.IPAddressStr("8.8.4.4"),
+					},
+				},
+			},
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "TestRef",
+				},
+			},
+			addresses: map[ipamv1.IPAddressStr]string{
+				ipamv1.IPAddressStr("192.168.1.11"): "bcde",
+				ipamv1.IPAddressStr("192.168.0.10"): "abcd",
+			},
+			expectedAddress: ipamv1.IPAddressStr("192.168.1.12"),
+			expectedGateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.1")),
+			expectedDNSServers: []ipamv1.IPAddressStr{
+				ipamv1.IPAddressStr("8.8.8.8"),
+			},
+			expectedPrefix: 24,
+		}),
+		Entry("two pools, with claim subnet", testCaseAllocateAddress{
+			ipPool: &ipamv1.IPPool{
+				Spec: ipamv1.IPPoolSpec{
+					Pools: []ipamv1.Pool{
+						{
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.20")),
+						},
+						{
+							Subnet: (*ipamv1.IPSubnetStr)(pointer.StringPtr("192.168.1.0/24")),
+						},
+					},
+					Prefix:  16,
+					Gateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.1")),
+				},
+			},
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "TestRef",
+				},
+				Spec: ipamv1.IPClaimSpec{
+					Subnet: (*ipamv1.IPSubnetStr)(pointer.StringPtr("192.168.1.64/26")),
+				},
+			},
+			addresses: map[ipamv1.IPAddressStr]string{
+				ipamv1.IPAddressStr("192.168.1.64"): "bcde",
+			},
+			expectedAddress: ipamv1.IPAddressStr("192.168.1.65"),
+			expectedPrefix:  24,
+		}),
+		Entry("One pool with subnet, gateway of the IPPool in the subnet", testCaseAllocateAddress{
+			ipPool: &ipamv1.IPPool{
+				Spec: ipamv1.IPPoolSpec{
+					Pools: []ipamv1.Pool{
+						{
+							Subnet: (*ipamv1.IPSubnetStr)(pointer.StringPtr("192.168.1.0/25")),
+						},
+					},
+					Prefix:  24,
+					Gateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.1")),
+				},
+			},
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "TestRef",
+				},
+			},
+			addresses: map[ipamv1.IPAddressStr]string{
+				ipamv1.IPAddressStr("192.168.1.1"): "gateway",
+			},
+			expectedAddress: ipamv1.IPAddressStr("192.168.1.2"),
+			expectedGateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.1")),
+			expectedPrefix:  25,
+		}),
+		Entry("One pool with subnet, network and broadcast addresses skipped", testCaseAllocateAddress{
+			ipPool: &ipamv1.IPPool{
+				Spec: ipamv1.IPPoolSpec{
+					Pools: []ipamv1.Pool{
+						{
+							Start:  (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.254")),
+							Subnet: (*ipamv1.IPSubnetStr)(pointer.StringPtr("192.168.1.0/24")),
+						},
+						{
+							Start:  (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.2.0")),
+							Subnet: (*ipamv1.IPSubnetStr)(pointer.StringPtr("192.168.2.0/24")),
+						},
+					},
+				},
+			},
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "TestRef",
+				},
+			},
+			addresses: map[ipamv1.IPAddressStr]string{
+				ipamv1.IPAddressStr("192.168.1.254"): "bcde",
+			},
+			expectedAddress: ipamv1.IPAddressStr("192.168.2.1"),
+			expectedPrefix:  24,
+		}),
+		Entry("One pool, claim subnet overlapping the start", testCaseAllocateAddress{
+			ipPool: &ipamv1.IPPool{
+				Spec: ipamv1.IPPoolSpec{
+					Pools: []ipamv1.Pool{
+						{
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.20")),
+						},
+					},
+					Prefix:  24,
+					Gateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.1")),
+				},
+			},
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "TestRef",
+				},
+				Spec: ipamv1.IPClaimSpec{
+					Subnet: (*ipamv1.IPSubnetStr)(pointer.StringPtr("192.168.0.0/28")),
+				},
+			},
+			addresses: map[ipamv1.IPAddressStr]string{
+				ipamv1.IPAddressStr("192.168.0.10"): "bcde",
+			},
+			expectedAddress: ipamv1.IPAddressStr("192.168.0.11"),
+			expectedGateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.1")),
+			expectedPrefix:  24,
+		}),
+		Entry("Exhausted claim subnet", testCaseAllocateAddress{
+			ipPool: &ipamv1.IPPool{
+				Spec: ipamv1.IPPoolSpec{
+					Pools: []ipamv1.Pool{
+						{
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.20")),
+						},
+					},
+					Prefix:  24,
+					Gateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.1")),
+				},
+			},
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "TestRef",
+				},
+				Spec: ipamv1.IPClaimSpec{
+					Subnet: (*ipamv1.IPSubnetStr)(pointer.StringPtr("192.168.0.8/30")),
+				},
+			},
+			addresses: map[ipamv1.IPAddressStr]string{
+				ipamv1.IPAddressStr("192.168.0.10"): "bcde",
+				ipamv1.IPAddressStr("192.168.0.11"): "abcd",
+			},
+			expectError: true,
+		}),
+		Entry("Claim subnet not overlapping", testCaseAllocateAddress{
+			ipPool: &ipamv1.IPPool{
+				Spec: ipamv1.IPPoolSpec{
+					Pools: []ipamv1.Pool{
+						{
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.20")),
+						},
+					},
+				},
+			},
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "TestRef",
+				},
+				Spec: ipamv1.IPClaimSpec{
+					Subnet: (*ipamv1.IPSubnetStr)(pointer.StringPtr("192.168.1.0/24")),
+				},
+			},
+			expectError: true,
+		}),
+		Entry("Pre-allocated out of claim subnet", testCaseAllocateAddress{
+			ipPool: &ipamv1.IPPool{
+				Spec: ipamv1.IPPoolSpec{
+					Pools: []ipamv1.Pool{
+						{
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.20")),
+						},
+					},
+					PreAllocations: map[string]ipamv1.IPAddressStr{
+						"TestRef": ipamv1.IPAddressStr("192.168.0.12"),
+					},
+				},
+			},
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "TestRef",
+				},
+				Spec: ipamv1.IPClaimSpec{
+					Subnet: (*ipamv1.IPSubnetStr)(pointer.StringPtr("192.168.0.16/28")),
+				},
+			},
+			expectError: true,
+		}),
+		Entry("One pool, with claim prefix override", testCaseAllocateAddress{
+			ipPool: &ipamv1.IPPool{
+				Spec: ipamv1.IPPoolSpec{
+					Pools: []ipamv1.Pool{
+						{
+							Start:  (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.11")),
+							End:    (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.20")),
+							Prefix: 25,
+						},
+					},
+					Prefix:  24,
+					Gateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.1")),
+				},
+			},
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "TestRef",
+					Annotations: map[string]string{
+						ipamv1.PrefixAnnotation: "28",
+					},
+				},
+				Spec: ipamv1.IPClaimSpec{
+					Prefix: pointer.IntPtr(26),
+				},
+			},
+			expectedAddress: ipamv1.IPAddressStr("192.168.0.11"),
+			expectedGateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.1")),
+			expectedPrefix:  26,
+		}),
+		Entry("One pool, with claim prefix annotation", testCaseAllocateAddress{
+			ipPool: &ipamv1.IPPool{
+				Spec: ipamv1.IPPoolSpec{
+					Pools: []ipamv1.Pool{
+						{
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.11")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.20")),
+						},
+					},
+					Prefix:  24,
+					Gateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.1")),
+				},
+			},
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "TestRef",
+					Annotations: map[string]string{
+						ipamv1.PrefixAnnotation: "28",
+					},
+				},
+			},
+			expectedAddress: ipamv1.IPAddressStr("192.168.0.11"),
+			expectedGateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.1")),
+			expectedPrefix:  28,
+		}),
+		Entry("One pool, with invalid claim prefix override", testCaseAllocateAddress{
+			ipPool: &ipamv1.IPPool{
+				Spec: ipamv1.IPPoolSpec{
+					Pools: []ipamv1.Pool{
+						{
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.11")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.20")),
+						},
+					},
+					Prefix:  24,
+					Gateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.1")),
+				},
+			},
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "TestRef",
+				},
+				Spec: ipamv1.IPClaimSpec{
+					Prefix: pointer.IntPtr(64),
+				},
+			},
+			expectError: true,
+		}),
+		Entry("Affinity group, not bound", testCaseAllocateAddress{
+			ipPool: &ipamv1.IPPool{
+				Spec: ipamv1.IPPoolSpec{
+					Pools: []ipamv1.Pool{
+						{
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.11")),
+						},
+						{
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.10")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.20")),
+						},
+					},
+					Prefix:  16,
+					Gateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.1")),
+				},
+			},
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "TestRef",
+				},
+				Spec: ipamv1.IPClaimSpec{
+					AffinityGroup: "storage",
+				},
+			},
+			addresses: map[ipamv1.IPAddressStr]string{
+				ipamv1.IPAddressStr("192.168.0.10"): "abcd",
+				ipamv1.IPAddressStr("192.168.0.11"): "bcde",
+			},
+			expectedAddress: ipamv1.IPAddressStr("192.168.1.10"),
+			expectedGateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.1")),
+			expectedPrefix:  16,
+			expectedGroups: map[string]int{
+				"storage": 1,
+			},
+		}),
+		Entry("Affinity group, bound", testCaseAllocateAddress{
+			ipPool: &ipamv1.IPPool{
+				Spec: ipamv1.IPPoolSpec{
+					Pools: []ipamv1.Pool{
+						{
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.11")),
+						},
+						{
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.10")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.20")),
+						},
+					},
+					Prefix:  16,
+					Gateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.1")),
+				},
+				Status: ipamv1.IPPoolStatus{
+					AffinityGroups: map[string]int{
+						"storage": 1,
+					},
+				},
+			},
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "TestRef",
+				},
+				Spec: ipamv1.IPClaimSpec{
+					AffinityGroup: "storage",
+				},
+			},
+			expectedAddress: ipamv1.IPAddressStr("192.168.1.10"),
+			expectedGateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.1")),
+			expectedPrefix:  16,
+			expectedGroups: map[string]int{
+				"storage": 1,
+			},
+		}),
+		Entry("Affinity group, bound pool exhausted", testCaseAllocateAddress{
+			ipPool: &ipamv1.IPPool{
+				Spec: ipamv1.IPPoolSpec{
+					Pools: []ipamv1.Pool{
+						{
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.11")),
+						},
+						{
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.10")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.20")),
+						},
+					},
+					Prefix:  16,
+					Gateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.1")),
+				},
+				Status: ipamv1.IPPoolStatus{
+					AffinityGroups: map[string]int{
+						"storage": 0,
+					},
+				},
+			},
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "TestRef",
+				},
+				Spec: ipamv1.IPClaimSpec{
+					AffinityGroup: "storage",
+				},
+			},
+			addresses: map[ipamv1.IPAddressStr]string{
+				ipamv1.IPAddressStr("192.168.0.10"): "abcd",
+				ipamv1.IPAddressStr("192.168.0.11"): "bcde",
+			},
+			expectError: true,
+		}),
+		Entry("Exhausted pools start", testCaseAllocateAddress{
+			ipPool: &ipamv1.IPPool{
+				Spec: ipamv1.IPPoolSpec{
+					Pools: []ipamv1.Pool{
+						{
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+						},
+					},
+					Prefix:  24,
+					Gateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.1")),
+				},
+			},
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "TestRef",
+				},
+			},
+			addresses: map[ipamv1.IPAddressStr]string{
+				ipamv1.IPAddressStr("192.168.0.10"): "abcd",
+			},
+			expectError: true,
+		}),
+		Entry("Exhausted pools subnet", testCaseAllocateAddress{
+			ipPool: &ipamv1.IPPool{
+				Spec: ipamv1.IPPoolSpec{
+					Pools: []ipamv1.Pool{
+						{
+							Subnet: (*ipamv1.IPSubnetStr)(pointer.StringPtr("192.168.0.0/30")),
+						},
+					},
+					Prefix:  24,
+					Gateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.1")),
+				},
+			},
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "TestRef",
+				},
+			},
+			addresses: map[ipamv1.IPAddressStr]string{
+				ipamv1.IPAddressStr("192.168.0.1"): "abcd",
+				ipamv1.IPAddressStr("192.168.0.2"): "abcd",
+				ipamv1.IPAddressStr("192.168.0.3"): "abcd",
+			},
+			expectError: true,
+		}),
+	)
+
+	type testCasePreferredAddress struct {
+		preferred            *ipamv1.PreferredAddress
+		subnet               *ipamv1.IPSubnetStr
+		addresses            map[ipamv1.IPAddressStr]string
+		expectedAddress      ipamv1.IPAddressStr
+		expectedErrorMessage *string
+	}
+
+	DescribeTable("Test allocateRoleAddress with a preferred address",
+		func(tc testCasePreferredAddress) {
+			ipPool := &ipamv1.IPPool{
+				Spec: ipamv1.IPPoolSpec{
+					Pools: []ipamv1.Pool{
+						{
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.20")),
+						},
+						{
+							Start:    (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.10")),
+							End:      (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.20")),
+							Disabled: true,
+						},
+					},
+				},
+			}
+			ipClaim := &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "abc",
+				},
+				Spec: ipamv1.IPClaimSpec{
+					Subnet:           tc.subnet,
+					PreferredAddress: tc.preferred,
+				},
+			}
+			addresses := tc.addresses
+			if addresses == nil {
+				addresses = map[ipamv1.IPAddressStr]string{}
+			}
+			ipPoolMgr, err := NewIPPoolManager(nil, ipPool, klogr.New())
+			Expect(err).NotTo(HaveOccurred())
+			allocation, _, err := ipPoolMgr.allocateRoleAddress(ipClaim, "", addresses, anyPool)
+			Expect(ipClaim.Status.ErrorMessage).To(Equal(tc.expectedErrorMessage))
+			if tc.expectedErrorMessage != nil {
+				Expect(err).To(HaveOccurred())
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(allocation.address).To(Equal(tc.expectedAddress))
+			Expect(ipClaim.Spec.PreferredAddress).To(Equal(tc.preferred))
+		},
+		Entry("No preferred address", testCasePreferredAddress{
+			expectedAddress: "192.168.0.10",
+		}),
+		Entry("Preferred address available", testCasePreferredAddress{
+			preferred:       &ipamv1.PreferredAddress{Address: "192.168.0.15"},
+			expectedAddress: "192.168.0.15",
+		}),
+		Entry("Preferred address already allocated to the claim", testCasePreferredAddress{
+			preferred: &ipamv1.PreferredAddress{Address: "192.168.0.15", Strict: true},
+			addresses: map[ipamv1.IPAddressStr]string{
+				"192.168.0.15": "abc",
+			},
+			expectedAddress: "192.168.0.15",
+		}),
+		Entry("Preferred address taken", testCasePreferredAddress{
+			preferred: &ipamv1.PreferredAddress{Address: "192.168.0.15"},
+			addresses: map[ipamv1.IPAddressStr]string{
+				"192.168.0.15": "bcd",
+			},
+			expectedAddress: "192.168.0.10",
+		}),
+		Entry("Strict preferred address taken", testCasePreferredAddress{
+			preferred: &ipamv1.PreferredAddress{Address: "192.168.0.15", Strict: true},
+			addresses: map[ipamv1.IPAddressStr]string{
+				"192.168.0.15": "bcd",
+			},
+			expectedErrorMessage: pointer.StringPtr("Preferred IP 192.168.0.15 allocated to bcd"),
+		}),
+		Entry("Strict preferred address reserved", testCasePreferredAddress{
+			preferred: &ipamv1.PreferredAddress{Address: "192.168.0.15", Strict: true},
+			addresses: map[ipamv1.IPAddressStr]string{
+				"192.168.0.15": "",
+			},
+			expectedErrorMessage: pointer.StringPtr("Preferred IP 192.168.0.15 pre-allocated or reserved"),
+		}),
+		Entry("Preferred address in a disabled pool", testCasePreferredAddress{
+			preferred:       &ipamv1.PreferredAddress{Address: "192.168.1.15"},
+			expectedAddress: "192.168.0.10",
+		}),
+		Entry("Strict preferred address out of the pools", testCasePreferredAddress{
+			preferred:            &ipamv1.PreferredAddress{Address: "192.168.2.15", Strict: true},
+			expectedErrorMessage: pointer.StringPtr("Preferred IP 192.168.2.15 out of the available pools"),
+		}),
+		Entry("Preferred address out of the claim subnet", testCasePreferredAddress{
+			preferred:       &ipamv1.PreferredAddress{Address: "192.168.0.11"},
+			subnet:          (*ipamv1.IPSubnetStr)(pointer.StringPtr("192.168.0.16/30")),
+			expectedAddress: "192.168.0.16",
+		}),
+	)
+
+	type testCaseAllocationStrategy struct {
+		strategy          ipamv1.AllocationStrategy
+		randomIndex       int64
+		addresses         map[ipamv1.IPAddressStr]string
+		releasedAddresses map[string]metav1.Time
+		expectedAddress   ipamv1.IPAddressStr
+		expectError       bool
+	}
+
+	DescribeTable("Test allocateRoleAddress with an allocation strategy",
+		func(tc testCaseAllocationStrategy) {
+			ipPool := &ipamv1.IPPool{
+				Spec: ipamv1.IPPoolSpec{
+					Pools: []ipamv1.Pool{
+						{
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.14")),
+						},
+					},
+					AllocationStrategy: tc.strategy,
+				},
+				Status: ipamv1.IPPoolStatus{
+					ReleasedAddresses: tc.releasedAddresses,
+				},
+			}
+			addresses := tc.addresses
+			if addresses == nil {
+				addresses = map[ipamv1.IPAddressStr]string{}
+			}
+			ipPoolMgr, err := NewIPPoolManager(nil, ipPool, klogr.New())
+			Expect(err).NotTo(HaveOccurred())
+			ipPoolMgr.randomIndex = func(size int64) int64 {
+				Expect(size).To(Equal(int64(5)))
+				return tc.randomIndex
+			}
+			allocation, _, err := ipPoolMgr.allocateRoleAddress(&ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "abc",
+				},
+			}, "", addresses, anyPool)
+			if tc.expectError {
+				Expect(err).To(HaveOccurred())
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(allocation.address).To(Equal(tc.expectedAddress))
+		},
+		Entry("Sequential", testCaseAllocationStrategy{
+			randomIndex:     3,
+			expectedAddress: "192.168.0.10",
+		}),
+		Entry("Random", testCaseAllocationStrategy{
+			strategy:        ipamv1.AllocationStrategyRandom,
+			randomIndex:     3,
+			expectedAddress: "192.168.0.13",
+		}),
+		Entry("Random, wrapping around", testCaseAllocationStrategy{
+			strategy:    ipamv1.AllocationStrategyRandom,
+			randomIndex: 3,
+			addresses: map[ipamv1.IPAddressStr]string{
+				"192.168.0.10": "bcd",
+				"192.168.0.13": "cde",
+				"192.168.0.14": "def",
+			},
+			expectedAddress: "192.168.0.11",
+		}),
+		Entry("Random, exhausted", testCaseAllocationStrategy{
+			strategy:    ipamv1.AllocationStrategyRandom,
+			randomIndex: 3,
+			addresses: map[ipamv1.IPAddressStr]string{
+				"192.168.0.10": "bcd",
+				"192.168.0.11": "cde",
+				"192.168.0.12": "def",
+				"192.168.0.13": "efg",
+				"192.168.0.14": "fgh",
+			},
+			expectError: true,
+		}),
+		Entry("Least recently used, address never released", testCaseAllocationStrategy{
+			strategy: ipamv1.AllocationStrategyLeastRecentlyUsed,
+			releasedAddresses: map[string]metav1.Time{
+				"192.168.0.10": metav1.NewTime(time.Now().Add(-time.Hour)),
+				"192.168.0.11": metav1.NewTime(time.Now().Add(-2 * time.Hour)),
+			},
+			expectedAddress: "192.168.0.12",
+		}),
+		Entry("Least recently used, all addresses released", testCaseAllocationStrategy{
+			strategy: ipamv1.AllocationStrategyLeastRecentlyUsed,
+			addresses: map[ipamv1.IPAddressStr]string{
+				"192.168.0.12": "bcd",
+				"192.168.0.13": "cde",
+				"192.168.0.14": "def",
+			},
+			releasedAddresses: map[string]metav1.Time{
+				"192.168.0.10": metav1.NewTime(time.Now().Add(-time.Hour)),
+				"192.168.0.11": metav1.NewTime(time.Now().Add(-2 * time.Hour)),
+			},
+			expectedAddress: "192.168.0.11",
+		}),
+		Entry("Hash", testCaseAllocationStrategy{
+			strategy:        ipamv1.AllocationStrategyHash,
+			randomIndex:     3,
+			expectedAddress: "192.168.0.11",
+		}),
+		Entry("Hash, wrapping around", testCaseAllocationStrategy{
+			strategy: ipamv1.AllocationStrategyHash,
+			addresses: map[ipamv1.IPAddressStr]string{
+				"192.168.0.11": "bcd",
+				"192.168.0.12": "cde",
+				"192.168.0.13": "def",
+				"192.168.0.14": "efg",
+			},
+			expectedAddress: "192.168.0.10",
+		}),
+		Entry("Sequential, release times ignored", testCaseAllocationStrategy{
+			releasedAddresses: map[string]metav1.Time{
+				"192.168.0.10": metav1.NewTime(time.Now().Add(-time.Hour)),
+			},
+			expectedAddress: "192.168.0.10",
+		}),
+	)
+
+	DescribeTable("Test recordRelease and forgetReleasedAddresses",
+		func(strategy ipamv1.AllocationStrategy, expectRecorded bool) {
+			ipPool := &ipamv1.IPPool{
+				Spec: ipamv1.IPPoolSpec{
+					AllocationStrategy: strategy,
+				},
+				Status: ipamv1.IPPoolStatus{
+					ReleasedAddresses: map[string]metav1.Time{
+						"192.168.0.10": metav1.NewTime(time.Now().Add(-time.Hour)),
+					},
+				},
+			}
+			ipPoolMgr, err := NewIPPoolManager(nil, ipPool, klogr.New())
+			Expect(err).NotTo(HaveOccurred())
+			ipPoolMgr.recordRelease("192.168.0.11")
+			ipPoolMgr.forgetReleasedAddresses()
+			if expectRecorded {
+				Expect(ipPool.Status.ReleasedAddresses).To(HaveLen(2))
+				Expect(ipPool.Status.ReleasedAddresses).To(HaveKey("192.168.0.11"))
+			} else {
+				Expect(ipPool.Status.ReleasedAddresses).To(BeNil())
+			}
+		},
+		Entry("Least recently used", ipamv1.AllocationStrategyLeastRecentlyUsed, true),
+		Entry("Random", ipamv1.AllocationStrategyRandom, false),
+		Entry("Default", ipamv1.AllocationStrategy(""), false),
+	)
+
+	type testCaseMACReservation struct {
+		macAddress           string
+		addresses            map[ipamv1.IPAddressStr]string
+		expectedAddress      ipamv1.IPAddressStr
+		expectedErrorMessage *string
+	}
+
+	DescribeTable("Test allocateRoleAddress with MAC reservations",
+		func(tc testCaseMACReservation) {
+			ipPool := &ipamv1.IPPool{
+				Spec: ipamv1.IPPoolSpec{
+					Pools: []ipamv1.Pool{
+						{
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.14")),
+						},
+					},
+					MACReservations: map[string]ipamv1.IPAddressStr{
+						"52:54:00:aa:bb:01": "192.168.0.12",
+					},
+				},
+			}
+			ipClaim := &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "abc",
+				},
+			}
+			if tc.macAddress != "" {
+				ipClaim.Annotations = map[string]string{ipamv1.MACAddressAnnotation: tc.macAddress}
+			}
+			// The reserved addresses are taken, as listed by getIndexes
+			addresses := map[ipamv1.IPAddressStr]string{"192.168.0.12": ""}
+			for address, owner := range tc.addresses {
+				addresses[address] = owner
+			}
+			ipPoolMgr, err := NewIPPoolManager(nil, ipPool, klogr.New())
+			Expect(err).NotTo(HaveOccurred())
+			allocation, _, err := ipPoolMgr.allocateRoleAddress(ipClaim, "", addresses, anyPool)
+			Expect(ipClaim.Status.ErrorMessage).To(Equal(tc.expectedErrorMessage))
+			if tc.expectedErrorMessage != nil {
+				Expect(err).To(HaveOccurred())
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(allocation.address).To(Equal(tc.expectedAddress))
+		},
+		Entry("Claim with the reserved MAC address", testCaseMACReservation{
+			macAddress:      "52-54-00-AA-BB-01",
+			expectedAddress: "192.168.0.12",
+		}),
+		Entry("Claim without MAC address", testCaseMACReservation{
+			addresses: map[ipamv1.IPAddressStr]string{
+				"192.168.0.10": "bcd",
+				"192.168.0.11": "cde",
+			},
+			expectedAddress: "192.168.0.13",
+		}),
+		Entry("Claim with another MAC address", testCaseMACReservation{
+			macAddress:      "52:54:00:aa:bb:02",
+			expectedAddress: "192.168.0.10",
+		}),
+		Entry("Reserved address allocated to another claim", testCaseMACReservation{
+			macAddress: "52:54:00:aa:bb:01",
+			addresses: map[ipamv1.IPAddressStr]string{
+				"192.168.0.12": "bcd",
+			},
+			expectedErrorMessage: pointer.StringPtr("Pre-allocated IP already allocated to bcd"),
+		}),
+		Entry("Invalid MAC address", testCaseMACReservation{
+			macAddress:           "52:54:00",
+			expectedErrorMessage: pointer.StringPtr("Invalid MAC address"),
+		}),
+	)
+
+	type testCaseEUI64 struct {
+		macAddress           string
+		start                string
+		addresses            map[ipamv1.IPAddressStr]string
+		expectedAddress      ipamv1.IPAddressStr
+		expectedErrorMessage *string
+	}
+
+	DescribeTable("Test allocateRoleAddress with the EUI64 strategy",
+		func(tc testCaseEUI64) {
+			pool := ipamv1.Pool{
+				Subnet: (*ipamv1.IPSubnetStr)(pointer.StringPtr("2001:db8::/64")),
+			}
+			if tc.start != "" {
+				pool.Start = (*ipamv1.IPAddressStr)(pointer.StringPtr(tc.start))
+			}
+			ipPool := &ipamv1.IPPool{
+				Spec: ipamv1.IPPoolSpec{
+					Pools:              []ipamv1.Pool{pool},
+					AllocationStrategy: ipamv1.AllocationStrategyEUI64,
+				},
+			}
+			ipClaim := &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "abc",
+				},
+			}
+			if tc.macAddress != "" {
+				ipClaim.Annotations = map[string]string{ipamv1.MACAddressAnnotation: tc.macAddress}
+			}
+			addresses := map[ipamv1.IPAddressStr]string{}
+			for address, owner := range tc.addresses {
+				addresses[address] = owner
+			}
+			ipPoolMgr, err := NewIPPoolManager(nil, ipPool, klogr.New())
+			Expect(err).NotTo(HaveOccurred())
+			allocation, _, err := ipPoolMgr.allocateRoleAddress(ipClaim, "", addresses, anyPool)
+			Expect(ipClaim.Status.ErrorMessage).To(Equal(tc.expectedErrorMessage))
+			if tc.expectedErrorMessage != nil {
+				Expect(err).To(HaveOccurred())
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(allocation.address).To(Equal(tc.expectedAddress))
+		},
+		Entry("Claim with a MAC address", testCaseEUI64{
+			macAddress:      "52:54:00:aa:bb:01",
+			expectedAddress: "2001:db8::5054:ff:feaa:bb01",
+		}),
+		Entry("Claim with an EUI-64 address", testCaseEUI64{
+			macAddress:      "02:00:5e:10:00:00:00:01",
+			expectedAddress: "2001:db8::5e10:0:1",
+		}),
+		Entry("Claim without MAC address", testCaseEUI64{
+			addresses: map[ipamv1.IPAddressStr]string{
+				"2001:db8::1": "bcd",
+			},
+			expectedAddress: "2001:db8::2",
+		}),
+		Entry("Derived address allocated to another claim", testCaseEUI64{
+			macAddress: "52:54:00:aa:bb:01",
+			addresses: map[ipamv1.IPAddressStr]string{
+				"2001:db8::5054:ff:feaa:bb01": "bcd",
+			},
+			expectedErrorMessage: pointer.StringPtr("Pre-allocated IP already allocated to bcd"),
+		}),
+		Entry("Derived address out of the pool range", testCaseEUI64{
+			macAddress:           "52:54:00:aa:bb:01",
+			start:                "2001:db8::ffff:0:0:0",
+			expectedErrorMessage: pointer.StringPtr("Pre-allocated IP out of bond"),
+		}),
+		Entry("Invalid MAC address", testCaseEUI64{
+			macAddress:           "52:54:00",
+			expectedErrorMessage: pointer.StringPtr("Invalid MAC address"),
+		}),
+	)
+
+	type testCaseExcludedRanges struct {
+		preAllocation        ipamv1.IPAddressStr
+		addresses            map[ipamv1.IPAddressStr]string
+		expectedAddress      ipamv1.IPAddressStr
+		expectedErrorMessage *string
+	}
+
+	DescribeTable("Test allocateRoleAddress with excluded ranges",
+		func(tc testCaseExcludedRanges) {
+			ipPool := &ipamv1.IPPool{
+				Spec: ipamv1.IPPoolSpec{
+					Pools: []ipamv1.Pool{
+						{
+							Subnet: (*ipamv1.IPSubnetStr)(pointer.StringPtr("192.168.0.0/28")),
+							Exclude: []ipamv1.ExcludedRange{
+								{Start: "192.168.0.1"},
+								{
+									Start: "192.168.0.3",
+									End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.13")),
+								},
+							},
+						},
+					},
+				},
+			}
+			if tc.preAllocation != "" {
+				ipPool.Spec.PreAllocations = map[string]ipamv1.IPAddressStr{"abc": tc.preAllocation}
+			}
+			ipClaim := &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "abc",
+				},
+			}
+			addresses := map[ipamv1.IPAddressStr]string{}
+			for address, owner := range tc.addresses {
+				addresses[address] = owner
+			}
+			ipPoolMgr, err := NewIPPoolManager(nil, ipPool, klogr.New())
+			Expect(err).NotTo(HaveOccurred())
+			allocation, _, err := ipPoolMgr.allocateRoleAddress(ipClaim, "", addresses, anyPool)
+			Expect(ipClaim.Status.ErrorMessage).To(Equal(tc.expectedErrorMessage))
+			if tc.expectedErrorMessage != nil {
+				Expect(err).To(HaveOccurred())
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(allocation.address).To(Equal(tc.expectedAddress))
+		},
+		Entry("First address after an excluded address", testCaseExcludedRanges{
+			expectedAddress: "192.168.0.2",
+		}),
+		Entry("First address after an excluded range", testCaseExcludedRanges{
+			addresses: map[ipamv1.IPAddressStr]string{
+				"192.168.0.2": "bcd",
+			},
+			expectedAddress: "192.168.0.14",
+		}),
+		Entry("Excluded addresses only left", testCaseExcludedRanges{
+			addresses: map[ipamv1.IPAddressStr]string{
+				"192.168.0.2":  "bcd",
+				"192.168.0.14": "cde",
+			},
+			expectedErrorMessage: pointer.StringPtr("Exhausted IP Pools"),
+		}),
+		Entry("Excluded pre-allocated address", testCaseExcludedRanges{
+			preAllocation:        "192.168.0.5",
+			expectedErrorMessage: pointer.StringPtr("Pre-allocated IP out of bond"),
+		}),
+	)
+
+	It("Skips the excluded ranges of an IPv6 subnet at once", func() {
+		ipPool := &ipamv1.IPPool{
+			Spec: ipamv1.IPPoolSpec{
+				Pools: []ipamv1.Pool{
+					{
+						Subnet: (*ipamv1.IPSubnetStr)(pointer.StringPtr("2001:db8::/64")),
+						Exclude: []ipamv1.ExcludedRange{
+							{
+								Start: "2001:db8::1",
+								End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("2001:db8::ffff:ffff:ffff")),
+							},
+							{
+								Start: "2001:db8::1:0:0:0",
+								End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("2001:db8::1:0:0:ff")),
+							},
+						},
+					},
+				},
+				AllocationStrategy: ipamv1.AllocationStrategyRandom,
+			},
+		}
+		ipPoolMgr, err := NewIPPoolManager(nil, ipPool, klogr.New())
+		Expect(err).NotTo(HaveOccurred())
+		ipPoolMgr.randomIndex = func(size int64) int64 {
+			Expect(size).To(Equal(int64(ipamv1.MaxPoolIndex)))
+			return 0x800
+		}
+		allocation, _, err := ipPoolMgr.allocateRoleAddress(&ipamv1.IPClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "abc"},
+		}, "", map[ipamv1.IPAddressStr]string{}, anyPool)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(allocation.address).To(Equal(ipamv1.IPAddressStr("2001:db8::1:0:0:100")))
+	})
+
+	type testCaseNetworkAddresses struct {
+		allocateNetworkAndBroadcast bool
+		gateway                     *ipamv1.IPAddressStr
+		poolGateway                 *ipamv1.IPAddressStr
+		preAllocation               ipamv1.IPAddressStr
+		addresses                   map[ipamv1.IPAddressStr]string
+		expectedAddress             ipamv1.IPAddressStr
+		expectedErrorMessage        *string
+	}
+
+	DescribeTable("Test allocateRoleAddress with the network, broadcast and gateway addresses",
+		func(tc testCaseNetworkAddresses) {
+			ipPool := &ipamv1.IPPool{
+				Spec: ipamv1.IPPoolSpec{
+					Pools: []ipamv1.Pool{
+						{
+							Subnet:                      (*ipamv1.IPSubnetStr)(pointer.StringPtr("192.168.0.0/29")),
+							AllocateNetworkAndBroadcast: tc.allocateNetworkAndBroadcast,
+							Gateway:                     tc.poolGateway,
+						},
+					},
+					Gateway: tc.gateway,
+				},
+			}
+			if tc.preAllocation != "" {
+				ipPool.Spec.PreAllocations = map[string]ipamv1.IPAddressStr{"abc": tc.preAllocation}
+			}
+			ipClaim := &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "abc",
+				},
+			}
+			addresses := map[ipamv1.IPAddressStr]string{}
+			for address, owner := range tc.addresses {
+				addresses[address] = owner
+			}
+			ipPoolMgr, err := NewIPPoolManager(nil, ipPool, klogr.New())
+			Expect(err).NotTo(HaveOccurred())
+			allocation, _, err := ipPoolMgr.allocateRoleAddress(ipClaim, "", addresses, anyPool)
+			Expect(ipClaim.Status.ErrorMessage).To(Equal(tc.expectedErrorMessage))
+			if tc.expectedErrorMessage != nil {
+				Expect(err).To(HaveOccurred())
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(allocation.address).To(Equal(tc.expectedAddress))
+		},
+		Entry("Default gateway skipped", testCaseNetworkAddresses{
+			gateway:         (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.1")),
+			expectedAddress: "192.168.0.2",
+		}),
+		Entry("Pool gateway skipped", testCaseNetworkAddresses{
+			gateway:     (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.1")),
+			poolGateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.2")),
+			addresses: map[ipamv1.IPAddressStr]string{
+				"192.168.0.1": "bcd",
+			},
+			expectedAddress: "192.168.0.3",
+		}),
+		Entry("Default gateway out of the subnet", testCaseNetworkAddresses{
+			gateway:         (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.1")),
+			expectedAddress: "192.168.0.1",
+		}),
+		Entry("Network address allocated", testCaseNetworkAddresses{
+			allocateNetworkAndBroadcast: true,
+			expectedAddress:             "192.168.0.0",
+		}),
+		Entry("Broadcast address allocated", testCaseNetworkAddresses{
+			allocateNetworkAndBroadcast: true,
+			gateway:                     (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.0")),
+			addresses: map[ipamv1.IPAddressStr]string{
+				"192.168.0.1": "bcd",
+				"192.168.0.2": "cde",
+				"192.168.0.3": "def",
+				"192.168.0.4": "efg",
+				"192.168.0.5": "fgh",
+				"192.168.0.6": "ghi",
+			},
+			expectedAddress: "192.168.0.7",
+		}),
+		Entry("Broadcast address not allocated", testCaseNetworkAddresses{
+			addresses: map[ipamv1.IPAddressStr]string{
+				"192.168.0.1": "bcd",
+				"192.168.0.2": "cde",
+				"192.168.0.3": "def",
+				"192.168.0.4": "efg",
+				"192.168.0.5": "fgh",
+				"192.168.0.6": "ghi",
+			},
+			expectedErrorMessage: pointer.StringPtr("Exhausted IP Pools"),
+		}),
+		Entry("Pre-allocated gateway", testCaseNetworkAddresses{
+			gateway:              (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.1")),
+			preAllocation:        "192.168.0.1",
+			expectedErrorMessage: pointer.StringPtr("Pre-allocated IP out of bond"),
+		}),
+	)
+
+	type testCaseDeleteAddresses struct {
+		ipPool              *ipamv1.IPPool
+		ipClaim             *ipamv1.IPClaim
+		m3addresses         []*ipamv1.IPAddress
+		addresses           map[ipamv1.IPAddressStr]string
+		expectedAddresses   map[ipamv1.IPAddressStr]string
+		expectedAllocations map[string]ipamv1.IPAddressStr
+		expectError         bool
+	}
+
+	DescribeTable("Test DeleteAddresses",
+		func(tc testCaseDeleteAddresses) {
+			objects := []client.Object{}
+			for _, address := range tc.m3addresses {
+				objects = append(objects, address)
+			}
+			c := fakeclient.NewClientBuilder().WithScheme(setupScheme()).WithObjects(objects...).Build()
+			ipPoolMgr, err := NewIPPoolManager(c, tc.ipPool,
+				klogr.New(),
+			)
+			Expect(err).NotTo(HaveOccurred())
+
+			allocatedMap, err := ipPoolMgr.deleteAddress(context.TODO(), tc.ipClaim, tc.addresses)
+			if tc.expectError {
+				Expect(err).To(HaveOccurred())
+			} else {
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			// get list of IPAddress objects
+			addressObjects := ipamv1.IPAddressList{}
+			opts := &client.ListOptions{}
+			err = c.List(context.TODO(), &addressObjects, opts)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(len(addressObjects.Items)).To(Equal(0))
+
+			Expect(tc.ipPool.Status.LastUpdated.IsZero()).To(BeFalse())
+			Expect(allocatedMap).To(Equal(tc.expectedAddresses))
+			Expect(tc.ipPool.Status.Allocations).To(Equal(tc.expectedAllocations))
+			Expect(len(tc.ipClaim.Finalizers)).To(Equal(0))
+		},
+		Entry("Empty IPPool", testCaseDeleteAddresses{
+			ipPool: &ipamv1.IPPool{},
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "TestRef",
+				},
+			},
+		}),
+		Entry("No Deletion needed", testCaseDeleteAddresses{
+			ipPool: &ipamv1.IPPool{},
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "TestRef",
+				},
+			},
+			expectedAddresses: map[ipamv1.IPAddressStr]string{ipamv1.IPAddressStr("192.168.0.1"): "abcd"},
+			addresses: map[ipamv1.IPAddressStr]string{
+				ipamv1.IPAddressStr("192.168.0.1"): "abcd",
+			},
+		}),
+		Entry("Deletion needed, not found", testCaseDeleteAddresses{
+			ipPool: &ipamv1.IPPool{
+				Status: ipamv1.IPPoolStatus{
+					Allocations: map[string]ipamv1.IPAddressStr{
+						"TestRef": ipamv1.IPAddressStr("192.168.0.1"),
+					},
+				},
+			},
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "TestRef",
+				},
+			},
+			addresses: map[ipamv1.IPAddressStr]string{
+				ipamv1.IPAddressStr("192.168.0.1"): "TestRef",
+			},
+			expectedAllocations: map[string]ipamv1.IPAddressStr{},
+			expectedAddresses:   map[ipamv1.IPAddressStr]string{},
+		}),
+		Entry("Deletion needed", testCaseDeleteAddresses{
+			ipPool: &ipamv1.IPPool{
+				Spec: ipamv1.IPPoolSpec{
+					NamePrefix: "abc",
+				},
+				Status: ipamv1.IPPoolStatus{
+					Allocations: map[string]ipamv1.IPAddressStr{
+						"TestRef": ipamv1.IPAddressStr("192.168.0.1"),
+					},
+				},
+			},
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "TestRef",
+					Finalizers: []string{
+						ipamv1.IPClaimFinalizer,
+					},
+				},
+			},
+			addresses: map[ipamv1.IPAddressStr]string{
+				ipamv1.IPAddressStr("192.168.0.1"): "TestRef",
+			},
+			expectedAddresses:   map[ipamv1.IPAddressStr]string{},
+			expectedAllocations: map[string]ipamv1.IPAddressStr{},
+			m3addresses: []*ipamv1.IPAddress{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "abc-192-168-0-1",
+					},
+				},
+			},
+		}),
+		Entry("Deletion needed, HA address set", testCaseDeleteAddresses{
+			ipPool: &ipamv1.IPPool{
+				Spec: ipamv1.IPPoolSpec{
+					NamePrefix: "abc",
+				},
+				Status: ipamv1.IPPoolStatus{
+					Allocations: map[string]ipamv1.IPAddressStr{
+						"TestRef":      ipamv1.IPAddressStr("192.168.0.1"),
+						"TestRef:peer": ipamv1.IPAddressStr("192.168.0.2"),
+						"TestRef:vip":  ipamv1.IPAddressStr("192.168.0.3"),
+						"TestRef2":     ipamv1.IPAddressStr("192.168.0.4"),
+					},
+				},
+			},
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "TestRef",
+					Finalizers: []string{
+						ipamv1.IPClaimFinalizer,
+					},
+				},
+				Spec: ipamv1.IPClaimSpec{
+					HAAddressSet: true,
+				},
+			},
+			addresses: map[ipamv1.IPAddressStr]string{
+				ipamv1.IPAddressStr("192.168.0.1"): "TestRef",
+				ipamv1.IPAddressStr("192.168.0.2"): "TestRef:peer",
+				ipamv1.IPAddressStr("192.168.0.3"): "TestRef:vip",
+				ipamv1.IPAddressStr("192.168.0.4"): "TestRef2",
+			},
+			expectedAddresses: map[ipamv1.IPAddressStr]string{
+				ipamv1.IPAddressStr("192.168.0.4"): "TestRef2",
+			},
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"TestRef2": ipamv1.IPAddressStr("192.168.0.4"),
+			},
+			m3addresses: []*ipamv1.IPAddress{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "abc-192-168-0-1",
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "abc-192-168-0-2",
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "abc-192-168-0-3",
+					},
+				},
+			},
+		}),
+	)
+
+	DescribeTable("Test allocateRoleAddress network settings",
+		func(ipPool *ipamv1.IPPool, expected addressAllocation) {
+			ipPoolMgr, err := NewIPPoolManager(nil, ipPool, klogr.New())
+			Expect(err).NotTo(HaveOccurred())
+			allocation, _, err := ipPoolMgr.allocateRoleAddress(&ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "TestRef",
+				},
+			}, "", map[ipamv1.IPAddressStr]string{}, anyPool)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(allocation).To(Equal(expected))
+		},
+		Entry("Default settings", &ipamv1.IPPool{
+			Spec: ipamv1.IPPoolSpec{
+				Pools: []ipamv1.Pool{
+					{
+						Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+					},
+				},
+				Prefix:        24,
+				SearchDomains: []string{"example.com"},
+			},
+		}, addressAllocation{
+			address:       ipamv1.IPAddressStr("192.168.0.10"),
+			prefix:        24,
+			searchDomains: []string{"example.com"},
+		}),
+		Entry("Pool settings", &ipamv1.IPPool{
+			Spec: ipamv1.IPPoolSpec{
+				Pools: []ipamv1.Pool{
+					{
+						Start:         (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+						SearchDomains: []string{"rack1.example.com"},
+					},
+				},
+				Prefix:        24,
+				SearchDomains: []string{"example.com"},
+			},
+		}, addressAllocation{
+			address:       ipamv1.IPAddressStr("192.168.0.10"),
+			prefix:        24,
+			searchDomains: []string{"rack1.example.com"},
+		}),
+		Entry("NTP servers and domain name", &ipamv1.IPPool{
+			Spec: ipamv1.IPPoolSpec{
+				Pools: []ipamv1.Pool{
+					{
+						Start:      (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+						DomainName: "rack1.example.com",
+					},
+				},
+				NTPServers: []string{"ntp.example.com"},
+				DomainName: "example.com",
+			},
+		}, addressAllocation{
+			address:    ipamv1.IPAddressStr("192.168.0.10"),
+			ntpServers: []string{"ntp.example.com"},
+			domainName: "rack1.example.com",
+		}),
+		Entry("Default gateway out of the subnet", &ipamv1.IPPool{
+			Spec: ipamv1.IPPoolSpec{
+				Pools: []ipamv1.Pool{
+					{
+						Subnet: (*ipamv1.IPSubnetStr)(pointer.StringPtr("192.168.0.0/24")),
+					},
+				},
+				Gateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.1")),
+			},
+		}, addressAllocation{
+			address: ipamv1.IPAddressStr("192.168.0.1"),
+			prefix:  24,
+		}),
+		Entry("Default gateway marked off-subnet", &ipamv1.IPPool{
+			Spec: ipamv1.IPPoolSpec{
+				Pools: []ipamv1.Pool{
+					{
+						Subnet: (*ipamv1.IPSubnetStr)(pointer.StringPtr("192.168.0.0/24")),
+					},
+				},
+				Gateway:          (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.1")),
+				OffSubnetGateway: true,
+			},
+		}, addressAllocation{
+			address: ipamv1.IPAddressStr("192.168.0.1"),
+			prefix:  24,
+			gateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.1")),
+		}),
+	)
+
+	DescribeTable("Test compactAllocations",
+		func(compact bool, status ipamv1.IPPoolStatus, expectedStatus ipamv1.IPPoolStatus) {
+			ipPool := &ipamv1.IPPool{
+				Spec: ipamv1.IPPoolSpec{
+					CompactAllocations: compact,
+				},
+				Status: status,
+			}
+			ipPoolMgr, err := NewIPPoolManager(nil, ipPool, klogr.New())
+			Expect(err).NotTo(HaveOccurred())
+			ipPoolMgr.compactAllocations()
+			Expect(ipPool.Status).To(Equal(expectedStatus))
+		},
+		Entry("Not compacted", false, ipamv1.IPPoolStatus{
+			Allocations: map[string]ipamv1.IPAddressStr{
+				"abc": "192.168.0.10",
+			},
+		}, ipamv1.IPPoolStatus{
+			Allocations: map[string]ipamv1.IPAddressStr{
+				"abc": "192.168.0.10",
+			},
+		}),
+		Entry("Migrated from compacted allocations", false, ipamv1.IPPoolStatus{
+			Allocations: map[string]ipamv1.IPAddressStr{
+				"abc": "192.168.0.10",
+			},
+			AllocatedRanges: []string{"192.168.0.10"},
+		}, ipamv1.IPPoolStatus{
+			Allocations: map[string]ipamv1.IPAddressStr{
+				"abc": "192.168.0.10",
+			},
+		}),
+		Entry("Compacted", true, ipamv1.IPPoolStatus{
+			Allocations: map[string]ipamv1.IPAddressStr{
+				"abc": "192.168.0.10",
+				"bcd": "192.168.0.11",
+				"cde": "192.168.0.13",
+			},
+		}, ipamv1.IPPoolStatus{
+			AllocatedRanges: []string{"192.168.0.10-192.168.0.11", "192.168.0.13"},
+		}),
+	)
+
+	DescribeTable("Test compactAllocations with a compaction threshold",
+		func(threshold int, expectCompacted bool) {
+			ipPool := &ipamv1.IPPool{
+				Status: ipamv1.IPPoolStatus{
+					Allocations: map[string]ipamv1.IPAddressStr{
+						"abc": "192.168.0.10",
+						"bcd": "192.168.0.11",
+					},
+				},
+			}
+			ipPoolMgr, err := NewIPPoolManager(nil, ipPool, klogr.New())
+			Expect(err).NotTo(HaveOccurred())
+			ipPoolMgr.CompactionThreshold = threshold
+			ipPoolMgr.compactAllocations()
+			if expectCompacted {
+				Expect(ipPool.Status.Allocations).To(BeNil())
+				Expect(ipPool.Status.AllocatedRanges).To(Equal([]string{"192.168.0.10-192.168.0.11"}))
+			} else {
+				Expect(ipPool.Status.Allocations).To(HaveLen(2))
+				Expect(ipPool.Status.AllocatedRanges).To(BeNil())
+			}
+		},
+		Entry("Disabled", 0, false),
+		Entry("Under the threshold", 2, false),
+		Entry("Above the threshold", 1, true),
+	)
+
+	type testCaseUpdateCapacity struct {
+		spec              ipamv1.IPPoolSpec
+		status            ipamv1.IPPoolStatus
+		expectedTotal     int64
+		expectedAllocated int64
+		expectedAvailable int64
+		expectedExact     [2]string
+		expectedExhausted bool
+		expectInvalidSpec bool
+	}
+
+	DescribeTable("Test updateCapacity",
+		func(tc testCaseUpdateCapacity) {
+			ipPool := &ipamv1.IPPool{
+				Spec:   tc.spec,
+				Status: tc.status,
+			}
+			ipPoolMgr, err := NewIPPoolManager(nil, ipPool, klogr.New())
+			Expect(err).NotTo(HaveOccurred())
+			ipPoolMgr.updateCapacity()
+			Expect(ipPool.Status.TotalCount).To(Equal(tc.expectedTotal))
+			Expect(ipPool.Status.AllocatedCount).To(Equal(tc.expectedAllocated))
+			Expect(ipPool.Status.AvailableCount).To(Equal(tc.expectedAvailable))
+			Expect(ipPool.Status.ExactTotalCount).To(Equal(tc.expectedExact[0]))
+			Expect(ipPool.Status.ExactAvailableCount).To(Equal(tc.expectedExact[1]))
+			Expect(meta.IsStatusConditionTrue(ipPool.Status.Conditions,
+				ipamv1.IPPoolInvalidSpecCondition,
+			)).To(Equal(tc.expectInvalidSpec))
+			Expect(meta.IsStatusConditionTrue(ipPool.Status.Conditions,
+				ipamv1.IPPoolExhaustedCondition,
+			)).To(Equal(tc.expectedExhausted))
+		},
+		Entry("Empty pool", testCaseUpdateCapacity{
+			expectedExhausted: true,
+		}),
+		Entry("Invalid pool", testCaseUpdateCapacity{
+			spec: ipamv1.IPPoolSpec{
+				Pools: []ipamv1.Pool{
+					{Subnet: (*ipamv1.IPSubnetStr)(pointer.StringPtr("192.168.0.0/33"))},
+				},
+			},
+			status: ipamv1.IPPoolStatus{
+				Conditions: []metav1.Condition{{
+					Type:   ipamv1.IPPoolExhaustedCondition,
+					Status: metav1.ConditionTrue,
+					Reason: "NoAddressAvailable",
+				}},
+			},
+			expectInvalidSpec: true,
+		}),
+		Entry("Allocations", testCaseUpdateCapacity{
+			spec: ipamv1.IPPoolSpec{
+				Pools: []ipamv1.Pool{
+					{Subnet: (*ipamv1.IPSubnetStr)(pointer.StringPtr("192.168.0.0/24"))},
+					{
+						Start:    (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.10")),
+						End:      (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.19")),
+						Disabled: true,
+					},
+				},
+			},
+			status: ipamv1.IPPoolStatus{
+				Allocations: map[string]ipamv1.IPAddressStr{
+					"abc": "192.168.0.10",
+					"bcd": "192.168.1.11",
+				},
+			},
+			expectedTotal:     254,
+			expectedAllocated: 2,
+			expectedAvailable: 252,
+		}),
+		Entry("Compacted allocations", testCaseUpdateCapacity{
+			spec: ipamv1.IPPoolSpec{
+				Pools: []ipamv1.Pool{
+					{
+						Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+						End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.19")),
+					},
+				},
+			},
+			status: ipamv1.IPPoolStatus{
+				AllocatedRanges: []string{"192.168.0.10-192.168.0.14", "192.168.0.16"},
+			},
+			expectedTotal:     10,
+			expectedAllocated: 6,
+			expectedAvailable: 4,
+		}),
+		Entry("Delegated prefixes", testCaseUpdateCapacity{
+			spec: ipamv1.IPPoolSpec{
+				Pools: []ipamv1.Pool{
+					{Subnet: (*ipamv1.IPSubnetStr)(pointer.StringPtr("192.168.0.0/24"))},
+				},
+				DelegatedPrefix: 29,
+			},
+			status: ipamv1.IPPoolStatus{
+				Allocations: map[string]ipamv1.IPAddressStr{
+					"abc": "192.168.0.0",
+				},
+			},
+			expectedTotal:     32,
+			expectedAllocated: 1,
+			expectedAvailable: 31,
+		}),
+		Entry("Large IPv6 pool", testCaseUpdateCapacity{
+			spec: ipamv1.IPPoolSpec{
+				Pools: []ipamv1.Pool{
+					{Subnet: (*ipamv1.IPSubnetStr)(pointer.StringPtr("2001:db8::/48"))},
+				},
+			},
+			status: ipamv1.IPPoolStatus{
+				Allocations: map[string]ipamv1.IPAddressStr{
+					"abc": "2001:db8::10",
+				},
+			},
+			expectedTotal:     math.MaxInt64,
+			expectedAllocated: 1,
+			expectedAvailable: math.MaxInt64,
+			expectedExact: [2]string{
+				"1208925819614629174706175", "1208925819614629174706174",
+			},
+		}),
+		Entry("IPv6 /64 pool", testCaseUpdateCapacity{
+			spec: ipamv1.IPPoolSpec{
+				Pools: []ipamv1.Pool{
+					{
+						Subnet: (*ipamv1.IPSubnetStr)(pointer.StringPtr("2001:db8::/64")),
+						Exclude: []ipamv1.ExcludedRange{{
+							Start: "2001:db8::1",
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("2001:db8::ffff")),
+						}},
+					},
+				},
+			},
+			expectedTotal:     math.MaxInt64,
+			expectedAvailable: math.MaxInt64,
+			expectedExact: [2]string{
+				"18446744073709486080", "18446744073709486080",
+			},
+		}),
+		Entry("More allocations than addresses", testCaseUpdateCapacity{
+			spec: ipamv1.IPPoolSpec{
+				Pools: []ipamv1.Pool{
+					{
+						Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+						End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.11")),
+					},
+				},
+			},
+			status: ipamv1.IPPoolStatus{
+				AllocatedRanges: []string{"192.168.0.10-192.168.0.12"},
+			},
+			expectedTotal:     2,
+			expectedAllocated: 3,
+			expectedAvailable: 0,
+			expectedExhausted: true,
+		}),
+	)
+
+	type testCaseSetStaleCondition struct {
+		threshold         time.Duration
+		created           time.Duration
+		address           *corev1.ObjectReference
+		errorMessage      *string
+		conditions        []metav1.Condition
+		expectedCondition *metav1.Condition
+	}
+
+	DescribeTable("Test setStaleCondition",
+		func(tc testCaseSetStaleCondition) {
+			ipPoolMgr, err := NewIPPoolManager(nil, &ipamv1.IPPool{}, klogr.New())
+			Expect(err).NotTo(HaveOccurred())
+			ipPoolMgr.StaleClaimThreshold = tc.threshold
+			ipClaim := &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              "abc",
+					CreationTimestamp: metav1.NewTime(time.Now().Add(-tc.created)),
+				},
+				Status: ipamv1.IPClaimStatus{
+					Address:      tc.address,
+					ErrorMessage: tc.errorMessage,
+					Conditions:   tc.conditions,
+				},
+			}
+
+			ipPoolMgr.setStaleCondition(ipClaim)
+
+			condition := meta.FindStatusCondition(ipClaim.Status.Conditions,
+				ipamv1.IPClaimStaleCondition,
+			)
+			if tc.expectedCondition == nil {
+				Expect(condition).To(BeNil())
+				return
+			}
+			Expect(condition).NotTo(BeNil())
+			Expect(condition.Status).To(Equal(tc.expectedCondition.Status))
+			Expect(condition.Reason).To(Equal(tc.expectedCondition.Reason))
+			Expect(condition.Message).To(Equal(tc.expectedCondition.Message))
+		},
+		Entry("Disabled", testCaseSetStaleCondition{
+			created: time.Hour,
+		}),
+		Entry("Recent claim", testCaseSetStaleCondition{
+			threshold: 15 * time.Minute,
+			created:   time.Minute,
+		}),
+		Entry("Bound claim", testCaseSetStaleCondition{
+			threshold: 15 * time.Minute,
+			created:   time.Hour,
+			address:   &corev1.ObjectReference{Name: "abc-192-168-0-10"},
+		}),
+		Entry("Stale claim", testCaseSetStaleCondition{
+			threshold: 15 * time.Minute,
+			created:   time.Hour,
+			expectedCondition: &metav1.Condition{
+				Status:  metav1.ConditionTrue,
+				Reason:  "AddressNotAllocated",
+				Message: "No address allocated for more than 15m0s",
+			},
+		}),
+		Entry("Stale claim with an error", testCaseSetStaleCondition{
+			threshold:    15 * time.Minute,
+			created:      time.Hour,
+			errorMessage: pointer.StringPtr("Failed to create associated IPAddress object"),
+			expectedCondition: &metav1.Condition{
+				Status:  metav1.ConditionTrue,
+				Reason:  "AddressNotAllocated",
+				Message: "No address allocated for more than 15m0s: Failed to create associated IPAddress object",
+			},
+		}),
+		Entry("Exhausted pool", testCaseSetStaleCondition{
+			threshold:    15 * time.Minute,
+			created:      time.Hour,
+			errorMessage: pointer.StringPtr("Exhausted IP Pools"),
+			expectedCondition: &metav1.Condition{
+				Status:  metav1.ConditionFalse,
+				Reason:  "PoolExhausted",
+				Message: "Exhausted IP Pools",
+			},
+		}),
+		Entry("Claim bound after being stale", testCaseSetStaleCondition{
+			threshold: 15 * time.Minute,
+			created:   time.Hour,
+			address:   &corev1.ObjectReference{Name: "abc-192-168-0-10"},
+			conditions: []metav1.Condition{
+				{
+					Type:   ipamv1.IPClaimStaleCondition,
+					Status: metav1.ConditionTrue,
+					Reason: "AddressNotAllocated",
+				},
+			},
+			expectedCondition: &metav1.Condition{
+				Status: metav1.ConditionFalse,
+				Reason: "AddressAllocated",
+			},
+		}),
+	)
+
+	type testCaseBindingDeadline struct {
+		poolDeadline      *metav1.Duration
+		claimDeadline     *metav1.Duration
+		pools             []ipamv1.Pool
+		conditions        []metav1.Condition
+		expectError       bool
+		expectAddress     bool
+		expectedCondition *metav1.Condition
+	}
+
+	DescribeTable("Test updateAddress binding deadline",
+		func(tc testCaseBindingDeadline) {
+			ipClaim := &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              "abc",
+					Namespace:         "myns",
+					CreationTimestamp: metav1.NewTime(time.Now().Add(-time.Hour)),
+				},
+				Spec: ipamv1.IPClaimSpec{
+					Pool:            corev1.ObjectReference{Name: "abc"},
+					BindingDeadline: tc.claimDeadline,
+				},
+				Status: ipamv1.IPClaimStatus{
+					Conditions: tc.conditions,
+				},
+			}
+			c := fakeclient.NewClientBuilder().WithScheme(setupScheme()).WithObjects(ipClaim).Build()
+			ipPoolMgr, err := NewIPPoolManager(c, &ipamv1.IPPool{
+				ObjectMeta: ipPoolMeta,
+				Spec: ipamv1.IPPoolSpec{
+					NamePrefix:           "abcpref",
+					Pools:                tc.pools,
+					ClaimBindingDeadline: tc.poolDeadline,
+				},
+				Status: ipamv1.IPPoolStatus{
+					Allocations: map[string]ipamv1.IPAddressStr{},
+				},
+			}, klogr.New())
+			Expect(err).NotTo(HaveOccurred())
+			recorder := record.NewFakeRecorder(1)
+			ipPoolMgr.Recorder = recorder
+
+			_, err = ipPoolMgr.updateAddress(context.TODO(), ipClaim,
+				map[ipamv1.IPAddressStr]string{},
+			)
+			if tc.expectError {
+				Expect(err).To(HaveOccurred())
+			} else {
+				Expect(err).NotTo(HaveOccurred())
+			}
+			Expect(ipClaim.Status.Address != nil).To(Equal(tc.expectAddress))
+
+			condition := meta.FindStatusCondition(ipClaim.Status.Conditions,
+				ipamv1.IPClaimBindingFailedCondition,
+			)
+			if tc.expectedCondition == nil {
+				Expect(condition).To(BeNil())
+				Expect(recorder.Events).To(BeEmpty())
+				return
+			}
+			Expect(condition).NotTo(BeNil())
+			Expect(condition.Status).To(Equal(tc.expectedCondition.Status))
+			Expect(condition.Reason).To(Equal(tc.expectedCondition.Reason))
+			Expect(condition.Message).To(Equal(tc.expectedCondition.Message))
+			Expect(*ipClaim.Status.ErrorMessage).To(Equal(tc.expectedCondition.Message))
+		},
+		Entry("No deadline, exhausted pool", testCaseBindingDeadline{
+			expectError: true,
+		}),
+		Entry("Deadline not reached, exhausted pool", testCaseBindingDeadline{
+			poolDeadline: &metav1.Duration{Duration: 2 * time.Hour},
+			expectError:  true,
+		}),
+		Entry("Deadline reached, address allocated", testCaseBindingDeadline{
+			poolDeadline: &metav1.Duration{Duration: time.Minute},
+			pools: []ipamv1.Pool{
+				{
+					Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+				},
+			},
+			expectAddress: true,
+		}),
+		Entry("Pool deadline reached, exhausted pool", testCaseBindingDeadline{
+			poolDeadline: &metav1.Duration{Duration: time.Minute},
+			expectedCondition: &metav1.Condition{
+				Status:  metav1.ConditionTrue,
+				Reason:  "DeadlineExceeded",
+				Message: "No address allocated within the binding deadline of 1m0s: Exhausted IP Pools",
+			},
+		}),
+		Entry("Claim deadline overriding the pool one", testCaseBindingDeadline{
+			poolDeadline:  &metav1.Duration{Duration: 2 * time.Hour},
+			claimDeadline: &metav1.Duration{Duration: 30 * time.Minute},
+			expectedCondition: &metav1.Condition{
+				Status:  metav1.ConditionTrue,
+				Reason:  "DeadlineExceeded",
+				Message: "No address allocated within the binding deadline of 30m0s: Exhausted IP Pools",
+			},
+		}),
+		Entry("Claim deadline disabling the pool one", testCaseBindingDeadline{
+			poolDeadline:  &metav1.Duration{Duration: time.Minute},
+			claimDeadline: &metav1.Duration{},
+			expectError:   true,
+		}),
+		Entry("Failed claim", testCaseBindingDeadline{
+			pools: []ipamv1.Pool{
+				{
+					Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+				},
+			},
+			conditions: []metav1.Condition{
+				{
+					Type:    ipamv1.IPClaimBindingFailedCondition,
+					Status:  metav1.ConditionTrue,
+					Reason:  "DeadlineExceeded",
+					Message: "No address allocated within the binding deadline of 1m0s",
+				},
+			},
+			expectedCondition: &metav1.Condition{
+				Status:  metav1.ConditionTrue,
+				Reason:  "DeadlineExceeded",
+				Message: "No address allocated within the binding deadline of 1m0s",
+			},
+		}),
+	)
+
+	It("Ignores the leases with the feature gate disabled", func() {
+		Expect(feature.MutableGates.Set("LeaseExpiry=false")).To(Succeed())
+		defer func() {
+			Expect(feature.MutableGates.Set("LeaseExpiry=true")).To(Succeed())
+		}()
+		ipPool := &ipamv1.IPPool{
+			ObjectMeta: ipPoolMeta,
+			Spec: ipamv1.IPPoolSpec{
+				LeaseDuration: &metav1.Duration{Duration: time.Minute},
+			},
+		}
+		c := fakeclient.NewClientBuilder().WithScheme(setupScheme()).Build()
+		ipPoolMgr, err := NewIPPoolManager(c, ipPool, klogr.New())
+		Expect(err).NotTo(HaveOccurred())
+		_, ok := ipPoolMgr.leaseExpiry(&ipamv1.IPClaim{ObjectMeta: testObjectMeta})
+		Expect(ok).To(BeFalse())
+	})
+
+	type testCaseLease struct {
+		poolLease         *metav1.Duration
+		claimLease        *metav1.Duration
+		renewal           *metav1.Duration
+		bound             bool
+		conditions        []metav1.Condition
+		expectAddress     bool
+		expectedRequeue   time.Duration
+		expectedCondition *metav1.Condition
+	}
+
+	DescribeTable("Test UpdateAddresses with leases",
+		func(tc testCaseLease) {
+			ipClaim := &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              "abc",
+					Namespace:         "myns",
+					CreationTimestamp: metav1.NewTime(time.Now().Add(-time.Hour)),
+					Finalizers:        []string{ipamv1.IPClaimFinalizer},
+				},
+				Spec: ipamv1.IPClaimSpec{
+					Pool:          corev1.ObjectReference{Name: "abc"},
+					LeaseDuration: tc.claimLease,
+				},
+				Status: ipamv1.IPClaimStatus{
+					Conditions: tc.conditions,
+				},
+			}
+			if tc.renewal != nil {
+				ipClaim.Annotations = map[string]string{
+					ipamv1.LeaseRenewedAnnotation: time.Now().Add(-tc.renewal.Duration).Format(time.RFC3339),
+				}
+			}
+			ipPool := &ipamv1.IPPool{
+				ObjectMeta: ipPoolMeta,
+				Spec: ipamv1.IPPoolSpec{
+					NamePrefix: "abcpref",
+					Pools: []ipamv1.Pool{
+						{
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+						},
+					},
+					LeaseDuration: tc.poolLease,
+				},
+				Status: ipamv1.IPPoolStatus{
+					Allocations: map[string]ipamv1.IPAddressStr{},
+				},
+			}
+			objects := []client.Object{ipClaim}
+			if tc.bound {
+				ipClaim.Status.Address = &corev1.ObjectReference{
+					Name:      "abcpref-192-168-0-10",
+					Namespace: "myns",
+				}
+				ipPool.Status.Allocations["abc"] = "192.168.0.10"
+				objects = append(objects, &ipamv1.IPAddress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "abcpref-192-168-0-10",
+						Namespace: "myns",
+					},
+					Spec: ipamv1.IPAddressSpec{
+						Address: "192.168.0.10",
+						Pool:    corev1.ObjectReference{Name: "abc", Namespace: "myns"},
+						Claim:   corev1.ObjectReference{Name: "abc", Namespace: "myns"},
+					},
+				})
+			}
+			c := fakeclient.NewClientBuilder().WithScheme(setupScheme()).WithObjects(objects...).Build()
+			ipPoolMgr, err := NewIPPoolManager(c, ipPool, klogr.New())
+			Expect(err).NotTo(HaveOccurred())
+			recorder := record.NewFakeRecorder(1)
+			ipPoolMgr.Recorder = recorder
+
+			_, err = ipPoolMgr.UpdateAddresses(context.TODO())
+			if tc.expectedRequeue == 0 {
+				Expect(err).NotTo(HaveOccurred())
+			} else {
+				Expect(err).To(BeAssignableToTypeOf(&RequeueAfterError{}))
+				requeueAfter := err.(*RequeueAfterError).RequeueAfter
+				Expect(requeueAfter).To(BeNumerically("<=", tc.expectedRequeue))
+				Expect(requeueAfter).To(BeNumerically(">", tc.expectedRequeue-time.Minute))
+			}
+
+			key := client.ObjectKeyFromObject(ipClaim)
+			ipClaim = &ipamv1.IPClaim{}
+			Expect(c.Get(context.TODO(), key, ipClaim)).To(Succeed())
+			addressObjects := ipamv1.IPAddressList{}
+			Expect(c.List(context.TODO(), &addressObjects)).To(Succeed())
+			if tc.expectAddress {
+				Expect(ipClaim.Status.Address).NotTo(BeNil())
+				Expect(ipPool.Status.Allocations).To(HaveLen(1))
+				Expect(addressObjects.Items).To(HaveLen(1))
+				Expect(ipClaim.Finalizers).To(HaveLen(1))
+			} else {
+				Expect(ipClaim.Status.Address).To(BeNil())
+				Expect(ipPool.Status.Allocations).To(BeEmpty())
+				Expect(addressObjects.Items).To(BeEmpty())
+				Expect(ipClaim.Finalizers).To(BeEmpty())
+			}
+
+			condition := meta.FindStatusCondition(ipClaim.Status.Conditions,
+				ipamv1.IPClaimLeaseExpiredCondition,
+			)
+			if tc.expectedCondition == nil {
+				Expect(condition).To(BeNil())
+				Expect(recorder.Events).To(BeEmpty())
+				return
+			}
+			Expect(condition).NotTo(BeNil())
+			Expect(condition.Status).To(Equal(tc.expectedCondition.Status))
+			Expect(condition.Reason).To(Equal(tc.expectedCondition.Reason))
+			if tc.expectedCondition.Status == metav1.ConditionTrue {
+				Expect(condition.Message).To(HavePrefix("Lease expired at "))
+				Expect(*ipClaim.Status.ErrorMessage).To(Equal(condition.Message))
+			}
+		},
+		Entry("No lease", testCaseLease{
+			bound:         true,
+			expectAddress: true,
+		}),
+		Entry("Lease not expired", testCaseLease{
+			poolLease:       &metav1.Duration{Duration: 2 * time.Hour},
+			bound:           true,
+			expectAddress:   true,
+			expectedRequeue: time.Hour,
+		}),
+		Entry("Lease expired", testCaseLease{
+			poolLease: &metav1.Duration{Duration: 30 * time.Minute},
+			bound:     true,
+			expectedCondition: &metav1.Condition{
+				Status: metav1.ConditionTrue,
+				Reason: "NotRenewed",
+			},
+		}),
+		Entry("Lease renewed", testCaseLease{
+			poolLease:       &metav1.Duration{Duration: 30 * time.Minute},
+			renewal:         &metav1.Duration{Duration: 10 * time.Minute},
+			bound:           true,
+			expectAddress:   true,
+			expectedRequeue: 20 * time.Minute,
+		}),
+		Entry("Claim lease overriding the pool one", testCaseLease{
+			poolLease:       &metav1.Duration{Duration: 2 * time.Hour},
+			claimLease:      &metav1.Duration{Duration: 90 * time.Minute},
+			bound:           true,
+			expectAddress:   true,
+			expectedRequeue: 30 * time.Minute,
+		}),
+		Entry("Claim lease disabling the pool one", testCaseLease{
+			poolLease:     &metav1.Duration{Duration: 30 * time.Minute},
+			claimLease:    &metav1.Duration{},
+			bound:         true,
+			expectAddress: true,
+		}),
+		Entry("Unbound claim with an expired lease", testCaseLease{
+			poolLease: &metav1.Duration{Duration: 30 * time.Minute},
+			expectedCondition: &metav1.Condition{
+				Status: metav1.ConditionTrue,
+				Reason: "NotRenewed",
+			},
+		}),
+		Entry("Expired lease renewed", testCaseLease{
+			poolLease: &metav1.Duration{Duration: 30 * time.Minute},
+			renewal:   &metav1.Duration{},
+			conditions: []metav1.Condition{
+				{
+					Type:    ipamv1.IPClaimLeaseExpiredCondition,
+					Status:  metav1.ConditionTrue,
+					Reason:  "NotRenewed",
+					Message: "Lease expired",
+				},
+			},
+			expectAddress: true,
+			expectedCondition: &metav1.Condition{
+				Status: metav1.ConditionFalse,
+				Reason: "Renewed",
+			},
+		}),
+	)
+
+	type testCaseSticky struct {
+		retention           *metav1.Duration
+		retained            map[string]ipamv1.RetainedAddress
+		deleteBound         bool
+		recreate            bool
+		poolDeleting        bool
+		expectedAllocations map[string]ipamv1.IPAddressStr
+		expectedRetained    map[string]ipamv1.IPAddressStr
+		expectedAvailable   int64
+	}
+
+	DescribeTable("Test UpdateAddresses with sticky allocations",
+		func(tc testCaseSticky) {
+			newClaim := func(name string) *ipamv1.IPClaim {
+				return &ipamv1.IPClaim{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:       name,
+						Namespace:  "myns",
+						Finalizers: []string{ipamv1.IPClaimFinalizer},
+					},
+					Spec: ipamv1.IPClaimSpec{
+						Pool: corev1.ObjectReference{Name: "abc"},
+					},
+				}
+			}
+			ipPool := &ipamv1.IPPool{
+				ObjectMeta: ipPoolMeta,
+				Spec: ipamv1.IPPoolSpec{
+					NamePrefix: "abcpref",
+					Pools: []ipamv1.Pool{
+						{
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.12")),
+						},
+					},
+					StickyAllocationRetention: tc.retention,
+				},
+				Status: ipamv1.IPPoolStatus{
+					RetainedAddresses: tc.retained,
+				},
+			}
+			if tc.poolDeleting {
+				ipPool.DeletionTimestamp = &timeNow
+			}
+			objects := []client.Object{newClaim("bcd")}
+			if tc.deleteBound {
+				deletedClaim := newClaim("abc")
+				deletedClaim.DeletionTimestamp = &timeNow
+				deletedClaim.Status.Address = &corev1.ObjectReference{
+					Name:      "abcpref-192-168-0-10",
+					Namespace: "myns",
+				}
+				objects = append(objects, deletedClaim, &ipamv1.IPAddress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "abcpref-192-168-0-10",
+						Namespace: "myns",
+					},
+					Spec: ipamv1.IPAddressSpec{
+						Address: "192.168.0.10",
+						Pool:    corev1.ObjectReference{Name: "abc", Namespace: "myns"},
+						Claim:   corev1.ObjectReference{Name: "abc", Namespace: "myns"},
+					},
+				})
+			}
+			if tc.recreate {
+				objects = append(objects, newClaim("abc"))
+			}
+			c := fakeclient.NewClientBuilder().WithScheme(setupScheme()).WithObjects(objects...).Build()
+			ipPoolMgr, err := NewIPPoolManager(c, ipPool, klogr.New())
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = ipPoolMgr.UpdateAddresses(context.TODO())
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(ipPool.Status.Allocations).To(Equal(tc.expectedAllocations))
+			retained := map[string]ipamv1.IPAddressStr{}
+			for key, retainedAddress := range ipPool.Status.RetainedAddresses {
+				retained[key] = retainedAddress.Address
+			}
+			Expect(retained).To(Equal(tc.expectedRetained))
+			Expect(ipPool.Status.AvailableCount).To(Equal(tc.expectedAvailable))
+		},
+		Entry("Deleted claim without sticky allocations", testCaseSticky{
+			deleteBound: true,
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"bcd": "192.168.0.10",
+			},
+			expectedRetained:  map[string]ipamv1.IPAddressStr{},
+			expectedAvailable: 2,
+		}),
+		Entry("Deleted claim with sticky allocations", testCaseSticky{
+			retention:   &metav1.Duration{Duration: time.Hour},
+			deleteBound: true,
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"bcd": "192.168.0.11",
+			},
+			expectedRetained: map[string]ipamv1.IPAddressStr{
+				"abc": "192.168.0.10",
+			},
+			expectedAvailable: 1,
+		}),
+		Entry("Recreated claim", testCaseSticky{
+			retention: &metav1.Duration{Duration: time.Hour},
+			retained: map[string]ipamv1.RetainedAddress{
+				"abc": {
+					Address:    "192.168.0.11",
+					ReleasedAt: metav1.NewTime(time.Now().Add(-time.Minute)),
+				},
+			},
+			recreate: true,
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"abc": "192.168.0.11",
+				"bcd": "192.168.0.10",
+			},
+			expectedRetained:  map[string]ipamv1.IPAddressStr{},
+			expectedAvailable: 1,
+		}),
+		Entry("Pool being deleted", testCaseSticky{
+			retention:    &metav1.Duration{Duration: time.Hour},
+			poolDeleting: true,
+			retained: map[string]ipamv1.RetainedAddress{
+				"abc": {
+					Address:    "192.168.0.10",
+					ReleasedAt: metav1.NewTime(time.Now().Add(-time.Minute)),
+				},
+			},
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"bcd": "192.168.0.10",
+			},
+			expectedRetained:  map[string]ipamv1.IPAddressStr{},
+			expectedAvailable: 2,
+		}),
+		Entry("Retention expired", testCaseSticky{
+			retention: &metav1.Duration{Duration: time.Hour},
+			retained: map[string]ipamv1.RetainedAddress{
+				"abc": {
+					Address:    "192.168.0.10",
+					ReleasedAt: metav1.NewTime(time.Now().Add(-2 * time.Hour)),
+				},
+			},
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"bcd": "192.168.0.10",
+			},
+			expectedRetained:  map[string]ipamv1.IPAddressStr{},
+			expectedAvailable: 2,
+		}),
+		Entry("Sticky allocations disabled", testCaseSticky{
+			retained: map[string]ipamv1.RetainedAddress{
+				"abc": {
+					Address:    "192.168.0.10",
+					ReleasedAt: metav1.NewTime(time.Now().Add(-time.Minute)),
+				},
+			},
+			recreate: true,
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"abc": "192.168.0.10",
+				"bcd": "192.168.0.11",
+			},
+			expectedRetained:  map[string]ipamv1.IPAddressStr{},
+			expectedAvailable: 1,
+		}),
+		Entry("Retained address out of the pools", testCaseSticky{
+			retention: &metav1.Duration{Duration: time.Hour},
+			retained: map[string]ipamv1.RetainedAddress{
+				"abc": {
+					Address:    "192.168.1.10",
+					ReleasedAt: metav1.NewTime(time.Now().Add(-time.Minute)),
+				},
+			},
+			recreate: true,
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"abc": "192.168.0.10",
+				"bcd": "192.168.0.11",
+			},
+			expectedRetained:  map[string]ipamv1.IPAddressStr{},
+			expectedAvailable: 1,
+		}),
+	)
+
+	type testCaseQuarantine struct {
+		quarantine          *metav1.Duration
+		quarantined         map[string]time.Duration
+		retained            bool
+		deleteBound         bool
+		recreate            bool
+		expectedAllocations map[string]ipamv1.IPAddressStr
+		expectedQuarantined []string
+		expectedRequeue     time.Duration
+		expectedAvailable   int64
+	}
+
+	DescribeTable("Test UpdateAddresses with a quarantine",
+		func(tc testCaseQuarantine) {
+			newClaim := func(name string) *ipamv1.IPClaim {
+				return &ipamv1.IPClaim{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:       name,
+						Namespace:  "myns",
+						Finalizers: []string{ipamv1.IPClaimFinalizer},
+					},
+					Spec: ipamv1.IPClaimSpec{
+						Pool: corev1.ObjectReference{Name: "abc"},
+					},
+				}
+			}
+			ipPool := &ipamv1.IPPool{
+				ObjectMeta: ipPoolMeta,
+				Spec: ipamv1.IPPoolSpec{
+					NamePrefix: "abcpref",
+					Pools: []ipamv1.Pool{
+						{
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.12")),
+						},
+					},
+					QuarantinePeriod: tc.quarantine,
+				},
+			}
+			for address, age := range tc.quarantined {
+				if ipPool.Status.QuarantinedAddresses == nil {
+					ipPool.Status.QuarantinedAddresses = make(map[string]metav1.Time)
+				}
+				ipPool.Status.QuarantinedAddresses[address] = metav1.NewTime(time.Now().Add(-age))
+			}
+			if tc.retained {
+				ipPool.Spec.StickyAllocationRetention = &metav1.Duration{Duration: time.Hour}
+				ipPool.Status.RetainedAddresses = map[string]ipamv1.RetainedAddress{
+					"abc": {
+						Address:    "192.168.0.10",
+						ReleasedAt: metav1.NewTime(time.Now().Add(-time.Minute)),
+					},
+				}
+			}
+			objects := []client.Object{newClaim("bcd")}
+			if tc.deleteBound {
+				deletedClaim := newClaim("abc")
+				deletedClaim.DeletionTimestamp = &timeNow
+				deletedClaim.Status.Address = &corev1.ObjectReference{
+					Name:      "abcpref-192-168-0-10",
+					Namespace: "myns",
+				}
+				objects = append(objects, deletedClaim, &ipamv1.IPAddress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "abcpref-192-168-0-10",
+						Namespace: "myns",
+					},
+					Spec: ipamv1.IPAddressSpec{
+						Address: "192.168.0.10",
+						Pool:    corev1.ObjectReference{Name: "abc", Namespace: "myns"},
+						Claim:   corev1.ObjectReference{Name: "abc", Namespace: "myns"},
+					},
+				})
+			}
+			if tc.recreate {
+				objects = append(objects, newClaim("abc"))
+			}
+			c := fakeclient.NewClientBuilder().WithScheme(setupScheme()).WithObjects(objects...).Build()
+			ipPoolMgr, err := NewIPPoolManager(c, ipPool, klogr.New())
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = ipPoolMgr.UpdateAddresses(context.TODO())
+			if tc.expectedRequeue == 0 {
+				Expect(err).NotTo(HaveOccurred())
+			} else {
+				Expect(err).To(BeAssignableToTypeOf(&RequeueAfterError{}))
+				requeueAfter := err.(*RequeueAfterError).RequeueAfter
+				Expect(requeueAfter).To(BeNumerically("<=", tc.expectedRequeue))
+				Expect(requeueAfter).To(BeNumerically(">", tc.expectedRequeue-time.Minute))
+			}
+
+			Expect(ipPool.Status.Allocations).To(Equal(tc.expectedAllocations))
+			quarantined := []string{}
+			for address := range ipPool.Status.QuarantinedAddresses {
+				quarantined = append(quarantined, address)
+			}
+			Expect(quarantined).To(ConsistOf(tc.expectedQuarantined))
+			Expect(ipPool.Status.AvailableCount).To(Equal(tc.expectedAvailable))
+		},
+		Entry("Released address without quarantine", testCaseQuarantine{
+			deleteBound: true,
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"bcd": "192.168.0.10",
+			},
+			expectedQuarantined: []string{},
+			expectedAvailable:   2,
+		}),
+		Entry("Released address in quarantine", testCaseQuarantine{
+			quarantine:  &metav1.Duration{Duration: time.Hour},
+			deleteBound: true,
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"bcd": "192.168.0.11",
+			},
+			expectedQuarantined: []string{"192.168.0.10"},
+			expectedRequeue:     time.Hour,
+			expectedAvailable:   1,
+		}),
+		Entry("Address still in quarantine", testCaseQuarantine{
+			quarantine:  &metav1.Duration{Duration: time.Hour},
+			quarantined: map[string]time.Duration{"192.168.0.10": 40 * time.Minute},
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"bcd": "192.168.0.11",
+			},
+			expectedQuarantined: []string{"192.168.0.10"},
+			expectedRequeue:     20 * time.Minute,
+			expectedAvailable:   1,
+		}),
+		Entry("Quarantine over", testCaseQuarantine{
+			quarantine:  &metav1.Duration{Duration: time.Hour},
+			quarantined: map[string]time.Duration{"192.168.0.10": 2 * time.Hour},
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"bcd": "192.168.0.10",
+			},
+			expectedQuarantined: []string{},
+			expectedAvailable:   2,
+		}),
+		Entry("Quarantine disabled", testCaseQuarantine{
+			quarantined: map[string]time.Duration{"192.168.0.10": time.Minute},
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"bcd": "192.168.0.10",
+			},
+			expectedQuarantined: []string{},
+			expectedAvailable:   2,
+		}),
+		Entry("Address in quarantine retained for the recreated claim", testCaseQuarantine{
+			quarantine:  &metav1.Duration{Duration: time.Hour},
+			quarantined: map[string]time.Duration{"192.168.0.10": time.Minute},
+			retained:    true,
+			recreate:    true,
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"abc": "192.168.0.10",
+				"bcd": "192.168.0.11",
+			},
+			expectedQuarantined: []string{"192.168.0.10"},
+			expectedRequeue:     59 * time.Minute,
+			expectedAvailable:   1,
+		}),
+	)
+
+	type testCaseReclaim struct {
+		policy              ipamv1.ReclaimPolicy
+		deleteBound         bool
+		retained            bool
+		recreate            bool
+		poolDeleting        bool
+		expectKept          bool
+		expectRetained      bool
+		expectedAllocations map[string]ipamv1.IPAddressStr
+	}
+
+	DescribeTable("Test UpdateAddresses with a reclaim policy",
+		func(tc testCaseReclaim) {
+			newClaim := func(name string) *ipamv1.IPClaim {
+				return &ipamv1.IPClaim{
+					TypeMeta: metav1.TypeMeta{
+						Kind:       "IPClaim",
+						APIVersion: ipamv1.GroupVersion.String(),
+					},
+					ObjectMeta: metav1.ObjectMeta{
+						Name:       name,
+						Namespace:  "myns",
+						Finalizers: []string{ipamv1.IPClaimFinalizer},
+					},
+					Spec: ipamv1.IPClaimSpec{
+						Pool: corev1.ObjectReference{Name: "abc"},
+					},
+				}
+			}
+			ipPool := &ipamv1.IPPool{
+				TypeMeta: metav1.TypeMeta{
+					Kind:       "IPPool",
+					APIVersion: ipamv1.GroupVersion.String(),
+				},
+				ObjectMeta: ipPoolMeta,
+				Spec: ipamv1.IPPoolSpec{
+					NamePrefix: "abcpref",
+					Pools: []ipamv1.Pool{
+						{
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.12")),
+						},
+					},
+					ReclaimPolicy: tc.policy,
+				},
+			}
+			if tc.poolDeleting {
+				ipPool.DeletionTimestamp = &timeNow
+			}
+			addressObject := &ipamv1.IPAddress{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "abcpref-192-168-0-10",
+					Namespace: "myns",
+					OwnerReferences: []metav1.OwnerReference{
+						{Kind: "IPPool", Name: "abc"},
+						{Kind: "IPClaim", Name: "abc"},
+					},
+				},
+				Spec: ipamv1.IPAddressSpec{
+					Address: "192.168.0.10",
+					Pool:    corev1.ObjectReference{Name: "abc", Namespace: "myns"},
+					Claim:   corev1.ObjectReference{Name: "abc", Namespace: "myns"},
+				},
+			}
+			objects := []client.Object{newClaim("bcd")}
+			if tc.deleteBound {
+				deletedClaim := newClaim("abc")
+				deletedClaim.DeletionTimestamp = &timeNow
+				deletedClaim.Status.Address = &corev1.ObjectReference{
+					Name:      "abcpref-192-168-0-10",
+					Namespace: "myns",
+				}
+				objects = append(objects, deletedClaim, addressObject)
+			}
+			if tc.retained {
+				addressObject.Labels = map[string]string{ipamv1.RetainedAddressLabel: "true"}
+				addressObject.OwnerReferences = addressObject.OwnerReferences[:1]
+				objects = append(objects, addressObject)
+			}
+			if tc.recreate {
+				objects = append(objects, newClaim("abc"))
+			}
+			c := fakeclient.NewClientBuilder().WithScheme(setupScheme()).WithObjects(objects...).Build()
+			ipPoolMgr, err := NewIPPoolManager(c, ipPool, klogr.New())
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = ipPoolMgr.UpdateAddresses(context.TODO())
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(ipPool.Status.Allocations).To(Equal(tc.expectedAllocations))
+			updatedAddress := &ipamv1.IPAddress{}
+			err = c.Get(context.TODO(), client.ObjectKeyFromObject(addressObject), updatedAddress)
+			Expect(err).NotTo(HaveOccurred())
+			if !tc.expectKept {
+				// The released address is allocated to the other claim
+				Expect(updatedAddress.Spec.Claim.Name).To(Equal("bcd"))
+				return
+			}
+			Expect(updatedAddress.Spec.Claim.Name).To(Equal("abc"))
+			if tc.expectRetained {
+				Expect(updatedAddress.Labels).To(HaveKeyWithValue(ipamv1.RetainedAddressLabel, "true"))
+				Expect(updatedAddress.OwnerReferences).To(HaveLen(1))
+				Expect(updatedAddress.OwnerReferences[0].Kind).To(Equal("IPPool"))
+			} else {
+				Expect(updatedAddress.Labels).NotTo(HaveKey(ipamv1.RetainedAddressLabel))
+				Expect(updatedAddress.OwnerReferences).To(HaveLen(2))
+				Expect(updatedAddress.OwnerReferences[1].Kind).To(Equal("IPClaim"))
+			}
+			if tc.recreate {
+				claim := &ipamv1.IPClaim{}
+				Expect(c.Get(context.TODO(), client.ObjectKey{Name: "abc", Namespace: "myns"}, claim)).To(Succeed())
+				Expect(claim.Status.Address).NotTo(BeNil())
+				Expect(claim.Status.Address.Name).To(Equal("abcpref-192-168-0-10"))
+			}
+		},
+		Entry("Deleted claim with the default policy", testCaseReclaim{
+			deleteBound: true,
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"bcd": "192.168.0.10",
+			},
+		}),
+		Entry("Deleted claim with the Retain policy", testCaseReclaim{
+			policy:         ipamv1.ReclaimPolicyRetain,
+			deleteBound:    true,
+			expectKept:     true,
+			expectRetained: true,
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"abc": "192.168.0.10",
+				"bcd": "192.168.0.11",
+			},
+		}),
+		Entry("Retained address kept", testCaseReclaim{
+			policy:         ipamv1.ReclaimPolicyRetain,
+			retained:       true,
+			expectKept:     true,
+			expectRetained: true,
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"abc": "192.168.0.10",
+				"bcd": "192.168.0.11",
+			},
+		}),
+		Entry("Retained address adopted by a recreated claim", testCaseReclaim{
+			policy:     ipamv1.ReclaimPolicyRetain,
+			retained:   true,
+			recreate:   true,
+			expectKept: true,
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"abc": "192.168.0.10",
+				"bcd": "192.168.0.11",
+			},
+		}),
+		Entry("Retained address released by the Delete policy", testCaseReclaim{
+			policy:   ipamv1.ReclaimPolicyDelete,
+			retained: true,
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"bcd": "192.168.0.10",
+			},
+		}),
+		Entry("Retained address released with the pool", testCaseReclaim{
+			policy:       ipamv1.ReclaimPolicyRetain,
+			retained:     true,
+			poolDeleting: true,
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"bcd": "192.168.0.10",
+			},
+		}),
+	)
+
+	type testCaseDrain struct {
+		drain                     bool
+		bound                     bool
+		migrate                   bool
+		servedBy                  bool
+		expectServedBy            bool
+		expectedAllocations       map[string]ipamv1.IPAddressStr
+		expectedTargetAllocations map[string]ipamv1.IPAddressStr
+		expectedDrained           metav1.ConditionStatus
+	}
+
+	DescribeTable("Test UpdateAddresses with a drain",
+		func(tc testCaseDrain) {
+			newPool := func(name, start, end string) *ipamv1.IPPool {
+				return &ipamv1.IPPool{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      name,
+						Namespace: "myns",
+					},
+					Spec: ipamv1.IPPoolSpec{
+						NamePrefix: name + "pref",
+						Pools: []ipamv1.Pool{
+							{
+								Start: (*ipamv1.IPAddressStr)(pointer.StringPtr(start)),
+								End:   (*ipamv1.IPAddressStr)(pointer.StringPtr(end)),
+							},
+						},
+					},
+				}
+			}
+			ipPool := newPool("abc", "192.168.0.10", "192.168.0.12")
+			if tc.drain {
+				ipPool.Spec.Drain = &ipamv1.PoolDrain{TargetPool: "bcd"}
+			}
+			targetPool := newPool("bcd", "10.0.0.10", "10.0.0.12")
+
+			claim := &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "abc",
+					Namespace:   "myns",
+					Finalizers:  []string{ipamv1.IPClaimFinalizer},
+					Annotations: map[string]string{},
+				},
+				Spec: ipamv1.IPClaimSpec{
+					Pool: corev1.ObjectReference{Name: "abc"},
+				},
+			}
+			if tc.migrate {
+				claim.Annotations[ipamv1.MigrateAnnotation] = ""
+			}
+			if tc.servedBy {
+				claim.Annotations[ipamv1.ServedByAnnotation] = "bcd"
+			}
+			objects := []client.Object{claim}
+			if tc.bound {
+				claim.Status.Address = &corev1.ObjectReference{
+					Name:      "abcpref-192-168-0-10",
+					Namespace: "myns",
+				}
+				objects = append(objects, &ipamv1.IPAddress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "abcpref-192-168-0-10",
+						Namespace: "myns",
+					},
+					Spec: ipamv1.IPAddressSpec{
+						Address: "192.168.0.10",
+						Pool:    corev1.ObjectReference{Name: "abc", Namespace: "myns"},
+						Claim:   corev1.ObjectReference{Name: "abc", Namespace: "myns"},
+					},
+				})
+			}
+			c := fakeclient.NewClientBuilder().WithScheme(setupScheme()).WithObjects(objects...).Build()
+
+			ipPoolMgr, err := NewIPPoolManager(c, ipPool, klogr.New())
+			Expect(err).NotTo(HaveOccurred())
+			_, err = ipPoolMgr.UpdateAddresses(context.TODO())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ipPool.Status.Allocations).To(Equal(tc.expectedAllocations))
+
+			drained := meta.FindStatusCondition(ipPool.Status.Conditions, ipamv1.IPPoolDrainedCondition)
+			if tc.expectedDrained == "" {
+				Expect(drained).To(BeNil())
+			} else {
+				Expect(drained).NotTo(BeNil())
+				Expect(drained.Status).To(Equal(tc.expectedDrained))
+			}
+
+			updatedClaim := &ipamv1.IPClaim{}
+			Expect(c.Get(context.TODO(), client.ObjectKey{Name: "abc", Namespace: "myns"}, updatedClaim)).To(Succeed())
+			servedBy, ok := updatedClaim.Annotations[ipamv1.ServedByAnnotation]
+			if tc.expectServedBy {
+				Expect(ok).To(BeTrue())
+				Expect(servedBy).To(Equal("bcd"))
+				Expect(updatedClaim.Annotations).NotTo(HaveKey(ipamv1.MigrateAnnotation))
+			} else {
+				Expect(ok).To(BeFalse())
+			}
+
+			targetPoolMgr, err := NewIPPoolManager(c, targetPool, klogr.New())
+			Expect(err).NotTo(HaveOccurred())
+			_, err = targetPoolMgr.UpdateAddresses(context.TODO())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(targetPool.Status.Allocations).To(Equal(tc.expectedTargetAllocations))
+		},
+		Entry("Pool not drained", testCaseDrain{
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"abc": "192.168.0.10",
+			},
+			expectedTargetAllocations: map[string]ipamv1.IPAddressStr{},
+		}),
+		Entry("Unbound claim handed over", testCaseDrain{
+			drain:               true,
+			expectServedBy:      true,
+			expectedAllocations: map[string]ipamv1.IPAddressStr{},
+			expectedTargetAllocations: map[string]ipamv1.IPAddressStr{
+				"abc": "10.0.0.10",
+			},
+			expectedDrained: metav1.ConditionTrue,
+		}),
+		Entry("Bound claim kept until migrated", testCaseDrain{
+			drain: true,
+			bound: true,
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"abc": "192.168.0.10",
+			},
+			expectedTargetAllocations: map[string]ipamv1.IPAddressStr{},
+			expectedDrained:           metav1.ConditionFalse,
+		}),
+		Entry("Bound claim migrated", testCaseDrain{
+			drain:               true,
+			bound:               true,
+			migrate:             true,
+			expectServedBy:      true,
+			expectedAllocations: map[string]ipamv1.IPAddressStr{},
+			expectedTargetAllocations: map[string]ipamv1.IPAddressStr{
+				"abc": "10.0.0.10",
+			},
+			expectedDrained: metav1.ConditionTrue,
+		}),
+		Entry("Migration requested without drain", testCaseDrain{
+			bound:   true,
+			migrate: true,
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"abc": "192.168.0.10",
+			},
+			expectedTargetAllocations: map[string]ipamv1.IPAddressStr{},
+		}),
+		Entry("Claim served by the target pool", testCaseDrain{
+			servedBy:            true,
+			expectServedBy:      true,
+			expectedAllocations: map[string]ipamv1.IPAddressStr{},
+			expectedTargetAllocations: map[string]ipamv1.IPAddressStr{
+				"abc": "10.0.0.10",
+			},
+		}),
+	)
+
+	type testCaseFallback struct {
+		exhausted                 bool
+		fallbackPools             []string
+		extraPools                []*ipamv1.IPPool
+		expectedServedBy          string
+		expectedErrorMessage      *string
+		expectedPool              *corev1.ObjectReference
+		expectedTargetAllocations map[string]ipamv1.IPAddressStr
+	}
+
+	newFallbackPool := func(name, start, end string) *ipamv1.IPPool {
+		return &ipamv1.IPPool{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "myns",
+			},
+			Spec: ipamv1.IPPoolSpec{
+				NamePrefix: name + "pref",
+				Pools: []ipamv1.Pool{
+					{
+						Start: (*ipamv1.IPAddressStr)(pointer.StringPtr(start)),
+						End:   (*ipamv1.IPAddressStr)(pointer.StringPtr(end)),
+					},
+				},
+			},
+		}
+	}
+
+	DescribeTable("Test UpdateAddresses with fallback pools",
+		func(tc testCaseFallback) {
+			ipPool := newFallbackPool("abc", "192.168.0.10", "192.168.0.10")
+			ipPool.Spec.FallbackPools = tc.fallbackPools
+
+			claim := &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:       "pending",
+					Namespace:  "myns",
+					Finalizers: []string{ipamv1.IPClaimFinalizer},
+				},
+				Spec: ipamv1.IPClaimSpec{
+					Pool: corev1.ObjectReference{Name: "abc"},
+				},
+			}
+			objects := []client.Object{claim}
+			if tc.exhausted {
+				objects = append(objects, &ipamv1.IPAddress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "abcpref-192-168-0-10",
+						Namespace: "myns",
+					},
+					Spec: ipamv1.IPAddressSpec{
+						Address: "192.168.0.10",
+						Pool:    corev1.ObjectReference{Name: "abc", Namespace: "myns"},
+						Claim:   corev1.ObjectReference{Name: "aaa", Namespace: "myns"},
+					},
+				})
+			}
+			for _, extraPool := range tc.extraPools {
+				objects = append(objects, extraPool)
+			}
+			c := fakeclient.NewClientBuilder().WithScheme(setupScheme()).WithObjects(objects...).Build()
+
+			ipPoolMgr, err := NewIPPoolManager(c, ipPool, klogr.New())
+			Expect(err).NotTo(HaveOccurred())
+			_, err = ipPoolMgr.UpdateAddresses(context.TODO())
+			if tc.expectedErrorMessage != nil {
+				Expect(err).To(HaveOccurred())
+			} else {
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			updatedClaim := &ipamv1.IPClaim{}
+			Expect(c.Get(context.TODO(), client.ObjectKey{Name: "pending", Namespace: "myns"}, updatedClaim)).To(Succeed())
+			Expect(updatedClaim.Status.ErrorMessage).To(Equal(tc.expectedErrorMessage))
+			if tc.expectedServedBy == "" {
+				Expect(updatedClaim.Annotations).NotTo(HaveKey(ipamv1.ServedByAnnotation))
+				Expect(updatedClaim.Status.Pool).To(Equal(tc.expectedPool))
+				return
+			}
+			Expect(updatedClaim.Annotations).To(HaveKeyWithValue(ipamv1.ServedByAnnotation, tc.expectedServedBy))
+			Expect(updatedClaim.Status.Address).To(BeNil())
+
+			// The fallback pool allocates the address of the claim
+			var targetPool *ipamv1.IPPool
+			for _, extraPool := range tc.extraPools {
+				if extraPool.Name == tc.expectedServedBy {
+					targetPool = extraPool
+				}
+			}
+			targetPoolMgr, err := NewIPPoolManager(c, targetPool, klogr.New())
+			Expect(err).NotTo(HaveOccurred())
+			_, err = targetPoolMgr.UpdateAddresses(context.TODO())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(targetPool.Status.Allocations).To(Equal(tc.expectedTargetAllocations))
+			Expect(c.Get(context.TODO(), client.ObjectKey{Name: "pending", Namespace: "myns"}, updatedClaim)).To(Succeed())
+			Expect(updatedClaim.Status.Address).NotTo(BeNil())
+			Expect(updatedClaim.Status.Pool).To(Equal(tc.expectedPool))
+		},
+		Entry("Pool with addresses available", testCaseFallback{
+			fallbackPools: []string{"bcd"},
+			extraPools:    []*ipamv1.IPPool{newFallbackPool("bcd", "10.0.0.10", "10.0.0.12")},
+			expectedPool:  &corev1.ObjectReference{Name: "abc", Namespace: "myns"},
+		}),
+		Entry("Exhausted pool without fallback pools", testCaseFallback{
+			exhausted:            true,
+			expectedErrorMessage: pointer.StringPtr("Exhausted IP Pools"),
+		}),
+		Entry("Exhausted pool with a fallback pool", testCaseFallback{
+			exhausted:        true,
+			fallbackPools:    []string{"bcd"},
+			extraPools:       []*ipamv1.IPPool{newFallbackPool("bcd", "10.0.0.10", "10.0.0.12")},
+			expectedServedBy: "bcd",
+			expectedPool:     &corev1.ObjectReference{Name: "bcd", Namespace: "myns"},
+			expectedTargetAllocations: map[string]ipamv1.IPAddressStr{
+				"pending": "10.0.0.10",
 			},
-			ipClaim: &ipamv1.IPClaim{
-				ObjectMeta: metav1.ObjectMeta{
-					Name: "abc",
-				},
+		}),
+		Entry("Missing and exhausted fallback pools skipped", testCaseFallback{
+			exhausted:     true,
+			fallbackPools: []string{"xyz", "bcd", "cde"},
+			extraPools: []*ipamv1.IPPool{
+				func() *ipamv1.IPPool {
+					ipPool := newFallbackPool("bcd", "10.0.0.10", "10.0.0.12")
+					ipPool.Status.Conditions = []metav1.Condition{{
+						Type:   ipamv1.IPPoolExhaustedCondition,
+						Status: metav1.ConditionTrue,
+					}}
+					return ipPool
+				}(),
+				newFallbackPool("cde", "10.0.1.10", "10.0.1.12"),
 			},
-			expectedAllocations: map[string]ipamv1.IPAddressStr{
-				"abc": ipamv1.IPAddressStr("192.168.0.12"),
+			expectedServedBy: "cde",
+			expectedPool:     &corev1.ObjectReference{Name: "cde", Namespace: "myns"},
+			expectedTargetAllocations: map[string]ipamv1.IPAddressStr{
+				"pending": "10.0.1.10",
 			},
-			expectedAddresses: map[ipamv1.IPAddressStr]string{
-				ipamv1.IPAddressStr("192.168.0.12"): "abc",
-				ipamv1.IPAddressStr("192.168.0.11"): "bcd",
+		}),
+		Entry("Draining fallback pool skipped", testCaseFallback{
+			exhausted:     true,
+			fallbackPools: []string{"bcd"},
+			extraPools: []*ipamv1.IPPool{
+				func() *ipamv1.IPPool {
+					ipPool := newFallbackPool("bcd", "10.0.0.10", "10.0.0.12")
+					ipPool.Spec.Drain = &ipamv1.PoolDrain{TargetPool: "cde"}
+					return ipPool
+				}(),
 			},
-			expectedIPAddresses: []string{"abcpref-192-168-0-12"},
+			expectedErrorMessage: pointer.StringPtr("Exhausted IP Pools"),
 		}),
-		Entry("Not allocated yet, conflict", testCaseCreateAddresses{
-			ipPool: &ipamv1.IPPool{
+	)
+
+	type testCaseExternallyManaged struct {
+		ipClaim              *ipamv1.IPClaim
+		ipAddress            *ipamv1.IPAddress
+		expectedAddress      *corev1.ObjectReference
+		expectedErrorMessage *string
+		expectedLabels       map[string]string
+		expectedAnnotations  map[string]string
+		expectAddressObject  bool
+	}
+
+	DescribeTable("Test UpdateAddresses of an externally managed pool",
+		func(tc testCaseExternallyManaged) {
+			objects := []client.Object{tc.ipClaim}
+			if tc.ipAddress != nil {
+				objects = append(objects, tc.ipAddress)
+			}
+			c := fakeclient.NewClientBuilder().WithScheme(setupScheme()).WithObjects(objects...).Build()
+			ipPoolMgr, err := NewIPPoolManager(c, &ipamv1.IPPool{
 				ObjectMeta: ipPoolMeta,
 				Spec: ipamv1.IPPoolSpec{
+					NamePrefix:            "abcpref",
+					ExternallyManaged:     true,
+					PropagatedAnnotations: []string{"example.com/*"},
 					Pools: []ipamv1.Pool{
 						{
-							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.11")),
-							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.20")),
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.10")),
 						},
 					},
-					NamePrefix: "abcpref",
 				},
-				Status: ipamv1.IPPoolStatus{
-					Allocations: map[string]ipamv1.IPAddressStr{},
+			}, klogr.New())
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = ipPoolMgr.UpdateAddresses(context.TODO())
+			Expect(err).NotTo(HaveOccurred())
+
+			claim := &ipamv1.IPClaim{}
+			err = c.Get(context.TODO(), client.ObjectKeyFromObject(tc.ipClaim), claim)
+			if err == nil {
+				Expect(claim.Status.Address).To(Equal(tc.expectedAddress))
+				Expect(claim.Status.ErrorMessage).To(Equal(tc.expectedErrorMessage))
+			}
+
+			addresses := ipamv1.IPAddressList{}
+			Expect(c.List(context.TODO(), &addresses)).To(Succeed())
+			if !tc.expectAddressObject {
+				Expect(addresses.Items).To(BeEmpty())
+				return
+			}
+			Expect(addresses.Items).To(HaveLen(1))
+			Expect(addresses.Items[0].Labels).To(Equal(tc.expectedLabels))
+			Expect(addresses.Items[0].Annotations).To(Equal(tc.expectedAnnotations))
+		},
+		Entry("IPAddress not imported", testCaseExternallyManaged{
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "abc",
+					Namespace: "myns",
+				},
+				Spec: ipamv1.IPClaimSpec{
+					Pool: corev1.ObjectReference{Name: "abc"},
 				},
 			},
-			addresses: map[ipamv1.IPAddressStr]string{},
+			expectedErrorMessage: pointer.StringPtr("Waiting for the IPAddress to be imported"),
+		}),
+		Entry("IPAddress imported", testCaseExternallyManaged{
 			ipClaim: &ipamv1.IPClaim{
 				ObjectMeta: metav1.ObjectMeta{
-					Name: "abc",
+					Name:      "abc",
+					Namespace: "myns",
+					Labels:    map[string]string{"rack": "r1"},
+					Annotations: map[string]string{
+						"example.com/ticket": "T-1",
+						"other.com/ticket":   "T-2",
+					},
+				},
+				Spec: ipamv1.IPClaimSpec{
+					Pool: corev1.ObjectReference{Name: "abc"},
 				},
 			},
-			ipAddresses: []*ipamv1.IPAddress{
-				{
-					ObjectMeta: metav1.ObjectMeta{
-						Name:      "abcpref-192-168-0-11",
-						Namespace: "myns",
-					},
-					Spec: ipamv1.IPAddressSpec{
-						Address: "192.168.0.11",
-						Pool: corev1.ObjectReference{
-							Name: "abc",
-						},
-						Claim: corev1.ObjectReference{
-							Name: "bcd",
-						},
-					},
+			ipAddress: &ipamv1.IPAddress{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "abcpref-192-168-1-11",
+					Namespace: "myns",
+					Labels:    map[string]string{"imported": "true"},
+				},
+				Spec: ipamv1.IPAddressSpec{
+					Pool:    corev1.ObjectReference{Name: "abc"},
+					Claim:   corev1.ObjectReference{Name: "abc"},
+					Address: "192.168.1.11",
 				},
 			},
-			expectedAllocations: map[string]ipamv1.IPAddressStr{},
-			expectedAddresses:   map[ipamv1.IPAddressStr]string{},
-			expectedIPAddresses: []string{"abcpref-192-168-0-11"},
-			expectRequeue:       true,
+			expectedAddress: &corev1.ObjectReference{
+				Name:      "abcpref-192-168-1-11",
+				Namespace: "myns",
+			},
+			expectAddressObject: true,
+			expectedLabels:      map[string]string{"imported": "true", "rack": "r1"},
+			expectedAnnotations: map[string]string{"example.com/ticket": "T-1"},
 		}),
-		Entry("Not allocated yet, exhausted pool", testCaseCreateAddresses{
-			ipPool: &ipamv1.IPPool{
-				ObjectMeta: ipPoolMeta,
-				Spec: ipamv1.IPPoolSpec{
-					Pools: []ipamv1.Pool{
-						{
-							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.11")),
-							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.11")),
-						},
-					},
-					NamePrefix: "abcpref",
+		Entry("IPAddress imported under another name", testCaseExternallyManaged{
+			ipClaim: &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "abc",
+					Namespace: "myns",
 				},
-				Status: ipamv1.IPPoolStatus{
-					Allocations: map[string]ipamv1.IPAddressStr{},
+				Spec: ipamv1.IPClaimSpec{
+					Pool: corev1.ObjectReference{Name: "abc"},
 				},
 			},
-			addresses: map[ipamv1.IPAddressStr]string{
-				ipamv1.IPAddressStr("192.168.0.11"): "bcd",
+			ipAddress: &ipamv1.IPAddress{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "legacy-1",
+					Namespace: "myns",
+				},
+				Spec: ipamv1.IPAddressSpec{
+					Pool:    corev1.ObjectReference{Name: "abc"},
+					Claim:   corev1.ObjectReference{Name: "abc"},
+					Address: "192.168.1.11",
+				},
 			},
+			expectedErrorMessage: pointer.StringPtr("Imported IPAddress not named abcpref-192-168-1-11"),
+			expectAddressObject:  true,
+		}),
+		Entry("Claim deleted", testCaseExternallyManaged{
 			ipClaim: &ipamv1.IPClaim{
 				ObjectMeta: metav1.ObjectMeta{
-					Name: "abc",
+					Name:              "abc",
+					Namespace:         "myns",
+					DeletionTimestamp: &timeNow,
+					Finalizers:        []string{ipamv1.IPClaimFinalizer},
+				},
+				Spec: ipamv1.IPClaimSpec{
+					Pool: corev1.ObjectReference{Name: "abc"},
+				},
+				Status: ipamv1.IPClaimStatus{
+					Address: &corev1.ObjectReference{
+						Name:      "abcpref-192-168-1-11",
+						Namespace: "myns",
+					},
 				},
 			},
-			expectedAllocations: map[string]ipamv1.IPAddressStr{},
-			expectedAddresses: map[ipamv1.IPAddressStr]string{
-				ipamv1.IPAddressStr("192.168.0.11"): "bcd",
+			ipAddress: &ipamv1.IPAddress{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "abcpref-192-168-1-11",
+					Namespace: "myns",
+				},
+				Spec: ipamv1.IPAddressSpec{
+					Pool:    corev1.ObjectReference{Name: "abc"},
+					Claim:   corev1.ObjectReference{Name: "abc"},
+					Address: "192.168.1.11",
+				},
 			},
-			expectedIPAddresses: []string{},
-			expectError:         true,
+			expectAddressObject: true,
 		}),
 	)
 
-	type testCaseAllocateAddress struct {
-		ipPool             *ipamv1.IPPool
-		ipClaim            *ipamv1.IPClaim
-		addresses          map[ipamv1.IPAddressStr]string
-		expectedAddress    ipamv1.IPAddressStr
-		expectedPrefix     int
-		expectedGateway    *ipamv1.IPAddressStr
-		expectedDNSServers []ipamv1.IPAddressStr
-		expectError        bool
+	type testCaseAllocateAddressSet struct {
+		ipPool              *ipamv1.IPPool
+		ipClaim             *ipamv1.IPClaim
+		addresses           map[ipamv1.IPAddressStr]string
+		expectedAllocations map[string]ipamv1.IPAddressStr
+		expectError         bool
 	}
 
-	DescribeTable("Test AllocateAddress",
-		func(tc testCaseAllocateAddress) {
+	DescribeTable("Test allocateAddressSet",
+		func(tc testCaseAllocateAddressSet) {
 			ipPoolMgr, err := NewIPPoolManager(nil, tc.ipPool,
 				klogr.New(),
 			)
 			Expect(err).NotTo(HaveOccurred())
-			allocatedAddress, prefix, gateway, dnsServers, err := ipPoolMgr.allocateAddress(
-				tc.ipClaim, tc.addresses,
+			allocations, err := ipPoolMgr.allocateAddressSet(
+				tc.ipClaim, ipPoolMgr.addressRoles(tc.ipClaim), tc.addresses,
 			)
 			if tc.expectError {
 				Expect(err).To(HaveOccurred())
+				Expect(tc.ipClaim.Status.ErrorMessage).NotTo(BeNil())
 				return
 			}
 			Expect(err).NotTo(HaveOccurred())
-			Expect(allocatedAddress).To(Equal(tc.expectedAddress))
-			Expect(prefix).To(Equal(tc.expectedPrefix))
-			Expect(*gateway).To(Equal(*tc.expectedGateway))
-			Expect(dnsServers).To(Equal(tc.expectedDNSServers))
+			Expect(tc.ipClaim.Status.ErrorMessage).To(BeNil())
+			allocatedAddresses := map[string]ipamv1.IPAddressStr{}
+			for role, allocation := range allocations {
+				allocatedAddresses[role] = allocation.address
+			}
+			Expect(allocatedAddresses).To(Equal(tc.expectedAllocations))
 		},
-		Entry("Empty pools", testCaseAllocateAddress{
+		Entry("Single address", testCaseAllocateAddressSet{
 			ipPool: &ipamv1.IPPool{
-				Spec: ipamv1.IPPoolSpec{},
+				Spec: ipamv1.IPPoolSpec{
+					Pools: []ipamv1.Pool{
+						{
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.11")),
+						},
+					},
+				},
 			},
 			ipClaim: &ipamv1.IPClaim{
 				ObjectMeta: metav1.ObjectMeta{
-					Name: "abc",
+					Name: "TestRef",
 				},
 			},
-			expectError: true,
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"": ipamv1.IPAddressStr("192.168.0.10"),
+			},
 		}),
-		Entry("One pool, pre-allocated", testCaseAllocateAddress{
+		Entry("HA address set in the second pool", testCaseAllocateAddressSet{
 			ipPool: &ipamv1.IPPool{
 				Spec: ipamv1.IPPoolSpec{
 					Pools: []ipamv1.Pool{
 						{
-							Start:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.11")),
-							End:     (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.20")),
-							Prefix:  26,
-							Gateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.1")),
-							DNSServers: []ipamv1.IPAddressStr{
-								ipamv1.IPAddressStr("8.8.8.8"),
-							},
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.12")),
 						},
 						{
-							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.21")),
-							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.30")),
+							Subnet: (*ipamv1.IPSubnetStr)(pointer.StringPtr("192.168.1.0/24")),
 						},
 					},
 					PreAllocations: map[string]ipamv1.IPAddressStr{
-						"TestRef": ipamv1.IPAddressStr("192.168.0.21"),
-					},
-					Prefix:  24,
-					Gateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.1")),
-					DNSServers: []ipamv1.IPAddressStr{
-						ipamv1.IPAddressStr("8.8.4.4"),
+						"TestRef:vip": ipamv1.IPAddressStr("192.168.1.100"),
 					},
 				},
 			},
@@ -760,35 +5567,32 @@ var _ = Describe("IPPool manager", func() {
 				ObjectMeta: metav1.ObjectMeta{
 					Name: "TestRef",
 				},
+				Spec: ipamv1.IPClaimSpec{
+					HAAddressSet: true,
+				},
 			},
-			expectedAddress: ipamv1.IPAddressStr("192.168.0.21"),
-			expectedGateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.1")),
-			expectedDNSServers: []ipamv1.IPAddressStr{
-				ipamv1.IPAddressStr("8.8.4.4"),
+			addresses: map[ipamv1.IPAddressStr]string{
+				ipamv1.IPAddressStr("192.168.0.10"): "abcd",
+				ipamv1.IPAddressStr("192.168.1.1"):  "bcde",
+			},
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"":                "192.168.1.2",
+				ipamv1.HAPeerRole: "192.168.1.3",
+				ipamv1.HAVIPRole:  "192.168.1.100",
 			},
-			expectedPrefix: 24,
 		}),
-		Entry("One pool, pre-allocated, with overrides", testCaseAllocateAddress{
+		Entry("Roles from different pools", testCaseAllocateAddressSet{
 			ipPool: &ipamv1.IPPool{
 				Spec: ipamv1.IPPoolSpec{
 					Pools: []ipamv1.Pool{
 						{
-							Start:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.11")),
-							End:     (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.20")),
-							Prefix:  26,
-							Gateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.1")),
-							DNSServers: []ipamv1.IPAddressStr{
-								ipamv1.IPAddressStr("8.8.8.8"),
-							},
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.11")),
+						},
+						{
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.10")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.11")),
 						},
-					},
-					PreAllocations: map[string]ipamv1.IPAddressStr{
-						"TestRef": ipamv1.IPAddressStr("192.168.0.15"),
-					},
-					Prefix:  24,
-					Gateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.1")),
-					DNSServers: []ipamv1.IPAddressStr{
-						ipamv1.IPAddressStr("8.8.4.4"),
 					},
 				},
 			},
@@ -796,130 +5600,110 @@ var _ = Describe("IPPool manager", func() {
 				ObjectMeta: metav1.ObjectMeta{
 					Name: "TestRef",
 				},
+				Spec: ipamv1.IPClaimSpec{
+					Roles: []string{"secondary", "bmc"},
+				},
 			},
-			expectedAddress: ipamv1.IPAddressStr("192.168.0.15"),
-			expectedGateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.1")),
-			expectedDNSServers: []ipamv1.IPAddressStr{
-				ipamv1.IPAddressStr("8.8.8.8"),
+			addresses: map[ipamv1.IPAddressStr]string{
+				ipamv1.IPAddressStr("192.168.0.10"): "abcd",
+			},
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"":          "192.168.0.11",
+				"secondary": "192.168.1.10",
+				"bmc":       "192.168.1.11",
 			},
-			expectedPrefix: 26,
 		}),
-		Entry("One pool, pre-allocated, out of bonds", testCaseAllocateAddress{
+		Entry("Roles, not enough addresses", testCaseAllocateAddressSet{
 			ipPool: &ipamv1.IPPool{
 				Spec: ipamv1.IPPoolSpec{
 					Pools: []ipamv1.Pool{
 						{
-							Start:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.11")),
-							End:     (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.20")),
-							Prefix:  26,
-							Gateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.1")),
-							DNSServers: []ipamv1.IPAddressStr{
-								ipamv1.IPAddressStr("8.8.8.8"),
-							},
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.11")),
 						},
 					},
-					PreAllocations: map[string]ipamv1.IPAddressStr{
-						"TestRef": ipamv1.IPAddressStr("192.168.0.21"),
-					},
-					Prefix:  24,
-					Gateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.1")),
-					DNSServers: []ipamv1.IPAddressStr{
-						ipamv1.IPAddressStr("8.8.4.4"),
-					},
 				},
 			},
 			ipClaim: &ipamv1.IPClaim{
 				ObjectMeta: metav1.ObjectMeta{
 					Name: "TestRef",
 				},
+				Spec: ipamv1.IPClaimSpec{
+					Roles: []string{"secondary", "bmc"},
+				},
 			},
 			expectError: true,
 		}),
-		Entry("One pool, with start and existing address", testCaseAllocateAddress{
+		Entry("Address count in the second pool", testCaseAllocateAddressSet{
 			ipPool: &ipamv1.IPPool{
 				Spec: ipamv1.IPPoolSpec{
 					Pools: []ipamv1.Pool{
 						{
-							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.11")),
-							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.20")),
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.11")),
+						},
+						{
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.10")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.12")),
 						},
 					},
-					Prefix:  24,
-					Gateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.1")),
 				},
 			},
 			ipClaim: &ipamv1.IPClaim{
 				ObjectMeta: metav1.ObjectMeta{
 					Name: "TestRef",
 				},
+				Spec: ipamv1.IPClaimSpec{
+					AddressCount: pointer.IntPtr(3),
+				},
 			},
 			addresses: map[ipamv1.IPAddressStr]string{
-				ipamv1.IPAddressStr("192.168.0.12"): "bcde",
-				ipamv1.IPAddressStr("192.168.0.11"): "abcd",
+				ipamv1.IPAddressStr("192.168.0.10"): "abcd",
+			},
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"":          "192.168.1.10",
+				"address-1": "192.168.1.11",
+				"address-2": "192.168.1.12",
 			},
-			expectedAddress: ipamv1.IPAddressStr("192.168.0.13"),
-			expectedGateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.1")),
-			expectedPrefix:  24,
 		}),
-		Entry("One pool, with subnet and override prefix", testCaseAllocateAddress{
+		Entry("Address count, not enough addresses", testCaseAllocateAddressSet{
 			ipPool: &ipamv1.IPPool{
 				Spec: ipamv1.IPPoolSpec{
 					Pools: []ipamv1.Pool{
 						{
-							Start:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.11")),
-							End:     (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.20")),
-							Prefix:  24,
-							Gateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.1")),
-							DNSServers: []ipamv1.IPAddressStr{
-								ipamv1.IPAddressStr("8.8.8.8"),
-							},
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.12")),
 						},
 					},
-					Prefix:  26,
-					Gateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.1")),
-					DNSServers: []ipamv1.IPAddressStr{
-						ipamv1.IPAddressStr("8.8.4.4"),
-					},
 				},
 			},
 			ipClaim: &ipamv1.IPClaim{
 				ObjectMeta: metav1.ObjectMeta{
 					Name: "TestRef",
 				},
+				Spec: ipamv1.IPClaimSpec{
+					AddressCount: pointer.IntPtr(3),
+				},
 			},
 			addresses: map[ipamv1.IPAddressStr]string{
-				ipamv1.IPAddressStr("192.168.0.12"): "bcde",
 				ipamv1.IPAddressStr("192.168.0.11"): "abcd",
 			},
-			expectedAddress: ipamv1.IPAddressStr("192.168.0.13"),
-			expectedGateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.1")),
-			expectedDNSServers: []ipamv1.IPAddressStr{
-				ipamv1.IPAddressStr("8.8.8.8"),
-			},
-			expectedPrefix: 24,
+			expectError: true,
 		}),
-		Entry("two pools, with subnet and override prefix in first", testCaseAllocateAddress{
+		Entry("Dual-stack pool", testCaseAllocateAddressSet{
 			ipPool: &ipamv1.IPPool{
 				Spec: ipamv1.IPPoolSpec{
 					Pools: []ipamv1.Pool{
 						{
-							Start:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
-							End:     (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
-							Prefix:  24,
-							Gateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.1")),
-							DNSServers: []ipamv1.IPAddressStr{
-								ipamv1.IPAddressStr("8.8.8.8"),
-							},
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("2001:db8::10")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("2001:db8::11")),
 						},
 						{
-							Subnet: (*ipamv1.IPSubnetStr)(pointer.StringPtr("192.168.1.10/24")),
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.12")),
 						},
 					},
-					Prefix:  26,
-					Gateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.2.1")),
-					DNSServers: []ipamv1.IPAddressStr{
-						ipamv1.IPAddressStr("8.8.4.4"),
-					},
+					DualStack: true,
 				},
 			},
 			ipClaim: &ipamv1.IPClaim{
@@ -927,68 +5711,59 @@ var _ = Describe("IPPool manager", func() {
 					Name: "TestRef",
 				},
 			},
-			addresses: map[ipamv1.IPAddressStr]string{
-				ipamv1.IPAddressStr("192.168.1.11"): "bcde",
-				ipamv1.IPAddressStr("192.168.0.10"): "abcd",
-			},
-			expectedAddress: ipamv1.IPAddressStr("192.168.1.12"),
-			expectedGateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.2.1")),
-			expectedDNSServers: []ipamv1.IPAddressStr{
-				ipamv1.IPAddressStr("8.8.4.4"),
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"":                       "192.168.0.10",
+				ipamv1.DualStackIPv6Role: "2001:db8::10",
 			},
-			expectedPrefix: 26,
 		}),
-		Entry("two pools, with subnet and override prefix", testCaseAllocateAddress{
+		Entry("Dual-stack pool with an HA address set", testCaseAllocateAddressSet{
 			ipPool: &ipamv1.IPPool{
 				Spec: ipamv1.IPPoolSpec{
 					Pools: []ipamv1.Pool{
 						{
-							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
-							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("2001:db8::10")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("2001:db8::11")),
 						},
 						{
-							Subnet:  (*ipamv1.IPSubnetStr)(pointer.StringPtr("192.168.1.10/24")),
-							Prefix:  24,
-							Gateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.1")),
-							DNSServers: []ipamv1.IPAddressStr{
-								ipamv1.IPAddressStr("8.8.8.8"),
-							},
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.12")),
 						},
 					},
-					Prefix:  26,
-					Gateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.2.1")),
-					DNSServers: []ipamv1.IPAddressStr{
-						ipamv1.IPAddressStr("8.8.4.4"),
-					},
+					DualStack: true,
 				},
 			},
 			ipClaim: &ipamv1.IPClaim{
 				ObjectMeta: metav1.ObjectMeta{
 					Name: "TestRef",
 				},
+				Spec: ipamv1.IPClaimSpec{
+					HAAddressSet: true,
+				},
 			},
 			addresses: map[ipamv1.IPAddressStr]string{
-				ipamv1.IPAddressStr("192.168.1.11"): "bcde",
-				ipamv1.IPAddressStr("192.168.0.10"): "abcd",
+				ipamv1.IPAddressStr("2001:db8::10"): "abcd",
 			},
-			expectedAddress: ipamv1.IPAddressStr("192.168.1.12"),
-			expectedGateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.1")),
-			expectedDNSServers: []ipamv1.IPAddressStr{
-				ipamv1.IPAddressStr("8.8.8.8"),
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"":                       "192.168.0.10",
+				ipamv1.HAPeerRole:        "192.168.0.11",
+				ipamv1.HAVIPRole:         "192.168.0.12",
+				ipamv1.DualStackIPv6Role: "2001:db8::11",
 			},
-			expectedPrefix: 24,
 		}),
-		Entry("Exhausted pools start", testCaseAllocateAddress{
+		Entry("Dual-stack pool, IPv6 pools exhausted", testCaseAllocateAddressSet{
 			ipPool: &ipamv1.IPPool{
 				Spec: ipamv1.IPPoolSpec{
 					Pools: []ipamv1.Pool{
+						{
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("2001:db8::10")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("2001:db8::11")),
+						},
 						{
 							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
-							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.12")),
 						},
 					},
-					Prefix:  24,
-					Gateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.1")),
+					DualStack: true,
 				},
 			},
 			ipClaim: &ipamv1.IPClaim{
@@ -997,149 +5772,414 @@ var _ = Describe("IPPool manager", func() {
 				},
 			},
 			addresses: map[ipamv1.IPAddressStr]string{
-				ipamv1.IPAddressStr("192.168.0.10"): "abcd",
+				ipamv1.IPAddressStr("2001:db8::10"): "abcd",
+				ipamv1.IPAddressStr("2001:db8::11"): "bcde",
 			},
 			expectError: true,
 		}),
-		Entry("Exhausted pools subnet", testCaseAllocateAddress{
+		Entry("HA address set, not enough addresses", testCaseAllocateAddressSet{
 			ipPool: &ipamv1.IPPool{
 				Spec: ipamv1.IPPoolSpec{
 					Pools: []ipamv1.Pool{
 						{
-							Subnet: (*ipamv1.IPSubnetStr)(pointer.StringPtr("192.168.0.0/30")),
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.12")),
+						},
+						{
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.10")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.1.11")),
 						},
 					},
-					Prefix:  24,
-					Gateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.1")),
 				},
 			},
 			ipClaim: &ipamv1.IPClaim{
 				ObjectMeta: metav1.ObjectMeta{
 					Name: "TestRef",
 				},
+				Spec: ipamv1.IPClaimSpec{
+					HAAddressSet: true,
+				},
 			},
 			addresses: map[ipamv1.IPAddressStr]string{
-				ipamv1.IPAddressStr("192.168.0.1"): "abcd",
-				ipamv1.IPAddressStr("192.168.0.2"): "abcd",
-				ipamv1.IPAddressStr("192.168.0.3"): "abcd",
+				ipamv1.IPAddressStr("192.168.0.10"): "abcd",
 			},
 			expectError: true,
 		}),
 	)
 
-	type testCaseDeleteAddresses struct {
-		ipPool              *ipamv1.IPPool
-		ipClaim             *ipamv1.IPClaim
-		m3addresses         []*ipamv1.IPAddress
-		addresses           map[ipamv1.IPAddressStr]string
-		expectedAddresses   map[ipamv1.IPAddressStr]string
+	type testCaseClusterIPPool struct {
+		allowedNamespaces   []string
+		claims              []*ipamv1.IPClaim
+		allocations         map[string]ipamv1.IPAddressStr
+		ipAddresses         []*ipamv1.IPAddress
 		expectedAllocations map[string]ipamv1.IPAddressStr
-		expectError         bool
 	}
 
-	DescribeTable("Test DeleteAddresses",
-		func(tc testCaseDeleteAddresses) {
-			objects := []client.Object{}
-			for _, address := range tc.m3addresses {
+	clusterClaim := func(namespace, name string) *ipamv1.IPClaim {
+		return &ipamv1.IPClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+			},
+			Spec: ipamv1.IPClaimSpec{
+				Pool: corev1.ObjectReference{Name: "abc", Kind: ipamv1.ClusterIPPoolKind},
+			},
+		}
+	}
+
+	DescribeTable("Test UpdateAddresses with a ClusterIPPool",
+		func(tc testCaseClusterIPPool) {
+			clusterIPPool := &ipamv1.ClusterIPPool{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "abc",
+				},
+				Spec: ipamv1.IPPoolSpec{
+					NamePrefix: "abcpref",
+					Pools: []ipamv1.Pool{
+						{
+							Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+							End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.20")),
+						},
+					},
+					AllowedNamespaces: tc.allowedNamespaces,
+				},
+				Status: ipamv1.IPPoolStatus{
+					Allocations: tc.allocations,
+				},
+			}
+			// The IPAddress of an IPPool of the same name is not part of the
+			// ClusterIPPool
+			objects := []client.Object{
+				&ipamv1.IPAddress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "other-192-168-0-10",
+						Namespace: "nsb",
+					},
+					Spec: ipamv1.IPAddressSpec{
+						Address: "192.168.0.10",
+						Pool:    corev1.ObjectReference{Name: "abc", Namespace: "nsb"},
+						Claim:   corev1.ObjectReference{Name: "other", Namespace: "nsb"},
+					},
+				},
+			}
+			for _, claim := range tc.claims {
+				objects = append(objects, claim)
+			}
+			for _, address := range tc.ipAddresses {
 				objects = append(objects, address)
 			}
 			c := fakeclient.NewClientBuilder().WithScheme(setupScheme()).WithObjects(objects...).Build()
-			ipPoolMgr, err := NewIPPoolManager(c, tc.ipPool,
-				klogr.New(),
-			)
+			ipPool := clusterIPPool.AsIPPool()
+			ipPoolMgr, err := NewIPPoolManager(c, ipPool, klogr.New())
 			Expect(err).NotTo(HaveOccurred())
 
-			allocatedMap, err := ipPoolMgr.deleteAddress(context.TODO(), tc.ipClaim, tc.addresses)
-			if tc.expectError {
-				Expect(err).To(HaveOccurred())
-			} else {
-				Expect(err).NotTo(HaveOccurred())
+			count, err := ipPoolMgr.UpdateAddresses(context.TODO())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(count).To(Equal(len(tc.expectedAllocations)))
+			Expect(ipPool.Status.Allocations).To(Equal(tc.expectedAllocations))
+
+			for _, claim := range tc.claims {
+				key := client.ObjectKeyFromObject(claim)
+				savedClaim := &ipamv1.IPClaim{}
+				Expect(c.Get(context.TODO(), key, savedClaim)).To(Succeed())
+				address, ok := tc.expectedAllocations[key.String()]
+				if !ok {
+					Expect(savedClaim.Status.Address).To(BeNil())
+					continue
+				}
+				// The IPAddress is in the namespace of the claim
+				Expect(savedClaim.Status.Address).NotTo(BeNil())
+				Expect(savedClaim.Status.Address.Namespace).To(Equal(claim.Namespace))
+				addressObject := &ipamv1.IPAddress{}
+				Expect(c.Get(context.TODO(), client.ObjectKey{
+					Name:      savedClaim.Status.Address.Name,
+					Namespace: claim.Namespace,
+				}, addressObject)).To(Succeed())
+				Expect(addressObject.Spec.Address).To(Equal(address))
+				Expect(ipamv1.IsClusterIPPoolRef(addressObject.Spec.Pool)).To(BeTrue())
 			}
+		},
+		Entry("Claims from all namespaces", testCaseClusterIPPool{
+			claims: []*ipamv1.IPClaim{
+				clusterClaim("nsa", "claim1"),
+			},
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"nsa/claim1": "192.168.0.10",
+			},
+		}),
+		Entry("Claim from a namespace not allowed", testCaseClusterIPPool{
+			allowedNamespaces: []string{"nsa"},
+			claims: []*ipamv1.IPClaim{
+				clusterClaim("nsa", "claim1"),
+				clusterClaim("nsb", "claim2"),
+			},
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"nsa/claim1": "192.168.0.10",
+			},
+		}),
+		Entry("Claim referencing an IPPool of the same name", testCaseClusterIPPool{
+			claims: []*ipamv1.IPClaim{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "claim1",
+						Namespace: "nsb",
+					},
+					Spec: ipamv1.IPClaimSpec{
+						Pool: corev1.ObjectReference{Name: "abc"},
+					},
+				},
+			},
+			expectedAllocations: map[string]ipamv1.IPAddressStr{},
+		}),
+		Entry("Existing allocation kept", testCaseClusterIPPool{
+			claims: []*ipamv1.IPClaim{
+				clusterClaim("nsb", "claim2"),
+			},
+			allocations: map[string]ipamv1.IPAddressStr{
+				"nsb/claim2": "192.168.0.15",
+			},
+			ipAddresses: []*ipamv1.IPAddress{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "abcpref-192-168-0-15",
+						Namespace: "nsb",
+					},
+					Spec: ipamv1.IPAddressSpec{
+						Address: "192.168.0.15",
+						Pool:    corev1.ObjectReference{Name: "abc", Kind: ipamv1.ClusterIPPoolKind},
+						Claim:   corev1.ObjectReference{Name: "claim2", Namespace: "nsb"},
+					},
+				},
+			},
+			expectedAllocations: map[string]ipamv1.IPAddressStr{
+				"nsb/claim2": "192.168.0.15",
+			},
+		}),
+	)
 
-			// get list of IPAddress objects
-			addressObjects := ipamv1.IPAddressList{}
-			opts := &client.ListOptions{}
-			err = c.List(context.TODO(), &addressObjects, opts)
-			Expect(err).NotTo(HaveOccurred())
+	type testCaseDelegatedPrefix struct {
+		pools                   []ipamv1.Pool
+		preAllocations          map[string]ipamv1.IPAddressStr
+		claimSubnet             *ipamv1.IPSubnetStr
+		addresses               map[ipamv1.IPAddressStr]string
+		delegatedPrefix         int
+		expectedDelegatedPrefix ipamv1.IPSubnetStr
+		expectError             bool
+	}
 
-			Expect(len(addressObjects.Items)).To(Equal(0))
+	DescribeTable("Test allocateRoleAddress with delegated prefixes",
+		func(tc testCaseDelegatedPrefix) {
+			ipPool := &ipamv1.IPPool{
+				Spec: ipamv1.IPPoolSpec{
+					Pools:           tc.pools,
+					PreAllocations:  tc.preAllocations,
+					DelegatedPrefix: tc.delegatedPrefix,
+				},
+			}
+			ipClaim := &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "abc",
+				},
+				Spec: ipamv1.IPClaimSpec{
+					Subnet: tc.claimSubnet,
+				},
+			}
+			ipPoolMgr, err := NewIPPoolManager(nil, ipPool, klogr.New())
+			Expect(err).NotTo(HaveOccurred())
 
-			Expect(tc.ipPool.Status.LastUpdated.IsZero()).To(BeFalse())
-			Expect(allocatedMap).To(Equal(tc.expectedAddresses))
-			Expect(tc.ipPool.Status.Allocations).To(Equal(tc.expectedAllocations))
-			Expect(len(tc.ipClaim.Finalizers)).To(Equal(0))
+			allocation, _, err := ipPoolMgr.allocateRoleAddress(ipClaim, "", tc.addresses, anyPool)
+			if tc.expectError {
+				Expect(err).To(HaveOccurred())
+				Expect(ipClaim.Status.ErrorMessage).NotTo(BeNil())
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(allocation.delegatedPrefix).NotTo(BeNil())
+			Expect(*allocation.delegatedPrefix).To(Equal(tc.expectedDelegatedPrefix))
+			Expect(string(allocation.address)).To(Equal(
+				strings.Split(string(tc.expectedDelegatedPrefix), "/")[0],
+			))
 		},
-		Entry("Empty IPPool", testCaseDeleteAddresses{
-			ipPool: &ipamv1.IPPool{},
-			ipClaim: &ipamv1.IPClaim{
-				ObjectMeta: metav1.ObjectMeta{
-					Name: "TestRef",
+		Entry("First prefix of a range", testCaseDelegatedPrefix{
+			pools: []ipamv1.Pool{
+				{
+					Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+					End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.40")),
 				},
 			},
+			delegatedPrefix:         29,
+			expectedDelegatedPrefix: "192.168.0.16/29",
 		}),
-		Entry("No Deletion needed", testCaseDeleteAddresses{
-			ipPool: &ipamv1.IPPool{},
-			ipClaim: &ipamv1.IPClaim{
-				ObjectMeta: metav1.ObjectMeta{
-					Name: "TestRef",
+		Entry("First prefix allocated", testCaseDelegatedPrefix{
+			pools: []ipamv1.Pool{
+				{
+					Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+					End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.40")),
 				},
 			},
-			expectedAddresses: map[ipamv1.IPAddressStr]string{ipamv1.IPAddressStr("192.168.0.1"): "abcd"},
 			addresses: map[ipamv1.IPAddressStr]string{
-				ipamv1.IPAddressStr("192.168.0.1"): "abcd",
+				"192.168.0.16": "bcd",
 			},
+			delegatedPrefix:         29,
+			expectedDelegatedPrefix: "192.168.0.24/29",
 		}),
-		Entry("Deletion needed, not found", testCaseDeleteAddresses{
-			ipPool: &ipamv1.IPPool{
-				Status: ipamv1.IPPoolStatus{
-					Allocations: map[string]ipamv1.IPAddressStr{
-						"TestRef": ipamv1.IPAddressStr("192.168.0.1"),
-					},
+		Entry("Subnet, starting at the network address", testCaseDelegatedPrefix{
+			pools: []ipamv1.Pool{
+				{
+					Subnet: (*ipamv1.IPSubnetStr)(pointer.StringPtr("192.168.0.0/24")),
 				},
 			},
-			ipClaim: &ipamv1.IPClaim{
-				ObjectMeta: metav1.ObjectMeta{
-					Name: "TestRef",
+			delegatedPrefix:         26,
+			expectedDelegatedPrefix: "192.168.0.0/26",
+		}),
+		Entry("IPv6 subnet", testCaseDelegatedPrefix{
+			pools: []ipamv1.Pool{
+				{
+					Subnet: (*ipamv1.IPSubnetStr)(pointer.StringPtr("2001:db8::/56")),
 				},
 			},
 			addresses: map[ipamv1.IPAddressStr]string{
-				ipamv1.IPAddressStr("192.168.0.1"): "TestRef",
+				"2001:db8::": "bcd",
 			},
-			expectedAllocations: map[string]ipamv1.IPAddressStr{},
-			expectedAddresses:   map[ipamv1.IPAddressStr]string{},
+			delegatedPrefix:         64,
+			expectedDelegatedPrefix: "2001:db8:0:1::/64",
 		}),
-		Entry("Deletion needed", testCaseDeleteAddresses{
-			ipPool: &ipamv1.IPPool{
-				Spec: ipamv1.IPPoolSpec{
-					NamePrefix: "abc",
+		Entry("Claim subnet", testCaseDelegatedPrefix{
+			pools: []ipamv1.Pool{
+				{
+					Subnet: (*ipamv1.IPSubnetStr)(pointer.StringPtr("192.168.0.0/24")),
 				},
-				Status: ipamv1.IPPoolStatus{
-					Allocations: map[string]ipamv1.IPAddressStr{
-						"TestRef": ipamv1.IPAddressStr("192.168.0.1"),
-					},
+			},
+			claimSubnet:             (*ipamv1.IPSubnetStr)(pointer.StringPtr("192.168.0.64/26")),
+			delegatedPrefix:         28,
+			expectedDelegatedPrefix: "192.168.0.64/28",
+		}),
+		Entry("Claim subnet smaller than the prefixes", testCaseDelegatedPrefix{
+			pools: []ipamv1.Pool{
+				{
+					Subnet: (*ipamv1.IPSubnetStr)(pointer.StringPtr("192.168.0.0/24")),
 				},
 			},
-			ipClaim: &ipamv1.IPClaim{
-				ObjectMeta: metav1.ObjectMeta{
-					Name: "TestRef",
-					Finalizers: []string{
-						ipamv1.IPClaimFinalizer,
-					},
+			claimSubnet:     (*ipamv1.IPSubnetStr)(pointer.StringPtr("192.168.0.64/30")),
+			delegatedPrefix: 28,
+			expectError:     true,
+		}),
+		Entry("Pre-allocated prefix", testCaseDelegatedPrefix{
+			pools: []ipamv1.Pool{
+				{
+					Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+					End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.40")),
 				},
 			},
+			preAllocations: map[string]ipamv1.IPAddressStr{
+				"abc": "192.168.0.32",
+			},
 			addresses: map[ipamv1.IPAddressStr]string{
-				ipamv1.IPAddressStr("192.168.0.1"): "TestRef",
+				"192.168.0.32": "",
 			},
-			expectedAddresses:   map[ipamv1.IPAddressStr]string{},
-			expectedAllocations: map[string]ipamv1.IPAddressStr{},
-			m3addresses: []*ipamv1.IPAddress{
+			delegatedPrefix:         29,
+			expectedDelegatedPrefix: "192.168.0.32/29",
+		}),
+		Entry("No prefix left", testCaseDelegatedPrefix{
+			pools: []ipamv1.Pool{
 				{
-					ObjectMeta: metav1.ObjectMeta{
-						Name: "abc-192-168-0-1",
-					},
+					Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+					End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.20")),
 				},
 			},
+			delegatedPrefix: 29,
+			expectError:     true,
 		}),
 	)
 
+	DescribeTable("Test applyAddressMetadata",
+		func(serverSideApply bool) {
+			addressObject := &ipamv1.IPAddress{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "abcpref-192-168-0-10",
+					Namespace: "myns",
+					Labels:    map[string]string{"gitops": "true"},
+				},
+				Spec: ipamv1.IPAddressSpec{
+					Address: "192.168.0.10",
+					Pool:    corev1.ObjectReference{Name: "abc", Namespace: "myns"},
+				},
+			}
+			c := &applyRecorder{
+				Client: fakeclient.NewClientBuilder().WithScheme(setupScheme()).WithObjects(
+					addressObject.DeepCopy(),
+				).Build(),
+			}
+			ipPoolMgr, err := NewIPPoolManager(c, &ipamv1.IPPool{ObjectMeta: ipPoolMeta}, klogr.New())
+			Expect(err).NotTo(HaveOccurred())
+			ipPoolMgr.ServerSideApply = serverSideApply
+
+			Expect(c.Get(context.TODO(), client.ObjectKeyFromObject(addressObject), addressObject)).To(Succeed())
+			addressObject.Labels["claim-label"] = "abc"
+			Expect(ipPoolMgr.applyAddressMetadata(context.TODO(), addressObject, "metadata",
+				map[string]string{"claim-label": "abc"}, nil,
+			)).To(Succeed())
+
+			if !serverSideApply {
+				Expect(c.applied).To(BeEmpty())
+				updated := &ipamv1.IPAddress{}
+				Expect(c.Get(context.TODO(), client.ObjectKeyFromObject(addressObject), updated)).To(Succeed())
+				Expect(updated.Labels).To(Equal(map[string]string{"gitops": "true", "claim-label": "abc"}))
+				return
+			}
+			// Only the labels of the concern are applied, by its field manager
+			Expect(c.applied).To(HaveLen(1))
+			Expect(c.fieldManagers).To(Equal([]string{FieldManager + "-metadata"}))
+			Expect(c.forced).To(BeTrue())
+			Expect(c.applied[0]).To(MatchJSON(`{
+				"apiVersion": "ipam.metal3.io/v1alpha1",
+				"kind": "IPAddress",
+				"metadata": {
+					"name": "abcpref-192-168-0-10",
+					"namespace": "myns",
+					"labels": {"claim-label": "abc"}
+				}
+			}`))
+		},
+		Entry("Update", false),
+		Entry("Server-side apply", true),
+	)
 })
+
+// failingCreateClient fails the creation of the object of the given name
+type failingCreateClient struct {
+	client.Client
+	failOn string
+}
+
+func (c *failingCreateClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	if obj.GetName() == c.failOn {
+		return errors.New("creation failed")
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+// applyRecorder records the server-side applies, not supported by the fake
+// client
+type applyRecorder struct {
+	client.Client
+	applied       []string
+	fieldManagers []string
+	forced        bool
+}
+
+func (c *applyRecorder) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if patch.Type() != client.Apply.Type() {
+		return c.Client.Patch(ctx, obj, patch, opts...)
+	}
+	data, err := patch.Data(obj)
+	if err != nil {
+		return err
+	}
+	patchOptions := &client.PatchOptions{}
+	patchOptions.ApplyOptions(opts)
+	c.applied = append(c.applied, string(data))
+	c.fieldManagers = append(c.fieldManagers, patchOptions.FieldManager)
+	c.forced = patchOptions.Force != nil && *patchOptions.Force
+	return nil
+}
@@ -0,0 +1,248 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// superPoolRetryInterval is the interval between two checks of a missing
+// super-pool, or of one not allowing the namespace of the IPPoolClaim
+const superPoolRetryInterval = time.Minute
+
+// IPPoolClaimManagerInterface is an interface for a IPPoolClaimManager
+type IPPoolClaimManagerInterface interface {
+	UpdatePool(context.Context) error
+}
+
+// IPPoolClaimManager is responsible for carving the prefix of an IPPoolClaim
+// out of its super-pool and creating the IPPool of the prefix
+type IPPoolClaimManager struct {
+	client      client.Client
+	IPPoolClaim *ipamv1.IPPoolClaim
+	Log         logr.Logger
+}
+
+// NewIPPoolClaimManager returns a new helper for managing an ipPoolClaim
+// object
+func NewIPPoolClaimManager(client client.Client,
+	ipPoolClaim *ipamv1.IPPoolClaim, ipPoolClaimLog logr.Logger) (*IPPoolClaimManager, error) {
+
+	return &IPPoolClaimManager{
+		client:      client,
+		IPPoolClaim: ipPoolClaim,
+		Log:         ipPoolClaimLog,
+	}, nil
+}
+
+// UpdatePool claims the prefix of the IPPoolClaim from its super-pool, then
+// creates the IPPool of the prefix once it is allocated, and updates the
+// status of the IPPoolClaim
+func (m *IPPoolClaimManager) UpdatePool(ctx context.Context) error {
+	now := metav1.Now()
+	m.IPPoolClaim.Status.LastUpdated = &now
+
+	prefixClaim := &ipamv1.IPClaim{}
+	err := m.client.Get(ctx, client.ObjectKey{
+		Name:      m.IPPoolClaim.Name,
+		Namespace: m.IPPoolClaim.Namespace,
+	}, prefixClaim)
+	if apierrors.IsNotFound(err) {
+		return m.createPrefixClaim(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	if !metav1.IsControlledBy(prefixClaim, m.IPPoolClaim) {
+		m.setReadyCondition(metav1.ConditionFalse, "ClaimConflict",
+			fmt.Sprintf("IPClaim %s is not owned by the IPPoolClaim", prefixClaim.Name),
+		)
+		return nil
+	}
+	if prefixClaim.Status.Address == nil {
+		message := "waiting for the prefix to be allocated"
+		if prefixClaim.Status.ErrorMessage != nil {
+			message = *prefixClaim.Status.ErrorMessage
+		}
+		m.setReadyCondition(metav1.ConditionFalse, "PrefixPending", message)
+		return nil
+	}
+
+	prefixAddress := &ipamv1.IPAddress{}
+	if err := m.client.Get(ctx, client.ObjectKey{
+		Name:      prefixClaim.Status.Address.Name,
+		Namespace: prefixClaim.Namespace,
+	}, prefixAddress); err != nil {
+		return errors.Wrap(err, "failed to get the IPAddress of the prefix")
+	}
+	if prefixAddress.Spec.DelegatedPrefix == nil {
+		m.setReadyCondition(metav1.ConditionFalse, "PrefixNotDelegated",
+			fmt.Sprintf("IPAddress %s is not a delegated prefix", prefixAddress.Name),
+		)
+		return nil
+	}
+	prefix := *prefixAddress.Spec.DelegatedPrefix
+	m.IPPoolClaim.Status.Prefix = &prefix
+
+	ipPool, err := m.ensureIPPool(ctx, prefix)
+	if err != nil || ipPool == nil {
+		return err
+	}
+	// The prefix is only released once the IPPool is deleted, not with the
+	// IPPoolClaim
+	if err := m.setPoolOwnerRef(ctx, prefixClaim, ipPool); err != nil {
+		return err
+	}
+	m.IPPoolClaim.Status.Pool = &corev1.ObjectReference{
+		Name:      ipPool.Name,
+		Namespace: ipPool.Namespace,
+	}
+	m.setReadyCondition(metav1.ConditionTrue, "PoolCreated",
+		fmt.Sprintf("IPPool %s created with prefix %s", ipPool.Name, prefix),
+	)
+	return nil
+}
+
+// createPrefixClaim creates the IPClaim of the prefix from the super-pool, if
+// the super-pool delegates prefixes of the requested length to the namespace
+// of the IPPoolClaim
+func (m *IPPoolClaimManager) createPrefixClaim(ctx context.Context) error {
+	superPool := &ipamv1.ClusterIPPool{}
+	err := m.client.Get(ctx, client.ObjectKey{Name: m.IPPoolClaim.Spec.SuperPool}, superPool)
+	if apierrors.IsNotFound(err) {
+		m.setReadyCondition(metav1.ConditionFalse, "SuperPoolNotFound",
+			fmt.Sprintf("ClusterIPPool %s not found", m.IPPoolClaim.Spec.SuperPool),
+		)
+		return &RequeueAfterError{RequeueAfter: superPoolRetryInterval}
+	}
+	if err != nil {
+		return err
+	}
+	if superPool.Spec.DelegatedPrefix != m.IPPoolClaim.Spec.PrefixLength {
+		m.setReadyCondition(metav1.ConditionFalse, "PrefixLengthMismatch",
+			fmt.Sprintf("ClusterIPPool %s delegates prefixes of length %d, not %d",
+				superPool.Name, superPool.Spec.DelegatedPrefix, m.IPPoolClaim.Spec.PrefixLength,
+			),
+		)
+		return nil
+	}
+	if !superPool.AsIPPool().IsNamespaceAllowed(m.IPPoolClaim.Namespace) {
+		m.setReadyCondition(metav1.ConditionFalse, "NamespaceNotAllowed",
+			fmt.Sprintf("ClusterIPPool %s does not allow namespace %s",
+				superPool.Name, m.IPPoolClaim.Namespace,
+			),
+		)
+		return &RequeueAfterError{RequeueAfter: superPoolRetryInterval}
+	}
+
+	m.Log.Info("Claiming the prefix", "ClusterIPPool", superPool.Name)
+	prefixClaim := m.IPPoolClaim.NewPrefixClaim()
+	prefixClaim.OwnerReferences = []metav1.OwnerReference{m.ownerRef()}
+	if err := createObject(m.client, ctx, prefixClaim); err != nil {
+		return err
+	}
+	m.setReadyCondition(metav1.ConditionFalse, "PrefixPending",
+		"waiting for the prefix to be allocated",
+	)
+	return nil
+}
+
+// ensureIPPool returns the IPPool of the IPPoolClaim, created with the prefix
+// if missing. It returns nil if an IPPool with the same name is not owned by
+// the IPPoolClaim.
+func (m *IPPoolClaimManager) ensureIPPool(ctx context.Context,
+	prefix ipamv1.IPSubnetStr,
+) (*ipamv1.IPPool, error) {
+	ipPool := &ipamv1.IPPool{}
+	err := m.client.Get(ctx, client.ObjectKey{
+		Name:      m.IPPoolClaim.GetPoolName(),
+		Namespace: m.IPPoolClaim.Namespace,
+	}, ipPool)
+	if apierrors.IsNotFound(err) {
+		m.Log.Info("Creating the IPPool", "IPPool", m.IPPoolClaim.GetPoolName(), "prefix", prefix)
+		ipPool = m.IPPoolClaim.NewIPPool(prefix)
+		ipPool.OwnerReferences = []metav1.OwnerReference{m.ownerRef()}
+		if err := m.client.Create(ctx, ipPool); err != nil {
+			return nil, err
+		}
+		return ipPool, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !metav1.IsControlledBy(ipPool, m.IPPoolClaim) {
+		m.setReadyCondition(metav1.ConditionFalse, "PoolConflict",
+			fmt.Sprintf("IPPool %s is not owned by the IPPoolClaim", ipPool.Name),
+		)
+		return nil, nil
+	}
+	return ipPool, nil
+}
+
+// setPoolOwnerRef adds an owner reference to the IPPool in the IPClaim of the
+// prefix, so that the IPClaim is only garbage collected once both the
+// IPPoolClaim and the IPPool are deleted
+func (m *IPPoolClaimManager) setPoolOwnerRef(ctx context.Context,
+	prefixClaim *ipamv1.IPClaim, ipPool *ipamv1.IPPool,
+) error {
+	for _, ownerRef := range prefixClaim.OwnerReferences {
+		if ownerRef.Kind == "IPPool" && ownerRef.Name == ipPool.Name {
+			return nil
+		}
+	}
+	prefixClaim.OwnerReferences = append(prefixClaim.OwnerReferences, metav1.OwnerReference{
+		APIVersion: ipamv1.GroupVersion.String(),
+		Kind:       "IPPool",
+		Name:       ipPool.Name,
+		UID:        ipPool.UID,
+	})
+	return updateObject(m.client, ctx, prefixClaim)
+}
+
+// ownerRef returns the controller owner reference to the IPPoolClaim
+func (m *IPPoolClaimManager) ownerRef() metav1.OwnerReference {
+	return metav1.OwnerReference{
+		APIVersion:         ipamv1.GroupVersion.String(),
+		Kind:               "IPPoolClaim",
+		Name:               m.IPPoolClaim.Name,
+		UID:                m.IPPoolClaim.UID,
+		Controller:         pointer.BoolPtr(true),
+		BlockOwnerDeletion: pointer.BoolPtr(true),
+	}
+}
+
+// setReadyCondition sets the ready condition of the IPPoolClaim
+func (m *IPPoolClaimManager) setReadyCondition(status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&m.IPPoolClaim.Status.Conditions, metav1.Condition{
+		Type:    ipamv1.IPPoolClaimReadyCondition,
+		Status:  status,
+		Reason:  reason,
+		Message: message,
+	})
+}
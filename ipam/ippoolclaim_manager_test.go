@@ -0,0 +1,255 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2/klogr"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("IPPoolClaim manager", func() {
+
+	poolClaimOwnerRefs := []metav1.OwnerReference{
+		{
+			APIVersion: ipamv1.GroupVersion.String(),
+			Kind:       "IPPoolClaim",
+			Name:       "abc",
+			UID:        types.UID("abc-uid"),
+			Controller: pointer.BoolPtr(true),
+		},
+	}
+
+	superPool := func(delegatedPrefix int, allowedNamespaces ...string) *ipamv1.ClusterIPPool {
+		return &ipamv1.ClusterIPPool{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "tenants",
+			},
+			Spec: ipamv1.IPPoolSpec{
+				Pools: []ipamv1.Pool{
+					{
+						Subnet: (*ipamv1.IPSubnetStr)(pointer.StringPtr("10.0.0.0/16")),
+					},
+				},
+				DelegatedPrefix:   delegatedPrefix,
+				NamePrefix:        "tenants",
+				AllowedNamespaces: allowedNamespaces,
+			},
+		}
+	}
+
+	prefixClaim := func(bound bool, ownerRefs []metav1.OwnerReference) *ipamv1.IPClaim {
+		claim := &ipamv1.IPClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            "abc",
+				Namespace:       "myns",
+				OwnerReferences: ownerRefs,
+			},
+			Spec: ipamv1.IPClaimSpec{
+				Pool: corev1.ObjectReference{
+					Kind: ipamv1.ClusterIPPoolKind,
+					Name: "tenants",
+				},
+			},
+		}
+		if bound {
+			claim.Status.Address = &corev1.ObjectReference{
+				Name:      "tenants-10-0-1-0",
+				Namespace: "myns",
+			}
+		}
+		return claim
+	}
+
+	prefixAddress := &ipamv1.IPAddress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "tenants-10-0-1-0",
+			Namespace: "myns",
+		},
+		Spec: ipamv1.IPAddressSpec{
+			Address:         "10.0.1.0",
+			DelegatedPrefix: (*ipamv1.IPSubnetStr)(pointer.StringPtr("10.0.1.0/24")),
+		},
+	}
+
+	type testCaseUpdatePool struct {
+		objects         []client.Object
+		poolName        string
+		expectRequeue   bool
+		expectedReason  string
+		expectClaim     bool
+		expectedPrefix  *ipamv1.IPSubnetStr
+		expectedPool    *corev1.ObjectReference
+		expectPoolOwner bool
+	}
+
+	DescribeTable("Test UpdatePool",
+		func(tc testCaseUpdatePool) {
+			ipPoolClaim := &ipamv1.IPPoolClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "abc",
+					Namespace: "myns",
+					UID:       types.UID("abc-uid"),
+				},
+				Spec: ipamv1.IPPoolClaimSpec{
+					SuperPool:    "tenants",
+					PrefixLength: 24,
+					PoolName:     tc.poolName,
+					Template: ipamv1.IPPoolTemplate{
+						Labels: map[string]string{"foo": "bar"},
+						Spec: ipamv1.IPPoolSpec{
+							Gateway: (*ipamv1.IPAddressStr)(pointer.StringPtr("10.0.1.1")),
+						},
+					},
+				},
+			}
+			c := fakeclient.NewClientBuilder().WithScheme(setupScheme()).WithObjects(tc.objects...).Build()
+			ipPoolClaimMgr, err := NewIPPoolClaimManager(c, ipPoolClaim, klogr.New())
+			Expect(err).NotTo(HaveOccurred())
+
+			err = ipPoolClaimMgr.UpdatePool(context.TODO())
+			if tc.expectRequeue {
+				Expect(err).To(BeAssignableToTypeOf(&RequeueAfterError{}))
+			} else {
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			Expect(ipPoolClaim.Status.LastUpdated.IsZero()).To(BeFalse())
+			ready := meta.FindStatusCondition(ipPoolClaim.Status.Conditions, ipamv1.IPPoolClaimReadyCondition)
+			Expect(ready).NotTo(BeNil())
+			Expect(ready.Reason).To(Equal(tc.expectedReason))
+			Expect(ready.Status == metav1.ConditionTrue).To(Equal(tc.expectedPool != nil))
+			Expect(ipPoolClaim.Status.Prefix).To(Equal(tc.expectedPrefix))
+			Expect(ipPoolClaim.Status.Pool).To(Equal(tc.expectedPool))
+
+			claim := &ipamv1.IPClaim{}
+			err = c.Get(context.TODO(), client.ObjectKey{Name: "abc", Namespace: "myns"}, claim)
+			if !tc.expectClaim {
+				// No claim of the IPPoolClaim, the claim of another owner
+				// is left untouched
+				if err == nil {
+					Expect(metav1.IsControlledBy(claim, ipPoolClaim)).To(BeFalse())
+				}
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(claim.Spec.Pool.Name).To(Equal("tenants"))
+			Expect(ipamv1.IsClusterIPPoolRef(claim.Spec.Pool)).To(BeTrue())
+			Expect(metav1.IsControlledBy(claim, ipPoolClaim)).To(BeTrue())
+
+			if tc.expectedPool == nil {
+				return
+			}
+			ipPool := &ipamv1.IPPool{}
+			Expect(c.Get(context.TODO(), client.ObjectKey{
+				Name:      tc.expectedPool.Name,
+				Namespace: "myns",
+			}, ipPool)).To(Succeed())
+			Expect(metav1.IsControlledBy(ipPool, ipPoolClaim)).To(BeTrue())
+			Expect(ipPool.Labels).To(Equal(map[string]string{
+				"foo":                   "bar",
+				ipamv1.IPPoolClaimLabel: "abc",
+			}))
+			Expect(ipPool.Spec.Pools).To(Equal([]ipamv1.Pool{{Subnet: tc.expectedPrefix}}))
+			Expect(ipPool.Spec.Prefix).To(Equal(24))
+			Expect(ipPool.Spec.NamePrefix).To(Equal(tc.expectedPool.Name))
+			Expect(*ipPool.Spec.Gateway).To(Equal(ipamv1.IPAddressStr("10.0.1.1")))
+
+			poolOwner := false
+			for _, ownerRef := range claim.OwnerReferences {
+				if ownerRef.Kind == "IPPool" && ownerRef.Name == tc.expectedPool.Name {
+					poolOwner = true
+				}
+			}
+			Expect(poolOwner).To(Equal(tc.expectPoolOwner))
+		},
+		Entry("Super-pool not found", testCaseUpdatePool{
+			expectRequeue:  true,
+			expectedReason: "SuperPoolNotFound",
+		}),
+		Entry("Prefix length mismatch", testCaseUpdatePool{
+			objects:        []client.Object{superPool(26)},
+			expectedReason: "PrefixLengthMismatch",
+		}),
+		Entry("Namespace not allowed", testCaseUpdatePool{
+			objects:        []client.Object{superPool(24, "other")},
+			expectRequeue:  true,
+			expectedReason: "NamespaceNotAllowed",
+		}),
+		Entry("Prefix claimed", testCaseUpdatePool{
+			objects:        []client.Object{superPool(24, "myns")},
+			expectedReason: "PrefixPending",
+			expectClaim:    true,
+		}),
+		Entry("Prefix pending", testCaseUpdatePool{
+			objects:        []client.Object{superPool(24), prefixClaim(false, poolClaimOwnerRefs)},
+			expectedReason: "PrefixPending",
+			expectClaim:    true,
+		}),
+		Entry("Claim of another owner", testCaseUpdatePool{
+			objects:        []client.Object{superPool(24), prefixClaim(true, nil)},
+			expectedReason: "ClaimConflict",
+		}),
+		Entry("Pool created", testCaseUpdatePool{
+			objects: []client.Object{
+				superPool(24), prefixClaim(true, poolClaimOwnerRefs), prefixAddress,
+			},
+			expectedReason:  "PoolCreated",
+			expectClaim:     true,
+			expectedPrefix:  (*ipamv1.IPSubnetStr)(pointer.StringPtr("10.0.1.0/24")),
+			expectedPool:    &corev1.ObjectReference{Name: "abc", Namespace: "myns"},
+			expectPoolOwner: true,
+		}),
+		Entry("Pool created with its name", testCaseUpdatePool{
+			objects: []client.Object{
+				superPool(24), prefixClaim(true, poolClaimOwnerRefs), prefixAddress,
+			},
+			poolName:        "bcd",
+			expectedReason:  "PoolCreated",
+			expectClaim:     true,
+			expectedPrefix:  (*ipamv1.IPSubnetStr)(pointer.StringPtr("10.0.1.0/24")),
+			expectedPool:    &corev1.ObjectReference{Name: "bcd", Namespace: "myns"},
+			expectPoolOwner: true,
+		}),
+		Entry("Pool of another owner", testCaseUpdatePool{
+			objects: []client.Object{
+				superPool(24), prefixClaim(true, poolClaimOwnerRefs), prefixAddress,
+				&ipamv1.IPPool{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "abc",
+						Namespace: "myns",
+					},
+				},
+			},
+			expectedReason: "PoolConflict",
+			expectClaim:    true,
+			expectedPrefix: (*ipamv1.IPSubnetStr)(pointer.StringPtr("10.0.1.0/24")),
+		}),
+	)
+})
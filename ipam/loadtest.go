@@ -0,0 +1,186 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// LoadTestLabel is the label of the IPClaims created by a load test, its
+// value identifies the run
+const LoadTestLabel = "ipam.metal3.io/loadtest"
+
+// LoadTestOptions are the settings of a load test
+type LoadTestOptions struct {
+	// Pool is the IPPool the claims are created for
+	Pool client.ObjectKey
+	// Claims is the number of claims to create
+	Claims int
+	// Rate is the number of claims created per second
+	Rate float64
+	// Timeout is the time a claim is given to get an address
+	Timeout time.Duration
+	// PollInterval is the interval at which the claims are checked
+	PollInterval time.Duration
+}
+
+// LoadTestReport contains the results of a load test
+type LoadTestReport struct {
+	// Claims is the number of claims created
+	Claims int
+	// Errors is the number of claims that could not be created, did not get an
+	// address in time or could not be deleted
+	Errors int
+	// Latencies are the times the claims took to get an address
+	Latencies []time.Duration
+}
+
+// Percentile returns the given percentile of the bind latencies
+func (r LoadTestReport) Percentile(percentile float64) time.Duration {
+	if len(r.Latencies) == 0 {
+		return 0
+	}
+	latencies := append([]time.Duration{}, r.Latencies...)
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	index := int(percentile * float64(len(latencies)-1) / 100)
+	return latencies[index]
+}
+
+func (r LoadTestReport) String() string {
+	errorRate := 0.0
+	if r.Claims != 0 {
+		errorRate = 100 * float64(r.Errors) / float64(r.Claims)
+	}
+	return fmt.Sprintf(
+		"claims: %d, bound: %d, errors: %d (%.1f%%), bind latency p50: %s, p90: %s, p99: %s, max: %s",
+		r.Claims, len(r.Latencies), r.Errors, errorRate,
+		r.Percentile(50), r.Percentile(90), r.Percentile(99), r.Percentile(100),
+	)
+}
+
+// RunLoadTest creates IPClaims for the pool at the given rate, waits for them
+// to get an address and deletes them. The pool should be a sandbox pool, the
+// claims compete with the real ones for its addresses.
+func RunLoadTest(ctx context.Context, cl client.Client, opts LoadTestOptions,
+	log logr.Logger,
+) (LoadTestReport, error) {
+	report := LoadTestReport{}
+	if opts.Claims <= 0 || opts.Rate <= 0 {
+		return report, errors.New("the number of claims and the rate must be positive")
+	}
+	ipPool := &ipamv1.IPPool{}
+	if err := cl.Get(ctx, opts.Pool, ipPool); err != nil {
+		return report, err
+	}
+
+	runID := fmt.Sprintf("%d", time.Now().Unix())
+	log = log.WithValues(LoadTestLabel, runID)
+	log.Info("Starting the load test", "IPPool", opts.Pool, "claims", opts.Claims)
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / opts.Rate))
+	defer ticker.Stop()
+
+	for i := 0; i < opts.Claims; i++ {
+		if i != 0 {
+			select {
+			case <-ctx.Done():
+				wg.Wait()
+				return report, ctx.Err()
+			case <-ticker.C:
+			}
+		}
+		addressClaim := &ipamv1.IPClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      fmt.Sprintf("loadtest-%s-%d", runID, i),
+				Namespace: opts.Pool.Namespace,
+				Labels: map[string]string{
+					LoadTestLabel: runID,
+				},
+			},
+			Spec: ipamv1.IPClaimSpec{
+				Pool: corev1.ObjectReference{
+					Name:      opts.Pool.Name,
+					Namespace: opts.Pool.Namespace,
+				},
+			},
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			latency, err := runLoadTestClaim(ctx, cl, addressClaim, opts)
+			lock.Lock()
+			defer lock.Unlock()
+			report.Claims++
+			if err != nil {
+				log.Info("Load test claim failed", "IPClaim", addressClaim.Name, "error", err.Error())
+				report.Errors++
+				return
+			}
+			report.Latencies = append(report.Latencies, latency)
+		}()
+	}
+	wg.Wait()
+	log.Info("Load test completed", "report", report.String())
+	return report, nil
+}
+
+// runLoadTestClaim creates the claim, waits for its address and deletes it.
+// It returns the time the claim took to get its address.
+func runLoadTestClaim(ctx context.Context, cl client.Client,
+	addressClaim *ipamv1.IPClaim, opts LoadTestOptions,
+) (time.Duration, error) {
+	start := time.Now()
+	if err := cl.Create(ctx, addressClaim); err != nil {
+		return 0, err
+	}
+	// Delete the claim even if the test is interrupted
+	defer func() {
+		_ = deleteObject(cl, context.Background(), addressClaim)
+	}()
+
+	key := client.ObjectKeyFromObject(addressClaim)
+	deadline := start.Add(opts.Timeout)
+	for {
+		if err := cl.Get(ctx, key, addressClaim); err != nil {
+			return 0, err
+		}
+		if addressClaim.Status.Address != nil {
+			return time.Since(start), nil
+		}
+		if time.Now().After(deadline) {
+			return 0, errors.Errorf("no address allocated after %s", opts.Timeout)
+		}
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(opts.PollInterval):
+		}
+	}
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2/klogr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// bindingClient allocates an address to the IPClaims when they are fetched
+type bindingClient struct {
+	client.Client
+}
+
+func (c bindingClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	if err := c.Client.Get(ctx, key, obj); err != nil {
+		return err
+	}
+	if addressClaim, ok := obj.(*ipamv1.IPClaim); ok {
+		addressClaim.Status.Address = &corev1.ObjectReference{Name: key.Name}
+	}
+	return nil
+}
+
+var _ = Describe("Load test", func() {
+
+	type testCaseRunLoadTest struct {
+		opts              LoadTestOptions
+		noPool            bool
+		bind              bool
+		expectError       bool
+		expectedClaims    int
+		expectedErrors    int
+		expectedLatencies int
+	}
+
+	DescribeTable("Test RunLoadTest",
+		func(tc testCaseRunLoadTest) {
+			objects := []client.Object{}
+			if !tc.noPool {
+				objects = append(objects, &ipamv1.IPPool{ObjectMeta: testObjectMeta})
+			}
+			var c client.Client = fakeclient.NewClientBuilder().WithScheme(setupScheme()).WithObjects(objects...).Build()
+			if tc.bind {
+				c = bindingClient{Client: c}
+			}
+			tc.opts.Pool = client.ObjectKey{Name: "abc", Namespace: "myns"}
+
+			report, err := RunLoadTest(context.TODO(), c, tc.opts, klogr.New())
+			if tc.expectError {
+				Expect(err).To(HaveOccurred())
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Claims).To(Equal(tc.expectedClaims))
+			Expect(report.Errors).To(Equal(tc.expectedErrors))
+			Expect(len(report.Latencies)).To(Equal(tc.expectedLatencies))
+
+			// The claims are deleted
+			claims := ipamv1.IPClaimList{}
+			Expect(c.List(context.TODO(), &claims)).To(Succeed())
+			Expect(claims.Items).To(BeEmpty())
+		},
+		Entry("Pool not found", testCaseRunLoadTest{
+			opts: LoadTestOptions{
+				Claims: 1,
+				Rate:   100,
+			},
+			noPool:      true,
+			expectError: true,
+		}),
+		Entry("Invalid rate", testCaseRunLoadTest{
+			opts: LoadTestOptions{
+				Claims: 1,
+			},
+			expectError: true,
+		}),
+		Entry("Claims bound", testCaseRunLoadTest{
+			opts: LoadTestOptions{
+				Claims:       5,
+				Rate:         100,
+				Timeout:      time.Second,
+				PollInterval: time.Millisecond,
+			},
+			bind:              true,
+			expectedClaims:    5,
+			expectedLatencies: 5,
+		}),
+		Entry("Claims not bound", testCaseRunLoadTest{
+			opts: LoadTestOptions{
+				Claims:       3,
+				Rate:         100,
+				Timeout:      10 * time.Millisecond,
+				PollInterval: time.Millisecond,
+			},
+			expectedClaims: 3,
+			expectedErrors: 3,
+		}),
+	)
+
+	DescribeTable("Test LoadTestReport Percentile",
+		func(latencies []time.Duration, percentile float64, expected time.Duration) {
+			report := LoadTestReport{Latencies: latencies}
+			Expect(report.Percentile(percentile)).To(Equal(expected))
+		},
+		Entry("No latencies", []time.Duration{}, 50.0, time.Duration(0)),
+		Entry("Median", []time.Duration{3, 1, 2}, 50.0, time.Duration(2)),
+		Entry("Max", []time.Duration{3, 1, 2}, 100.0, time.Duration(3)),
+		Entry("Min", []time.Duration{3, 1, 2}, 0.0, time.Duration(1)),
+	)
+})
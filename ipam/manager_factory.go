@@ -19,6 +19,7 @@ package ipam
 import (
 	"github.com/go-logr/logr"
 	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
@@ -26,19 +27,83 @@ type ManagerFactoryInterface interface {
 	NewIPPoolManager(*ipamv1.IPPool, logr.Logger) (
 		IPPoolManagerInterface, error,
 	)
+	NewIPClaimSetManager(*ipamv1.IPClaimSet, logr.Logger) (
+		IPClaimSetManagerInterface, error,
+	)
+	NewIPPoolClaimManager(*ipamv1.IPPoolClaim, logr.Logger) (
+		IPPoolClaimManagerInterface, error,
+	)
 }
 
-// ManagerFactory only contains a client
+// ManagerFactory contains a client and the settings of the managers
 type ManagerFactory struct {
 	client client.Client
+	// Settings are the operator-wide settings of the managers, the defaults
+	// are used if nil
+	Settings *Settings
+	// Recorder records the events of the managers, no event is recorded if
+	// nil
+	Recorder record.EventRecorder
+	// BackendCredentials locates the credentials of the backends of the
+	// pools
+	BackendCredentials *BackendCredentials
+	// ConflictScope is the set of pools each pool is compared with to detect
+	// conflicts, the other pools of its namespace if empty
+	ConflictScope ConflictScope
+	// CompactionThreshold is the number of allocations above which the
+	// allocations of the pools are compacted, zero disables it
+	CompactionThreshold int
+	// ShardingThreshold is the number of allocations above which the
+	// allocations of the IPPools are recorded in IPPoolAllocationShard
+	// objects, zero disables it
+	ShardingThreshold int
+	// IndexedClaims is true if the ClaimPoolIndex is registered in the cache
+	// of the client, so that the managers list the claims of their pool only
+	IndexedClaims bool
+	// AllocationBatchSize is the number of pending claims of a pool
+	// allocated at once by the managers, zero or one disables it
+	AllocationBatchSize int
+	// ServerSideApply is true if the managers update the IPAddresses with a
+	// server-side apply
+	ServerSideApply bool
+	// reservations are the addresses allocated by the managers, shared by
+	// the controllers allocating from the same pools
+	reservations *addressReservations
 }
 
 // NewManagerFactory returns a new factory.
 func NewManagerFactory(client client.Client) ManagerFactory {
-	return ManagerFactory{client: client}
+	return ManagerFactory{
+		client:       client,
+		reservations: newAddressReservations(),
+	}
 }
 
 // NewIPPoolManager creates a new IPPoolManager
 func (f ManagerFactory) NewIPPoolManager(ipPool *ipamv1.IPPool, metadataLog logr.Logger) (IPPoolManagerInterface, error) {
-	return NewIPPoolManager(f.client, ipPool, metadataLog)
+	ipPoolMgr, err := NewIPPoolManager(f.client, ipPool, metadataLog)
+	if err != nil {
+		return nil, err
+	}
+	ipPoolMgr.StaleClaimThreshold = f.Settings.StaleClaimThreshold()
+	ipPoolMgr.Recorder = f.Recorder
+	ipPoolMgr.BackendCredentials = f.BackendCredentials
+	ipPoolMgr.ConflictScope = f.ConflictScope
+	ipPoolMgr.CompactionThreshold = f.CompactionThreshold
+	ipPoolMgr.ShardingThreshold = f.ShardingThreshold
+	ipPoolMgr.IndexedClaims = f.IndexedClaims
+	ipPoolMgr.AllocationBatchSize = f.AllocationBatchSize
+	ipPoolMgr.ServerSideApply = f.ServerSideApply
+	ipPoolMgr.reservations = f.reservations
+	return ipPoolMgr, nil
+}
+
+// NewIPClaimSetManager creates a new IPClaimSetManager
+func (f ManagerFactory) NewIPClaimSetManager(ipClaimSet *ipamv1.IPClaimSet, metadataLog logr.Logger) (IPClaimSetManagerInterface, error) {
+	return NewIPClaimSetManager(f.client, ipClaimSet, metadataLog)
+}
+
+// NewIPPoolClaimManager creates a new IPPoolClaimManager
+func (f ManagerFactory) NewIPPoolClaimManager(ipPoolClaim *ipamv1.IPPoolClaim, metadataLog logr.Logger) (IPPoolClaimManagerInterface, error) {
+	return NewIPPoolClaimManager(f.client, ipPoolClaim, metadataLog)
 }
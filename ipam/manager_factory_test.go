@@ -17,10 +17,13 @@ limitations under the License.
 package ipam
 
 import (
+	"time"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
 	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2/klogr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
@@ -45,4 +48,73 @@ var _ = Describe("Manager factory testing", func() {
 		Expect(err).NotTo(HaveOccurred())
 	})
 
+	It("returns an IPPool manager with the stale claim threshold", func() {
+		managerFactory.Settings = NewSettings(DefaultRequeueAfter, 10*time.Minute, 0)
+		ipPoolMgr, err := managerFactory.NewIPPoolManager(&ipamv1.IPPool{}, clusterLog)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ipPoolMgr.(*IPPoolManager).StaleClaimThreshold).To(Equal(10 * time.Minute))
+	})
+
+	It("returns an IPPool manager with the event recorder", func() {
+		recorder := record.NewFakeRecorder(1)
+		managerFactory.Recorder = recorder
+		ipPoolMgr, err := managerFactory.NewIPPoolManager(&ipamv1.IPPool{}, clusterLog)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ipPoolMgr.(*IPPoolManager).Recorder).To(Equal(recorder))
+	})
+
+	It("returns an IPPool manager with the compaction threshold", func() {
+		managerFactory.CompactionThreshold = 1000
+		ipPoolMgr, err := managerFactory.NewIPPoolManager(&ipamv1.IPPool{}, clusterLog)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ipPoolMgr.(*IPPoolManager).CompactionThreshold).To(Equal(1000))
+	})
+
+	It("returns an IPPool manager with the sharding threshold", func() {
+		managerFactory.ShardingThreshold = 20000
+		ipPoolMgr, err := managerFactory.NewIPPoolManager(&ipamv1.IPPool{}, clusterLog)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ipPoolMgr.(*IPPoolManager).ShardingThreshold).To(Equal(20000))
+	})
+
+	It("returns an IPPool manager listing the indexed claims", func() {
+		managerFactory.IndexedClaims = true
+		ipPoolMgr, err := managerFactory.NewIPPoolManager(&ipamv1.IPPool{}, clusterLog)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ipPoolMgr.(*IPPoolManager).IndexedClaims).To(BeTrue())
+	})
+
+	It("returns an IPPool manager with the allocation batch size", func() {
+		managerFactory.AllocationBatchSize = 20
+		ipPoolMgr, err := managerFactory.NewIPPoolManager(&ipamv1.IPPool{}, clusterLog)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ipPoolMgr.(*IPPoolManager).AllocationBatchSize).To(Equal(20))
+	})
+
+	It("returns an IPPool manager with the server-side apply", func() {
+		managerFactory.ServerSideApply = true
+		ipPoolMgr, err := managerFactory.NewIPPoolManager(&ipamv1.IPPool{}, clusterLog)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ipPoolMgr.(*IPPoolManager).ServerSideApply).To(BeTrue())
+	})
+
+	It("returns IPPool managers sharing the address reservations", func() {
+		ipPoolMgr, err := managerFactory.NewIPPoolManager(&ipamv1.IPPool{}, clusterLog)
+		Expect(err).NotTo(HaveOccurred())
+		otherMgr, err := managerFactory.NewIPPoolManager(&ipamv1.IPPool{}, clusterLog)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ipPoolMgr.(*IPPoolManager).reservations).NotTo(BeNil())
+		Expect(otherMgr.(*IPPoolManager).reservations).To(BeIdenticalTo(ipPoolMgr.(*IPPoolManager).reservations))
+	})
+
+	It("returns an IPClaimSet manager", func() {
+		_, err := managerFactory.NewIPClaimSetManager(&ipamv1.IPClaimSet{}, clusterLog)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("returns an IPPoolClaim manager", func() {
+		_, err := managerFactory.NewIPPoolClaimManager(&ipamv1.IPPoolClaim{}, clusterLog)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
 })
@@ -0,0 +1,41 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	// addressReleaseDuration measures the time from the deletion of an IPClaim
+	// to the release of its addresses, available for reuse
+	addressReleaseDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "ipam_address_release_duration_seconds",
+			Help: "Time from the deletion of an IPClaim to the release of its addresses, per IPPool.",
+			Buckets: []float64{
+				0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600, 1800, 3600,
+			},
+		},
+		[]string{"namespace", "ippool"},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(addressReleaseDuration)
+}
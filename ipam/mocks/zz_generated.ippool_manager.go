@@ -27,6 +27,7 @@ import (
 	reflect "reflect"
 
 	gomock "github.com/golang/mock/gomock"
+	v1alpha1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
 	v1alpha4 "sigs.k8s.io/cluster-api/api/v1alpha4"
 )
 
@@ -53,6 +54,48 @@ func (m *MockIPPoolManagerInterface) EXPECT() *MockIPPoolManagerInterfaceMockRec
 	return m.recorder
 }
 
+// DeleteClusterClaims mocks base method.
+func (m *MockIPPoolManagerInterface) DeleteClusterClaims(arg0 context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteClusterClaims", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteClusterClaims indicates an expected call of DeleteClusterClaims.
+func (mr *MockIPPoolManagerInterfaceMockRecorder) DeleteClusterClaims(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteClusterClaims", reflect.TypeOf((*MockIPPoolManagerInterface)(nil).DeleteClusterClaims), arg0)
+}
+
+// DeletePoolClaims mocks base method.
+func (m *MockIPPoolManagerInterface) DeletePoolClaims(arg0 context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeletePoolClaims", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeletePoolClaims indicates an expected call of DeletePoolClaims.
+func (mr *MockIPPoolManagerInterfaceMockRecorder) DeletePoolClaims(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeletePoolClaims", reflect.TypeOf((*MockIPPoolManagerInterface)(nil).DeletePoolClaims), arg0)
+}
+
+// ReleaseAddress mocks base method.
+func (m *MockIPPoolManagerInterface) ReleaseAddress(arg0 context.Context, arg1 *v1alpha1.IPClaim) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReleaseAddress", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReleaseAddress indicates an expected call of ReleaseAddress.
+func (mr *MockIPPoolManagerInterfaceMockRecorder) ReleaseAddress(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReleaseAddress", reflect.TypeOf((*MockIPPoolManagerInterface)(nil).ReleaseAddress), arg0, arg1)
+}
+
 // SetClusterOwnerRef mocks base method.
 func (m *MockIPPoolManagerInterface) SetClusterOwnerRef(arg0 *v1alpha4.Cluster) error {
 	m.ctrl.T.Helper()
@@ -105,3 +148,17 @@ func (mr *MockIPPoolManagerInterfaceMockRecorder) UpdateAddresses(arg0 interface
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateAddresses", reflect.TypeOf((*MockIPPoolManagerInterface)(nil).UpdateAddresses), arg0)
 }
+
+// UpdateClaim mocks base method.
+func (m *MockIPPoolManagerInterface) UpdateClaim(arg0 context.Context, arg1 *v1alpha1.IPClaim) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateClaim", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateClaim indicates an expected call of UpdateClaim.
+func (mr *MockIPPoolManagerInterfaceMockRecorder) UpdateClaim(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateClaim", reflect.TypeOf((*MockIPPoolManagerInterface)(nil).UpdateClaim), arg0, arg1)
+}
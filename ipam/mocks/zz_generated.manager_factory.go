@@ -54,6 +54,36 @@ func (m *MockManagerFactoryInterface) EXPECT() *MockManagerFactoryInterfaceMockR
 	return m.recorder
 }
 
+// NewIPClaimSetManager mocks base method.
+func (m *MockManagerFactoryInterface) NewIPClaimSetManager(arg0 *v1alpha1.IPClaimSet, arg1 logr.Logger) (ipam.IPClaimSetManagerInterface, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NewIPClaimSetManager", arg0, arg1)
+	ret0, _ := ret[0].(ipam.IPClaimSetManagerInterface)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// NewIPClaimSetManager indicates an expected call of NewIPClaimSetManager.
+func (mr *MockManagerFactoryInterfaceMockRecorder) NewIPClaimSetManager(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NewIPClaimSetManager", reflect.TypeOf((*MockManagerFactoryInterface)(nil).NewIPClaimSetManager), arg0, arg1)
+}
+
+// NewIPPoolClaimManager mocks base method.
+func (m *MockManagerFactoryInterface) NewIPPoolClaimManager(arg0 *v1alpha1.IPPoolClaim, arg1 logr.Logger) (ipam.IPPoolClaimManagerInterface, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NewIPPoolClaimManager", arg0, arg1)
+	ret0, _ := ret[0].(ipam.IPPoolClaimManagerInterface)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// NewIPPoolClaimManager indicates an expected call of NewIPPoolClaimManager.
+func (mr *MockManagerFactoryInterfaceMockRecorder) NewIPPoolClaimManager(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NewIPPoolClaimManager", reflect.TypeOf((*MockManagerFactoryInterface)(nil).NewIPPoolClaimManager), arg0, arg1)
+}
+
 // NewIPPoolManager mocks base method.
 func (m *MockManagerFactoryInterface) NewIPPoolManager(arg0 *v1alpha1.IPPool, arg1 logr.Logger) (ipam.IPPoolManagerInterface, error) {
 	m.ctrl.T.Helper()
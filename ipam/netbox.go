@@ -0,0 +1,241 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultNetBoxTimeout is the timeout of the requests to NetBox of the
+	// backends that do not set it
+	defaultNetBoxTimeout = 10 * time.Second
+	// defaultNetBoxStatus is the status of the addresses reserved in NetBox
+	// by the backends that do not set it
+	defaultNetBoxStatus = "active"
+	// netBoxAddressesPath is the path of the IP addresses in the NetBox API
+	netBoxAddressesPath = "/api/ipam/ip-addresses/"
+)
+
+// netBoxBackend reserves the addresses as IP addresses of NetBox
+type netBoxBackend struct {
+	url     string
+	token   string
+	status  string
+	timeout time.Duration
+}
+
+// netBoxTag is a tag of a NetBox object, referenced by its name
+type netBoxTag struct {
+	Name string `json:"name"`
+}
+
+// netBoxAddress is an IP address of NetBox
+type netBoxAddress struct {
+	ID          int         `json:"id,omitempty"`
+	Address     string      `json:"address"`
+	Status      string      `json:"status,omitempty"`
+	Description string      `json:"description"`
+	Tags        []netBoxTag `json:"tags"`
+}
+
+// netBoxAddressList is a page of IP addresses of NetBox
+type netBoxAddressList struct {
+	Count   int             `json:"count"`
+	Results []netBoxAddress `json:"results"`
+}
+
+// netBoxError is an unsuccessful response of NetBox
+type netBoxError struct {
+	method     string
+	path       string
+	status     string
+	statusCode int
+}
+
+func (e *netBoxError) Error() string {
+	return fmt.Sprintf("NetBox returned %s to %s %s", e.status, e.method, e.path)
+}
+
+// isNetBoxNotFound returns true if NetBox did not find the object
+func isNetBoxNotFound(err error) bool {
+	netBoxErr, ok := errors.Cause(err).(*netBoxError)
+	return ok && netBoxErr.statusCode == http.StatusNotFound
+}
+
+// newNetBoxBackend returns the NetBox driver of the backend
+func newNetBoxBackend(backend *ipamv1.NetBoxBackend, token string) *netBoxBackend {
+	netBox := &netBoxBackend{
+		url:     strings.TrimRight(backend.URL, "/"),
+		token:   token,
+		status:  backend.Status,
+		timeout: defaultNetBoxTimeout,
+	}
+	if netBox.status == "" {
+		netBox.status = defaultNetBoxStatus
+	}
+	if backend.Timeout != nil {
+		netBox.timeout = backend.Timeout.Duration
+	}
+	return netBox
+}
+
+// Reserve creates or updates the IP address in NetBox
+func (b *netBoxBackend) Reserve(ctx context.Context, address BackendAddress) (string, error) {
+	record := b.newRecord(address)
+	if address.ID != "" {
+		err := b.request(ctx, http.MethodPatch, netBoxAddressesPath+address.ID+"/", nil, record, nil)
+		if !isNetBoxNotFound(err) {
+			return address.ID, err
+		}
+		// The IP address was deleted in NetBox, it is reserved again
+	}
+	existing, err := b.findAddresses(ctx, address.Address)
+	if err != nil {
+		return "", err
+	}
+	for _, existingRecord := range existing {
+		if existingRecord.Description == address.Owner {
+			id := strconv.Itoa(existingRecord.ID)
+			return id, b.request(ctx, http.MethodPatch, netBoxAddressesPath+id+"/", nil, record, nil)
+		}
+	}
+	if len(existing) != 0 {
+		return "", ErrAddressInUse
+	}
+	created := netBoxAddress{}
+	if err := b.request(ctx, http.MethodPost, netBoxAddressesPath, nil, record, &created); err != nil {
+		return "", err
+	}
+	return strconv.Itoa(created.ID), nil
+}
+
+// Release deletes the IP address from NetBox
+func (b *netBoxBackend) Release(ctx context.Context, address BackendAddress) error {
+	ids := []string{}
+	if address.ID != "" {
+		ids = append(ids, address.ID)
+	} else {
+		existing, err := b.findAddresses(ctx, address.Address)
+		if err != nil {
+			return err
+		}
+		for _, existingRecord := range existing {
+			if existingRecord.Description == address.Owner {
+				ids = append(ids, strconv.Itoa(existingRecord.ID))
+			}
+		}
+	}
+	for _, id := range ids {
+		err := b.request(ctx, http.MethodDelete, netBoxAddressesPath+id+"/", nil, nil, nil)
+		if err != nil && !isNetBoxNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// newRecord returns the NetBox IP address of the reserved address. The
+// addresses without prefix are reserved as host addresses.
+func (b *netBoxBackend) newRecord(address BackendAddress) netBoxAddress {
+	prefix := address.Prefix
+	if prefix == 0 {
+		prefix = 128
+		if isIPv4(address.Address) {
+			prefix = 32
+		}
+	}
+	tags := make([]netBoxTag, 0, len(address.Tags))
+	for _, tag := range address.Tags {
+		tags = append(tags, netBoxTag{Name: tag})
+	}
+	return netBoxAddress{
+		Address:     fmt.Sprintf("%s/%d", address.Address, prefix),
+		Status:      b.status,
+		Description: address.Description,
+		Tags:        tags,
+	}
+}
+
+// findAddresses returns the IP addresses of NetBox with the given address,
+// whatever their prefix
+func (b *netBoxBackend) findAddresses(ctx context.Context, address ipamv1.IPAddressStr) ([]netBoxAddress, error) {
+	list := netBoxAddressList{}
+	err := b.request(ctx, http.MethodGet, netBoxAddressesPath,
+		url.Values{"address": []string{string(address)}}, nil, &list,
+	)
+	return list.Results, err
+}
+
+// request sends a request to the NetBox API and decodes the response in the
+// result, if not nil
+func (b *netBoxBackend) request(ctx context.Context, method, path string,
+	query url.Values, body, result interface{},
+) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	requestURL := b.url + path
+	if query != nil {
+		requestURL += "?" + query.Encode()
+	}
+	ctx, cancel := context.WithTimeout(ctx, b.timeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, method, requestURL, reader)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Token "+b.token)
+	request.Header.Set("Accept", "application/json")
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return &netBoxError{
+			method:     method,
+			path:       path,
+			status:     response.Status,
+			statusCode: response.StatusCode,
+		}
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(result)
+}
@@ -0,0 +1,298 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+)
+
+// fakeNetBox serves the IP addresses of the NetBox API from memory
+type fakeNetBox struct {
+	addresses map[int]netBoxAddress
+	nextID    int
+	requests  []string
+	failing   bool
+}
+
+func (n *fakeNetBox) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n.requests = append(n.requests, r.Method)
+	if r.Header.Get("Authorization") != "Token secret-token" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if n.failing {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if r.URL.Path == netBoxAddressesPath {
+		switch r.Method {
+		case http.MethodGet:
+			list := netBoxAddressList{Results: []netBoxAddress{}}
+			for _, address := range n.addresses {
+				if strings.Split(address.Address, "/")[0] == r.URL.Query().Get("address") {
+					list.Results = append(list.Results, address)
+				}
+			}
+			list.Count = len(list.Results)
+			_ = json.NewEncoder(w).Encode(list)
+		case http.MethodPost:
+			address := netBoxAddress{}
+			Expect(json.NewDecoder(r.Body).Decode(&address)).To(Succeed())
+			n.nextID++
+			address.ID = n.nextID
+			n.addresses[address.ID] = address
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(address)
+		}
+		return
+	}
+	id, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(r.URL.Path, netBoxAddressesPath), "/"))
+	if _, ok := n.addresses[id]; err != nil || !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodPatch:
+		address := netBoxAddress{}
+		Expect(json.NewDecoder(r.Body).Decode(&address)).To(Succeed())
+		address.ID = id
+		n.addresses[id] = address
+		_ = json.NewEncoder(w).Encode(address)
+	case http.MethodDelete:
+		delete(n.addresses, id)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+var _ = Describe("NetBox backend", func() {
+
+	type testCaseNetBox struct {
+		existing          []netBoxAddress
+		failing           bool
+		release           bool
+		address           BackendAddress
+		expectInUse       bool
+		expectError       bool
+		expectedID        string
+		expectedAddresses map[int]netBoxAddress
+	}
+
+	DescribeTable("Test the NetBox backend",
+		func(tc testCaseNetBox) {
+			netBox := &fakeNetBox{
+				addresses: map[int]netBoxAddress{},
+				nextID:    10,
+				failing:   tc.failing,
+			}
+			for _, address := range tc.existing {
+				netBox.addresses[address.ID] = address
+			}
+			server := httptest.NewServer(netBox)
+			defer server.Close()
+			backend := newNetBoxBackend(&ipamv1.NetBoxBackend{URL: server.URL + "/"}, "secret-token")
+
+			var err error
+			id := ""
+			if tc.release {
+				err = backend.Release(context.TODO(), tc.address)
+			} else {
+				id, err = backend.Reserve(context.TODO(), tc.address)
+			}
+			if tc.expectInUse {
+				Expect(err).To(Equal(ErrAddressInUse))
+			} else if tc.expectError {
+				Expect(err).To(HaveOccurred())
+			} else {
+				Expect(err).NotTo(HaveOccurred())
+			}
+			Expect(id).To(Equal(tc.expectedID))
+			Expect(netBox.addresses).To(Equal(tc.expectedAddresses))
+		},
+		Entry("Address reserved", testCaseNetBox{
+			address: BackendAddress{
+				Address:     "192.168.0.11",
+				Prefix:      24,
+				Owner:       "myns/abc",
+				Description: "myns/abc",
+				Tags:        []string{"metal3"},
+			},
+			expectedID: "11",
+			expectedAddresses: map[int]netBoxAddress{
+				11: {
+					ID:          11,
+					Address:     "192.168.0.11/24",
+					Status:      "active",
+					Description: "myns/abc",
+					Tags:        []netBoxTag{{Name: "metal3"}},
+				},
+			},
+		}),
+		Entry("Host address reserved", testCaseNetBox{
+			address: BackendAddress{
+				Address:     "2001:db8::11",
+				Owner:       "myns/abc",
+				Description: "myns/abc",
+			},
+			expectedID: "11",
+			expectedAddresses: map[int]netBoxAddress{
+				11: {
+					ID:          11,
+					Address:     "2001:db8::11/128",
+					Status:      "active",
+					Description: "myns/abc",
+					Tags:        []netBoxTag{},
+				},
+			},
+		}),
+		Entry("Address in use", testCaseNetBox{
+			existing: []netBoxAddress{
+				{ID: 3, Address: "192.168.0.11/24", Description: "router"},
+			},
+			address: BackendAddress{
+				Address:     "192.168.0.11",
+				Prefix:      24,
+				Owner:       "myns/abc",
+				Description: "myns/abc",
+			},
+			expectInUse: true,
+			expectedAddresses: map[int]netBoxAddress{
+				3: {ID: 3, Address: "192.168.0.11/24", Description: "router"},
+			},
+		}),
+		Entry("Reservation of the owner adopted", testCaseNetBox{
+			existing: []netBoxAddress{
+				{ID: 3, Address: "192.168.0.11/24", Description: "myns/abc"},
+			},
+			address: BackendAddress{
+				Address:     "192.168.0.11",
+				Prefix:      24,
+				Owner:       "myns/abc",
+				Description: "web server",
+			},
+			expectedID: "3",
+			expectedAddresses: map[int]netBoxAddress{
+				3: {
+					ID:          3,
+					Address:     "192.168.0.11/24",
+					Status:      "active",
+					Description: "web server",
+					Tags:        []netBoxTag{},
+				},
+			},
+		}),
+		Entry("Reservation updated", testCaseNetBox{
+			existing: []netBoxAddress{
+				{ID: 3, Address: "192.168.0.11/24", Description: "web server"},
+			},
+			address: BackendAddress{
+				ID:          "3",
+				Address:     "192.168.0.11",
+				Prefix:      24,
+				Owner:       "myns/abc",
+				Description: "web server",
+				Tags:        []string{"prod"},
+			},
+			expectedID: "3",
+			expectedAddresses: map[int]netBoxAddress{
+				3: {
+					ID:          3,
+					Address:     "192.168.0.11/24",
+					Status:      "active",
+					Description: "web server",
+					Tags:        []netBoxTag{{Name: "prod"}},
+				},
+			},
+		}),
+		Entry("Reservation deleted in NetBox reserved again", testCaseNetBox{
+			address: BackendAddress{
+				ID:          "3",
+				Address:     "192.168.0.11",
+				Prefix:      24,
+				Owner:       "myns/abc",
+				Description: "myns/abc",
+			},
+			expectedID: "11",
+			expectedAddresses: map[int]netBoxAddress{
+				11: {
+					ID:          11,
+					Address:     "192.168.0.11/24",
+					Status:      "active",
+					Description: "myns/abc",
+					Tags:        []netBoxTag{},
+				},
+			},
+		}),
+		Entry("NetBox failing", testCaseNetBox{
+			failing: true,
+			address: BackendAddress{
+				Address: "192.168.0.11",
+				Owner:   "myns/abc",
+			},
+			expectError:       true,
+			expectedAddresses: map[int]netBoxAddress{},
+		}),
+		Entry("Released by identifier", testCaseNetBox{
+			release: true,
+			existing: []netBoxAddress{
+				{ID: 3, Address: "192.168.0.11/24", Description: "web server"},
+				{ID: 4, Address: "192.168.0.12/24", Description: "myns/other"},
+			},
+			address: BackendAddress{
+				ID:      "3",
+				Address: "192.168.0.11",
+				Owner:   "myns/abc",
+			},
+			expectedAddresses: map[int]netBoxAddress{
+				4: {ID: 4, Address: "192.168.0.12/24", Description: "myns/other"},
+			},
+		}),
+		Entry("Released by owner", testCaseNetBox{
+			release: true,
+			existing: []netBoxAddress{
+				{ID: 3, Address: "192.168.0.11/24", Description: "myns/abc"},
+				{ID: 4, Address: "192.168.0.11/32", Description: "router"},
+			},
+			address: BackendAddress{
+				Address: "192.168.0.11",
+				Owner:   "myns/abc",
+			},
+			expectedAddresses: map[int]netBoxAddress{
+				4: {ID: 4, Address: "192.168.0.11/32", Description: "router"},
+			},
+		}),
+		Entry("Released while already deleted", testCaseNetBox{
+			release: true,
+			address: BackendAddress{
+				ID:      "3",
+				Address: "192.168.0.11",
+				Owner:   "myns/abc",
+			},
+			expectedAddresses: map[int]netBoxAddress{},
+		}),
+	)
+})
@@ -0,0 +1,158 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"sort"
+
+	"github.com/go-logr/logr"
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+)
+
+// poolRangeRef is a parsed range of the pools of an IPPool
+type poolRangeRef struct {
+	pool      *ipamv1.IPPool
+	index     int
+	poolRange *ipamv1.PoolRange
+}
+
+// BuildOverlapReport returns the overlaps between the ranges of the given
+// IPPools, from all namespaces, with their severity and the allocations they
+// affect. The ranges that cannot be parsed are skipped.
+func BuildOverlapReport(ipPools []ipamv1.IPPool, log logr.Logger) ipamv1.IPOverlapReportStatus {
+	sort.Slice(ipPools, func(i, j int) bool {
+		if ipPools[i].Namespace != ipPools[j].Namespace {
+			return ipPools[i].Namespace < ipPools[j].Namespace
+		}
+		return ipPools[i].Name < ipPools[j].Name
+	})
+
+	ranges := []poolRangeRef{}
+	for i := range ipPools {
+		for index, pool := range ipPools[i].Spec.Pools {
+			poolRange, err := ipamv1.NewPoolRange(pool)
+			if err != nil {
+				log.Info("Skipping an invalid range", "IPPool", ipPools[i].Namespace+"/"+ipPools[i].Name,
+					"index", index, "error", err.Error(),
+				)
+				continue
+			}
+			ranges = append(ranges, poolRangeRef{pool: &ipPools[i], index: index, poolRange: poolRange})
+		}
+	}
+
+	status := ipamv1.IPOverlapReportStatus{
+		Pools:    len(ipPools),
+		Overlaps: []ipamv1.IPRangeOverlap{},
+	}
+	for i, rangeRef := range ranges {
+		for _, otherRef := range ranges[i+1:] {
+			if !rangeRef.poolRange.OverlapsRange(otherRef.poolRange) {
+				continue
+			}
+			overlap := newRangeOverlap(rangeRef, otherRef)
+			switch overlap.Severity {
+			case ipamv1.OverlapSeverityCritical:
+				status.Critical++
+			case ipamv1.OverlapSeverityWarning:
+				status.Warning++
+			}
+			status.Overlaps = append(status.Overlaps, overlap)
+		}
+	}
+	return status
+}
+
+// newRangeOverlap returns the overlap between two overlapping ranges
+func newRangeOverlap(rangeRef, otherRef poolRangeRef) ipamv1.IPRangeOverlap {
+	first, last := rangeBounds(rangeRef.poolRange)
+	otherFirst, otherLast := rangeBounds(otherRef.poolRange)
+	if otherFirst.compare(first) > 0 {
+		first = otherFirst
+	}
+	if otherLast.compare(last) < 0 {
+		last = otherLast
+	}
+
+	overlap := ipamv1.IPRangeOverlap{
+		Ranges: []ipamv1.OverlappingRange{
+			{Namespace: rangeRef.pool.Namespace, Name: rangeRef.pool.Name, Index: rangeRef.index},
+			{Namespace: otherRef.pool.Namespace, Name: otherRef.pool.Name, Index: otherRef.index},
+		},
+		Start:    ipamv1.IPAddressStr(first.String()),
+		End:      ipamv1.IPAddressStr(last.String()),
+		Severity: ipamv1.OverlapSeverityInfo,
+	}
+
+	affected := affectedAllocations(rangeRef.pool, first, last)
+	if otherRef.pool != rangeRef.pool {
+		otherAffected := affectedAllocations(otherRef.pool, first, last)
+		addresses := map[ipamv1.IPAddressStr]bool{}
+		for _, allocation := range affected {
+			addresses[allocation.Address] = true
+		}
+		for _, allocation := range otherAffected {
+			if addresses[allocation.Address] {
+				overlap.Severity = ipamv1.OverlapSeverityCritical
+			}
+		}
+		affected = append(affected, otherAffected...)
+	}
+	if len(affected) != 0 {
+		overlap.AffectedAllocations = affected
+		if overlap.Severity == ipamv1.OverlapSeverityInfo {
+			overlap.Severity = ipamv1.OverlapSeverityWarning
+		}
+	}
+	return overlap
+}
+
+// rangeBounds returns the first and last addresses of the range
+func rangeBounds(poolRange *ipamv1.PoolRange) (ipAddr, ipAddr) {
+	first, last := poolRange.Bounds()
+	firstAddr, _ := addrFromIP(first)
+	lastAddr, _ := addrFromIP(last)
+	return firstAddr, lastAddr
+}
+
+// affectedAllocations returns the allocations of the IPPool between the given
+// addresses, sorted by address
+func affectedAllocations(ipPool *ipamv1.IPPool, first, last ipAddr) []ipamv1.AffectedAllocation {
+	overlap := addrRange{first: first, last: last}
+	affected := []ipamv1.AffectedAllocation{}
+	for claim, address := range ipPool.Status.Allocations {
+		addr, ok := parseAddr(string(address))
+		if !ok || !overlap.contains(addr) {
+			continue
+		}
+		affected = append(affected, ipamv1.AffectedAllocation{
+			Namespace: ipPool.Namespace,
+			Pool:      ipPool.Name,
+			Claim:     claim,
+			Address:   address,
+		})
+	}
+	sort.Slice(affected, func(i, j int) bool {
+		iAddr, _ := parseAddr(string(affected[i].Address))
+		jAddr, _ := parseAddr(string(affected[j].Address))
+		if iAddr != jAddr {
+			return iAddr.compare(jAddr) < 0
+		}
+		return affected[i].Claim < affected[j].Claim
+	})
+	return affected
+}
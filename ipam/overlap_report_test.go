@@ -0,0 +1,179 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/klogr"
+	"k8s.io/utils/pointer"
+)
+
+var _ = Describe("Overlap report", func() {
+
+	type testCaseOverlapReport struct {
+		ipPools        []ipamv1.IPPool
+		expectedStatus ipamv1.IPOverlapReportStatus
+	}
+
+	newPool := func(namespace, name string, allocations map[string]ipamv1.IPAddressStr,
+		pools ...ipamv1.Pool,
+	) ipamv1.IPPool {
+		return ipamv1.IPPool{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec:       ipamv1.IPPoolSpec{Pools: pools},
+			Status:     ipamv1.IPPoolStatus{Allocations: allocations},
+		}
+	}
+
+	addressRange := func(start, end string) ipamv1.Pool {
+		return ipamv1.Pool{
+			Start: (*ipamv1.IPAddressStr)(pointer.StringPtr(start)),
+			End:   (*ipamv1.IPAddressStr)(pointer.StringPtr(end)),
+		}
+	}
+
+	subnet := func(subnet string) ipamv1.Pool {
+		return ipamv1.Pool{
+			Subnet: (*ipamv1.IPSubnetStr)(pointer.StringPtr(subnet)),
+		}
+	}
+
+	DescribeTable("Test BuildOverlapReport",
+		func(tc testCaseOverlapReport) {
+			status := BuildOverlapReport(tc.ipPools, klogr.New())
+			Expect(status).To(Equal(tc.expectedStatus))
+		},
+		Entry("No pools", testCaseOverlapReport{
+			expectedStatus: ipamv1.IPOverlapReportStatus{
+				Overlaps: []ipamv1.IPRangeOverlap{},
+			},
+		}),
+		Entry("No overlap", testCaseOverlapReport{
+			ipPools: []ipamv1.IPPool{
+				newPool("myns", "abc", nil, addressRange("192.168.0.1", "192.168.0.10")),
+				newPool("myns", "bcd", nil, addressRange("192.168.0.11", "192.168.0.20")),
+				newPool("myns", "cde", nil, subnet("2001:db8::/64")),
+			},
+			expectedStatus: ipamv1.IPOverlapReportStatus{
+				Pools:    3,
+				Overlaps: []ipamv1.IPRangeOverlap{},
+			},
+		}),
+		Entry("Overlap without allocations, invalid range skipped", testCaseOverlapReport{
+			ipPools: []ipamv1.IPPool{
+				newPool("otherns", "bcd", nil, subnet("192.168.0.0/24")),
+				newPool("myns", "abc", nil,
+					ipamv1.Pool{}, addressRange("192.168.0.250", "192.168.1.10"),
+				),
+			},
+			expectedStatus: ipamv1.IPOverlapReportStatus{
+				Pools: 2,
+				Overlaps: []ipamv1.IPRangeOverlap{
+					{
+						Ranges: []ipamv1.OverlappingRange{
+							{Namespace: "myns", Name: "abc", Index: 1},
+							{Namespace: "otherns", Name: "bcd", Index: 0},
+						},
+						Start:    "192.168.0.250",
+						End:      "192.168.0.255",
+						Severity: ipamv1.OverlapSeverityInfo,
+					},
+				},
+			},
+		}),
+		Entry("Overlaps with allocations", testCaseOverlapReport{
+			ipPools: []ipamv1.IPPool{
+				newPool("myns", "abc",
+					map[string]ipamv1.IPAddressStr{
+						"claim1": "192.168.0.5",
+						"claim2": "192.168.0.15",
+					},
+					addressRange("192.168.0.1", "192.168.0.20"),
+					addressRange("192.168.0.18", "192.168.0.30"),
+				),
+				newPool("otherns", "bcd",
+					map[string]ipamv1.IPAddressStr{
+						"claim3": "192.168.0.5",
+					},
+					addressRange("192.168.0.5", "192.168.0.10"),
+				),
+			},
+			expectedStatus: ipamv1.IPOverlapReportStatus{
+				Pools:    2,
+				Critical: 1,
+				Overlaps: []ipamv1.IPRangeOverlap{
+					{
+						Ranges: []ipamv1.OverlappingRange{
+							{Namespace: "myns", Name: "abc", Index: 0},
+							{Namespace: "myns", Name: "abc", Index: 1},
+						},
+						Start:    "192.168.0.18",
+						End:      "192.168.0.20",
+						Severity: ipamv1.OverlapSeverityInfo,
+					},
+					{
+						Ranges: []ipamv1.OverlappingRange{
+							{Namespace: "myns", Name: "abc", Index: 0},
+							{Namespace: "otherns", Name: "bcd", Index: 0},
+						},
+						Start:    "192.168.0.5",
+						End:      "192.168.0.10",
+						Severity: ipamv1.OverlapSeverityCritical,
+						AffectedAllocations: []ipamv1.AffectedAllocation{
+							{Namespace: "myns", Pool: "abc", Claim: "claim1", Address: "192.168.0.5"},
+							{Namespace: "otherns", Pool: "bcd", Claim: "claim3", Address: "192.168.0.5"},
+						},
+					},
+				},
+			},
+		}),
+		Entry("Overlap with allocations from one pool", testCaseOverlapReport{
+			ipPools: []ipamv1.IPPool{
+				newPool("myns", "abc",
+					map[string]ipamv1.IPAddressStr{
+						"claim1": "192.168.0.7",
+					},
+					addressRange("192.168.0.1", "192.168.0.20"),
+				),
+				newPool("myns", "bcd", nil, addressRange("192.168.0.5", "192.168.0.10")),
+			},
+			expectedStatus: ipamv1.IPOverlapReportStatus{
+				Pools:   2,
+				Warning: 1,
+				Overlaps: []ipamv1.IPRangeOverlap{
+					{
+						Ranges: []ipamv1.OverlappingRange{
+							{Namespace: "myns", Name: "abc", Index: 0},
+							{Namespace: "myns", Name: "bcd", Index: 0},
+						},
+						Start:    "192.168.0.5",
+						End:      "192.168.0.10",
+						Severity: ipamv1.OverlapSeverityWarning,
+						AffectedAllocations: []ipamv1.AffectedAllocation{
+							{Namespace: "myns", Pool: "abc", Claim: "claim1", Address: "192.168.0.7"},
+						},
+					},
+				},
+			},
+		}),
+	)
+})
@@ -0,0 +1,230 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ConflictScope is the set of pools an IPPool is compared with to detect the
+// overlapping ranges and the addresses allocated twice
+type ConflictScope string
+
+const (
+	// ConflictScopeNamespace compares the IPPools with the other IPPools of
+	// their namespace, and the ClusterIPPools with the other ClusterIPPools
+	ConflictScopeNamespace ConflictScope = "Namespace"
+
+	// ConflictScopeCluster compares all the IPPools and ClusterIPPools with
+	// each other
+	ConflictScopeCluster ConflictScope = "Cluster"
+)
+
+// maxListedConflicts is the number of conflicts listed in the message of the
+// conflict condition
+const maxListedConflicts = 10
+
+// poolConflicts are the conflicts of an IPPool with another pool
+type poolConflicts struct {
+	pool      *ipamv1.IPPool
+	overlaps  bool
+	addresses []ipamv1.IPAddressStr
+}
+
+// setConflictCondition compares the pool with the other pools of its conflict
+// scope and sets its conflict condition, listing the pools whose ranges
+// overlap its ones and the addresses they also allocated. A warning event is
+// recorded on both pools when new conflicts are detected. The pools delegating
+// prefixes are not compared, their prefixes being served by other pools.
+func (m *IPPoolManager) setConflictCondition(ctx context.Context) error {
+	conflicts := []poolConflicts{}
+	if m.IPPool.Spec.DelegatedPrefix == 0 && m.IPPool.DeletionTimestamp.IsZero() {
+		peers, err := m.listPeerPools(ctx)
+		if err != nil {
+			return err
+		}
+		for i := range peers {
+			if conflict := m.compareWithPool(&peers[i]); conflict.overlaps || len(conflict.addresses) != 0 {
+				conflicts = append(conflicts, conflict)
+			}
+		}
+	}
+
+	previous := meta.FindStatusCondition(m.IPPool.Status.Conditions, ipamv1.IPPoolConflictCondition)
+	if len(conflicts) == 0 {
+		if previous == nil {
+			return nil
+		}
+		meta.SetStatusCondition(&m.IPPool.Status.Conditions, metav1.Condition{
+			Type:   ipamv1.IPPoolConflictCondition,
+			Status: metav1.ConditionFalse,
+			Reason: "NoConflict",
+		})
+		return nil
+	}
+
+	reason := "RangeOverlap"
+	messages := []string{}
+	for _, conflict := range conflicts {
+		if len(conflict.addresses) != 0 {
+			reason = "AddressConflict"
+		}
+		messages = append(messages, conflict.message())
+	}
+	message := strings.Join(messages, "; ")
+	if previous != nil && previous.Status == metav1.ConditionTrue && previous.Message == message {
+		return nil
+	}
+
+	m.Log.Info("Conflicts with other pools", "conflicts", message)
+	meta.SetStatusCondition(&m.IPPool.Status.Conditions, metav1.Condition{
+		Type:    ipamv1.IPPoolConflictCondition,
+		Status:  metav1.ConditionTrue,
+		Reason:  reason,
+		Message: message,
+	})
+	if m.Recorder != nil {
+		for _, conflict := range conflicts {
+			m.Recorder.Eventf(m.IPPool, corev1.EventTypeWarning, reason,
+				"Conflicts with %s", conflict.message(),
+			)
+			m.Recorder.Eventf(conflict.pool, corev1.EventTypeWarning, reason,
+				"Conflicts with %s", poolName(m.IPPool),
+			)
+		}
+	}
+	return nil
+}
+
+// listPeerPools returns the pools of the conflict scope of the pool, without
+// the pool itself nor its former and new names
+func (m *IPPoolManager) listPeerPools(ctx context.Context) ([]ipamv1.IPPool, error) {
+	pools := []ipamv1.IPPool{}
+	if m.ConflictScope == ConflictScopeCluster || !m.IPPool.IsClusterScoped() {
+		ipPools := ipamv1.IPPoolList{}
+		opts := []client.ListOption{}
+		if m.ConflictScope != ConflictScopeCluster {
+			opts = append(opts, client.InNamespace(m.IPPool.Namespace))
+		}
+		if err := m.client.List(ctx, &ipPools, opts...); err != nil {
+			return nil, err
+		}
+		pools = append(pools, ipPools.Items...)
+	}
+	if m.ConflictScope == ConflictScopeCluster || m.IPPool.IsClusterScoped() {
+		clusterIPPools := ipamv1.ClusterIPPoolList{}
+		if err := m.client.List(ctx, &clusterIPPools); err != nil {
+			return nil, err
+		}
+		for i := range clusterIPPools.Items {
+			pools = append(pools, *clusterIPPools.Items[i].AsIPPool())
+		}
+	}
+
+	peers := []ipamv1.IPPool{}
+	for _, pool := range pools {
+		if pool.IsClusterScoped() == m.IPPool.IsClusterScoped() && pool.Namespace == m.IPPool.Namespace &&
+			(pool.IsNamed(m.IPPool.Name) || m.IPPool.IsNamed(pool.Name)) {
+			continue
+		}
+		if pool.Spec.DelegatedPrefix != 0 || !pool.DeletionTimestamp.IsZero() {
+			continue
+		}
+		peers = append(peers, pool)
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return poolName(&peers[i]) < poolName(&peers[j])
+	})
+	return peers, nil
+}
+
+// compareWithPool returns whether the ranges of the pools overlap, and the
+// addresses allocated by both pools, in address order
+func (m *IPPoolManager) compareWithPool(other *ipamv1.IPPool) poolConflicts {
+	conflict := poolConflicts{pool: other}
+	for _, pool := range m.IPPool.Spec.Pools {
+		poolRange, err := ipamv1.NewPoolRange(pool)
+		if err != nil {
+			continue
+		}
+		for _, otherPool := range other.Spec.Pools {
+			otherRange, err := ipamv1.NewPoolRange(otherPool)
+			if err == nil && poolRange.OverlapsRange(otherRange) {
+				conflict.overlaps = true
+			}
+		}
+	}
+
+	allocated := map[ipamv1.IPAddressStr]bool{}
+	for _, address := range m.IPPool.Status.AllocatedAddresses() {
+		allocated[address] = true
+	}
+	seen := map[ipamv1.IPAddressStr]bool{}
+	for _, address := range other.Status.AllocatedAddresses() {
+		if allocated[address] && !seen[address] {
+			seen[address] = true
+			conflict.addresses = append(conflict.addresses, address)
+		}
+	}
+	sort.Slice(conflict.addresses, func(i, j int) bool {
+		iAddr, iOK := parseAddr(string(conflict.addresses[i]))
+		jAddr, jOK := parseAddr(string(conflict.addresses[j]))
+		if !iOK || !jOK {
+			return conflict.addresses[i] < conflict.addresses[j]
+		}
+		return iAddr.compare(jAddr) < 0
+	})
+	return conflict
+}
+
+// message describes the conflicts with a pool
+func (c poolConflicts) message() string {
+	parts := []string{}
+	if c.overlaps {
+		parts = append(parts, "ranges overlap")
+	}
+	if len(c.addresses) != 0 {
+		listed := []string{}
+		for i, address := range c.addresses {
+			if i == maxListedConflicts {
+				listed = append(listed, fmt.Sprintf("%d more", len(c.addresses)-maxListedConflicts))
+				break
+			}
+			listed = append(listed, string(address))
+		}
+		parts = append(parts, "also allocated "+strings.Join(listed, ", "))
+	}
+	return poolName(c.pool) + ": " + strings.Join(parts, ", ")
+}
+
+// poolName renders the kind and name of a pool, with the namespace of the
+// IPPools
+func poolName(ipPool *ipamv1.IPPool) string {
+	if ipPool.IsClusterScoped() {
+		return "ClusterIPPool " + ipPool.Name
+	}
+	return "IPPool " + ipPool.Namespace + "/" + ipPool.Name
+}
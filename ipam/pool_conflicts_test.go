@@ -0,0 +1,235 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2/klogr"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Pool conflicts", func() {
+
+	type testCaseConflicts struct {
+		ipPool            *ipamv1.IPPool
+		ipPools           []*ipamv1.IPPool
+		clusterIPPools    []*ipamv1.ClusterIPPool
+		scope             ConflictScope
+		expectedReason    string
+		expectedMessage   string
+		expectNoCondition bool
+		expectedEvents    int
+	}
+
+	addressRange := func(start, end string) ipamv1.Pool {
+		return ipamv1.Pool{
+			Start: (*ipamv1.IPAddressStr)(pointer.StringPtr(start)),
+			End:   (*ipamv1.IPAddressStr)(pointer.StringPtr(end)),
+		}
+	}
+
+	newPool := func(namespace, name string, allocations map[string]ipamv1.IPAddressStr,
+		pools ...ipamv1.Pool,
+	) *ipamv1.IPPool {
+		return &ipamv1.IPPool{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec:       ipamv1.IPPoolSpec{Pools: pools},
+			Status:     ipamv1.IPPoolStatus{Allocations: allocations},
+		}
+	}
+
+	DescribeTable("Test setConflictCondition",
+		func(tc testCaseConflicts) {
+			objects := []client.Object{}
+			for _, ipPool := range tc.ipPools {
+				objects = append(objects, ipPool)
+			}
+			for _, clusterIPPool := range tc.clusterIPPools {
+				objects = append(objects, clusterIPPool)
+			}
+			c := fakeclient.NewClientBuilder().WithScheme(setupScheme()).WithObjects(objects...).Build()
+			ipPoolMgr, err := NewIPPoolManager(c, tc.ipPool, klogr.New())
+			Expect(err).NotTo(HaveOccurred())
+			recorder := record.NewFakeRecorder(10)
+			ipPoolMgr.Recorder = recorder
+			ipPoolMgr.ConflictScope = tc.scope
+
+			Expect(ipPoolMgr.setConflictCondition(context.TODO())).To(Succeed())
+
+			condition := meta.FindStatusCondition(tc.ipPool.Status.Conditions,
+				ipamv1.IPPoolConflictCondition,
+			)
+			Expect(recorder.Events).To(HaveLen(tc.expectedEvents))
+			if tc.expectNoCondition {
+				Expect(condition).To(BeNil())
+				return
+			}
+			Expect(condition).NotTo(BeNil())
+			if tc.expectedReason == "" {
+				Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+				return
+			}
+			Expect(condition.Status).To(Equal(metav1.ConditionTrue))
+			Expect(condition.Reason).To(Equal(tc.expectedReason))
+			Expect(condition.Message).To(Equal(tc.expectedMessage))
+		},
+		Entry("No conflict", testCaseConflicts{
+			ipPool: newPool("myns", "abc", map[string]ipamv1.IPAddressStr{"abc": "192.168.0.1"},
+				addressRange("192.168.0.1", "192.168.0.10"),
+			),
+			ipPools: []*ipamv1.IPPool{
+				newPool("myns", "bcd", map[string]ipamv1.IPAddressStr{"bcd": "192.168.0.11"},
+					addressRange("192.168.0.11", "192.168.0.20"),
+				),
+			},
+			expectNoCondition: true,
+		}),
+		Entry("Overlapping ranges", testCaseConflicts{
+			ipPool: newPool("myns", "abc", nil,
+				addressRange("192.168.0.1", "192.168.0.10"),
+			),
+			ipPools: []*ipamv1.IPPool{
+				newPool("myns", "bcd", nil, addressRange("192.168.0.5", "192.168.0.20")),
+			},
+			expectedReason:  "RangeOverlap",
+			expectedMessage: "IPPool myns/bcd: ranges overlap",
+			expectedEvents:  2,
+		}),
+		Entry("Addresses allocated twice", testCaseConflicts{
+			ipPool: newPool("myns", "abc", map[string]ipamv1.IPAddressStr{
+				"abc": "192.168.0.7",
+				"bcd": "192.168.0.6",
+			}, addressRange("192.168.0.1", "192.168.0.10")),
+			ipPools: []*ipamv1.IPPool{
+				newPool("myns", "bcd", map[string]ipamv1.IPAddressStr{
+					"cde": "192.168.0.6",
+					"def": "192.168.0.7",
+					"efg": "192.168.0.8",
+				}, addressRange("192.168.0.5", "192.168.0.20")),
+			},
+			expectedReason:  "AddressConflict",
+			expectedMessage: "IPPool myns/bcd: ranges overlap, also allocated 192.168.0.6, 192.168.0.7",
+			expectedEvents:  2,
+		}),
+		Entry("Overlapping ranges in another namespace", testCaseConflicts{
+			ipPool: newPool("myns", "abc", nil,
+				addressRange("192.168.0.1", "192.168.0.10"),
+			),
+			ipPools: []*ipamv1.IPPool{
+				newPool("otherns", "bcd", nil, addressRange("192.168.0.5", "192.168.0.20")),
+			},
+			expectNoCondition: true,
+		}),
+		Entry("Overlapping ranges in another namespace, cluster scope", testCaseConflicts{
+			ipPool: newPool("myns", "abc", nil,
+				addressRange("192.168.0.1", "192.168.0.10"),
+			),
+			ipPools: []*ipamv1.IPPool{
+				newPool("otherns", "bcd", nil, addressRange("192.168.0.5", "192.168.0.20")),
+			},
+			clusterIPPools: []*ipamv1.ClusterIPPool{
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "cde"},
+					Spec: ipamv1.IPPoolSpec{
+						Pools: []ipamv1.Pool{addressRange("192.168.0.9", "192.168.0.9")},
+					},
+				},
+			},
+			scope:           ConflictScopeCluster,
+			expectedReason:  "RangeOverlap",
+			expectedMessage: "ClusterIPPool cde: ranges overlap; IPPool otherns/bcd: ranges overlap",
+			expectedEvents:  4,
+		}),
+		Entry("Renamed pool and pool delegating prefixes", testCaseConflicts{
+			ipPool: newPool("myns", "abc", nil,
+				addressRange("192.168.0.1", "192.168.0.10"),
+			),
+			ipPools: []*ipamv1.IPPool{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:        "bcd",
+						Namespace:   "myns",
+						Annotations: map[string]string{ipamv1.RenamedFromAnnotation: "abc"},
+					},
+					Spec: ipamv1.IPPoolSpec{
+						Pools: []ipamv1.Pool{addressRange("192.168.0.1", "192.168.0.10")},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "cde", Namespace: "myns"},
+					Spec: ipamv1.IPPoolSpec{
+						Pools:           []ipamv1.Pool{addressRange("192.168.0.0", "192.168.0.255")},
+						DelegatedPrefix: 28,
+					},
+				},
+			},
+			expectNoCondition: true,
+		}),
+		Entry("Conflict resolved", testCaseConflicts{
+			ipPool: &ipamv1.IPPool{
+				ObjectMeta: metav1.ObjectMeta{Name: "abc", Namespace: "myns"},
+				Spec: ipamv1.IPPoolSpec{
+					Pools: []ipamv1.Pool{addressRange("192.168.0.1", "192.168.0.10")},
+				},
+				Status: ipamv1.IPPoolStatus{
+					Conditions: []metav1.Condition{
+						{
+							Type:    ipamv1.IPPoolConflictCondition,
+							Status:  metav1.ConditionTrue,
+							Reason:  "RangeOverlap",
+							Message: "IPPool myns/bcd: ranges overlap",
+						},
+					},
+				},
+			},
+		}),
+		Entry("Conflict already reported", testCaseConflicts{
+			ipPool: &ipamv1.IPPool{
+				ObjectMeta: metav1.ObjectMeta{Name: "abc", Namespace: "myns"},
+				Spec: ipamv1.IPPoolSpec{
+					Pools: []ipamv1.Pool{addressRange("192.168.0.1", "192.168.0.10")},
+				},
+				Status: ipamv1.IPPoolStatus{
+					Conditions: []metav1.Condition{
+						{
+							Type:    ipamv1.IPPoolConflictCondition,
+							Status:  metav1.ConditionTrue,
+							Reason:  "RangeOverlap",
+							Message: "IPPool myns/bcd: ranges overlap",
+						},
+					},
+				},
+			},
+			ipPools: []*ipamv1.IPPool{
+				newPool("myns", "bcd", nil, addressRange("192.168.0.5", "192.168.0.20")),
+			},
+			expectedReason:  "RangeOverlap",
+			expectedMessage: "IPPool myns/bcd: ranges overlap",
+		}),
+	)
+})
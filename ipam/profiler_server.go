@@ -0,0 +1,85 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+)
+
+// ProfilerServer serves the pprof handlers of the controller, to profile it
+// in production. It only listens on the loopback interface, the profiles are
+// fetched through a port-forward to the pod.
+type ProfilerServer struct {
+	// Addr is the address the profiler listens on
+	Addr string
+	Log  logr.Logger
+}
+
+// NewProfilerServer returns the profiler listening on the address, which must
+// be a loopback address
+func NewProfilerServer(addr string, log logr.Logger) (*ProfilerServer, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid profiler address")
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("the profiler address %s is not a loopback address", addr)
+	}
+	return &ProfilerServer{
+		Addr: addr,
+		Log:  log,
+	}, nil
+}
+
+// Start serves the profiler until the context is done, it implements the
+// Runnable of the manager
+func (s *ProfilerServer) Start(ctx context.Context) error {
+	server := &http.Server{Addr: s.Addr, Handler: s.Handler()}
+	go func() {
+		<-ctx.Done()
+		_ = server.Close()
+	}()
+	s.Log.Info("Serving the profiler", "address", s.Addr)
+	err := server.ListenAndServe()
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// NeedLeaderElection returns false, all the replicas can be profiled
+func (s *ProfilerServer) NeedLeaderElection() bool {
+	return false
+}
+
+// Handler returns the handler of the pprof endpoints, under /debug/pprof/
+func (s *ProfilerServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/klog/v2/klogr"
+)
+
+var _ = Describe("Profiler server", func() {
+
+	DescribeTable("Test NewProfilerServer",
+		func(addr string, expectError bool) {
+			_, err := NewProfilerServer(addr, klogr.New())
+			if expectError {
+				Expect(err).To(HaveOccurred())
+			} else {
+				Expect(err).NotTo(HaveOccurred())
+			}
+		},
+		Entry("localhost", "localhost:6060", false),
+		Entry("IPv4 loopback", "127.0.0.1:6060", false),
+		Entry("IPv6 loopback", "[::1]:6060", false),
+		Entry("All interfaces", ":6060", true),
+		Entry("Other address", "192.168.0.1:6060", true),
+		Entry("No port", "localhost", true),
+	)
+
+	DescribeTable("Test Handler",
+		func(path string, expectedStatus int) {
+			server, err := NewProfilerServer("localhost:6060", klogr.New())
+			Expect(err).NotTo(HaveOccurred())
+			recorder := httptest.NewRecorder()
+			server.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+			Expect(recorder.Code).To(Equal(expectedStatus))
+		},
+		Entry("Index", "/debug/pprof/", http.StatusOK),
+		Entry("Heap profile", "/debug/pprof/heap", http.StatusOK),
+		Entry("Goroutines", "/debug/pprof/goroutine?debug=1", http.StatusOK),
+		Entry("Unknown profile", "/debug/pprof/unknown", http.StatusNotFound),
+		Entry("Other path", "/metrics", http.StatusNotFound),
+	)
+})
@@ -0,0 +1,233 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"time"
+
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	"github.com/pkg/errors"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// defaultReleaseHookTimeout is the timeout of the requests of the release
+	// hooks that do not set it
+	defaultReleaseHookTimeout = 10 * time.Second
+	// releaseHookRetryInterval is the interval after which a release waiting
+	// for its hook is retried
+	releaseHookRetryInterval = 10 * time.Second
+)
+
+// ReleasedAddress is the address sent to the release hook of a pool
+type ReleasedAddress struct {
+	Address        ipamv1.IPAddressStr `json:"address"`
+	Prefix         int                 `json:"prefix,omitempty"`
+	Pool           string              `json:"pool"`
+	Namespace      string              `json:"namespace"`
+	Claim          string              `json:"claim"`
+	ClaimNamespace string              `json:"claimNamespace"`
+	Role           string              `json:"role,omitempty"`
+}
+
+// newReleasedAddress returns the released address of the given role of a claim
+func (m *IPPoolManager) newReleasedAddress(addressClaim *ipamv1.IPClaim,
+	role string, address ipamv1.IPAddressStr, addressObject *ipamv1.IPAddress,
+) ReleasedAddress {
+	released := ReleasedAddress{
+		Address:        address,
+		Pool:           m.IPPool.Name,
+		Namespace:      m.IPPool.Namespace,
+		Claim:          addressClaim.Name,
+		ClaimNamespace: addressClaim.Namespace,
+		Role:           role,
+	}
+	if addressObject != nil {
+		released.Prefix = addressObject.Spec.Prefix
+	}
+	return released
+}
+
+// deregisterAddress runs the release hook of the pool for the released
+// address. It returns true once the hook succeeded, false if it is still
+// running, and an error if it failed.
+func (m *IPPoolManager) deregisterAddress(ctx context.Context, released ReleasedAddress) (bool, error) {
+	hook := m.IPPool.Spec.ReleaseHook
+	if hook.Job != nil {
+		return m.runReleaseJob(ctx, released)
+	}
+	return true, callReleaseURL(ctx, hook, released)
+}
+
+// callReleaseURL posts the released address to the URL of the release hook
+func callReleaseURL(ctx context.Context, hook *ipamv1.ReleaseHook, released ReleasedAddress) error {
+	timeout := defaultReleaseHookTimeout
+	if hook.Timeout != nil {
+		timeout = hook.Timeout.Duration
+	}
+	body, err := json.Marshal(released)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return errors.New(fmt.Sprintf("the release hook returned %s", response.Status))
+	}
+	return nil
+}
+
+// runReleaseJob runs the Job of the release hook for the released address.
+// The Job is created on the first call and deleted once it succeeded, or
+// failed so that the next call retries it.
+func (m *IPPoolManager) runReleaseJob(ctx context.Context, released ReleasedAddress) (bool, error) {
+	job := &batchv1.Job{}
+	key := client.ObjectKey{
+		Name:      m.releaseJobName(released.Address),
+		Namespace: m.addressNamespace(released.ClaimNamespace),
+	}
+	if err := m.client.Get(ctx, key, job); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return false, err
+		}
+		job = m.renderReleaseJob(key, released)
+		if err := m.client.Create(ctx, job); err != nil && !apierrors.IsAlreadyExists(err) {
+			return false, errors.Wrap(err, "failed to create the release Job")
+		}
+		m.Log.Info("Release Job created", "Job", key.Name, "address", released.Address)
+		return false, nil
+	}
+
+	failed := false
+	for _, condition := range job.Status.Conditions {
+		if condition.Type == batchv1.JobFailed && condition.Status == corev1.ConditionTrue {
+			failed = true
+		}
+	}
+	if job.Status.Succeeded == 0 && !failed {
+		return false, nil
+	}
+	if err := deleteObject(m.client, ctx, job,
+		client.PropagationPolicy(metav1.DeletePropagationBackground),
+	); err != nil {
+		return false, err
+	}
+	if job.Status.Succeeded == 0 {
+		return false, errors.New(fmt.Sprintf("the release Job %s failed", key.Name))
+	}
+	return true, nil
+}
+
+// releaseJobName returns the name of the release Job of an address. The
+// names too long for a Job are replaced by a hash.
+func (m *IPPoolManager) releaseJobName(address ipamv1.IPAddressStr) string {
+	name := m.formatAddressName(address) + "-release"
+	if len(name) <= validation.DNS1035LabelMaxLength {
+		return name
+	}
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(name))
+	return "ipam-release-" + strconv.FormatUint(hash.Sum64(), 16)
+}
+
+// renderReleaseJob renders the Job of the release hook for the released
+// address, giving it to the containers through environment variables
+func (m *IPPoolManager) renderReleaseJob(key client.ObjectKey, released ReleasedAddress) *batchv1.Job {
+	template := m.IPPool.Spec.ReleaseHook.Job.DeepCopy()
+	job := &batchv1.Job{
+		ObjectMeta: template.ObjectMeta,
+		Spec:       template.Spec,
+	}
+	job.Name = key.Name
+	job.Namespace = key.Namespace
+	job.OwnerReferences = []metav1.OwnerReference{
+		{
+			APIVersion: m.IPPool.APIVersion,
+			Kind:       m.IPPool.Kind,
+			Name:       m.IPPool.Name,
+			UID:        m.IPPool.UID,
+		},
+	}
+	if job.Spec.Template.Spec.RestartPolicy == "" {
+		job.Spec.Template.Spec.RestartPolicy = corev1.RestartPolicyNever
+	}
+	env := []corev1.EnvVar{
+		{Name: "IPAM_ADDRESS", Value: string(released.Address)},
+		{Name: "IPAM_PREFIX", Value: strconv.Itoa(released.Prefix)},
+		{Name: "IPAM_POOL", Value: released.Pool},
+		{Name: "IPAM_NAMESPACE", Value: released.Namespace},
+		{Name: "IPAM_CLAIM", Value: released.Claim},
+		{Name: "IPAM_CLAIM_NAMESPACE", Value: released.ClaimNamespace},
+		{Name: "IPAM_ROLE", Value: released.Role},
+	}
+	for i := range job.Spec.Template.Spec.Containers {
+		container := &job.Spec.Template.Spec.Containers[i]
+		container.Env = append(container.Env, env...)
+	}
+	return job
+}
+
+// setDeregistrationCondition reports the result of the release hook and of
+// the release from the backend on the claim being deleted. A failure is
+// reported with its reason and error, the condition is set to false once the
+// addresses are deregistered.
+func (m *IPPoolManager) setDeregistrationCondition(addressClaim *ipamv1.IPClaim, reason string, err error) {
+	if err == nil {
+		if meta.FindStatusCondition(addressClaim.Status.Conditions,
+			ipamv1.IPClaimDeregistrationFailedCondition,
+		) != nil {
+			meta.SetStatusCondition(&addressClaim.Status.Conditions, metav1.Condition{
+				Type:   ipamv1.IPClaimDeregistrationFailedCondition,
+				Status: metav1.ConditionFalse,
+				Reason: "Deregistered",
+			})
+		}
+		return
+	}
+	message := "Deregistration failed: " + err.Error()
+	m.Log.Info("Address deregistration failed", "IPClaim", addressClaim.Name, "message", message)
+	meta.SetStatusCondition(&addressClaim.Status.Conditions, metav1.Condition{
+		Type:    ipamv1.IPClaimDeregistrationFailedCondition,
+		Status:  metav1.ConditionTrue,
+		Reason:  reason,
+		Message: message,
+	})
+	addressClaim.Status.ErrorMessage = &message
+}
@@ -0,0 +1,231 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/klogr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Release hook", func() {
+
+	type testCaseReleaseHook struct {
+		hookStatus        int
+		job               bool
+		jobStatus         *batchv1.JobStatus
+		failedCondition   bool
+		expectReleased    bool
+		expectJob         bool
+		expectedCondition *metav1.ConditionStatus
+	}
+
+	conditionStatus := func(status metav1.ConditionStatus) *metav1.ConditionStatus {
+		return &status
+	}
+
+	DescribeTable("Test deleteAddress with a release hook",
+		func(tc testCaseReleaseHook) {
+			received := []ReleasedAddress{}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				released := ReleasedAddress{}
+				Expect(json.NewDecoder(r.Body).Decode(&released)).To(Succeed())
+				received = append(received, released)
+				w.WriteHeader(tc.hookStatus)
+			}))
+			defer server.Close()
+
+			hook := &ipamv1.ReleaseHook{URL: server.URL}
+			if tc.job {
+				hook = &ipamv1.ReleaseHook{
+					Job: &batchv1.JobTemplateSpec{
+						Spec: batchv1.JobSpec{
+							Template: corev1.PodTemplateSpec{
+								Spec: corev1.PodSpec{
+									Containers: []corev1.Container{{Name: "deregister"}},
+								},
+							},
+						},
+					},
+				}
+			}
+			ipPool := &ipamv1.IPPool{
+				ObjectMeta: testObjectMeta,
+				Spec: ipamv1.IPPoolSpec{
+					NamePrefix:  "abcpref",
+					ReleaseHook: hook,
+				},
+				Status: ipamv1.IPPoolStatus{
+					Allocations: map[string]ipamv1.IPAddressStr{
+						"TestRef": "192.168.0.5",
+					},
+				},
+			}
+			ipClaim := &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:       "TestRef",
+					Namespace:  "myns",
+					Finalizers: []string{ipamv1.IPClaimFinalizer},
+				},
+			}
+			if tc.failedCondition {
+				ipClaim.Status.Conditions = []metav1.Condition{
+					{
+						Type:   ipamv1.IPClaimDeregistrationFailedCondition,
+						Status: metav1.ConditionTrue,
+						Reason: "ReleaseHookFailed",
+					},
+				}
+			}
+
+			objects := []client.Object{
+				&ipamv1.IPAddress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "abcpref-192-168-0-5",
+						Namespace: "myns",
+					},
+					Spec: ipamv1.IPAddressSpec{
+						Address: "192.168.0.5",
+						Prefix:  24,
+					},
+				},
+			}
+			if tc.jobStatus != nil {
+				objects = append(objects, &batchv1.Job{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "abcpref-192-168-0-5-release",
+						Namespace: "myns",
+					},
+					Status: *tc.jobStatus,
+				})
+			}
+			s := setupScheme()
+			Expect(batchv1.AddToScheme(s)).To(Succeed())
+			c := fakeclient.NewClientBuilder().WithScheme(s).WithObjects(objects...).Build()
+			ipPoolMgr, err := NewIPPoolManager(c, ipPool, klogr.New())
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = ipPoolMgr.deleteAddress(context.TODO(), ipClaim,
+				map[ipamv1.IPAddressStr]string{"192.168.0.5": "TestRef"},
+			)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ipPoolMgr.releasePending).To(Equal(!tc.expectReleased))
+
+			addressObjects := ipamv1.IPAddressList{}
+			Expect(c.List(context.TODO(), &addressObjects)).To(Succeed())
+			if tc.expectReleased {
+				Expect(addressObjects.Items).To(BeEmpty())
+				Expect(ipPool.Status.Allocations).To(BeEmpty())
+				Expect(ipClaim.Finalizers).To(BeEmpty())
+			} else {
+				Expect(addressObjects.Items).To(HaveLen(1))
+				Expect(ipPool.Status.Allocations).To(HaveLen(1))
+				Expect(ipClaim.Finalizers).To(HaveLen(1))
+			}
+
+			if !tc.job {
+				Expect(received).To(Equal([]ReleasedAddress{
+					{
+						Address:        "192.168.0.5",
+						Prefix:         24,
+						Pool:           "abc",
+						Namespace:      "myns",
+						Claim:          "TestRef",
+						ClaimNamespace: "myns",
+					},
+				}))
+			}
+
+			jobs := batchv1.JobList{}
+			Expect(c.List(context.TODO(), &jobs)).To(Succeed())
+			if !tc.expectJob {
+				Expect(jobs.Items).To(BeEmpty())
+			} else {
+				Expect(jobs.Items).To(HaveLen(1))
+			}
+			if tc.expectJob && tc.jobStatus == nil {
+				container := jobs.Items[0].Spec.Template.Spec.Containers[0]
+				Expect(container.Env).To(ContainElement(corev1.EnvVar{Name: "IPAM_ADDRESS", Value: "192.168.0.5"}))
+				Expect(container.Env).To(ContainElement(corev1.EnvVar{Name: "IPAM_PREFIX", Value: "24"}))
+				Expect(jobs.Items[0].Spec.Template.Spec.RestartPolicy).To(Equal(corev1.RestartPolicyNever))
+			}
+
+			condition := meta.FindStatusCondition(ipClaim.Status.Conditions,
+				ipamv1.IPClaimDeregistrationFailedCondition,
+			)
+			if tc.expectedCondition == nil {
+				Expect(condition).To(BeNil())
+			} else {
+				Expect(condition).NotTo(BeNil())
+				Expect(condition.Status).To(Equal(*tc.expectedCondition))
+			}
+		},
+		Entry("URL succeeded", testCaseReleaseHook{
+			hookStatus:     http.StatusOK,
+			expectReleased: true,
+		}),
+		Entry("URL succeeded after a failure", testCaseReleaseHook{
+			hookStatus:        http.StatusNoContent,
+			failedCondition:   true,
+			expectReleased:    true,
+			expectedCondition: conditionStatus(metav1.ConditionFalse),
+		}),
+		Entry("URL failed", testCaseReleaseHook{
+			hookStatus:        http.StatusInternalServerError,
+			expectedCondition: conditionStatus(metav1.ConditionTrue),
+		}),
+		Entry("Job created", testCaseReleaseHook{
+			job:       true,
+			expectJob: true,
+		}),
+		Entry("Job running, after a failure", testCaseReleaseHook{
+			job:               true,
+			jobStatus:         &batchv1.JobStatus{Active: 1},
+			failedCondition:   true,
+			expectJob:         true,
+			expectedCondition: conditionStatus(metav1.ConditionTrue),
+		}),
+		Entry("Job succeeded", testCaseReleaseHook{
+			job:            true,
+			jobStatus:      &batchv1.JobStatus{Succeeded: 1},
+			expectReleased: true,
+		}),
+		Entry("Job failed", testCaseReleaseHook{
+			job: true,
+			jobStatus: &batchv1.JobStatus{
+				Conditions: []batchv1.JobCondition{
+					{Type: batchv1.JobFailed, Status: corev1.ConditionTrue},
+				},
+			},
+			expectedCondition: conditionStatus(metav1.ConditionTrue),
+		}),
+	)
+})
@@ -0,0 +1,112 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"sync"
+	"time"
+
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+)
+
+// reservationTimeout is the duration after which a reservation whose
+// IPAddress never showed up in the cache is forgotten
+const reservationTimeout = time.Minute
+
+// reservation is an address allocated by a manager, whose IPAddress may not
+// be in the cache of the client yet
+type reservation struct {
+	// key is the allocation key of the claim
+	key        string
+	reservedAt time.Time
+}
+
+// addressReservations are the addresses allocated by the managers of a
+// factory, by pool. The IPPool and IPClaim controllers allocate the
+// addresses of the same pools concurrently, from the cache of the client,
+// which does not contain the IPAddresses they just created. Reserving an
+// address before creating its IPAddress makes the reservation the
+// linearization point of the allocations within the process, including for
+// the ClusterIPPools whose IPAddresses are spread across namespaces. The
+// reservations are kept until the IPAddresses are in the cache.
+type addressReservations struct {
+	mu    sync.Mutex
+	pools map[string]map[ipamv1.IPAddressStr]reservation
+	// now returns the current time, replaced in the tests
+	now func() time.Time
+}
+
+func newAddressReservations() *addressReservations {
+	return &addressReservations{
+		pools: make(map[string]map[ipamv1.IPAddressStr]reservation),
+		now:   time.Now,
+	}
+}
+
+// reserve reserves the address of the pool for the allocation key. It returns
+// the key of the current reservation and false if the address is reserved
+// for another key.
+func (r *addressReservations) reserve(pool string, address ipamv1.IPAddressStr, key string) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	reservations, ok := r.pools[pool]
+	if !ok {
+		reservations = make(map[ipamv1.IPAddressStr]reservation)
+		r.pools[pool] = reservations
+	}
+	if current, ok := reservations[address]; ok && current.key != key &&
+		now.Sub(current.reservedAt) < reservationTimeout {
+		return current.key, false
+	}
+	reservations[address] = reservation{key: key, reservedAt: now}
+	return key, true
+}
+
+// release forgets the reservation of the address of the pool, when its
+// IPAddress could not be created or was deleted
+func (r *addressReservations) release(pool string, address ipamv1.IPAddressStr) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.pools[pool], address)
+	if len(r.pools[pool]) == 0 {
+		delete(r.pools, pool)
+	}
+}
+
+// pending returns the allocation keys of the addresses of the pool reserved
+// but missing from the given addresses, listed from the cache. The
+// reservations of the listed addresses and the expired ones are forgotten.
+func (r *addressReservations) pending(pool string,
+	listed map[ipamv1.IPAddressStr]string,
+) map[ipamv1.IPAddressStr]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	pending := make(map[ipamv1.IPAddressStr]string)
+	for address, current := range r.pools[pool] {
+		if _, ok := listed[address]; ok || now.Sub(current.reservedAt) >= reservationTimeout {
+			delete(r.pools[pool], address)
+			continue
+		}
+		pending[address] = current.key
+	}
+	if len(r.pools[pool]) == 0 {
+		delete(r.pools, pool)
+	}
+	return pending
+}
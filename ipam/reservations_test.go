@@ -0,0 +1,120 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/klogr"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Address reservations", func() {
+
+	It("Reserves the addresses until they are listed", func() {
+		now := time.Now()
+		reservations := newAddressReservations()
+		reservations.now = func() time.Time { return now }
+
+		owner, ok := reservations.reserve("IPPool myns/abc", "192.168.0.10", "abc")
+		Expect(ok).To(BeTrue())
+		Expect(owner).To(Equal("abc"))
+		owner, ok = reservations.reserve("IPPool myns/abc", "192.168.0.10", "bcd")
+		Expect(ok).To(BeFalse())
+		Expect(owner).To(Equal("abc"))
+		// The same address of another pool is not reserved
+		_, ok = reservations.reserve("ClusterIPPool abc", "192.168.0.10", "bcd")
+		Expect(ok).To(BeTrue())
+		_, ok = reservations.reserve("IPPool myns/abc", "192.168.0.11", "cde")
+		Expect(ok).To(BeTrue())
+
+		Expect(reservations.pending("IPPool myns/abc", map[ipamv1.IPAddressStr]string{
+			"192.168.0.11": "cde",
+		})).To(Equal(map[ipamv1.IPAddressStr]string{"192.168.0.10": "abc"}))
+		Expect(reservations.pending("IPPool myns/abc", map[ipamv1.IPAddressStr]string{})).To(
+			Equal(map[ipamv1.IPAddressStr]string{"192.168.0.10": "abc"}),
+		)
+
+		reservations.release("IPPool myns/abc", "192.168.0.10")
+		Expect(reservations.pending("IPPool myns/abc", nil)).To(BeEmpty())
+		Expect(reservations.pools).To(HaveLen(1))
+	})
+
+	It("Forgets the expired reservations", func() {
+		now := time.Now()
+		reservations := newAddressReservations()
+		reservations.now = func() time.Time { return now }
+
+		_, ok := reservations.reserve("IPPool myns/abc", "192.168.0.10", "abc")
+		Expect(ok).To(BeTrue())
+		now = now.Add(reservationTimeout)
+		_, ok = reservations.reserve("IPPool myns/abc", "192.168.0.10", "bcd")
+		Expect(ok).To(BeTrue())
+		now = now.Add(reservationTimeout)
+		Expect(reservations.pending("IPPool myns/abc", nil)).To(BeEmpty())
+		Expect(reservations.pools).To(BeEmpty())
+	})
+
+	It("Does not allocate the addresses missing from a stale cache twice", func() {
+		clusterIPPool := &ipamv1.ClusterIPPool{
+			ObjectMeta: metav1.ObjectMeta{Name: "abc"},
+			Spec: ipamv1.IPPoolSpec{
+				NamePrefix: "abcpref",
+				Pools: []ipamv1.Pool{
+					{
+						Start: (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.10")),
+						End:   (*ipamv1.IPAddressStr)(pointer.StringPtr("192.168.0.19")),
+					},
+				},
+			},
+		}
+		newClaim := func(namespace string) *ipamv1.IPClaim {
+			return &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{Name: "abc", Namespace: namespace},
+				Spec: ipamv1.IPClaimSpec{
+					Pool: corev1.ObjectReference{Name: "abc", Kind: ipamv1.ClusterIPPoolKind},
+				},
+			}
+		}
+		reservations := newAddressReservations()
+		allocate := func(claim *ipamv1.IPClaim) ipamv1.IPAddressStr {
+			// Each reconcile lists from a cache without the IPAddresses
+			// created by the other one
+			c := fakeclient.NewClientBuilder().WithScheme(setupScheme()).WithObjects(claim).Build()
+			ipPoolMgr, err := NewIPPoolManager(c, clusterIPPool.AsIPPool(), klogr.New())
+			Expect(err).NotTo(HaveOccurred())
+			ipPoolMgr.reservations = reservations
+			Expect(ipPoolMgr.UpdateClaim(context.TODO(), claim)).To(Succeed())
+			addressObjects := ipamv1.IPAddressList{}
+			Expect(c.List(context.TODO(), &addressObjects, client.InNamespace(claim.Namespace))).To(Succeed())
+			Expect(addressObjects.Items).To(HaveLen(1))
+			return addressObjects.Items[0].Spec.Address
+		}
+
+		Expect(allocate(newClaim("ns1"))).To(Equal(ipamv1.IPAddressStr("192.168.0.10")))
+		Expect(allocate(newClaim("ns2"))).To(Equal(ipamv1.IPAddressStr("192.168.0.11")))
+	})
+})
@@ -0,0 +1,160 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+// DefaultRequeueAfter is the default interval after which the objects whose
+// reconciliation is paused or blocked are reconciled again
+const DefaultRequeueAfter = 30 * time.Second
+
+// RequeueJitterFactor is the maximum fraction of the requeue interval added to
+// it when requeueing an object, so that the objects paused or blocked together,
+// such as during an outage, are not all reconciled again in the same second
+const RequeueJitterFactor = 0.2
+
+// Settings contains the operator-wide settings that can be modified at
+// runtime through the ControllerConfig. The settings not given by the
+// ControllerConfig have the default values, given by the flags. It is safe for
+// concurrent use and a nil Settings returns the default values.
+type Settings struct {
+	mutex                      sync.RWMutex
+	defaultRequeueAfter        time.Duration
+	defaultStaleClaimThreshold time.Duration
+	defaultLogVerbosity        int32
+	requeueAfter               time.Duration
+	staleClaimThreshold        time.Duration
+	logVerbosity               int32
+	// config is the ControllerConfig applied last, applied again when the
+	// default values change
+	config *ipamv1.ControllerConfig
+}
+
+// NewSettings returns the settings with the given default values
+func NewSettings(requeueAfter, staleClaimThreshold time.Duration, logVerbosity int32) *Settings {
+	s := &Settings{
+		defaultRequeueAfter:        requeueAfter,
+		defaultStaleClaimThreshold: staleClaimThreshold,
+		defaultLogVerbosity:        logVerbosity,
+	}
+	s.Apply(nil)
+	return s
+}
+
+// Apply sets the settings of the ControllerConfig. The settings not given by
+// the ControllerConfig, or all of them if it is nil, get the default values.
+// The log verbosity is applied to the logger of the controllers.
+func (s *Settings) Apply(config *ipamv1.ControllerConfig) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if config != nil {
+		config = config.DeepCopy()
+	}
+	s.config = config
+	s.apply()
+}
+
+// SetDefaults modifies the default values of the settings, such as when the
+// configuration file of the controller changes. The ControllerConfig applied
+// last still takes precedence.
+func (s *Settings) SetDefaults(requeueAfter, staleClaimThreshold time.Duration, logVerbosity int32) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.defaultRequeueAfter = requeueAfter
+	s.defaultStaleClaimThreshold = staleClaimThreshold
+	s.defaultLogVerbosity = logVerbosity
+	s.apply()
+}
+
+// apply sets the settings of the ControllerConfig applied last, or the
+// default values. The mutex must be held.
+func (s *Settings) apply() {
+	config := s.config
+	s.requeueAfter = s.defaultRequeueAfter
+	s.staleClaimThreshold = s.defaultStaleClaimThreshold
+	s.logVerbosity = s.defaultLogVerbosity
+	if config != nil {
+		if config.Spec.RequeueInterval != nil {
+			s.requeueAfter = config.Spec.RequeueInterval.Duration
+		}
+		if config.Spec.StaleClaimThreshold != nil {
+			s.staleClaimThreshold = config.Spec.StaleClaimThreshold.Duration
+		}
+		if config.Spec.LogVerbosity != nil {
+			s.logVerbosity = *config.Spec.LogVerbosity
+		}
+	}
+	// The verbosity of klog is global, setting it through any level
+	// modifies it for all the loggers
+	var level klog.Level
+	_ = level.Set(strconv.Itoa(int(s.logVerbosity)))
+}
+
+// String renders the settings in use
+func (s *Settings) String() string {
+	return fmt.Sprintf("requeueInterval: %s, staleClaimThreshold: %s, logVerbosity: %d",
+		s.RequeueAfter(), s.StaleClaimThreshold(), s.LogVerbosity(),
+	)
+}
+
+// RequeueAfter returns the interval after which the objects whose
+// reconciliation is paused or blocked are reconciled again
+func (s *Settings) RequeueAfter() time.Duration {
+	if s == nil {
+		return DefaultRequeueAfter
+	}
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.requeueAfter
+}
+
+// JitteredRequeueAfter returns the RequeueAfter interval increased by a random
+// duration of up to RequeueJitterFactor of it, the interval the objects are
+// requeued after
+func (s *Settings) JitteredRequeueAfter() time.Duration {
+	return wait.Jitter(s.RequeueAfter(), RequeueJitterFactor)
+}
+
+// StaleClaimThreshold returns the duration after which a claim without an
+// address is reported as stale, zero disables the reporting
+func (s *Settings) StaleClaimThreshold() time.Duration {
+	if s == nil {
+		return 0
+	}
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.staleClaimThreshold
+}
+
+// LogVerbosity returns the verbosity of the logs of the controllers
+func (s *Settings) LogVerbosity() int32 {
+	if s == nil {
+		return 0
+	}
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.logVerbosity
+}
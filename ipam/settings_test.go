@@ -0,0 +1,120 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/pointer"
+)
+
+var _ = Describe("Settings", func() {
+
+	type testCaseSettings struct {
+		config                      *ipamv1.ControllerConfig
+		expectedRequeueAfter        time.Duration
+		expectedStaleClaimThreshold time.Duration
+		expectedLogVerbosity        int32
+	}
+
+	DescribeTable("Test Apply",
+		func(tc testCaseSettings) {
+			settings := NewSettings(time.Minute, 10*time.Minute, 0)
+			// The previous settings are not kept
+			settings.Apply(&ipamv1.ControllerConfig{
+				Spec: ipamv1.ControllerConfigSpec{
+					RequeueInterval:     &metav1.Duration{Duration: time.Hour},
+					StaleClaimThreshold: &metav1.Duration{Duration: time.Hour},
+					LogVerbosity:        pointer.Int32Ptr(5),
+				},
+			})
+			settings.Apply(tc.config)
+			defer settings.Apply(nil)
+			Expect(settings.RequeueAfter()).To(Equal(tc.expectedRequeueAfter))
+			Expect(settings.StaleClaimThreshold()).To(Equal(tc.expectedStaleClaimThreshold))
+			Expect(settings.LogVerbosity()).To(Equal(tc.expectedLogVerbosity))
+			Expect(bool(klog.V(klog.Level(tc.expectedLogVerbosity)).Enabled())).To(BeTrue())
+			Expect(bool(klog.V(klog.Level(tc.expectedLogVerbosity + 1)).Enabled())).To(BeFalse())
+		},
+		Entry("No config", testCaseSettings{
+			expectedRequeueAfter:        time.Minute,
+			expectedStaleClaimThreshold: 10 * time.Minute,
+		}),
+		Entry("Empty config", testCaseSettings{
+			config:                      &ipamv1.ControllerConfig{},
+			expectedRequeueAfter:        time.Minute,
+			expectedStaleClaimThreshold: 10 * time.Minute,
+		}),
+		Entry("Config", testCaseSettings{
+			config: &ipamv1.ControllerConfig{
+				Spec: ipamv1.ControllerConfigSpec{
+					RequeueInterval:     &metav1.Duration{Duration: 5 * time.Second},
+					StaleClaimThreshold: &metav1.Duration{},
+					LogVerbosity:        pointer.Int32Ptr(3),
+				},
+			},
+			expectedRequeueAfter:        5 * time.Second,
+			expectedStaleClaimThreshold: 0,
+			expectedLogVerbosity:        3,
+		}),
+	)
+
+	It("returns the defaults when nil", func() {
+		var settings *Settings
+		Expect(settings.RequeueAfter()).To(Equal(DefaultRequeueAfter))
+		Expect(settings.StaleClaimThreshold()).To(BeZero())
+		Expect(settings.LogVerbosity()).To(BeZero())
+	})
+
+	It("jitters the requeue interval", func() {
+		settings := NewSettings(time.Minute, 0, 0)
+		maxRequeueAfter := time.Minute + time.Duration(RequeueJitterFactor*float64(time.Minute))
+		for i := 0; i < 100; i++ {
+			requeueAfter := settings.JitteredRequeueAfter()
+			Expect(requeueAfter).To(BeNumerically(">=", time.Minute))
+			Expect(requeueAfter).To(BeNumerically("<", maxRequeueAfter))
+		}
+		var nilSettings *Settings
+		Expect(nilSettings.JitteredRequeueAfter()).To(BeNumerically(">=", DefaultRequeueAfter))
+	})
+
+	It("applies the last ControllerConfig over the new defaults", func() {
+		settings := NewSettings(time.Minute, 10*time.Minute, 0)
+		settings.Apply(&ipamv1.ControllerConfig{
+			Spec: ipamv1.ControllerConfigSpec{
+				RequeueInterval: &metav1.Duration{Duration: time.Hour},
+			},
+		})
+		settings.SetDefaults(2*time.Minute, 20*time.Minute, 0)
+		Expect(settings.RequeueAfter()).To(Equal(time.Hour))
+		Expect(settings.StaleClaimThreshold()).To(Equal(20 * time.Minute))
+		settings.Apply(nil)
+		Expect(settings.RequeueAfter()).To(Equal(2 * time.Minute))
+	})
+
+	It("renders the settings", func() {
+		settings := NewSettings(time.Minute, 0, 0)
+		Expect(settings.String()).To(Equal("requeueInterval: 1m0s, staleClaimThreshold: 0s, logVerbosity: 0"))
+	})
+})
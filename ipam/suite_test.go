@@ -26,6 +26,7 @@ import (
 
 	_ "github.com/go-logr/logr"
 	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	"github.com/metal3-io/ip-address-manager/feature"
 	corev1 "k8s.io/api/core/v1"
 	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -52,6 +53,8 @@ func TestManagers(t *testing.T) {
 }
 
 var _ = BeforeSuite(func() {
+	// The features behind the gates, disabled by default, are covered too
+	Expect(feature.MutableGates.Set("LeaseExpiry=true,ExternalBackends=true")).To(Succeed())
 	done := make(chan interface{})
 
 	go func() {
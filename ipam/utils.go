@@ -20,12 +20,54 @@ import (
 	"context"
 
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/utils/pointer"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+// FieldManager is the name of the controller in the managed fields of the
+// objects it writes
+const FieldManager = "ip-address-manager"
+
+// listPageSize is the number of objects per page of the lists of the tools
+// reading from the API server, so that the lists of the large installations
+// do not time out
+const listPageSize = 500
+
+// listPages lists the objects page by page, following the continue tokens,
+// into the list. A zero page size lists them at once, as from the cache of
+// the controller, which truncates the lists to their limit.
+func listPages(ctx context.Context, cl client.Reader, list client.ObjectList, pageSize int64,
+	opts ...client.ListOption,
+) error {
+	if pageSize == 0 {
+		return cl.List(ctx, list, opts...)
+	}
+	items := []runtime.Object{}
+	continueToken := ""
+	for {
+		pageOpts := append([]client.ListOption{client.Limit(pageSize), client.Continue(continueToken)}, opts...)
+		if err := cl.List(ctx, list, pageOpts...); err != nil {
+			return err
+		}
+		page, err := meta.ExtractList(list)
+		if err != nil {
+			return err
+		}
+		// The items are copied, the next page being decoded in the same list
+		for _, item := range page {
+			items = append(items, item.DeepCopyObject())
+		}
+		continueToken = list.GetContinue()
+		if continueToken == "" {
+			return meta.SetList(list, items)
+		}
+	}
+}
+
 // Filter filters a list for a string.
 func Filter(list []string, strToFilter string) (newList []string) {
 	for _, item := range list {
@@ -56,6 +98,7 @@ func (e *NotFoundError) Error() string {
 }
 
 func updateObject(cl client.Client, ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	opts = append(opts, client.FieldOwner(FieldManager))
 	err := cl.Update(ctx, obj.DeepCopyObject().(client.Object), opts...)
 	if apierrors.IsConflict(err) {
 		return &RequeueAfterError{}
@@ -64,6 +107,7 @@ func updateObject(cl client.Client, ctx context.Context, obj client.Object, opts
 }
 
 func createObject(cl client.Client, ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	opts = append(opts, client.FieldOwner(FieldManager))
 	err := cl.Create(ctx, obj.DeepCopyObject().(client.Object), opts...)
 	if apierrors.IsAlreadyExists(err) {
 		return &RequeueAfterError{}
@@ -71,6 +115,18 @@ func createObject(cl client.Client, ctx context.Context, obj client.Object, opts
 	return err
 }
 
+// applyObject applies the fields set in the object with a server-side apply
+// as the field manager, forcing their ownership. The object only sets the
+// fields owned by the field manager, the fields it no longer sets are removed
+// unless another manager owns them.
+func applyObject(cl client.Client, ctx context.Context, obj client.Object, fieldManager string) error {
+	err := cl.Patch(ctx, obj, client.Apply, client.FieldOwner(fieldManager), client.ForceOwnership)
+	if apierrors.IsConflict(err) {
+		return &RequeueAfterError{}
+	}
+	return err
+}
+
 func deleteObject(cl client.Client, ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
 	err := cl.Delete(ctx, obj.DeepCopyObject().(client.Object), opts...)
 	if apierrors.IsNotFound(err) {
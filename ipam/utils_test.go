@@ -18,6 +18,8 @@ package ipam
 
 import (
 	"context"
+	"fmt"
+	"strconv"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
@@ -26,8 +28,10 @@ import (
 	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
 
 var _ = Describe("Metal3 manager utils", func() {
@@ -191,7 +195,8 @@ var _ = Describe("Metal3 manager utils", func() {
 					Namespace: "myns",
 				},
 				Spec: ipamv1.IPClaimSpec{
-					Pool: corev1.ObjectReference{Name: "abcd"},
+					Pool:  corev1.ObjectReference{Name: "abc"},
+					Roles: []string{"storage"},
 				},
 				Status: ipamv1.IPClaimStatus{
 					Address: &corev1.ObjectReference{Name: "abcd"},
@@ -500,4 +505,64 @@ var _ = Describe("Metal3 manager utils", func() {
 			},
 		}),
 	)
+
+	DescribeTable("Test listPages",
+		func(pageSize int64, expectedPages int) {
+			objects := []client.Object{}
+			for i := 0; i < 7; i++ {
+				objects = append(objects, &ipamv1.IPClaim{
+					ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("claim-%d", i), Namespace: "myns"},
+				})
+			}
+			objects = append(objects, &ipamv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{Name: "claim-other", Namespace: "otherns"},
+			})
+			c := &pagingReader{
+				Client: fakeclient.NewClientBuilder().WithScheme(setupScheme()).WithObjects(objects...).Build(),
+			}
+			claims := ipamv1.IPClaimList{}
+			Expect(listPages(context.TODO(), c, &claims, pageSize, client.InNamespace("myns"))).To(Succeed())
+			Expect(c.pages).To(Equal(expectedPages))
+			Expect(claims.Items).To(HaveLen(7))
+			for i, claim := range claims.Items {
+				Expect(claim.Name).To(Equal(fmt.Sprintf("claim-%d", i)))
+			}
+		},
+		Entry("Without pages", int64(0), 1),
+		Entry("Pages", int64(3), 3),
+		Entry("Single page", int64(10), 1),
+	)
 })
+
+// pagingReader paginates the lists of the fake client, that ignores the
+// limit and continue options, the continue token being the index of the next
+// item
+type pagingReader struct {
+	client.Client
+	pages int
+}
+
+func (c *pagingReader) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	c.pages++
+	listOpts := &client.ListOptions{}
+	listOpts.ApplyOptions(opts)
+	if err := c.Client.List(ctx, list, opts...); err != nil || listOpts.Limit == 0 {
+		return err
+	}
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		return err
+	}
+	start := 0
+	if listOpts.Continue != "" {
+		start, _ = strconv.Atoi(listOpts.Continue)
+	}
+	end := start + int(listOpts.Limit)
+	list.SetContinue("")
+	if end < len(items) {
+		list.SetContinue(strconv.Itoa(end))
+	} else {
+		end = len(items)
+	}
+	return meta.SetList(list, items[start:end])
+}
@@ -0,0 +1,410 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/go-logr/logr"
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/cluster-api/util/patch"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Discrepancy is an inconsistency between the status of an IPPool and the
+// IPClaim and IPAddress objects, with a suggested fix
+type Discrepancy struct {
+	// Pool is the namespace and name of the IPPool
+	Pool string
+	// Message describes the inconsistency
+	Message string
+	// Fix is the suggested fix
+	Fix string
+
+	// poolKey is the key of the IPPool
+	poolKey client.ObjectKey
+	// repair applies the fix, nil if the fix is the rebuild of the pool status
+	repair func(context.Context) error
+}
+
+func (d Discrepancy) String() string {
+	return fmt.Sprintf("IPPool %s: %s. Suggested fix: %s", d.Pool, d.Message, d.Fix)
+}
+
+// VerifyPools cross-checks the allocations of the IPPools of the namespace,
+// or of all namespaces if empty, against the IPClaim and IPAddress objects.
+// It does not modify anything.
+func VerifyPools(ctx context.Context, cl client.Client, namespace string,
+	log logr.Logger,
+) ([]Discrepancy, error) {
+	pools := ipamv1.IPPoolList{}
+	if err := cl.List(ctx, &pools, &client.ListOptions{Namespace: namespace}); err != nil {
+		return nil, err
+	}
+	discrepancies := []Discrepancy{}
+	for i := range pools.Items {
+		ipPoolMgr, err := NewIPPoolManager(cl, &pools.Items[i],
+			log.WithValues("metal3-ippool", client.ObjectKeyFromObject(&pools.Items[i])),
+		)
+		if err != nil {
+			return nil, err
+		}
+		ipPoolMgr.ListPageSize = listPageSize
+		poolDiscrepancies, err := ipPoolMgr.Verify(ctx)
+		if err != nil {
+			return nil, err
+		}
+		discrepancies = append(discrepancies, poolDiscrepancies...)
+	}
+	return discrepancies, nil
+}
+
+// Verify cross-checks the allocations of the pool against the IPClaim and
+// IPAddress objects. It does not modify anything.
+func (m *IPPoolManager) Verify(ctx context.Context) ([]Discrepancy, error) {
+	poolKey := client.ObjectKeyFromObject(m.IPPool)
+	discrepancies := []Discrepancy{}
+	report := func(fix string, repair func(context.Context) error, format string, args ...interface{}) {
+		discrepancies = append(discrepancies, Discrepancy{
+			Pool:    poolKey.String(),
+			Message: fmt.Sprintf(format, args...),
+			Fix:     fix,
+			poolKey: poolKey,
+			repair:  repair,
+		})
+	}
+	rebuildStatus := "reconcile the IPPool to rebuild its status, for example by updating one of its annotations"
+
+	claims, err := m.listClaims(ctx)
+	if err != nil {
+		return nil, err
+	}
+	claimsByKey := make(map[string]*ipamv1.IPClaim)
+	for i := range claims {
+		if !m.isClaimForPool(&claims[i]) {
+			continue
+		}
+		claimsByKey[client.ObjectKeyFromObject(&claims[i]).String()] = &claims[i]
+	}
+
+	addressObjects := ipamv1.IPAddressList{}
+	opts := &client.ListOptions{
+		Namespace: m.IPPool.Namespace,
+	}
+	if err := listPages(ctx, m.client, &addressObjects, m.ListPageSize, opts); err != nil {
+		return nil, err
+	}
+
+	if err := m.loadShardedAllocations(ctx); err != nil {
+		return nil, err
+	}
+	// Compacted allocations only give the allocated addresses
+	compacted := len(m.IPPool.Status.AllocatedRanges) != 0
+	rangeAddresses := make(map[ipamv1.IPAddressStr]bool)
+	if compacted {
+		for _, address := range m.IPPool.Status.AllocatedAddresses() {
+			rangeAddresses[address] = true
+		}
+	}
+
+	// The claims of the IPAddresses, by IPAddress name
+	addressClaims := make(map[string]string)
+	addressesByName := make(map[string]*ipamv1.IPAddress)
+	holders := make(map[ipamv1.IPAddressStr][]string)
+	allocated := make(map[string]bool)
+	for i := range addressObjects.Items {
+		addressObject := &addressObjects.Items[i]
+		if !m.isPoolAddress(addressObject) {
+			continue
+		}
+		addressClaims[addressObject.Name] = ""
+		addressesByName[addressObject.Name] = addressObject
+		holders[addressObject.Spec.Address] = append(
+			holders[addressObject.Spec.Address], addressObject.Name,
+		)
+		deleteAddress := func(ctx context.Context) error {
+			return deleteObject(m.client, ctx, addressObject)
+		}
+
+		if addressObject.Spec.Claim.Name == "" {
+			report("delete the IPAddress", deleteAddress,
+				"IPAddress %s has no claim", addressObject.Name,
+			)
+			continue
+		}
+		claimNamespace := addressObject.Spec.Claim.Namespace
+		if claimNamespace == "" {
+			claimNamespace = addressObject.Namespace
+		}
+		claimKey := client.ObjectKey{
+			Name:      addressObject.Spec.Claim.Name,
+			Namespace: claimNamespace,
+		}
+		addressClaims[addressObject.Name] = claimKey.String()
+		// The IPAddresses retained by the reclaim policy outlive their claim
+		_, retained := addressObject.Labels[ipamv1.RetainedAddressLabel]
+		if _, ok := claimsByKey[claimKey.String()]; !ok && !retained {
+			report("delete the IPAddress", deleteAddress,
+				"IPAddress %s references the missing IPClaim %s",
+				addressObject.Name, claimKey,
+			)
+		}
+
+		key := addressKey(m.allocationKey(addressObject.Spec.Claim.Name,
+			addressObject.Spec.Claim.Namespace,
+		), addressObject.Labels[ipamv1.AddressRoleLabel])
+		allocated[key] = true
+		if compacted {
+			if !rangeAddresses[addressObject.Spec.Address] {
+				report(rebuildStatus, nil,
+					"IPAddress %s of %s is missing from status.allocatedRanges",
+					addressObject.Name, key,
+				)
+			}
+			continue
+		}
+		allocation, ok := m.IPPool.Status.Allocations[key]
+		if !ok {
+			report(rebuildStatus, nil,
+				"IPAddress %s of %s is missing from status.allocations",
+				addressObject.Name, key,
+			)
+		} else if allocation != addressObject.Spec.Address {
+			report(rebuildStatus, nil,
+				"status.allocations[%s] is %s but IPAddress %s has %s",
+				key, allocation, addressObject.Name, addressObject.Spec.Address,
+			)
+		}
+	}
+
+	addresses := make([]string, 0, len(holders))
+	for address, names := range holders {
+		if len(names) > 1 {
+			addresses = append(addresses, string(address))
+		}
+	}
+	sort.Strings(addresses)
+	for _, address := range addresses {
+		names := holders[ipamv1.IPAddressStr(address)]
+		// The IPAddress referenced by its claim is kept, or else the first one
+		kept := names[0]
+		for _, name := range names {
+			if claim, ok := claimsByKey[addressClaims[name]]; ok && referencesAddress(claim, name) {
+				kept = name
+				break
+			}
+		}
+		duplicates := []*ipamv1.IPAddress{}
+		for _, name := range names {
+			if name != kept {
+				duplicates = append(duplicates, addressesByName[name])
+			}
+		}
+		report("delete all the IPAddresses but one, and the IPClaims of the deleted ones to allocate them again",
+			func(ctx context.Context) error {
+				for _, duplicate := range duplicates {
+					if err := deleteObject(m.client, ctx, duplicate); err != nil {
+						return err
+					}
+					claim, ok := claimsByKey[addressClaims[duplicate.Name]]
+					if !ok {
+						continue
+					}
+					if err := m.releaseAddressReference(ctx, claim, duplicate.Name); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			"address %s is held by the IPAddresses %v", address, names,
+		)
+	}
+
+	if compacted {
+		for _, address := range m.IPPool.Status.AllocatedAddresses() {
+			if _, ok := holders[address]; !ok {
+				report(rebuildStatus, nil,
+					"status.allocatedRanges contains %s but there is no IPAddress for it",
+					address,
+				)
+			}
+		}
+	}
+	keys := make([]string, 0, len(m.IPPool.Status.Allocations))
+	for key := range m.IPPool.Status.Allocations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !allocated[key] {
+			report(rebuildStatus, nil,
+				"status.allocations[%s] is %s but there is no IPAddress for it",
+				key, m.IPPool.Status.Allocations[key],
+			)
+		}
+	}
+
+	claimKeys := make([]string, 0, len(claimsByKey))
+	for key := range claimsByKey {
+		claimKeys = append(claimKeys, key)
+	}
+	sort.Strings(claimKeys)
+	for _, key := range claimKeys {
+		claim := claimsByKey[key]
+		if !claim.DeletionTimestamp.IsZero() {
+			continue
+		}
+		references := make(map[string]string)
+		if claim.Status.Address != nil {
+			references[""] = claim.Status.Address.Name
+		}
+		for role, reference := range claim.Status.Addresses {
+			references[role] = reference.Name
+		}
+		for _, role := range m.addressRoles(claim) {
+			name, ok := references[role]
+			if !ok {
+				continue
+			}
+			releaseReference := func(ctx context.Context) error {
+				return m.releaseAddressReference(ctx, claim, name)
+			}
+			addressClaim, ok := addressClaims[name]
+			if !ok {
+				report("remove the address reference from the IPClaim status to allocate it again",
+					releaseReference,
+					"IPClaim %s references the missing IPAddress %s", key, name,
+				)
+				continue
+			}
+			if addressClaim != key {
+				report("remove the address reference from the IPClaim status to allocate it again",
+					releaseReference,
+					"IPClaim %s references the IPAddress %s of the IPClaim %q",
+					key, name, addressClaim,
+				)
+			}
+		}
+	}
+
+	return discrepancies, nil
+}
+
+// Repair fixes the discrepancies found by Verify. It deletes the IPAddresses
+// without claim and all the holders of a duplicated address but one, and
+// removes the references to missing or duplicated IPAddresses from the IPClaim
+// status so that they are allocated again. The allocations in the pool status
+// are then rebuilt from the remaining IPAddresses. The IPAddresses of an
+// externally managed pool are imported, they are not repaired. It returns the
+// number of discrepancies repaired.
+func (m *IPPoolManager) Repair(ctx context.Context) (int, error) {
+	if m.IPPool.Spec.ExternallyManaged {
+		m.Log.Info("Externally managed IPPool, not repaired")
+		return 0, nil
+	}
+	discrepancies, err := m.Verify(ctx)
+	if err != nil {
+		return 0, err
+	}
+	repaired := 0
+	for _, discrepancy := range discrepancies {
+		if discrepancy.repair == nil {
+			continue
+		}
+		m.Log.Info("Repairing", "discrepancy", discrepancy.Message, "fix", discrepancy.Fix)
+		if err := discrepancy.repair(ctx); err != nil {
+			return repaired, errors.Wrapf(err, "failed to repair: %s", discrepancy.Message)
+		}
+		repaired++
+	}
+	return repaired, nil
+}
+
+// releaseAddressReference removes the references to the IPAddress from the
+// status of the claim, the claim is allocated its missing addresses again.
+func (m *IPPoolManager) releaseAddressReference(ctx context.Context,
+	addressClaim *ipamv1.IPClaim, name string,
+) error {
+	// Several repairs may release the references of the same claim
+	claim := &ipamv1.IPClaim{}
+	if err := m.client.Get(ctx, client.ObjectKeyFromObject(addressClaim), claim); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if !referencesAddress(claim, name) {
+		return nil
+	}
+	helper, err := patch.NewHelper(claim, m.client)
+	if err != nil {
+		return errors.Wrap(err, "failed to init patch helper")
+	}
+	claim.Status.Address = nil
+	claim.Status.Pool = nil
+	for role, reference := range claim.Status.Addresses {
+		if reference.Name == name {
+			delete(claim.Status.Addresses, role)
+		}
+	}
+	return helper.Patch(ctx, claim)
+}
+
+// referencesAddress returns true if the status of the claim references the
+// IPAddress
+func referencesAddress(addressClaim *ipamv1.IPClaim, name string) bool {
+	if addressClaim.Status.Address != nil && addressClaim.Status.Address.Name == name {
+		return true
+	}
+	for _, reference := range addressClaim.Status.Addresses {
+		if reference.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// RequestRepairs sets the repair annotation on the IPPools of the
+// discrepancies, the IPPool controller repairs them on their next
+// reconciliation.
+func RequestRepairs(ctx context.Context, cl client.Client, discrepancies []Discrepancy) error {
+	requested := make(map[client.ObjectKey]bool)
+	for _, discrepancy := range discrepancies {
+		if requested[discrepancy.poolKey] {
+			continue
+		}
+		requested[discrepancy.poolKey] = true
+		ipPool := &ipamv1.IPPool{}
+		if err := cl.Get(ctx, discrepancy.poolKey, ipPool); err != nil {
+			return err
+		}
+		original := ipPool.DeepCopy()
+		if ipPool.Annotations == nil {
+			ipPool.Annotations = make(map[string]string)
+		}
+		ipPool.Annotations[ipamv1.RepairAnnotation] = time.Now().UTC().Format(time.RFC3339)
+		if err := cl.Patch(ctx, ipPool, client.MergeFrom(original)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,324 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/klogr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Verify", func() {
+
+	type testCaseVerify struct {
+		allocations      map[string]ipamv1.IPAddressStr
+		allocatedRanges  []string
+		claims           []*ipamv1.IPClaim
+		addresses        []*ipamv1.IPAddress
+		expectedMessages []string
+	}
+
+	newClaim := func(name string, address string) *ipamv1.IPClaim {
+		claim := &ipamv1.IPClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "myns",
+			},
+			Spec: ipamv1.IPClaimSpec{
+				Pool: corev1.ObjectReference{Name: "abc"},
+			},
+		}
+		if address != "" {
+			claim.Status.Address = &corev1.ObjectReference{
+				Name:      address,
+				Namespace: "myns",
+			}
+		}
+		return claim
+	}
+
+	newAddress := func(name, claim string, address ipamv1.IPAddressStr) *ipamv1.IPAddress {
+		return &ipamv1.IPAddress{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "myns",
+			},
+			Spec: ipamv1.IPAddressSpec{
+				Pool:    corev1.ObjectReference{Name: "abc"},
+				Claim:   corev1.ObjectReference{Name: claim},
+				Address: address,
+			},
+		}
+	}
+
+	DescribeTable("Test Verify",
+		func(tc testCaseVerify) {
+			ipPool := &ipamv1.IPPool{
+				ObjectMeta: testObjectMeta,
+				Spec: ipamv1.IPPoolSpec{
+					NamePrefix: "abc",
+				},
+				Status: ipamv1.IPPoolStatus{
+					Allocations:     tc.allocations,
+					AllocatedRanges: tc.allocatedRanges,
+				},
+			}
+			objects := []client.Object{ipPool}
+			for _, claim := range tc.claims {
+				objects = append(objects, claim)
+			}
+			for _, address := range tc.addresses {
+				objects = append(objects, address)
+			}
+			c := fakeclient.NewClientBuilder().WithScheme(setupScheme()).WithObjects(objects...).Build()
+
+			discrepancies, err := VerifyPools(context.TODO(), c, "", klogr.New())
+			Expect(err).NotTo(HaveOccurred())
+			messages := []string{}
+			for _, discrepancy := range discrepancies {
+				Expect(discrepancy.Pool).To(Equal("myns/abc"))
+				Expect(discrepancy.Fix).NotTo(BeEmpty())
+				messages = append(messages, discrepancy.Message)
+			}
+			Expect(messages).To(Equal(tc.expectedMessages))
+
+			// Nothing was modified
+			pool := &ipamv1.IPPool{}
+			err = c.Get(context.TODO(), client.ObjectKeyFromObject(ipPool), pool)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pool.Status.Allocations).To(Equal(tc.allocations))
+		},
+		Entry("No allocations", testCaseVerify{
+			expectedMessages: []string{},
+		}),
+		Entry("Consistent allocations", testCaseVerify{
+			allocations: map[string]ipamv1.IPAddressStr{
+				"bcd": "192.168.0.10",
+			},
+			claims: []*ipamv1.IPClaim{
+				newClaim("bcd", "abc-192-168-0-10"),
+				newClaim("cde", ""),
+			},
+			addresses: []*ipamv1.IPAddress{
+				newAddress("abc-192-168-0-10", "bcd", "192.168.0.10"),
+			},
+			expectedMessages: []string{},
+		}),
+		Entry("Compacted allocations", testCaseVerify{
+			allocatedRanges: []string{"192.168.0.10-192.168.0.11"},
+			claims: []*ipamv1.IPClaim{
+				newClaim("bcd", "abc-192-168-0-10"),
+				newClaim("cde", "abc-192-168-0-12"),
+			},
+			addresses: []*ipamv1.IPAddress{
+				newAddress("abc-192-168-0-10", "bcd", "192.168.0.10"),
+				newAddress("abc-192-168-0-12", "cde", "192.168.0.12"),
+			},
+			expectedMessages: []string{
+				"IPAddress abc-192-168-0-12 of cde is missing from status.allocatedRanges",
+				"status.allocatedRanges contains 192.168.0.11 but there is no IPAddress for it",
+			},
+		}),
+		Entry("Discrepancies", testCaseVerify{
+			allocations: map[string]ipamv1.IPAddressStr{
+				"bcd": "192.168.0.10",
+				"cde": "192.168.0.12",
+				"efg": "192.168.0.14",
+			},
+			claims: []*ipamv1.IPClaim{
+				newClaim("bcd", "abc-192-168-0-11"),
+				newClaim("cde", "abc-192-168-0-11"),
+				newClaim("def", "abc-192-168-0-13"),
+			},
+			addresses: []*ipamv1.IPAddress{
+				newAddress("abc-192-168-0-10", "bcd", "192.168.0.10"),
+				newAddress("abc-192-168-0-11", "def", "192.168.0.11"),
+				newAddress("abc-192-168-0-12", "cde", "192.168.0.11"),
+				newAddress("abc-192-168-0-15", "", "192.168.0.15"),
+				newAddress("abc-192-168-0-16", "fgh", "192.168.0.16"),
+			},
+			expectedMessages: []string{
+				"IPAddress abc-192-168-0-11 of def is missing from status.allocations",
+				"status.allocations[cde] is 192.168.0.12 but IPAddress abc-192-168-0-12 has 192.168.0.11",
+				"IPAddress abc-192-168-0-15 has no claim",
+				"IPAddress abc-192-168-0-16 references the missing IPClaim myns/fgh",
+				"IPAddress abc-192-168-0-16 of fgh is missing from status.allocations",
+				"address 192.168.0.11 is held by the IPAddresses [abc-192-168-0-11 abc-192-168-0-12]",
+				"status.allocations[efg] is 192.168.0.14 but there is no IPAddress for it",
+				"IPClaim myns/bcd references the IPAddress abc-192-168-0-11 of the IPClaim \"myns/def\"",
+				"IPClaim myns/cde references the IPAddress abc-192-168-0-11 of the IPClaim \"myns/def\"",
+				"IPClaim myns/def references the missing IPAddress abc-192-168-0-13",
+			},
+		}),
+	)
+
+	type testCaseRepair struct {
+		externallyManaged bool
+		claims            []*ipamv1.IPClaim
+		addresses         []*ipamv1.IPAddress
+		expectedRepaired  int
+		expectedAddresses []string
+		expectedReleased  []string
+	}
+
+	DescribeTable("Test Repair",
+		func(tc testCaseRepair) {
+			ipPool := &ipamv1.IPPool{
+				ObjectMeta: testObjectMeta,
+				Spec: ipamv1.IPPoolSpec{
+					NamePrefix:        "abc",
+					ExternallyManaged: tc.externallyManaged,
+				},
+			}
+			objects := []client.Object{ipPool}
+			for _, claim := range tc.claims {
+				objects = append(objects, claim)
+			}
+			for _, address := range tc.addresses {
+				objects = append(objects, address)
+			}
+			c := fakeclient.NewClientBuilder().WithScheme(setupScheme()).WithObjects(objects...).Build()
+			ipPoolMgr, err := NewIPPoolManager(c, ipPool, klogr.New())
+			Expect(err).NotTo(HaveOccurred())
+
+			repaired, err := ipPoolMgr.Repair(context.TODO())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(repaired).To(Equal(tc.expectedRepaired))
+
+			addressObjects := ipamv1.IPAddressList{}
+			Expect(c.List(context.TODO(), &addressObjects)).To(Succeed())
+			names := []string{}
+			for _, address := range addressObjects.Items {
+				names = append(names, address.Name)
+			}
+			Expect(names).To(ConsistOf(tc.expectedAddresses))
+
+			released := []string{}
+			for _, claim := range tc.claims {
+				updated := &ipamv1.IPClaim{}
+				Expect(c.Get(context.TODO(), client.ObjectKeyFromObject(claim), updated)).To(Succeed())
+				if claim.Status.Address != nil && updated.Status.Address == nil {
+					released = append(released, claim.Name)
+				}
+			}
+			Expect(released).To(ConsistOf(tc.expectedReleased))
+		},
+		Entry("Nothing to repair", testCaseRepair{
+			claims: []*ipamv1.IPClaim{
+				newClaim("bcd", "abc-192-168-0-10"),
+			},
+			addresses: []*ipamv1.IPAddress{
+				newAddress("abc-192-168-0-10", "bcd", "192.168.0.10"),
+			},
+			expectedAddresses: []string{"abc-192-168-0-10"},
+			expectedReleased:  []string{},
+		}),
+		Entry("Discrepancies", testCaseRepair{
+			claims: []*ipamv1.IPClaim{
+				newClaim("bcd", "abc-192-168-0-11"),
+				newClaim("cde", "abc-192-168-0-11"),
+				newClaim("def", "abc-192-168-0-13"),
+			},
+			addresses: []*ipamv1.IPAddress{
+				newAddress("abc-192-168-0-10", "bcd", "192.168.0.10"),
+				newAddress("abc-192-168-0-11", "def", "192.168.0.11"),
+				newAddress("abc-192-168-0-12", "cde", "192.168.0.11"),
+				newAddress("abc-192-168-0-15", "", "192.168.0.15"),
+				newAddress("abc-192-168-0-16", "fgh", "192.168.0.16"),
+			},
+			expectedRepaired:  6,
+			expectedAddresses: []string{"abc-192-168-0-10", "abc-192-168-0-11"},
+			expectedReleased:  []string{"bcd", "cde", "def"},
+		}),
+		Entry("Duplicated address referenced by its claim", testCaseRepair{
+			claims: []*ipamv1.IPClaim{
+				newClaim("bcd", "abc-192-168-0-12"),
+				newClaim("cde", "abc-192-168-0-11"),
+			},
+			addresses: []*ipamv1.IPAddress{
+				newAddress("abc-192-168-0-11", "cde", "192.168.0.11"),
+				newAddress("abc-192-168-0-12", "bcd", "192.168.0.11"),
+			},
+			expectedRepaired:  1,
+			expectedAddresses: []string{"abc-192-168-0-11"},
+			expectedReleased:  []string{"bcd"},
+		}),
+		Entry("Externally managed pool", testCaseRepair{
+			externallyManaged: true,
+			addresses: []*ipamv1.IPAddress{
+				newAddress("abc-192-168-0-15", "", "192.168.0.15"),
+			},
+			expectedAddresses: []string{"abc-192-168-0-15"},
+			expectedReleased:  []string{},
+		}),
+	)
+
+	It("Repairs the pools annotated for repair", func() {
+		ipPool := &ipamv1.IPPool{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "abc",
+				Namespace:   "myns",
+				Annotations: map[string]string{ipamv1.RepairAnnotation: ""},
+			},
+			Spec: ipamv1.IPPoolSpec{
+				NamePrefix: "abc",
+			},
+		}
+		c := fakeclient.NewClientBuilder().WithScheme(setupScheme()).WithObjects(
+			ipPool, newAddress("abc-192-168-0-15", "", "192.168.0.15"),
+		).Build()
+		ipPoolMgr, err := NewIPPoolManager(c, ipPool, klogr.New())
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = ipPoolMgr.UpdateAddresses(context.TODO())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ipPool.Annotations).NotTo(HaveKey(ipamv1.RepairAnnotation))
+		addressObjects := ipamv1.IPAddressList{}
+		Expect(c.List(context.TODO(), &addressObjects)).To(Succeed())
+		Expect(addressObjects.Items).To(BeEmpty())
+	})
+
+	It("Requests the repair of the pools with discrepancies", func() {
+		ipPool := &ipamv1.IPPool{
+			ObjectMeta: testObjectMeta,
+			Spec: ipamv1.IPPoolSpec{
+				NamePrefix: "abc",
+			},
+		}
+		c := fakeclient.NewClientBuilder().WithScheme(setupScheme()).WithObjects(
+			ipPool, newAddress("abc-192-168-0-15", "", "192.168.0.15"),
+		).Build()
+
+		discrepancies, err := VerifyPools(context.TODO(), c, "", klogr.New())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(discrepancies).To(HaveLen(1))
+		Expect(RequestRepairs(context.TODO(), c, discrepancies)).To(Succeed())
+
+		pool := &ipamv1.IPPool{}
+		Expect(c.Get(context.TODO(), client.ObjectKeyFromObject(ipPool), pool)).To(Succeed())
+		Expect(pool.Annotations).To(HaveKey(ipamv1.RepairAnnotation))
+	})
+})
@@ -20,19 +20,34 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"math/rand"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	ipamv1beta1 "github.com/metal3-io/ip-address-manager/api/v1beta1"
 	"github.com/metal3-io/ip-address-manager/controllers"
+	"github.com/metal3-io/ip-address-manager/feature"
 	"github.com/metal3-io/ip-address-manager/ipam"
+	"golang.org/x/time/rate"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
 	"k8s.io/klog/v2/klogr"
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha4"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/yaml"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -45,24 +60,74 @@ var (
 	webhookPort          int
 	healthAddr           string
 	watchNamespace       string
+	watchNamespaces      string
+	configFile           string
+	configValues         map[string]string
+	commandLineFlags     map[string]bool
 	webhookCertDir       string
 	watchFilterValue     string
+	enableMDClaims       bool
+	staleClaimThreshold  time.Duration
+	requeueInterval      time.Duration
+	conflictScope        string
+	compactionThreshold  int
+	shardingThreshold    int
+	allocationBatchSize  int
+	serverSideApply      bool
+	claimDebounce        time.Duration
+	ippoolConcurrency    int
+	ipclaimConcurrency   int
+	defaultConcurrency   int
+	rateLimiterBaseDelay time.Duration
+	rateLimiterMaxDelay  time.Duration
+	rateLimiterQPS       float64
+	rateLimiterBurst     int
+	netBoxTokenFile      string
+	noSecrets            bool
+	allocationAPIAddr    string
+	allocationAPIToken   string
+	allocationAPICert    string
+	allocationAPIKey     string
+	allocationNamespaces string
+	allocationInsecure   bool
+	profilerAddr         string
 )
 
 func init() {
+	// Seed the Random allocation strategy of the IPPools
+	rand.Seed(time.Now().UnixNano())
 	_ = scheme.AddToScheme(myscheme)
 	_ = ipamv1.AddToScheme(myscheme)
+	// The v1beta1 types enable the conversion webhook of the v1alpha1 ones
+	_ = ipamv1beta1.AddToScheme(myscheme)
 	_ = clusterv1.AddToScheme(myscheme)
 	// +kubebuilder:scaffold:scheme
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "verify":
+			os.Exit(verify(os.Args[2:]))
+		case "loadtest":
+			os.Exit(loadTest(os.Args[2:]))
+		case "explain":
+			os.Exit(explain(os.Args[2:]))
+		case "convert":
+			os.Exit(convert(os.Args[2:]))
+		}
+	}
+
 	klog.InitFlags(nil)
+	flag.StringVar(&configFile, "config", "",
+		"The configuration file of the controller, a YAML map of the names of the flags to their values, such as a mounted ConfigMap. The flags given on the command line take precedence. The changes of requeue-interval, stale-claim-threshold and v are applied without restarting the controller.")
 	flag.StringVar(&metricsBindAddr, "metrics-bind-addr", "localhost:8080", "The address the metric endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. Enabling this will ensure there is only one active controller manager.")
 	flag.StringVar(&watchNamespace, "namespace", "",
 		"Namespace that the controller watches to reconcile CAPM3 objects. If unspecified, the controller watches for CAPM3 objects across all namespaces.")
+	flag.StringVar(&watchNamespaces, "watch-namespaces", "",
+		"Comma-separated list of the namespaces the controller watches, with caches scoped to those namespaces. The controllers of the cluster-scoped ClusterIPPools, ControllerConfigs and IPOverlapReports are then disabled, so that the controller only needs permissions in those namespaces. Exclusive with --namespace.")
 	flag.DurationVar(&syncPeriod, "sync-period", 10*time.Minute,
 		"The minimum interval at which watched resources are reconciled (e.g. 15m)")
 	flag.IntVar(&webhookPort, "webhook-port", 9443,
@@ -77,15 +142,104 @@ func main() {
 		&watchFilterValue,
 		"watch-filter",
 		"",
-		fmt.Sprintf("Label value that the controller watches to reconcile cluster-api objects. Label key is always %s. If unspecified, the controller watches for all cluster-api objects.", clusterv1.WatchLabel),
+		fmt.Sprintf("Label value that the controller watches to reconcile cluster-api objects. Label key is always %s. Several values can be given as a comma-separated list, or a label selector expression such as \"%s notin (canary)\" can be given instead. If unspecified, the controller watches for all cluster-api objects.", clusterv1.WatchLabel, clusterv1.WatchLabel),
 	)
+	flag.BoolVar(&enableMDClaims, "enable-machinedeployment-claims", false,
+		fmt.Sprintf("Enable the creation of IPClaimSets for the MachineDeployments with the %s annotation.", ipamv1.ClaimPoolAnnotation))
+	flag.BoolVar(&ipamv1.ValidateIPClaimPools, "validate-claim-pools", false,
+		"Reject the IPClaims whose pool does not exist, is being deleted or is exhausted. The IPClaims must then be created after their pools.")
+	flag.DurationVar(&requeueInterval, "requeue-interval", ipam.DefaultRequeueAfter,
+		"The interval after which the objects whose reconciliation is paused or blocked are reconciled again (e.g. 30s), unless set by the requeueInterval of the ControllerConfig.")
+	flag.DurationVar(&staleClaimThreshold, "stale-claim-threshold", 15*time.Minute,
+		"The duration after which an IPClaim without an address is reported as stale (e.g. 15m). Zero disables the reporting.")
+	flag.StringVar(&conflictScope, "conflict-scope", string(ipam.ConflictScopeNamespace),
+		"The pools each IPPool is compared with to detect overlapping ranges and addresses allocated twice: Namespace, the other pools of its namespace, or Cluster, all the pools.")
+	flag.IntVar(&compactionThreshold, "compaction-threshold", 10000,
+		"The number of allocations above which the allocations of an IPPool are compacted in its status, like with compactAllocations, to keep it under the size limit of the objects. Zero disables it.")
+	flag.IntVar(&shardingThreshold, "sharding-threshold", 20000,
+		"The number of allocations above which the allocations of an IPPool are recorded in IPPoolAllocationShard objects instead of its status. Zero disables it.")
+	flag.IntVar(&allocationBatchSize, "allocation-batch-size", 20,
+		"The number of pending IPClaims of an IPPool allocated together when reconciling one of them, persisted with a single patch of the IPPool. Zero or one disables it.")
+	flag.BoolVar(&serverSideApply, "server-side-apply", true,
+		"Write the labels and annotations the controller sets on the IPAddresses with a server-side apply, leaving the other ones to their owners.")
+	flag.DurationVar(&claimDebounce, "claim-debounce", time.Second,
+		"The window during which the events of the IPClaims of a pool are aggregated into a single reconcile of the pool (e.g. 1s). Zero reconciles the pool for each event.")
+	flag.IntVar(&ippoolConcurrency, "ippool-concurrency", 1,
+		"Number of IPPools and ClusterIPPools to process simultaneously.")
+	flag.IntVar(&ipclaimConcurrency, "ipclaim-concurrency", 1,
+		"Number of IPClaims to process simultaneously.")
+	flag.IntVar(&defaultConcurrency, "concurrency", 1,
+		"Number of objects the other controllers, of the IPClaimSets, IPPoolClaims, ControllerConfigs, IPOverlapReports and MachineDeployments, each process simultaneously.")
+	flag.DurationVar(&rateLimiterBaseDelay, "rate-limiter-base-delay", 5*time.Millisecond,
+		"The delay before retrying the failed reconcile of an object, doubled at each new failure of the object.")
+	flag.DurationVar(&rateLimiterMaxDelay, "rate-limiter-max-delay", 1000*time.Second,
+		"The maximum delay before retrying the failed reconcile of an object.")
+	flag.Float64Var(&rateLimiterQPS, "rate-limiter-qps", 10,
+		"The number of retries of failed reconciles per second of each controller, all objects together.")
+	flag.IntVar(&rateLimiterBurst, "rate-limiter-burst", 100,
+		"The bucket size of the rate limiter of the retries of each controller, the number of retries allowed at once above --rate-limiter-qps.")
+	flag.Func("feature-gates", "A set of key=value pairs that describe feature gates for alpha/experimental features. "+
+		"Options are:\n"+strings.Join(feature.MutableGates.KnownFeatures(), "\n"), feature.MutableGates.Set)
 	flag.StringVar(&healthAddr, "health-addr", ":9440",
 		"The address the health endpoint binds to.")
+	flag.StringVar(&netBoxTokenFile, "netbox-token-file", "",
+		"The file containing the API token of NetBox, for the IPPools with a NetBox backend. It is read again at each reconciliation.")
+	flag.BoolVar(&noSecrets, "no-secrets", false,
+		"Never read the Secrets, disabling the IPPool backends whose credentials are read from Secrets, such as Infoblox. The controller can then be deployed without access to the Secrets, with config/rbac-nosecrets.")
+	flag.StringVar(&allocationAPIAddr, "allocation-api-addr", "",
+		"The address the allocation API binds to, letting the systems outside of the cluster allocate addresses. If unspecified, the API is disabled.")
+	flag.StringVar(&allocationAPIToken, "allocation-api-token-file", "",
+		"The file containing the bearer token of the clients of the allocation API, required with --allocation-api-addr.")
+	flag.StringVar(&allocationAPICert, "allocation-api-tls-cert-file", "",
+		"The TLS certificate of the allocation API, required unless --allocation-api-insecure is set.")
+	flag.StringVar(&allocationAPIKey, "allocation-api-tls-key-file", "",
+		"The TLS key of the allocation API.")
+	flag.StringVar(&allocationNamespaces, "allocation-api-namespaces", "",
+		"The comma-separated list of namespaces the clients of the allocation API can allocate in, required with --allocation-api-addr.")
+	flag.BoolVar(&allocationInsecure, "allocation-api-insecure", false,
+		"Serve the allocation API over plain HTTP when no TLS certificate is given, sending the bearer token in clear.")
+	flag.StringVar(&profilerAddr, "profiler-address", "",
+		"The loopback address the pprof endpoints bind to, e.g. localhost:6060. If unspecified, the profiler is disabled.")
 	flag.Parse()
 
 	ctrl.SetLogger(klogr.New())
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+	if configFile != "" {
+		var err error
+		configValues, err = ipam.ReadConfigFile(configFile)
+		if err != nil {
+			setupLog.Error(err, "unable to read the configuration file")
+			os.Exit(1)
+		}
+		commandLineFlags = ipam.CommandLineFlags(flag.CommandLine)
+		if err := ipam.ApplyConfig(flag.CommandLine, configValues, commandLineFlags); err != nil {
+			setupLog.Error(err, "unable to apply the configuration file")
+			os.Exit(1)
+		}
+	}
+
+	if conflictScope != string(ipam.ConflictScopeNamespace) && conflictScope != string(ipam.ConflictScopeCluster) {
+		setupLog.Error(fmt.Errorf("invalid conflict scope %q", conflictScope), "unable to start manager")
+		os.Exit(1)
+	}
+	if rateLimiterBaseDelay <= 0 || rateLimiterMaxDelay < rateLimiterBaseDelay ||
+		rateLimiterQPS <= 0 || rateLimiterBurst <= 0 {
+		setupLog.Error(fmt.Errorf("invalid rate limiter: the delays, qps and burst must be positive, the max delay at least the base delay"),
+			"unable to start manager")
+		os.Exit(1)
+	}
+
+	watchFilter, err := controllers.WatchFilterSelector(watchFilterValue)
+	if err != nil {
+		setupLog.Error(err, "invalid watch filter", "watchFilter", watchFilterValue)
+		os.Exit(1)
+	}
+	namespaces := splitNamespaces(watchNamespaces)
+	if watchNamespace != "" && len(namespaces) != 0 {
+		setupLog.Error(fmt.Errorf("--namespace and --watch-namespaces are exclusive"), "unable to start manager")
+		os.Exit(1)
+	}
+	options := ctrl.Options{
 		Scheme:                 myscheme,
 		MetricsBindAddress:     metricsBindAddr,
 		LeaderElection:         enableLeaderElection,
@@ -95,7 +249,19 @@ func main() {
 		HealthProbeBindAddress: healthAddr,
 		Namespace:              watchNamespace,
 		CertDir:                webhookCertDir,
-	})
+		NewCache:               newCache(namespaces, watchFilter),
+	}
+	if len(namespaces) != 0 {
+		// The cluster-scoped objects are read from the API server, failing
+		// without the permissions instead of waiting for the cache to sync
+		options.ClientDisableCacheFor = []client.Object{
+			&ipamv1.ClusterIPPool{}, &ipamv1.ControllerConfig{}, &ipamv1.IPOverlapReport{},
+		}
+		if len(namespaces) == 1 {
+			options.Namespace = namespaces[0]
+		}
+	}
+	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), options)
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
@@ -104,8 +270,10 @@ func main() {
 	ctx := ctrl.SetupSignalHandler()
 
 	setupChecks(mgr)
-	setupReconcilers(ctx, mgr)
+	setupReconcilers(ctx, mgr, len(namespaces) == 0)
 	setupWebhooks(mgr)
+	setupAllocationAPI(mgr)
+	setupProfiler(mgr)
 
 	// +kubebuilder:scaffold:builder
 	setupLog.Info("starting manager")
@@ -115,6 +283,80 @@ func main() {
 	}
 }
 
+// newCache returns the function creating the cache of the manager. The
+// managedFields and the last-applied-configuration annotation of the cached
+// IPClaims and IPAddresses, whose count grows with the allocated addresses,
+// are stripped, the controller never reads them. With a watch filter, the
+// cluster-api objects it does not select are not cached, their events are
+// filtered out anyway. The IPAM objects are always all cached, since an
+// IPAddress missing from the cache could lead to its address being
+// allocated twice. With several watched namespaces, the cache is made of a
+// cache per namespace.
+func newCache(namespaces []string, selector labels.Selector) cache.NewCacheFunc {
+	newFunc := cache.New
+	if len(namespaces) > 1 {
+		newFunc = cache.MultiNamespacedCacheBuilder(namespaces)
+	}
+	return func(config *rest.Config, opts cache.Options) (cache.Cache, error) {
+		opts.TransformByObject = cache.TransformByObject{
+			&ipamv1.IPClaim{}:   stripCachedMetadata,
+			&ipamv1.IPAddress{}: stripCachedMetadata,
+		}
+		if !selector.Empty() {
+			opts.SelectorsByObject = cache.SelectorsByObject{
+				&clusterv1.Cluster{}:           {Label: selector},
+				&clusterv1.MachineDeployment{}: {Label: selector},
+			}
+		}
+		return newFunc(config, opts)
+	}
+}
+
+// stripCachedMetadata is the cache transform function removing the
+// managedFields and the last-applied-configuration annotation of an object
+// before it is stored. The tombstones of the deleted objects are left as is.
+func stripCachedMetadata(obj interface{}) (interface{}, error) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return obj, nil
+	}
+	accessor.SetManagedFields(nil)
+	if annotations := accessor.GetAnnotations(); annotations != nil {
+		delete(annotations, corev1.LastAppliedConfigAnnotation)
+		accessor.SetAnnotations(annotations)
+	}
+	return obj, nil
+}
+
+// splitNamespaces returns the namespaces of the comma-separated list, without
+// duplicates
+func splitNamespaces(list string) []string {
+	namespaces := []string{}
+	seen := map[string]bool{}
+	for _, namespace := range strings.Split(list, ",") {
+		namespace = strings.TrimSpace(namespace)
+		if namespace == "" || seen[namespace] {
+			continue
+		}
+		seen[namespace] = true
+		namespaces = append(namespaces, namespace)
+	}
+	return namespaces
+}
+
+// logVerbosity returns the log verbosity given by the -v flag of klog
+func logVerbosity() int32 {
+	verbosityFlag := flag.Lookup("v")
+	if verbosityFlag == nil {
+		return 0
+	}
+	verbosity, err := strconv.ParseInt(verbosityFlag.Value.String(), 10, 32)
+	if err != nil {
+		return 0
+	}
+	return int32(verbosity)
+}
+
 func setupChecks(mgr ctrl.Manager) {
 	if err := mgr.AddReadyzCheck("webhook", mgr.GetWebhookServer().StartedChecker()); err != nil {
 		setupLog.Error(err, "unable to create ready check")
@@ -127,17 +369,149 @@ func setupChecks(mgr ctrl.Manager) {
 	}
 }
 
-func setupReconcilers(ctx context.Context, mgr ctrl.Manager) {
+// setupReconcilers sets the controllers up. The controllers of the
+// cluster-scoped objects are only set up if clusterScoped is true.
+func setupReconcilers(ctx context.Context, mgr ctrl.Manager, clusterScoped bool) {
+
+	settings := ipam.NewSettings(requeueInterval, staleClaimThreshold,
+		logVerbosity(),
+	)
+	if clusterScoped {
+		if err := (&controllers.ControllerConfigReconciler{
+			Client:   mgr.GetClient(),
+			Log:      ctrl.Log.WithName("controllers").WithName("ControllerConfig"),
+			Settings: settings,
+		}).SetupWithManager(ctx, mgr, controllerOptions(defaultConcurrency)); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "ControllerConfigReconciler")
+			os.Exit(1)
+		}
+	}
+
+	if configFile != "" {
+		watcher := ipam.NewConfigFileWatcher(configFile, flag.CommandLine, configValues,
+			commandLineFlags, settings, ctrl.Log.WithName("config-file"),
+		)
+		if err := mgr.Add(watcher); err != nil {
+			setupLog.Error(err, "unable to add the configuration file watcher")
+			os.Exit(1)
+		}
+	}
 
+	poolManagerFactory := ipam.NewManagerFactory(mgr.GetClient())
+	poolManagerFactory.Settings = settings
+	poolManagerFactory.Recorder = mgr.GetEventRecorderFor("ippool-controller")
+	poolManagerFactory.ConflictScope = ipam.ConflictScope(conflictScope)
+	poolManagerFactory.CompactionThreshold = compactionThreshold
+	poolManagerFactory.ShardingThreshold = shardingThreshold
+	if err := ipam.RegisterClaimPoolIndex(ctx, mgr.GetFieldIndexer()); err != nil {
+		setupLog.Error(err, "unable to register the field index", "index", ipam.ClaimPoolIndex)
+		os.Exit(1)
+	}
+	poolManagerFactory.IndexedClaims = true
+	poolManagerFactory.AllocationBatchSize = allocationBatchSize
+	poolManagerFactory.ServerSideApply = serverSideApply
+	poolManagerFactory.BackendCredentials = &ipam.BackendCredentials{
+		NetBoxTokenFile: netBoxTokenFile,
+	}
+	if !noSecrets {
+		poolManagerFactory.BackendCredentials.SecretReader = mgr.GetAPIReader()
+	}
 	if err := (&controllers.IPPoolReconciler{
 		Client:           mgr.GetClient(),
-		ManagerFactory:   ipam.NewManagerFactory(mgr.GetClient()),
+		ManagerFactory:   poolManagerFactory,
 		Log:              ctrl.Log.WithName("controllers").WithName("IPPool"),
 		WatchFilterValue: watchFilterValue,
-	}).SetupWithManager(ctx, mgr); err != nil {
+		Settings:         settings,
+		ClaimDebounce:    claimDebounce,
+	}).SetupWithManager(ctx, mgr, controllerOptions(ippoolConcurrency)); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "IPPoolReconciler")
 		os.Exit(1)
 	}
+
+	if clusterScoped {
+		if err := (&controllers.ClusterIPPoolReconciler{
+			IPPoolReconciler: controllers.IPPoolReconciler{
+				Client:           mgr.GetClient(),
+				ManagerFactory:   poolManagerFactory,
+				Log:              ctrl.Log.WithName("controllers").WithName("ClusterIPPool"),
+				WatchFilterValue: watchFilterValue,
+				Settings:         settings,
+				ClaimDebounce:    claimDebounce,
+			},
+		}).SetupWithManager(ctx, mgr, controllerOptions(ippoolConcurrency)); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "ClusterIPPoolReconciler")
+			os.Exit(1)
+		}
+	}
+
+	if err := (&controllers.IPClaimReconciler{
+		Client:           mgr.GetClient(),
+		ManagerFactory:   poolManagerFactory,
+		Log:              ctrl.Log.WithName("controllers").WithName("IPClaim"),
+		WatchFilterValue: watchFilterValue,
+		Settings:         settings,
+	}).SetupWithManager(ctx, mgr, controllerOptions(ipclaimConcurrency)); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "IPClaimReconciler")
+		os.Exit(1)
+	}
+
+	if err := (&controllers.IPClaimSetReconciler{
+		Client:           mgr.GetClient(),
+		ManagerFactory:   ipam.NewManagerFactory(mgr.GetClient()),
+		Log:              ctrl.Log.WithName("controllers").WithName("IPClaimSet"),
+		WatchFilterValue: watchFilterValue,
+		Settings:         settings,
+	}).SetupWithManager(ctx, mgr, controllerOptions(defaultConcurrency)); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "IPClaimSetReconciler")
+		os.Exit(1)
+	}
+
+	if err := (&controllers.IPPoolClaimReconciler{
+		Client:           mgr.GetClient(),
+		ManagerFactory:   ipam.NewManagerFactory(mgr.GetClient()),
+		Log:              ctrl.Log.WithName("controllers").WithName("IPPoolClaim"),
+		WatchFilterValue: watchFilterValue,
+		Settings:         settings,
+	}).SetupWithManager(ctx, mgr, controllerOptions(defaultConcurrency)); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "IPPoolClaimReconciler")
+		os.Exit(1)
+	}
+
+	if clusterScoped {
+		if err := (&controllers.IPOverlapReportReconciler{
+			Client: mgr.GetClient(),
+			Log:    ctrl.Log.WithName("controllers").WithName("IPOverlapReport"),
+		}).SetupWithManager(ctx, mgr, controllerOptions(defaultConcurrency)); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "IPOverlapReportReconciler")
+			os.Exit(1)
+		}
+	}
+
+	if enableMDClaims {
+		if err := (&controllers.MachineDeploymentReconciler{
+			Client:           mgr.GetClient(),
+			Log:              ctrl.Log.WithName("controllers").WithName("MachineDeployment"),
+			WatchFilterValue: watchFilterValue,
+			Settings:         settings,
+		}).SetupWithManager(ctx, mgr, controllerOptions(defaultConcurrency)); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "MachineDeploymentReconciler")
+			os.Exit(1)
+		}
+	}
+}
+
+// controllerOptions returns the options of a controller reconciling up to
+// concurrency objects at the same time. Each controller gets its own rate
+// limiter, retrying the failed reconciles of an object with an exponential
+// backoff and limiting the overall rate of the retries.
+func controllerOptions(concurrency int) controller.Options {
+	return controller.Options{
+		MaxConcurrentReconciles: concurrency,
+		RateLimiter: workqueue.NewMaxOfRateLimiter(
+			workqueue.NewItemExponentialFailureRateLimiter(rateLimiterBaseDelay, rateLimiterMaxDelay),
+			&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(rateLimiterQPS), rateLimiterBurst)},
+		),
+	}
 }
 
 func setupWebhooks(mgr ctrl.Manager) {
@@ -146,6 +520,11 @@ func setupWebhooks(mgr ctrl.Manager) {
 		os.Exit(1)
 	}
 
+	if err := (&ipamv1.ClusterIPPool{}).SetupWebhookWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create webhook", "webhook", "ClusterIPPool")
+		os.Exit(1)
+	}
+
 	if err := (&ipamv1.IPAddress{}).SetupWebhookWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create webhook", "webhook", "IPAddress")
 		os.Exit(1)
@@ -155,4 +534,266 @@ func setupWebhooks(mgr ctrl.Manager) {
 		setupLog.Error(err, "unable to create webhook", "webhook", "IPClaim")
 		os.Exit(1)
 	}
+
+	if err := (&ipamv1.IPClaimSet{}).SetupWebhookWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create webhook", "webhook", "IPClaimSet")
+		os.Exit(1)
+	}
+
+	if err := (&ipamv1.IPPoolClaim{}).SetupWebhookWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create webhook", "webhook", "IPPoolClaim")
+		os.Exit(1)
+	}
+
+	if err := (&ipamv1.IPAMConfig{}).SetupWebhookWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create webhook", "webhook", "IPAMConfig")
+		os.Exit(1)
+	}
+
+	if err := (&ipamv1.ControllerConfig{}).SetupWebhookWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create webhook", "webhook", "ControllerConfig")
+		os.Exit(1)
+	}
+
+	if err := (&ipamv1.IPOverlapReport{}).SetupWebhookWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create webhook", "webhook", "IPOverlapReport")
+		os.Exit(1)
+	}
+}
+
+// setupAllocationAPI adds the allocation API to the manager if it is enabled
+func setupAllocationAPI(mgr ctrl.Manager) {
+	if allocationAPIAddr == "" {
+		return
+	}
+	if allocationAPIToken == "" {
+		setupLog.Error(nil, "the allocation API requires --allocation-api-token-file")
+		os.Exit(1)
+	}
+	if (allocationAPICert == "") != (allocationAPIKey == "") {
+		setupLog.Error(nil, "the allocation API requires both a TLS certificate and key")
+		os.Exit(1)
+	}
+	if allocationAPICert == "" && !allocationInsecure {
+		setupLog.Error(nil, "the allocation API requires a TLS certificate and key, or --allocation-api-insecure")
+		os.Exit(1)
+	}
+	namespaces := splitNamespaces(allocationNamespaces)
+	if len(namespaces) == 0 {
+		setupLog.Error(nil, "the allocation API requires --allocation-api-namespaces")
+		os.Exit(1)
+	}
+	server := ipam.NewAllocationServer(mgr.GetClient(), allocationAPIAddr, allocationAPIToken,
+		namespaces, ctrl.Log.WithName("allocation-api"),
+	)
+	server.CertFile = allocationAPICert
+	server.KeyFile = allocationAPIKey
+	server.Insecure = allocationInsecure
+	if err := mgr.Add(server); err != nil {
+		setupLog.Error(err, "unable to add the allocation API")
+		os.Exit(1)
+	}
+}
+
+// setupProfiler adds the pprof endpoints to the manager if they are enabled
+func setupProfiler(mgr ctrl.Manager) {
+	if profilerAddr == "" {
+		return
+	}
+	server, err := ipam.NewProfilerServer(profilerAddr, ctrl.Log.WithName("profiler"))
+	if err != nil {
+		setupLog.Error(err, "invalid --profiler-address")
+		os.Exit(1)
+	}
+	if err := mgr.Add(server); err != nil {
+		setupLog.Error(err, "unable to add the profiler")
+		os.Exit(1)
+	}
+}
+
+// verify runs the verify command, that prints the discrepancies between the
+// status of the IPPools and the IPClaim and IPAddress objects. Unless
+// requested to repair them, it does not modify anything. With an interval, it
+// runs periodically until stopped. It returns the exit code of the command.
+func verify(args []string) int {
+	var namespace string
+	var interval time.Duration
+	var repair bool
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	fs.StringVar(&namespace, "namespace", "",
+		"Namespace of the IPPools to verify. If unspecified, the IPPools of all namespaces are verified.")
+	fs.DurationVar(&interval, "interval", 0,
+		"Interval at which the IPPools are verified (e.g. 1h). If unspecified, the IPPools are verified once.")
+	fs.BoolVar(&repair, "repair", false,
+		"Annotate the IPPools with discrepancies for the controller to repair them.")
+	_ = parseCommandFlags(fs, args)
+
+	log := klogr.New().WithName("verify")
+	cl, err := client.New(ctrl.GetConfigOrDie(), client.Options{Scheme: myscheme})
+	if err != nil {
+		log.Error(err, "unable to create client")
+		return 1
+	}
+
+	ctx := ctrl.SetupSignalHandler()
+	for {
+		discrepancies, err := ipam.VerifyPools(ctx, cl, namespace, log)
+		if err != nil {
+			log.Error(err, "unable to verify the IPPools")
+			if interval == 0 {
+				return 1
+			}
+		}
+		for _, discrepancy := range discrepancies {
+			fmt.Println(discrepancy)
+		}
+		if repair {
+			if err := ipam.RequestRepairs(ctx, cl, discrepancies); err != nil {
+				log.Error(err, "unable to request the repair of the IPPools")
+				if interval == 0 {
+					return 1
+				}
+			}
+		}
+		if interval == 0 {
+			if len(discrepancies) != 0 {
+				return 1
+			}
+			return 0
+		}
+		log.Info("verified the IPPools", "discrepancies", len(discrepancies))
+		select {
+		case <-ctx.Done():
+			return 0
+		case <-time.After(interval):
+		}
+	}
+}
+
+// loadTest runs the loadtest command, that creates IPClaims for a sandbox
+// IPPool at the given rate and reports the time they took to get an address
+// and the error rate. It returns the exit code of the command.
+func loadTest(args []string) int {
+	opts := ipam.LoadTestOptions{}
+	fs := flag.NewFlagSet("loadtest", flag.ExitOnError)
+	fs.StringVar(&opts.Pool.Namespace, "namespace", "default",
+		"Namespace of the IPPool and of the IPClaims created.")
+	fs.StringVar(&opts.Pool.Name, "pool", "",
+		"Name of the IPPool to create the IPClaims for. It should be a sandbox pool, the IPClaims compete with the real ones for its addresses.")
+	fs.IntVar(&opts.Claims, "claims", 100,
+		"Number of IPClaims to create.")
+	fs.Float64Var(&opts.Rate, "rate", 10,
+		"Number of IPClaims created per second.")
+	fs.DurationVar(&opts.Timeout, "timeout", time.Minute,
+		"Time an IPClaim is given to get an address before it is counted as an error.")
+	fs.DurationVar(&opts.PollInterval, "poll-interval", 100*time.Millisecond,
+		"Interval at which the IPClaims are checked for an address.")
+	_ = parseCommandFlags(fs, args)
+
+	log := klogr.New().WithName("loadtest")
+	if opts.Pool.Name == "" {
+		log.Error(nil, "the pool is required")
+		return 1
+	}
+	cl, err := client.New(ctrl.GetConfigOrDie(), client.Options{Scheme: myscheme})
+	if err != nil {
+		log.Error(err, "unable to create client")
+		return 1
+	}
+
+	report, err := ipam.RunLoadTest(ctrl.SetupSignalHandler(), cl, opts, log)
+	fmt.Println(report)
+	if err != nil {
+		log.Error(err, "unable to run the load test")
+		return 1
+	}
+	return 0
+}
+
+// explain runs the explain command, that describes the allocation of the
+// addresses of an IPClaim without modifying anything. It returns the exit code
+// of the command.
+func explain(args []string) int {
+	var namespace string
+	fs := flag.NewFlagSet("explain", flag.ExitOnError)
+	fs.StringVar(&namespace, "namespace", "default",
+		"Namespace of the IPClaim.")
+	_ = parseCommandFlags(fs, args)
+
+	log := klogr.New().WithName("explain")
+	if fs.NArg() != 1 {
+		log.Error(nil, "the name of the IPClaim is required")
+		return 1
+	}
+	cl, err := client.New(ctrl.GetConfigOrDie(), client.Options{Scheme: myscheme})
+	if err != nil {
+		log.Error(err, "unable to create client")
+		return 1
+	}
+
+	key := client.ObjectKey{Name: fs.Arg(0), Namespace: namespace}
+	steps, err := ipam.ExplainAllocation(ctrl.SetupSignalHandler(), cl, key, log)
+	if err != nil {
+		log.Error(err, "unable to explain the allocation")
+		return 1
+	}
+	for _, step := range steps {
+		fmt.Println(step)
+	}
+	return 0
+}
+
+// convert runs the convert command, that prints the manifests converting a
+// pool with its addresses and claims between this provider and the CAPI
+// in-cluster IPAM provider, without modifying anything. It returns the exit
+// code of the command.
+func convert(args []string) int {
+	var namespace, pool, target string
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	fs.StringVar(&namespace, "namespace", "default",
+		"Namespace of the pool.")
+	fs.StringVar(&pool, "pool", "",
+		"Name of the pool to convert.")
+	fs.StringVar(&target, "to", ipam.ConvertToInCluster,
+		"Provider to convert the pool to, capi for an InClusterIPPool or metal3 for an IPPool.")
+	_ = parseCommandFlags(fs, args)
+
+	log := klogr.New().WithName("convert")
+	if pool == "" {
+		log.Error(nil, "the name of the pool is required")
+		return 1
+	}
+	cl, err := client.New(ctrl.GetConfigOrDie(), client.Options{Scheme: myscheme})
+	if err != nil {
+		log.Error(err, "unable to create client")
+		return 1
+	}
+
+	key := client.ObjectKey{Name: pool, Namespace: namespace}
+	objects, warnings, err := ipam.ConvertPool(ctrl.SetupSignalHandler(), cl, key, target, log)
+	for _, warning := range warnings {
+		log.Info("Warning: " + warning)
+	}
+	if err != nil {
+		log.Error(err, "unable to convert the pool")
+		return 1
+	}
+	for _, object := range objects {
+		manifest, err := yaml.Marshal(object)
+		if err != nil {
+			log.Error(err, "unable to render the manifest")
+			return 1
+		}
+		fmt.Printf("---\n%s", manifest)
+	}
+	return 0
+}
+
+// parseCommandFlags parses the flags of a command, sharing the kubeconfig flag
+// registered by controller-runtime
+func parseCommandFlags(fs *flag.FlagSet, args []string) error {
+	if kubeconfig := flag.Lookup("kubeconfig"); kubeconfig != nil {
+		fs.Var(kubeconfig.Value, kubeconfig.Name, kubeconfig.Usage)
+	}
+	return fs.Parse(args)
 }
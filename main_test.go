@@ -0,0 +1,108 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+
+	ipamv1 "github.com/metal3-io/ip-address-manager/api/v1alpha1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("Manager cache", func() {
+
+	type testCaseNewCache struct {
+		namespace  string
+		namespaces []string
+		selector   labels.Selector
+	}
+
+	DescribeTable("Test newCache",
+		func(tc testCaseNewCache) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			Expect(k8sClient.Create(ctx, &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{Name: tc.namespace},
+			})).To(Succeed())
+			objectMeta := metav1.ObjectMeta{
+				Name:      "abc",
+				Namespace: tc.namespace,
+				Annotations: map[string]string{
+					corev1.LastAppliedConfigAnnotation: "{}",
+					"foo":                              "bar",
+				},
+			}
+			objects := []client.Object{
+				&ipamv1.IPClaim{
+					ObjectMeta: objectMeta,
+					Spec: ipamv1.IPClaimSpec{
+						Pool: corev1.ObjectReference{Name: "abc"},
+					},
+				},
+				&ipamv1.IPAddress{
+					ObjectMeta: *objectMeta.DeepCopy(),
+					Spec: ipamv1.IPAddressSpec{
+						Claim:   corev1.ObjectReference{Name: "abc"},
+						Pool:    corev1.ObjectReference{Name: "abc"},
+						Address: ipamv1.IPAddressStr("192.168.0.11"),
+					},
+				},
+			}
+			for _, obj := range objects {
+				Expect(k8sClient.Create(ctx, obj)).To(Succeed())
+				// The objects stored by the API server have managedFields
+				Expect(obj.GetManagedFields()).NotTo(BeEmpty())
+			}
+
+			c, err := newCache(tc.namespaces, tc.selector)(cfg, cache.Options{Scheme: myscheme})
+			Expect(err).NotTo(HaveOccurred())
+			go func() {
+				defer GinkgoRecover()
+				Expect(c.Start(ctx)).To(Succeed())
+			}()
+
+			key := client.ObjectKey{Name: "abc", Namespace: tc.namespace}
+			cachedObjects := []client.Object{&ipamv1.IPClaim{}, &ipamv1.IPAddress{}}
+			for _, obj := range cachedObjects {
+				Eventually(func() error {
+					return c.Get(ctx, key, obj)
+				}, 10).Should(Succeed())
+				Expect(obj.GetManagedFields()).To(BeEmpty())
+				Expect(obj.GetAnnotations()).To(Equal(map[string]string{"foo": "bar"}))
+			}
+		},
+		Entry("Single namespace", testCaseNewCache{
+			namespace: "cachens1",
+			selector:  labels.Everything(),
+		}),
+		Entry("Several namespaces, watch filter", testCaseNewCache{
+			namespace:  "cachens2",
+			namespaces: []string{"cachens2", "otherns"},
+			selector: labels.SelectorFromSet(labels.Set{
+				"cluster.x-k8s.io/watch-filter": "canary",
+			}),
+		}),
+	)
+})